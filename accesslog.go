@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Layout of the timestamp in the Combined Log Format.
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// A log file which is rotated once it grows too large or too old. Rotated
+// files are renamed with the time they were rotated, to the millisecond so
+// two rotations can't clash, eg. access.log.20060102-150405.000, for
+// something else to compress or remove.
+type RotatingFile struct {
+	Path string
+	// Bytes and age the file may reach before rotation, 0 for no limit.
+	MaxSize int64
+	MaxAge  time.Duration
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func MakeRotatingFile(path string, maxSize int64, maxAge time.Duration) (*RotatingFile, error) {
+	f := &RotatingFile{Path: path, MaxSize: maxSize, MaxAge: maxAge}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.opened = time.Now()
+	return nil
+}
+
+func (f *RotatingFile) Write(data []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.due(len(data)) {
+		if err := f.rotate(); err != nil {
+			log.Errorf("Unable to rotate %s (%v)", f.Path, err)
+		}
+	}
+	n, err := f.file.Write(data)
+	f.size += int64(n)
+	return n, err
+}
+
+// Whether the file should be rotated before the write. Must be called with
+// the lock held.
+func (f *RotatingFile) due(length int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.MaxSize > 0 && f.size+int64(length) > f.MaxSize {
+		return true
+	}
+	return f.MaxAge > 0 && time.Since(f.opened) > f.MaxAge
+}
+
+// Moves the file aside and starts a new one. Must be called with the lock
+// held.
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	rotated := f.Path + "." + time.Now().Format("20060102-150405.000")
+	if err := os.Rename(f.Path, rotated); err != nil {
+		// Carry on writing to the file we have, rather than not at all.
+		f.open()
+		return err
+	}
+	return f.open()
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+// Writes a line for each request in the Combined Log Format, as Apache and
+// nginx do, so tools like GoAccess and AWStats can read it.
+type AccessLog struct {
+	out io.Writer
+}
+
+func MakeAccessLog(out io.Writer) *AccessLog {
+	return &AccessLog{out: out}
+}
+
+func (a *AccessLog) write(r *http.Request, identity string, status int, size int, at time.Time) {
+	fmt.Fprintf(a.out, "%s - %s [%s] \"%s %s %s\" %d %s \"%s\" \"%s\"\n",
+		clientIP(r),
+		clfField(identity),
+		at.Format(clfTimeFormat),
+		r.Method, r.RequestURI, r.Proto,
+		status,
+		clfSize(size),
+		clfQuoted(r.Referer()),
+		clfQuoted(r.UserAgent()),
+	)
+}
+
+func clfField(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func clfSize(size int) string {
+	if size == 0 {
+		return "-"
+	}
+	return strconv.Itoa(size)
+}
+
+// Escapes a header for a quoted field, so a client can't forge log lines.
+func clfQuoted(value string) string {
+	if value == "" {
+		return "-"
+	}
+	quoted := strconv.Quote(value)
+	return quoted[1 : len(quoted)-1]
+}
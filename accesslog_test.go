@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAccessLogFormat(t *testing.T) {
+	sw := new(StashingWriter)
+	r := httptest.NewRequest("GET", "/avatar/clone1018/64.png", nil)
+	r.RemoteAddr = "192.0.2.1:4321"
+	r.Header.Set("Referer", "https://example.com/")
+	r.Header.Set("User-Agent", "Mozilla/5.0 \"quoted\"")
+	at := time.Date(2017, time.March, 4, 5, 6, 7, 0, time.UTC)
+
+	MakeAccessLog(sw).write(r, "", 200, 1234, at)
+	expected := `192.0.2.1 - - [04/Mar/2017:05:06:07 +0000] "GET /avatar/clone1018/64.png HTTP/1.1" 200 1234 "https://example.com/" "Mozilla/5.0 \"quoted\""` + "\n"
+	if line := sw.Unstash(); line != expected {
+		t.Fatalf("Expected %q, got %q", expected, line)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	f, err := MakeRotatingFile(path, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	f.Write([]byte("12345678\n"))
+	f.Write([]byte("abcdefgh\n"))
+	matches, _ := filepath.Glob(path + ".*")
+	if len(matches) != 1 {
+		t.Fatalf("Expected one rotated file, got %v", matches)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "abcdefgh\n" {
+		t.Fatalf("Expected the new file to hold only the latest line, got %q", data)
+	}
+	if data, _ := os.ReadFile(matches[0]); string(data) != "12345678\n" {
+		t.Fatalf("Expected the rotated file to hold the first line, got %q", data)
+	}
+}
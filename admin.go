@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// The default and maximum number of cache entries listed per page.
+const (
+	DefaultEntriesLimit = 100
+	MaxEntriesLimit     = 1000
+)
+
+// Middleware which only lets admins through. With admin keys configured,
+// the request must carry one as a bearer token, in the X-Admin-Key header
+// or as the basic auth password. Otherwise any request an API key, or a
+// custom Authenticator, vouched for is let through, even if [auth] doesn't
+// require authentication for everything else. Tenants' keys, signed URLs,
+// allowed addresses and upload keys are only good for the public routes.
+// Every call is logged, so there's a record of who did what, though the
+// dashboard's only at debug as it polls.
+func requireIdentity(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identity := authIdentity(r)
+		if adminKeys != nil {
+			identity = adminKeys.identify(adminKey(r))
+			r = r.WithContext(context.WithValue(r.Context(), authContextKey{}, identity))
+		} else if !vouchesForAdmin(authSource(r)) || tenants.byKey(r) != nil {
+			identity = ""
+		}
+		dashboard := strings.HasPrefix(r.URL.Path, "/admin/dashboard/")
+		if identity == "" {
+			log.Warningf("Refused admin %s %s from %s", r.Method, r.RequestURI, clientIP(r))
+			stats.Errored(ErrAuthDenied)
+			if dashboard && adminKeys != nil {
+				// So browsers ask for the key.
+				w.Header().Set("WWW-Authenticate", `Basic realm="imgd admin"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprintf(w, "401 unauthorized")
+				return
+			}
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, "403 forbidden")
+			return
+		}
+		if dashboard {
+			// It polls, and only reads.
+			log.Debugf("Admin %s %s by %s from %s", r.Method, r.RequestURI, identity, clientIP(r))
+		} else {
+			log.Noticef("Admin %s %s by %s from %s", r.Method, r.RequestURI, identity, clientIP(r))
+		}
+		handler(w, r)
+	}
+}
+
+// Whether an identity from the Authenticator will do for the admin routes
+// when there are no admin keys. Those only saying where the request's from,
+// or that its URL was signed for it, won't.
+func vouchesForAdmin(source Authenticator) bool {
+	switch source.(type) {
+	case nil, *HMACAuthenticator, *IPListAuthenticator, adminKeyAuthenticator:
+		return false
+	}
+	return true
+}
+
+// Returns the admin key the request carries, if any. Browsers, eg. for the
+// dashboard, can give it as the password for basic auth.
+func adminKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	return r.Header.Get("X-Admin-Key")
+}
+
+// PurgePage evicts a single player, eg. after they've changed their skin.
+func (router *Router) PurgePage(w http.ResponseWriter, r *http.Request) {
+	username := strings.ToLower(mux.Vars(r)["username"])
+	uuid, _ := lookupUUID(username)
+	purgePlayer(username, uuid)
+	if purger != nil {
+		purger.purge(username, uuid)
+	}
+
+	log.Noticef("Purged %s from the cache (by %s)", username, authIdentity(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// FlushPage empties the cache entirely.
+func (router *Router) FlushPage(w http.ResponseWriter, r *http.Request) {
+	if purger != nil {
+		purger.flush()
+	}
+	if err := flushCaches(); err != nil {
+		log.Errorf("Failed to flush the cache (%v)", err)
+		stats.Errored(ErrCacheFlush)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "500 internal server error (request %s)", requestIDFrom(r.Context()))
+		return
+	}
+
+	log.Noticef("Flushed the cache (by %s)", authIdentity(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WarmupPage preloads the players listed in the request body, one per line,
+// or those in the configured warm-up file if the body is empty.
+func (router *Router) WarmupPage(w http.ResponseWriter, r *http.Request) {
+	players, err := readWarmupList(r.Body)
+	if err == nil && len(players) == 0 && config.Warmup.File != "" {
+		players, err = readWarmupFile(config.Warmup.File)
+	}
+	if err != nil {
+		log.Errorf("Failed to read warm-up list (%v)", err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "400 bad request")
+		return
+	}
+
+	if !warmer.start(players) {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w, "409 warm-up already running")
+		return
+	}
+
+	log.Noticef("Started warm-up of %d players (by %s)", len(players), authIdentity(r))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// EntriesPage lists what the cache holds, a page at a time.
+func (router *Router) EntriesPage(w http.ResponseWriter, r *http.Request) {
+	inspector, ok := cache.(inspectableCache)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprintf(w, "501 the %s cache can't be listed", config.Server.Cache)
+		return
+	}
+
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > MaxEntriesLimit {
+		limit = DefaultEntriesLimit
+	}
+
+	entries := inspector.entries()
+	total := len(entries)
+	if offset < 0 || offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+
+	page, _ := json.Marshal(struct {
+		Total   int
+		Offset  int
+		Limit   int
+		Entries []cacheEntry
+	}{total, offset, limit, entries[offset:end]})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(page)
+}
+
+// LogLevelPage shows the log level, or given one in the body, changes it
+// until we're restarted.
+func (router *Router) LogLevelPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PUT" {
+		body, _ := io.ReadAll(io.LimitReader(r.Body, 64))
+		level, err := parseLogLevel(strings.TrimSpace(string(body)))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "400 %s", err.Error())
+			return
+		}
+		log.SetLevel(level)
+		log.Noticef("Log level set to %s (by %s)", log.Level(), authIdentity(r))
+	}
+	fmt.Fprintf(w, "%s\n", log.Level())
+}
+
+// ConfigPage shows the config we're running with, where each option came
+// from, and with secrets redacted.
+func (router *Router) ConfigPage(w http.ResponseWriter, r *http.Request) {
+	page, _ := json.MarshalIndent(config.effective(), "", "  ")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(page)
+}
+
+// TopPlayersPage lists the most requested players, most first, up to the
+// limit query parameter if it's given.
+func (router *Router) TopPlayersPage(w http.ResponseWriter, r *http.Request) {
+	if topPlayers == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprintf(w, "501 top players aren't being tracked")
+		return
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = topPlayers.Size
+	}
+	page, _ := json.Marshal(struct {
+		// Seconds the counts are over.
+		Window  int64
+		Players []topPlayer
+	}{int64(topPlayers.Window.Seconds()), topPlayers.list(limit)})
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(page)
+}
+
+// WatchesPage lists the watched players on GET. POSTing {"Player": ...,
+// "URL": ...} watches the player for the URL, and DELETEing it stops.
+func (router *Router) WatchesPage(w http.ResponseWriter, r *http.Request) {
+	if watcher == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprintf(w, "501 the watcher isn't enabled")
+		return
+	}
+
+	if r.Method != "GET" {
+		request := struct {
+			Player string
+			URL    string
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || !profilesPlayerRegex.MatchString(request.Player) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "400 expected a player and URL")
+			return
+		}
+
+		if r.Method == "DELETE" {
+			removed, err := watcher.remove(request.Player, request.URL)
+			if err != nil {
+				log.Errorf("Unable to save watches (%v)", err)
+				stats.Errored(ErrWatch)
+			}
+			if !removed {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprintf(w, "404 %s isn't watched for %s", request.Player, request.URL)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		added, err := watcher.add(requestContext(r), request.Player, request.URL)
+		if err != nil && added.UUID == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "400 %v", err)
+			return
+		} else if err != nil {
+			log.Errorf("Unable to save watches (%v)", err)
+			stats.Errored(ErrWatch)
+		}
+		page, _ := json.Marshal(added)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(page)
+		return
+	}
+
+	page, _ := json.Marshal(watcher.list())
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(page)
+}
+
+// Bind the admin routes to the ServerMux.
+func (router *Router) BindAdmin() {
+	router.Mux.HandleFunc("/admin/cache/entries", requireIdentity(router.EntriesPage)).Methods("GET")
+	router.Mux.HandleFunc("/admin/cache/flush", requireIdentity(router.FlushPage)).Methods("POST")
+	router.Mux.HandleFunc("/admin/cache/warmup", requireIdentity(router.WarmupPage)).Methods("POST")
+	router.Mux.HandleFunc("/admin/loglevel", requireIdentity(router.LogLevelPage)).Methods("GET", "PUT")
+	router.Mux.HandleFunc("/admin/config", requireIdentity(router.ConfigPage)).Methods("GET")
+	router.Mux.HandleFunc("/admin/players/top", requireIdentity(router.TopPlayersPage)).Methods("GET")
+	router.Mux.HandleFunc("/admin/dashboard/data", requireIdentity(router.DashboardDataPage)).Methods("GET")
+	router.Mux.Handle("/admin/dashboard", http.RedirectHandler("/admin/dashboard/", http.StatusMovedPermanently))
+	router.Mux.PathPrefix("/admin/dashboard/").Handler(requireIdentity(dashboardHandler().ServeHTTP)).Methods("GET")
+	router.Mux.HandleFunc("/admin/watches", requireIdentity(router.WatchesPage)).Methods("GET", "POST", "DELETE")
+	router.Mux.HandleFunc("/admin/quotas", requireIdentity(router.QuotasPage)).Methods("GET")
+	router.Mux.HandleFunc("/admin/upstreams", requireIdentity(router.UpstreamsPage)).Methods("GET")
+	router.Mux.HandleFunc("/admin/tenants", requireIdentity(router.TenantsPage)).Methods("GET")
+	if config.Admin.Pprof {
+		router.Mux.PathPrefix("/admin/debug/pprof/").HandlerFunc(requireIdentity(pprofHandler()))
+	}
+	router.Mux.HandleFunc("/admin/cache/{username:"+playerRegex+"}", requireIdentity(router.PurgePage)).Methods("DELETE")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Serves paths under one prefix as if they were under another, eg. /a/ as
+// /avatar/, so links made for another avatar service keep working.
+type RouteAlias struct {
+	From string
+	To   string
+}
+
+// Parses aliases written as "/a/ -> /avatar/". They're returned longest
+// first, so the most specific alias for a path wins.
+func parseRouteAliases(list []string) ([]RouteAlias, error) {
+	aliases := []RouteAlias{}
+	for _, entry := range list {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "->", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("alias %q isn't of the form \"/from/ -> /to/\"", entry)
+		}
+		alias := RouteAlias{From: strings.TrimSpace(parts[0]), To: strings.TrimSpace(parts[1])}
+		if !strings.HasPrefix(alias.From, "/") || !strings.HasPrefix(alias.To, "/") {
+			return nil, fmt.Errorf("alias %q must be between paths starting with /", entry)
+		}
+		aliases = append(aliases, alias)
+	}
+	sort.SliceStable(aliases, func(i, j int) bool {
+		return len(aliases[i].From) > len(aliases[j].From)
+	})
+	return aliases, nil
+}
+
+// Rewrites aliased paths before routing. The original path is still what's
+// logged.
+func aliasHandler(aliases []RouteAlias, router http.Handler) http.Handler {
+	if len(aliases) == 0 {
+		return router
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, alias := range aliases {
+			if strings.HasPrefix(r.URL.Path, alias.From) {
+				r2 := r.Clone(r.Context())
+				r2.URL.Path = alias.To + strings.TrimPrefix(r.URL.Path, alias.From)
+				r2.URL.RawPath = ""
+				r = r2
+				break
+			}
+		}
+		router.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouteAliases(t *testing.T) {
+	aliases, err := parseRouteAliases([]string{"/a/ -> /avatar/", "/av/ -> /avatar/", "/h/->/helm/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var served string
+	handler := aliasHandler(aliases, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = r.URL.Path
+	}))
+
+	for path, expected := range map[string]string{
+		"/a/clone1018/64":  "/avatar/clone1018/64",
+		"/av/clone1018":    "/avatar/clone1018",
+		"/h/clone1018.png": "/helm/clone1018.png",
+		"/skin/clone1018":  "/skin/clone1018",
+	} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		if served != expected {
+			t.Fatalf("Expected %s to be served as %s, got %s", path, expected, served)
+		}
+	}
+
+	if _, err := parseRouteAliases([]string{"/a/ /avatar/"}); err == nil {
+		t.Fatal("Expected an alias without -> to be refused")
+	}
+}
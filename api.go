@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Most usernames which may be looked up in one request to us.
+	MaxProfilesBatch = 100
+	// Most usernames Mojang will look up in one request to them.
+	bulkLookupChunk = 10
+)
+
+var profilesPlayerRegex = regexp.MustCompile("^" + playerRegex + "$")
+
+// A single player in a batch lookup.
+type profileLookup struct {
+	Username string
+	UUID     string `json:",omitempty"`
+	// Where we serve their skin.
+	Skin string `json:",omitempty"`
+	// Set if we couldn't look them up: user_not_found, or
+	// upstream_timeout if Mojang couldn't tell us.
+	Error ErrorCode `json:",omitempty"`
+}
+
+// Looks up UUIDs for the usernames with Mojang's bulk endpoint, returning
+// those it found by lowercased username.
+func fetchUUIDs(ctx context.Context, usernames []string) (map[string]string, error) {
+	stats.APIRequested("BulkUUID")
+	bulkTimer := prometheus.NewTimer(getDuration.WithLabelValues("BulkUUID"))
+	defer bulkTimer.ObserveDuration()
+
+	body, _ := json.Marshal(usernames)
+	req, err := http.NewRequestWithContext(ctx, "POST", config.Minecraft.BulkURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", config.Minecraft.UserAgent)
+	forwardRequestID(ctx, req)
+
+	resp, err := mcClient.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to GetBulkUUID: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusTooManyRequests:
+		return nil, fmt.Errorf("unable to GetBulkUUID: rate limited")
+	default:
+		return nil, fmt.Errorf("unable to GetBulkUUID: %s", resp.Status)
+	}
+
+	profiles := []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&profiles); err != nil {
+		return nil, fmt.Errorf("unable to GetBulkUUID: %v", err)
+	}
+
+	uuids := map[string]string{}
+	for _, profile := range profiles {
+		uuids[strings.ToLower(profile.Name)] = profile.ID
+	}
+	return uuids, nil
+}
+
+// Looks up the usernames, answering what we can from the UUID cache and
+// asking Mojang for the rest in as few requests as they'll allow.
+func lookupProfiles(ctx context.Context, usernames []string) []profileLookup {
+	results := make([]profileLookup, len(usernames))
+	missing := []string{}
+	for i, username := range usernames {
+		results[i].Username = username
+		if uuid, known := lookupUUID(username); known {
+			results[i].UUID = uuid
+		} else if missingFilter.has(username) {
+			results[i].Error = ErrUserNotFound
+		} else {
+			missing = append(missing, username)
+		}
+	}
+
+	found := map[string]string{}
+	unavailable := map[string]bool{}
+	for start := 0; start < len(missing); start += bulkLookupChunk {
+		end := start + bulkLookupChunk
+		if end > len(missing) {
+			end = len(missing)
+		}
+		chunk := missing[start:end]
+
+		result, err := upstream.do(func() (interface{}, error) {
+			return fetchUUIDs(ctx, chunk)
+		})
+		if err != nil {
+			log.Noticef("Failed bulk UUID lookup of %d players (%s)", len(chunk), err.Error())
+			stats.Errored(ErrLookupBulkUUID)
+			for _, username := range chunk {
+				unavailable[strings.ToLower(username)] = true
+			}
+			continue
+		}
+
+		uuids := result.(map[string]string)
+		for _, username := range chunk {
+			username = strings.ToLower(username)
+			if uuid, exists := uuids[username]; exists {
+				found[username] = uuid
+				uuidCache.add(username, uuid, config.uuidTtl())
+			} else {
+				missingFilter.add(username)
+			}
+		}
+	}
+
+	for i := range results {
+		if results[i].UUID != "" || results[i].Error != "" {
+			continue
+		}
+		username := strings.ToLower(results[i].Username)
+		if uuid, exists := found[username]; exists {
+			results[i].UUID = uuid
+		} else if unavailable[username] {
+			results[i].Error = ErrUpstreamTimeout
+		} else {
+			results[i].Error = ErrUserNotFound
+		}
+	}
+
+	for i := range results {
+		if results[i].UUID != "" {
+			results[i].Skin = strings.TrimRight(config.Server.URL, "/") + "/skin/" + results[i].Username
+		}
+	}
+	return results
+}
+
+// ProfilesPage looks up the UUIDs of a JSON list of usernames, so pages
+// showing many players don't have to ask for each in turn. UUIDs are
+// accepted too, and passed straight back.
+func (router *Router) ProfilesPage(w http.ResponseWriter, r *http.Request) {
+	usernames := []string{}
+	if err := json.NewDecoder(r.Body).Decode(&usernames); err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrBadRequest, "expected a JSON list of usernames")
+		return
+	}
+	if len(usernames) > MaxProfilesBatch {
+		writeError(w, r, http.StatusBadRequest, ErrBadRequest, "at most %d usernames may be looked up at once", MaxProfilesBatch)
+		return
+	}
+	for _, username := range usernames {
+		if !profilesPlayerRegex.MatchString(username) {
+			writeError(w, r, http.StatusBadRequest, ErrBadRequest, "invalid username %q", username)
+			return
+		}
+	}
+
+	stats.Requested("Profiles")
+	results, _ := json.Marshal(lookupProfiles(requestContext(r), usernames))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(results)
+}
+
+// Bind the API routes to the ServerMux.
+func (router *Router) BindAPI() {
+	router.Mux.HandleFunc("/api/profiles", router.ProfilesPage).Methods("POST")
+	router.Mux.HandleFunc("/api/render/batch", requireSignatureOrIdentity(router.RenderBatchPage)).Methods("POST")
+	router.Mux.HandleFunc("/api/render/async", requireSignatureOrIdentity(router.RenderAsyncPage)).Methods("POST")
+	router.Mux.HandleFunc("/api/render/jobs/{id:[0-9a-f]{32}}", router.RenderJobPage).Methods("GET")
+	router.Mux.HandleFunc("/api/render/jobs/{id:[0-9a-f]{32}}/result", router.RenderJobResultPage).Methods("GET", "HEAD")
+	router.Mux.HandleFunc("/api/srcset/{username:"+playerRegex+"}", requireSignatureOrIdentity(router.SrcsetPage)).Methods("GET")
+	router.Mux.HandleFunc("/api/skin/{uuid}", requireUploader(router.SkinUploadPage)).Methods("PUT")
+	router.Mux.HandleFunc("/api/history/{username:"+playerRegex+"}", router.HistoryPage).Methods("GET")
+	router.Mux.HandleFunc("/api/text/{text:[^/]+}.png", requireSignatureOrIdentity(router.TextPage)).Methods("GET", "HEAD")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/minecraft"
+)
+
+func TestProfilesPage(t *testing.T) {
+	stats = MakeStatsCollector()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	missingFilter = MakeMissingFilter(1000, time.Minute)
+	upstream = MakeUpstream(0, 0)
+	config.Server.URL = "https://minotar.net/"
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.Contains(string(body), "Clone1018") {
+			w.Write([]byte(`[{"id":"d9135e082f2244c89cb10d21ed3ac8fd","name":"clone1018"}]`))
+			return
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+	config.Minecraft.BulkURL = server.URL
+	mcClient = &minecraft.Minecraft{Client: server.Client()}
+
+	uuidCache.add("lukegb", "2f3665cc5e29439bbd14cb6d3a6313a7", time.Minute)
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.BindAPI()
+
+	usernames := []string{"lukegb", "Clone1018"}
+	for i := 0; i < 10; i++ {
+		usernames = append(usernames, "nobody"+string(rune('a'+i)))
+	}
+	body, _ := json.Marshal(usernames)
+	w := httptest.NewRecorder()
+	router.Mux.ServeHTTP(w, httptest.NewRequest("POST", "/api/profiles", strings.NewReader(string(body))))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+
+	results := []profileLookup{}
+	json.Unmarshal(w.Body.Bytes(), &results)
+	if len(results) != len(usernames) {
+		t.Fatalf("Expected a result per username, got %d", len(results))
+	}
+	if results[0].UUID != "2f3665cc5e29439bbd14cb6d3a6313a7" || results[1].UUID != "d9135e082f2244c89cb10d21ed3ac8fd" {
+		t.Fatalf("Expected both players' UUIDs, got %+v", results[:2])
+	}
+	if results[1].Skin != "https://minotar.net/skin/Clone1018" {
+		t.Fatalf("Expected a skin URL, got %s", results[1].Skin)
+	}
+	if results[2].Error != ErrUserNotFound {
+		t.Fatalf("Expected the unknown player not to be found, got %+v", results[2])
+	}
+	// The cached player doesn't need looking up, leaving 11 for Mojang.
+	if requests != 2 {
+		t.Fatalf("Expected the lookups to be made in 2 batches, got %d", requests)
+	}
+
+	w = httptest.NewRecorder()
+	router.Mux.ServeHTTP(w, httptest.NewRequest("POST", "/api/profiles", strings.NewReader(`["not a username"]`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected an invalid username to be refused, got %d", w.Code)
+	}
+}
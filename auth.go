@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuthResult is the verdict an Authenticator reaches about a request.
+type AuthResult int
+
+const (
+	// The Authenticator has no opinion, let the next one decide.
+	AuthAbstain AuthResult = iota
+	// The request is allowed through.
+	AuthAllow
+	// The request is refused.
+	AuthDeny
+)
+
+// Authenticator decides whether a request may proceed. Alongside the verdict
+// it may return an identity (eg. the name of an API key) which is attached
+// to the request for handlers further down the chain.
+type Authenticator interface {
+	Authenticate(r *http.Request) (AuthResult, string)
+}
+
+type authContextKey struct{}
+
+// The Authenticator which vouched for the request.
+type authSourceContextKey struct{}
+
+// Factories for the Authenticators which can be named in the config. Custom
+// ones (SSO, JWT...) can be compiled in by calling RegisterAuthenticator
+// from an init() in their own file.
+var authenticatorFactories = map[string]func() (Authenticator, error){}
+
+// Makes an Authenticator available to the [auth] config section.
+func RegisterAuthenticator(name string, factory func() (Authenticator, error)) {
+	authenticatorFactories[strings.ToLower(name)] = factory
+}
+
+func init() {
+	RegisterAuthenticator("apikey", func() (Authenticator, error) {
+		// Tenants' keys are theirs to use too, identified as the tenant.
+		keys := append(append([]string{}, config.Auth.APIKey...), tenantAPIKeys(config.Tenant)...)
+		return MakeAPIKeyAuthenticator(keys), nil
+	})
+	RegisterAuthenticator("hmac", func() (Authenticator, error) {
+		if config.Auth.HMACSecret == "" {
+			return nil, fmt.Errorf("hmac authenticator requires hmacsecret")
+		}
+		return &HMACAuthenticator{Secret: []byte(config.Auth.HMACSecret)}, nil
+	})
+	RegisterAuthenticator("iplist", func() (Authenticator, error) {
+		return MakeIPListAuthenticator(config.Auth.AllowIP, config.Auth.DenyIP)
+	})
+}
+
+// Builds the Authenticators named in the config, in order.
+func MakeAuthChain(names []string) ([]Authenticator, error) {
+	chain := []Authenticator{}
+	for _, name := range names {
+		factory, exists := authenticatorFactories[strings.ToLower(name)]
+		if !exists {
+			return nil, fmt.Errorf("unknown authenticator \"%s\"", name)
+		}
+		auth, err := factory()
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, auth)
+	}
+	return chain, nil
+}
+
+// Runs the request past each Authenticator in turn. The first one with an
+// opinion wins, and is returned with its verdict. If nobody has an opinion,
+// the request is allowed unless required is set.
+func authenticate(chain []Authenticator, required bool, r *http.Request) (AuthResult, string, Authenticator) {
+	for _, auth := range chain {
+		result, identity := auth.Authenticate(r)
+		if result != AuthAbstain {
+			return result, identity, auth
+		}
+	}
+	if required {
+		return AuthDeny, "", nil
+	}
+	return AuthAllow, "", nil
+}
+
+// Middleware which refuses requests the chain doesn't allow, and annotates
+// the rest with the identity the chain returned. The admin and upload keys
+// are asked after the chain, so they'll do where [auth] requires an
+// identity, but can't override its deny entries.
+func authHandler(chain []Authenticator, required bool, router http.Handler) http.Handler {
+	chain = append(chain[:len(chain):len(chain)], adminKeyAuthenticator{})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result, identity, source := authenticate(chain, required, r)
+		if result == AuthDeny {
+			writeError(w, r, http.StatusForbidden, ErrForbidden, "forbidden")
+			stats.Errored(ErrAuthDenied)
+			return
+		}
+		if identity != "" {
+			ctx := context.WithValue(r.Context(), authContextKey{}, identity)
+			r = r.WithContext(context.WithValue(ctx, authSourceContextKey{}, source))
+		}
+		router.ServeHTTP(w, r)
+	})
+}
+
+// Returns the identity an Authenticator annotated the request with, if any.
+func authIdentity(r *http.Request) string {
+	identity, _ := r.Context().Value(authContextKey{}).(string)
+	return identity
+}
+
+// Returns the Authenticator which identified the request, if any.
+func authSource(r *http.Request) Authenticator {
+	source, _ := r.Context().Value(authSourceContextKey{}).(Authenticator)
+	return source
+}
+
+// Identifies requests carrying an admin or upload key, as the name of the
+// key. Their own routes check which it was.
+type adminKeyAuthenticator struct{}
+
+func (adminKeyAuthenticator) Authenticate(r *http.Request) (AuthResult, string) {
+	key := adminKey(r)
+	if key == "" {
+		return AuthAbstain, ""
+	}
+	for _, keys := range []*APIKeyAuthenticator{adminKeys, uploadKeys} {
+		if keys == nil {
+			continue
+		}
+		if identity := keys.identify(key); identity != "" {
+			return AuthAllow, identity
+		}
+	}
+	return AuthAbstain, ""
+}
+
+// APIKeyAuthenticator allows requests carrying a known key in the X-API-Key
+// header or the "apikey" query parameter.
+type APIKeyAuthenticator struct {
+	// Map of key to the name we log it as.
+	Keys map[string]string
+}
+
+// Keys are given as either "name:key" or just "key".
+func MakeAPIKeyAuthenticator(keys []string) *APIKeyAuthenticator {
+	auth := &APIKeyAuthenticator{Keys: map[string]string{}}
+	for index, key := range keys {
+		if key == "" {
+			continue
+		}
+		if sep := strings.Index(key, ":"); sep != -1 {
+			auth.Keys[key[sep+1:]] = key[:sep]
+		} else {
+			auth.Keys[key] = "key" + strconv.Itoa(index)
+		}
+	}
+	return auth
+}
+
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (AuthResult, string) {
+	given := r.Header.Get("X-API-Key")
+	if given == "" {
+		given = r.URL.Query().Get("apikey")
+	}
+	if given == "" {
+		return AuthAbstain, ""
+	}
+
+	identity := a.identify(given)
+	if identity == "" {
+		return AuthDeny, ""
+	}
+	return AuthAllow, identity
+}
+
+// Returns the name of the key given, or "" if it isn't one of ours.
+func (a *APIKeyAuthenticator) identify(given string) string {
+	if given == "" {
+		return ""
+	}
+	// Compare against every key so timing doesn't leak which one was close.
+	identity := ""
+	for key, name := range a.Keys {
+		if subtle.ConstantTimeCompare([]byte(given), []byte(key)) == 1 {
+			identity = name
+		}
+	}
+	return identity
+}
+
+// HMACAuthenticator allows requests signed with a shared secret. The "sig"
+// query parameter is the hex HMAC-SHA256 of the path and the "expires" unix
+// timestamp, which must not have passed.
+type HMACAuthenticator struct {
+	Secret []byte
+}
+
+// Returns the signature for the given path and expiry.
+func (a *HMACAuthenticator) Sign(path string, expires int64) string {
+	mac := hmac.New(sha256.New, a.Secret)
+	mac.Write([]byte(path + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (a *HMACAuthenticator) Authenticate(r *http.Request) (AuthResult, string) {
+	result := a.verify(r.URL.Path, r.URL.Query())
+	if result == AuthAllow {
+		return result, "hmac"
+	}
+	return result, ""
+}
+
+// Checks the signature in the query is for the path and hasn't expired.
+func (a *HMACAuthenticator) verify(path string, query url.Values) AuthResult {
+	sig := query.Get("sig")
+	if sig == "" {
+		return AuthAbstain
+	}
+
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || expires < time.Now().Unix() {
+		return AuthDeny
+	}
+
+	expected := a.Sign(path, expires)
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return AuthDeny
+	}
+	return AuthAllow
+}
+
+// Returns the HMAC authenticator URLs are signed for: the one in the chain,
+// or with signed on, one for the shared secret.
+func hmacSigner() *HMACAuthenticator {
+	for _, authenticator := range authChain {
+		if signer, ok := authenticator.(*HMACAuthenticator); ok {
+			return signer
+		}
+	}
+	if config.Auth.Signed && config.Auth.HMACSecret != "" {
+		return &HMACAuthenticator{Secret: []byte(config.Auth.HMACSecret)}
+	}
+	return nil
+}
+
+// Wraps an image route so, with signed on, it's refused without a valid
+// signature, whatever the authenticators made of the request. The
+// signature is for the path as requested, before any alias rewrote it.
+func requireSignature(fn http.HandlerFunc) http.HandlerFunc {
+	if !config.Auth.Signed {
+		return fn
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if requested, err := url.ParseRequestURI(r.RequestURI); err == nil {
+			path = requested.Path
+		}
+		signer := hmacSigner()
+		if signer == nil || signer.verify(path, r.URL.Query()) != AuthAllow {
+			writeError(w, r, http.StatusForbidden, ErrForbidden, "forbidden")
+			stats.Errored(ErrSignatureInvalid)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// Wraps an API route which renders, so with signed on, it's refused unless
+// an authenticator identified the request, or it's signed as the image
+// routes are. Otherwise anyone could render through the API instead.
+func requireSignatureOrIdentity(fn http.HandlerFunc) http.HandlerFunc {
+	if !config.Auth.Signed {
+		return fn
+	}
+	signed := requireSignature(fn)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if authIdentity(r) != "" {
+			fn(w, r)
+			return
+		}
+		signed(w, r)
+	}
+}
+
+// IPListAuthenticator allows or denies requests by the client's address.
+// Deny entries take precedence over allow entries.
+type IPListAuthenticator struct {
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+}
+
+func MakeIPListAuthenticator(allow, deny []string) (*IPListAuthenticator, error) {
+	auth := &IPListAuthenticator{}
+	var err error
+	if auth.Allow, err = parseCIDRs(allow); err != nil {
+		return nil, err
+	}
+	if auth.Deny, err = parseCIDRs(deny); err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+func (a *IPListAuthenticator) Authenticate(r *http.Request) (AuthResult, string) {
+	ip := net.ParseIP(clientIP(r))
+	if ip == nil {
+		return AuthAbstain, ""
+	}
+	if containsIP(a.Deny, ip) {
+		return AuthDeny, ""
+	}
+	if containsIP(a.Allow, ip) {
+		return AuthAllow, ip.String()
+	}
+	return AuthAbstain, ""
+}
+
+// Parses a list of CIDRs. Bare addresses are treated as a single host.
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, entry := range list {
+		if !strings.Contains(entry, "/") {
+			if strings.Contains(entry, ":") {
+				entry += "/128"
+			} else {
+				entry += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Proxies, eg. our load balancer, we believe about who they're forwarding
+// requests for.
+var trustedProxies []*net.IPNet
+
+func isTrustedProxy(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && containsIP(trustedProxies, ip)
+}
+
+// Returns the address of the client which made the request. When that's a
+// trusted proxy, it's who the proxy says it's forwarding for instead: the
+// last address in X-Forwarded-For that isn't another of our proxies, or
+// failing that X-Real-IP. Anything before that in X-Forwarded-For could
+// have been made up by the client.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host) {
+		return host
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				break
+			}
+			host = hop.String()
+			if !containsIP(trustedProxies, hop) {
+				break
+			}
+		}
+		return host
+	}
+	if realIP := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); realIP != nil {
+		return realIP.String()
+	}
+	return host
+}
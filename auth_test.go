@@ -0,0 +1,173 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	auth := MakeAPIKeyAuthenticator([]string{"ops:s3cret", "bare"})
+
+	r := httptest.NewRequest("GET", "/avatar/clone1018", nil)
+	if result, _ := auth.Authenticate(r); result != AuthAbstain {
+		t.Fatalf("Expected abstain without a key, got %d", result)
+	}
+
+	r.Header.Set("X-API-Key", "s3cret")
+	if result, identity := auth.Authenticate(r); result != AuthAllow || identity != "ops" {
+		t.Fatalf("Expected allow as ops, got %d as %s", result, identity)
+	}
+
+	r.Header.Set("X-API-Key", "wrong")
+	if result, _ := auth.Authenticate(r); result != AuthDeny {
+		t.Fatalf("Expected deny with a bad key, got %d", result)
+	}
+}
+
+func TestHMACAuthenticator(t *testing.T) {
+	auth := &HMACAuthenticator{Secret: []byte("secret")}
+	expires := time.Now().Add(time.Minute).Unix()
+	sig := auth.Sign("/avatar/clone1018", expires)
+
+	r := httptest.NewRequest("GET", "/avatar/clone1018?expires="+strconv.FormatInt(expires, 10)+"&sig="+sig, nil)
+	if result, _ := auth.Authenticate(r); result != AuthAllow {
+		t.Fatalf("Expected allow with a valid signature, got %d", result)
+	}
+
+	r = httptest.NewRequest("GET", "/avatar/lukegb?expires="+strconv.FormatInt(expires, 10)+"&sig="+sig, nil)
+	if result, _ := auth.Authenticate(r); result != AuthDeny {
+		t.Fatalf("Expected deny for a different path, got %d", result)
+	}
+}
+
+func TestRequireSignature(t *testing.T) {
+	stats = MakeStatsCollector()
+	config.Auth.Signed = true
+	config.Auth.HMACSecret = "secret"
+	defer func() {
+		config.Auth.Signed = false
+		config.Auth.HMACSecret = ""
+	}()
+
+	handler := requireSignature(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	signer := hmacSigner()
+	unix := time.Now().Add(time.Minute).Unix()
+	expires := strconv.FormatInt(unix, 10)
+	sig := signer.Sign("/avatar/clone1018", unix)
+
+	for url, expected := range map[string]int{
+		"/avatar/clone1018": http.StatusForbidden,
+		"/avatar/clone1018?expires=" + expires + "&sig=" + sig: http.StatusOK,
+		"/avatar/lukegb?expires=" + expires + "&sig=" + sig:    http.StatusForbidden,
+		"/avatar/clone1018?expires=1&sig=" + sig:               http.StatusForbidden,
+	} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", url, nil))
+		if w.Code != expected {
+			t.Errorf("Expected %d for %s, got %d", expected, url, w.Code)
+		}
+	}
+}
+
+func TestRequireSignatureOrIdentity(t *testing.T) {
+	stats = MakeStatsCollector()
+	config.Auth.Signed = true
+	config.Auth.HMACSecret = "secret"
+	defer func() {
+		config.Auth.Signed = false
+		config.Auth.HMACSecret = ""
+	}()
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.BindAPI()
+	handler := authHandler([]Authenticator{MakeAPIKeyAuthenticator([]string{"ops:s3cret"})}, false, router.Mux)
+	serve := func(method string, path string, key string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader("[]"))
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for _, route := range [][2]string{
+		{"POST", "/api/render/batch"},
+		{"POST", "/api/render/async"},
+		{"GET", "/api/text/hello.png"},
+		{"GET", "/api/srcset/clone1018"},
+	} {
+		if code := serve(route[0], route[1], ""); code != http.StatusForbidden {
+			t.Errorf("Expected %s %s to be refused unsigned, got %d", route[0], route[1], code)
+		}
+		if code := serve(route[0], route[1], "s3cret"); code == http.StatusForbidden {
+			t.Errorf("Expected %s %s to be served with an API key", route[0], route[1])
+		}
+	}
+
+	signer := hmacSigner()
+	unix := time.Now().Add(time.Minute).Unix()
+	signed := "/api/text/hello.png?expires=" + strconv.FormatInt(unix, 10) + "&sig=" + signer.Sign("/api/text/hello.png", unix)
+	if code := serve("GET", signed, ""); code != http.StatusOK {
+		t.Fatalf("Expected signed text to be drawn, got %d", code)
+	}
+}
+
+func TestIPListAuthenticator(t *testing.T) {
+	auth, err := MakeIPListAuthenticator([]string{"10.0.0.0/8"}, []string{"10.1.2.3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	if result, _ := auth.Authenticate(r); result != AuthAllow {
+		t.Fatalf("Expected allow inside the allow list, got %d", result)
+	}
+	r.RemoteAddr = "10.1.2.3:1234"
+	if result, _ := auth.Authenticate(r); result != AuthDeny {
+		t.Fatalf("Expected deny inside the deny list, got %d", result)
+	}
+	r.RemoteAddr = "192.168.0.1:1234"
+	if result, _ := auth.Authenticate(r); result != AuthAbstain {
+		t.Fatalf("Expected abstain outside both lists, got %d", result)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trustedProxies, _ = parseCIDRs([]string{"10.0.0.0/8"})
+	defer func() { trustedProxies = nil }()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.7")
+	if ip := clientIP(r); ip != "192.0.2.1" {
+		t.Fatalf("Expected an untrusted peer's X-Forwarded-For to be ignored, got %s", ip)
+	}
+
+	// The client made up the first address, our proxies added the rest.
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9, 198.51.100.7, 10.0.0.2")
+	if ip := clientIP(r); ip != "198.51.100.7" {
+		t.Fatalf("Expected the address our proxies saw, got %s", ip)
+	}
+
+	r.Header.Del("X-Forwarded-For")
+	r.Header.Set("X-Real-IP", "198.51.100.8")
+	if ip := clientIP(r); ip != "198.51.100.8" {
+		t.Fatalf("Expected X-Real-IP, got %s", ip)
+	}
+
+	r.Header.Del("X-Real-IP")
+	if ip := clientIP(r); ip != "10.0.0.1" {
+		t.Fatalf("Expected the proxy when it doesn't say, got %s", ip)
+	}
+}
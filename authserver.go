@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Points us at an authlib-injector style auth server, eg. Ely.by or
+// Blessing Skin, in place of Mojang. They all lay out their API the same
+// way under the root URL, so the URLs we'd otherwise need configuring are
+// derived from it.
+func (c *Configuration) applyAuthServer() {
+	root := strings.TrimSuffix(c.Minecraft.AuthServer, "/")
+	c.Minecraft.SessionServerURL = root + "/sessionserver/session/minecraft/profile/"
+	c.Minecraft.ProfileURL = root + "/api/users/profiles/minecraft/"
+	c.Minecraft.BulkURL = root + "/api/profiles/minecraft"
+}
+
+// Fetches the key the auth server signs textures with, which it publishes
+// in the metadata at its root URL.
+func fetchAuthServerKey(root string) (*rsa.PublicKey, error) {
+	resp, err := upstreamGet(context.Background(), "GetAuthServerMetadata", root)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	metadata := struct {
+		SignaturePublickey string `json:"signaturePublickey"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("unable to GetAuthServerMetadata: %v", err)
+	}
+	if metadata.SignaturePublickey == "" {
+		return nil, fmt.Errorf("auth server doesn't publish a signature key")
+	}
+	return parseSignatureKey([]byte(metadata.SignaturePublickey))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/minotar/minecraft"
+)
+
+func TestAuthServer(t *testing.T) {
+	stats = MakeStatsCollector()
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	publicKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	// Unpadded, with a capitalised model and an extension on the texture,
+	// as some auth servers give them.
+	textures := base64.RawStdEncoding.EncodeToString([]byte(`{"textures":{"SKIN":{"url":"http://skins.example/69c6740e2e1b8d8f4ee2a3ee4e9f1cc6.png","metadata":{"model":"SLIM"}}}}`))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/yggdrasil":
+			json.NewEncoder(w).Encode(map[string]string{"signaturePublickey": string(publicKey)})
+		case "/api/yggdrasil/sessionserver/session/minecraft/profile/069a79f444e94726a5befca90e38aaf5":
+			w.Write([]byte(`{"id":"069a79f4-44e9-4726-a5be-fca90e38aaf5","name":"Notch","properties":[{"name":"textures","value":"` + textures + `"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	mcClient = &minecraft.Minecraft{Client: server.Client()}
+
+	saved := config.Minecraft
+	defer func() { config.Minecraft = saved }()
+	config.Minecraft.AuthServer = server.URL + "/api/yggdrasil/"
+	config.applyAuthServer()
+	if config.Minecraft.ProfileURL != server.URL+"/api/yggdrasil/api/users/profiles/minecraft/" {
+		t.Fatalf("Expected the profile URL to be derived, got %s", config.Minecraft.ProfileURL)
+	}
+
+	profile, err := fetchProfile(context.Background(), "069a79f444e94726a5befca90e38aaf5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.UUID != "069a79f444e94726a5befca90e38aaf5" || !profile.Slim || profile.SkinURL == "" {
+		t.Fatalf("Expected the auth server's profile to be understood, got %+v", profile)
+	}
+
+	fetched, err := fetchAuthServerKey(server.URL + "/api/yggdrasil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched.N.Cmp(key.PublicKey.N) != 0 {
+		t.Fatal("Expected the auth server's signature key")
+	}
+}
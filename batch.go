@@ -0,0 +1,208 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	// Most renders which may be asked for in one batch.
+	MaxRenderBatch = 100
+	// Most players in a batch whose skins are fetched at once.
+	batchFetchers = 8
+)
+
+// A single render in a batch, eg. {"user": "clone1018", "type": "helm",
+// "size": 180}. The type is any of the render routes, eg. "armor/bust", and
+// the size is optional.
+type batchRender struct {
+	User string `json:"user"`
+	Type string `json:"type"`
+	Size uint   `json:"size"`
+
+	resource string
+}
+
+// Returns the render resource with the name, ignoring case, if it's served.
+func batchResource(name string) (string, bool) {
+	for _, resource := range renderResources {
+		if !strings.EqualFold(resource, name) {
+			continue
+		}
+		if group := resourceGroup(resource); group != "" && !routeEnabled(group) {
+			return "", false
+		}
+		return resource, true
+	}
+	return "", false
+}
+
+// Returns the size asked for as it'd be in a URL, "" for the default.
+func batchSize(size uint) string {
+	if size == 0 {
+		return ""
+	}
+	return fmt.Sprint(size)
+}
+
+// Name of the render's file in the ZIP, eg. "clone1018-armor-bust-180.png".
+func (b batchRender) filename(width uint) string {
+	return fmt.Sprintf("%s-%s-%d.png", b.User, strings.Replace(strings.ToLower(b.resource), "/", "-", -1), width)
+}
+
+// Reads a JSON list of at most max renders from the request, checking each
+// is of a player, render and size we serve.
+func (router *Router) parseBatch(r *http.Request, max int) ([]batchRender, error) {
+	renders := []batchRender{}
+	if err := json.NewDecoder(r.Body).Decode(&renders); err != nil {
+		return nil, fmt.Errorf("expected a JSON list of renders")
+	}
+	if len(renders) > max {
+		return nil, fmt.Errorf("at most %d renders may be asked for at once", max)
+	}
+	for i := range renders {
+		if !profilesPlayerRegex.MatchString(renders[i].User) {
+			return nil, fmt.Errorf("invalid user %q", renders[i].User)
+		}
+		resource, ok := batchResource(renders[i].Type)
+		if !ok {
+			return nil, fmt.Errorf("unknown render type %q", renders[i].Type)
+		}
+		renders[i].resource = resource
+		if _, allowed := router.routeWidth(resource, batchSize(renders[i].Size)); !allowed {
+			return nil, fmt.Errorf("size %d not allowed for %s", renders[i].Size, renders[i].Type)
+		}
+	}
+	return renders, nil
+}
+
+// RenderBatchPage renders a JSON list of players as a ZIP of PNGs, so sites
+// showing hundreds of players needn't make hundreds of requests. Each
+// player's skin is fetched once, however many renders of it are asked for.
+func (router *Router) RenderBatchPage(w http.ResponseWriter, r *http.Request) {
+	renders, err := router.parseBatch(r, MaxRenderBatch)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrBadRequest, "%v", err)
+		return
+	}
+	stats.Requested("RenderBatch")
+	if !chargeRateLimit(w, r, len(renders)) {
+		return
+	}
+
+	data, err := router.renderBatch(r.Context(), renders, router.fetchBatchSkins(r, renders))
+	if err == errRenderQueueFull || err == context.DeadlineExceeded {
+		writeRenderQueueFull(w, r)
+		return
+	} else if err == context.Canceled {
+		return
+	} else if err != nil {
+		writeInternalError(w, r, ErrRenderFailed)
+		return
+	}
+	writeBatch(w, r, data)
+}
+
+// Renders the batch as a ZIP of PNGs, from the players' skins by lowercased
+// username.
+func (router *Router) renderBatch(ctx context.Context, renders []batchRender, skins map[string]*mcSkin) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	archive := zip.NewWriter(buf)
+	for _, render := range renders {
+		width, _ := router.routeWidth(render.resource, batchSize(render.Size))
+
+		// Each render draws on its own copy, as it leaves its result there.
+		skin := *skins[strings.ToLower(render.User)]
+		skin.Mode = router.getResizeMode(".png")
+		key := renderKey(render.resource, width, ".png", &skin)
+		data, ok := renderCache.get(key)
+		if !ok {
+			var err error
+			data, err = router.render(ctx, render.resource, width, ".png", &skin)
+			if err == errRenderQueueFull || err == context.DeadlineExceeded || err == context.Canceled {
+				return nil, err
+			} else if err != nil {
+				log.Errorf("Failed batch render of %s for %s (%s)", render.resource, render.User, err.Error())
+				stats.Errored(ErrRenderFailed)
+				return nil, err
+			}
+			renderCache.add(key, data)
+		}
+
+		// PNGs are already compressed, so there's nothing to gain deflating
+		// them. They're left undated, so the same batch zips to the same
+		// bytes, and an interrupted download can be resumed.
+		file, err := archive.CreateHeader(&zip.FileHeader{Name: render.filename(width), Method: zip.Store})
+		if err == nil {
+			_, err = file.Write(data)
+		}
+		if err != nil {
+			stats.Errored(ErrInternal)
+			return nil, err
+		}
+	}
+	archive.Close()
+	return buf.Bytes(), nil
+}
+
+// Serves the ZIP of a batch's renders.
+func writeBatch(w http.ResponseWriter, r *http.Request, data []byte) {
+	// For If-Range, so a resumed download can't mix two different batches.
+	sum := md5.Sum(data)
+	w.Header().Set("ETag", quoteETag("batch-"+hex.EncodeToString(sum[:8])))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"renders.zip\"")
+	writeBody(w, r, data)
+}
+
+// Fetches the skin of each player in the batch, a few at a time, returning
+// them by lowercased username.
+func (router *Router) fetchBatchSkins(r *http.Request, renders []batchRender) map[string]*mcSkin {
+	users := make([]string, len(renders))
+	for i, render := range renders {
+		users[i] = render.User
+	}
+	return fetchSkinsConcurrently(users, func(user string) *mcSkin {
+		return fetchSkinForRequest(r, user, true)
+	})
+}
+
+// Fetches each of the players' skins once, batchFetchers at a time,
+// returning them by lowercased player.
+func fetchSkinsConcurrently(users []string, fetch func(user string) *mcSkin) map[string]*mcSkin {
+	skins := map[string]*mcSkin{}
+	seen := map[string]bool{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	fetchers := make(chan struct{}, batchFetchers)
+
+	for _, user := range users {
+		user = strings.ToLower(user)
+		if seen[user] {
+			continue
+		}
+		seen[user] = true
+
+		wg.Add(1)
+		fetchers <- struct{}{}
+		go func(user string) {
+			defer wg.Done()
+			skin := fetch(user)
+			<-fetchers
+
+			mu.Lock()
+			skins[user] = skin
+			mu.Unlock()
+		}(user)
+	}
+	wg.Wait()
+	return skins
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/minecraft"
+)
+
+func TestRenderBatchPage(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	renderCache = MakeRenderCache(1<<20, false)
+	hotCache = MakeHotCache(nil, 0)
+	missingFilter = MakeMissingFilter(0, time.Minute)
+
+	skin, _ := minecraft.FetchSkinForSteve()
+	skin.Hash = "clone1018"
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.BindAPI()
+	serve := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.Mux.ServeHTTP(w, httptest.NewRequest("POST", "/api/render/batch", strings.NewReader(body)))
+		return w
+	}
+
+	w := serve(`[{"user":"d9135e082f2244c89cb10d21ed3ac8fd","type":"helm","size":32},
+		{"user":"d9135e082f2244c89cb10d21ed3ac8fd","type":"Armor/Bust"}]`)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("Expected a ZIP, got %d", w.Code)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.File) != 2 || archive.File[0].Name != "d9135e082f2244c89cb10d21ed3ac8fd-helm-32.png" ||
+		archive.File[1].Name != "d9135e082f2244c89cb10d21ed3ac8fd-armor-bust-180.png" {
+		t.Fatalf("Expected a PNG per render, got %d files", len(archive.File))
+	}
+	file, _ := archive.File[0].Open()
+	img, err := png.Decode(file)
+	if err != nil || img.Bounds().Dx() != 32 {
+		t.Fatalf("Expected a 32 pixel helm, got %v", err)
+	}
+
+	if w := serve(`[{"user":"clone1018","type":"cape"}]`); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected an unknown type to be refused, got %d", w.Code)
+	}
+	if w := serve(`[{"user":"not a user","type":"helm"}]`); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected an invalid user to be refused, got %d", w.Code)
+	}
+	if w := serve(`[` + strings.Repeat(`{"user":"clone1018","type":"helm"},`, MaxRenderBatch) + `{"user":"clone1018","type":"helm"}]`); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected too big a batch to be refused, got %d", w.Code)
+	}
+}
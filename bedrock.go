@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Floodgate gives Bedrock players a UUID of 16 zero digits followed by
+// their XUID in hex.
+const floodgatePrefix = "0000000000000000"
+
+// Whether the UUID is one Floodgate gave a Bedrock player.
+func isFloodgateUUID(uuid string) bool {
+	return len(uuid) == 32 && strings.HasPrefix(uuid, floodgatePrefix)
+}
+
+func floodgateUUID(xuid uint64) string {
+	return fmt.Sprintf("%s%016x", floodgatePrefix, xuid)
+}
+
+// Whether the username is a Bedrock player's gamertag, which Floodgate
+// prefixes so they can't clash with Java players' names.
+func isBedrockGamertag(username string) bool {
+	return config.Bedrock.URL != "" && config.Bedrock.Prefix != "" && strings.HasPrefix(username, config.Bedrock.Prefix)
+}
+
+// Looks up the XUID for the gamertag with the Geyser API, returning it as
+// a Floodgate UUID.
+func resolveGamertag(ctx context.Context, username string) (string, NegativeReason) {
+	key := strings.ToLower(username)
+	if uuid, known := uuidCache.get(key); known {
+		return uuid, NegativeNone
+	}
+
+	gamertag := strings.TrimPrefix(username, config.Bedrock.Prefix)
+	result, err := coalesce("GetXUID", key, func() (interface{}, error) {
+		stats.APIRequested("GetXUID")
+		resp, err := upstreamGet(ctx, "GetXUID", config.Bedrock.URL+"xbox/xuid/"+gamertag)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		response := struct {
+			XUID json.Number `json:"xuid"`
+		}{}
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			return nil, fmt.Errorf("unable to GetXUID: %v", err)
+		}
+		xuid, err := strconv.ParseUint(response.XUID.String(), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unable to GetXUID: user not found")
+		}
+		return floodgateUUID(xuid), nil
+	})
+	if err != nil {
+		log.Infof("Failed XUID lookup: %s (%s)", username, err.Error())
+		stats.Errored(ErrLookupXUID)
+		if strings.HasSuffix(err.Error(), "user not found") {
+			return "", NegativeNotFound
+		}
+		return "", NegativeAPIError
+	}
+
+	uuid := result.(string)
+	uuidCache.add(key, uuid, config.uuidTtl())
+	return uuid, NegativeNone
+}
+
+// Fetches the Bedrock player's skin from the cache or Geyser.
+func fetchBedrockSkin(ctx context.Context, uuid string) *mcSkin {
+	if skin := pullCachedSkin(uuid); skin != nil {
+		return skin
+	}
+	stats.MissCache()
+	return fetchGeyserSkin(ctx, uuid)
+}
+
+// Fetches the Bedrock player's skin, caching it. Geyser converts the skins
+// Bedrock players wear to Java ones and uploads them to Mojang, so we only
+// need it to tell us which texture that is.
+func fetchGeyserSkin(ctx context.Context, uuid string) *mcSkin {
+	xuid, _ := strconv.ParseUint(strings.TrimPrefix(uuid, floodgatePrefix), 16, 64)
+	result, err := coalesce("GetBedrockSkin", uuid, func() (interface{}, error) {
+		stats.APIRequested("GetBedrockSkin")
+		skinTimer := prometheus.NewTimer(getDuration.WithLabelValues("GetBedrockSkin"))
+		defer skinTimer.ObserveDuration()
+
+		resp, err := upstreamGet(ctx, "GetBedrockSkin", config.Bedrock.URL+"skin/"+strconv.FormatUint(xuid, 10))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		response := struct {
+			TextureID string `json:"texture_id"`
+		}{}
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			return nil, fmt.Errorf("unable to GetBedrockSkin: %v", err)
+		}
+		if response.TextureID == "" {
+			return nil, fmt.Errorf("unable to GetBedrockSkin: user not found")
+		}
+		return response.TextureID, nil
+	})
+	if err != nil {
+		log.Infof("Failed Bedrock skin lookup: %s (%s)", uuid, err.Error())
+		stats.Errored(ErrBedrockSkin)
+		return fallbackFor(uuid)
+	}
+
+	skin := fetchSkinByHash(ctx, result.(string))
+	if !skin.Fallback {
+		addTimer := prometheus.NewTimer(cacheDuration.WithLabelValues("add"))
+		cache.add(uuid, skin.Skin, skinCacheTtl())
+		addTimer.ObserveDuration()
+	}
+	return skin
+}
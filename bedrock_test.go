@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/minecraft"
+)
+
+func TestFloodgateUUID(t *testing.T) {
+	uuid := floodgateUUID(2535428650863921)
+	if uuid != "0000000000000000000901f57c168d31" {
+		t.Fatalf("Expected the XUID in hex, got %s", uuid)
+	}
+	if !isFloodgateUUID(uuid) || isFloodgateUUID("069a79f444e94726a5befca90e38aaf5") {
+		t.Fatal("Expected only the Floodgate UUID to be recognised")
+	}
+}
+
+func TestBedrockSkin(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	renderCache = MakeRenderCache(0, false)
+	hotCache = MakeHotCache(nil, 0)
+	missingFilter = MakeMissingFilter(0, time.Minute)
+	config.Ttl.Skin = 60
+	config.Ttl.UUID = 60
+
+	texture := new(bytes.Buffer)
+	png.Encode(texture, image.NewNRGBA(image.Rect(0, 0, 64, 64)))
+
+	hash := "3b60a1f6d562f52aaebbf1434f1de147933a3affe0e764fa49ea057536623cd3"
+	var skinLookups int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/geyser/xbox/xuid/Steve_Bedrock":
+			w.Write([]byte(`{"xuid":2535428650863921}`))
+		case "/geyser/skin/2535428650863921":
+			atomic.AddInt32(&skinLookups, 1)
+			w.Write([]byte(`{"texture_id":"` + hash + `","is_steve":false}`))
+		case "/texture/" + hash:
+			w.Write(texture.Bytes())
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+	config.Bedrock.URL = server.URL + "/geyser/"
+	config.Bedrock.Prefix = "."
+	config.Minecraft.TextureURL = server.URL + "/texture/"
+	mcClient = &minecraft.Minecraft{Client: server.Client()}
+	defer func() { config.Bedrock.URL = "" }()
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+
+	w := httptest.NewRecorder()
+	router.Mux.ServeHTTP(w, httptest.NewRequest("GET", "/skin/.Steve_Bedrock", nil))
+	uuid := floodgateUUID(2535428650863921)
+	if w.Code != http.StatusOK || !cache.has(uuid) {
+		t.Fatalf("Expected the Geyser skin to be served and cached, got %d", w.Code)
+	}
+	if cached, _ := uuidCache.get(".steve_bedrock"); cached != uuid {
+		t.Fatalf("Expected the gamertag to be remembered as %s, got %s", uuid, cached)
+	}
+
+	if skin := fetchSkinVia(context.Background(), ".Nobody", false); !skin.Fallback {
+		t.Fatal("Expected an unknown gamertag to get Steve")
+	}
+
+	// Stale Bedrock skins are refreshed through Geyser, not Mojang.
+	refresher = MakeRefresher()
+	refresher.refresh(uuid)
+	for deadline := time.Now().Add(time.Second); refresher.pendingCount() > 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if lookups := atomic.LoadInt32(&skinLookups); lookups != 2 {
+		t.Fatalf("Expected the refresh to ask Geyser, it was asked %d times", lookups)
+	}
+}
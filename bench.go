@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Renders and sizes the synthetic mix of "imgd bench" picks from.
+var (
+	benchResources = []string{"avatar", "helm", "cube", "bust", "body", "armor/bust"}
+	benchSizes     = []uint{32, 64, 100, 180, 300}
+	// Players the mix is of, unless a file of them is given.
+	benchPlayers = []string{"clone1018", "Notch", "jeb_", "Dinnerbone", "char"}
+)
+
+// Picks the path out of a GET in a Combined Log Format line.
+var benchLogRegex = regexp.MustCompile(`"GET (/\S*) HTTP/[0-9.]+"`)
+
+// Returns n requests for the players, a few of whom are far more popular
+// than the rest, as on a real instance.
+func syntheticBenchPaths(players []string, n int, random *rand.Rand) []string {
+	popularity := rand.NewZipf(random, 1.2, 1, uint64(len(players)-1))
+	paths := make([]string, n)
+	for i := range paths {
+		player := players[popularity.Uint64()]
+		resource := benchResources[random.Intn(len(benchResources))]
+		size := benchSizes[random.Intn(len(benchSizes))]
+		paths[i] = fmt.Sprintf("/%s/%s/%d", resource, player, size)
+	}
+	return paths
+}
+
+// Returns the paths requested in an access log, in the order they were.
+func readBenchLog(r io.Reader) ([]string, error) {
+	paths := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if match := benchLogRegex.FindStringSubmatch(scanner.Text()); match != nil {
+			paths = append(paths, match[1])
+		}
+	}
+	return paths, scanner.Err()
+}
+
+// Skin and render cache lookups, to tell how a run went for the caches.
+type benchCacheCounts struct {
+	CacheHits         uint
+	CacheMisses       uint
+	RenderCacheHits   uint
+	RenderCacheMisses uint
+}
+
+// Something to run a benchmark against: a running instance, or our own
+// handlers in this process.
+type benchTarget struct {
+	get    func(path string) (int, error)
+	counts func() (benchCacheCounts, error)
+}
+
+// Targets the instance at the URL, reading its cache counts from the stats
+// URL.
+func remoteBenchTarget(base string, statsURL string) benchTarget {
+	client := &http.Client{Timeout: 30 * time.Second}
+	return benchTarget{
+		get: func(path string) (int, error) {
+			resp, err := client.Get(base + path)
+			if err != nil {
+				return 0, err
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			return resp.StatusCode, nil
+		},
+		counts: func() (benchCacheCounts, error) {
+			counts := benchCacheCounts{}
+			resp, err := client.Get(statsURL)
+			if err != nil {
+				return counts, err
+			}
+			defer resp.Body.Close()
+			return counts, json.NewDecoder(resp.Body).Decode(&counts)
+		},
+	}
+}
+
+// Targets our own handlers, as set up by the config, without a network in
+// between.
+func localBenchTarget() benchTarget {
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+	return benchTarget{
+		get: func(path string) (int, error) {
+			w := httptest.NewRecorder()
+			router.Mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+			return w.Code, nil
+		},
+		counts: func() (benchCacheCounts, error) {
+			info := stats.snapshot()
+			return benchCacheCounts{info.CacheHits, info.CacheMisses, info.RenderCacheHits, info.RenderCacheMisses}, nil
+		},
+	}
+}
+
+// How a benchmark run went.
+type benchReport struct {
+	Requests int
+	Failed   int
+	Elapsed  time.Duration
+	// Latencies of every request, fastest first.
+	Latencies []time.Duration
+	// What the caches saw over the run, if we could tell.
+	Counts *benchCacheCounts
+}
+
+// Requests each path from the target, concurrency at a time.
+func runBenchPaths(target benchTarget, paths []string, concurrency int) benchReport {
+	report := benchReport{Requests: len(paths), Latencies: make([]time.Duration, len(paths))}
+	before, countsErr := target.counts()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	next := make(chan int)
+	start := time.Now()
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				requested := time.Now()
+				status, err := target.get(paths[i])
+				report.Latencies[i] = time.Since(requested)
+				if err != nil || status >= 400 {
+					mu.Lock()
+					report.Failed++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range paths {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	report.Elapsed = time.Since(start)
+
+	sort.Slice(report.Latencies, func(i, j int) bool { return report.Latencies[i] < report.Latencies[j] })
+	if after, err := target.counts(); countsErr == nil && err == nil {
+		report.Counts = &benchCacheCounts{
+			CacheHits:         after.CacheHits - before.CacheHits,
+			CacheMisses:       after.CacheMisses - before.CacheMisses,
+			RenderCacheHits:   after.RenderCacheHits - before.RenderCacheHits,
+			RenderCacheMisses: after.RenderCacheMisses - before.RenderCacheMisses,
+		}
+	}
+	return report
+}
+
+// Returns the latency the fraction of requests were at least as fast as.
+func (b benchReport) percentile(fraction float64) time.Duration {
+	if len(b.Latencies) == 0 {
+		return 0
+	}
+	return b.Latencies[int(fraction*float64(len(b.Latencies)-1))]
+}
+
+func (b benchReport) write(w io.Writer) {
+	fmt.Fprintf(w, "Requests:     %d (%d failed) in %s, %.1f/s\n", b.Requests, b.Failed, b.Elapsed.Round(time.Millisecond), float64(b.Requests)/b.Elapsed.Seconds())
+	fmt.Fprintf(w, "Latency:      p50 %s, p95 %s, p99 %s, max %s\n", b.percentile(0.5), b.percentile(0.95), b.percentile(0.99), b.percentile(1))
+	if b.Counts == nil {
+		fmt.Fprintf(w, "Cache hits:   unknown, the stats couldn't be read\n")
+		return
+	}
+	fmt.Fprintf(w, "Cache hits:   %s of skins, %s of renders\n",
+		benchRatio(b.Counts.CacheHits, b.Counts.CacheMisses), benchRatio(b.Counts.RenderCacheHits, b.Counts.RenderCacheMisses))
+}
+
+func benchRatio(hits uint, misses uint) string {
+	if hits+misses == 0 {
+		return "none looked up"
+	}
+	return fmt.Sprintf("%.1f%% (%d/%d)", float64(hits)/float64(hits+misses)*100, hits, hits+misses)
+}
+
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	target := fs.String("url", "", "instance to run against, rather than our own handlers in process")
+	statsURL := fs.String("stats", "", "where the instance's /stats are, if not under -url")
+	logFile := fs.String("log", "", "access log to replay the requests of, rather than a synthetic mix")
+	playersFile := fs.String("players", "", "file of players for the synthetic mix, one per line")
+	requests := fs.Int("n", 1000, "requests to make, for the synthetic mix")
+	concurrency := fs.Int("c", 8, "requests to make at once")
+	if !parseCommand(fs, args, 0, 0) {
+		return 2
+	}
+	if *requests < 1 || *concurrency < 1 {
+		fmt.Fprintf(os.Stderr, "-n and -c must be at least 1\n")
+		return 2
+	}
+
+	var paths []string
+	if *logFile != "" {
+		file, err := os.Open(*logFile)
+		if err == nil {
+			paths, err = readBenchLog(file)
+			file.Close()
+		}
+		if err != nil || len(paths) == 0 {
+			fmt.Fprintf(os.Stderr, "Unable to read requests from %s (%v)\n", *logFile, err)
+			return 1
+		}
+	}
+
+	if *target == "" {
+		setupTool()
+		setupRoutes()
+		setupRenderPool()
+	} else {
+		stats = MakeStatsCollector()
+		setupConfig()
+		setupLog(os.Stderr)
+	}
+	if paths == nil {
+		players := benchPlayers
+		if *playersFile != "" {
+			var err error
+			if players, err = readWarmupFile(*playersFile); err != nil || len(players) == 0 {
+				fmt.Fprintf(os.Stderr, "Unable to read players from %s (%v)\n", *playersFile, err)
+				return 1
+			}
+		}
+		paths = syntheticBenchPaths(players, *requests, rand.New(rand.NewSource(time.Now().UnixNano())))
+	}
+
+	var bench benchTarget
+	if *target == "" {
+		bench = localBenchTarget()
+	} else {
+		base := strings.TrimRight(*target, "/")
+		if *statsURL == "" {
+			*statsURL = base + "/stats"
+		}
+		bench = remoteBenchTarget(base, *statsURL)
+	}
+
+	fmt.Fprintf(os.Stderr, "Making %d requests, %d at a time...\n", len(paths), *concurrency)
+	runBenchPaths(bench, paths, *concurrency).write(os.Stdout)
+	return 0
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReadBenchLog(t *testing.T) {
+	paths, err := readBenchLog(strings.NewReader(`192.0.2.1 - - [02/Jan/2006:15:04:05 +0000] "GET /helm/clone1018/64 HTTP/1.1" 200 1234 "-" "curl/8.0"
+192.0.2.1 - - [02/Jan/2006:15:04:05 +0000] "POST /api/profiles HTTP/1.1" 200 99 "-" "-"
+192.0.2.1 - - [02/Jan/2006:15:04:06 +0000] "GET /avatar/Notch.png?fallback=identicon HTTP/2.0" 200 567 "-" "-"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 || paths[1] != "/avatar/Notch.png?fallback=identicon" {
+		t.Fatalf("Expected the two GETs, got %v", paths)
+	}
+}
+
+func TestRunBenchPaths(t *testing.T) {
+	paths := syntheticBenchPaths(benchPlayers, 200, rand.New(rand.NewSource(1)))
+	if len(paths) != 200 || !strings.HasPrefix(paths[0], "/") {
+		t.Fatalf("Expected 200 paths, got %v", paths)
+	}
+
+	var served int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stats" {
+			n := uint(atomic.LoadInt32(&served))
+			json.NewEncoder(w).Encode(benchCacheCounts{CacheHits: n * 3 / 4, CacheMisses: n - n*3/4})
+			return
+		}
+		if atomic.AddInt32(&served, 1)%10 == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	report := runBenchPaths(remoteBenchTarget(server.URL, server.URL+"/stats"), paths, 4)
+	if report.Requests != 200 || report.Failed != 20 {
+		t.Fatalf("Expected 20 of 200 requests to fail, got %d of %d", report.Failed, report.Requests)
+	}
+	if report.Counts == nil || report.Counts.CacheHits != 150 || report.percentile(0.5) > report.percentile(0.99) {
+		t.Fatalf("Expected the cache hits over the run and ordered latencies, got %+v", report.Counts)
+	}
+
+	out := new(bytes.Buffer)
+	report.write(out)
+	if !strings.Contains(out.String(), "75.0% (150/200) of skins") || !strings.Contains(out.String(), "p95") {
+		t.Fatalf("Expected a report of the run, got %s", out.String())
+	}
+}
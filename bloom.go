@@ -0,0 +1,124 @@
+package main
+
+import (
+	"hash/fnv"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Number of hash functions, which gives a 1% false positive rate when the
+// filter holds as many names as it was sized for.
+const bloomHashes = 7
+
+type bloomFilter []uint64
+
+// Calls fn with each bit position the name maps to.
+func (b bloomFilter) positions(name string, fn func(uint64)) {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32
+
+	bits := uint64(len(b)) * 64
+	for i := uint64(0); i < bloomHashes; i++ {
+		fn((h1 + i*h2) % bits)
+	}
+}
+
+func (b bloomFilter) add(name string) {
+	b.positions(name, func(pos uint64) {
+		b[pos/64] |= 1 << (pos % 64)
+	})
+}
+
+func (b bloomFilter) has(name string) bool {
+	found := true
+	b.positions(name, func(pos uint64) {
+		if b[pos/64]&(1<<(pos%64)) == 0 {
+			found = false
+		}
+	})
+	return found
+}
+
+// Remembers names Mojang recently told us don't exist, so bots probing
+// random names are turned away before we touch the cache or upstream. Names
+// go into the current generation of the filter, which is rotated out every
+// Interval, so names are forgotten after one to two intervals. As with any
+// bloom filter, a small fraction of real players may be turned away until
+// then.
+type MissingFilter struct {
+	Interval time.Duration
+
+	mu       sync.Mutex
+	current  bloomFilter
+	previous bloomFilter
+	rotated  time.Time
+}
+
+// Makes a filter sized to hold the given number of names per generation.
+// A size of 0 makes a filter which never matches.
+func MakeMissingFilter(size int, interval time.Duration) *MissingFilter {
+	f := &MissingFilter{Interval: interval, rotated: time.Now()}
+	if size > 0 {
+		// Bits needed for a 1% false positive rate, rounded up to a word.
+		bits := math.Ceil(-float64(size) * math.Log(0.01) / (math.Ln2 * math.Ln2))
+		words := int(math.Ceil(bits / 64))
+		f.current = make(bloomFilter, words)
+		f.previous = make(bloomFilter, words)
+	}
+	return f
+}
+
+// Swaps in a fresh generation if the current one is old enough. Must be
+// called with the lock held.
+func (f *MissingFilter) rotate() {
+	if time.Since(f.rotated) < f.Interval {
+		return
+	}
+	f.previous, f.current = f.current, f.previous
+	for i := range f.current {
+		f.current[i] = 0
+	}
+	f.rotated = time.Now()
+}
+
+func (f *MissingFilter) add(name string) {
+	if len(f.current) == 0 {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.rotate()
+	f.current.add(strings.ToLower(name))
+}
+
+// Whether the name was recently missing. May rarely return true for a name
+// which wasn't.
+func (f *MissingFilter) has(name string) bool {
+	if len(f.current) == 0 {
+		return false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.rotate()
+	name = strings.ToLower(name)
+	return f.current.has(name) || f.previous.has(name)
+}
+
+// Forgets every name.
+func (f *MissingFilter) flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range f.current {
+		f.current[i] = 0
+		f.previous[i] = 0
+	}
+}
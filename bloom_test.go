@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMissingFilter(t *testing.T) {
+	f := MakeMissingFilter(1000, time.Hour)
+	for i := 0; i < 1000; i++ {
+		f.add("missing" + strconv.Itoa(i))
+	}
+	for i := 0; i < 1000; i++ {
+		if !f.has("Missing" + strconv.Itoa(i)) {
+			t.Fatalf("Expected missing%d to be found", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if f.has("player" + strconv.Itoa(i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 300 {
+		t.Fatalf("Expected around 1%% false positives, got %d in 10000", falsePositives)
+	}
+
+	// After two rotations, everything should be forgotten.
+	f.Interval = 0
+	f.has("rotate")
+	f.has("rotate")
+	if f.has("missing0") {
+		t.Fatal("Expected missing0 to be forgotten")
+	}
+
+	if MakeMissingFilter(0, time.Hour).has("anything") {
+		t.Fatal("A disabled filter shouldn't match")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Returned in place of asking Mojang while the circuit is open.
+var errCircuitOpen = errors.New("upstream unavailable: circuit open")
+
+// The states of a CircuitBreaker.
+const (
+	CircuitClosed = iota
+	// Letting a single request through to see if upstream has recovered.
+	CircuitHalfOpen
+	CircuitOpen
+)
+
+var circuitStateNames = map[int]string{
+	CircuitClosed:   "closed",
+	CircuitHalfOpen: "half-open",
+	CircuitOpen:     "open",
+}
+
+// Stops us waiting on an upstream which is down. After Threshold failures
+// in a row the circuit opens, and requests fail straight away for the
+// Cooldown. Then one request is let through to try upstream again: if it
+// succeeds the circuit closes, otherwise it opens for another Cooldown.
+type CircuitBreaker struct {
+	// Failures in a row which open the circuit, 0 to never open it.
+	Threshold uint
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    int
+	failures uint
+	openedAt time.Time
+	// Number of times the circuit has opened.
+	trips uint
+}
+
+func MakeCircuitBreaker(threshold uint, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{Threshold: threshold, Cooldown: cooldown}
+}
+
+// Whether a request may go through to upstream. A request which is allowed
+// must report back with succeeded or failed.
+func (b *CircuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.Cooldown {
+			return false
+		}
+		b.state = CircuitHalfOpen
+		return true
+	case CircuitHalfOpen:
+		// Someone's already trying upstream.
+		return false
+	default:
+		return true
+	}
+}
+
+func (b *CircuitBreaker) succeeded() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitHalfOpen {
+		log.Notice("Upstream recovered, closing circuit")
+	}
+	b.state = CircuitClosed
+	b.failures = 0
+}
+
+func (b *CircuitBreaker) failed() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == CircuitHalfOpen || (b.Threshold > 0 && b.failures >= b.Threshold) {
+		if b.state != CircuitOpen {
+			b.trips++
+			log.Warningf("Upstream failed %d times in a row, opening circuit for %s", b.failures, b.Cooldown)
+		}
+		b.state = CircuitOpen
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *CircuitBreaker) currentState() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// Number of times the circuit has opened.
+func (b *CircuitBreaker) tripCount() uint {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.trips
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	stats = MakeStatsCollector()
+	u := MakeUpstream(0, 0)
+	u.Breaker = MakeCircuitBreaker(3, time.Minute)
+
+	calls := 0
+	failing := func() (interface{}, error) {
+		calls++
+		return nil, errors.New("unable to GetSessionProfile: 503 Service Unavailable")
+	}
+	notFound := func() (interface{}, error) {
+		calls++
+		return nil, errors.New("unable to GetSessionProfile: user not found")
+	}
+
+	u.do(failing)
+	u.do(notFound)
+	u.do(failing)
+	u.do(failing)
+	if u.Breaker.currentState() != CircuitClosed {
+		t.Fatal("Expected a missing player to break the run of failures")
+	}
+	u.do(failing)
+	if u.Breaker.currentState() != CircuitOpen || u.Breaker.tripCount() != 1 {
+		t.Fatal("Expected the circuit to open after 3 failures in a row")
+	}
+
+	if _, err := u.do(failing); err != errCircuitOpen || calls != 5 {
+		t.Fatal("Expected requests to fail straight away while the circuit is open")
+	}
+
+	// Once the cooldown's up a single request is let through, and failing
+	// it opens the circuit again.
+	u.Breaker.openedAt = time.Now().Add(-time.Minute)
+	u.do(failing)
+	if u.Breaker.currentState() != CircuitOpen || calls != 6 {
+		t.Fatal("Expected the trial request to reopen the circuit")
+	}
+
+	u.Breaker.openedAt = time.Now().Add(-time.Minute)
+	if !u.Breaker.allow() || u.Breaker.allow() {
+		t.Fatal("Expected only one trial request while half-open")
+	}
+	u.Breaker.succeeded()
+	if u.Breaker.currentState() != CircuitClosed {
+		t.Fatal("Expected a successful trial to close the circuit")
+	}
+}
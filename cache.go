@@ -1,21 +1,159 @@
 package main
 
 import (
+	"strconv"
+	"time"
+
 	"github.com/minotar/minecraft"
 )
 
+// Why we have no skin for a username. Stored in the cache in place of a
+// skin, so we don't ask Mojang again on every request.
+type NegativeReason uint8
+
+const (
+	// No negative entry, we either have a skin or haven't looked yet.
+	NegativeNone NegativeReason = iota
+	// Mojang told us the user doesn't exist.
+	NegativeNotFound
+	// Mojang failed us, or rate limited us.
+	NegativeAPIError
+)
+
+func (r NegativeReason) String() string {
+	switch r {
+	case NegativeNotFound:
+		return "not found"
+	case NegativeAPIError:
+		return "API error"
+	default:
+		return "none"
+	}
+}
+
+// Parses a reason stored by one of the external caches.
+func parseNegativeReason(value []byte) NegativeReason {
+	reason, err := strconv.Atoi(string(value))
+	if err != nil || reason <= int(NegativeNone) || reason > int(NegativeAPIError) {
+		return NegativeNone
+	}
+	return NegativeReason(reason)
+}
+
+// The value external caches store for a reason.
+func (r NegativeReason) bytes() []byte {
+	return []byte(strconv.Itoa(int(r)))
+}
+
 type Cache interface {
 	setup() error
 	has(username string) bool
 	pull(username string) minecraft.Skin
-	add(username string, skin minecraft.Skin)
+	// Stores the skin, expiring it after ttl.
+	add(username string, skin minecraft.Skin, ttl time.Duration)
+	// Records why we have no skin for the username, expiring after ttl.
+	addNegative(username string, reason NegativeReason, ttl time.Duration)
+	// Returns why we have no skin for the username, or NegativeNone.
+	pullNegative(username string) NegativeReason
+	// Removes the username's skin and any negative entry.
+	remove(username string)
+	// Removes everything we've cached.
+	flush() error
 	size() uint
 	memory() uint64
 }
 
+// Caches which store a texture once for every username wearing it.
+type dedupCache interface {
+	// Bytes saved compared to storing a copy per username.
+	dedupSaved() uint64
+}
+
+// Caches which can tell how long an entry has left, allowing us to serve
+// stale skins while refreshing them.
+type agingCache interface {
+	// Returns how long until the username's skin expires, if it's cached.
+	expiresIn(username string) (time.Duration, bool)
+}
+
+// Caches with a fixed size limit, so we can tell how full they are.
+type boundedCache interface {
+	// Returns the most bytes the cache may use, 0 for no limit.
+	capacity() uint64
+}
+
+// Caches which know how long they've held their entries.
+type agedCache interface {
+	// Returns the mean time since the entries were added.
+	averageAge() time.Duration
+}
+
+// Returns the name the cache is configured by, to label its metrics with.
+func cacheBackend(c Cache) string {
+	switch c.(type) {
+	case *CacheRedis:
+		return "redis"
+	case *CacheMemcached:
+		return "memcached"
+	case *CacheDisk:
+		return "disk"
+	case *CacheS3:
+		return "s3"
+	case *CacheTiered:
+		return "tiered"
+	case *CacheMemory:
+		return "memory"
+	default:
+		return "off"
+	}
+}
+
+// A single entry in the cache, as shown to operators.
+type cacheEntry struct {
+	Key string
+	// Set for negative entries.
+	Reason string `json:",omitempty"`
+	// Bytes the entry takes up.
+	Size uint64
+	// Seconds since the entry was added, and until it expires.
+	Age int64
+	TTL int64
+	// Number of times the entry has been pulled, if the cache counts them.
+	Hits uint
+}
+
+// Caches which can list what they hold.
+type inspectableCache interface {
+	entries() []cacheEntry
+}
+
+// Caches which can tidy up after themselves, eg. dropping expired entries
+// and reclaiming the space they used.
+type compactingCache interface {
+	// Returns the number of entries removed.
+	compact() (uint, error)
+}
+
+// Caches backed by a server or filesystem we can lose touch with.
+type pingableCache interface {
+	// Returns why the backend can't be reached, if it can't.
+	ping() error
+}
+
+// Caches which can be configured, any other is treated as "off".
+var cacheTypes = []string{"redis", "memcached", "disk", "s3", "tiered", "memory", "off"}
+
 func MakeCache(cacheType string) Cache {
 	if cacheType == "redis" {
 		return &CacheRedis{}
+	} else if cacheType == "memcached" {
+		return &CacheMemcached{}
+	} else if cacheType == "disk" {
+		return &CacheDisk{}
+	} else if cacheType == "s3" {
+		return &CacheS3{}
+	} else if cacheType == "tiered" {
+		return &CacheTiered{}
 	} else if cacheType == "memory" {
 		return &CacheMemory{}
 	} else {
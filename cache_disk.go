@@ -0,0 +1,363 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minotar/minecraft"
+)
+
+// Cache object that stores skins as PNG files on disk. Files are spread
+// over 256 subdirectories so no single directory gets too large, and each
+// file's modification time is set to when it expires.
+type CacheDisk struct {
+	Path string
+	// Maximum bytes to use before the skins closest to expiring are
+	// cleaned up, 0 for no limit.
+	MaxSize uint64
+
+	mu    sync.Mutex
+	count uint
+	bytes uint64
+	// Set while a cleanup is running, so we don't start a second.
+	cleaning bool
+}
+
+func (c *CacheDisk) setup() error {
+	c.Path = config.Disk.Path
+	c.MaxSize = uint64(config.Disk.MaxSize) << 20
+
+	if err := os.MkdirAll(c.Path, 0755); err != nil {
+		log.Error("Error creating disk cache directory")
+		return err
+	}
+	if _, err := c.compact(); err != nil {
+		return err
+	}
+
+	log.Noticef("Loaded Disk cache (path: %s, skins: %d, size: %d bytes)", c.Path, c.count, c.bytes)
+	return nil
+}
+
+// Returns the path the username's skin is stored at.
+func (c *CacheDisk) file(username string) string {
+	return c.fileWithExt(username, ".png")
+}
+
+// Returns the path the username's negative entry is stored at, next to
+// where their skin would be.
+func (c *CacheDisk) negativeFile(username string) string {
+	return c.fileWithExt(username, ".neg")
+}
+
+func (c *CacheDisk) fileWithExt(username, ext string) string {
+	sum := md5.Sum([]byte(username))
+	return filepath.Join(c.Path, hex.EncodeToString(sum[:1]), username+ext)
+}
+
+func (c *CacheDisk) expired(info os.FileInfo) bool {
+	return time.Now().After(info.ModTime())
+}
+
+func (c *CacheDisk) ping() error {
+	_, err := os.Stat(c.Path)
+	return err
+}
+
+func (c *CacheDisk) has(username string) bool {
+	info, err := os.Stat(c.file(username))
+	if err != nil {
+		return false
+	}
+	return !c.expired(info)
+}
+
+func (c *CacheDisk) expiresIn(username string) (time.Duration, bool) {
+	info, err := os.Stat(c.file(username))
+	if err != nil || c.expired(info) {
+		return 0, false
+	}
+	return time.Until(info.ModTime()), true
+}
+
+func (c *CacheDisk) pull(username string) minecraft.Skin {
+	skinBytes, err := ioutil.ReadFile(c.file(username))
+	if err != nil {
+		log.Error(err.Error())
+		return fallbackSkin()
+	}
+
+	skin, err := decodeSkinPNG(skinBytes)
+	if err != nil {
+		log.Error(err.Error())
+		c.remove(username)
+		return fallbackSkin()
+	}
+	return skin
+}
+
+func (c *CacheDisk) add(username string, skin minecraft.Skin, ttl time.Duration) {
+	data, err := encodeSkinPNG(skin)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	c.write(c.file(username), data, ttl)
+}
+
+func (c *CacheDisk) addNegative(username string, reason NegativeReason, ttl time.Duration) {
+	c.write(c.negativeFile(username), reason.bytes(), ttl)
+}
+
+func (c *CacheDisk) pullNegative(username string) NegativeReason {
+	path := c.negativeFile(username)
+	info, err := os.Stat(path)
+	if err != nil || c.expired(info) {
+		return NegativeNone
+	}
+	value, err := ioutil.ReadFile(path)
+	if err != nil {
+		return NegativeNone
+	}
+	return parseNegativeReason(value)
+}
+
+// Writes the file, setting it to expire after ttl, and kicks off a cleanup
+// if that took us over the size limit.
+func (c *CacheDisk) write(path string, data []byte, ttl time.Duration) {
+	var previous int64
+	if info, err := os.Stat(path); err == nil {
+		previous = info.Size()
+	}
+
+	if err := writeFileAtomic(path, data); err != nil {
+		log.Error(err.Error())
+		stats.Errored(ErrCacheDisk)
+		return
+	}
+	expires := time.Now().Add(ttl)
+	if err := os.Chtimes(path, expires, expires); err != nil {
+		log.Error(err.Error())
+	}
+
+	c.mu.Lock()
+	if previous == 0 {
+		c.count++
+	}
+	c.bytes = c.bytes + uint64(len(data)) - uint64(previous)
+	countAdmitted("disk", uint64(len(data)))
+	if previous > 0 {
+		countEvicted("disk", uint64(previous))
+	}
+	needsCleanup := c.MaxSize > 0 && c.bytes > c.MaxSize && !c.cleaning
+	if needsCleanup {
+		c.cleaning = true
+	}
+	c.mu.Unlock()
+
+	if needsCleanup {
+		go c.cleanup()
+	}
+}
+
+func (c *CacheDisk) remove(username string) {
+	c.removeFile(c.file(username))
+	c.removeFile(c.negativeFile(username))
+}
+
+func (c *CacheDisk) removeFile(path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	c.mu.Lock()
+	c.count--
+	c.bytes -= uint64(info.Size())
+	c.mu.Unlock()
+	countRemoved("disk", removedPurge, 1)
+	countEvicted("disk", uint64(info.Size()))
+}
+
+// Removes every file in the cache.
+func (c *CacheDisk) flush() error {
+	files, err := c.files()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		c.removeFile(file.path)
+	}
+	return nil
+}
+
+type diskCacheFile struct {
+	path string
+	info os.FileInfo
+}
+
+// Lists every skin and negative entry file in the cache.
+func (c *CacheDisk) files() ([]diskCacheFile, error) {
+	files := []diskCacheFile{}
+	err := filepath.Walk(c.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && (strings.HasSuffix(path, ".png") || strings.HasSuffix(path, ".neg")) {
+			files = append(files, diskCacheFile{path, info})
+		}
+		return nil
+	})
+	return files, err
+}
+
+// Lists the unexpired skins and negative entries. We don't know when they
+// were added, or how often they've been used.
+func (c *CacheDisk) entries() []cacheEntry {
+	files, err := c.files()
+	if err != nil {
+		log.Error(err.Error())
+		return nil
+	}
+
+	now := time.Now()
+	entries := []cacheEntry{}
+	for _, file := range files {
+		if c.expired(file.info) {
+			continue
+		}
+		name := filepath.Base(file.path)
+		entry := cacheEntry{
+			Key:  strings.TrimSuffix(name, filepath.Ext(name)),
+			Size: uint64(file.info.Size()),
+			TTL:  int64(file.info.ModTime().Sub(now).Seconds()),
+		}
+		if filepath.Ext(name) == ".neg" {
+			if value, err := ioutil.ReadFile(file.path); err == nil {
+				entry.Reason = parseNegativeReason(value).String()
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Removes the skins closest to expiring until we're back under 90% of the
+// size limit.
+func (c *CacheDisk) cleanup() {
+	defer func() {
+		c.mu.Lock()
+		c.cleaning = false
+		c.mu.Unlock()
+	}()
+
+	files, err := c.files()
+	if err != nil {
+		log.Errorf("Disk cache cleanup failed (%v)", err)
+		return
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].info.ModTime().Before(files[j].info.ModTime())
+	})
+
+	target := c.MaxSize / 10 * 9
+	for _, file := range files {
+		c.mu.Lock()
+		done := c.bytes <= target
+		c.mu.Unlock()
+		if done {
+			break
+		}
+		if err := os.Remove(file.path); err != nil {
+			continue
+		}
+		c.mu.Lock()
+		c.count--
+		c.bytes -= uint64(file.info.Size())
+		c.mu.Unlock()
+		countRemoved("disk", removedLRU, 1)
+		countEvicted("disk", uint64(file.info.Size()))
+	}
+}
+
+// Removes expired skins and recounts what's left.
+func (c *CacheDisk) compact() (uint, error) {
+	files, err := c.files()
+	if err != nil {
+		return 0, err
+	}
+
+	var removed, count uint
+	var size uint64
+	for _, file := range files {
+		if c.expired(file.info) {
+			if os.Remove(file.path) == nil {
+				removed++
+				continue
+			}
+		}
+		count++
+		size += uint64(file.info.Size())
+	}
+
+	c.mu.Lock()
+	c.count = count
+	c.bytes = size
+	c.mu.Unlock()
+	countRemoved("disk", removedTTL, removed)
+
+	return removed, nil
+}
+
+func (c *CacheDisk) size() uint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.count
+}
+
+func (c *CacheDisk) capacity() uint64 {
+	return c.MaxSize
+}
+
+func (c *CacheDisk) memory() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.bytes
+}
+
+// Writes the file to a temporary path and renames it into place, so readers
+// never see a half-written file.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
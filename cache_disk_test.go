@@ -0,0 +1,117 @@
+package main
+
+import (
+	"image"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/minotar/minecraft"
+)
+
+func setupDiskTest(t *testing.T) *CacheDisk {
+	stats = MakeStatsCollector()
+	config = &Configuration{}
+	config.Disk.Path = t.TempDir()
+
+	c := &CacheDisk{}
+	if err := c.setup(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func diskTestSkin() minecraft.Skin {
+	skin := minecraft.Skin{}
+	skin.Image = image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	skin.URL = "http://textures.minecraft.net/texture/3b60a1f6"
+	skin.Hash = "3b60a1f6"
+	return skin
+}
+
+func TestCacheDiskInsertPull(t *testing.T) {
+	c := setupDiskTest(t)
+
+	if c.has("clone1018") {
+		t.Fatal("Expected an empty cache")
+	}
+	c.add("clone1018", diskTestSkin(), time.Minute)
+	if !c.has("clone1018") {
+		t.Fatal("Expected the skin to be cached")
+	}
+	if c.size() != 1 || c.memory() == 0 {
+		t.Fatalf("Expected one skin to be counted, got %d in %d bytes", c.size(), c.memory())
+	}
+	if pulled := c.pull("clone1018"); pulled.Image == nil || pulled.Image.Bounds().Dx() != 64 {
+		t.Fatal("Expected the skin to decode back")
+	} else if pulled.URL != diskTestSkin().URL || pulled.Hash != "3b60a1f6" {
+		t.Fatalf("Expected the skin's URL and hash to be kept, got %q and %q", pulled.URL, pulled.Hash)
+	}
+	if ttl, ok := c.expiresIn("clone1018"); !ok || ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("Expected the skin to expire within a minute, got %s", ttl)
+	}
+}
+
+func TestCacheDiskExpiry(t *testing.T) {
+	c := setupDiskTest(t)
+
+	c.add("clone1018", diskTestSkin(), time.Minute)
+	c.add("lukegb", diskTestSkin(), -time.Second)
+	if !c.has("clone1018") || c.has("lukegb") {
+		t.Fatal("Expected only the unexpired skin to be cached")
+	}
+	if _, ok := c.expiresIn("lukegb"); ok {
+		t.Fatal("Expected no expiry for an expired skin")
+	}
+
+	removed, err := c.compact()
+	if err != nil || removed != 1 {
+		t.Fatalf("Expected the expired skin to be compacted away, removed %d (%v)", removed, err)
+	}
+	if c.size() != 1 {
+		t.Fatalf("Expected one skin left, got %d", c.size())
+	}
+	if _, err := os.Stat(c.file("lukegb")); !os.IsNotExist(err) {
+		t.Fatal("Expected the expired skin's file to be gone")
+	}
+}
+
+func TestCacheDiskRemove(t *testing.T) {
+	c := setupDiskTest(t)
+
+	c.add("clone1018", diskTestSkin(), time.Minute)
+	c.addNegative("clone1018", NegativeNotFound, time.Minute)
+	if c.pullNegative("clone1018") != NegativeNotFound {
+		t.Fatal("Expected the negative entry")
+	}
+	if c.size() != 2 {
+		t.Fatalf("Expected the skin and negative entry to be counted, got %d", c.size())
+	}
+
+	c.remove("clone1018")
+	if c.has("clone1018") || c.pullNegative("clone1018") != NegativeNone {
+		t.Fatal("Expected the skin and negative entry to be removed")
+	}
+	if c.size() != 0 || c.memory() != 0 {
+		t.Fatalf("Expected nothing to be counted, got %d in %d bytes", c.size(), c.memory())
+	}
+}
+
+func TestCacheDiskCleanup(t *testing.T) {
+	c := setupDiskTest(t)
+
+	c.add("clone1018", diskTestSkin(), time.Minute)
+	// Room for one skin and a half, so one has to go.
+	c.MaxSize = c.memory() * 3 / 2
+	c.add("lukegb", diskTestSkin(), time.Hour)
+
+	// The cleanup runs in the background, and removes the skin closest to
+	// expiring.
+	deadline := time.Now().Add(time.Second)
+	for c.has("clone1018") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c.has("clone1018") || !c.has("lukegb") {
+		t.Fatal("Expected the skin closest to expiring to be cleaned up")
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/minotar/minecraft"
+)
+
+// Memcached treats expirations longer than 30 days as a unix timestamp.
+const memcachedMaxTtl = 60 * 60 * 24 * 30
+
+// How long a skin fetched by has is held for the pull which usually follows
+// it, and how many may be held at once.
+const (
+	memcachedHoldFor = time.Second
+	memcachedHoldMax = 1024
+)
+
+type memcachedHeld struct {
+	Value   []byte
+	Expires time.Time
+}
+
+type CacheMemcached struct {
+	Client *memcache.Client
+
+	mu   sync.Mutex
+	held map[string]memcachedHeld
+}
+
+func (c *CacheMemcached) setup() error {
+	c.Client = memcache.New(config.Memcached.Server...)
+	if config.Memcached.Timeout > 0 {
+		c.Client.Timeout = msDuration(config.Memcached.Timeout)
+	}
+
+	log.Noticef("Loaded Memcached cache (servers: %v, prefix: \"%s\")", config.Memcached.Server, config.Memcached.Prefix)
+	return nil
+}
+
+// Logs errors other than a plain miss, which is expected.
+func (c *CacheMemcached) checkError(err error) {
+	if err != nil && err != memcache.ErrCacheMiss {
+		log.Error(err.Error())
+		stats.Errored(ErrCacheMemcached)
+	}
+}
+
+func (c *CacheMemcached) ping() error {
+	return c.Client.Ping()
+}
+
+// Memcached has no EXISTS, and touching the item would put off its expiry,
+// so this fetches it, holding on to it briefly for pull.
+func (c *CacheMemcached) has(username string) bool {
+	item, err := c.Client.Get(config.Memcached.Prefix + username)
+	c.checkError(err)
+	if err != nil {
+		return false
+	}
+	c.hold(username, item.Value)
+	return true
+}
+
+// Holds the value for pull, sweeping out those never pulled when full.
+func (c *CacheMemcached) hold(username string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.held == nil {
+		c.held = map[string]memcachedHeld{}
+	}
+	now := time.Now()
+	if len(c.held) >= memcachedHoldMax {
+		for key, held := range c.held {
+			if now.After(held.Expires) {
+				delete(c.held, key)
+			}
+		}
+		if len(c.held) >= memcachedHoldMax {
+			return
+		}
+	}
+	c.held[username] = memcachedHeld{Value: value, Expires: now.Add(memcachedHoldFor)}
+}
+
+// Returns the value has just fetched, if it's still fresh, letting go of it.
+func (c *CacheMemcached) takeHeld(username string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	held, exists := c.held[username]
+	delete(c.held, username)
+	if !exists || time.Now().After(held.Expires) {
+		return nil, false
+	}
+	return held.Value, true
+}
+
+func (c *CacheMemcached) forgetHeld(username string) {
+	c.mu.Lock()
+	delete(c.held, username)
+	c.mu.Unlock()
+}
+
+func (c *CacheMemcached) pull(username string) minecraft.Skin {
+	value, ok := c.takeHeld(username)
+	if !ok {
+		item, err := c.Client.Get(config.Memcached.Prefix + username)
+		if err != nil {
+			c.checkError(err)
+			return fallbackSkin()
+		}
+		value = item.Value
+	}
+
+	skin, err := decodeSkinPNG(value)
+	if err != nil {
+		log.Error(err.Error())
+		c.remove(username)
+		return fallbackSkin()
+	}
+	return skin
+}
+
+func (c *CacheMemcached) add(username string, skin minecraft.Skin, ttl time.Duration) {
+	data, err := encodeSkinPNG(skin)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	// The PNG bytes are stored as-is, memcached values are binary safe.
+	c.forgetHeld(username)
+	c.checkError(c.Client.Set(&memcache.Item{
+		Key:        config.Memcached.Prefix + username,
+		Value:      data,
+		Expiration: memcachedExpiration(ttl),
+	}))
+}
+
+// Negative entries live alongside the skins. Usernames can't contain a
+// colon, so they can't collide.
+func (c *CacheMemcached) addNegative(username string, reason NegativeReason, ttl time.Duration) {
+	c.checkError(c.Client.Set(&memcache.Item{
+		Key:        config.Memcached.Prefix + "negative:" + username,
+		Value:      reason.bytes(),
+		Expiration: memcachedExpiration(ttl),
+	}))
+}
+
+func (c *CacheMemcached) pullNegative(username string) NegativeReason {
+	item, err := c.Client.Get(config.Memcached.Prefix + "negative:" + username)
+	if err != nil {
+		c.checkError(err)
+		return NegativeNone
+	}
+	return parseNegativeReason(item.Value)
+}
+
+func (c *CacheMemcached) remove(username string) {
+	c.forgetHeld(username)
+	c.checkError(c.Client.Delete(config.Memcached.Prefix + username))
+	c.checkError(c.Client.Delete(config.Memcached.Prefix + "negative:" + username))
+}
+
+// Memcached can't list keys by prefix, so this flushes the servers entirely.
+func (c *CacheMemcached) flush() error {
+	c.mu.Lock()
+	c.held = nil
+	c.mu.Unlock()
+	return c.Client.DeleteAll()
+}
+
+func memcachedExpiration(ttl time.Duration) int32 {
+	if ttl.Seconds() > memcachedMaxTtl {
+		return memcachedMaxTtl
+	}
+	return int32(ttl.Seconds())
+}
+
+// Memcached doesn't tell us how many items or bytes are ours.
+func (c *CacheMemcached) size() uint {
+	return 0
+}
+
+func (c *CacheMemcached) memory() uint64 {
+	return 0
+}
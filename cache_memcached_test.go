@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minotar/minecraft"
+)
+
+// Just enough of memcached's text protocol for the cache: get, set, delete,
+// touch and flush_all. Touches are counted, as they put off an item's
+// expiry, and so are gets.
+type fakeMemcached struct {
+	mu      sync.Mutex
+	items   map[string][]byte
+	touches int
+	gets    int
+}
+
+func startFakeMemcached(t *testing.T) (*fakeMemcached, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	fake := &fakeMemcached{items: map[string][]byte{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go fake.serve(conn)
+		}
+	}()
+	return fake, listener.Addr().String()
+}
+
+func (f *fakeMemcached) serve(conn net.Conn) {
+	defer conn.Close()
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		f.mu.Lock()
+		switch fields[0] {
+		case "get", "gets":
+			f.gets++
+			for _, key := range fields[1:] {
+				if value, ok := f.items[key]; ok {
+					fmt.Fprintf(rw, "VALUE %s 0 %d 1\r\n%s\r\n", key, len(value), value)
+				}
+			}
+			rw.WriteString("END\r\n")
+		case "set":
+			size, _ := strconv.Atoi(fields[4])
+			value := make([]byte, size+2)
+			if _, err := io.ReadFull(rw, value); err != nil {
+				f.mu.Unlock()
+				return
+			}
+			f.items[fields[1]] = value[:size]
+			rw.WriteString("STORED\r\n")
+		case "delete":
+			if _, ok := f.items[fields[1]]; ok {
+				delete(f.items, fields[1])
+				rw.WriteString("DELETED\r\n")
+			} else {
+				rw.WriteString("NOT_FOUND\r\n")
+			}
+		case "touch":
+			f.touches++
+			if _, ok := f.items[fields[1]]; ok {
+				rw.WriteString("TOUCHED\r\n")
+			} else {
+				rw.WriteString("NOT_FOUND\r\n")
+			}
+		case "flush_all":
+			f.items = map[string][]byte{}
+			rw.WriteString("OK\r\n")
+		default:
+			rw.WriteString("ERROR\r\n")
+		}
+		f.mu.Unlock()
+		rw.Flush()
+	}
+}
+
+func setupMemcachedTest(t *testing.T) (*fakeMemcached, *CacheMemcached) {
+	stats = MakeStatsCollector()
+	fake, address := startFakeMemcached(t)
+	config = &Configuration{}
+	config.Memcached.Server = []string{address}
+	config.Memcached.Prefix = "skins:"
+
+	c := &CacheMemcached{}
+	if err := c.setup(); err != nil {
+		t.Fatal(err)
+	}
+	return fake, c
+}
+
+func TestCacheMemcached(t *testing.T) {
+	fake, c := setupMemcachedTest(t)
+
+	skin := minecraft.Skin{}
+	skin.Image = image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	skin.URL = "http://textures.minecraft.net/texture/3b60a1f6"
+	if c.has("clone1018") {
+		t.Fatal("Expected an empty cache")
+	}
+	c.add("clone1018", skin, time.Minute)
+	if !c.has("clone1018") {
+		t.Fatal("Expected the skin to be cached")
+	}
+	if pulled := c.pull("clone1018"); pulled.Image == nil || pulled.Image.Bounds().Dx() != 64 {
+		t.Fatal("Expected the skin to decode back")
+	} else if pulled.URL != skin.URL {
+		t.Fatalf("Expected the skin's URL to be kept, got %q", pulled.URL)
+	}
+	if fake.touches != 0 {
+		t.Fatalf("Expected has not to touch the item, it was touched %d times", fake.touches)
+	}
+
+	c.addNegative("clone1018", NegativeNotFound, time.Minute)
+	if c.pullNegative("clone1018") != NegativeNotFound {
+		t.Fatal("Expected the negative entry")
+	}
+	c.remove("clone1018")
+	if c.has("clone1018") || c.pullNegative("clone1018") != NegativeNone {
+		t.Fatal("Expected the skin and negative entry to be removed")
+	}
+}
+
+func TestCacheMemcachedHasHoldsForPull(t *testing.T) {
+	fake, c := setupMemcachedTest(t)
+
+	skin := minecraft.Skin{}
+	skin.Image = image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	c.add("clone1018", skin, time.Minute)
+	fake.gets = 0
+	if !c.has("clone1018") || c.pull("clone1018").Image == nil {
+		t.Fatal("Expected the skin")
+	}
+	if fake.gets != 1 {
+		t.Fatalf("Expected has and pull to share one get, got %d", fake.gets)
+	}
+
+	// What has fetched is only held for one pull, and not past a change.
+	c.pull("clone1018")
+	c.has("clone1018")
+	c.remove("clone1018")
+	if c.pull("clone1018").Source != fallbackSource {
+		t.Fatal("Expected the removed skin not to be pulled")
+	}
+	if fake.gets != 4 {
+		t.Fatalf("Expected pulls without a has first to get, got %d gets", fake.gets)
+	}
+
+	c.held["clone1018"] = memcachedHeld{Value: []byte("stale"), Expires: time.Now().Add(-time.Second)}
+	if _, held := c.takeHeld("clone1018"); held {
+		t.Fatal("Expected a stale value not to be used")
+	}
+}
+
+func TestCacheMemcachedFlush(t *testing.T) {
+	_, c := setupMemcachedTest(t)
+
+	skin := minecraft.Skin{}
+	skin.Image = image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	c.add("clone1018", skin, time.Minute)
+	if err := c.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if c.has("clone1018") {
+		t.Fatal("Expected the flush to drop the skin")
+	}
+}
+
+func TestMemcachedExpiration(t *testing.T) {
+	if exp := memcachedExpiration(time.Minute); exp != 60 {
+		t.Fatalf("Expected 60 seconds, got %d", exp)
+	}
+	if exp := memcachedExpiration(365 * 24 * time.Hour); exp != memcachedMaxTtl {
+		t.Fatalf("Expected a long TTL to be capped at %d, got %d", memcachedMaxTtl, exp)
+	}
+}
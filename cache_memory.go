@@ -1,108 +1,612 @@
 package main
 
 import (
+	"bytes"
+	"container/list"
+	"crypto/md5"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/minotar/minecraft"
 )
 
 const (
-	// Get the skin size in bytes. Stored as a []uint8, one byte each,
-	// plus bounces. So 64 * 64 bytes and we'll throw in an extra 16
-	// bytes of overhead.
-	skinSize = (64 * 64) + 16
+	// Bytes we count for each texture on top of its PNG.
+	textureOverhead = 64
 
-	// Define a 64 MB cache size.
-	cacheSize = 2 << 25
+	// Default to a 64 MB cache.
+	cacheSize = 64 << 20
 
-	// Based off those, calculate the maximum number of skins we'll store
-	// in memory.
-	skinCount = cacheSize / skinSize
+	// Default number of shards usernames are split over.
+	cacheShards = 64
 )
 
-// Cache object that stores skins in memory.
+// A skin texture shared by every username wearing it. Textures are kept PNG
+// encoded, which takes a fraction of the memory of the decoded pixels, and
+// are decoded each time they're pulled.
+type cachedTexture struct {
+	Source string
+	URL    string
+	Hash   string
+	PNG    []byte
+	// Bytes the texture takes up in memory.
+	Size uint64
+	// Number of usernames referencing this texture.
+	Refs uint
+}
+
+// A username in the cache and the texture they wear, or why they have none.
+type cachedUser struct {
+	Username string
+	Hash     string
+	Reason   NegativeReason
+	Added    time.Time
+	Expires  time.Time
+	// When the entry was last used, in Unix nanoseconds, so the least
+	// recently used can be found across shards.
+	Used int64
+	// Number of times the entry has been pulled.
+	Hits uint
+	// The texture worn, nil for negative entries.
+	texture *cachedTexture
+}
+
+// Cache object that stores skins in memory, evicting the least recently
+// used to stay within a byte budget and entry cap. Skins are stored once per
+// texture, so popular skins worn by many players only take up one slot.
+//
+// Usernames are split over shards, each with its own lock, so busy hit
+// paths don't queue up behind one another. Textures are shared by every
+// shard under a lock of their own, which is only taken to add or drop one.
 type CacheMemory struct {
-	// Map of usernames to minecraft skins. Lookups here are O(1), so that
-	// makes my happy.
-	Skins map[string]minecraft.Skin
-	// Additionally keep a *slice* of usernames which we can update
-	Usernames []string
-}
-
-// Find the position of a string in a slice. Returns -1 on failure.
-func indexOf(str string, list []string) int {
-	for index, value := range list {
-		if value == str {
-			return index
+	// Bytes the textures may take up, and the maximum number of usernames
+	// (0 for no cap).
+	MaxMem     uint64
+	MaxEntries uint
+
+	shards []*memoryShard
+
+	// Guards Textures. Taken while holding a shard's lock, never the other
+	// way round.
+	mu sync.Mutex
+	// Map of texture hashes to the textures themselves.
+	Textures map[string]*cachedTexture
+
+	// Kept as atomics, so we can tell we're over our limits without locking
+	// every shard.
+	bytes atomic.Uint64
+	count atomic.Int64
+	// Sum of the Unix times the usernames were added, for their average age.
+	addedSum atomic.Int64
+}
+
+// A share of the usernames, with its own lock and recency list.
+type memoryShard struct {
+	mu sync.Mutex
+	// Map of usernames to their element in the recency list.
+	Users map[string]*list.Element
+	// Usernames, most recently used at the front.
+	recency *list.List
+}
+
+func (s *memoryShard) reset() {
+	s.Users = map[string]*list.Element{}
+	s.recency = list.New()
+}
+
+// Returns the key we deduplicate the skin's texture under. This is the
+// texture hash, falling back to a hash of the pixels if we don't have one,
+// or of the skin as a PNG if they aren't NRGBA.
+func textureKey(skin minecraft.Skin) string {
+	if skin.Hash != "" {
+		return skin.Hash
+	}
+	if img, ok := skin.Image.(*image.NRGBA); ok {
+		return fmt.Sprintf("%x", md5.Sum(img.Pix))
+	}
+	if skin.Image == nil {
+		return ""
+	}
+	pngBuf := new(bytes.Buffer)
+	if err := png.Encode(pngBuf, skin.Image); err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", md5.Sum(pngBuf.Bytes()))
+}
+
+// PNG encodes the skin for storing.
+func encodeTexture(skin minecraft.Skin) (*cachedTexture, error) {
+	texture := &cachedTexture{Source: skin.Source, URL: skin.URL, Hash: skin.Hash}
+	if skin.Image != nil {
+		pngBuf := new(bytes.Buffer)
+		if err := png.Encode(pngBuf, skin.Image); err != nil {
+			return nil, err
 		}
+		texture.PNG = pngBuf.Bytes()
 	}
+	texture.Size = uint64(len(texture.PNG)) + textureOverhead
+	return texture, nil
+}
 
-	return -1
+// Decodes the stored texture back into a skin.
+func (t *cachedTexture) decode() (minecraft.Skin, error) {
+	skin := minecraft.Skin{}
+	if t.PNG != nil {
+		if err := skin.Decode(bytes.NewReader(t.PNG)); err != nil {
+			return skin, err
+		}
+	}
+	skin.Source = t.Source
+	skin.URL = t.URL
+	skin.Hash = t.Hash
+	return skin, nil
 }
 
 func (c *CacheMemory) setup() error {
-	c.Skins = map[string]minecraft.Skin{}
-	c.Usernames = []string{}
+	c.MaxMem = uint64(config.Server.CacheMaxMem) << 20
+	if c.MaxMem == 0 {
+		c.MaxMem = cacheSize
+	}
+	c.MaxEntries = uint(config.Server.CacheMaxEntries)
+	shards := config.Server.CacheShards
+	if shards <= 0 {
+		shards = cacheShards
+	}
+
+	c.shards = make([]*memoryShard, shards)
+	for i := range c.shards {
+		c.shards[i] = &memoryShard{}
+		c.shards[i].reset()
+	}
+	c.Textures = map[string]*cachedTexture{}
+	c.bytes.Store(0)
+	c.count.Store(0)
+	c.addedSum.Store(0)
 
-	log.Notice("Loaded Memory cache")
+	log.Noticef("Loaded Memory cache (max memory: %d bytes, max entries: %d, shards: %d)", c.MaxMem, c.MaxEntries, shards)
 	return nil
 }
 
+// Returns the shard the username is kept in. The FNV-1a hash is inlined so
+// the hit path doesn't allocate.
+func (c *CacheMemory) shard(username string) *memoryShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(username); i++ {
+		h ^= uint32(username[i])
+		h *= 16777619
+	}
+	return c.shards[h%uint32(len(c.shards))]
+}
+
+// Returns the username's element if they're cached and haven't expired.
+// Must be called with the shard's lock held.
+func (c *CacheMemory) lookup(s *memoryShard, username string) *list.Element {
+	elem, exists := s.Users[username]
+	if !exists {
+		return nil
+	}
+	if time.Now().After(elem.Value.(*cachedUser).Expires) {
+		c.unlink(s, elem)
+		countRemoved("memory", removedTTL, 1)
+		return nil
+	}
+	return elem
+}
+
+// Marks the entry as the most recently used. Must be called with the
+// shard's lock held.
+func (s *memoryShard) touch(elem *list.Element) *cachedUser {
+	s.recency.MoveToFront(elem)
+	user := elem.Value.(*cachedUser)
+	user.Used = time.Now().UnixNano()
+	user.Hits++
+	return user
+}
+
 // Returns whether the item exists in the cache.
 func (c *CacheMemory) has(username string) bool {
-	if _, exists := c.Skins[username]; exists {
-		return true
-	}
-	return false
+	s := c.shard(username)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem := c.lookup(s, username)
+	return elem != nil && elem.Value.(*cachedUser).Reason == NegativeNone
 }
 
-// Retrieves the item from the cache. We'll promote it to the "top" of the
-// cache, effectively updating its expiry time.
+// Retrieves the item from the cache, marking it as the most recently used.
 func (c *CacheMemory) pull(username string) minecraft.Skin {
-	index := indexOf(username, c.Usernames)
-	c.Usernames = append(c.Usernames, username)
-	c.Usernames = append(c.Usernames[:index], c.Usernames[index+1:]...)
+	s := c.shard(username)
+	s.mu.Lock()
+	elem := c.lookup(s, username)
+	if elem == nil || elem.Value.(*cachedUser).Reason != NegativeNone {
+		s.mu.Unlock()
+		return fallbackSkin()
+	}
+	// Textures are never modified once cached, so we can decode it without
+	// holding everyone else up.
+	texture := s.touch(elem).texture
+	s.mu.Unlock()
+
+	skin, err := texture.decode()
+	if err != nil {
+		log.Error(err.Error())
+		c.remove(username)
+		return fallbackSkin()
+	}
+	return skin
+}
 
-	return c.Skins[username]
+func (c *CacheMemory) expiresIn(username string) (time.Duration, bool) {
+	s := c.shard(username)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem := c.lookup(s, username)
+	if elem == nil || elem.Value.(*cachedUser).Reason != NegativeNone {
+		return 0, false
+	}
+	return time.Until(elem.Value.(*cachedUser).Expires), true
 }
 
 // Removes the username from the cache
 func (c *CacheMemory) remove(username string) {
-	index := indexOf(username, c.Usernames)
-	if index == -1 {
+	s := c.shard(username)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, exists := s.Users[username]; exists {
+		c.unlink(s, elem)
+		countRemoved("memory", removedPurge, 1)
+	}
+}
+
+// Drops everything, starting afresh.
+func (c *CacheMemory) flush() error {
+	for _, s := range c.shards {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	countRemoved("memory", removedPurge, uint(c.count.Load()))
+	countEvicted("memory", c.bytes.Load())
+	for _, s := range c.shards {
+		s.reset()
+	}
+	c.Textures = map[string]*cachedTexture{}
+	c.bytes.Store(0)
+	c.count.Store(0)
+	c.addedSum.Store(0)
+	return nil
+}
+
+// Drops the username from the cache, deleting their texture once nobody is
+// left wearing it. Must be called with the shard's lock held.
+func (c *CacheMemory) unlink(s *memoryShard, elem *list.Element) {
+	user := s.recency.Remove(elem).(*cachedUser)
+	delete(s.Users, user.Username)
+	c.count.Add(-1)
+	c.addedSum.Add(-user.Added.Unix())
+	if user.texture == nil {
 		return
 	}
 
-	key := c.Usernames[index]
-	delete(c.Skins, key)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	user.texture.Refs--
+	if user.texture.Refs == 0 {
+		delete(c.Textures, user.Hash)
+		c.bytes.Add(-user.texture.Size)
+		countEvicted("memory", user.texture.Size)
+	}
+}
+
+// Whether we're over either of our limits.
+func (c *CacheMemory) full() bool {
+	if c.MaxEntries > 0 && uint(c.count.Load()) > c.MaxEntries {
+		return true
+	}
+	return c.bytes.Load() > c.MaxMem
+}
+
+// Adds the skin to the cache, evicting the least recently used skins until
+// we're back within our limits.
+func (c *CacheMemory) add(username string, skin minecraft.Skin, ttl time.Duration) {
+	hash := textureKey(skin)
+
+	c.mu.Lock()
+	_, exists := c.Textures[hash]
+	c.mu.Unlock()
+
+	// Popular textures are likely already stored, so only encode new ones.
+	var texture *cachedTexture
+	if !exists {
+		var err error
+		if texture, err = encodeTexture(skin); err != nil {
+			log.Error(err.Error())
+			return
+		}
+	}
+	c.insert(username, hash, texture, ttl)
+}
+
+// Adds the user, wearing the texture stored under hash, then evicts until
+// we're back within our limits. If the texture isn't stored yet, it's stored
+// as the given texture.
+func (c *CacheMemory) insert(username string, hash string, texture *cachedTexture, ttl time.Duration) {
+	s := c.shard(username)
+	s.mu.Lock()
+	user := c.link(s, username, hash, texture, ttl)
+	s.mu.Unlock()
+
+	if user != nil {
+		c.evict(user)
+	}
 }
 
-// Adds the skin to the cache, remove the oldest, expired skin if the cache
-// list is full.
-func (c *CacheMemory) add(username string, skin minecraft.Skin) {
-	if len(c.Usernames) >= skinCount {
-		first := c.Usernames[0]
-		delete(c.Skins, first)
-		c.Usernames = append(c.Usernames[1:], username)
+// Adds the user to the shard, returning them, or nil if the texture was
+// evicted since we checked and we didn't encode it. Must be called with the
+// shard's lock held.
+func (c *CacheMemory) link(s *memoryShard, username string, hash string, texture *cachedTexture, ttl time.Duration) *cachedUser {
+	// Replacing an existing entry shouldn't leave a dangling reference.
+	if elem, exists := s.Users[username]; exists {
+		c.unlink(s, elem)
+	}
+
+	c.mu.Lock()
+	if existing, exists := c.Textures[hash]; exists {
+		existing.Refs++
+		texture = existing
+	} else if texture != nil {
+		texture.Refs = 1
+		c.Textures[hash] = texture
+		c.bytes.Add(texture.Size)
+		countAdmitted("memory", texture.Size)
 	} else {
-		c.Usernames = append(c.Usernames, username)
+		c.mu.Unlock()
+		return nil
 	}
+	c.mu.Unlock()
 
-	c.Skins[username] = skin
+	user := &cachedUser{
+		Username: username,
+		Hash:     hash,
+		Added:    time.Now(),
+		Expires:  time.Now().Add(ttl),
+		texture:  texture,
+	}
+	c.push(s, user)
+	return user
+}
 
-	// After the expiration time, remove the item from the cache.
-	time.AfterFunc(time.Duration(config.Server.Ttl)*time.Second, func() {
-		c.remove(username)
-	})
+// Evicts the least recently used entries until we're back within our
+// limits, never evicting the entry just added. Each round looks at the back
+// of every shard for the oldest, one lock at a time, so it's only as exact
+// as concurrent use allows.
+func (c *CacheMemory) evict(added *cachedUser) {
+	for c.full() {
+		var oldest *memoryShard
+		var used int64
+		for _, s := range c.shards {
+			s.mu.Lock()
+			if back := s.recency.Back(); back != nil && back.Value != added {
+				if user := back.Value.(*cachedUser); oldest == nil || user.Used < used {
+					oldest, used = s, user.Used
+				}
+			}
+			s.mu.Unlock()
+		}
+		if oldest == nil {
+			return
+		}
+
+		oldest.mu.Lock()
+		if back := oldest.recency.Back(); back != nil && back.Value != added && c.full() {
+			c.unlink(oldest, back)
+			countRemoved("memory", removedLRU, 1)
+		}
+		oldest.mu.Unlock()
+	}
+}
+
+// Adds the user as the most recently used. Must be called with the shard's
+// lock held.
+func (c *CacheMemory) push(s *memoryShard, user *cachedUser) {
+	user.Used = time.Now().UnixNano()
+	s.Users[user.Username] = s.recency.PushFront(user)
+	c.count.Add(1)
+	c.addedSum.Add(user.Added.Unix())
+}
+
+// Negative entries take up a slot towards MaxEntries, but no texture.
+func (c *CacheMemory) addNegative(username string, reason NegativeReason, ttl time.Duration) {
+	user := &cachedUser{
+		Username: username,
+		Reason:   reason,
+		Added:    time.Now(),
+		Expires:  time.Now().Add(ttl),
+	}
+
+	s := c.shard(username)
+	s.mu.Lock()
+	if elem, exists := s.Users[username]; exists {
+		c.unlink(s, elem)
+	}
+	c.push(s, user)
+	s.mu.Unlock()
+
+	c.evict(user)
+}
+
+func (c *CacheMemory) pullNegative(username string) NegativeReason {
+	s := c.shard(username)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem := c.lookup(s, username)
+	if elem == nil {
+		return NegativeNone
+	}
+	return s.touch(elem).Reason
+}
+
+// Drops usernames which have expired, and any textures nobody is left
+// wearing.
+func (c *CacheMemory) compact() (uint, error) {
+	var removed uint
+	now := time.Now()
+	for _, s := range c.shards {
+		s.mu.Lock()
+		for elem := s.recency.Front(); elem != nil; {
+			next := elem.Next()
+			if now.After(elem.Value.(*cachedUser).Expires) {
+				c.unlink(s, elem)
+				removed++
+			}
+			elem = next
+		}
+		s.mu.Unlock()
+	}
+	countRemoved("memory", removedTTL, removed)
+
+	return removed, nil
+}
+
+// Copies out the unexpired entries from every shard, least recently used
+// first.
+func (c *CacheMemory) unexpired() []cachedUser {
+	var users []cachedUser
+	now := time.Now()
+	for _, s := range c.shards {
+		s.mu.Lock()
+		for elem := s.recency.Front(); elem != nil; elem = elem.Next() {
+			if user := elem.Value.(*cachedUser); !now.After(user.Expires) {
+				users = append(users, *user)
+			}
+		}
+		s.mu.Unlock()
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Used < users[j].Used })
+	return users
+}
+
+// Lists the unexpired entries, most recently used first.
+func (c *CacheMemory) entries() []cacheEntry {
+	users := c.unexpired()
+	now := time.Now()
+	entries := make([]cacheEntry, 0, len(users))
+	for i := len(users) - 1; i >= 0; i-- {
+		user := users[i]
+		entry := cacheEntry{
+			Key:  user.Username,
+			Age:  int64(now.Sub(user.Added).Seconds()),
+			TTL:  int64(user.Expires.Sub(now).Seconds()),
+			Hits: user.Hits,
+		}
+		if user.Reason != NegativeNone {
+			entry.Reason = user.Reason.String()
+		} else {
+			entry.Size = user.texture.Size
+		}
+		entries = append(entries, entry)
+	}
+	return entries
 }
 
 // The exact number of usernames in the map
 func (c *CacheMemory) size() uint {
-	return uint(len(c.Skins))
+	return uint(c.count.Load())
 }
 
-// The byte size of the cache. Fairly rough... don't really want to venture
-// into the land of manual memory management, because there be dragons.
+// The bytes taken up by the cached textures, which we keep within MaxMem.
 func (c *CacheMemory) memory() uint64 {
-	return uint64(len(c.Skins) * skinSize)
+	return c.bytes.Load()
+}
+
+func (c *CacheMemory) averageAge() time.Duration {
+	count := c.count.Load()
+	if count <= 0 {
+		return 0
+	}
+	added := c.addedSum.Load() / count
+	return time.Since(time.Unix(added, 0))
+}
+
+func (c *CacheMemory) capacity() uint64 {
+	return c.MaxMem
+}
+
+// The bytes saved by sharing textures between usernames, compared to
+// storing a copy of the skin for each.
+func (c *CacheMemory) dedupSaved() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var saved uint64
+	for _, texture := range c.Textures {
+		saved += uint64(texture.Refs-1) * texture.Size
+	}
+	return saved
+}
+
+// Writes every unexpired entry out, oldest first.
+func (c *CacheMemory) snapshot(w io.Writer) error {
+	snapshot := &cacheSnapshot{Textures: map[string]snapshotTexture{}}
+
+	for _, user := range c.unexpired() {
+		snapshot.Users = append(snapshot.Users, snapshotUser{
+			Username: user.Username,
+			Hash:     user.Hash,
+			Reason:   user.Reason,
+			Expires:  user.Expires,
+		})
+		if texture := user.texture; texture != nil {
+			snapshot.Textures[user.Hash] = snapshotTexture{
+				Source: texture.Source,
+				URL:    texture.URL,
+				Hash:   texture.Hash,
+				PNG:    texture.PNG,
+			}
+		}
+	}
+
+	return encodeSnapshot(w, snapshot)
+}
+
+// Adds the entries from a snapshot, skipping any which have since expired.
+func (c *CacheMemory) restore(r io.Reader) error {
+	snapshot, err := decodeSnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range snapshot.Users {
+		ttl := time.Until(user.Expires)
+		if ttl <= 0 {
+			continue
+		}
+		if user.Reason != NegativeNone {
+			c.addNegative(user.Username, user.Reason, ttl)
+			continue
+		}
+		saved, exists := snapshot.Textures[user.Hash]
+		if !exists {
+			continue
+		}
+		c.insert(user.Username, user.Hash, &cachedTexture{
+			Source: saved.Source,
+			URL:    saved.URL,
+			Hash:   saved.Hash,
+			PNG:    saved.PNG,
+			Size:   uint64(len(saved.PNG)) + textureOverhead,
+		}, ttl)
+	}
+	return nil
 }
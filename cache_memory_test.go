@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minotar/minecraft"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCacheMemoryDedup(t *testing.T) {
+	c := &CacheMemory{}
+	c.setup()
+
+	skin := minecraft.Skin{}
+	skin.Hash = "popular"
+	c.add("alice", skin, time.Minute)
+	c.add("bob", skin, time.Minute)
+
+	if c.size() != 2 {
+		t.Fatalf("Expected 2 usernames, got %d", c.size())
+	}
+	if c.memory() != textureOverhead {
+		t.Fatalf("Expected one texture's worth of memory, got %d", c.memory())
+	}
+	if c.dedupSaved() != textureOverhead {
+		t.Fatalf("Expected one texture saved, got %d", c.dedupSaved())
+	}
+
+	c.remove("alice")
+	if !c.has("bob") || c.pull("bob").Hash != "popular" {
+		t.Fatal("Removing alice should leave bob's texture alone")
+	}
+	c.remove("bob")
+	if len(c.Textures) != 0 {
+		t.Fatalf("Expected the texture to be dropped, %d left", len(c.Textures))
+	}
+}
+
+func TestCacheMemoryEvictsLeastRecentlyUsed(t *testing.T) {
+	c := &CacheMemory{}
+	c.setup()
+	c.MaxMem = textureOverhead * 2
+	evictions := testutil.ToFloat64(evictionCounter.WithLabelValues("memory"))
+
+	for _, name := range []string{"alice", "bob"} {
+		skin := minecraft.Skin{}
+		skin.Hash = name
+		c.add(name, skin, time.Minute)
+	}
+	// Using alice makes bob the least recently used.
+	c.pull("alice")
+
+	skin := minecraft.Skin{}
+	skin.Hash = "carol"
+	c.add("carol", skin, time.Minute)
+
+	if c.has("bob") || !c.has("alice") || !c.has("carol") {
+		t.Fatal("Expected bob to be evicted")
+	}
+	if c.memory() != textureOverhead*2 {
+		t.Fatalf("Expected memory to stay within budget, got %d", c.memory())
+	}
+	if got := testutil.ToFloat64(evictionCounter.WithLabelValues("memory")) - evictions; got != 1 {
+		t.Fatalf("Expected one eviction to be counted, got %v", got)
+	}
+
+	c.MaxEntries = 1
+	skin.Hash = "dave"
+	c.add("dave", skin, time.Minute)
+	if c.size() != 1 || !c.has("dave") {
+		t.Fatalf("Expected only dave to be left, got %d entries", c.size())
+	}
+}
+
+func TestCacheMemoryNegative(t *testing.T) {
+	c := &CacheMemory{}
+	c.setup()
+
+	c.addNegative("nobody", NegativeNotFound, time.Minute)
+	if c.has("nobody") {
+		t.Fatal("A negative entry shouldn't count as a skin")
+	}
+	if reason := c.pullNegative("nobody"); reason != NegativeNotFound {
+		t.Fatalf("Expected not found, got %s", reason)
+	}
+	if c.memory() != 0 {
+		t.Fatalf("Negative entries shouldn't take up texture memory, got %d", c.memory())
+	}
+
+	skin := minecraft.Skin{}
+	skin.Hash = "found"
+	c.add("nobody", skin, time.Minute)
+	if !c.has("nobody") || c.pullNegative("nobody") != NegativeNone {
+		t.Fatal("Adding a skin should replace the negative entry")
+	}
+}
+
+func TestCacheMemoryStoresEncoded(t *testing.T) {
+	c := &CacheMemory{}
+	c.setup()
+
+	skin := minecraft.Skin{}
+	skin.Hash = "plain"
+	skin.Image = image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	c.add("clone1018", skin, time.Minute)
+
+	if c.memory() >= 64*64*4 {
+		t.Fatalf("Expected the texture to take less than its decoded size, got %d", c.memory())
+	}
+	pulled := c.pull("clone1018")
+	if pulled.Hash != "plain" || pulled.Image.Bounds().Dx() != 64 {
+		t.Fatal("Expected the texture to decode back to the skin")
+	}
+}
+
+func TestTextureKeyFallsBackToPNG(t *testing.T) {
+	skin := minecraft.Skin{}
+	skin.Image = image.NewRGBA(image.Rect(0, 0, 64, 64))
+	key := textureKey(skin)
+	if key == "" {
+		t.Fatal("Expected a key for a skin without a hash or NRGBA pixels")
+	}
+	skin.Image = image.NewRGBA(image.Rect(0, 0, 64, 32))
+	if textureKey(skin) == key {
+		t.Fatal("Expected different skins to get different keys")
+	}
+}
+
+func TestCacheMemoryRemovalReasons(t *testing.T) {
+	c := &CacheMemory{}
+	c.setup()
+	removals := func(reason string) float64 {
+		return testutil.ToFloat64(removalCounter.WithLabelValues("memory", reason))
+	}
+	ttl, purge := removals(removedTTL), removals(removedPurge)
+	evicted := testutil.ToFloat64(evictedBytesCounter.WithLabelValues("memory"))
+
+	skin := minecraft.Skin{}
+	skin.Hash = "alice"
+	c.add("alice", skin, time.Minute)
+	c.addNegative("bob", NegativeNotFound, -time.Minute)
+	c.remove("alice")
+	c.compact()
+
+	if got := removals(removedPurge) - purge; got != 1 {
+		t.Fatalf("Expected one purge, got %v", got)
+	}
+	if got := removals(removedTTL) - ttl; got != 1 {
+		t.Fatalf("Expected one expiry, got %v", got)
+	}
+	if got := testutil.ToFloat64(evictedBytesCounter.WithLabelValues("memory")) - evicted; got != textureOverhead {
+		t.Fatalf("Expected alice's texture to be freed, got %v bytes", got)
+	}
+}
+
+func TestCacheMemoryAverageAge(t *testing.T) {
+	c := &CacheMemory{}
+	c.setup()
+	if c.averageAge() != 0 {
+		t.Fatalf("Expected no age while empty, got %s", c.averageAge())
+	}
+
+	c.push(c.shard("alice"), &cachedUser{Username: "alice", Reason: NegativeNotFound, Added: time.Now().Add(-time.Hour), Expires: time.Now().Add(time.Minute)})
+	c.addNegative("bob", NegativeNotFound, time.Minute)
+	if age := c.averageAge(); age < 29*time.Minute || age > 31*time.Minute {
+		t.Fatalf("Expected about half an hour, got %s", age)
+	}
+
+	c.remove("alice")
+	if age := c.averageAge(); age > time.Minute {
+		t.Fatalf("Expected bob's age alone, got %s", age)
+	}
+}
+
+func TestCacheMemoryShards(t *testing.T) {
+	c := &CacheMemory{}
+	c.setup()
+	c.MaxEntries = 100
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				skin := minecraft.Skin{}
+				skin.Hash = fmt.Sprintf("texture%d", j%10)
+				name := fmt.Sprintf("player%d-%d", i, j)
+				c.add(name, skin, time.Minute)
+				c.pull(name)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if c.size() != 100 || len(c.entries()) != 100 {
+		t.Fatalf("Expected the cap to hold across shards, got %d entries", c.size())
+	}
+	used := 0
+	for _, s := range c.shards {
+		if len(s.Users) > 0 {
+			used++
+		}
+	}
+	if used < len(c.shards)/2 {
+		t.Fatalf("Expected usernames spread over the shards, only %d of %d used", used, len(c.shards))
+	}
+	if c.memory() > 10*textureOverhead {
+		t.Fatalf("Expected textures to be shared across shards, got %d bytes", c.memory())
+	}
+}
+
+// Checks many usernames from every core at once, as a busy server's hit path
+// does, with every username behind one lock and split over the default
+// number of shards.
+func BenchmarkCacheMemoryParallel(b *testing.B) {
+	defer func(shards int) { config.Server.CacheShards = shards }(config.Server.CacheShards)
+
+	for _, shards := range []int{1, cacheShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			config.Server.CacheShards = shards
+			c := &CacheMemory{}
+			c.setup()
+			names := make([]string, 1024)
+			for i := range names {
+				names[i] = fmt.Sprintf("player%d", i)
+				skin := minecraft.Skin{}
+				skin.Hash = names[i]
+				c.add(names[i], skin, time.Hour)
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					c.pullNegative(names[i%len(names)])
+				}
+			})
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"time"
+
 	"github.com/minotar/minecraft"
 )
 
@@ -18,11 +20,24 @@ func (c *CacheOff) has(username string) bool {
 
 // Should never be called.
 func (c *CacheOff) pull(username string) minecraft.Skin {
-	char, _ := minecraft.FetchSkinForSteve()
-	return char
+	return fallbackSkin()
+}
+
+func (c *CacheOff) add(username string, skin minecraft.Skin, ttl time.Duration) {
+}
+
+func (c *CacheOff) addNegative(username string, reason NegativeReason, ttl time.Duration) {
 }
 
-func (c *CacheOff) add(username string, skin minecraft.Skin) {
+func (c *CacheOff) pullNegative(username string) NegativeReason {
+	return NegativeNone
+}
+
+func (c *CacheOff) remove(username string) {
+}
+
+func (c *CacheOff) flush() error {
+	return nil
 }
 
 func (c *CacheOff) size() uint {
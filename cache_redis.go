@@ -1,11 +1,10 @@
 package main
 
 import (
-	"bytes"
 	"errors"
-	"image/png"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/fzzy/radix/extra/pool"
 	"github.com/fzzy/radix/redis"
@@ -27,7 +26,7 @@ func dialFunc(network, addr string) (*redis.Client, error) {
 		r := client.Cmd("AUTH", config.Redis.Auth)
 		if r.Err != nil {
 			client.Close()
-			return nil, err
+			return nil, r.Err
 		}
 	}
 
@@ -35,7 +34,7 @@ func dialFunc(network, addr string) (*redis.Client, error) {
 	r := client.Cmd("SELECT", config.Redis.DB)
 	if r.Err != nil {
 		client.Close()
-		return nil, err
+		return nil, r.Err
 	}
 
 	return client, nil
@@ -68,6 +67,17 @@ func (c *CacheRedis) getFromPool() *redis.Client {
 	return client
 }
 
+func (c *CacheRedis) ping() error {
+	client, err := c.Pool.Get()
+	if err != nil {
+		return err
+	}
+	defer c.Pool.CarefullyPut(client, &err)
+
+	err = client.Cmd("PING").Err
+	return err
+}
+
 func (c *CacheRedis) has(username string) bool {
 	var err error
 	client := c.getFromPool()
@@ -86,11 +96,31 @@ func (c *CacheRedis) has(username string) bool {
 	return exists
 }
 
+func (c *CacheRedis) expiresIn(username string) (time.Duration, bool) {
+	var err error
+	client := c.getFromPool()
+	if client == nil {
+		return 0, false
+	}
+	defer c.Pool.CarefullyPut(client, &err)
+
+	var ms int64
+	ms, err = client.Cmd("PTTL", config.Redis.Prefix+username).Int64()
+	if err != nil {
+		log.Error(err.Error())
+		return 0, false
+	}
+	// Negative if the key is missing or has no expiry.
+	if ms < 0 {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
 // What to do when failing to pull a skin from redis
 func (c *CacheRedis) pullFailed(username string) minecraft.Skin {
 	c.remove(username)
-	char, _ := minecraft.FetchSkinForSteve()
-	return char
+	return fallbackSkin()
 }
 
 func (c *CacheRedis) pull(username string) minecraft.Skin {
@@ -111,7 +141,7 @@ func (c *CacheRedis) pull(username string) minecraft.Skin {
 	return skin
 }
 
-func (c *CacheRedis) add(username string, skin minecraft.Skin) {
+func (c *CacheRedis) add(username string, skin minecraft.Skin, ttl time.Duration) {
 	var err error
 	client := c.getFromPool()
 	if client == nil {
@@ -119,11 +149,48 @@ func (c *CacheRedis) add(username string, skin minecraft.Skin) {
 	}
 	defer c.Pool.CarefullyPut(client, &err)
 
-	skinBuf := new(bytes.Buffer)
-	_ = png.Encode(skinBuf, skin.Image)
+	data, encodeErr := encodeSkinPNG(skin)
+	if encodeErr != nil {
+		log.Error(encodeErr.Error())
+		return
+	}
+
+	// read into err so that it's set for the defer
+	err = client.Cmd("SETEX", config.Redis.Prefix+username, strconv.Itoa(int(ttl.Seconds())), data).Err
+}
+
+// Negative entries live alongside the skins. Usernames can't contain a
+// colon, so they can't collide.
+func (c *CacheRedis) addNegative(username string, reason NegativeReason, ttl time.Duration) {
+	var err error
+	client := c.getFromPool()
+	if client == nil {
+		return
+	}
+	defer c.Pool.CarefullyPut(client, &err)
 
 	// read into err so that it's set for the defer
-	err = client.Cmd("SETEX", "skins:"+username, strconv.Itoa(config.Server.Ttl), skinBuf.Bytes()).Err
+	err = client.Cmd("SETEX", config.Redis.Prefix+"negative:"+username, strconv.Itoa(int(ttl.Seconds())), reason.bytes()).Err
+}
+
+func (c *CacheRedis) pullNegative(username string) NegativeReason {
+	var err error
+	client := c.getFromPool()
+	if client == nil {
+		return NegativeNone
+	}
+	defer c.Pool.CarefullyPut(client, &err)
+
+	resp := client.Cmd("GET", config.Redis.Prefix+"negative:"+username)
+	if resp.Type == redis.NilReply {
+		return NegativeNone
+	}
+	value, err := resp.Bytes()
+	if err != nil {
+		log.Error(err.Error())
+		return NegativeNone
+	}
+	return parseNegativeReason(value)
 }
 
 func (c *CacheRedis) remove(username string) {
@@ -135,7 +202,49 @@ func (c *CacheRedis) remove(username string) {
 	defer c.Pool.CarefullyPut(client, &err)
 
 	// read into err so that it's set for the defer
-	err = client.Cmd("DEL", config.Redis.Prefix+username).Err
+	err = client.Cmd("DEL", config.Redis.Prefix+username, config.Redis.Prefix+"negative:"+username).Err
+}
+
+// Deletes every key under our prefix. The database may be shared, so we
+// can't just FLUSHDB.
+func (c *CacheRedis) flush() error {
+	var err error
+	client := c.getFromPool()
+	if client == nil {
+		return errors.New("no redis connection available")
+	}
+	defer c.Pool.CarefullyPut(client, &err)
+
+	cursor := "0"
+	for {
+		resp := client.Cmd("SCAN", cursor, "MATCH", config.Redis.Prefix+"*", "COUNT", 1000)
+		if err = resp.Err; err != nil {
+			return err
+		}
+		if len(resp.Elems) != 2 {
+			err = errors.New("unexpected SCAN reply")
+			return err
+		}
+		if cursor, err = resp.Elems[0].Str(); err != nil {
+			return err
+		}
+		var keys []string
+		if keys, err = resp.Elems[1].List(); err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			args := make([]interface{}, len(keys))
+			for i, key := range keys {
+				args[i] = key
+			}
+			if err = client.Cmd("DEL", args...).Err; err != nil {
+				return err
+			}
+		}
+		if cursor == "0" {
+			return nil
+		}
+	}
 }
 
 func (c *CacheRedis) size() uint {
@@ -174,20 +283,11 @@ func (c *CacheRedis) memory() uint64 {
 }
 
 func getSkinFromReply(resp *redis.Reply) (minecraft.Skin, error) {
-	skin := &minecraft.Skin{}
 	respBytes, respErr := resp.Bytes()
 	if respErr != nil {
 		return minecraft.Skin{}, respErr
 	}
-
-	imgBuf := bytes.NewReader(respBytes)
-
-	skinErr := skin.Decode(imgBuf)
-	if skinErr != nil {
-		return minecraft.Skin{}, skinErr
-	}
-
-	return *skin, nil
+	return decodeSkinPNG(respBytes)
 }
 
 // Parses a reply from redis INFO into a nice map.
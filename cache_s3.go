@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minotar/minecraft"
+)
+
+const (
+	// Number of writes which may be waiting to go out to S3 before we start
+	// dropping them.
+	s3WriteQueue = 256
+	// Number of recently used skins we keep locally, so repeat pulls only
+	// cost a conditional GET.
+	s3LocalCount = 1024
+)
+
+// A skin we've recently read from or written to S3, with the ETag we can
+// revalidate it against, and when it expires. The ETag's blank until our
+// write of it lands.
+type s3LocalSkin struct {
+	ETag    string
+	Skin    minecraft.Skin
+	Expires time.Time
+}
+
+type s3Write struct {
+	Key         string
+	Data        []byte
+	ContentType string
+	Expires     time.Time
+}
+
+// Cache object that stores skins in an S3 compatible bucket, so a fleet of
+// instances can share one durable cache. Writes happen in the background so
+// S3 latency never holds up a response.
+type CacheS3 struct {
+	Client *minio.Client
+	Bucket string
+	Prefix string
+
+	mu    sync.Mutex
+	local map[string]s3LocalSkin
+	// Counts from the last time we listed the bucket.
+	count  uint
+	bytes  uint64
+	writes chan s3Write
+}
+
+func (c *CacheS3) setup() error {
+	client, err := minio.New(config.S3.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.S3.AccessKey, config.S3.SecretKey, ""),
+		Secure: !config.S3.Insecure,
+		Region: config.S3.Region,
+	})
+	if err != nil {
+		log.Error("Error connecting to S3")
+		return err
+	}
+
+	c.Client = client
+	c.Bucket = config.S3.Bucket
+	c.Prefix = config.S3.Prefix
+	c.local = map[string]s3LocalSkin{}
+	c.writes = make(chan s3Write, s3WriteQueue)
+
+	for i := 0; i < config.S3.Writers || i == 0; i++ {
+		go c.writer()
+	}
+
+	log.Noticef("Loaded S3 cache (endpoint: %s, bucket: %s, prefix: \"%s\")", config.S3.Endpoint, c.Bucket, c.Prefix)
+	return nil
+}
+
+func (c *CacheS3) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), msDuration(config.S3.Timeout))
+}
+
+// Whether an object has expired. We set Expires when writing, but listing
+// the bucket only gives us when it was written, so fall back to that.
+func (c *CacheS3) expired(info minio.ObjectInfo) bool {
+	if !info.Expires.IsZero() {
+		return time.Now().After(info.Expires)
+	}
+	return time.Since(info.LastModified) > config.skinTtl()
+}
+
+func (c *CacheS3) ping() error {
+	ctx, cancel := c.context()
+	defer cancel()
+
+	exists, err := c.Client.BucketExists(ctx, c.Bucket)
+	if err == nil && !exists {
+		err = fmt.Errorf("bucket %s doesn't exist", c.Bucket)
+	}
+	return err
+}
+
+func (c *CacheS3) has(username string) bool {
+	// A write still in the queue won't be in the bucket yet.
+	c.mu.Lock()
+	local, haveLocal := c.local[c.Prefix+username]
+	c.mu.Unlock()
+	if haveLocal && local.ETag == "" {
+		return time.Now().Before(local.Expires)
+	}
+
+	ctx, cancel := c.context()
+	defer cancel()
+
+	info, err := c.Client.StatObject(ctx, c.Bucket, c.Prefix+username, minio.StatObjectOptions{})
+	if err != nil {
+		c.checkError(err)
+		return false
+	}
+	return !c.expired(info)
+}
+
+func (c *CacheS3) pull(username string) minecraft.Skin {
+	key := c.Prefix + username
+
+	c.mu.Lock()
+	local, haveLocal := c.local[key]
+	c.mu.Unlock()
+
+	ctx, cancel := c.context()
+	defer cancel()
+
+	opts := minio.GetObjectOptions{}
+	if haveLocal && local.ETag != "" {
+		opts.SetMatchETagExcept(local.ETag)
+	}
+
+	skin, etag, expires, err := c.get(ctx, key, opts)
+	if err == nil {
+		c.remember(key, etag, skin, expires)
+		return skin
+	}
+	if haveLocal && time.Now().Before(local.Expires) && (local.ETag == "" || minio.ToErrorResponse(err).StatusCode == http.StatusNotModified) {
+		return local.Skin
+	}
+
+	c.checkError(err)
+	return fallbackSkin()
+}
+
+// Downloads and decodes the skin, returning it with its ETag and when it
+// expires.
+func (c *CacheS3) get(ctx context.Context, key string, opts minio.GetObjectOptions) (minecraft.Skin, string, time.Time, error) {
+	obj, err := c.Client.GetObject(ctx, c.Bucket, key, opts)
+	if err != nil {
+		return minecraft.Skin{}, "", time.Time{}, err
+	}
+	defer obj.Close()
+
+	info, err := obj.Stat()
+	if err != nil {
+		return minecraft.Skin{}, "", time.Time{}, err
+	}
+	data, err := ioutil.ReadAll(obj)
+	if err != nil {
+		return minecraft.Skin{}, "", time.Time{}, err
+	}
+
+	skin, err := decodeSkinPNG(data)
+	if err != nil {
+		return minecraft.Skin{}, "", time.Time{}, err
+	}
+	expires := info.Expires
+	if expires.IsZero() {
+		expires = info.LastModified.Add(config.skinTtl())
+	}
+	return skin, info.ETag, expires, nil
+}
+
+// Keeps a local copy of the skin to revalidate against.
+func (c *CacheS3) remember(key, etag string, skin minecraft.Skin, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.local) >= s3LocalCount {
+		// Not worth tracking recency for, just start afresh.
+		c.local = map[string]s3LocalSkin{}
+	}
+	c.local[key] = s3LocalSkin{ETag: etag, Skin: skin, Expires: expires}
+}
+
+// Forgets the local copy of a write which didn't land, so it isn't served
+// as if it had.
+func (c *CacheS3) forgetPending(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if local, exists := c.local[key]; exists && local.ETag == "" {
+		delete(c.local, key)
+	}
+}
+
+func (c *CacheS3) add(username string, skin minecraft.Skin, ttl time.Duration) {
+	data, err := encodeSkinPNG(skin)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	// Until the write lands, serve the skin from our local copy.
+	key := c.Prefix + username
+	expires := time.Now().Add(ttl)
+	c.remember(key, "", skin, expires)
+
+	c.queue(s3Write{Key: key, Data: data, ContentType: "image/png", Expires: expires})
+}
+
+// Queues the write for the background writers, dropping it if they're too
+// far behind.
+func (c *CacheS3) queue(write s3Write) {
+	select {
+	case c.writes <- write:
+	default:
+		log.Warning("S3 write queue full, dropping write")
+		stats.Errored(ErrCacheS3WriteDropped)
+		c.forgetPending(write.Key)
+	}
+}
+
+// Negative entries are stored under their own prefix, so they never get
+// decoded as a skin.
+func (c *CacheS3) negativeKey(username string) string {
+	return c.Prefix + "negative/" + username
+}
+
+func (c *CacheS3) addNegative(username string, reason NegativeReason, ttl time.Duration) {
+	c.queue(s3Write{Key: c.negativeKey(username), Data: reason.bytes(), ContentType: "text/plain", Expires: time.Now().Add(ttl)})
+}
+
+func (c *CacheS3) pullNegative(username string) NegativeReason {
+	ctx, cancel := c.context()
+	defer cancel()
+
+	obj, err := c.Client.GetObject(ctx, c.Bucket, c.negativeKey(username), minio.GetObjectOptions{})
+	if err != nil {
+		c.checkError(err)
+		return NegativeNone
+	}
+	defer obj.Close()
+
+	info, err := obj.Stat()
+	if err != nil {
+		c.checkError(err)
+		return NegativeNone
+	}
+	if c.expired(info) {
+		return NegativeNone
+	}
+	value, err := ioutil.ReadAll(obj)
+	if err != nil {
+		c.checkError(err)
+		return NegativeNone
+	}
+	return parseNegativeReason(value)
+}
+
+// Works through the queue of writes.
+func (c *CacheS3) writer() {
+	for write := range c.writes {
+		ctx, cancel := c.context()
+		info, err := c.Client.PutObject(ctx, c.Bucket, write.Key, bytes.NewReader(write.Data), int64(len(write.Data)),
+			minio.PutObjectOptions{ContentType: write.ContentType, Expires: write.Expires})
+		cancel()
+		if err != nil {
+			c.checkError(err)
+			c.forgetPending(write.Key)
+			continue
+		}
+
+		c.mu.Lock()
+		if local, exists := c.local[write.Key]; exists && local.ETag == "" {
+			local.ETag = info.ETag
+			c.local[write.Key] = local
+		}
+		c.mu.Unlock()
+	}
+}
+
+func (c *CacheS3) remove(username string) {
+	key := c.Prefix + username
+
+	c.mu.Lock()
+	delete(c.local, key)
+	c.mu.Unlock()
+
+	ctx, cancel := c.context()
+	defer cancel()
+	c.checkError(c.Client.RemoveObject(ctx, c.Bucket, key, minio.RemoveObjectOptions{}))
+	c.checkError(c.Client.RemoveObject(ctx, c.Bucket, c.negativeKey(username), minio.RemoveObjectOptions{}))
+}
+
+// Deletes every object under our prefix.
+func (c *CacheS3) flush() error {
+	c.mu.Lock()
+	c.local = map[string]s3LocalSkin{}
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for obj := range c.Client.ListObjects(ctx, c.Bucket, minio.ListObjectsOptions{Prefix: c.Prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		if err := c.Client.RemoveObject(ctx, c.Bucket, obj.Key, minio.RemoveObjectOptions{}); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	c.count = 0
+	c.bytes = 0
+	c.mu.Unlock()
+	return nil
+}
+
+// Logs errors other than the object simply not existing.
+func (c *CacheS3) checkError(err error) {
+	if err == nil {
+		return
+	}
+	resp := minio.ToErrorResponse(err)
+	if resp.StatusCode == http.StatusNotFound || resp.Code == "NoSuchKey" {
+		return
+	}
+	log.Error(err.Error())
+	stats.Errored(ErrCacheS3)
+}
+
+// Lists the bucket, deleting expired skins and counting the rest. S3 has
+// no expiry of its own that we can rely on across providers.
+func (c *CacheS3) compact() (uint, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var removed, count uint
+	var size uint64
+	for obj := range c.Client.ListObjects(ctx, c.Bucket, minio.ListObjectsOptions{Prefix: c.Prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return removed, obj.Err
+		}
+		if c.expired(obj) {
+			if c.Client.RemoveObject(ctx, c.Bucket, obj.Key, minio.RemoveObjectOptions{}) == nil {
+				removed++
+				continue
+			}
+		}
+		count++
+		size += uint64(obj.Size)
+	}
+
+	c.mu.Lock()
+	c.count = count
+	c.bytes = size
+	c.mu.Unlock()
+	countRemoved("s3", removedTTL, removed)
+
+	return removed, nil
+}
+
+// As of the last compaction, counting every object in the bucket is too
+// expensive to do on demand.
+func (c *CacheS3) size() uint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.count
+}
+
+func (c *CacheS3) memory() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.bytes
+}
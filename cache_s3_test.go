@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minotar/minecraft"
+)
+
+type fakeS3Object struct {
+	data     []byte
+	etag     string
+	expires  string
+	modified time.Time
+}
+
+// Just enough of S3 for the cache: buckets always exist, and objects can be
+// put, fetched, stat'ed and removed. Puts can be made to fail.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]fakeS3Object
+	failPut bool
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if len(parts) < 2 {
+		return
+	}
+	key := parts[1]
+	switch r.Method {
+	case "PUT":
+		if f.failPut {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<Error><Code>AccessDenied</Code><Message>denied</Message></Error>`)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		etag := fmt.Sprintf("%x", md5.Sum(data))
+		f.objects[key] = fakeS3Object{data: data, etag: etag, expires: r.Header.Get("Expires"), modified: time.Now()}
+		w.Header().Set("ETag", `"`+etag+`"`)
+	case "GET", "HEAD":
+		obj, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == "GET" {
+				fmt.Fprint(w, `<Error><Code>NoSuchKey</Code><Message>missing</Message></Error>`)
+			}
+			return
+		}
+		w.Header().Set("ETag", `"`+obj.etag+`"`)
+		w.Header().Set("Last-Modified", obj.modified.UTC().Format(http.TimeFormat))
+		if obj.expires != "" {
+			w.Header().Set("Expires", obj.expires)
+		}
+		if r.Header.Get("If-None-Match") == `"`+obj.etag+`"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(obj.data)))
+		w.Header().Set("Content-Type", "image/png")
+		if r.Method == "GET" {
+			w.Write(obj.data)
+		}
+	case "DELETE":
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Returns an S3 cache against a fake endpoint. Without writers, the queue
+// has no room, so every write is dropped.
+func startFakeS3(t *testing.T, writers bool) (*fakeS3, *CacheS3) {
+	fake := &fakeS3{objects: map[string]fakeS3Object{}}
+	server := httptest.NewTLSServer(fake)
+	t.Cleanup(server.Close)
+
+	client, err := minio.New(strings.TrimPrefix(server.URL, "https://"), &minio.Options{
+		Creds:     credentials.NewStaticV4("access", "secret", ""),
+		Secure:    true,
+		Region:    "us-east-1",
+		Transport: server.Client().Transport,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.S3.Timeout = 5000
+	cache := &CacheS3{Client: client, Bucket: "skins", Prefix: "imgd/", local: map[string]s3LocalSkin{}, writes: make(chan s3Write)}
+	if writers {
+		cache.writes = make(chan s3Write, s3WriteQueue)
+		go cache.writer()
+	}
+	return fake, cache
+}
+
+// Waits for the skin's write to land, or be given up on.
+func waitForS3Write(cache *CacheS3, key string) {
+	for i := 0; i < 100; i++ {
+		cache.mu.Lock()
+		local, exists := cache.local[cache.Prefix+key]
+		cache.mu.Unlock()
+		if !exists || local.ETag != "" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCacheS3(t *testing.T) {
+	stats = MakeStatsCollector()
+	config.Ttl.Skin = 60
+	fake, cache := startFakeS3(t, true)
+
+	skin, _ := minecraft.FetchSkinForSteve()
+	skin.Hash = "clone1018"
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+	waitForS3Write(cache, "d9135e082f2244c89cb10d21ed3ac8fd")
+	fake.mu.Lock()
+	_, exists := fake.objects["imgd/d9135e082f2244c89cb10d21ed3ac8fd"]
+	fake.mu.Unlock()
+	if !exists {
+		t.Fatal("Expected the skin to be written to the bucket")
+	}
+	if !cache.has("d9135e082f2244c89cb10d21ed3ac8fd") || cache.pull("d9135e082f2244c89cb10d21ed3ac8fd").Hash != "clone1018" {
+		t.Fatal("Expected the skin back")
+	}
+
+	// Once forgotten locally, it's fetched from the bucket.
+	cache.local = map[string]s3LocalSkin{}
+	if cache.pull("d9135e082f2244c89cb10d21ed3ac8fd").Hash != "clone1018" {
+		t.Fatal("Expected the skin back from the bucket")
+	}
+
+	cache.add("853c80ef3c3749fdaa49938b674adae6", skin, -time.Second)
+	if cache.has("853c80ef3c3749fdaa49938b674adae6") {
+		t.Fatal("Expected an expired skin not to be had while it's written")
+	}
+	waitForS3Write(cache, "853c80ef3c3749fdaa49938b674adae6")
+	if cache.has("853c80ef3c3749fdaa49938b674adae6") {
+		t.Fatal("Expected an expired skin not to be had")
+	}
+
+	cache.remove("d9135e082f2244c89cb10d21ed3ac8fd")
+	if cache.has("d9135e082f2244c89cb10d21ed3ac8fd") {
+		t.Fatal("Expected the skin to be removed")
+	}
+}
+
+func TestCacheS3ForgetsLostWrites(t *testing.T) {
+	stats = MakeStatsCollector()
+	config.Ttl.Skin = 60
+	skin, _ := minecraft.FetchSkinForSteve()
+
+	fake, cache := startFakeS3(t, true)
+	fake.failPut = true
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+	waitForS3Write(cache, "d9135e082f2244c89cb10d21ed3ac8fd")
+	if cache.has("d9135e082f2244c89cb10d21ed3ac8fd") {
+		t.Fatal("Expected a failed write not to be served from the local copy")
+	}
+
+	_, cache = startFakeS3(t, false)
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+	if cache.has("d9135e082f2244c89cb10d21ed3ac8fd") {
+		t.Fatal("Expected a dropped write not to be served from the local copy")
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/minotar/minecraft"
+)
+
+// Cache object that puts a fast in-memory cache in front of a slower, but
+// larger or shared, one. Skins found in the second tier are promoted into
+// the first.
+type CacheTiered struct {
+	L1 Cache
+	L2 Cache
+}
+
+func (c *CacheTiered) setup() error {
+	if config.Tiered.L2 == "tiered" {
+		return fmt.Errorf("the second tier can't itself be tiered")
+	}
+
+	c.L1 = &CacheMemory{}
+	c.L2 = MakeCache(config.Tiered.L2)
+	if err := c.L1.setup(); err != nil {
+		return err
+	}
+	if err := c.L2.setup(); err != nil {
+		return err
+	}
+
+	log.Noticef("Loaded Tiered cache (L2: %s)", config.Tiered.L2)
+	return nil
+}
+
+func (c *CacheTiered) ping() error {
+	if pingable, ok := c.L2.(pingableCache); ok {
+		return pingable.ping()
+	}
+	return nil
+}
+
+func (c *CacheTiered) has(username string) bool {
+	return c.L1.has(username) || c.L2.has(username)
+}
+
+func (c *CacheTiered) pull(username string) minecraft.Skin {
+	if c.L1.has(username) {
+		stats.HitCacheTier("L1")
+		return c.L1.pull(username)
+	}
+
+	// A miss in L2 pulls the fallback, which mustn't be promoted.
+	if !c.L2.has(username) {
+		return c.L2.pull(username)
+	}
+	stats.HitCacheTier("L2")
+	skin := c.L2.pull(username)
+	// Don't let the copy in L1 outlive the one in L2.
+	ttl := config.skinTtl()
+	if aging, ok := c.L2.(agingCache); ok {
+		if remaining, ok := aging.expiresIn(username); ok {
+			ttl = remaining
+		}
+	}
+	c.L1.add(username, skin, ttl)
+	return skin
+}
+
+func (c *CacheTiered) expiresIn(username string) (time.Duration, bool) {
+	if remaining, ok := c.L1.(agingCache).expiresIn(username); ok {
+		return remaining, true
+	}
+	if aging, ok := c.L2.(agingCache); ok {
+		return aging.expiresIn(username)
+	}
+	return 0, false
+}
+
+func (c *CacheTiered) add(username string, skin minecraft.Skin, ttl time.Duration) {
+	c.L1.add(username, skin, ttl)
+	c.L2.add(username, skin, ttl)
+}
+
+func (c *CacheTiered) addNegative(username string, reason NegativeReason, ttl time.Duration) {
+	c.L1.addNegative(username, reason, ttl)
+	c.L2.addNegative(username, reason, ttl)
+}
+
+func (c *CacheTiered) pullNegative(username string) NegativeReason {
+	if reason := c.L1.pullNegative(username); reason != NegativeNone {
+		return reason
+	}
+	return c.L2.pullNegative(username)
+}
+
+func (c *CacheTiered) remove(username string) {
+	c.L1.remove(username)
+	c.L2.remove(username)
+}
+
+func (c *CacheTiered) flush() error {
+	if err := c.L1.flush(); err != nil {
+		return err
+	}
+	return c.L2.flush()
+}
+
+// Only the first tier is listed, the second may well be too big to.
+func (c *CacheTiered) entries() []cacheEntry {
+	return c.L1.(inspectableCache).entries()
+}
+
+// Only the first tier is snapshotted, the second is expected to survive a
+// restart by itself.
+func (c *CacheTiered) snapshot(w io.Writer) error {
+	return c.L1.(snapshottingCache).snapshot(w)
+}
+
+func (c *CacheTiered) restore(r io.Reader) error {
+	return c.L1.(snapshottingCache).restore(r)
+}
+
+// Compacts both tiers.
+func (c *CacheTiered) compact() (uint, error) {
+	var removed uint
+	for _, tier := range []Cache{c.L1, c.L2} {
+		if compactor, ok := tier.(compactingCache); ok {
+			tierRemoved, err := compactor.compact()
+			removed += tierRemoved
+			if err != nil {
+				return removed, err
+			}
+		}
+	}
+	return removed, nil
+}
+
+// The second tier holds everything the first does, and more.
+func (c *CacheTiered) size() uint {
+	return c.L2.size()
+}
+
+func (c *CacheTiered) memory() uint64 {
+	return c.L1.memory() + c.L2.memory()
+}
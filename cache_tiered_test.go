@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/minotar/minecraft"
+)
+
+func makeTestTiers() *CacheTiered {
+	c := &CacheTiered{L1: &CacheMemory{}, L2: &CacheMemory{}}
+	c.L1.setup()
+	c.L2.setup()
+	return c
+}
+
+func TestCacheTieredPromotes(t *testing.T) {
+	stats = MakeStatsCollector()
+	c := makeTestTiers()
+
+	skin := minecraft.Skin{}
+	skin.Hash = "clone1018"
+	c.L2.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+	if !c.has("d9135e082f2244c89cb10d21ed3ac8fd") || c.pull("d9135e082f2244c89cb10d21ed3ac8fd").Hash != "clone1018" {
+		t.Fatal("Expected the skin from L2")
+	}
+	if !c.L1.has("d9135e082f2244c89cb10d21ed3ac8fd") {
+		t.Fatal("Expected the skin to be promoted into L1")
+	}
+	// L1's copy mustn't outlive L2's.
+	if remaining, _ := c.L1.(agingCache).expiresIn("d9135e082f2244c89cb10d21ed3ac8fd"); remaining > time.Minute {
+		t.Fatalf("Expected L1's copy to expire with L2's, got %s", remaining)
+	}
+
+	c.pull("d9135e082f2244c89cb10d21ed3ac8fd")
+	if hits := stats.tierHits.snapshot(); hits["L1"] != 1 || hits["L2"] != 1 {
+		t.Fatalf("Expected a hit in each tier, got %v", hits)
+	}
+}
+
+func TestCacheTieredMiss(t *testing.T) {
+	stats = MakeStatsCollector()
+	c := makeTestTiers()
+
+	if c.has("853c80ef3c3749fdaa49938b674adae6") {
+		t.Fatal("Expected an empty cache not to have the skin")
+	}
+	c.pull("853c80ef3c3749fdaa49938b674adae6")
+	if c.L1.has("853c80ef3c3749fdaa49938b674adae6") {
+		t.Fatal("Expected a miss not to be promoted into L1")
+	}
+	if hits := stats.tierHits.snapshot(); len(hits) != 0 {
+		t.Fatalf("Expected a miss not to count as a hit, got %v", hits)
+	}
+}
+
+func TestCacheTieredNegative(t *testing.T) {
+	c := makeTestTiers()
+
+	c.L2.addNegative("nobody", NegativeNotFound, time.Minute)
+	if c.pullNegative("nobody") != NegativeNotFound {
+		t.Fatal("Expected L2's negative entry")
+	}
+	c.remove("nobody")
+	if c.pullNegative("nobody") != NegativeNone {
+		t.Fatal("Expected the negative entry to be removed from both tiers")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// The classes of route which get their own caching headers.
+const (
+	CacheClassRender = "render"
+	CacheClassSkin   = "skin"
+	CacheClassStatus = "status"
+	// Renders whose URL names the texture they're drawn from, so they can
+	// never change.
+	CacheClassImmutable = "immutable"
+)
+
+// A year, the longest caches are asked to keep anything for.
+const immutableMaxAge = 31536000
+
+// How long browsers and CDNs may cache a class of route for, in seconds.
+type CacheControl struct {
+	MaxAge int
+	// How long shared caches, eg. CDNs, may cache for, 0 for MaxAge.
+	SMaxAge int
+	// How long past expiry a cache may serve the response while it
+	// revalidates, or while we're erroring. 0 to leave out.
+	StaleWhileRevalidate int
+	StaleIfError         int
+	// Tells browsers not to revalidate it even on reload.
+	Immutable bool
+}
+
+// Returns the configured caching for the class. Renders and skins default
+// to the server TTL, and status routes to no caching headers at all.
+func cacheControlFor(class string) *CacheControl {
+	if c, exists := config.CacheControl[class]; exists && c != nil {
+		return c
+	}
+	if class == CacheClassStatus {
+		return nil
+	}
+	if class == CacheClassImmutable {
+		return &CacheControl{MaxAge: immutableMaxAge, Immutable: true}
+	}
+	return &CacheControl{MaxAge: config.Server.Ttl}
+}
+
+func (c *CacheControl) header() string {
+	directives := []string{"public", fmt.Sprintf("max-age=%d", c.MaxAge)}
+	if c.SMaxAge > 0 {
+		directives = append(directives, fmt.Sprintf("s-maxage=%d", c.SMaxAge))
+	}
+	if c.StaleWhileRevalidate > 0 {
+		directives = append(directives, fmt.Sprintf("stale-while-revalidate=%d", c.StaleWhileRevalidate))
+	}
+	if c.StaleIfError > 0 {
+		directives = append(directives, fmt.Sprintf("stale-if-error=%d", c.StaleIfError))
+	}
+	if c.Immutable {
+		directives = append(directives, "immutable")
+	}
+	return strings.Join(directives, ", ")
+}
+
+// Sets Cache-Control, and Expires for older caches, for the class of route,
+// along with any extra headers configured for it.
+func setCacheHeaders(w http.ResponseWriter, r *http.Request, class string) {
+	if class == CacheClassImmutable {
+		// They're renders all the same.
+		setExtraHeaders(w, r, CacheClassRender)
+	} else {
+		setExtraHeaders(w, r, class)
+	}
+	c := cacheControlFor(class)
+	if c == nil {
+		return
+	}
+	w.Header().Set("Cache-Control", c.header())
+	expires := time.Now().Add(time.Duration(c.MaxAge) * time.Second)
+	w.Header().Set("Expires", expires.UTC().Format(http.TimeFormat))
+}
+
+// The class of render the request is for: immutable if its URL names the
+// texture it's drawn from, which it's been checked to match, as a new skin
+// gets a new URL.
+func renderCacheClass(r *http.Request) string {
+	if _, pinned := mux.Vars(r)["texturehash"]; pinned {
+		return CacheClassImmutable
+	}
+	return CacheClassRender
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/minecraft"
+	"gopkg.in/gcfg.v1"
+)
+
+func TestCacheControlConfig(t *testing.T) {
+	parsed := Configuration{}
+	if err := gcfg.ReadFileInto(&parsed, "config.example.gcfg"); err != nil {
+		t.Fatal(err)
+	}
+	if render := parsed.CacheControl[CacheClassRender]; render == nil || render.StaleWhileRevalidate != 86400 {
+		t.Fatalf("Expected the render caching to be configured, got %+v", render)
+	}
+}
+
+func TestSetCacheHeaders(t *testing.T) {
+	saved := config.CacheControl
+	defer func() { config.CacheControl = saved }()
+	config.Server.Ttl = 60
+	config.CacheControl = map[string]*CacheControl{
+		CacheClassRender: {MaxAge: 300, SMaxAge: 3600, StaleWhileRevalidate: 30},
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	setCacheHeaders(w, r, CacheClassRender)
+	if w.Header().Get("Cache-Control") != "public, max-age=300, s-maxage=3600, stale-while-revalidate=30" || w.Header().Get("Expires") == "" {
+		t.Fatalf("Expected the configured headers, got %v", w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	setCacheHeaders(w, r, CacheClassSkin)
+	if w.Header().Get("Cache-Control") != "public, max-age=60" {
+		t.Fatalf("Expected skins to default to the server ttl, got %s", w.Header().Get("Cache-Control"))
+	}
+
+	w = httptest.NewRecorder()
+	setCacheHeaders(w, r, CacheClassStatus)
+	if w.Header().Get("Cache-Control") != "" {
+		t.Fatal("Expected no caching headers for status by default")
+	}
+}
+
+func TestPinnedRenders(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	renderCache = MakeRenderCache(0, false)
+	hotCache = MakeHotCache(nil, 0)
+	missingFilter = MakeMissingFilter(0, time.Minute)
+	defer func(stale int) { config.Ttl.Stale = stale }(config.Ttl.Stale)
+	config.Ttl.Stale = 0
+	saved := config.CacheControl
+	defer func() { config.CacheControl = saved }()
+	config.CacheControl = map[string]*CacheControl{}
+
+	hash := strings.Repeat("ab", 32)
+	skin, _ := minecraft.FetchSkinForSteve()
+	skin.Hash = hash
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.Mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := serve("/avatar/d9135e082f2244c89cb10d21ed3ac8fd/32/" + hash + ".png")
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "public, max-age=31536000, immutable" {
+		t.Fatalf("Expected the render to be cached forever, got %d %v", w.Code, w.Header())
+	}
+
+	w = serve("/avatar/d9135e082f2244c89cb10d21ed3ac8fd/32/" + strings.Repeat("0", 32) + ".png?overlay")
+	expected := "/avatar/d9135e082f2244c89cb10d21ed3ac8fd/32/" + hash + ".png?overlay"
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != expected {
+		t.Fatalf("Expected a redirect to the current texture, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	if strings.Contains(w.Header().Get("Cache-Control"), "immutable") {
+		t.Fatalf("Expected the redirect to be cached as a render, got %q", w.Header().Get("Cache-Control"))
+	}
+
+	// A skin with just its URL kept is pinned to the hash the URL ends in.
+	skin.Hash = ""
+	skin.URL = "http://textures.minecraft.net/texture/" + strings.Repeat("cd", 32)
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+	if w = serve("/avatar/d9135e082f2244c89cb10d21ed3ac8fd/32/" + strings.Repeat("cd", 32) + ".png"); w.Code != http.StatusOK {
+		t.Fatalf("Expected the render pinned to the URL's hash, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/imgd/pkg/mcskin"
+	"github.com/minotar/minecraft"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The cape providers built in. Any others are [capeprovider] sections.
+const (
+	CapeProviderMojang   = "mojang"
+	CapeProviderOptiFine = "optifine"
+)
+
+// Where OptiFine serves its capes, by username.
+var optiFineCapeURL = "http://s.optifine.net/capes/{username}.png"
+
+// A third-party cape provider, configured as a [capeprovider "<name>"]
+// section.
+type CapeProvider struct {
+	// URL of a player's cape, with {username} and {uuid} filled in. The
+	// provider answers 404 for players without one.
+	URL string
+}
+
+// Capes are cached alongside skins, under their own keys.
+func capeCacheKey(uuid string) string {
+	return "cape:" + uuid
+}
+
+// Returns an error if a cape provider in the list is unknown.
+func checkCapeProviders(providers []string, custom map[string]*CapeProvider) error {
+	for _, name := range providers {
+		name = strings.ToLower(name)
+		if name == CapeProviderMojang || name == CapeProviderOptiFine {
+			continue
+		}
+		if provider := custom[name]; provider == nil || provider.URL == "" {
+			return fmt.Errorf("unknown cape provider %q", name)
+		}
+	}
+	return nil
+}
+
+// Returns where the provider has the player's cape, "" if it has none. Only
+// Mojang says so up front; the others are asked for the cape and answer 404.
+func capeURLFrom(provider string, profile Profile) string {
+	template := optiFineCapeURL
+	switch strings.ToLower(provider) {
+	case CapeProviderMojang:
+		return profile.CapeURL
+	case CapeProviderOptiFine:
+	default:
+		custom := config.CapeProvider[strings.ToLower(provider)]
+		if custom == nil {
+			return ""
+		}
+		template = custom.URL
+	}
+	return strings.NewReplacer("{username}", profile.Name, "{uuid}", profile.UUID).Replace(template)
+}
+
+// Fetches the player's cape from the first of the providers in [cape]
+// which has one for them. Returns why if none of them do, or we couldn't
+// ask.
+func fetchCape(ctx context.Context, player string) (minecraft.Skin, NegativeReason) {
+	uuid, reason := resolveUUID(ctx, player)
+	if reason != NegativeNone {
+		return minecraft.Skin{}, reason
+	}
+
+	key := capeCacheKey(uuid)
+	if cache.has(key) {
+		stats.HitCache()
+		pullTimer := prometheus.NewTimer(cacheDuration.WithLabelValues("pull"))
+		defer pullTimer.ObserveDuration()
+		return cache.pull(key), NegativeNone
+	}
+	if reason := cache.pullNegative(key); reason != NegativeNone {
+		stats.HitCache()
+		return minecraft.Skin{}, reason
+	}
+	stats.MissCache()
+
+	profile, reason := fetchProfileForUUID(ctx, player, uuid)
+	if reason != NegativeNone {
+		return minecraft.Skin{}, reason
+	}
+	if profile.Name == "" && !isUUID(strings.Replace(player, "-", "", -1)) {
+		profile.Name = player
+	}
+	profile.UUID = uuid
+
+	reason = NegativeNotFound
+	for _, provider := range config.Cape.Provider {
+		url := capeURLFrom(provider, profile)
+		if url == "" {
+			continue
+		}
+		result, err := coalesce("Cape", url, func() (interface{}, error) {
+			return fetchTexture(ctx, url)
+		})
+		if err != nil {
+			if !strings.HasSuffix(err.Error(), "user not found") {
+				log.Infof("Failed cape fetch: %s from %s (%s)", player, provider, err.Error())
+				stats.Errored(ErrCape)
+				reason = NegativeAPIError
+			}
+			continue
+		}
+
+		cape := result.(minecraft.Skin)
+		cape.Source = strings.ToLower(provider)
+		cache.add(key, cape, config.skinTtl())
+		return cape, NegativeNone
+	}
+
+	// Only remember they've none for long if every provider said so.
+	ttl := config.failedTtl()
+	if reason == NegativeAPIError {
+		ttl = config.errorTtl()
+	}
+	cache.addNegative(key, reason, ttl)
+	return minecraft.Skin{}, reason
+}
+
+// Fetches the player's Mojang cape alongside their skin, if [cape] says to,
+// so a request for it finds it cached. Only done when Mojang's the first
+// provider, as otherwise theirs may not be the cape we'd serve.
+func warmCape(ctx context.Context, uuid string, profile Profile) {
+	providers := config.Cape.Provider
+	if !config.Cape.WithSkin || profile.CapeURL == "" || len(providers) == 0 || strings.ToLower(providers[0]) != CapeProviderMojang {
+		return
+	}
+	key := capeCacheKey(uuid)
+	if cache.has(key) {
+		return
+	}
+
+	stage(ctx, "cape", func() {
+		result, err := coalesce("Cape", profile.CapeURL, func() (interface{}, error) {
+			return fetchTexture(ctx, profile.CapeURL)
+		})
+		if err != nil {
+			log.Debugf("Failed cape warm: %s (%s)", uuid, err.Error())
+			return
+		}
+		cape := result.(minecraft.Skin)
+		cape.Source = CapeProviderMojang
+		cache.add(key, cape, config.skinTtl())
+	})
+}
+
+// CapePage shows the player's cape, from the first provider with one, or
+// 404s if they've none.
+func (router *Router) CapePage(w http.ResponseWriter, r *http.Request) {
+	stats.Requested("Cape")
+	username := mux.Vars(r)["username"]
+	cape, reason := fetchCape(requestContext(r), username)
+	if reason != NegativeNone {
+		NotFoundHandler{}.ServeHTTP(w, r)
+		return
+	}
+
+	// Versioned like renders, as the renderer encodes the cape we send.
+	etag := quoteETag(fmt.Sprintf("%s-v%d", textureKey(cape), mcskin.Version))
+	if writeNotModified(w, r, etag, CacheClassSkin) {
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s-cape.png\"", username))
+	if cape.Source != "" {
+		w.Header().Set("X-Cape-Source", cape.Source)
+	}
+	setCacheHeaders(w, r, CacheClassSkin)
+	w.Header().Add("ETag", etag)
+	w.Header().Add("Content-Type", "image/png")
+	writeSkin(w, r, &mcSkin{Render: mcskin.Render{Skin: cape}})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/minecraft"
+)
+
+func TestCheckCapeProviders(t *testing.T) {
+	custom := map[string]*CapeProvider{"example": {URL: "https://capes.example.com/{uuid}.png"}}
+	if err := checkCapeProviders([]string{"mojang", "OptiFine", "example"}, custom); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkCapeProviders([]string{"mojang", "labymod"}, custom); err == nil {
+		t.Fatal("Expected an unknown provider to be refused")
+	}
+}
+
+func TestCapePage(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/capes/clone1018.png" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(encodeTestSkin(64, 32))
+	}))
+	defer server.Close()
+	mcClient = &minecraft.Minecraft{Client: server.Client()}
+
+	defer func(url string, providers []string) {
+		optiFineCapeURL = url
+		config.Cape.Provider = providers
+	}(optiFineCapeURL, config.Cape.Provider)
+	optiFineCapeURL = server.URL + "/capes/{username}.png"
+	config.Cape.Provider = []string{"mojang", "optifine"}
+	config.Ttl.Failed = 60
+
+	profileCache.add("d9135e082f2244c89cb10d21ed3ac8fd", Profile{UUID: "d9135e082f2244c89cb10d21ed3ac8fd", Name: "clone1018"}, time.Minute)
+	profileCache.add("2f3665cc5e29439bbd14cb6d3a6313a7", Profile{UUID: "2f3665cc5e29439bbd14cb6d3a6313a7", Name: "lukegb"}, time.Minute)
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.Mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := serve("/cape/d9135e082f2244c89cb10d21ed3ac8fd.png")
+	if w.Code != http.StatusOK || w.Header().Get("X-Cape-Source") != "optifine" {
+		t.Fatalf("Expected OptiFine's cape when Mojang has none, got %d %v", w.Code, w.Header())
+	}
+	if img, err := png.Decode(w.Body); err != nil || img.Bounds().Dx() != 64 {
+		t.Fatalf("Expected a 64px wide cape (%v)", err)
+	}
+
+	if w := serve("/cape/2f3665cc5e29439bbd14cb6d3a6313a7"); w.Code != http.StatusNotFound {
+		t.Fatalf("Expected a 404 when no provider has a cape, got %d", w.Code)
+	}
+	if reason := cache.pullNegative(capeCacheKey("2f3665cc5e29439bbd14cb6d3a6313a7")); reason != NegativeNotFound {
+		t.Fatalf("Expected the missing cape to be remembered, got %v", reason)
+	}
+}
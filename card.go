@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"image/color"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/imgd/pkg/mcskin"
+)
+
+// The colours cards are drawn in, from the [card] config.
+var cardStyle mcskin.CardStyle
+
+// Parses an RGB colour as hex, eg. "5fb65f", with or without a leading #.
+func parseHexColor(s string) (color.NRGBA, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(s), "#"))
+	if err != nil || len(b) != 3 {
+		return color.NRGBA{}, fmt.Errorf("%q isn't an RGB hex colour", s)
+	}
+	return color.NRGBA{b[0], b[1], b[2], 0xFF}, nil
+}
+
+// Parses the card's colours, naming the first which isn't a colour.
+func parseCardStyle(background, gradient, text, accent string) (mcskin.CardStyle, error) {
+	style := mcskin.CardStyle{}
+	for _, c := range []struct {
+		name  string
+		value string
+		dst   *color.NRGBA
+	}{
+		{"background", background, &style.Background},
+		{"gradient", gradient, &style.Gradient},
+		{"text", text, &style.Text},
+		{"accent", accent, &style.Accent},
+	} {
+		parsed, err := parseHexColor(c.value)
+		if err != nil {
+			return style, fmt.Errorf("%s: %v", c.name, err)
+		}
+		*c.dst = parsed
+	}
+	return style, nil
+}
+
+// Returns the name to put on the player's card: the one asked for with
+// ?name=, else their username, if they were asked for by UUID and we know
+// it.
+func cardName(r *http.Request, player string) string {
+	if name := r.URL.Query().Get("name"); name != "" {
+		return name
+	}
+	if uuid, ok := normalizeUUID(player); ok {
+		if profile, ok := profileCache.get(uuid); ok && profile.Name != "" {
+			return profile.Name
+		}
+	}
+	return player
+}
+
+// Draws the card and encodes it, waiting its turn on the render pool.
+func (router *Router) renderCard(ctx context.Context, name string, ext string, skin *mcSkin) ([]byte, error) {
+	var data []byte
+	var err error
+	draw := func() {
+		if err = skin.GetCard(name, cardStyle); err != nil {
+			return
+		}
+		defer skin.Release()
+		data, err = router.encodeType(ext, skin)
+	}
+	if renderPool == nil {
+		draw()
+		return data, err
+	}
+	if poolErr := renderPool.do(ctx, draw); poolErr != nil {
+		return nil, poolErr
+	}
+	return data, err
+}
+
+// CardPage draws the player's card for link previews on Discord, Twitter
+// and the like: their body and name on a background, as a PNG, or WebP if
+// asked for.
+func (router *Router) CardPage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	ext := vars["extension"]
+	if ext == "" {
+		// Not every embed reader takes WebP.
+		ext = ".png"
+	}
+	player := vars["username"]
+	skin := fetchSkinForRequest(r, player, true)
+	if r.Context().Err() == context.Canceled {
+		return
+	}
+	record := accessRecordFor(r)
+	record.noteSkin(skin)
+	stats.Requested("Card")
+
+	name := cardName(r, player)
+	skin.Mode = "Normal"
+	skin.Options = router.GetRenderOptions(r)
+	// The card has its own name on, and its size is fixed.
+	skin.Options.Label = ""
+	skin.Options.Trim = false
+	skin.Options.Background = mcskin.Background{}
+
+	key := fmt.Sprintf("%s|%s|%v", renderKey("Card", mcskin.CardWidth, ext, skin), name, cardStyle)
+	etag := renderETag(key, skin)
+	if writeNotModified(w, r, etag, CacheClassRender) {
+		return
+	}
+	if data, ok := renderCache.get(key); ok {
+		stats.HitRenderCache()
+		record.Cache = "hit"
+		router.writeType(ext, etag, data, w, r)
+		return
+	}
+	if renderCache.enabled() {
+		stats.MissRenderCache()
+		record.Cache = "miss"
+	}
+	data, err := router.renderCard(r.Context(), name, ext, skin)
+	if err == errRenderQueueFull || err == context.DeadlineExceeded {
+		writeRenderQueueFull(w, r)
+		return
+	} else if err == context.Canceled {
+		return
+	} else if err != nil {
+		writeInternalError(w, r, ErrRenderFailed)
+		stats.Errored(ErrRenderFailed)
+		return
+	}
+	renderCache.add(key, data)
+	router.writeType(ext, etag, data, w, r)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/imgd/pkg/mcskin"
+	"github.com/minotar/minecraft"
+)
+
+func TestParseCardStyle(t *testing.T) {
+	style, err := parseCardStyle("2b2d42", "#14151F", "ffffff", "5fb65f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if style.Gradient != (color.NRGBA{0x14, 0x15, 0x1F, 0xFF}) {
+		t.Fatalf("Expected the gradient to be parsed, got %v", style.Gradient)
+	}
+	for _, bad := range []string{"", "fff", "zzzzzz", "11223344"} {
+		if _, err := parseCardStyle("2b2d42", "14151f", bad, "5fb65f"); err == nil {
+			t.Fatalf("Expected %q to be refused", bad)
+		}
+	}
+}
+
+func TestCardPage(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	renderCache = MakeRenderCache(1<<20, false)
+	hotCache = MakeHotCache(nil, 0)
+	missingFilter = MakeMissingFilter(0, time.Minute)
+	cardStyle, _ = parseCardStyle("2b2d42", "14151f", "ffffff", "5fb65f")
+
+	skin, _ := minecraft.FetchSkinForSteve()
+	skin.Hash = "clone1018"
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+	profileCache.add("d9135e082f2244c89cb10d21ed3ac8fd", Profile{UUID: "d9135e082f2244c89cb10d21ed3ac8fd", Name: "clone1018"}, time.Minute)
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+	serve := func(path string, etag string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		router.Mux.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("/card/d9135e082f2244c89cb10d21ed3ac8fd", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("Expected a PNG card, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	img, err := png.Decode(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bounds := img.Bounds(); bounds.Dx() != mcskin.CardWidth || bounds.Dy() != mcskin.CardHeight {
+		t.Fatalf("Expected a %dx%d card, got %v", mcskin.CardWidth, mcskin.CardHeight, bounds)
+	}
+	if name := cardName(httptest.NewRequest("GET", "/card/d9135e082f2244c89cb10d21ed3ac8fd", nil), "d9135e082f2244c89cb10d21ed3ac8fd"); name != "clone1018" {
+		t.Fatalf("Expected the card to carry the player's name, got %q", name)
+	}
+
+	etag := w.Header().Get("ETag")
+	if w := serve("/card/d9135e082f2244c89cb10d21ed3ac8fd", etag); w.Code != http.StatusNotModified {
+		t.Fatalf("Expected the ETag to match, got %d", w.Code)
+	}
+	if w := serve("/card/d9135e082f2244c89cb10d21ed3ac8fd?name=Someone", etag); w.Code != http.StatusOK {
+		t.Fatalf("Expected another name to be another card, got %d", w.Code)
+	}
+	if w := serve("/card/d9135e082f2244c89cb10d21ed3ac8fd.webp", ""); w.Header().Get("Content-Type") != "image/webp" {
+		t.Fatalf("Expected a WebP card, got %s", w.Header().Get("Content-Type"))
+	}
+	if w := serve("/card/d9135e082f2244c89cb10d21ed3ac8fd.svg", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("Expected SVG cards not to be served, got %d", w.Code)
+	}
+
+	// HEAD draws the card if it has to, to give its length.
+	renderCache.flush()
+	head := httptest.NewRecorder()
+	router.Mux.ServeHTTP(head, httptest.NewRequest("HEAD", "/card/d9135e082f2244c89cb10d21ed3ac8fd", nil))
+	if head.Code != http.StatusOK || head.Header().Get("Content-Length") == "" || head.Body.Len() != 0 {
+		t.Fatalf("Expected the card's headers with its length, got %d %v", head.Code, head.Header())
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/minotar/minecraft"
+)
+
+// Returned in place of Mojang's answer when we're pretending they rate
+// limited us. Named so isRateLimited takes it for the real thing.
+var errChaosRateLimited = errors.New("chaos: rate limited")
+
+// Returned in place of Mojang's answer when we're pretending they failed.
+var errChaosUpstream = errors.New("chaos: upstream failed")
+
+// Injects faults, so the circuit breaker, fallbacks and stale serving can
+// be seen to work in staging. Only made when serving with -chaos, so
+// they're never injected by a config copied into production. Rates are
+// the chance, from 0 to 1, of each request meeting the fault.
+type Chaos struct {
+	Latency     time.Duration
+	LatencyRate float64
+	// Upstream requests answered as if Mojang rate limited us, or failed.
+	RateLimitRate float64
+	ErrorRate     float64
+	// Cache reads which miss, and writes which are dropped, as if the cache
+	// erred.
+	CacheErrorRate float64
+}
+
+// Whether to inject the fault this time.
+func (c *Chaos) roll(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+// Runs fn as a request to Mojang, unless a fault's injected in its place.
+func (c *Chaos) upstream(fn func() (interface{}, error)) (interface{}, error) {
+	if c.roll(c.LatencyRate) {
+		chaosCounter.WithLabelValues("latency").Inc()
+		time.Sleep(c.Latency)
+	}
+	if c.roll(c.RateLimitRate) {
+		chaosCounter.WithLabelValues("rate_limit").Inc()
+		return nil, errChaosRateLimited
+	}
+	if c.roll(c.ErrorRate) {
+		chaosCounter.WithLabelValues("upstream_error").Inc()
+		return nil, errChaosUpstream
+	}
+	return fn()
+}
+
+// Whether this cache request errs.
+func (c *Chaos) cacheFails() bool {
+	if !c.roll(c.CacheErrorRate) {
+		return false
+	}
+	chaosCounter.WithLabelValues("cache_error").Inc()
+	stats.Errored(ErrChaosCache)
+	return true
+}
+
+func checkChaosRates(rates ...float64) error {
+	for _, rate := range rates {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("rate %g isn't between 0 and 1", rate)
+		}
+	}
+	return nil
+}
+
+// Puts the chaos in front of a cache: reads which err miss, and writes
+// which err are dropped, as our caches do when their backend fails. Only
+// whether entries age is passed through, for stale serving, so snapshots,
+// compaction and the like are unavailable under chaos.
+type CacheChaos struct {
+	Cache
+	Chaos *Chaos
+}
+
+// A CacheChaos in front of a cache whose entries age.
+type agingCacheChaos struct {
+	*CacheChaos
+}
+
+func MakeCacheChaos(cache Cache, chaos *Chaos) Cache {
+	c := &CacheChaos{Cache: cache, Chaos: chaos}
+	if _, ok := cache.(agingCache); ok {
+		return &agingCacheChaos{c}
+	}
+	return c
+}
+
+func (c *CacheChaos) has(username string) bool {
+	return !c.Chaos.cacheFails() && c.Cache.has(username)
+}
+
+func (c *CacheChaos) add(username string, skin minecraft.Skin, ttl time.Duration) {
+	if !c.Chaos.cacheFails() {
+		c.Cache.add(username, skin, ttl)
+	}
+}
+
+func (c *CacheChaos) addNegative(username string, reason NegativeReason, ttl time.Duration) {
+	if !c.Chaos.cacheFails() {
+		c.Cache.addNegative(username, reason, ttl)
+	}
+}
+
+func (c *CacheChaos) pullNegative(username string) NegativeReason {
+	if c.Chaos.cacheFails() {
+		return NegativeNone
+	}
+	return c.Cache.pullNegative(username)
+}
+
+func (c *agingCacheChaos) expiresIn(username string) (time.Duration, bool) {
+	return c.Cache.(agingCache).expiresIn(username)
+}
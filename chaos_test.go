@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/minotar/minecraft"
+	"gopkg.in/gcfg.v1"
+)
+
+func TestChaosUpstream(t *testing.T) {
+	stats = MakeStatsCollector()
+	defer func() { chaos = nil }()
+
+	chaos = &Chaos{RateLimitRate: 1}
+	u := MakeUpstream(time.Minute, 4*time.Minute)
+	calls := 0
+	fn := func() (interface{}, error) { calls++; return nil, nil }
+	if _, err := u.do(fn); err != errChaosRateLimited || calls != 0 {
+		t.Fatalf("Expected a rate limit in place of the request, got %v", err)
+	}
+	if _, blocked := u.blocked(); !blocked {
+		t.Fatal("Expected the injected rate limit to be backed off from")
+	}
+
+	chaos = &Chaos{ErrorRate: 1}
+	u = MakeUpstream(time.Minute, 4*time.Minute)
+	u.Breaker = MakeCircuitBreaker(5, time.Minute)
+	for i := 0; i < 10; i++ {
+		u.do(fn)
+	}
+	if calls != 0 || u.Breaker.allow() {
+		t.Fatalf("Expected injected failures to open the breaker, got %d calls", calls)
+	}
+
+	chaos = &Chaos{Latency: 20 * time.Millisecond, LatencyRate: 1}
+	u = MakeUpstream(time.Minute, 4*time.Minute)
+	start := time.Now()
+	if _, err := u.do(fn); err != nil || calls != 1 || time.Since(start) < 20*time.Millisecond {
+		t.Fatalf("Expected the request to be made late, got %v after %s", err, time.Since(start))
+	}
+}
+
+func TestCacheChaos(t *testing.T) {
+	stats = MakeStatsCollector()
+	inner := &CacheMemory{}
+	inner.setup()
+	skin, _ := minecraft.FetchSkinForSteve()
+	inner.add("a", skin, time.Minute)
+
+	c := MakeCacheChaos(inner, &Chaos{CacheErrorRate: 1})
+	if _, ok := c.(agingCache); !ok {
+		t.Fatal("Expected a cache whose entries age to keep doing so")
+	}
+	if c.has("a") {
+		t.Fatal("Expected reads to miss")
+	}
+	c.add("b", skin, time.Minute)
+	if inner.has("b") {
+		t.Fatal("Expected writes to be dropped")
+	}
+
+	if _, ok := MakeCacheChaos(&CacheOff{}, &Chaos{}).(agingCache); ok {
+		t.Fatal("Expected a cache whose entries don't age not to pretend to")
+	}
+	if !MakeCacheChaos(inner, &Chaos{}).has("a") {
+		t.Fatal("Expected reads to pass through without faults")
+	}
+}
+
+func TestChaosConfig(t *testing.T) {
+	c := Configuration{}
+	if err := gcfg.ReadStringInto(&c, "[chaos]\nlatency = 500\nratelimitrate = 0.25\n"); err != nil {
+		t.Fatal(err)
+	}
+	if c.Chaos.RateLimitRate != 0.25 || checkChaosRates(c.Chaos.RateLimitRate) != nil {
+		t.Fatalf("Expected the rate, got %v", c.Chaos.RateLimitRate)
+	}
+	if checkChaosRates(0, 1.5) == nil {
+		t.Fatal("Expected a rate over 1 to be refused")
+	}
+}
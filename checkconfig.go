@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// Checks the config, and optionally that the cache backend is reachable,
+// for -check-config. Writes any problems to w, and returns the exit code.
+func runConfigCheck(w io.Writer, pingCache bool) int {
+	if !fileExists(configTOML) && !fileExists(configFile) {
+		fmt.Fprintf(w, "No %s or %s to check\n", configTOML, configFile)
+		return 1
+	}
+	if err := config.load(); err != nil {
+		fmt.Fprintf(w, "%v\n", err)
+		return 1
+	}
+
+	problems := config.validate()
+	if pingCache && len(problems) == 0 {
+		if err := checkCache(); err != nil {
+			problems = append(problems, fmt.Sprintf("[server] cache: %s unreachable (%v)", config.Server.Cache, err))
+		}
+	}
+	for _, problem := range problems {
+		fmt.Fprintf(w, "%s\n", problem)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	fmt.Fprintf(w, "Config OK\n")
+	return 0
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Connects to the configured cache, and pings it if it can be.
+func checkCache() error {
+	c := MakeCache(config.Server.Cache)
+	if err := c.setup(); err != nil {
+		return err
+	}
+	if pingable, ok := c.(pingableCache); ok {
+		return pingable.ping()
+	}
+	return nil
+}
+
+// Returns what's wrong with the config, each prefixed with the section and
+// key it's in, eg. "[server] readtimeout: must not be negative".
+func (c *Configuration) validate() []string {
+	problems := negativeOptions(c)
+	add := func(section string, key string, err error) {
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("[%s] %s: %v", section, key, err))
+		}
+	}
+
+	_, err := parseLogLevel(c.Server.Logging)
+	add("server", "logging", err)
+	if c.Server.LogFormat != "" && c.Server.LogFormat != LogFormatText && c.Server.LogFormat != LogFormatJSON {
+		add("server", "logformat", fmt.Errorf("unknown format %q", c.Server.LogFormat))
+	}
+	add("server", "cache", checkCacheType(c.Server.Cache))
+	if c.Server.Cache == "tiered" {
+		add("tiered", "l2", checkCacheType(c.Tiered.L2))
+		if c.Tiered.L2 == "tiered" {
+			add("tiered", "l2", fmt.Errorf("the second tier can't itself be tiered"))
+		}
+	}
+
+	_, err = parseCIDRs(c.Server.DeadlineTrusted)
+	add("server", "deadlinetrusted", err)
+	_, err = parseCIDRs(c.Server.TrustedProxy)
+	add("server", "trustedproxy", err)
+	_, err = parseCIDRs(c.Auth.AllowIP)
+	add("auth", "allowip", err)
+	_, err = parseCIDRs(c.Auth.DenyIP)
+	add("auth", "denyip", err)
+	_, err = parseCIDRs(c.IPFilter.Allow)
+	add("ipfilter", "allow", err)
+	_, err = parseCIDRs(c.IPFilter.Deny)
+	add("ipfilter", "deny", err)
+	_, err = parseCIDRs(c.IPFilter.AdminAllow)
+	add("ipfilter", "adminallow", err)
+	_, err = parseCIDRs(c.IPFilter.AdminDeny)
+	add("ipfilter", "admindeny", err)
+	_, err = parseQuotaLimits(c.Quota.Limit)
+	add("quota", "limit", err)
+	_, err = parseRouteAliases(c.Alias.Route)
+	add("alias", "route", err)
+	_, err = parseDisabledRoutes(c.Routes.Disable)
+	add("routes", "disable", err)
+	add("chaos", "rates", checkChaosRates(c.Chaos.LatencyRate, c.Chaos.RateLimitRate, c.Chaos.ErrorRate, c.Chaos.CacheErrorRate))
+	_, err = parseExtraHeaders(c.Headers)
+	add("headers", "header", err)
+	for _, colour := range [][2]string{{"background", c.Card.Background}, {"gradient", c.Card.Gradient}, {"text", c.Card.Text}, {"accent", c.Card.Accent}} {
+		_, err = parseHexColor(colour[1])
+		add("card", colour[0], err)
+	}
+
+	for _, name := range c.Auth.Authenticator {
+		if _, exists := authenticatorFactories[strings.ToLower(name)]; !exists {
+			add("auth", "authenticator", fmt.Errorf("unknown authenticator %q", name))
+		}
+	}
+	if c.Auth.Signed && c.Auth.HMACSecret == "" {
+		add("auth", "signed", fmt.Errorf("signing URLs needs an hmacsecret"))
+	}
+	if _, exists := skinStoreFactories[strings.ToLower(c.Offline.Store)]; c.Offline.Store != "" && !exists {
+		add("offline", "store", fmt.Errorf("unknown skin store %q", c.Offline.Store))
+	}
+	if c.Offline.Upload && c.Offline.Store == "" {
+		add("offline", "upload", fmt.Errorf("uploads need a store"))
+	}
+	if c.Offline.Upload && len(c.Offline.UploadKey) == 0 && len(c.Admin.Key) == 0 {
+		add("offline", "upload", fmt.Errorf("uploads need an uploadkey or [admin] key"))
+	}
+	if strings.EqualFold(c.Offline.Store, "mysql") && c.Offline.DSN == "" {
+		add("offline", "dsn", fmt.Errorf("the mysql store needs a dsn"))
+	}
+	if c.Jobs.Store != "memory" && c.Jobs.Store != "redis" {
+		add("jobs", "store", fmt.Errorf("unknown job store %q, expected memory or redis", c.Jobs.Store))
+	}
+	if (c.TLS.Cert == "") != (c.TLS.Key == "") {
+		add("tls", "cert", fmt.Errorf("cert and key must be given together"))
+	}
+	add("cape", "provider", checkCapeProviders(c.Cape.Provider, c.CapeProvider))
+	for name, renderer := range c.Renderer {
+		if renderer != nil {
+			add(fmt.Sprintf("renderer \"%s\"", name), "url", checkExternalRenderer(name, renderer))
+		}
+	}
+	for name, size := range c.Size {
+		if size != nil {
+			add(fmt.Sprintf("size \"%s\"", name), "allow", checkRouteSize(name, size, c.Renderer))
+		}
+	}
+	for name, preset := range c.Preset {
+		if preset != nil {
+			add(fmt.Sprintf("preset \"%s\"", name), "size", checkPreset(preset))
+			if _, exists := c.Theme[preset.Theme]; preset.Theme != "" && !exists {
+				add(fmt.Sprintf("preset \"%s\"", name), "theme", fmt.Errorf("unknown theme %q", preset.Theme))
+			}
+		}
+	}
+	for name, theme := range c.Theme {
+		if theme != nil {
+			_, err = parseTheme(theme)
+			add(fmt.Sprintf("theme \"%s\"", name), "background", err)
+		}
+	}
+	for name, tenant := range c.Tenant {
+		if tenant != nil {
+			add(fmt.Sprintf("tenant \"%s\"", name), "route", checkTenant(tenant, c.Preset))
+		}
+	}
+	for name, listen := range c.Listen {
+		if listen != nil {
+			add(fmt.Sprintf("listen \"%s\"", name), "address", checkListen(listen))
+		}
+	}
+
+	add("server", "url", checkURL(c.Server.URL))
+	add("minecraft", "sessionserverurl", checkURL(c.Minecraft.SessionServerURL))
+	add("minecraft", "profileurl", checkURL(c.Minecraft.ProfileURL))
+	add("minecraft", "bulkurl", checkURL(c.Minecraft.BulkURL))
+	add("minecraft", "textureurl", checkURL(c.Minecraft.TextureURL))
+	add("minecraft", "authserver", checkURL(c.Minecraft.AuthServer))
+	// Negative values are caught below.
+	if c.Minecraft.BackoffBase == 0 {
+		add("minecraft", "backoffbase", fmt.Errorf("must be more than 0, or we'd never back off"))
+	}
+	if c.Minecraft.BackoffMax == 0 {
+		add("minecraft", "backoffmax", fmt.Errorf("must be more than 0, or we'd never back off"))
+	}
+	add("bedrock", "url", checkURL(c.Bedrock.URL))
+	add("offline", "url", checkURL(c.Offline.URL))
+	for _, u := range c.Mirror.URL {
+		add("mirror", "url", checkURL(u))
+	}
+	for _, u := range c.Peer.URL {
+		add("peer", "url", checkURL(u))
+	}
+	return problems
+}
+
+// Numbers, sizes and durations are never meaningfully negative.
+func negativeOptions(c *Configuration) []string {
+	problems := []string{}
+	check := func(section string, v reflect.Value) {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Field(i)
+			if (field.Kind() == reflect.Int && field.Int() < 0) || (field.Kind() == reflect.Float64 && field.Float() < 0) {
+				problems = append(problems, fmt.Sprintf("[%s] %s: must not be negative", section, strings.ToLower(v.Type().Field(i).Name)))
+			}
+		}
+	}
+
+	config := reflect.ValueOf(c).Elem()
+	for i := 0; i < config.NumField(); i++ {
+		section := strings.ToLower(config.Type().Field(i).Name)
+		switch field := config.Field(i); field.Kind() {
+		case reflect.Struct:
+			check(section, field)
+		case reflect.Map:
+			for _, key := range field.MapKeys() {
+				if field.MapIndex(key).IsNil() {
+					continue
+				}
+				check(fmt.Sprintf("%s \"%s\"", section, key), field.MapIndex(key).Elem())
+			}
+		}
+	}
+	return problems
+}
+
+func checkCacheType(name string) error {
+	for _, known := range cacheTypes {
+		if name == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown cache %q", name)
+}
+
+// Checks an optional URL is absolute, over HTTP or HTTPS.
+func checkURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q isn't an http or https URL", raw)
+	}
+	return nil
+}
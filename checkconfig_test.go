@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/gcfg.v1"
+)
+
+func TestValidateConfig(t *testing.T) {
+	var c Configuration
+	if err := gcfg.ReadStringInto(&c, configDefaults); err != nil {
+		t.Fatal(err)
+	}
+	c.dropBlankEntries()
+	if problems := c.validate(); len(problems) > 0 {
+		t.Fatalf("Expected the example to be valid, got %v", problems)
+	}
+
+	c.Server.ReadTimeout = -1
+	c.Server.Logging = "LOUD"
+	c.Server.TrustedProxy = []string{"10.0.0.0/33"}
+	c.CacheControl["render"].MaxAge = -60
+	c.TLS.Cert = "cert.pem"
+	c.Mirror.URL = []string{"mirror.example.com"}
+	c.Minecraft.BackoffBase = 0
+
+	problems := strings.Join(c.validate(), "\n")
+	for _, expected := range []string{
+		"[server] readtimeout: must not be negative",
+		"[server] logging: unknown log level",
+		"[server] trustedproxy: ",
+		"[cachecontrol \"render\"] maxage: must not be negative",
+		"[tls] cert: cert and key must be given together",
+		"[mirror] url: ",
+		"[minecraft] backoffbase: must be more than 0",
+	} {
+		if !strings.Contains(problems, expected) {
+			t.Fatalf("Expected a problem starting %q, got\n%s", expected, problems)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/minotar/minecraft"
+)
+
+// How we talk to Mojang, and its mirrors.
+type upstreamClientOptions struct {
+	// HTTP or SOCKS5 proxy to send requests through, and extra CAs to trust.
+	Proxy    string
+	CABundle string
+	// How long to wait to connect, and then for a response. 0 leaves the
+	// defaults.
+	ConnectTimeout time.Duration
+	ReadTimeout    time.Duration
+	// Times to retry a request which failed or timed out, waiting Backoff
+	// before the first retry and twice as long before each one after.
+	Retries      int
+	RetryBackoff time.Duration
+}
+
+// Builds the HTTP client we talk to Mojang, and its mirrors, with. Requests
+// can be sent through an HTTP or SOCKS5 proxy, and certificates checked
+// against an extra CA bundle, for deployments which can only reach Mojang
+// through one.
+func makeUpstreamClient(opts upstreamClientOptions) (*http.Client, error) {
+	client := minecraft.NewHTTPClient()
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.ConnectTimeout > 0 {
+		dialer := &net.Dialer{Timeout: opts.ConnectTimeout, KeepAlive: 30 * time.Second}
+		transport.DialContext = dialer.DialContext
+		transport.TLSHandshakeTimeout = opts.ConnectTimeout
+	}
+	if opts.ReadTimeout > 0 {
+		transport.ResponseHeaderTimeout = opts.ReadTimeout
+	}
+
+	if opts.Proxy != "" {
+		proxyURL, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme \"%s\"", proxyURL.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if opts.CABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := ioutil.ReadFile(opts.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.CABundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	client.Transport = &retryTransport{Transport: transport, Retries: opts.Retries, Backoff: opts.RetryBackoff}
+	return client, nil
+}
+
+// Retries requests which fail outright, or which the server fails with a
+// 5xx. Anything else, such as being told a player doesn't exist or that
+// we're being rate limited, is passed straight back.
+type retryTransport struct {
+	Transport http.RoundTripper
+	Retries   int
+	Backoff   time.Duration
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := t.Backoff
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		resp, err := t.Transport.RoundTrip(req)
+		observeUpstream(req.URL.Host, time.Since(start), resp, err)
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		debugTraceFrom(req.Context()).call(req.Method+" "+req.URL.Host+req.URL.Path, status, err, start)
+		if isTimeout(err) {
+			stats.TimedOut(req.URL.Host)
+		}
+		retryable := err != nil || resp.StatusCode >= http.StatusInternalServerError
+		if !retryable || attempt >= t.Retries || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		backoff *= 2
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// Whether the request failed by taking too long.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
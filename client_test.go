@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestUpstreamClientProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Proxied requests carry the full URL.
+		fmt.Fprint(w, r.URL.String())
+	}))
+	defer proxy.Close()
+
+	client, err := makeUpstreamClient(upstreamClientOptions{Proxy: proxy.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get("http://sessionserver.mojang.com/session/minecraft/profile/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "http://sessionserver.mojang.com/session/minecraft/profile/" {
+		t.Fatalf("Expected the request to go through the proxy, got %s", body)
+	}
+
+	if _, err := makeUpstreamClient(upstreamClientOptions{Proxy: "ftp://proxy"}); err == nil {
+		t.Fatal("Expected an unsupported proxy to be refused")
+	}
+	if _, err := makeUpstreamClient(upstreamClientOptions{CABundle: "missing.pem"}); err == nil {
+		t.Fatal("Expected a missing CA bundle to be refused")
+	}
+}
+
+func TestUpstreamClientRetries(t *testing.T) {
+	stats = MakeStatsCollector()
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body, _ := ioutil.ReadAll(r.Body)
+		if requests < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	client, _ := makeUpstreamClient(upstreamClientOptions{Retries: 2})
+	resp, err := client.Post(server.URL, "application/json", strings.NewReader(`["clone1018"]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != `["clone1018"]` || requests != 3 {
+		t.Fatalf("Expected the request to succeed on the third try, got %d after %d", resp.StatusCode, requests)
+	}
+
+	requests = 0
+	client, _ = makeUpstreamClient(upstreamClientOptions{Retries: 1})
+	resp, _ = client.Get(server.URL)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway || requests != 2 {
+		t.Fatalf("Expected to give up after one retry, got %d after %d", resp.StatusCode, requests)
+	}
+}
+
+func TestUpstreamMetrics(t *testing.T) {
+	stats = MakeStatsCollector()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	client, _ := makeUpstreamClient(upstreamClientOptions{})
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if count := testutil.ToFloat64(upstreamResponseCounter.WithLabelValues(host, "429")); count != 1 {
+		t.Fatalf("Expected one rate limited response, got %v", count)
+	}
+
+	server.Close()
+	client.Get(server.URL)
+	if count := testutil.ToFloat64(upstreamResponseCounter.WithLabelValues(host, "error")); count != 1 {
+		t.Fatalf("Expected one failed request, got %v", count)
+	}
+}
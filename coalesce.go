@@ -0,0 +1,39 @@
+package main
+
+import (
+	"golang.org/x/sync/singleflight"
+)
+
+// Upstream calls currently in flight, keyed by call and argument.
+var upstreamGroup singleflight.Group
+
+// By call, the upstream fetches in flight, and the requests waiting on one,
+// whether they're making it or sharing its result.
+var coalesceInFlight, coalesceWaiting gaugeMap
+
+// Runs fn, unless the same call for the same key is already in flight, in
+// which case we wait for it and share its result. When a popular player
+// isn't cached, this means a burst of requests for them costs one call to
+// Mojang rather than one each.
+func coalesce(call string, key string, fn func() (interface{}, error)) (interface{}, error) {
+	coalesceWaiting.add(call, 1)
+	defer coalesceWaiting.add(call, -1)
+
+	ran := false
+	result, err, _ := upstreamGroup.Do(call+":"+key, func() (interface{}, error) {
+		ran = true
+		coalesceInFlight.add(call, 1)
+		upstreamInFlightGauge.WithLabelValues(call).Inc()
+		defer func() {
+			coalesceInFlight.add(call, -1)
+			upstreamInFlightGauge.WithLabelValues(call).Dec()
+		}()
+		return fn()
+	})
+	if ran {
+		stats.Uncoalesced(call)
+	} else {
+		stats.Coalesced(call)
+	}
+	return result, err
+}
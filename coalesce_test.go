@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalesce(t *testing.T) {
+	stats = MakeStatsCollector()
+
+	var calls int32
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "853c80ef3c3749fdaa49938b674adae6", nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if result, _ := coalesce("GetUUID", "clone1018", fn); result != "853c80ef3c3749fdaa49938b674adae6" {
+				t.Errorf("Expected the shared UUID, got %v", result)
+			}
+		}()
+	}
+	// Give everyone a chance to join the call in flight.
+	time.Sleep(50 * time.Millisecond)
+	info := stats.snapshot()
+	if info.InFlight["GetUUID"] != 1 || info.Waiting["GetUUID"] != 10 {
+		t.Fatalf("Expected one call in flight with ten waiting, got %d and %d", info.InFlight["GetUUID"], info.Waiting["GetUUID"])
+	}
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("Expected one call, got %d", calls)
+	}
+	info = stats.snapshot()
+	if info.InFlight["GetUUID"] != 0 || info.Waiting["GetUUID"] != 0 {
+		t.Fatal("Expected nothing in flight once the call's done")
+	}
+	if info.Coalesced["GetUUID"] != 9 || info.CoalescedRatio["GetUUID"] != 0.9 {
+		t.Fatalf("Expected nine of ten requests coalesced, got %v", info.CoalescedRatio)
+	}
+}
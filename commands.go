@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minotar/imgd/pkg/mcskin"
+)
+
+// A sub-command of imgd, eg. "cache purge" in "imgd cache purge clone1018".
+type Command struct {
+	Name string
+	// Arguments it takes, for the usage message.
+	Args    string
+	Summary string
+	Run     func(args []string) int
+}
+
+func commands() []Command {
+	return []Command{
+		{"serve", "[-chaos] [-self-test]", "Serve images over HTTP, the default", runServe},
+		{"render", "[-size N] [-format png|svg] [-out FILE] (<resource> <player> | -type <resource> -skin FILE)", "Render a player, or a skin file without going online", runRender},
+		{"cache purge", "<player>...", "Remove players from the cache", runCachePurge},
+		{"cache warm", "<file>", "Fetch the players listed in the file into the cache", runCacheWarm},
+		{"cache stats", "", "Report how much the cache holds", runCacheStats},
+		{"check-config", "[-cache]", "Check the config for problems", runCheckConfig},
+		{"verify-renders", "[-write FILE]", "Check the renders of the bundled skins against their golden checksums", runVerifyRenders},
+		{"sign", "[-ttl DURATION] <path>...", "Print URLs for the paths, signed with the hmac secret", runSign},
+		{"bench", "[-url URL [-stats URL]] [-log FILE | -players FILE -n N] [-c N]", "Load test an instance, or our handlers, and report latency and cache hits", runBench},
+	}
+}
+
+// Runs the sub-command named by the arguments, and returns the exit code.
+// Without one we serve, as imgd always has.
+func runCommand(args []string) int {
+	if checkArgs, ok := checkConfigAlias(args); ok {
+		return runCheckConfig(checkArgs)
+	}
+	if len(args) == 0 || (strings.HasPrefix(args[0], "-") && !isHelp(args[0])) {
+		return runServe(args)
+	}
+	if isHelp(args[0]) {
+		printUsage(os.Stdout)
+		return 0
+	}
+	for _, command := range commands() {
+		words := strings.Fields(command.Name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == command.Name {
+			return command.Run(args[len(words):])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", strings.Join(args, " "))
+	printUsage(os.Stderr)
+	return 2
+}
+
+// Before there were commands, the config was checked with -check-config,
+// and -check-cache to ping the cache too. Those still work, for scripts
+// written for them, by returning check-config's arguments for them.
+func checkConfigAlias(args []string) ([]string, bool) {
+	alias := false
+	checkArgs := []string{}
+	for _, arg := range args {
+		switch strings.TrimLeft(arg, "-") {
+		case "check-config", "check-config=true":
+			alias = true
+		case "check-cache", "check-cache=true":
+			checkArgs = append(checkArgs, "-cache")
+		default:
+			checkArgs = append(checkArgs, arg)
+		}
+	}
+	return checkArgs, alias
+}
+
+func isHelp(arg string) bool {
+	return arg == "help" || arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: imgd <command> [arguments]\n\nCommands:\n")
+	for _, command := range commands() {
+		fmt.Fprintf(w, "  %-14s %s\n", command.Name, command.Summary)
+		if command.Args != "" {
+			fmt.Fprintf(w, "  %-14s   imgd %s %s\n", "", command.Name, command.Args)
+		}
+	}
+}
+
+// Parses a command's flags, and checks it was given as many arguments as it
+// needs. Returns false, having said why, if it wasn't.
+func parseCommand(fs *flag.FlagSet, args []string, min int, max int) bool {
+	if err := fs.Parse(args); err != nil {
+		return false
+	}
+	if fs.NArg() < min || (max >= 0 && fs.NArg() > max) {
+		fmt.Fprintf(os.Stderr, "Wrong number of arguments to %s\n", fs.Name())
+		fs.Usage()
+		return false
+	}
+	return true
+}
+
+// Sets up what the offline tools need to fetch and cache players, logging
+// to stderr so it stays out of anything they print.
+func setupTool() {
+	stats = MakeStatsCollector()
+	setupConfig()
+	setupLog(os.Stderr)
+	setupCache()
+	setupMcClient()
+	setupSkinStore()
+	setupFallback()
+}
+
+// Largest render the command will draw. It's only ever for one person, so
+// it needn't be held to the server's MaxWidth.
+const maxToolWidth = 2048
+
+func runRender(args []string) int {
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	size := fs.Uint("size", DefaultWidth, "width in pixels")
+	fs.UintVar(size, "width", DefaultWidth, "same as -size")
+	format := fs.String("format", "png", "png or svg")
+	out := fs.String("out", "", "file to write to, rather than stdout")
+	skinFile := fs.String("skin", "", "skin file to render, without going online")
+	resourceName := fs.String("type", "", "what to render, rather than giving it first")
+	if !parseCommand(fs, args, 0, 2) {
+		return 2
+	}
+
+	players := fs.Args()
+	if *resourceName == "" && len(players) > 0 {
+		*resourceName, players = players[0], players[1:]
+	}
+	resource := ""
+	for _, known := range renderResources {
+		if strings.EqualFold(known, *resourceName) {
+			resource = known
+		}
+	}
+	if resource == "" {
+		fmt.Fprintf(os.Stderr, "Unknown resource %q, expected one of %s\n", *resourceName, strings.ToLower(strings.Join(renderResources, ", ")))
+		return 2
+	}
+	if *format != "png" && *format != "svg" {
+		fmt.Fprintf(os.Stderr, "Unknown format %q, expected png or svg\n", *format)
+		return 2
+	}
+	if *size < MinWidth || *size > maxToolWidth {
+		fmt.Fprintf(os.Stderr, "Size must be from %d to %d\n", MinWidth, maxToolWidth)
+		return 2
+	}
+
+	var skin *mcSkin
+	if *skinFile != "" && len(players) == 0 {
+		// Nothing but the file, so previews are the same wherever they're
+		// drawn and need no config.
+		setupLog(os.Stderr)
+		loaded, err := loadFallbackSkin(*skinFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to load %s (%v)\n", *skinFile, err)
+			return 1
+		}
+		loaded.Source = "File"
+		skin = &mcSkin{Render: mcskin.Render{Skin: loaded}}
+	} else if *skinFile == "" && len(players) == 1 {
+		setupTool()
+		skin = fetchSkin(players[0])
+		if skin.Fallback {
+			log.Warningf("Unable to fetch %s, rendering the fallback skin", players[0])
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "Give either a player to render, or a -skin file\n")
+		return 2
+	}
+
+	router := &Router{}
+	ext := "." + *format
+	skin.Mode = router.getResizeMode(ext)
+	err := router.ResolveMethod(skin, resource)(int(*size))
+	var data []byte
+	if err == nil {
+		skin.PostProcess()
+		data, err = router.encodeType(ext, skin)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to render (%v)\n", err)
+		return 1
+	}
+
+	if *out == "" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = os.WriteFile(*out, data, 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to write the render (%v)\n", err)
+		return 1
+	}
+	return 0
+}
+
+func runCachePurge(args []string) int {
+	fs := flag.NewFlagSet("cache purge", flag.ContinueOnError)
+	if !parseCommand(fs, args, 1, -1) {
+		return 2
+	}
+
+	setupTool()
+	// Running instances hold players in memory too, so tell them if we can.
+	var broadcaster *PurgeBroadcaster
+	if config.Redis.PurgeChannel != "" {
+		broadcaster = MakePurgeBroadcaster(config.Redis.PurgeChannel)
+	}
+	for _, player := range fs.Args() {
+		username := strings.ToLower(player)
+		uuid := strings.Replace(username, "-", "", -1)
+		if !isUUID(uuid) {
+			// Skins are cached by UUID, which we may not have to hand.
+			uuid, _ = resolveUUID(context.Background(), username)
+		}
+		purgePlayer(username, uuid)
+		if broadcaster != nil {
+			broadcaster.purge(username, uuid)
+		}
+		fmt.Printf("Purged %s\n", player)
+	}
+	return 0
+}
+
+func runCacheWarm(args []string) int {
+	fs := flag.NewFlagSet("cache warm", flag.ContinueOnError)
+	if !parseCommand(fs, args, 1, 1) {
+		return 2
+	}
+
+	setupTool()
+	players, err := readWarmupFile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read %s (%v)\n", fs.Arg(0), err)
+		return 1
+	}
+	MakeWarmer(config.Warmup.Rate).run(players)
+	return 0
+}
+
+func runCacheStats(args []string) int {
+	fs := flag.NewFlagSet("cache stats", flag.ContinueOnError)
+	if !parseCommand(fs, args, 0, 0) {
+		return 2
+	}
+
+	stats = MakeStatsCollector()
+	setupConfig()
+	setupLog(os.Stderr)
+	setupCache()
+	if config.Server.Cache == "memory" {
+		fmt.Fprintf(os.Stderr, "The memory cache is per process, see /stats on the server for its size.\n")
+		return 1
+	}
+	fmt.Printf("Cache: %s\nEntries: %d\nMemory: %d bytes\n", config.Server.Cache, cache.size(), cache.memory())
+	return 0
+}
+
+func runSign(args []string) int {
+	fs := flag.NewFlagSet("sign", flag.ContinueOnError)
+	ttl := fs.Duration("ttl", 24*time.Hour, "how long the URLs are good for")
+	if !parseCommand(fs, args, 1, -1) {
+		return 2
+	}
+
+	setupConfig()
+	setupLog(os.Stderr)
+	if config.Auth.HMACSecret == "" {
+		fmt.Fprintln(os.Stderr, "No hmacsecret is configured to sign with")
+		return 1
+	}
+	signer := &HMACAuthenticator{Secret: []byte(config.Auth.HMACSecret)}
+	expires := time.Now().Add(*ttl).Unix()
+	for _, path := range fs.Args() {
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		fmt.Printf("%s%s?%s\n", strings.TrimRight(config.Server.URL, "/"), path, url.Values{
+			"expires": {strconv.FormatInt(expires, 10)},
+			"sig":     {signer.Sign(path, expires)},
+		}.Encode())
+	}
+	return 0
+}
+
+func runCheckConfig(args []string) int {
+	fs := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	pingCache := fs.Bool("cache", false, "also check the cache backend is reachable")
+	if !parseCommand(fs, args, 0, 0) {
+		return 2
+	}
+	return runConfigCheck(os.Stdout, *pingCache)
+}
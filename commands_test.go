@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/minotar/minecraft"
+)
+
+func TestRunCommand(t *testing.T) {
+	if code := runCommand([]string{"cache", "stat"}); code != 2 {
+		t.Fatalf("Expected an unknown command to exit 2, got %d", code)
+	}
+	if code := runCommand([]string{"help"}); code != 0 {
+		t.Fatalf("Expected help to exit 0, got %d", code)
+	}
+
+	usage := new(bytes.Buffer)
+	printUsage(usage)
+	if !strings.Contains(usage.String(), "imgd cache purge <player>...") {
+		t.Fatalf("Expected the usage to show arguments, got %q", usage.String())
+	}
+}
+
+func TestCheckConfigAlias(t *testing.T) {
+	if args, ok := checkConfigAlias([]string{"-check-cache", "--check-config"}); !ok || len(args) != 1 || args[0] != "-cache" {
+		t.Fatalf("Expected the old flags to check the config and cache, got %v", args)
+	}
+	if _, ok := checkConfigAlias([]string{"-chaos"}); ok {
+		t.Fatal("Expected serve's flags to serve")
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	fs.SetOutput(new(bytes.Buffer))
+	width := fs.String("width", "180", "")
+	if !parseCommand(fs, []string{"-width", "32", "helm", "clone1018"}, 1, 2) || *width != "32" {
+		t.Fatal("Expected flags and two arguments to parse")
+	}
+	if parseCommand(fs, []string{"helm", "clone1018", "extra"}, 1, 2) {
+		t.Fatal("Expected too many arguments to be refused")
+	}
+	if parseCommand(fs, []string{}, 1, 2) {
+		t.Fatal("Expected too few arguments to be refused")
+	}
+}
+
+func TestRenderSkinFile(t *testing.T) {
+	dir := t.TempDir()
+	steve, _ := minecraft.FetchSkinForSteve()
+	skinFile := filepath.Join(dir, "skin.png")
+	file, _ := os.Create(skinFile)
+	png.Encode(file, steve.Image)
+	file.Close()
+
+	renders := [2][]byte{}
+	for i := range renders {
+		out := filepath.Join(dir, fmt.Sprintf("body%d.png", i))
+		if code := runCommand([]string{"render", "-skin", skinFile, "-type", "body", "-size", "512", "-out", out}); code != 0 {
+			t.Fatalf("Expected the render to succeed, got %d", code)
+		}
+		renders[i], _ = os.ReadFile(out)
+	}
+	img, err := png.Decode(bytes.NewReader(renders[0]))
+	if err != nil || img.Bounds().Dx() != 512 {
+		t.Fatalf("Expected a 512 wide PNG, got %v (%v)", img, err)
+	}
+	if !bytes.Equal(renders[0], renders[1]) {
+		t.Fatal("Expected the same render each time")
+	}
+}
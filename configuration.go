@@ -1,9 +1,16 @@
 package main
 
 import (
+	_ "embed"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"reflect"
+	"strings"
+	"time"
 
+	"github.com/BurntSushi/toml"
 	"gopkg.in/gcfg.v1"
 )
 
@@ -13,41 +20,539 @@ const (
 	// The example file kept in version control. We'll copy and load from this
 	// by default.
 	configExample = "config.example.gcfg"
+	// Read instead of the config file if it exists, over the defaults in
+	// the example.
+	configTOML = "config.toml"
 )
 
+// The example config, for defaults which don't depend on it being beside
+// the binary.
+//
+//go:embed config.example.gcfg
+var configDefaults string
+
 type Configuration struct {
 	Server struct {
 		Address string
 		Cache   string
 		Logging string
-		URL     string
-		Ttl     int
+		// Whether to log as "text" or "json".
+		LogFormat string
+		URL       string
+		Ttl       int
+		// Seconds between cache maintenance runs, 0 to disable.
+		MaintenanceInterval int
+		// Networks trusted to set a deadline header.
+		DeadlineTrusted []string
+		// Proxies trusted to tell us the client's address.
+		TrustedProxy []string
+		// File or URL of the skin to serve for players we can't fetch,
+		// blank for Steve.
+		FallbackSkin string
+		// Megabytes and entries the memory cache is bounded to.
+		CacheMaxMem     int
+		CacheMaxEntries int
+		// Shards the memory cache splits usernames over, each with its own
+		// lock.
+		CacheShards int
+		// Megabytes of encoded renders to keep, 0 to disable.
+		RenderCacheMem int
+		// Whether to compress larger renders in the render cache.
+		RenderCacheCompress bool
+		// Widths whose plain renders are kept by player, and how many of
+		// them to keep, 0 to disable.
+		HotSize         []uint
+		HotCacheEntries int
+		// Where and how often, in seconds, to snapshot the memory cache.
+		SnapshotPath     string
+		SnapshotInterval int
+		// Unix socket to hand the memory cache to our replacement over.
+		HandoffSocket string
+		// Names the missing username filter is sized for, 0 to disable.
+		MissingFilter int
+		// Number of most requested players to keep track of, 0 to disable,
+		// and the seconds they're counted over.
+		TopPlayers       int
+		TopPlayersWindow int
+		// Address to serve metrics, status and admin on instead, blank to
+		// serve them with everything else.
+		InternalAddress string
+		// Whether to accept HTTP/2 without TLS.
+		H2C bool
+		// Whether to attach the trace ID of requests arriving with a
+		// traceparent header to the latency histograms.
+		Exemplars bool
+		// Seconds to wait for in-flight requests to finish when shutting
+		// down.
+		ShutdownGrace int
+		// Seconds a client may take to send a request, we may take to
+		// answer it, and a kept-alive connection may sit idle. 0 for no
+		// limit.
+		ReadTimeout  int
+		WriteTimeout int
+		IdleTimeout  int
+		// Bytes of request headers to accept, 0 for Go's default of 1MB.
+		MaxHeaderBytes int
+		// Connections to serve at once, 0 for no limit.
+		MaxConns int
+		// Seconds to spend on a request before giving up on it, 0 for no
+		// limit.
+		RequestTimeout int
 	}
 
 	Minecraft struct {
 		UserAgent        string
 		SessionServerURL string
 		ProfileURL       string
+		BulkURL          string
+		TextureURL       string
+		// Root URL of an authlib-injector style auth server to use in place
+		// of Mojang. Overrides the URLs above, bar TextureURL.
+		AuthServer string
+		// HTTP or SOCKS5 proxy to reach Mojang through, and extra CAs to
+		// trust, eg. for a TLS intercepting proxy.
+		Proxy    string
+		CABundle string
+		// Milliseconds to wait to connect to Mojang, and for them to respond.
+		ConnectTimeout int
+		ReadTimeout    int
+		// Times to retry failed requests, and milliseconds to wait before
+		// the first retry.
+		Retries      int
+		RetryBackoff int
+		// Whether to check textures are signed by the key in this file.
+		VerifySignature bool
+		SignatureKey    string
+		// Milliseconds to back off for once rate limited, doubling up to
+		// the max while we keep being limited.
+		BackoffBase int
+		BackoffMax  int
+		// Failures in a row before we stop asking Mojang for the cooldown,
+		// in milliseconds.
+		BreakerThreshold uint
+		BreakerCooldown  int
+		// Requests a minute we'll make to Mojang at most, 0 for no cap, and
+		// how many of them may be made at once.
+		Budget      int
+		BudgetBurst int
+		// Bytes a texture may be, larger ones being refused.
+		MaxTextureSize int64
 	}
 
 	Redis struct {
 		Address  string
 		Auth     string
+		AuthFile string
 		DB       int
 		Prefix   string
 		PoolSize int
+		// Channel purges are relayed to other instances over.
+		PurgeChannel string
+	}
+
+	Ttl struct {
+		Skin    int
+		UUID    int
+		Profile int
+		History int
+		Failed  int
+		Error   int
+		Stale   int
+	}
+
+	Memcached struct {
+		Server  []string
+		Prefix  string
+		Timeout int
+	}
+
+	Disk struct {
+		Path    string
+		MaxSize int
+	}
+
+	S3 struct {
+		Endpoint  string
+		Region    string
+		Bucket    string
+		Prefix    string
+		AccessKey string
+		SecretKey string
+		Insecure  bool
+		Timeout   int
+		Writers   int
+		// Files to read the keys from instead.
+		AccessKeyFile string
+		SecretKeyFile string
+	}
+
+	Tiered struct {
+		L2 string
+	}
+
+	Warmup struct {
+		File string
+		Rate int
+	}
+
+	Prefetch struct {
+		// Seconds between looks for top players' skins to refresh, 0 to
+		// disable.
+		Interval int
+		Players  int
+		// Seconds before a skin expires to refresh it.
+		Ahead int
+		Rate  int
+	}
+
+	TLS struct {
+		// Certificate and key to serve HTTPS with, blank to serve HTTP.
+		Cert string
+		Key  string
+		// Seconds between checks for a changed certificate, 0 to disable.
+		Reload int
+	}
+
+	Offline struct {
+		Enabled bool
+		Store   string
+		Path    string
+		URL     string
+		// Whether skins may be uploaded into the store, taking the place
+		// of players' Mojang skins.
+		Upload bool
+		// Keys which may upload skins, as "name:key", besides the admin
+		// keys.
+		UploadKey []string
+		// Whether to look every player up in the store before Mojang, not
+		// just offline mode ones. Implied by Upload.
+		Prefer bool
+		// Database and query for the "mysql" store.
+		DSN     string
+		Query   string
+		DSNFile string
+	}
+
+	Bedrock struct {
+		// Geyser's global API, which we look gamertags and skins up with.
+		// Blank to treat Bedrock players like anyone else.
+		URL string
+		// What Floodgate prefixes gamertags with.
+		Prefix string
+	}
+
+	Mirror struct {
+		URL []string
+	}
+
+	Peer struct {
+		URL     []string
+		Timeout int
+		// API key sent to peers.
+		Key string
+	}
+
+	Auth struct {
+		Authenticator []string
+		Required      bool
+		// Refuse renders and skins without a valid hmac signature.
+		Signed     bool
+		APIKey     []string
+		HMACSecret string
+		AllowIP    []string
+		DenyIP     []string
+		// Files to read the keys, one per line, and secret from instead.
+		APIKeyFile     string
+		HMACSecretFile string
+	}
+
+	Admin struct {
+		// Keys, as "name:key", which alone may use the admin endpoints.
+		Key []string
+		// File to read the keys from instead, one per line.
+		KeyFile string
+		// Whether to serve pprof profiles under /admin/debug/pprof/.
+		Pprof bool
+	}
+
+	Render struct {
+		// Whether heavy renders are run on a pool of workers, rather than
+		// each on its request as it comes.
+		Pool bool
+		// Workers for heavy renders, 0 for one per CPU.
+		Workers int
+		// Heavy renders which may wait for a worker.
+		Queue int
+		// Renders at least this wide count as heavy.
+		LargeWidth uint
+	}
+
+	Jobs struct {
+		// Where render jobs are queued and their results kept: "memory",
+		// or "redis" to share them between instances.
+		Store string
+		// Jobs rendered at once, 0 to turn the async API off.
+		Workers int
+		// Jobs which may wait for a worker.
+		Queue int
+		// Most renders in one job.
+		MaxRenders int
+		// Seconds a finished job's status and result are kept.
+		Ttl int
+		// Megabytes of results the memory store keeps.
+		ResultMem int
+	}
+
+	IPFilter struct {
+		// CIDRs let in, and turned away, on every route. Blank allow lets
+		// in anyone not denied.
+		Allow []string
+		Deny  []string
+		// Further CIDRs for the internal routes: metrics, status and admin.
+		AdminAllow []string
+		AdminDeny  []string
+	}
+
+	RateLimit struct {
+		// Requests a second each client IP may make, 0 for no limit, and
+		// how many they may make at once.
+		Rate  float64
+		Burst int
+	}
+
+	Quota struct {
+		// Requests a day each client may make, counted in Redis.
+		Daily int
+		// Quotas for particular clients, as "client:requests".
+		Limit  []string
+		Prefix string
+	}
+
+	// Caching headers for each class of route: "render", "skin" or
+	// "status".
+	CacheControl map[string]*CacheControl
+
+	// Extra headers for each class of route, as for CacheControl.
+	Headers map[string]*ResponseHeaders
+
+	// Further addresses to serve on, by name.
+	Listen map[string]*Listen
+
+	Cape struct {
+		// Where to look for capes, in order: "mojang", "optifine" or a
+		// [capeprovider] section.
+		Provider []string
+		// Whether to fetch players' Mojang capes alongside their skins.
+		WithSkin bool
+	}
+
+	Card struct {
+		// Colours of the /card/ link previews, as RGB hex.
+		Background string
+		Gradient   string
+		Text       string
+		Accent     string
+	}
+
+	GRPC struct {
+		// Address to serve the gRPC render API on, blank to not.
+		Address string
+	}
+
+	// Faults to inject when serving with -chaos.
+	Chaos struct {
+		// Milliseconds to delay upstream requests by.
+		Latency     int
+		LatencyRate float64
+		// Chance of an upstream request being rate limited, or failing.
+		RateLimitRate float64
+		ErrorRate     float64
+		// Chance of a cache read or write failing.
+		CacheErrorRate float64
+	}
+
+	// Third-party cape providers, by name.
+	CapeProvider map[string]*CapeProvider
+
+	// Renderer plugins, by the render type they draw.
+	Renderer map[string]*ExternalRenderer
+
+	// Widths each render may be asked for, by render or "default".
+	Size map[string]*RouteSize
+
+	// Named sets of render options, for ?preset=.
+	Preset map[string]*Preset
+
+	// Named backgrounds, for ?theme=.
+	Theme map[string]*Theme
+
+	// Customers sharing the instance, by name.
+	Tenant map[string]*Tenant
+
+	CORS struct {
+		Origin []string
+		Method []string
+		Header []string
+		MaxAge int
+	}
+
+	Security struct {
+		NoSniff        bool
+		ReferrerPolicy string
+		StatusCSP      string
+		// Seconds for Strict-Transport-Security, 0 to not send it.
+		HSTS int
+	}
+
+	Routes struct {
+		// Groups of routes not to serve, see routeGroups.
+		Disable []string
+	}
+
+	Alias struct {
+		// Paths to serve as others, as "/from/ -> /to/".
+		Route []string
+	}
+
+	AccessLog struct {
+		// File to write the access log to, blank to not keep one.
+		File string
+		// Megabytes and hours the file may reach before it's rotated, 0
+		// for no limit.
+		MaxSize int
+		MaxAge  int
+	}
+
+	StatsD struct {
+		// host:port of the StatsD server, blank to not send to one.
+		Address string
+		// Prefix for each metric's name.
+		Prefix string
+		// Seconds between sending the metrics.
+		FlushInterval int
+		// Whether to send labels as DogStatsD tags.
+		DogStatsD bool
+	}
+
+	Watch struct {
+		// Seconds between polls of watched players' profiles, 0 to disable
+		// the watcher.
+		Interval int
+		// File to keep the watches in, blank to keep them in memory.
+		File string
+		// Key to sign webhooks with, blank to not sign them.
+		Secret     string
+		SecretFile string
 	}
 }
 
-// Reads the configuration from the config file, copying a config into
-// place from the example if one does not yet exist.
+// Reads the configuration from config.toml if there is one, otherwise from
+// the config file, copying a config into place from the example if one does
+// not yet exist. IMGD_ environment variables override either.
 func (c *Configuration) load() error {
-	err := c.ensureConfigExists()
+	var err error
+	if _, statErr := os.Stat(configTOML); statErr == nil {
+		err = c.loadTOML(configTOML)
+	} else if err = c.ensureConfigExists(); err == nil {
+		err = gcfg.ReadFileInto(c, configFile)
+	}
+	if err == nil {
+		err = c.loadEnv(os.Environ())
+	}
+	c.dropBlankEntries()
+	if err == nil {
+		err = c.readSecretFiles()
+	}
+	return err
+}
+
+// Removes the blank entries gcfg gives lists left as "key =", which mean
+// "none" rather than one empty value.
+func (c *Configuration) dropBlankEntries() {
+	config := reflect.ValueOf(c).Elem()
+	for i := 0; i < config.NumField(); i++ {
+		section := config.Field(i)
+		if section.Kind() != reflect.Struct {
+			continue
+		}
+		for j := 0; j < section.NumField(); j++ {
+			list, ok := section.Field(j).Interface().([]string)
+			if !ok {
+				continue
+			}
+			kept := []string{}
+			for _, entry := range list {
+				if strings.TrimSpace(entry) != "" {
+					kept = append(kept, entry)
+				}
+			}
+			section.Field(j).Set(reflect.ValueOf(kept))
+		}
+	}
+}
+
+// Reads each secret with a file given for it, eg. [redis] authfile, from
+// that file, as Docker and Kubernetes mount secrets. Lists are read as a
+// line per entry.
+func (c *Configuration) readSecretFiles() error {
+	config := reflect.ValueOf(c).Elem()
+	for i := 0; i < config.NumField(); i++ {
+		section := config.Field(i)
+		if section.Kind() != reflect.Struct {
+			continue
+		}
+		sectionName := strings.ToLower(config.Type().Field(i).Name)
+		for j := 0; j < section.NumField(); j++ {
+			key := strings.ToLower(section.Type().Field(j).Name)
+			file := sectionField(section, key+"file")
+			if !secretOptions[sectionName+"."+key] || !file.IsValid() || file.String() == "" {
+				continue
+			}
+
+			field := section.Field(j)
+			if (field.Kind() == reflect.String && field.String() != "") || (field.Kind() == reflect.Slice && field.Len() > 0) {
+				return fmt.Errorf("[%s] %s and %sfile can't both be set", sectionName, key, key)
+			}
+			data, err := ioutil.ReadFile(file.String())
+			if err != nil {
+				return fmt.Errorf("[%s] %sfile: %v", sectionName, key, err)
+			}
+			// Editors and echo leave a newline on the end.
+			value := strings.TrimRight(string(data), "\r\n")
+			if field.Kind() == reflect.Slice {
+				list := []string{}
+				for _, line := range strings.Split(value, "\n") {
+					if line = strings.TrimSpace(line); line != "" {
+						list = append(list, line)
+					}
+				}
+				field.Set(reflect.ValueOf(list))
+			} else {
+				field.SetString(value)
+			}
+		}
+	}
+	return nil
+}
+
+// Reads a TOML config over the example's defaults, so it need only set what
+// differs. Its tables and keys are the example's sections and keys.
+func (c *Configuration) loadTOML(path string) error {
+	if err := gcfg.ReadStringInto(c, configDefaults); err != nil {
+		return err
+	}
+	meta, err := toml.DecodeFile(path, c)
 	if err != nil {
 		return err
 	}
-
-	return gcfg.ReadFileInto(c, configFile)
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, key := range undecoded {
+			keys[i] = key.String()
+		}
+		return fmt.Errorf("unknown keys in %s: %s", path, strings.Join(keys, ", "))
+	}
+	return nil
 }
 
 // Creates the config.json if it does not exist.
@@ -59,6 +564,55 @@ func (c *Configuration) ensureConfigExists() error {
 
 }
 
+// Returns the TTL in seconds as a Duration, or the server TTL if it isn't set.
+func ttlOrDefault(ttl int) time.Duration {
+	if ttl <= 0 {
+		ttl = config.Server.Ttl
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+// How long to cache skins we fetched successfully.
+func (c *Configuration) skinTtl() time.Duration {
+	return ttlOrDefault(c.Ttl.Skin)
+}
+
+// How long to remember which UUID a username belongs to.
+func (c *Configuration) uuidTtl() time.Duration {
+	return ttlOrDefault(c.Ttl.UUID)
+}
+
+// How long to remember a player's profile, apart from their skin.
+func (c *Configuration) profileTtl() time.Duration {
+	return ttlOrDefault(c.Ttl.Profile)
+}
+
+// How long to remember the usernames a player has gone by.
+func (c *Configuration) historyTtl() time.Duration {
+	return ttlOrDefault(c.Ttl.History)
+}
+
+// How long to remember that a player doesn't exist.
+func (c *Configuration) failedTtl() time.Duration {
+	return ttlOrDefault(c.Ttl.Failed)
+}
+
+// How long to remember that Mojang failed us for a player.
+func (c *Configuration) errorTtl() time.Duration {
+	return ttlOrDefault(c.Ttl.Error)
+}
+
+// How long past the skin TTL we'll serve a skin while refreshing it. Unlike
+// the others, this doesn't default to the server TTL.
+func (c *Configuration) staleTtl() time.Duration {
+	return time.Duration(c.Ttl.Stale) * time.Second
+}
+
+// Converts a config value in milliseconds to a Duration.
+func msDuration(ms int) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
+
 // Copies *only the contents* of one file to a new path.
 func copyFile(src string, dest string) error {
 	original, err := os.Open(src)
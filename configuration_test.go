@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadTOML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	os.WriteFile(path, []byte(`
+[server]
+address = "127.0.0.1:9000"
+trustedproxy = ["10.0.0.0/8", "192.168.0.0/16"]
+
+[cachecontrol.render]
+maxage = 60
+`), 0644)
+
+	var c Configuration
+	if err := c.loadTOML(path); err != nil {
+		t.Fatal(err)
+	}
+	if c.Server.Address != "127.0.0.1:9000" || len(c.Server.TrustedProxy) != 2 {
+		t.Fatalf("Expected the TOML's values, got %q and %v", c.Server.Address, c.Server.TrustedProxy)
+	}
+	if c.Server.Cache != "memory" || c.Ttl.Skin != 172800 {
+		t.Fatalf("Expected the example's defaults for the rest, got %q and %d", c.Server.Cache, c.Ttl.Skin)
+	}
+	if c.CacheControl["render"].MaxAge != 60 || c.CacheControl["skin"].MaxAge != 172800 {
+		t.Fatalf("Expected the render class replaced and the skin class kept")
+	}
+
+	os.WriteFile(path, []byte("[server]\nadress = \"127.0.0.1:9000\"\n"), 0644)
+	if err := new(Configuration).loadTOML(path); err == nil || !strings.Contains(err.Error(), "server.adress") {
+		t.Fatalf("Expected the misspelt key to be an error, got %v", err)
+	}
+}
+
+func TestExampleTOML(t *testing.T) {
+	var c Configuration
+	if err := c.loadTOML("config.example.toml"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "redis"), []byte("hunter2\n"), 0600)
+	os.WriteFile(filepath.Join(dir, "keys"), []byte("one\n\ntwo\n"), 0600)
+
+	var c Configuration
+	err := c.loadEnv([]string{
+		"IMGD_REDIS_AUTH_FILE=" + filepath.Join(dir, "redis"),
+		"IMGD_ADMIN_KEYFILE=" + filepath.Join(dir, "keys"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.readSecretFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Redis.Auth != "hunter2" {
+		t.Fatalf("Expected the password without its newline, got %q", c.Redis.Auth)
+	}
+	if len(c.Admin.Key) != 2 || c.Admin.Key[1] != "two" {
+		t.Fatalf("Expected a key per line, got %v", c.Admin.Key)
+	}
+
+	// The secrets are set now, so reading the files again would clash.
+	if err := c.readSecretFiles(); err == nil || !strings.Contains(err.Error(), "[redis] auth and authfile") {
+		t.Fatalf("Expected setting both to be an error, got %v", err)
+	}
+	c = Configuration{}
+	c.S3.SecretKeyFile = filepath.Join(dir, "missing")
+	if err := c.readSecretFiles(); err == nil || !strings.HasPrefix(err.Error(), "[s3] secretkeyfile") {
+		t.Fatalf("Expected a missing file to be an error, got %v", err)
+	}
+}
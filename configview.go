@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"gopkg.in/gcfg.v1"
+)
+
+// Options never shown in full, as "section.key".
+var secretOptions = map[string]bool{
+	"redis.auth":        true,
+	"auth.apikey":       true,
+	"auth.hmacsecret":   true,
+	"admin.key":         true,
+	"peer.key":          true,
+	"s3.accesskey":      true,
+	"s3.secretkey":      true,
+	"watch.secret":      true,
+	"offline.dsn":       true,
+	"offline.uploadkey": true,
+	// May have the proxy's credentials in its URL.
+	"minecraft.proxy": true,
+	// In every [tenant] section.
+	"tenant.apikey": true,
+}
+
+// Shown in place of a secret which is set.
+const redacted = "[redacted]"
+
+// An option's value as we're running with it, and where it came from:
+// "default" when it's as in the example config, "file" when the config file
+// changed it, or "env" when an IMGD_ environment variable did.
+type effectiveOption struct {
+	Value  interface{}
+	Source string
+}
+
+// Returns every option by section, eg. "server" or "cachecontrol.render",
+// then key.
+func (c *Configuration) effective() map[string]map[string]effectiveOption {
+	defaults := &Configuration{}
+	gcfg.ReadStringInto(defaults, configDefaults)
+	defaults.dropBlankEntries()
+
+	options := map[string]map[string]effectiveOption{}
+	describe := func(section string, v reflect.Value, d reflect.Value) {
+		options[section] = map[string]effectiveOption{}
+		for i := 0; i < v.NumField(); i++ {
+			key := strings.ToLower(v.Type().Field(i).Name)
+			option := effectiveOption{Value: v.Field(i).Interface(), Source: "file"}
+			if envOverridden[section+"."+key] {
+				option.Source = "env"
+			} else if d.IsValid() && reflect.DeepEqual(option.Value, d.Field(i).Interface()) {
+				option.Source = "default"
+			}
+			kind := strings.SplitN(section, ".", 2)[0]
+			if (secretOptions[section+"."+key] || secretOptions[kind+"."+key]) && !v.Field(i).IsZero() {
+				option.Value = redacted
+			}
+			options[section][key] = option
+		}
+	}
+
+	config, defaultConfig := reflect.ValueOf(c).Elem(), reflect.ValueOf(defaults).Elem()
+	for i := 0; i < config.NumField(); i++ {
+		section := strings.ToLower(config.Type().Field(i).Name)
+		switch field := config.Field(i); field.Kind() {
+		case reflect.Struct:
+			describe(section, field, defaultConfig.Field(i))
+		case reflect.Map:
+			for _, name := range field.MapKeys() {
+				if field.MapIndex(name).IsNil() {
+					continue
+				}
+				var d reflect.Value
+				if defaultSub := defaultConfig.Field(i).MapIndex(name); defaultSub.IsValid() && !defaultSub.IsNil() {
+					d = defaultSub.Elem()
+				}
+				describe(section+"."+name.String(), field.MapIndex(name).Elem(), d)
+			}
+		}
+	}
+	return options
+}
+
+// Returns a hash of every option's value, secrets redacted, so instances
+// whose configs have drifted apart can be told apart. It fits in a float64
+// without rounding, for the config_hash gauge.
+func (c *Configuration) hash() uint64 {
+	values := map[string]map[string]interface{}{}
+	for section, options := range c.effective() {
+		values[section] = map[string]interface{}{}
+		for key, option := range options {
+			values[section][key] = option.Value
+		}
+	}
+	// Maps are written in key order, so the same config always hashes the
+	// same.
+	encoded, _ := json.Marshal(values)
+	sum := sha256.Sum256(encoded)
+	return binary.BigEndian.Uint64(sum[:8]) >> 11
+}
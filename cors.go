@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Which other sites' pages may use what we serve, eg. to draw avatars onto
+// a <canvas> without tainting it.
+type CORS struct {
+	// Origins allowed, or "*" for any.
+	Origins []string
+	Methods []string
+	Headers []string
+	// Seconds browsers may cache a preflight for, 0 to leave it to them.
+	MaxAge int
+}
+
+// Allows the origins to use the methods and send the headers. Any left
+// empty default to what we've always allowed: any origin, GET, HEAD and
+// POST, and the usual request headers.
+func MakeCORS(origins []string, methods []string, headers []string, maxAge int) *CORS {
+	if len(origins) == 0 {
+		origins = []string{"*"}
+	}
+	if len(methods) == 0 {
+		methods = []string{"GET", "HEAD", "POST"}
+	}
+	if len(headers) == 0 {
+		headers = []string{"Accept", "Content-Type", "Content-Length", "Accept-Encoding"}
+	}
+	return &CORS{Origins: origins, Methods: methods, Headers: headers, MaxAge: maxAge}
+}
+
+// Returns what to send as Access-Control-Allow-Origin for the origin, or
+// "" if it isn't allowed.
+func (c *CORS) allowedOrigin(origin string) string {
+	for _, allowed := range c.Origins {
+		if allowed == "*" {
+			return "*"
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// Adds the CORS headers to responses, and answers preflights itself. The
+// admin routes are left alone, they're not for other sites to use.
+func (c *CORS) handler(router http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/admin/") {
+			router.ServeHTTP(w, r)
+			return
+		}
+
+		allowed := c.allowedOrigin(r.Header.Get("Origin"))
+		if allowed != "*" {
+			w.Header().Add("Vary", "Origin")
+		}
+		if allowed != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowed)
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(c.Methods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.Headers, ", "))
+		}
+
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			if allowed != "" && c.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(c.MaxAge))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		router.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	served := 0
+	handler := MakeCORS([]string{"https://example.com"}, nil, nil, 600).handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+	}))
+
+	r := httptest.NewRequest("GET", "/avatar/clone1018", nil)
+	r.Header.Set("Origin", "https://example.com")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://example.com" || w.Header().Get("Vary") != "Origin" {
+		t.Fatalf("Expected the origin to be allowed, got %v", w.Header())
+	}
+
+	r.Header.Set("Origin", "https://evil.example")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("Expected other origins not to be allowed")
+	}
+
+	r = httptest.NewRequest("OPTIONS", "/api/profiles", nil)
+	r.Header.Set("Origin", "https://example.com")
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Max-Age") != "600" || served != 2 {
+		t.Fatalf("Expected the preflight to be answered, got %d", w.Code)
+	}
+}
+
+func TestCORSDefaultsToAnyOrigin(t *testing.T) {
+	handler := MakeCORS(nil, nil, nil, 0).handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/skin/clone1018", nil))
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" || w.Header().Get("Access-Control-Allow-Methods") != "GET, HEAD, POST" {
+		t.Fatalf("Expected any origin to be allowed, got %v", w.Header())
+	}
+}
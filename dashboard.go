@@ -0,0 +1,58 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"time"
+)
+
+// Minutes of the time series the dashboard charts.
+const dashboardMinutes = 60
+
+// Looser than the status CSP, as the dashboard runs its own script and
+// styles, though still only from us.
+const dashboardCSP = "default-src 'none'; script-src 'self'; style-src 'self'; connect-src 'self'; frame-ancestors 'none'"
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// What the dashboard polls for.
+type dashboardData struct {
+	Status statusInfo
+	// Seconds each slot covers, and the slots, oldest first.
+	Interval int
+	Slots    []timeSeriesSlot
+	// Most requested players, nil if they aren't being tracked.
+	TopPlayers []topPlayer
+}
+
+// Serves the dashboard's page, script and styles.
+func dashboardHandler() http.Handler {
+	files, _ := fs.Sub(dashboardFiles, "dashboard")
+	fileServer := http.StripPrefix("/admin/dashboard/", http.FileServer(http.FS(files)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", dashboardCSP)
+		w.Header().Set("Cache-Control", "no-store")
+		fileServer.ServeHTTP(w, r)
+	})
+}
+
+// DashboardDataPage gives the dashboard what we know, for it to show.
+func (router *Router) DashboardDataPage(w http.ResponseWriter, r *http.Request) {
+	slots := stats.TimeSeries.Slots(time.Now())
+	data := dashboardData{
+		Status:   stats.snapshot(),
+		Interval: timeSeriesInterval,
+		Slots:    slots[len(slots)-dashboardMinutes:],
+	}
+	if topPlayers != nil {
+		data.TopPlayers = topPlayers.list(10)
+	}
+
+	page, _ := json.Marshal(data)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(page)
+}
@@ -0,0 +1,123 @@
+// Polls /admin/dashboard/data and draws what it says. Kept free of any
+// framework so it can be embedded in the binary as is.
+"use strict";
+
+const refreshInterval = 10000;
+
+function $(id) {
+  return document.getElementById(id);
+}
+
+function formatDuration(seconds) {
+  const days = Math.floor(seconds / 86400);
+  const hours = Math.floor(seconds % 86400 / 3600);
+  const minutes = Math.floor(seconds % 3600 / 60);
+  if (days > 0) {
+    return days + "d " + hours + "h";
+  }
+  if (hours > 0) {
+    return hours + "h " + minutes + "m";
+  }
+  return minutes + "m " + seconds % 60 + "s";
+}
+
+function formatBytes(bytes) {
+  const units = ["B", "KB", "MB", "GB"];
+  let unit = 0;
+  while (bytes >= 1024 && unit < units.length - 1) {
+    bytes /= 1024;
+    unit++;
+  }
+  return bytes.toFixed(unit ? 1 : 0) + " " + units[unit];
+}
+
+// Replaces the table's rows with one per pair of cells.
+function fillTable(table, rows, empty) {
+  table.replaceChildren();
+  if (rows.length === 0) {
+    rows = [[empty, ""]];
+  }
+  for (const cells of rows) {
+    const row = table.insertRow();
+    for (const cell of cells) {
+      const td = row.insertCell();
+      if (cell instanceof Node) {
+        td.appendChild(cell);
+      } else {
+        td.textContent = cell;
+      }
+    }
+  }
+}
+
+function status(text, good) {
+  const span = document.createElement("span");
+  span.textContent = text;
+  span.className = good ? "good" : "bad";
+  return span;
+}
+
+function drawChart(slots) {
+  const chart = $("chart");
+  const max = Math.max(1, ...slots.map(slot => slot.Requests));
+  const points = field => slots.map((slot, i) =>
+    (i / Math.max(1, slots.length - 1) * 600).toFixed(1) + "," + (115 - slot[field] / max * 110).toFixed(1)).join(" ");
+
+  chart.replaceChildren();
+  for (const field of ["Requests", "Errors"]) {
+    const line = document.createElementNS("http://www.w3.org/2000/svg", "polyline");
+    line.setAttribute("class", field.toLowerCase());
+    line.setAttribute("points", points(field));
+    chart.appendChild(line);
+  }
+}
+
+function render(data) {
+  const info = data.Status;
+  $("version").textContent = "v" + info.Version + (info.GitCommit ? " (" + info.GitCommit.slice(0, 7) + ")" : "");
+  $("uptime").textContent = formatDuration(info.Uptime);
+  // The current minute is still filling up, so rate the one before it.
+  const slots = data.Slots;
+  $("rate").textContent = slots.length > 1 ? slots[slots.length - 2].Requests : "-";
+  $("hitratio").textContent = (info.CacheHitRatio * 100).toFixed(1) + "%";
+  $("cachesize").textContent = info.CacheSize + " (" + info.CacheBackend + ")";
+  $("memory").textContent = formatBytes(info.ImgdMem);
+  drawChart(slots);
+
+  const upstream = [
+    ["Mojang", status(info.UpstreamAvailability || "unknown", info.UpstreamAvailability === "up")],
+    ["Circuit", status(info.UpstreamCircuit, info.UpstreamCircuit === "closed")],
+    ["Backing off", status(info.UpstreamBlocked > 0 ? info.UpstreamBlocked.toFixed(0) + "s" : "no", info.UpstreamBlocked === 0)],
+    ["Budget left", info.UpstreamBudget < 0 ? "uncapped" : info.UpstreamBudget],
+  ];
+  for (const [mirror, healthy] of Object.entries(info.MirrorHealthy || {})) {
+    upstream.push(["Mirror " + mirror, status(healthy ? "healthy" : "unhealthy", healthy)]);
+  }
+  fillTable($("upstream"), upstream, "");
+
+  if (data.TopPlayers === null) {
+    fillTable($("players"), [], "Not being tracked");
+  } else {
+    fillTable($("players"), data.TopPlayers.map(player => [player.Name, player.Count]), "None yet");
+  }
+
+  fillTable($("errors"), (info.RecentErrors || []).map(error =>
+    [error.Type, new Date(error.Time).toLocaleTimeString()]), "None");
+
+  $("updated").textContent = "Updated " + new Date().toLocaleTimeString();
+}
+
+async function refresh() {
+  try {
+    const response = await fetch("data", {cache: "no-store"});
+    if (!response.ok) {
+      throw new Error(response.status + " " + response.statusText);
+    }
+    render(await response.json());
+  } catch (err) {
+    $("updated").textContent = "Update failed: " + err.message;
+  }
+}
+
+refresh();
+setInterval(refresh, refreshInterval);
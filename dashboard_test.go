@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestDashboard(t *testing.T) {
+	stats = MakeStatsCollector()
+	topPlayers = nil
+	adminKeys = MakeAPIKeyAuthenticator([]string{"ops:adm1n"})
+	defer func() { adminKeys = nil }()
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.BindAdmin()
+	serve := func(path string, password string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+		if password != "" {
+			r.SetBasicAuth("", password)
+		}
+		router.Mux.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("/admin/dashboard/", "")
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("Expected browsers to be asked for a key, got %d", w.Code)
+	}
+
+	w = serve("/admin/dashboard/", "adm1n")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "dashboard.js") {
+		t.Fatalf("Expected the dashboard page, got %d", w.Code)
+	}
+	if !strings.Contains(w.Header().Get("Content-Security-Policy"), "script-src 'self'") {
+		t.Fatalf("Expected the dashboard's own CSP, got %q", w.Header().Get("Content-Security-Policy"))
+	}
+	if w = serve("/admin/dashboard/dashboard.js", "adm1n"); w.Code != http.StatusOK {
+		t.Fatalf("Expected the dashboard's script, got %d", w.Code)
+	}
+
+	stats.Errored("First")
+	stats.Errored("Second")
+	w = serve("/admin/dashboard/data", "adm1n")
+	data := dashboardData{}
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Slots) != dashboardMinutes || data.TopPlayers != nil {
+		t.Fatalf("Expected an hour of slots and no top players, got %d", len(data.Slots))
+	}
+	if len(data.Status.RecentErrors) != 3 || data.Status.RecentErrors[0].Type != "Second" || data.Status.RecentErrors[2].Type != string(ErrAuthDenied) {
+		t.Fatalf("Expected the recent errors newest first, got %+v", data.Status.RecentErrors)
+	}
+}
+
+func TestRecentErrorsWrap(t *testing.T) {
+	errors := recentErrors{}
+	for i := 0; i < recentErrorCount+5; i++ {
+		errors.add(string(rune('a'+i)), time.Unix(int64(i), 0))
+	}
+	list := errors.list()
+	if len(list) != recentErrorCount || list[0].Type != string(rune('a'+recentErrorCount+4)) {
+		t.Fatalf("Expected the last %d errors, newest first, got %+v", recentErrorCount, list)
+	}
+}
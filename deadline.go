@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Header trusted callers can set to cap, in milliseconds, how long we spend
+// on their request.
+const DeadlineHeader = "X-Imgd-Deadline"
+
+// Networks allowed to set a deadline, in addition to authenticated callers.
+var deadlineTrusted []*net.IPNet
+
+// Returns the budget the caller gave us, if they're trusted to set one.
+func requestDeadline(r *http.Request) (time.Duration, bool) {
+	header := r.Header.Get(DeadlineHeader)
+	if header == "" {
+		return 0, false
+	}
+
+	ip := net.ParseIP(clientIP(r))
+	if authIdentity(r) == "" && (ip == nil || !containsIP(deadlineTrusted, ip)) {
+		return 0, false
+	}
+
+	ms, err := strconv.ParseUint(header, 10, 32)
+	if err != nil || ms == 0 {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+// Gives each request, bar the admin routes, the timeout before its context
+// is done, so requests held up by a slow upstream or a busy render pool
+// give up rather than tie the instance up. Profiling takes as long as it's
+// asked to, so the admin routes are left alone.
+func timeoutHandler(timeout time.Duration, next http.Handler) http.Handler {
+	if timeout <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Caps the request's context at the deadline the caller set, if they're
+// trusted to, so it bounds the whole of the request: fetching the skin,
+// waiting on the render pool, and rendering. Must come after authHandler,
+// as authenticated callers are trusted.
+func deadlineHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		budget, ok := requestDeadline(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), budget)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Fetches the skin for the request. If the request's context is done while
+// we're still waiting on upstream, eg. as the caller's deadline passed, we
+// give up and serve Steve. The fetch carries on in the background so the
+// cache is warm next time.
+func fetchSkinForRequest(r *http.Request, username string, usePeers bool) *mcSkin {
+	if offlineRequested(r) && !isBedrockGamertag(username) {
+		if _, ok := normalizeUUID(username); !ok {
+			username = offlineUUID(username)
+		}
+	}
+	return fetchSkinUntil(requestContext(r), r.Context(), username, usePeers)
+}
+
+// Fetches the skin with ctx, but only waits for it until wait is done,
+// serving the fallback if it's not fetched by then.
+func fetchSkinUntil(ctx context.Context, wait context.Context, username string, usePeers bool) *mcSkin {
+	if wait.Done() == nil {
+		return fetchSkinVia(ctx, username, usePeers)
+	}
+
+	result := make(chan *mcSkin, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				logPanic(ctx, "fetch", p)
+				uuid, _ := lookupUUID(username)
+				result <- fallbackFor(uuid)
+			}
+		}()
+		result <- fetchSkinVia(ctx, username, usePeers)
+	}()
+
+	select {
+	case skin := <-result:
+		return skin
+	case <-wait.Done():
+		if wait.Err() == context.DeadlineExceeded {
+			log.Infof("Deadline passed fetching %s", username)
+			stats.Errored(ErrUpstreamTimeout)
+		}
+		uuid, _ := lookupUUID(username)
+		return fallbackFor(uuid)
+	}
+}
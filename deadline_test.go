@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/minotar/minecraft"
+)
+
+func TestTimeoutHandler(t *testing.T) {
+	deadlines := map[string]bool{}
+	handler := timeoutHandler(time.Minute, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, deadlines[r.URL.Path] = r.Context().Deadline()
+	}))
+	for _, path := range []string{"/avatar/clone1018", "/admin/debug/pprof/profile"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	if !deadlines["/avatar/clone1018"] || deadlines["/admin/debug/pprof/profile"] {
+		t.Fatalf("Expected only the image route to get a deadline, got %v", deadlines)
+	}
+}
+
+func TestFetchSkinForRequestGivesUp(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	missingFilter = MakeMissingFilter(0, time.Minute)
+	upstream = MakeUpstream(0, 0)
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	defer close(release)
+	config.Minecraft.SessionServerURL = server.URL + "/profile/"
+	mcClient = &minecraft.Minecraft{Client: server.Client()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	r := httptest.NewRequest("GET", "/avatar/853c80ef3c3749fdaa49938b674adae6", nil).WithContext(ctx)
+
+	start := time.Now()
+	skin := fetchSkinForRequest(r, "853c80ef3c3749fdaa49938b674adae6", false)
+	if !skin.Fallback {
+		t.Fatal("Expected the fallback skin once the request ran out of time")
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Fatalf("Expected to give up at the deadline, took %s", took)
+	}
+}
+
+func TestDeadlineHandler(t *testing.T) {
+	deadlineTrusted, _ = parseCIDRs([]string{"198.51.100.0/24"})
+	defer func() { deadlineTrusted = nil }()
+	var capped bool
+	handler := deadlineHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, capped = r.Context().Deadline()
+	}))
+
+	// Anyone else's header is ignored.
+	r := httptest.NewRequest("GET", "/avatar/clone1018", nil)
+	r.Header.Set(DeadlineHeader, "50")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if capped {
+		t.Fatal("Expected an untrusted caller's deadline to be ignored")
+	}
+
+	r.RemoteAddr = "198.51.100.1:1234"
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if !capped {
+		t.Fatal("Expected a trusted network's deadline to cap the request")
+	}
+
+	r = httptest.NewRequest("GET", "/avatar/clone1018", nil)
+	r.Header.Set(DeadlineHeader, "50")
+	r = r.WithContext(context.WithValue(r.Context(), authContextKey{}, "ops"))
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if !capped {
+		t.Fatal("Expected an authenticated caller's deadline to cap the request")
+	}
+}
+
+func TestDeadlineServesFallback(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	missingFilter = MakeMissingFilter(0, time.Minute)
+	upstream = MakeUpstream(0, 0)
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	defer close(release)
+	config.Minecraft.SessionServerURL = server.URL + "/profile/"
+	mcClient = &minecraft.Minecraft{Client: server.Client()}
+
+	var skin *mcSkin
+	handler := deadlineHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		skin = fetchSkinForRequest(r, "853c80ef3c3749fdaa49938b674adae6", false)
+	}))
+	r := httptest.NewRequest("GET", "/avatar/853c80ef3c3749fdaa49938b674adae6", nil)
+	r.Header.Set(DeadlineHeader, "50")
+	r = r.WithContext(context.WithValue(r.Context(), authContextKey{}, "ops"))
+
+	start := time.Now()
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if !skin.Fallback {
+		t.Fatal("Expected the fallback skin at the caller's deadline")
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Fatalf("Expected to give up at the deadline, took %s", took)
+	}
+}
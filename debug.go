@@ -0,0 +1,37 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"sync"
+)
+
+// Serves the net/http/pprof profiles under /admin/debug/pprof/, so they can
+// be taken from a running instance, eg. with
+// go tool pprof -H "X-Admin-Key: <key>" https://host/admin/debug/pprof/heap
+func pprofHandler() http.HandlerFunc {
+	m := http.NewServeMux()
+	m.HandleFunc("/debug/pprof/", pprof.Index)
+	m.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	m.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	m.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	m.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	// pprof.Index finds the profile to serve by its path under
+	// /debug/pprof/.
+	return http.StripPrefix("/admin", m).ServeHTTP
+}
+
+var publishVars sync.Once
+
+// Serves expvar's /debug/vars, with what /stats reports as "imgd" alongside
+// Go's own memstats and cmdline, for tools which already know how to read it.
+func expvarHandler() http.Handler {
+	// Publishing a name twice panics, and stats is replaced in tests.
+	publishVars.Do(func() {
+		expvar.Publish("imgd", expvar.Func(func() interface{} {
+			return stats.snapshot()
+		}))
+	})
+	return expvar.Handler()
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/minotar/imgd/pkg/mcskin"
+)
+
+// A request made with ?debug=1, by a client we know, gets what became of it
+// as JSON instead of the image: the caches we looked in, what we asked
+// upstream and how long it took, the skin we settled on and how the render
+// went. It's for working out why one player's avatar is wrong, or slow,
+// without trawling the logs.
+
+type debugTraceContextKey struct{}
+
+// A lookup in one of our caches.
+type debugLookup struct {
+	Cache string
+	Key   string
+	Hit   bool
+	// Seconds it took.
+	Took float64
+}
+
+// A request to Mojang, a mirror or anything else we asked on the client's
+// behalf. Requests we shared with another client's, already in flight, are
+// traced for theirs.
+type debugCall struct {
+	Request string
+	Status  int    `json:",omitempty"`
+	Error   string `json:",omitempty"`
+	Took    float64
+}
+
+// A stage of fetching the skin, or of rendering it.
+type debugStage struct {
+	Name string
+	Took float64
+}
+
+// What we did for a request, as it's traced.
+type debugTrace struct {
+	mu    sync.Mutex
+	start time.Time
+
+	lookups  []debugLookup
+	upstream []debugCall
+	fetch    []debugStage
+	render   []debugStage
+	// The model the skin's for, slim or classic, and whether it's the one
+	// we fell back on.
+	model    string
+	fallback bool
+}
+
+func withDebugTrace(ctx context.Context, trace *debugTrace) context.Context {
+	if trace == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, debugTraceContextKey{}, trace)
+}
+
+// Returns the trace of the request the context is for, or nil if it isn't
+// being traced. Its methods do nothing on nil, so callers needn't check.
+func debugTraceFrom(ctx context.Context) *debugTrace {
+	trace, _ := ctx.Value(debugTraceContextKey{}).(*debugTrace)
+	return trace
+}
+
+// Notes a lookup in the cache, which started at start.
+func (t *debugTrace) lookup(cache string, key string, hit bool, start time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lookups = append(t.lookups, debugLookup{Cache: cache, Key: key, Hit: hit, Took: time.Since(start).Seconds()})
+}
+
+// Notes a request upstream, which started at start. The status is 0 if we
+// never got a response.
+func (t *debugTrace) call(request string, status int, err error, start time.Time) {
+	if t == nil {
+		return
+	}
+	call := debugCall{Request: request, Status: status, Took: time.Since(start).Seconds()}
+	if err != nil {
+		call.Error = err.Error()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.upstream = append(t.upstream, call)
+}
+
+// Notes a stage of fetching the skin, which took took.
+func (t *debugTrace) fetchStage(name string, took time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.fetch = append(t.fetch, debugStage{Name: name, Took: took.Seconds()})
+}
+
+// Notes a stage of rendering, which took took.
+func (t *debugTrace) renderStage(name string, took time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.render = append(t.render, debugStage{Name: name, Took: took.Seconds()})
+}
+
+// Notes how long each stage of the render took.
+func (t *debugTrace) noteRender(timings mcskin.Timings) {
+	t.renderStage("extract", timings.Extract)
+	t.renderStage("composite", timings.Composite)
+	t.renderStage("scale", timings.Scale)
+	t.renderStage("encode", timings.Encode)
+}
+
+// Notes the skin we're rendering.
+func (t *debugTrace) noteSkin(skin *mcSkin) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.model = "classic"
+	if skin.Slim {
+		t.model = "slim"
+	}
+	t.fallback = skin.Fallback
+}
+
+// The trace as the client gets it.
+type debugReport struct {
+	RequestID string
+	Route     string
+	Player    string
+	Identity  string
+	// What we'd have answered with, had they not asked for the trace.
+	Status  int
+	Size    int
+	Headers http.Header
+	// Where the skin came from, and whether it was cached.
+	Source    string `json:",omitempty"`
+	SkinCache string `json:",omitempty"`
+	Texture   string `json:",omitempty"`
+	Model     string `json:",omitempty"`
+	Fallback  bool
+	// Which cache the render came from, if any.
+	RenderCache string `json:",omitempty"`
+
+	Lookups  []debugLookup
+	Upstream []debugCall
+	Fetch    []debugStage
+	Render   []debugStage
+	// Seconds the whole request took.
+	Took float64
+}
+
+func (t *debugTrace) report(r *http.Request, response *bufferedResponse) debugReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	record := accessRecordFor(r)
+	status := response.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return debugReport{
+		RequestID:   requestIDFrom(r.Context()),
+		Route:       record.Route,
+		Player:      record.Player,
+		Identity:    record.Identity,
+		Status:      status,
+		Size:        response.body.Len(),
+		Headers:     response.header,
+		Source:      record.Source,
+		SkinCache:   record.SkinCache,
+		Texture:     record.Texture,
+		Model:       t.model,
+		Fallback:    t.fallback,
+		RenderCache: record.Cache,
+		Lookups:     append([]debugLookup{}, t.lookups...),
+		Upstream:    append([]debugCall{}, t.upstream...),
+		Fetch:       append([]debugStage{}, t.fetch...),
+		Render:      append([]debugStage{}, t.render...),
+		Took:        time.Since(t.start).Seconds(),
+	}
+}
+
+// Answers requests asking for ?debug=1 with their trace. Only clients the
+// auth chain identified may ask, as the trace shows our workings. Signed
+// URLs are handed out to the public, so don't count. The request is served
+// as it would have been without the parameter, but in full, whatever it
+// says it already has.
+func debugHandler(router http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("debug") != "1" {
+			router.ServeHTTP(w, r)
+			return
+		}
+		if identity := authIdentity(r); identity == "" || identity == "hmac" {
+			writeError(w, r, http.StatusForbidden, ErrForbidden, "debug traces need an API key")
+			stats.Errored(ErrAuthDenied)
+			return
+		}
+
+		trace := &debugTrace{start: time.Now()}
+		r = r.Clone(withDebugTrace(r.Context(), trace))
+		query.Del("debug")
+		r.URL.RawQuery = query.Encode()
+		for _, header := range []string{"If-None-Match", "If-Modified-Since", "If-Range", "Range"} {
+			r.Header.Del(header)
+		}
+		response := newBufferedResponse()
+		router.ServeHTTP(response, r)
+
+		body, _ := json.MarshalIndent(trace.report(r, response), "", "  ")
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(body)
+	})
+}
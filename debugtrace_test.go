@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/minecraft"
+)
+
+func TestDebugTrace(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	renderCache = MakeRenderCache(1<<20, false)
+	hotCache = MakeHotCache(nil, 0)
+	missingFilter = MakeMissingFilter(0, time.Minute)
+
+	skin, _ := minecraft.FetchSkinForSteve()
+	skin.Hash = "clone1018"
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+	chain := []Authenticator{MakeAPIKeyAuthenticator([]string{"ops:secret"})}
+	handler := accessLogHandler(authHandler(chain, false, debugHandler(router.Mux)))
+
+	serve := func(path string, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		r.Header.Set("If-None-Match", "*")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := serve("/avatar/d9135e082f2244c89cb10d21ed3ac8fd/64?debug=1", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected a trace without a key to be refused, got %d", w.Code)
+	}
+	if w := serve("/avatar/d9135e082f2244c89cb10d21ed3ac8fd/64", "secret"); w.Code != http.StatusNotModified {
+		t.Fatalf("Expected a request without ?debug to be served as usual, got %d", w.Code)
+	}
+
+	for _, cached := range []bool{false, true} {
+		w := serve("/avatar/d9135e082f2244c89cb10d21ed3ac8fd/64?debug=1", "secret")
+		report := debugReport{}
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || w.Header().Get("Content-Type") != "application/json" {
+			t.Fatalf("Expected a JSON trace, got %q", w.Body.String())
+		}
+		if report.Status != http.StatusOK || report.Size == 0 || report.Headers.Get("Content-Type") != "image/png" {
+			t.Fatalf("Expected the render to be served in full, got %+v", report)
+		}
+		if report.Identity != "ops" || report.Model == "" || report.SkinCache != "hit" {
+			t.Fatalf("Expected the skin we rendered, got %+v", report)
+		}
+
+		lookups := map[string]bool{}
+		for _, lookup := range report.Lookups {
+			lookups[lookup.Cache] = lookup.Hit
+		}
+		if !lookups["uuid"] || !lookups["skin"] || lookups["render"] != cached {
+			t.Fatalf("Expected the cache lookups, got %+v", report.Lookups)
+		}
+		if cached && len(report.Render) != 0 {
+			t.Fatalf("Expected no render stages for a cached render, got %+v", report.Render)
+		}
+		if !cached && (len(report.Render) != 4 || report.Render[3].Name != "encode") {
+			t.Fatalf("Expected the render's stages, got %+v", report.Render)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Prefix of the environment variables which override the config.
+const envPrefix = "IMGD_"
+
+// Returned for environment variables which don't name an option.
+var errNoEnvOption = errors.New("no such option")
+
+// Options set from the environment, as "section.key" or
+// "section.subsection.key".
+var envOverridden = map[string]bool{}
+
+// Overrides the config from IMGD_<SECTION>_<KEY> environment variables, eg.
+// IMGD_REDIS_ADDRESS, or IMGD_CACHECONTROL_RENDER_MAXAGE for a subsection,
+// so a container can be configured without a config file of its own. Lists
+// are comma separated. Secrets can be given as IMGD_<SECTION>_<KEY>_FILE,
+// eg. IMGD_REDIS_AUTH_FILE, to read them from a file rather than have them
+// show up in the environment. Variables which don't name an option, which
+// may well be meant for something else, are warned about and ignored.
+func (c *Configuration) loadEnv(environ []string) error {
+	for _, entry := range environ {
+		name, value, _ := strings.Cut(entry, "=")
+		if !strings.HasPrefix(name, envPrefix) {
+			continue
+		}
+		key := strings.ToLower(strings.TrimPrefix(name, envPrefix))
+		field, option, err := c.envField(key)
+		if secret, isFile := strings.CutSuffix(key, "_file"); err != nil && isFile {
+			// The same as the option's file option, eg. [redis] authfile.
+			field, option, err = c.envField(secret + "file")
+		}
+		if errors.Is(err, errNoEnvOption) {
+			log.Warningf("Ignoring %s, as there's no such option", name)
+			continue
+		} else if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if err := setConfigField(field, value); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		envOverridden[option] = true
+	}
+	return nil
+}
+
+// Finds the option named section_key or section_subsection_key, and returns
+// it with its name as section.key or section.subsection.key.
+func (c *Configuration) envField(name string) (reflect.Value, string, error) {
+	config := reflect.ValueOf(c).Elem()
+	for i := 0; i < config.NumField(); i++ {
+		sectionName := strings.ToLower(config.Type().Field(i).Name)
+		key, found := strings.CutPrefix(name, sectionName+"_")
+		if !found {
+			continue
+		}
+		section := config.Field(i)
+		if section.Kind() == reflect.Map {
+			cut := strings.LastIndex(key, "_")
+			if cut < 0 {
+				return reflect.Value{}, "", fmt.Errorf("no subsection given")
+			}
+			// A new subsection's only added once we know it has the key,
+			// so a mistyped name doesn't leave an empty one behind.
+			subsection, value := reflect.ValueOf(key[:cut]), reflect.Value{}
+			if !section.IsNil() {
+				value = section.MapIndex(subsection)
+			}
+			fresh := !value.IsValid()
+			if fresh {
+				value = reflect.New(section.Type().Elem().Elem())
+			}
+			field := sectionField(value.Elem(), key[cut+1:])
+			if !field.IsValid() {
+				continue
+			}
+			if fresh {
+				if section.IsNil() {
+					section.Set(reflect.MakeMap(section.Type()))
+				}
+				section.SetMapIndex(subsection, value)
+			}
+			return field, sectionName + "." + key[:cut] + "." + key[cut+1:], nil
+		}
+		if field := sectionField(section, key); field.IsValid() {
+			return field, sectionName + "." + key, nil
+		}
+	}
+	return reflect.Value{}, "", errNoEnvOption
+}
+
+func sectionField(section reflect.Value, key string) reflect.Value {
+	for i := 0; i < section.NumField(); i++ {
+		if strings.ToLower(section.Type().Field(i).Name) == key {
+			return section.Field(i)
+		}
+	}
+	return reflect.Value{}
+}
+
+func setConfigField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		// Each item is parsed as the slice's elements are, eg. hotsize's
+		// widths.
+		list := reflect.MakeSlice(field.Type(), 0, 0)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			elem := reflect.New(field.Type().Elem()).Elem()
+			if err := setConfigField(elem, item); err != nil {
+				return err
+			}
+			list = reflect.Append(list, elem)
+		}
+		field.Set(list)
+	default:
+		return fmt.Errorf("can't be set from the environment")
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadEnv(t *testing.T) {
+	var c Configuration
+	err := c.loadEnv([]string{
+		"PATH=/usr/bin",
+		"IMGD_SERVER_ADDRESS=127.0.0.1:9000",
+		"IMGD_SERVER_H2C=true",
+		"IMGD_REDIS_POOLSIZE=20",
+		"IMGD_SERVER_TRUSTEDPROXY=10.0.0.0/8, 192.168.0.0/16",
+		"IMGD_CACHECONTROL_RENDER_MAXAGE=60",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Server.Address != "127.0.0.1:9000" || !c.Server.H2C || c.Redis.PoolSize != 20 {
+		t.Fatalf("Expected the overrides, got %q, %v and %d", c.Server.Address, c.Server.H2C, c.Redis.PoolSize)
+	}
+	if len(c.Server.TrustedProxy) != 2 || c.Server.TrustedProxy[1] != "192.168.0.0/16" {
+		t.Fatalf("Expected a list of two proxies, got %v", c.Server.TrustedProxy)
+	}
+	if c.CacheControl["render"] == nil || c.CacheControl["render"].MaxAge != 60 {
+		t.Fatal("Expected the render class's max age to be set")
+	}
+
+	// Ones which aren't options are ignored.
+	if err := c.loadEnv([]string{"IMGD_SERVER_ADRESS=x", "IMGD_VERSION=2"}); err != nil {
+		t.Fatalf("Expected unknown variables to be ignored, got %v", err)
+	}
+	if envOverridden["server.adress"] {
+		t.Fatal("Expected the unknown variable not to be counted as an override")
+	}
+	if err := c.loadEnv([]string{"IMGD_TENANT_FOO_BAR=x"}); err != nil || c.Tenant["foo"] != nil {
+		t.Fatalf("Expected a mistyped subsection option not to add the subsection, got %v", err)
+	}
+
+	for _, entry := range []string{"IMGD_CACHECONTROL_MAXAGE=60", "IMGD_REDIS_POOLSIZE=lots"} {
+		if err := c.loadEnv([]string{entry}); err == nil || !strings.HasPrefix(err.Error(), strings.Split(entry, "=")[0]) {
+			t.Fatalf("Expected %s to be an error, got %v", entry, err)
+		}
+	}
+}
+
+func TestLoadEnvNumbers(t *testing.T) {
+	var c Configuration
+	err := c.loadEnv([]string{
+		"IMGD_SERVER_HOTSIZE=32, 64",
+		"IMGD_MINECRAFT_MAXTEXTURESIZE=1048576",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Server.HotSize) != 2 || c.Server.HotSize[0] != 32 || c.Server.HotSize[1] != 64 {
+		t.Fatalf("Expected two hot widths, got %v", c.Server.HotSize)
+	}
+	if c.Minecraft.MaxTextureSize != 1048576 {
+		t.Fatalf("Expected the largest texture to be set, got %d", c.Minecraft.MaxTextureSize)
+	}
+	if err := c.loadEnv([]string{"IMGD_SERVER_HOTSIZE=32,-64"}); err == nil {
+		t.Fatal("Expected a negative width to be refused")
+	}
+}
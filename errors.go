@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Header responses carry the code of what went wrong in.
+const ErrorHeader = "X-Imgd-Error"
+
+// A stable name for what went wrong. Errors are counted by code, and
+// clients are told it in the X-Imgd-Error header, so neither dashboards nor
+// clients need to match on messages, which may change.
+type ErrorCode string
+
+// What clients are told went wrong.
+const (
+	// Mojang, and any mirrors, didn't answer in time.
+	ErrUpstreamTimeout ErrorCode = "upstream_timeout"
+	ErrUserNotFound    ErrorCode = "user_not_found"
+	// The client's over their rate limit or daily quota.
+	ErrRateLimited  ErrorCode = "rate_limited"
+	ErrBadRequest   ErrorCode = "bad_request"
+	ErrRenderFailed ErrorCode = "render_failed"
+	// Too busy rendering to take the request, try again shortly.
+	ErrBusy      ErrorCode = "busy"
+	ErrForbidden ErrorCode = "forbidden"
+	// The route needs a feature the config hasn't turned on.
+	ErrNotEnabled  ErrorCode = "not_enabled"
+	ErrJobNotFound ErrorCode = "job_not_found"
+	// The render job's result was asked for before it's done.
+	ErrJobPending ErrorCode = "job_pending"
+	ErrInternal   ErrorCode = "internal_error"
+)
+
+// Errors only counted, as clients see a fallback or nothing at all.
+const (
+	ErrAuthDenied          ErrorCode = "auth_denied"
+	ErrIPFiltered          ErrorCode = "ip_filtered"
+	ErrSignatureInvalid    ErrorCode = "signature_invalid"
+	ErrQuotaExceeded       ErrorCode = "quota_exceeded"
+	ErrQuota               ErrorCode = "quota"
+	ErrRenderQueueFull     ErrorCode = "render_queue_full"
+	ErrRendererPlugin      ErrorCode = "renderer_plugin"
+	ErrRenderJobs          ErrorCode = "render_jobs"
+	ErrTenantRoute         ErrorCode = "tenant_route"
+	ErrPanic               ErrorCode = "panic"
+	ErrUpstreamRateLimited ErrorCode = "upstream_rate_limited"
+	ErrUpstreamBlocked     ErrorCode = "upstream_blocked"
+	ErrUpstreamBudget      ErrorCode = "upstream_budget"
+	ErrCircuitOpen         ErrorCode = "circuit_open"
+	ErrLookupUUID          ErrorCode = "lookup_uuid"
+	ErrLookupUUIDMirror    ErrorCode = "lookup_uuid_mirror"
+	ErrLookupBulkUUID      ErrorCode = "lookup_bulk_uuid"
+	ErrLookupXUID          ErrorCode = "lookup_xuid"
+	ErrSkinSessionProfile  ErrorCode = "skin_session_profile"
+	ErrSkinMirror          ErrorCode = "skin_mirror"
+	ErrMirrorHistory       ErrorCode = "mirror_history"
+	ErrSkinTexture         ErrorCode = "skin_texture"
+	ErrBedrockSkin         ErrorCode = "bedrock_skin"
+	ErrCape                ErrorCode = "cape"
+	ErrPeer                ErrorCode = "peer"
+	ErrFallbackSteve       ErrorCode = "fallback_steve"
+	ErrInvalidTexture      ErrorCode = "invalid_texture"
+	ErrTextureHash         ErrorCode = "texture_hash"
+	ErrTextureSignature    ErrorCode = "texture_signature"
+	ErrSkinStore           ErrorCode = "skin_store"
+	ErrCacheDisk           ErrorCode = "cache_disk"
+	ErrCacheMemcached      ErrorCode = "cache_memcached"
+	ErrCacheS3             ErrorCode = "cache_s3"
+	ErrCacheS3WriteDropped ErrorCode = "cache_s3_write_dropped"
+	ErrCacheFlush          ErrorCode = "cache_flush"
+	ErrCacheCompact        ErrorCode = "cache_compact"
+	ErrChaosCache          ErrorCode = "chaos_cache"
+	ErrSnapshot            ErrorCode = "snapshot"
+	ErrHandoff             ErrorCode = "handoff"
+	ErrRefresh             ErrorCode = "refresh"
+	ErrPurgePublish        ErrorCode = "purge_publish"
+	ErrPurgeSubscribe      ErrorCode = "purge_subscribe"
+	ErrWatch               ErrorCode = "watch"
+	ErrWebhook             ErrorCode = "webhook"
+	ErrStatsD              ErrorCode = "statsd"
+)
+
+// The body of an API route's error.
+type apiError struct {
+	Error   ErrorCode `json:"error"`
+	Message string    `json:"message"`
+	// So the failure can be found in our logs.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Whether the request is for one of the /api/ routes, which answer errors
+// in JSON.
+func isAPIRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/")
+}
+
+// Answers with the error, its code in the X-Imgd-Error header. API routes
+// get a JSON body, the rest the status and message in plain text.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code ErrorCode, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	w.Header().Set(ErrorHeader, string(code))
+	if !isAPIRequest(r) {
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "%d %s", status, message)
+		return
+	}
+
+	body, _ := json.Marshal(apiError{Error: code, Message: message, RequestID: requestIDFrom(r.Context())})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// Answers that something went wrong on our side, with the request's ID to
+// find it in our logs by.
+func writeInternalError(w http.ResponseWriter, r *http.Request, code ErrorCode) {
+	writeError(w, r, http.StatusInternalServerError, code, "internal server error (request %s)", requestIDFrom(r.Context()))
+}
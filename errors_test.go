@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestWriteError(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeError(w, httptest.NewRequest("GET", "/avatar/clone1018/1", nil), http.StatusBadRequest, ErrBadRequest, "size not allowed")
+	if w.Header().Get(ErrorHeader) != "bad_request" || w.Body.String() != "400 size not allowed" {
+		t.Fatalf("Expected the code and a plain message, got %v %q", w.Header(), w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	writeError(w, httptest.NewRequest("GET", "/api/text/hi.png", nil), http.StatusBadRequest, ErrBadRequest, "size not allowed")
+	body := apiError{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if w.Header().Get(ErrorHeader) != "bad_request" || body.Error != ErrBadRequest || body.Message != "size not allowed" {
+		t.Fatalf("Expected the code in the header and body, got %v %+v", w.Header(), body)
+	}
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Expected JSON, got %q", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	stats = MakeStatsCollector()
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+
+	w := httptest.NewRecorder()
+	router.Mux.ServeHTTP(w, httptest.NewRequest("POST", "/api/profiles", strings.NewReader("nonsense")))
+	body := apiError{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusBadRequest || body.Error != ErrBadRequest {
+		t.Fatalf("Expected a JSON bad_request, got %d %q", w.Code, w.Body.String())
+	}
+
+	limiter := MakeRateLimiter(1, 1)
+	handler := rateLimitHandler(limiter, router.Mux)
+	for i := 0; i < 2; i++ {
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/text/hi.png", nil))
+	}
+	if w.Code != http.StatusTooManyRequests || w.Header().Get(ErrorHeader) != string(ErrRateLimited) {
+		t.Fatalf("Expected a rate_limited 429, got %d %v", w.Code, w.Header())
+	}
+	if stats.snapshot().Errored[string(ErrRateLimited)] != 1 {
+		t.Fatalf("Expected the error to be counted by its code, got %v", stats.snapshot().Errored)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// Quotes the tag, as the ETag header wants it.
+func quoteETag(tag string) string {
+	return "\"" + tag + "\""
+}
+
+// The ETag for a render: the texture it's drawn from, and a hash of
+// everything else about how it was drawn, from its render cache key.
+func renderETag(key string, skin *mcSkin) string {
+	sum := md5.Sum([]byte(key))
+	return quoteETag(textureKey(skin.Skin) + "-" + hex.EncodeToString(sum[:4]))
+}
+
+// Whether the request's If-None-Match matches the ETag, so the client
+// already has what we'd send. We used to send ETags unquoted, so clients
+// holding one of those match too.
+func etagMatches(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" || etag == quoteETag("") {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	etag = strings.Trim(etag, "\"")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if strings.Trim(candidate, "\"") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Answers with a 304 if the client already has what we'd send, returning
+// whether we did.
+func writeNotModified(w http.ResponseWriter, r *http.Request, etag string, class string) bool {
+	if !etagMatches(r, etag) {
+		return false
+	}
+	w.Header().Set("ETag", etag)
+	setCacheHeaders(w, r, class)
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
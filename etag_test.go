@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/minecraft"
+)
+
+func TestETagMatches(t *testing.T) {
+	for header, matches := range map[string]bool{
+		`"abc"`:          true,
+		`W/"abc"`:        true,
+		`"xyz", "abc"`:   true,
+		`abc`:            true,
+		`*`:              true,
+		`"abc-1"`:        false,
+		``:               false,
+		`"xyz", W/"abd"`: false,
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("If-None-Match", header)
+		if etagMatches(r, `"abc"`) != matches {
+			t.Errorf("Expected If-None-Match %s matching to be %v", header, matches)
+		}
+	}
+}
+
+func TestRenderETags(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	renderCache = MakeRenderCache(0, false)
+	hotCache = MakeHotCache(nil, 0)
+	missingFilter = MakeMissingFilter(0, time.Minute)
+
+	skin, _ := minecraft.FetchSkinForSteve()
+	skin.Hash = "clone1018"
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+
+	etags := map[string]bool{}
+	for _, path := range []string{
+		"/avatar/d9135e082f2244c89cb10d21ed3ac8fd/32",
+		"/avatar/d9135e082f2244c89cb10d21ed3ac8fd/64",
+		"/avatar/d9135e082f2244c89cb10d21ed3ac8fd/64?label=1",
+	} {
+		w := httptest.NewRecorder()
+		router.Mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		etag := w.Header().Get("ETag")
+		if etags[etag] {
+			t.Fatalf("Expected %s to have its own ETag, got %s", path, etag)
+		}
+		etags[etag] = true
+
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("If-None-Match", etag)
+		w = httptest.NewRecorder()
+		router.Mux.ServeHTTP(w, r)
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Fatalf("Expected %s to be revalidated with a 304, got %d", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Header a tracing proxy in front of us passes the trace a request is part
+// of in, as in the W3C Trace Context spec, eg.
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+const TraceParentHeader = "traceparent"
+
+var traceParentRegex = regexp.MustCompile("^[0-9a-f]{2}-([0-9a-f]{32})-[0-9a-f]{16}-[0-9a-f]{2}$")
+
+type traceIDContextKey struct{}
+
+// Returns the ID of the trace the request is part of, if it's part of one.
+func parseTraceParent(header string) string {
+	match := traceParentRegex.FindStringSubmatch(header)
+	if match == nil || match[1] == "00000000000000000000000000000000" {
+		return ""
+	}
+	return match[1]
+}
+
+// Notes the trace each request is part of, so the latency histograms can
+// point at it from their exemplars. It's outside everything else, so the
+// duration of the whole request can be tied to its trace.
+func traceHandler(router http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := parseTraceParent(r.Header.Get(TraceParentHeader)); id != "" && config.Server.Exemplars {
+			r = r.WithContext(context.WithValue(r.Context(), traceIDContextKey{}, id))
+		}
+		router.ServeHTTP(w, r)
+	})
+}
+
+// Returns the ID of the trace the request the context is for is part of.
+func traceIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDContextKey{}).(string)
+	return id
+}
+
+// Returns the exemplar to attach to an observation for the trace, nil if
+// there isn't one.
+func traceExemplar(traceID string) prometheus.Labels {
+	if traceID == "" {
+		return nil
+	}
+	return prometheus.Labels{"trace_id": traceID}
+}
+
+// Returns the exemplar for the request the context is for.
+func exemplarFor(ctx context.Context) prometheus.Labels {
+	return traceExemplar(traceIDFrom(ctx))
+}
+
+// Observes the value, with the trace it came from as its exemplar if there
+// is one.
+func observeWithTrace(observer prometheus.Observer, value float64, traceID string) {
+	if exemplars, ok := observer.(prometheus.ExemplarObserver); ok && traceID != "" {
+		exemplars.ObserveWithExemplar(value, traceExemplar(traceID))
+		return
+	}
+	observer.Observe(value)
+}
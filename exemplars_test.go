@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	for header, expected := range map[string]string{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01": "4bf92f3577b34da6a3ce929d0e0e4736",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01": "",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01": "",
+		"4bf92f3577b34da6a3ce929d0e0e4736":                        "",
+		"":                                                        "",
+	} {
+		if id := parseTraceParent(header); id != expected {
+			t.Fatalf("Expected %q for %q, got %q", expected, header, id)
+		}
+	}
+}
+
+func TestExemplars(t *testing.T) {
+	config.Server.Exemplars = true
+	defer func() { config.Server.Exemplars = false }()
+
+	router := mux.NewRouter()
+	router.Use(recordRoute)
+	router.HandleFunc("/traced", func(w http.ResponseWriter, r *http.Request) {})
+	handler := traceHandler(metricChain(accessLogHandler(router)))
+	r := httptest.NewRequest("GET", "/traced", nil)
+	r.Header.Set(TraceParentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	w := httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/metrics", nil)
+	r.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}).ServeHTTP(w, r)
+	for _, metric := range []string{"imgd_http_request_duration_seconds_bucket", "imgd_http_route_duration_seconds_bucket{class=\"none\",route=\"traced\""} {
+		found := false
+		for _, line := range strings.Split(w.Body.String(), "\n") {
+			if strings.HasPrefix(line, metric) && strings.Contains(line, `# {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"}`) {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("Expected an exemplar on %s", metric)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"embed"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/minotar/imgd/pkg/mcskin"
+	"github.com/minotar/minecraft"
+)
+
+// The Source of the skins we fall back to. Only they're given it, so
+// they're told apart from players who really wear Steve or Alex.
+const fallbackSource = "Fallback"
+
+// Returns the skin to serve for players we couldn't fetch: the operator's
+// own if they've configured one, otherwise Steve.
+func fallbackSkin() minecraft.Skin {
+	if customSkin != nil {
+		return *customSkin
+	}
+	skin, _ := minecraft.FetchSkinForSteve()
+	skin.Source = fallbackSource
+	return skin
+}
+
+// Whether the skin is one we fell back to for a player we couldn't fetch.
+func isFallbackSkin(skin minecraft.Skin) bool {
+	return skin.Source == fallbackSource
+}
+
+// The hash of vanilla's default Alex texture on Mojang's texture server.
+const alexTextureHash = "3b60a1f6d562f52aaebbf1434f1de147933a3affe0e764fa49ea057536623cd3"
+
+// Vanilla's default textures the minecraft package doesn't bundle, as
+// Mojang serves them: defaults/alex.png is the texture with alexTextureHash.
+//
+//go:embed defaults
+var defaultTextures embed.FS
+
+// Loads the bundled Alex into alexSkin, returning whether it could.
+func loadAlexSkin() bool {
+	data, err := defaultTextures.ReadFile("defaults/alex.png")
+	if err != nil {
+		return false
+	}
+	skin, err := decodeSkinPNG(data)
+	if err != nil {
+		return false
+	}
+	skin.Hash = alexTextureHash
+	alexSkin = &skin
+	return true
+}
+
+// Whether vanilla gives the player Alex by default rather than Steve, which
+// it does when the Java hashCode of their UUID is odd.
+func isAlexUUID(uuid string) bool {
+	uuid, ok := normalizeUUID(uuid)
+	if !ok {
+		return false
+	}
+	raw, _ := hex.DecodeString(uuid)
+	hilo := binary.BigEndian.Uint64(raw[:8]) ^ binary.BigEndian.Uint64(raw[8:])
+	return (uint32(hilo>>32)^uint32(hilo))&1 == 1
+}
+
+// Returns the skin vanilla gives the player until they set their own, and
+// whether it's for the slim model. Steve stands in for Alex if Alex isn't
+// bundled.
+func defaultSkin(uuid string) (minecraft.Skin, bool) {
+	if isAlexUUID(uuid) {
+		if alexSkin != nil {
+			skin := *alexSkin
+			skin.Source = fallbackSource
+			return skin, true
+		}
+	}
+	steve, _ := minecraft.FetchSkinForSteve()
+	steve.Source = fallbackSource
+	return steve, false
+}
+
+// Returns what to serve for a player we couldn't fetch. Unless the operator
+// has their own fallback, that's the default skin for their UUID if we
+// know it, as they'd see in game.
+func fallbackFor(uuid string) *mcSkin {
+	if customSkin != nil || uuid == "" {
+		return &mcSkin{Render: mcskin.Render{Skin: fallbackSkin()}, Fallback: true}
+	}
+	skin, slim := defaultSkin(uuid)
+	return &mcSkin{Render: mcskin.Render{Skin: skin, Slim: slim}, Fallback: true}
+}
+
+// Loads a skin from a file, or an http(s) URL, to fall back to. It must be
+// a whole skin rather than just a face, so every render can be drawn from
+// it at any size.
+func loadFallbackSkin(source string) (minecraft.Skin, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		data, err = downloadFallbackSkin(source)
+	} else {
+		data, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return minecraft.Skin{}, err
+	}
+
+	skin := minecraft.Skin{}
+	if err := skin.Decode(bytes.NewReader(data)); err != nil {
+		return minecraft.Skin{}, err
+	}
+	bounds := skin.Image.Bounds()
+	if bounds.Dx() != 64 || (bounds.Dy() != 64 && bounds.Dy() != 32) {
+		return minecraft.Skin{}, fmt.Errorf("%s is %dx%d, not a 64x64 or 64x32 skin", source, bounds.Dx(), bounds.Dy())
+	}
+	skin.Hash = fmt.Sprintf("fallback-%x", md5.Sum(data))
+	skin.Source = fallbackSource
+	return skin, nil
+}
+
+func downloadFallbackSkin(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/minotar/minecraft"
+)
+
+func encodeTestSkin(width, height int) []byte {
+	buf := new(bytes.Buffer)
+	png.Encode(buf, image.NewNRGBA(image.Rect(0, 0, width, height)))
+	return buf.Bytes()
+}
+
+func TestLoadFallbackSkin(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fallback.png")
+	os.WriteFile(path, encodeTestSkin(64, 64), 0644)
+
+	skin, err := loadFallbackSkin(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	customSkin = &skin
+	defer func() { customSkin = nil }()
+	if fallbackSkin().Source != "Fallback" || !isFallbackSkin(fallbackSkin()) {
+		t.Fatal("Expected the custom skin to be served as the fallback")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(encodeTestSkin(64, 32))
+	}))
+	defer server.Close()
+	if _, err := loadFallbackSkin(server.URL + "/fallback.png"); err != nil {
+		t.Fatalf("Expected a legacy skin from a URL to load, got %v", err)
+	}
+
+	os.WriteFile(path, encodeTestSkin(32, 32), 0644)
+	if _, err := loadFallbackSkin(path); err == nil {
+		t.Fatal("Expected an image which isn't a skin to be refused")
+	}
+}
+
+func TestDefaultSkinByUUID(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	config.Ttl.Skin = 60
+
+	// Without Alex bundled, Steve stands in.
+	alexSkin = nil
+	defer func() { alexSkin = nil }()
+	if skin, slim := defaultSkin("853c80ef3c3749fdaa49938b674adae6"); slim || textureKey(skin) == alexTextureHash {
+		t.Fatal("Expected Steve without Alex")
+	}
+	alex, _ := decodeSkinPNG(encodeTestSkin(64, 64))
+	alex.Hash = alexTextureHash
+	alexSkin = &alex
+
+	// Notch's UUID has an even hashCode, jeb_'s an odd one.
+	if skin, slim := defaultSkin("069a79f4-44e9-4726-a5be-fca90e38aaf5"); slim || textureKey(skin) == alexTextureHash {
+		t.Fatal("Expected Steve for an even hashCode")
+	}
+	if skin, slim := defaultSkin("853c80ef3c3749fdaa49938b674adae6"); !slim || textureKey(skin) != alexTextureHash {
+		t.Fatal("Expected Alex for an odd hashCode")
+	}
+
+	skin := fallbackFor("853c80ef3c3749fdaa49938b674adae6")
+	if !skin.Fallback || !skin.Slim {
+		t.Fatal("Expected players we couldn't fetch to get their default too")
+	}
+	if !isFallbackSkin(skin.Skin) {
+		t.Fatal("Expected Alex to be recognised as a fallback")
+	}
+
+	// As do players we recently failed to fetch.
+	uuidCache = MakeUUIDCache()
+	missingFilter = MakeMissingFilter(0, time.Minute)
+	cache.addNegative("853c80ef3c3749fdaa49938b674adae6", NegativeAPIError, time.Minute)
+	if skin := fetchSkinVia(context.Background(), "853c80ef3c3749fdaa49938b674adae6", false); !skin.Fallback || !skin.Cached || !skin.Slim {
+		t.Fatal("Expected a negative cache hit to get the player's default")
+	}
+}
+
+func TestDefaultSkinnedPlayerIsntFallback(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	missingFilter = MakeMissingFilter(0, time.Minute)
+	config.Ttl.Skin = 60
+
+	// Fetched players wearing Steve or Alex are as Mojang gave them.
+	steve, _ := minecraft.FetchSkinForSteve()
+	steve.Source = "SessionProfile"
+	cache.add("069a79f444e94726a5befca90e38aaf5", steve, time.Minute)
+	alex := steve
+	alex.Hash = alexTextureHash
+	cache.add("853c80ef3c3749fdaa49938b674adae6", alex, time.Minute)
+
+	for _, uuid := range []string{"069a79f444e94726a5befca90e38aaf5", "853c80ef3c3749fdaa49938b674adae6"} {
+		if skin := fetchSkinVia(context.Background(), uuid, false); !skin.Cached || skin.Fallback {
+			t.Fatalf("Expected %s's cached default skin not to be taken for a fallback", uuid)
+		}
+	}
+	if !isFallbackSkin(fallbackFor("069a79f444e94726a5befca90e38aaf5").Skin) {
+		t.Fatal("Expected Steve to be a fallback when we fall back to it")
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Formats renders can be encoded as, by extension, with their Content-Type.
+// Any other extension gets a PNG.
+var renderFormats = map[string]string{
+	".png":  "image/png",
+	".svg":  "image/svg+xml",
+	".webp": "image/webp",
+	".gif":  "image/gif",
+}
+
+// Formats an Accept header can choose between, in the order we prefer them
+// when it likes several as much.
+var negotiableFormats = []string{".webp", ".png", ".gif"}
+
+// Picks the format for a render requested without an extension from the
+// Accept header. WebP and GIF have to be asked for by name, as clients
+// accepting anything, eg. "*/*", have always had a PNG and may not cope with
+// anything else.
+func negotiateFormat(accept string) string {
+	best, bestQ := ".png", 0.0
+	for _, ext := range negotiableFormats {
+		q, named := acceptQuality(accept, renderFormats[ext])
+		if (named || ext == ".png") && q > bestQ {
+			best, bestQ = ext, q
+		}
+	}
+	return best
+}
+
+// Returns the quality the Accept header gives the media type, from the most
+// specific range matching it, and whether it named the type itself.
+func acceptQuality(accept string, mediaType string) (float64, bool) {
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		accepted := strings.ToLower(strings.TrimSpace(params[0]))
+
+		matched := -1
+		switch {
+		case accepted == mediaType:
+			matched = 2
+		case accepted == strings.SplitN(mediaType, "/", 2)[0]+"/*":
+			matched = 1
+		case accepted == "*/*":
+			matched = 0
+		}
+		if matched <= specificity {
+			continue
+		}
+
+		specificity, q = matched, 1
+		for _, param := range params[1:] {
+			if value := strings.TrimSpace(param); strings.HasPrefix(value, "q=") {
+				if parsed, err := strconv.ParseFloat(strings.TrimPrefix(value, "q="), 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+	}
+	return q, specificity == 2
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"image/gif"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/gorilla/mux"
+	"github.com/minotar/minecraft"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	for accept, expected := range map[string]string{
+		"":    ".png",
+		"*/*": ".png",
+		"image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8": ".webp",
+		"image/webp;q=0.5,image/png":                                       ".png",
+		"image/webp;q=0,*/*":                                               ".png",
+		"image/gif":                                                        ".gif",
+		"text/html":                                                        ".png",
+	} {
+		if format := negotiateFormat(accept); format != expected {
+			t.Errorf("Expected %s for %q, got %s", expected, accept, format)
+		}
+	}
+}
+
+func TestNegotiatedRenders(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	renderCache = MakeRenderCache(1<<20, false)
+	hotCache = MakeHotCache(nil, 0)
+	missingFilter = MakeMissingFilter(0, time.Minute)
+
+	skin, _ := minecraft.FetchSkinForSteve()
+	skin.Hash = "clone1018"
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+	serve := func(path string, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		router.Mux.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("/avatar/d9135e082f2244c89cb10d21ed3ac8fd/32", "image/webp,*/*")
+	if w.Header().Get("Content-Type") != "image/webp" || w.Header().Get("Vary") != "Accept" {
+		t.Fatalf("Expected a WebP which varies by Accept, got %v", w.Header())
+	}
+	if img, err := nativewebp.Decode(w.Body); err != nil || img.Bounds().Dx() != 32 {
+		t.Fatalf("Expected a 32px WebP (%v)", err)
+	}
+	png := serve("/avatar/d9135e082f2244c89cb10d21ed3ac8fd/32", "*/*")
+	if png.Header().Get("Content-Type") != "image/png" || png.Header().Get("ETag") == w.Header().Get("ETag") {
+		t.Fatalf("Expected a PNG with its own ETag, got %v", png.Header())
+	}
+
+	w = serve("/avatar/d9135e082f2244c89cb10d21ed3ac8fd/32.gif", "image/webp")
+	if w.Header().Get("Content-Type") != "image/gif" || w.Header().Get("Vary") != "" {
+		t.Fatalf("Expected the extension to win without varying, got %v", w.Header())
+	}
+	if img, err := gif.Decode(bytes.NewReader(w.Body.Bytes())); err != nil || img.Bounds().Dx() != 32 {
+		t.Fatalf("Expected a 32px GIF (%v)", err)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"flag"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/minotar/imgd/pkg/mcskin"
+	"github.com/minotar/minecraft"
+)
+
+// Skins the golden renders are drawn from, by name: a classic and a slim
+// skin, and one in the old 64x32 format, each noise so any part cut from
+// the wrong place shows.
+var (
+	//go:embed selftest/slim.png
+	goldenSlimPNG []byte
+	//go:embed selftest/legacy.png
+	goldenLegacyPNG []byte
+)
+
+func goldenSkins() map[string][]byte {
+	return map[string][]byte{
+		"classic": selfTestSkinPNG,
+		"slim":    goldenSlimPNG,
+		"legacy":  goldenLegacyPNG,
+	}
+}
+
+// What each render of each golden skin should come out as, and the render
+// version they're for. Regenerate it with
+// "imgd verify-renders -write selftest/golden.txt" after bumping
+// mcskin.Version.
+//
+//go:embed selftest/golden.txt
+var goldenChecksums string
+
+// Width the golden renders are drawn at.
+const goldenWidth = 64
+
+// One render of a golden skin.
+type goldenRender struct {
+	Skin     string
+	Resource string
+	Ext      string
+	Walking  bool
+}
+
+// eg. "slim/armor/body-walking.png".
+func (g goldenRender) name() string {
+	name := g.Skin + "/" + strings.ToLower(g.Resource)
+	if g.Walking {
+		name += "-walking"
+	}
+	return name + g.Ext
+}
+
+// Lists the renders to check. Cubes are rotated in floating point, so may
+// be a pixel out on some architectures, and Armour is only Armor spelt
+// differently.
+func goldenRenders() []goldenRender {
+	skins := []string{}
+	for name := range goldenSkins() {
+		skins = append(skins, name)
+	}
+	sort.Strings(skins)
+
+	renders := []goldenRender{}
+	for _, skin := range skins {
+		for _, resource := range renderResources {
+			if resource == "Cube" || strings.HasPrefix(resource, "Armour") {
+				continue
+			}
+			for _, ext := range []string{".png", ".svg"} {
+				renders = append(renders, goldenRender{Skin: skin, Resource: resource, Ext: ext})
+				if strings.HasSuffix(resource, "Body") {
+					renders = append(renders, goldenRender{Skin: skin, Resource: resource, Ext: ext, Walking: true})
+				}
+			}
+		}
+	}
+	return renders
+}
+
+// Reads the golden file: a "version N" line, then a "<name> <checksum>"
+// line for each render. Blank lines and those starting with # are skipped.
+func parseGoldenChecksums(data string) (int, map[string]string, error) {
+	version := 0
+	checksums := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return 0, nil, fmt.Errorf("line %d: expected a name and a checksum", line)
+		}
+		if fields[0] == "version" {
+			var err error
+			if version, err = strconv.Atoi(fields[1]); err != nil {
+				return 0, nil, fmt.Errorf("line %d: bad version %q", line, fields[1])
+			}
+			continue
+		}
+		checksums[fields[0]] = fields[1]
+	}
+	return version, checksums, scanner.Err()
+}
+
+// Returns a checksum of the render which is the same wherever it's drawn:
+// of its pixels for a PNG, as the encoder may change between Go releases,
+// and of its bytes for anything else.
+func renderChecksum(ext string, body []byte) (string, error) {
+	if ext == ".png" {
+		img, err := png.Decode(bytes.NewReader(body))
+		if err != nil {
+			return "", err
+		}
+		nrgba := image.NewNRGBA(img.Bounds())
+		draw.Draw(nrgba, nrgba.Bounds(), img, img.Bounds().Min, draw.Src)
+		body = nrgba.Pix
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(body))[:16], nil
+}
+
+// Draws the golden render, without the server, and returns its checksum.
+func drawGoldenRender(g goldenRender) (string, error) {
+	skin := minecraft.Skin{}
+	if err := skin.Decode(bytes.NewReader(goldenSkins()[g.Skin])); err != nil {
+		return "", err
+	}
+	render := &mcSkin{Render: mcskin.Render{Skin: skin, Slim: isSlimSkin(skin)}}
+	router := &Router{}
+	render.Mode = router.getResizeMode(g.Ext)
+	render.Options.Walking = g.Walking
+	data, err := router.draw(g.Resource, goldenWidth, g.Ext, render)
+	if err != nil {
+		return "", err
+	}
+	return renderChecksum(g.Ext, data)
+}
+
+// Draws every golden render and checks each against the golden file. If
+// any has changed but the render version hasn't, the version needs bumping
+// so caches are invalidated; if the version has, the golden file needs
+// regenerating.
+func verifyRenders() ([]selfTestResult, error) {
+	version, expected, err := parseGoldenChecksums(goldenChecksums)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the golden checksums (%v)", err)
+	}
+
+	results := []selfTestResult{}
+	changed := false
+	for _, g := range goldenRenders() {
+		result := selfTestResult{Name: g.name()}
+		result.Checksum, result.Err = drawGoldenRender(g)
+		if want, known := expected[result.Name]; result.Err == nil && !known {
+			result.Err = fmt.Errorf("no golden checksum")
+		} else if result.Err == nil {
+			result.Verified = true
+			if result.Checksum != want {
+				changed = true
+				result.Err = fmt.Errorf("checksum %s, expected %s", result.Checksum, want)
+			}
+		}
+		results = append(results, result)
+	}
+
+	switch {
+	case version != mcskin.Version:
+		err = fmt.Errorf("the golden checksums are for render version %d, but we're version %d: regenerate them with -write", version, mcskin.Version)
+	case changed:
+		err = fmt.Errorf("renders have changed, but the render version is still %d: if that's intended, bump mcskin.Version, then regenerate the checksums with -write", mcskin.Version)
+	}
+	return results, err
+}
+
+// Writes the golden file for the renders as they're drawn now.
+func writeGoldenChecksums(path string) error {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "# Written by imgd verify-renders -write. See golden.go.\nversion %d\n", mcskin.Version)
+	for _, g := range goldenRenders() {
+		sum, err := drawGoldenRender(g)
+		if err != nil {
+			return fmt.Errorf("unable to draw %s (%v)", g.name(), err)
+		}
+		fmt.Fprintf(buf, "%s %s\n", g.name(), sum)
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+func runVerifyRenders(args []string) int {
+	fs := flag.NewFlagSet("verify-renders", flag.ContinueOnError)
+	write := fs.String("write", "", "write the checksums of the renders as they're drawn now to the file, rather than checking them")
+	if !parseCommand(fs, args, 0, 0) {
+		return 2
+	}
+	// Nothing's configured, so anything worth logging is a warning.
+	log = MakeLogger(os.Stderr, LogFormatText)
+	log.SetLevel(slog.LevelWarn)
+
+	if *write != "" {
+		if err := writeGoldenChecksums(*write); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write %s (%v)\n", *write, err)
+			return 1
+		}
+		fmt.Printf("Wrote the checksums of %d renders, for render version %d, to %s\n", len(goldenRenders()), mcskin.Version, *write)
+		return 0
+	}
+
+	results, err := verifyRenders()
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+			fmt.Printf("FAIL  %-28s %v\n", result.Name, result.Err)
+		} else {
+			fmt.Printf("ok    %-28s %s\n", result.Name, result.Checksum)
+		}
+	}
+	fmt.Printf("%d of %d renders match render version %d\n", len(results)-failed, len(results), mcskin.Version)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+	if failed > 0 || err != nil {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/minotar/imgd/pkg/mcskin"
+)
+
+// Fails on any change to how a skin's drawn, until the render version's
+// bumped and the golden checksums regenerated, so it can't reach a release
+// by accident, or with the caches still serving the old renders.
+func TestGoldenRenders(t *testing.T) {
+	results, err := verifyRenders()
+	for _, result := range results {
+		if result.Err != nil {
+			t.Errorf("%s: %v", result.Name, result.Err)
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(goldenRenders()) {
+		t.Fatalf("Expected %d renders, got %d", len(goldenRenders()), len(results))
+	}
+}
+
+func TestParseGoldenChecksums(t *testing.T) {
+	version, checksums, err := parseGoldenChecksums("# comment\nversion 3\n\nslim/avatar.png 0123456789abcdef\n")
+	if err != nil || version != 3 || checksums["slim/avatar.png"] != "0123456789abcdef" {
+		t.Fatalf("Expected the version and checksum, got %d, %v, %v", version, checksums, err)
+	}
+	if _, _, err := parseGoldenChecksums("slim/avatar.png\n"); err == nil {
+		t.Fatal("Expected a line without a checksum to be refused")
+	}
+}
+
+func TestGoldenRendersNeedVersionBump(t *testing.T) {
+	saved := goldenChecksums
+	defer func() { goldenChecksums = saved }()
+
+	_, checksums, _ := parseGoldenChecksums(saved)
+	goldenChecksums = fmt.Sprintf("version %d\n", mcskin.Version)
+	for name, sum := range checksums {
+		if name == "slim/armor/body.png" {
+			sum = "0000000000000000"
+		}
+		goldenChecksums += name + " " + sum + "\n"
+	}
+	if _, err := verifyRenders(); err == nil {
+		t.Fatal("Expected a changed render without a version bump to fail")
+	}
+
+	goldenChecksums = fmt.Sprintf("version %d\n", mcskin.Version-1)
+	for name, sum := range checksums {
+		goldenChecksums += name + " " + sum + "\n"
+	}
+	if _, err := verifyRenders(); err == nil {
+		t.Fatal("Expected checksums for another render version to fail")
+	}
+}
+
+func TestRenderKeyHasVersion(t *testing.T) {
+	key := renderKey("Avatar", 64, ".png", &mcSkin{})
+	if !strings.HasPrefix(key, fmt.Sprintf("v%d|", mcskin.Version)) {
+		t.Fatalf("Expected the render version in the key, got %q", key)
+	}
+}
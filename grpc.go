@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/minotar/imgd/pkg/imgdpb"
+	"github.com/minotar/imgd/pkg/mcskin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Serves renders over gRPC, for services rendering server to server, eg.
+// game server plugins and bots, which would rather have typed clients and
+// streamed batches than build URLs. See pkg/imgdpb for the API.
+type grpcRenderer struct {
+	imgdpb.UnimplementedRendererServer
+	router *Router
+}
+
+// A render asked for over gRPC, once checked.
+type grpcRender struct {
+	// The player, as the skins of a batch are kept: a lowercased username
+	// or UUID, or "texture/" and a texture hash.
+	player   string
+	resource string
+	width    uint
+	ext      string
+	options  mcskin.Options
+}
+
+// Prefix of the players which are texture hashes.
+const grpcTexturePrefix = "texture/"
+
+// Checks the request is for a render we'd serve over HTTP, returning an
+// InvalidArgument error if not.
+func (g *grpcRenderer) parse(req *imgdpb.RenderRequest) (grpcRender, error) {
+	render := grpcRender{}
+	switch player := req.Player.(type) {
+	case *imgdpb.RenderRequest_User:
+		if !profilesPlayerRegex.MatchString(player.User) {
+			return render, status.Errorf(codes.InvalidArgument, "invalid user %q", player.User)
+		}
+		render.player = strings.ToLower(player.User)
+	case *imgdpb.RenderRequest_Uuid:
+		uuid, ok := normalizeUUID(player.Uuid)
+		if !ok {
+			return render, status.Errorf(codes.InvalidArgument, "invalid uuid %q", player.Uuid)
+		}
+		render.player = uuid
+	case *imgdpb.RenderRequest_Texture:
+		if !routeEnabled("textures") || !textureHashPattern.MatchString(player.Texture) {
+			return render, status.Errorf(codes.InvalidArgument, "invalid texture %q", player.Texture)
+		}
+		render.player = grpcTexturePrefix + strings.ToLower(player.Texture)
+	default:
+		return render, status.Error(codes.InvalidArgument, "a user, uuid or texture is needed")
+	}
+
+	name := req.Type
+	if name == "" {
+		name = "avatar"
+	}
+	resource, ok := batchResource(name)
+	if !ok {
+		return render, status.Errorf(codes.InvalidArgument, "unknown render type %q", name)
+	}
+	render.resource = resource
+
+	size := ""
+	if req.Size != 0 {
+		size = fmt.Sprint(req.Size)
+	}
+	width, allowed := g.router.routeWidth(resource, size)
+	if !allowed {
+		return render, status.Errorf(codes.InvalidArgument, "size %d not allowed for %s", req.Size, name)
+	}
+	render.width = width
+
+	render.ext = ".png"
+	if req.Format != "" {
+		render.ext = "." + strings.TrimPrefix(strings.ToLower(req.Format), ".")
+	}
+	if _, known := renderFormats[render.ext]; !known || (render.ext == ".svg" && !routeEnabled("svg")) {
+		return render, status.Errorf(codes.InvalidArgument, "unknown format %q", req.Format)
+	}
+
+	if opts := req.Options; opts != nil {
+		render.options = mcskin.Options{
+			ArmAngle: math.Max(0, math.Min(opts.ArmAngle, mcskin.MaxArmAngle)),
+			Walking:  opts.Walking,
+			Ears:     opts.Ears,
+			Trim:     opts.Trim,
+			Label:    opts.Label,
+		}
+	}
+	return render, nil
+}
+
+// Fetches the player's skin, serving the fallback if it's not fetched
+// before ctx is done.
+func fetchGRPCSkin(ctx context.Context, player string) *mcSkin {
+	fetchCtx := withRequestID(context.Background(), requestIDFrom(ctx))
+	if hash, byHash := strings.CutPrefix(player, grpcTexturePrefix); byHash {
+		return fetchSkinByHash(fetchCtx, hash)
+	}
+	return fetchSkinUntil(fetchCtx, ctx, player, true)
+}
+
+// Draws the render of the skin, or has it from the render cache.
+func (g *grpcRenderer) draw(ctx context.Context, render grpcRender, base *mcSkin) (*imgdpb.RenderResponse, error) {
+	// Each render draws on its own copy, as it leaves its result there.
+	skin := *base
+	skin.Mode = g.router.getResizeMode(render.ext)
+	skin.Options = render.options
+
+	key := renderKey(render.resource, render.width, render.ext, &skin)
+	data, ok := defaultRender(key)
+	if !ok {
+		if data, ok = renderCache.get(key); ok {
+			stats.HitRenderCache()
+		}
+	}
+	if !ok {
+		if renderCache.enabled() {
+			stats.MissRenderCache()
+		}
+		var err error
+		data, err = g.router.render(ctx, render.resource, render.width, render.ext, &skin)
+		switch {
+		case err == errRenderQueueFull:
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+			return nil, status.FromContextError(err).Err()
+		case err != nil:
+			log.Errorf("Failed gRPC render of %s for %s (%s)", render.resource, render.player, err.Error())
+			stats.Errored(ErrRenderFailed)
+			return nil, status.Errorf(codes.Internal, "internal server error (request %s)", requestIDFrom(ctx))
+		}
+		renderCache.add(key, data)
+	}
+
+	return &imgdpb.RenderResponse{
+		Image:       data,
+		ContentType: renderFormats[render.ext],
+		Etag:        renderETag(key, &skin),
+		Fallback:    skin.Fallback,
+	}, nil
+}
+
+func (g *grpcRenderer) Render(ctx context.Context, req *imgdpb.RenderRequest) (*imgdpb.RenderResponse, error) {
+	render, err := g.parse(req)
+	if err != nil {
+		return nil, err
+	}
+	stats.Requested("GRPCRender")
+	return g.draw(ctx, render, fetchGRPCSkin(ctx, render.player))
+}
+
+func (g *grpcRenderer) RenderBatch(req *imgdpb.RenderBatchRequest, stream imgdpb.Renderer_RenderBatchServer) error {
+	if len(req.Renders) > MaxRenderBatch {
+		return status.Errorf(codes.InvalidArgument, "at most %d renders may be asked for at once", MaxRenderBatch)
+	}
+	renders := make([]grpcRender, len(req.Renders))
+	players := make([]string, len(req.Renders))
+	for i, r := range req.Renders {
+		render, err := g.parse(r)
+		if err != nil {
+			return err
+		}
+		renders[i], players[i] = render, render.player
+	}
+	stats.Requested("GRPCRenderBatch")
+
+	ctx := stream.Context()
+	if r, err := grpcHTTPRequest(ctx, imgdpb.Renderer_RenderBatch_FullMethodName); err == nil && !chargeRateLimit(newBufferedResponse(), r, len(renders)) {
+		return status.Error(codes.ResourceExhausted, "more renders than your rate limit allows at once")
+	}
+	skins := fetchSkinsConcurrently(players, func(player string) *mcSkin {
+		return fetchGRPCSkin(ctx, player)
+	})
+	for i, render := range renders {
+		resp, err := g.draw(ctx, render, skins[render.player])
+		if err != nil {
+			return err
+		}
+		resp.Index = uint32(i)
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Puts the call through the IP filter, authentication, rate limits, quotas
+// and tenants as if it were an HTTP request, by way of one standing in for
+// it: from its peer, with its metadata as headers, to its method as a path.
+// With signed on, calls have nothing to sign, so must be identified. Returns
+// the context with who the call's from, or the error to refuse it with.
+func grpcAdmit(ctx context.Context, method string) (context.Context, error) {
+	r, err := grpcHTTPRequest(ctx, method)
+	if err != nil {
+		return ctx, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	admitted := ctx
+	admit := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.Auth.Signed && authIdentity(r) == "" {
+			writeError(w, r, http.StatusForbidden, ErrForbidden, "forbidden")
+			stats.Errored(ErrSignatureInvalid)
+			return
+		}
+		admitted = r.Context()
+	})
+	response := newBufferedResponse()
+	ipFilterHandler(ipFilter, adminIPFilter, authHandler(authChain, config.Auth.Required, rateLimitHandler(rateLimiter, quotaHandler(quota, tenantHandler(tenants, admit))))).ServeHTTP(response, r)
+
+	switch response.status {
+	case 0, http.StatusOK:
+		return admitted, nil
+	case http.StatusTooManyRequests:
+		return ctx, status.Errorf(codes.ResourceExhausted, "too many requests, retry after %s seconds", response.header.Get("Retry-After"))
+	case http.StatusUnauthorized:
+		return ctx, status.Error(codes.Unauthenticated, "unauthenticated")
+	case http.StatusForbidden:
+		return ctx, status.Error(codes.PermissionDenied, "forbidden")
+	default:
+		return ctx, status.Error(codes.Unavailable, http.StatusText(response.status))
+	}
+}
+
+// Makes the HTTP request standing in for the call, to admit and charge it
+// as one.
+func grpcHTTPRequest(ctx context.Context, method string) (*http.Request, error) {
+	r, err := http.NewRequestWithContext(ctx, "POST", method, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.RequestURI = method
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for key, values := range md {
+			for _, value := range values {
+				r.Header.Add(key, value)
+			}
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+	return r, nil
+}
+
+// Gives each call the request ID its caller sent, or one of our own, and a
+// deadline if it didn't come with one, then admits it as grpcAdmit does.
+// Panics are answered with Internal rather than taking the server down, and
+// each call is counted by its method and code.
+func grpcUnaryInterceptor(timeout time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		ctx = grpcRequestContext(ctx)
+		if _, ok := ctx.Deadline(); !ok && timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		defer func() {
+			if p := recover(); p != nil {
+				logPanic(ctx, "grpc", p)
+				err = status.Errorf(codes.Internal, "internal server error (request %s)", requestIDFrom(ctx))
+			}
+			grpcCounter.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+		}()
+		if ctx, err = grpcAdmit(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func grpcStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	ctx := grpcRequestContext(stream.Context())
+	defer func() {
+		if p := recover(); p != nil {
+			logPanic(ctx, "grpc", p)
+			err = status.Errorf(codes.Internal, "internal server error (request %s)", requestIDFrom(ctx))
+		}
+		grpcCounter.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+	}()
+	if ctx, err = grpcAdmit(ctx, info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, &grpcContextStream{ServerStream: stream, ctx: ctx})
+}
+
+// Carries the call's request ID, taken from its x-request-id metadata if
+// that's one we'd accept over HTTP.
+func grpcRequestContext(ctx context.Context) context.Context {
+	id := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(strings.ToLower(RequestIDHeader)); len(ids) > 0 {
+			id = ids[0]
+		}
+	}
+	if !requestIDRegex.MatchString(id) {
+		id = newRequestID()
+	}
+	return withRequestID(ctx, id)
+}
+
+// A stream with the context the interceptor gave it.
+type grpcContextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grpcContextStream) Context() context.Context {
+	return s.ctx
+}
+
+func startGRPCServer() {
+	listener, err := net.Listen("tcp", config.GRPC.Address)
+	if err != nil {
+		log.Criticalf("Listen: \"%s\"", err.Error())
+		os.Exit(1)
+	}
+	timeout := time.Duration(config.Server.RequestTimeout) * time.Second
+	grpcServer = grpc.NewServer(
+		grpc.UnaryInterceptor(grpcUnaryInterceptor(timeout)),
+		grpc.StreamInterceptor(grpcStreamInterceptor),
+	)
+	imgdpb.RegisterRendererServer(grpcServer, &grpcRenderer{router: &Router{}})
+	log.Noticef("Serving gRPC renders on %s", listener.Addr())
+	go func() {
+		if err := grpcServer.Serve(listener); err != nil {
+			log.Criticalf("Serve: \"%s\"", err.Error())
+			os.Exit(1)
+		}
+	}()
+}
+
+// Stops the gRPC server, letting calls in flight finish until ctx is done.
+func stopGRPCServer(ctx context.Context) {
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		grpcServer.Stop()
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image/png"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/minotar/imgd/pkg/imgdpb"
+	"github.com/minotar/minecraft"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestGRPCRender(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	renderCache = MakeRenderCache(0, false)
+	missingFilter = MakeMissingFilter(0, time.Minute)
+	config.Ttl.Failed = 60
+
+	skin, _ := minecraft.FetchSkinForSteve()
+	skin.Hash = "x"
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+	uuidCache.add("clone1018", "d9135e082f2244c89cb10d21ed3ac8fd", time.Minute)
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(grpc.UnaryInterceptor(grpcUnaryInterceptor(time.Second)), grpc.StreamInterceptor(grpcStreamInterceptor))
+	imgdpb.RegisterRendererServer(server, &grpcRenderer{router: &Router{}})
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := imgdpb.NewRendererClient(conn)
+
+	render, err := client.Render(context.Background(), &imgdpb.RenderRequest{
+		Player: &imgdpb.RenderRequest_Uuid{Uuid: "d9135e08-2f22-44c8-9cb1-0d21ed3ac8fd"},
+		Type:   "helm",
+		Size:   32,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(render.Image))
+	if err != nil || img.Bounds().Dx() != 32 || render.ContentType != "image/png" || render.Fallback {
+		t.Fatalf("Expected a 32px PNG helm (%v)", err)
+	}
+
+	_, err = client.Render(context.Background(), &imgdpb.RenderRequest{
+		Player: &imgdpb.RenderRequest_User{User: "clone1018"},
+		Type:   "hat",
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected an unknown type to be an invalid argument, got %v", err)
+	}
+
+	stream, err := client.RenderBatch(context.Background(), &imgdpb.RenderBatchRequest{Renders: []*imgdpb.RenderRequest{
+		{Player: &imgdpb.RenderRequest_User{User: "clone1018"}, Size: 16},
+		{Player: &imgdpb.RenderRequest_User{User: "Clone1018"}, Type: "armor/bust", Format: "webp"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var renders []*imgdpb.RenderResponse
+	for {
+		render, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		renders = append(renders, render)
+	}
+	if len(renders) != 2 || renders[0].Index != 0 || renders[1].Index != 1 || renders[1].ContentType != "image/webp" {
+		t.Fatalf("Expected both renders back in order, got %d", len(renders))
+	}
+}
+
+func TestGRPCAdmit(t *testing.T) {
+	stats = MakeStatsCollector()
+	savedChain, savedLimiter := authChain, rateLimiter
+	defer func() {
+		authChain, rateLimiter = savedChain, savedLimiter
+		config.Auth.Required, config.Auth.Signed = false, false
+	}()
+	authChain = []Authenticator{MakeAPIKeyAuthenticator([]string{"bot:s3cret"})}
+	rateLimiter = MakeRateLimiter(0, 1)
+
+	// Calls are limited as HTTP requests are, by their peer's address.
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}})
+	if _, err := grpcAdmit(ctx, imgdpb.Renderer_Render_FullMethodName); err != nil {
+		t.Fatalf("Expected the first call to be admitted, got %v", err)
+	}
+	if _, err := grpcAdmit(ctx, imgdpb.Renderer_Render_FullMethodName); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Expected the second call to be rate limited, got %v", err)
+	}
+
+	// With signed on, they need a key, given in their metadata.
+	rateLimiter = nil
+	config.Auth.Signed = true
+	if _, err := grpcAdmit(ctx, imgdpb.Renderer_Render_FullMethodName); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("Expected an anonymous call to be refused, got %v", err)
+	}
+	keyed := metadata.NewIncomingContext(ctx, metadata.Pairs("x-api-key", "s3cret"))
+	admitted, err := grpcAdmit(keyed, imgdpb.Renderer_Render_FullMethodName)
+	if err != nil {
+		t.Fatalf("Expected a call with a key to be admitted, got %v", err)
+	}
+	if identity, _ := admitted.Value(authContextKey{}).(string); identity != "bot" {
+		t.Fatalf("Expected the call to be from bot, got %q", identity)
+	}
+
+	config.Auth.Signed = false
+	if _, err := grpcAdmit(metadata.NewIncomingContext(ctx, metadata.Pairs("x-api-key", "wrong")), imgdpb.Renderer_Render_FullMethodName); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("Expected a wrong key to be refused, got %v", err)
+	}
+}
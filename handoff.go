@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// How long a handoff may take before we give up and start cold.
+const handoffTimeout = 30 * time.Second
+
+// Hands the cache over a unix socket to the process replacing us in an
+// upgrade, so it starts warm even without a shared cache backend. On
+// startup we take the cache from whoever's listening on the socket, then
+// listen on it ourselves for our own replacement. The cache is sent in the
+// snapshot format.
+type Handoff struct {
+	Path  string
+	Cache snapshottingCache
+	// Whether we took the cache over on startup.
+	Received bool
+	listener *net.UnixListener
+}
+
+func MakeHandoff(path string, cache snapshottingCache) *Handoff {
+	return &Handoff{Path: path, Cache: cache}
+}
+
+// Takes the cache over from the process we're replacing, if one's
+// listening on the socket.
+func (h *Handoff) receive() error {
+	conn, err := net.DialTimeout("unix", h.Path, time.Second)
+	if err != nil {
+		// Nobody to take over from, eg. on first start or after a crash.
+		return nil
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(handoffTimeout))
+
+	if err := h.Cache.restore(conn); err != nil {
+		return err
+	}
+	h.Received = true
+	return nil
+}
+
+// Listens on the socket for our replacement, taking it over from anyone
+// already there.
+func (h *Handoff) listen() error {
+	// Left behind by a process which didn't exit cleanly, or the one we've
+	// just taken over from.
+	if err := os.Remove(h.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// Anyone who can connect gets the cache, so the socket's made only
+	// ours from the start, rather than chmoded once anyone could have.
+	umask := syscall.Umask(0177)
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: h.Path, Net: "unix"})
+	syscall.Umask(umask)
+	if err != nil {
+		return err
+	}
+
+	h.listener = listener
+	go h.serve()
+	return nil
+}
+
+func (h *Handoff) serve() {
+	for {
+		conn, err := h.listener.Accept()
+		if err != nil {
+			return
+		}
+		if h.send(conn) {
+			return
+		}
+	}
+}
+
+// Writes the cache to our replacement, then stops listening, as the socket
+// is theirs from now on. Returns whether it was sent.
+func (h *Handoff) send(conn net.Conn) bool {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(handoffTimeout))
+
+	// Our replacement takes over the socket's path as soon as it's read the
+	// cache, so closing ours mustn't remove it.
+	h.listener.SetUnlinkOnClose(false)
+	start := time.Now()
+	if err := h.Cache.snapshot(conn); err != nil {
+		h.listener.SetUnlinkOnClose(true)
+		log.Errorf("Cache handoff failed (%v)", err)
+		stats.Errored(ErrHandoff)
+		return false
+	}
+
+	log.Noticef("Handed the cache off to our replacement, took %s", time.Since(start))
+	h.listener.Close()
+	return true
+}
+
+func (h *Handoff) Stop() {
+	if h.listener != nil {
+		h.listener.Close()
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/minotar/minecraft"
+)
+
+func TestHandoff(t *testing.T) {
+	stats = MakeStatsCollector()
+	path := filepath.Join(t.TempDir(), "handoff.sock")
+
+	old := &CacheMemory{}
+	old.setup()
+	skin := minecraft.Skin{}
+	skin.Hash = "clone1018"
+	old.add("clone1018", skin, time.Minute)
+	old.addNegative("nobody", NegativeNotFound, time.Minute)
+
+	first := MakeHandoff(path, old)
+	if err := first.receive(); err != nil || first.Received {
+		t.Fatalf("Expected nobody to take over from, got %v", err)
+	}
+	if err := first.listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer first.Stop()
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected the socket to be ours alone, got %v (%v)", info.Mode(), err)
+	}
+
+	replacement := &CacheMemory{}
+	replacement.setup()
+	second := MakeHandoff(path, replacement)
+	if err := second.receive(); err != nil || !second.Received {
+		t.Fatalf("Expected the cache to be handed over, got %v", err)
+	}
+	if !replacement.has("clone1018") || replacement.pullNegative("nobody") != NegativeNotFound {
+		t.Fatal("Expected the old process's entries")
+	}
+
+	if err := second.listen(); err != nil {
+		t.Fatal(err)
+	}
+	first.Stop()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Expected the old process to leave the socket to its replacement, got %v", err)
+	}
+	second.Stop()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Expected the socket to be removed, got %v", err)
+	}
+}
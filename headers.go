@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Extra headers to send with a class of route, eg. for a CDN.
+type ResponseHeaders struct {
+	// Headers as "Name: value", with {username}, {uuid} and {hash} filled
+	// in for the player the response is for.
+	Header []string
+}
+
+type extraHeader struct {
+	Name  string
+	Value string
+}
+
+// Headers from the [headers] config, by class of route.
+var extraHeaders map[string][]extraHeader
+
+var headerPlaceholderRegex = regexp.MustCompile(`\{[^}]*\}`)
+
+var headerNameRegex = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// Parses the [headers] sections, refusing classes of route we don't have
+// and placeholders we can't fill.
+func parseExtraHeaders(sections map[string]*ResponseHeaders) (map[string][]extraHeader, error) {
+	headers := map[string][]extraHeader{}
+	for class, section := range sections {
+		if section == nil {
+			continue
+		}
+		switch class {
+		case CacheClassRender, CacheClassSkin, CacheClassStatus:
+		default:
+			return nil, fmt.Errorf("unknown class of route %q", class)
+		}
+		for _, line := range section.Header {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			name, value, found := strings.Cut(line, ":")
+			name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+			if !found || !headerNameRegex.MatchString(name) {
+				return nil, fmt.Errorf("%q isn't \"Name: value\"", line)
+			}
+			for _, placeholder := range headerPlaceholderRegex.FindAllString(value, -1) {
+				switch placeholder {
+				case "{username}", "{uuid}", "{hash}":
+				default:
+					return nil, fmt.Errorf("unknown placeholder %s in %q", placeholder, line)
+				}
+			}
+			headers[class] = append(headers[class], extraHeader{Name: name, Value: value})
+		}
+	}
+	return headers, nil
+}
+
+// Adds the extra headers for the class of route, filled in for the player
+// the request is for. A header needing what we don't know, eg. the UUID of
+// a player we've yet to look up, is left out rather than sent half filled.
+func setExtraHeaders(w http.ResponseWriter, r *http.Request, class string) {
+	headers := extraHeaders[class]
+	if len(headers) == 0 {
+		return
+	}
+
+	vars := mux.Vars(r)
+	username := strings.ToLower(vars["username"])
+	uuid := ""
+	if username != "" {
+		uuid, _ = lookupUUID(username)
+	}
+	hash := vars["hash"]
+	if hash == "" {
+		hash = accessRecordFor(r).Texture
+	}
+	values := map[string]string{"{username}": username, "{uuid}": uuid, "{hash}": hash}
+
+	for _, header := range headers {
+		complete := true
+		value := headerPlaceholderRegex.ReplaceAllStringFunc(header.Value, func(placeholder string) string {
+			if values[placeholder] == "" {
+				complete = false
+			}
+			return values[placeholder]
+		})
+		if complete {
+			w.Header().Add(header.Name, value)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/minecraft"
+)
+
+func TestParseExtraHeaders(t *testing.T) {
+	headers, err := parseExtraHeaders(map[string]*ResponseHeaders{
+		"render": {Header: []string{"Surrogate-Key: skin-{uuid} render", ""}},
+		"skin":   nil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers["render"]) != 1 || headers["render"][0] != (extraHeader{Name: "Surrogate-Key", Value: "skin-{uuid} render"}) {
+		t.Fatalf("Unexpected headers %v", headers)
+	}
+
+	for _, bad := range []map[string]*ResponseHeaders{
+		{"avatar": {Header: []string{"X-Test: 1"}}},
+		{"render": {Header: []string{"no colon"}}},
+		{"render": {Header: []string{"Bad Name: 1"}}},
+		{"render": {Header: []string{"X-Test: {skin}"}}},
+	} {
+		if _, err := parseExtraHeaders(bad); err == nil {
+			t.Fatalf("Expected %v to be refused", bad)
+		}
+	}
+}
+
+func TestExtraHeaders(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	renderCache = MakeRenderCache(1<<20, false)
+	hotCache = MakeHotCache(nil, 0)
+	missingFilter = MakeMissingFilter(0, time.Minute)
+
+	var err error
+	extraHeaders, err = parseExtraHeaders(map[string]*ResponseHeaders{
+		"render": {Header: []string{"Surrogate-Key: skin-{uuid} render", "X-Texture: {hash}"}},
+		"skin":   {Header: []string{"Cache-Tag: player-{username}"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { extraHeaders = nil }()
+
+	skin, _ := minecraft.FetchSkinForSteve()
+	skin.Hash = "clone1018"
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+	serve := func(path string, etag string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		handler := accessLogHandler(router.Mux)
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("/avatar/d9135e082f2244c89cb10d21ed3ac8fd/32.png", "")
+	if key := w.Header().Get("Surrogate-Key"); key != "skin-d9135e082f2244c89cb10d21ed3ac8fd render" {
+		t.Fatalf("Expected the surrogate key to name the player, got %q", key)
+	}
+	if hash := w.Header().Get("X-Texture"); hash != "clone1018" {
+		t.Fatalf("Expected the texture hash, got %q", hash)
+	}
+	// A 304 carries them too, so the CDN's copy keeps its keys.
+	w = serve("/avatar/d9135e082f2244c89cb10d21ed3ac8fd/32.png", w.Header().Get("ETag"))
+	if w.Code != 304 || w.Header().Get("Surrogate-Key") == "" {
+		t.Fatalf("Expected the headers on a 304, got %d %v", w.Code, w.Header())
+	}
+
+	w = serve("/skin/d9135e082f2244c89cb10d21ed3ac8fd", "")
+	if w.Header().Get("Cache-Tag") != "player-d9135e082f2244c89cb10d21ed3ac8fd" || w.Header().Get("Surrogate-Key") != "" {
+		t.Fatalf("Expected the skin's own headers, got %v", w.Header())
+	}
+
+	// Without a UUID to fill in, the header's left out.
+	r := httptest.NewRequest("GET", "/avatar/nobody", nil)
+	r = mux.SetURLVars(r, map[string]string{"username": "nobody"})
+	w = httptest.NewRecorder()
+	setExtraHeaders(w, r, CacheClassRender)
+	if w.Header().Get("Surrogate-Key") != "" {
+		t.Fatalf("Expected a header missing its UUID to be left out, got %v", w.Header())
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Tracks whether we're ready for traffic, for /readyz.
+type Readiness struct {
+	// Set once we're listening and any warm-up at startup is done.
+	warm atomic.Bool
+}
+
+func MakeReadiness() *Readiness {
+	return &Readiness{}
+}
+
+// Marks us warm once the warm-up we started with, if any, is done.
+func (r *Readiness) warmAfter(w *Warmer) {
+	for w != nil && w.busy() {
+		time.Sleep(time.Second)
+	}
+	r.warm.Store(true)
+}
+
+// Returns why we aren't ready for traffic, if we aren't.
+func (r *Readiness) check() []string {
+	failures := []string{}
+	if pingable, ok := cache.(pingableCache); ok {
+		if err := pingable.ping(); err != nil {
+			failures = append(failures, fmt.Sprintf("cache unreachable (%v)", err))
+		}
+	}
+	if upstream != nil && upstream.Breaker.currentState() == CircuitOpen {
+		failures = append(failures, "upstream circuit open")
+	}
+	if !r.warm.Load() {
+		failures = append(failures, "warming up")
+	}
+	return failures
+}
+
+// Answers /healthz and /readyz ahead of authentication and rate limiting,
+// so Kubernetes probes and load balancer health checks always get through.
+// /healthz only says we're alive, /readyz that we can serve traffic well.
+func healthHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			writeHealth(w, nil)
+		case "/readyz":
+			writeHealth(w, readiness.check())
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func writeHealth(w http.ResponseWriter, failures []string) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if len(failures) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "%s\n", strings.Join(failures, "\n"))
+		return
+	}
+	fmt.Fprintf(w, "ok\n")
+}
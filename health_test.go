@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A cache whose backend we can take away.
+type unreachableCache struct {
+	CacheOff
+	err error
+}
+
+func (c *unreachableCache) ping() error {
+	return c.err
+}
+
+func TestHealth(t *testing.T) {
+	readiness = MakeReadiness()
+	upstream = MakeUpstream(0, 0)
+	backend := &unreachableCache{}
+	cache = backend
+	served := false
+	handler := healthHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = true
+	}))
+
+	probe := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	if w := probe("/healthz"); w.Code != http.StatusOK {
+		t.Fatalf("Expected to be alive, got %d", w.Code)
+	}
+	if w := probe("/readyz"); w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "warming up") {
+		t.Fatalf("Expected not to be ready before warming up, got %d", w.Code)
+	}
+
+	readiness.warmAfter(nil)
+	if w := probe("/readyz"); w.Code != http.StatusOK {
+		t.Fatalf("Expected to be ready, got %d %q", w.Code, w.Body.String())
+	}
+
+	backend.err = errors.New("connection refused")
+	if w := probe("/readyz"); w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "cache unreachable") {
+		t.Fatalf("Expected not to be ready without the cache, got %d", w.Code)
+	}
+	backend.err = nil
+
+	upstream.Breaker = MakeCircuitBreaker(1, time.Minute)
+	upstream.Breaker.allow()
+	upstream.Breaker.failed()
+	if w := probe("/readyz"); w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "circuit open") {
+		t.Fatalf("Expected not to be ready with the circuit open, got %d", w.Code)
+	}
+
+	if probe("/avatar/clone1018"); !served {
+		t.Fatal("Expected other requests to be passed on")
+	}
+}
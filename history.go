@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/imgd/pkg/mcclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Most histories we'll remember at once.
+const historyCacheCount = 10000
+
+// Mirrors which keep track of players' pasts, and how to parse it from
+// their responses. Mojang stopped saying in 2022.
+var historyParsers = map[string]func(body []byte) (History, error){
+	"ashcon":   parseAshconHistory,
+	"playerdb": parsePlayerDBHistory,
+}
+
+// What the mirrors know of a player's past.
+type History struct {
+	UUID     string
+	Username string
+	// Usernames the player has gone by, oldest first.
+	Usernames []NameChange
+	// The skin they wear now. Mirrors don't keep the skins worn before.
+	SkinURL string `json:",omitempty"`
+}
+
+type NameChange struct {
+	Username string
+	// Unset for the name they started with, or if the mirror doesn't know.
+	ChangedAt *time.Time `json:",omitempty"`
+}
+
+// Remembers the histories we've fetched, by username or UUID, as asked for.
+type HistoryCache = TTLCache[History]
+
+func MakeHistoryCache() *HistoryCache {
+	return MakeTTLCache[History](historyCacheCount)
+}
+
+// Whether any of the mirrors keep history.
+func historyEnabled() bool {
+	for _, mirror := range mirrors {
+		if historyParsers[mirror.Kind] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Looks up the player's history, by username or UUID.
+func (m *Mirror) history(ctx context.Context, player string) (History, error) {
+	stats.APIRequested("MirrorHistory")
+	historyTimer := prometheus.NewTimer(getDuration.WithLabelValues("MirrorHistory"))
+	defer historyTimer.ObserveDuration()
+
+	start := time.Now()
+	body, err := m.get(ctx, "GetMirrorHistory", player)
+	var history History
+	if err == nil {
+		history, err = historyParsers[m.Kind](body)
+	}
+	m.record(time.Since(start), err)
+	return history, err
+}
+
+// Returns the player's history from the history cache, or else asks each
+// healthy mirror which keeps history in turn.
+func lookupHistory(ctx context.Context, player string) (History, error) {
+	key := strings.ToLower(player)
+	if history, ok := historyCache.get(key); ok {
+		return history, nil
+	}
+
+	result, err := coalesce("MirrorHistory", key, func() (interface{}, error) {
+		err := fmt.Errorf("unable to GetMirrorHistory: no healthy mirrors")
+		for _, mirror := range mirrors {
+			if historyParsers[mirror.Kind] == nil || !mirror.healthy() {
+				continue
+			}
+			var history History
+			if history, err = mirror.history(ctx, player); err == nil {
+				return history, nil
+			}
+			log.Debugf("Mirror %s failed for %s's history (%s)", mirror.Name, player, err.Error())
+		}
+		return nil, err
+	})
+	if err != nil {
+		return History{}, err
+	}
+	history := result.(History)
+	historyCache.add(key, history, config.historyTtl())
+	return history, nil
+}
+
+// HistoryPage lists the usernames the player has gone by, and the skin they
+// wear now, as far as the mirrors know, for profile pages wanting more than
+// the skin.
+func (router *Router) HistoryPage(w http.ResponseWriter, r *http.Request) {
+	if !historyEnabled() {
+		writeError(w, r, http.StatusNotImplemented, ErrNotEnabled, "no mirror which keeps history is configured")
+		return
+	}
+	stats.Requested("History")
+
+	player := mux.Vars(r)["username"]
+	history, err := lookupHistory(requestContext(r), player)
+	if err != nil && strings.HasSuffix(err.Error(), "user not found") {
+		writeError(w, r, http.StatusNotFound, ErrUserNotFound, "no such player")
+		return
+	} else if err != nil {
+		log.Infof("Failed history lookup: %s (%s)", player, err.Error())
+		stats.Errored(ErrMirrorHistory)
+		writeError(w, r, http.StatusServiceUnavailable, ErrUpstreamTimeout, "unable to reach the mirrors, try again later")
+		return
+	}
+
+	body, _ := json.Marshal(history)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// Parses the username history from an Ashcon style API, oldest first.
+func parseAshconHistory(body []byte) (History, error) {
+	response := struct {
+		UUID            string `json:"uuid"`
+		Username        string `json:"username"`
+		UsernameHistory []struct {
+			Username  string     `json:"username"`
+			ChangedAt *time.Time `json:"changed_at"`
+		} `json:"username_history"`
+		Textures struct {
+			Skin struct {
+				URL string `json:"url"`
+			} `json:"skin"`
+		} `json:"textures"`
+	}{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return History{}, fmt.Errorf("unable to GetMirrorHistory: %v", err)
+	}
+	if response.UUID == "" {
+		return History{}, fmt.Errorf("unable to GetMirrorHistory: user not found")
+	}
+
+	history := History{
+		UUID:      mcclient.NormalizeUUID(response.UUID),
+		Username:  response.Username,
+		Usernames: []NameChange{},
+	}
+	// Ashcon doesn't pass on the signed textures property, so if we only
+	// trust signed skins, we can't vouch for it.
+	if signatureKey == nil {
+		history.SkinURL = response.Textures.Skin.URL
+	}
+	for _, change := range response.UsernameHistory {
+		history.Usernames = append(history.Usernames, NameChange{Username: change.Username, ChangedAt: change.ChangedAt})
+	}
+	return history, nil
+}
+
+// Parses the username history from a PlayerDB style API, which keeps
+// Mojang's old format, with times in Unix milliseconds.
+func parsePlayerDBHistory(body []byte) (History, error) {
+	response := struct {
+		Success bool `json:"success"`
+		Data    struct {
+			Player struct {
+				ID         string               `json:"id"`
+				Username   string               `json:"username"`
+				Properties []sessionProfileProp `json:"properties"`
+				Meta       struct {
+					NameHistory []struct {
+						Name        string `json:"name"`
+						ChangedToAt int64  `json:"changedToAt"`
+					} `json:"name_history"`
+				} `json:"meta"`
+			} `json:"player"`
+		} `json:"data"`
+	}{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return History{}, fmt.Errorf("unable to GetMirrorHistory: %v", err)
+	}
+	if !response.Success || response.Data.Player.ID == "" {
+		return History{}, fmt.Errorf("unable to GetMirrorHistory: user not found")
+	}
+
+	player := response.Data.Player
+	history := History{UUID: mcclient.NormalizeUUID(player.ID), Username: player.Username, Usernames: []NameChange{}}
+	for _, change := range player.Meta.NameHistory {
+		name := NameChange{Username: change.Name}
+		if change.ChangedToAt > 0 {
+			changed := time.UnixMilli(change.ChangedToAt).UTC()
+			name.ChangedAt = &changed
+		}
+		history.Usernames = append(history.Usernames, name)
+	}
+	// The skin's only worth listing if it's one we'd trust.
+	profile := Profile{}
+	if err := applyProperties(&profile, player.Properties); err == nil {
+		history.SkinURL = profile.SkinURL
+	}
+	return history, nil
+}
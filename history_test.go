@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/minecraft"
+)
+
+func TestParseHistory(t *testing.T) {
+	history, err := parseAshconHistory([]byte(`{"uuid":"d9135e08-2f22-44c8-9cb1-0d21ed3ac8fd","username":"clone1018","username_history":[{"username":"clone"},{"username":"clone1018","changed_at":"2015-02-04T15:03:32.000Z"}],"textures":{"skin":{"url":"http://textures/abc"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.UUID != "d9135e082f2244c89cb10d21ed3ac8fd" || len(history.Usernames) != 2 || history.Usernames[0].ChangedAt != nil || history.Usernames[1].ChangedAt.Year() != 2015 || history.SkinURL != "http://textures/abc" {
+		t.Fatalf("Unexpected history %+v", history)
+	}
+
+	history, err = parsePlayerDBHistory([]byte(`{"success":true,"data":{"player":{"id":"d9135e082f2244c89cb10d21ed3ac8fd","username":"clone1018","meta":{"name_history":[{"name":"clone"},{"name":"clone1018","changedToAt":1423062212000}]}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Usernames) != 2 || history.Usernames[1].ChangedAt.Unix() != 1423062212 {
+		t.Fatalf("Unexpected history %+v", history)
+	}
+
+	if _, err := parsePlayerDBHistory([]byte(`{"success":false}`)); err == nil {
+		t.Fatal("Expected a missing player to be an error")
+	}
+}
+
+func TestHistoryPage(t *testing.T) {
+	stats = MakeStatsCollector()
+	historyCache = MakeHistoryCache()
+	config.Ttl.History = 60
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.Mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	if w := serve("/api/history/clone1018"); w.Code != http.StatusNotImplemented {
+		t.Fatalf("Expected history to need a mirror, got %d", w.Code)
+	}
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if !strings.EqualFold(r.URL.Path, "/ashcon/clone1018") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"uuid":"d9135e08-2f22-44c8-9cb1-0d21ed3ac8fd","username":"clone1018","username_history":[{"username":"clone1018"}]}`)
+	}))
+	defer server.Close()
+	mcClient = &minecraft.Minecraft{Client: server.Client()}
+	var err error
+	if mirrors, err = MakeMirrors([]string{"ashcon:" + server.URL + "/ashcon/"}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { mirrors = nil }()
+
+	for i := 0; i < 2; i++ {
+		w := serve("/api/history/Clone1018")
+		history := History{}
+		if err := json.Unmarshal(w.Body.Bytes(), &history); err != nil || len(history.Usernames) != 1 || history.Usernames[0].Username != "clone1018" {
+			t.Fatalf("Expected the history, got %d %s", w.Code, w.Body.String())
+		}
+	}
+	if requests != 1 {
+		t.Fatalf("Expected the history to be cached, got %d requests", requests)
+	}
+
+	if w := serve("/api/history/nobody"); w.Code != http.StatusNotFound || w.Header().Get(ErrorHeader) != string(ErrUserNotFound) {
+		t.Fatalf("Expected a missing player to be a 404, got %d", w.Code)
+	}
+}
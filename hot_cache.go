@@ -0,0 +1,159 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A render kept encoded for a player, with its ETag.
+type hotRender struct {
+	Key string
+	// The player as requested, and their UUID if we knew it, for purges.
+	Player  string
+	UUID    string
+	Data    []byte
+	ETag    string
+	Expires time.Time
+}
+
+// Keeps the encoded renders of the most requested widths by player, so the
+// hottest requests, plain avatars at the usual sizes, are written straight
+// out without fetching the skin, rendering or encoding. Only renders without
+// options are kept, as they're what nearly everyone asks for. Renders expire
+// when the player's skin goes stale, and go when the player is purged.
+type HotCache struct {
+	Sizes map[uint]bool
+	// Renders to keep, 0 to disable the cache.
+	MaxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// Renders, most recently used at the front.
+	recency *list.List
+}
+
+func MakeHotCache(sizes []uint, maxEntries int) *HotCache {
+	c := &HotCache{
+		Sizes:      map[uint]bool{},
+		MaxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		recency:    list.New(),
+	}
+	for _, size := range sizes {
+		c.Sizes[size] = true
+	}
+	return c
+}
+
+// Returns the key a player's render is kept under.
+func hotKey(resource string, width uint, extension string, player string) string {
+	return fmt.Sprintf("%s|%d|%s|%s", resource, width, extension, strings.ToLower(player))
+}
+
+// Whether the request is for a render we keep: one of our widths, with no
+// options.
+func (c *HotCache) eligible(r *http.Request, width uint) bool {
+	return c.MaxEntries > 0 && c.Sizes[width] && r.URL.RawQuery == ""
+}
+
+// Returns the player's render, marking it as the most recently used.
+func (c *HotCache) get(key string) (*hotRender, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, exists := c.entries[key]
+	if !exists {
+		return nil, false
+	}
+	render := elem.Value.(*hotRender)
+	if time.Now().After(render.Expires) {
+		c.unlink(elem)
+		return nil, false
+	}
+	c.recency.MoveToFront(elem)
+	cacheCounter.WithLabelValues("hot_hit").Inc()
+	return render, true
+}
+
+// Keeps the player's render until their skin goes stale, evicting the
+// least recently used render if we're full.
+func (c *HotCache) add(key string, player string, data []byte, etag string) {
+	if c.MaxEntries <= 0 {
+		return
+	}
+
+	uuid, _ := lookupUUID(player)
+	ttl := config.skinTtl()
+	if aging, ok := cache.(agingCache); ok && uuid != "" {
+		if remaining, ok := aging.expiresIn(uuid); ok {
+			ttl = remaining - config.staleTtl()
+		}
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	render := &hotRender{
+		Key:     key,
+		Player:  strings.ToLower(player),
+		UUID:    uuid,
+		Data:    data,
+		ETag:    etag,
+		Expires: time.Now().Add(ttl),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, exists := c.entries[key]; exists {
+		c.unlink(elem)
+	}
+	c.entries[key] = c.recency.PushFront(render)
+	for len(c.entries) > c.MaxEntries {
+		c.unlink(c.recency.Back())
+	}
+}
+
+// Must be called with the lock held.
+func (c *HotCache) unlink(elem *list.Element) {
+	render := c.recency.Remove(elem).(*hotRender)
+	delete(c.entries, render.Key)
+}
+
+// Drops the player's renders, whether they were asked for by username or
+// UUID.
+func (c *HotCache) purge(username string, uuid string) {
+	username = strings.ToLower(username)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for elem := c.recency.Front(); elem != nil; {
+		next := elem.Next()
+		render := elem.Value.(*hotRender)
+		if render.Player == username || (uuid != "" && (render.UUID == uuid || render.Player == uuid)) {
+			c.unlink(elem)
+		}
+		elem = next
+	}
+}
+
+// Drops every render.
+func (c *HotCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = map[string]*list.Element{}
+	c.recency = list.New()
+}
+
+func (c *HotCache) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries)
+}
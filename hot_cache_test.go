@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/minecraft"
+)
+
+func TestHotCacheEligible(t *testing.T) {
+	hot := MakeHotCache([]uint{32, 180}, 10)
+	for path, eligible := range map[string]bool{
+		"/avatar/clone1018/32":         true,
+		"/avatar/clone1018/33":         false,
+		"/avatar/clone1018/32?ears=1":  false,
+		"/avatar/clone1018/32?label=1": false,
+	} {
+		width := uint(32)
+		if path == "/avatar/clone1018/33" {
+			width = 33
+		}
+		if hot.eligible(httptest.NewRequest("GET", path, nil), width) != eligible {
+			t.Errorf("Expected %s being kept to be %v", path, eligible)
+		}
+	}
+	if MakeHotCache([]uint{32}, 0).eligible(httptest.NewRequest("GET", "/avatar/clone1018/32", nil), 32) {
+		t.Error("Expected nothing to be kept with the cache disabled")
+	}
+}
+
+func TestHotCacheEvictsAndPurges(t *testing.T) {
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	uuidCache.add("clone1018", "d9135e082f2244c89cb10d21ed3ac8fd", time.Minute)
+
+	hot := MakeHotCache([]uint{32}, 2)
+	hot.add(hotKey("Avatar", 32, ".png", "clone1018"), "clone1018", []byte("a"), `"a"`)
+	hot.add(hotKey("Avatar", 32, ".png", "lukegb"), "lukegb", []byte("b"), `"b"`)
+	hot.get(hotKey("Avatar", 32, ".png", "clone1018"))
+	hot.add(hotKey("Avatar", 32, ".png", "citricsquid"), "citricsquid", []byte("c"), `"c"`)
+	if _, ok := hot.get(hotKey("Avatar", 32, ".png", "lukegb")); ok {
+		t.Fatal("Expected the least recently used render to be evicted")
+	}
+
+	// Uploads and the watcher purge by UUID alone.
+	hot.purge("d9135e082f2244c89cb10d21ed3ac8fd", "d9135e082f2244c89cb10d21ed3ac8fd")
+	if _, ok := hot.get(hotKey("Avatar", 32, ".png", "clone1018")); ok {
+		t.Fatal("Expected purging the player's UUID to drop renders asked for by username")
+	}
+	if hot.size() != 1 {
+		t.Fatalf("Expected only citricsquid's render to be left, got %d", hot.size())
+	}
+}
+
+func TestHotCacheServes(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	renderCache = MakeRenderCache(0, false)
+	hotCache = MakeHotCache([]uint{32}, 10)
+	defer func() { hotCache = MakeHotCache(nil, 0) }()
+	missingFilter = MakeMissingFilter(0, time.Minute)
+
+	skin, _ := minecraft.FetchSkinForSteve()
+	skin.Hash = "clone1018"
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Hour)
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.Mux.ServeHTTP(w, r)
+		return w
+	}
+
+	path := "/avatar/d9135e082f2244c89cb10d21ed3ac8fd/32.png"
+	first := serve(httptest.NewRequest("GET", path, nil))
+	if first.Code != http.StatusOK || hotCache.size() != 1 {
+		t.Fatalf("Expected the render to be kept, got %d with %d kept", first.Code, hotCache.size())
+	}
+
+	// Were the skin needed, there'd be none to render.
+	cache.remove("d9135e082f2244c89cb10d21ed3ac8fd")
+	second := serve(httptest.NewRequest("GET", path, nil))
+	if !bytes.Equal(second.Body.Bytes(), first.Body.Bytes()) || second.Header().Get("ETag") != first.Header().Get("ETag") {
+		t.Fatal("Expected the kept render to be served as it was")
+	}
+
+	r := httptest.NewRequest("GET", path, nil)
+	r.Header.Set("If-None-Match", first.Header().Get("ETag"))
+	if w := serve(r); w.Code != http.StatusNotModified {
+		t.Fatalf("Expected the kept render to be revalidated with a 304, got %d", w.Code)
+	}
+
+	purgePlayer("d9135e082f2244c89cb10d21ed3ac8fd", "d9135e082f2244c89cb10d21ed3ac8fd")
+	if hotCache.size() != 0 {
+		t.Fatal("Expected purging the player to drop their render")
+	}
+}
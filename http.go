@@ -1,11 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"path"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/minotar/imgd/pkg/mcskin"
 	"github.com/minotar/minecraft"
 
 	"github.com/gorilla/mux"
@@ -19,18 +27,14 @@ type Router struct {
 
 // Middleware function to manipulate our request and response.
 func imgdHandler(router http.Handler) http.Handler {
-	return metricChain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding")
-		router.ServeHTTP(w, r)
-	}))
+	return traceHandler(metricChain(requestIDHandler(accessLogHandler(recoverHandler(security.handler(cors.handler(router)))))))
 }
 
 func metricChain(router http.Handler) http.Handler {
 	return promhttp.InstrumentHandlerInFlight(inFlightGauge,
 		promhttp.InstrumentHandlerDuration(requestDuration,
 			promhttp.InstrumentHandlerResponseSize(responseSize, router),
+			promhttp.WithExemplarFromContext(exemplarFor),
 		),
 	)
 }
@@ -41,7 +45,6 @@ type NotFoundHandler struct{}
 func (h NotFoundHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNotFound)
 	fmt.Fprintf(w, "404 not found")
-	log.Infof("%s %s 404", r.RemoteAddr, r.RequestURI)
 }
 
 // GetWidth converts and sanitizes the string for the avatar width.
@@ -64,27 +67,68 @@ func (router *Router) SkinPage(w http.ResponseWriter, r *http.Request) {
 	stats.Requested("Skin")
 	vars := mux.Vars(r)
 	username := vars["username"]
-	skin := fetchSkin(username)
+	// Peers asking us for a skin shouldn't cause us to ask our own peers.
+	fromPeer := r.Header.Get(PeerHeader) != ""
+	skin := fetchSkinForRequest(r, username, !fromPeer)
+	accessRecordFor(r).noteSkin(skin)
 
-	if r.Header.Get("If-None-Match") == skin.Skin.Hash {
-		w.WriteHeader(http.StatusNotModified)
-		log.Infof("%s %s 304 %s", r.RemoteAddr, r.RequestURI, skin.Skin.Source)
+	if fromPeer && skin.Fallback {
+		NotFoundHandler{}.ServeHTTP(w, r)
 		return
 	}
 
-	w.Header().Add("Cache-Control", fmt.Sprintf("public, max-age=%d", config.Server.Ttl))
-	w.Header().Add("ETag", skin.Hash)
+	etag := quoteETag(textureKey(skin.Skin))
+	if writeNotModified(w, r, etag, CacheClassSkin) {
+		return
+	}
+
+	if w.Header().Get("Content-Disposition") == "" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s.png\"", username))
+	}
+	setCacheHeaders(w, r, CacheClassSkin)
+	w.Header().Add("ETag", etag)
 	w.Header().Add("Content-Type", "image/png")
-	skin.WriteSkin(w)
-	log.Infof("%s %s 200 %s", r.RemoteAddr, r.RequestURI, skin.Skin.Source)
+	writeSkin(w, r, skin)
 }
 
 // DownloadPage shows the skin and tells the browser to attempt to download it.
 func (router *Router) DownloadPage(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Content-Disposition", "attachment; filename=\"skin.png\"")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.png\"", mux.Vars(r)["username"]))
 	router.SkinPage(w, r)
 }
 
+// SkinURLPage redirects to where the user's skin is hosted, or with ?json=1
+// just says where, for integrations which only need the URL.
+func (router *Router) SkinURLPage(w http.ResponseWriter, r *http.Request) {
+	stats.Requested("SkinURL")
+	username := mux.Vars(r)["username"]
+	skin := fetchSkinForRequest(r, username, true)
+	accessRecordFor(r).noteSkin(skin)
+	if skin.Fallback || skin.Skin.URL == "" {
+		NotFoundHandler{}.ServeHTTP(w, r)
+		return
+	}
+
+	setCacheHeaders(w, r, CacheClassSkin)
+	if r.URL.Query().Get("json") == "1" {
+		body, _ := json.Marshal(struct {
+			Username string
+			URL      string
+			Slim     bool
+		}{username, skin.Skin.URL, skin.Slim})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+		return
+	}
+	http.Redirect(w, r, skin.Skin.URL, http.StatusFound)
+}
+
+// The renders we serve, each under its lowercased name, eg. /armor/bust/.
+var renderResources = []string{
+	"Avatar", "Helm", "Cube", "Bust", "Body",
+	"Armor/Bust", "Armour/Bust", "Armor/Body", "Armour/Body",
+}
+
 // ResolveMethod pulls the Get<resource> method from the skin. Originally this used
 // reflection, but that was slow.
 func (router *Router) ResolveMethod(skin *mcSkin, resource string) func(int) error {
@@ -112,6 +156,44 @@ func (router *Router) ResolveMethod(skin *mcSkin, resource string) func(int) err
 	}
 }
 
+// GetRenderOptions reads the optional render tweaks from the query string,
+// over those of the preset asked for, if any.
+func (router *Router) GetRenderOptions(r *http.Request) mcskin.Options {
+	query := r.URL.Query()
+	opts := mcskin.Options{}
+	if preset, _ := presetFor(r); preset != nil {
+		opts = preset.options(mux.Vars(r)["username"])
+	}
+
+	if angle, err := strconv.ParseFloat(query.Get("armangle"), 64); err == nil {
+		opts.ArmAngle = math.Max(0, math.Min(angle, mcskin.MaxArmAngle))
+	}
+	if query.Has("walking") {
+		opts.Walking = query.Get("walking") == "1"
+	}
+	if query.Has("ears") {
+		opts.Ears = query.Get("ears") == "1"
+	}
+	if query.Has("trim") {
+		opts.Trim = query.Get("trim") == "1"
+	}
+
+	if query.Has("label") {
+		opts.Label = ""
+		if query.Get("label") == "1" {
+			opts.Label = mux.Vars(r)["username"]
+		}
+	}
+	if text := query.Get("labeltext"); text != "" && opts.Label != "" {
+		opts.Label = text
+	}
+	if name := query.Get("theme"); name != "" {
+		opts.Background = themes[name]
+	}
+
+	return opts
+}
+
 func (router *Router) getResizeMode(ext string) string {
 	switch ext {
 	case ".svg":
@@ -121,143 +203,677 @@ func (router *Router) getResizeMode(ext string) string {
 	}
 }
 
-func (router *Router) writeType(ext string, skin *mcSkin, w http.ResponseWriter) {
-	w.Header().Add("Cache-Control", fmt.Sprintf("public, max-age=%d", config.Server.Ttl))
-	w.Header().Add("ETag", skin.Hash)
+// Buffers to encode into, reused as most encodes of a format come out much
+// the same size.
+var encodeBuffers = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// Encodes the processed image in the requested format.
+func (router *Router) encodeType(ext string, skin *mcSkin) ([]byte, error) {
+	buf := encodeBuffers.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		encodeBuffers.Put(buf)
+	}()
+	var err error
 	switch ext {
 	case ".svg":
-		w.Header().Add("Content-Type", "image/svg+xml")
-		skin.WriteSVG(w)
+		err = skin.WriteSVG(buf)
+	case ".webp":
+		err = skin.WriteWebP(buf)
+	case ".gif":
+		err = skin.WriteGIF(buf)
 	default:
-		w.Header().Add("Content-Type", "image/png")
-		skin.WritePNG(w)
+		err = skin.WritePNG(buf)
+	}
+	// The buffer's going back in the pool, so the caller needs a copy.
+	return append([]byte(nil), buf.Bytes()...), err
+}
+
+// Sets the headers for a render, which is all a HEAD request gets.
+func (router *Router) writeTypeHeaders(ext string, etag string, w http.ResponseWriter, r *http.Request) {
+	setCacheHeaders(w, r, renderCacheClass(r))
+	w.Header().Add("ETag", etag)
+	w.Header().Set("Accept-Ranges", "bytes")
+	contentType, known := renderFormats[ext]
+	if !known {
+		contentType = renderFormats[".png"]
+	}
+	w.Header().Add("Content-Type", contentType)
+}
+
+func (router *Router) writeType(ext string, etag string, data []byte, w http.ResponseWriter, r *http.Request) {
+	router.writeTypeHeaders(ext, etag, w, r)
+	writeBody(w, r, data)
+}
+
+// Writes the body with its length, or just the length for a HEAD request.
+// Clients may ask for part of it with a Range header, eg. to resume a large
+// batch download, and CDNs which only fetch in ranges can sit in front. An
+// If-Range which doesn't match the ETag gets the whole body.
+func writeBody(w http.ResponseWriter, r *http.Request, data []byte) {
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+// Writes the raw skin, as a PNG.
+func writeSkin(w http.ResponseWriter, r *http.Request, skin *mcSkin) {
+	buf := encodeBuffers.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		encodeBuffers.Put(buf)
+	}()
+	skin.WriteSkin(buf)
+	writeBody(w, r, buf.Bytes())
+}
+
+// Renders the skin as the resource, encoded for the extension. Heavy renders
+// wait their turn on the render pool, and fail with errRenderQueueFull if
+// there's no room to, or the context's error if it's done while they wait.
+func (router *Router) render(ctx context.Context, resource string, width uint, ext string, skin *mcSkin) ([]byte, error) {
+	trace := debugTraceFrom(ctx)
+	start := time.Now()
+	if plugin := pluginRenderers[resource]; plugin != nil {
+		// Drawn elsewhere, so there's no CPU of ours to pool.
+		data, err := plugin.render(ctx, width, ext, skin)
+		trace.renderStage("plugin", time.Since(start))
+		return data, err
+	}
+	if renderPool == nil || !renderPool.heavy(resource, width) {
+		data, err := router.draw(resource, width, ext, skin)
+		trace.noteRender(skin.Timings)
+		return data, err
+	}
+
+	var data []byte
+	var err error
+	if poolErr := renderPool.do(ctx, func() {
+		trace.renderStage("queue", time.Since(start))
+		data, err = router.draw(resource, width, ext, skin)
+	}); poolErr != nil {
+		return nil, poolErr
+	}
+	trace.noteRender(skin.Timings)
+	return data, err
+}
+
+// Renders the skin as the resource, encoded for the extension, timing each
+// stage.
+func (router *Router) draw(resource string, width uint, ext string, skin *mcSkin) ([]byte, error) {
+	processingTimer := prometheus.NewTimer(processingDuration.WithLabelValues(resource))
+	defer processingTimer.ObserveDuration()
+
+	start := time.Now()
+	if err := router.ResolveMethod(skin, resource)(int(width)); err != nil {
+		return nil, err
+	}
+	// The render's no use once it's encoded.
+	defer skin.Release()
+	skin.PostProcess()
+	// What isn't cutting out or resizing is drawing.
+	skin.Timings.Composite = time.Since(start) - skin.Timings.Extract - skin.Timings.Scale
+	start = time.Now()
+	data, err := router.encodeType(ext, skin)
+	skin.Timings.Encode = time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+	observeRender(resource, ext, skin.Timings)
+	return data, nil
+}
+
+// Returns the URL of a render pinned to a texture hash, with the hash
+// swapped for the one given, or taken out if it's blank. The query's kept.
+func pinnedURL(r *http.Request, hash string) string {
+	u := *r.URL
+	u.Path = path.Dir(u.Path)
+	if hash != "" {
+		u.Path += "/" + hash
 	}
+	u.Path += mux.Vars(r)["extension"]
+	u.RawPath = ""
+	return u.RequestURI()
 }
 
 // Serve binds the route and makes a handler function for the requested resource.
 func (router *Router) Serve(resource string) {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
-		width := router.GetWidth(vars["width"])
-		skin := fetchSkin(vars["username"])
-		skin.Mode = router.getResizeMode(vars["extension"])
+		ext := vars["extension"]
+		if ext == ".svg" && !routeEnabled("svg") {
+			NotFoundHandler{}.ServeHTTP(w, r)
+			return
+		}
+		// Without an extension, they get the best format they say they take.
+		negotiated := ext == ""
+		if negotiated {
+			ext = negotiateFormat(r.Header.Get("Accept"))
+			w.Header().Add("Vary", "Accept")
+		}
+		if plugin := pluginRenderers[resource]; plugin != nil {
+			var ok bool
+			if ext, ok = plugin.format(ext, negotiated); !ok {
+				NotFoundHandler{}.ServeHTTP(w, r)
+				return
+			}
+		}
+		if _, known := presetFor(r); !known {
+			writeError(w, r, http.StatusBadRequest, ErrBadRequest, "unknown preset")
+			return
+		}
+		if !themeKnown(r) {
+			writeError(w, r, http.StatusBadRequest, ErrBadRequest, "unknown theme")
+			return
+		}
+		width, allowed := router.routeWidth(resource, requestedWidth(r))
+		if !allowed {
+			writeError(w, r, http.StatusBadRequest, ErrBadRequest, "size not allowed")
+			return
+		}
+		player := vars["username"]
+		// The plainest, most common renders are kept by player, so don't
+		// need the skin at all.
+		hot := ""
+		_, byHash := vars["hash"]
+		_, pinned := vars["texturehash"]
+		trace := debugTraceFrom(r.Context())
+		if !byHash && !pinned && hotCache.eligible(r, width) {
+			hot = hotKey(resource, width, ext, player)
+			start := time.Now()
+			render, ok := hotCache.get(hot)
+			trace.lookup("hot", hot, ok, start)
+			if ok {
+				stats.Requested(resource)
+				stats.HitRenderCache()
+				record := accessRecordFor(r)
+				record.SkinCache = "hit"
+				record.Cache = "hot"
+				if writeNotModified(w, r, render.ETag, CacheClassRender) {
+					return
+				}
+				router.writeType(ext, render.ETag, render.Data, w, r)
+				return
+			}
+		}
+		var skin *mcSkin
+		if hash, byHash := vars["hash"]; byHash {
+			player = hash
+			skin = fetchSkinByHash(requestContext(r), hash)
+		} else {
+			skin = fetchSkinForRequest(r, player, true)
+		}
+		if r.Context().Err() == context.Canceled {
+			// They've gone, so there's nobody to render for.
+			return
+		}
+		if pinned && !strings.EqualFold(vars["texturehash"], mojangTextureHash(skin.Skin)) {
+			if skin.Fallback {
+				// We don't know their skin right now, so can't say which
+				// URL is theirs.
+				http.Redirect(w, r, pinnedURL(r, ""), http.StatusFound)
+				return
+			}
+			// Their skin's changed, and so has its URL. The redirect's only
+			// good until it changes again.
+			setCacheHeaders(w, r, CacheClassRender)
+			http.Redirect(w, r, pinnedURL(r, mojangTextureHash(skin.Skin)), http.StatusMovedPermanently)
+			return
+		}
+		if skin.Fallback && r.URL.Query().Get("fallback") == "identicon" {
+			skin.Skin = identiconSkin(player)
+		}
+		record := accessRecordFor(r)
+		record.noteSkin(skin)
+		trace.noteSkin(skin)
+		skin.Mode = router.getResizeMode(ext)
+		skin.Options = router.GetRenderOptions(r)
 		stats.Requested(resource)
 
-		if r.Header.Get("If-None-Match") == skin.Skin.Hash {
-			w.WriteHeader(http.StatusNotModified)
-			log.Infof("%s %s 304 %s", r.RemoteAddr, r.RequestURI, skin.Skin.Source)
+		key := renderKey(resource, width, ext, skin)
+		etag := renderETag(key, skin)
+		if writeNotModified(w, r, etag, renderCacheClass(r)) {
 			return
 		}
 
-		processingTimer := prometheus.NewTimer(processingDuration.WithLabelValues(resource))
-		err := router.ResolveMethod(skin, resource)(int(width))
-		processingTimer.ObserveDuration()
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "500 internal server error")
-			log.Infof("%s %s 500 %s", r.RemoteAddr, r.RequestURI, skin.Skin.Source)
-			stats.Errored("InternalServerError")
+		if data, ok := defaultRender(key); ok {
+			record.Cache = "default"
+			router.writeType(ext, etag, data, w, r)
+			return
+		}
+		start := time.Now()
+		data, ok := renderCache.get(key)
+		trace.lookup("render", key, ok, start)
+		if ok {
+			stats.HitRenderCache()
+			record.Cache = "hit"
+			if hot != "" && !skin.Fallback {
+				hotCache.add(hot, player, data, etag)
+			}
+			router.writeType(ext, etag, data, w, r)
+			return
+		}
+		if renderCache.enabled() {
+			stats.MissRenderCache()
+			record.Cache = "miss"
+		}
+		data, err := router.render(r.Context(), resource, width, ext, skin)
+		if err == errRenderQueueFull || err == context.DeadlineExceeded {
+			// Either way, we can't render for them in time.
+			writeRenderQueueFull(w, r)
+			return
+		} else if err == context.Canceled {
 			return
+		} else if err != nil {
+			writeInternalError(w, r, ErrRenderFailed)
+			stats.Errored(ErrRenderFailed)
+			return
+		}
+		renderCache.add(key, data)
+		if hot != "" && !skin.Fallback {
+			hotCache.add(hot, player, data, etag)
 		}
-		router.writeType(vars["extension"], skin, w)
-		log.Infof("%s %s 200 %s", r.RemoteAddr, r.RequestURI, skin.Skin.Source)
+		router.writeType(ext, etag, data, w, r)
 	}
 
-	router.Mux.HandleFunc("/"+strings.ToLower(resource)+"/{username:"+minecraft.ValidUsernameRegex+"}{extension:(?:\\..*)?}", fn)
-	router.Mux.HandleFunc("/"+strings.ToLower(resource)+"/{username:"+minecraft.ValidUsernameRegex+"}/{width:[0-9]+}{extension:(?:\\..*)?}", fn)
+	if group := resourceGroup(resource); group != "" && !routeEnabled(group) {
+		return
+	}
+	fn = requireSignature(fn)
+	router.Mux.HandleFunc("/"+strings.ToLower(resource)+"/{username:"+playerRegex+"}{extension:(?:\\..*)?}", fn)
+	router.Mux.HandleFunc("/"+strings.ToLower(resource)+"/{username:"+playerRegex+"}/{width:[0-9]+}{extension:(?:\\..*)?}", fn)
+	// Naming the texture lets the render be cached forever, see
+	// renderCacheClass.
+	router.Mux.HandleFunc("/"+strings.ToLower(resource)+"/{username:"+playerRegex+"}/{width:[0-9]+}/{texturehash:"+textureHashRegex+"}{extension:(?:\\..*)?}", fn)
+	if routeEnabled("textures") {
+		router.Mux.HandleFunc("/texture/{hash:"+textureHashRegex+"}/"+strings.ToLower(resource)+"{extension:(?:\\..*)?}", fn)
+		router.Mux.HandleFunc("/texture/{hash:"+textureHashRegex+"}/"+strings.ToLower(resource)+"/{width:[0-9]+}{extension:(?:\\..*)?}", fn)
+	}
 }
 
 // Bind routes to the ServerMux.
 func (router *Router) Bind() {
 
 	router.Mux.NotFoundHandler = NotFoundHandler{}
+	router.Mux.Use(recordRoute)
 
-	router.Serve("Avatar")
-	router.Serve("Helm")
-	router.Serve("Cube")
-	router.Serve("Bust")
-	router.Serve("Body")
-	router.Serve("Armor/Bust")
-	router.Serve("Armour/Bust")
-	router.Serve("Armor/Body")
-	router.Serve("Armour/Body")
+	for _, resource := range renderResources {
+		router.Serve(resource)
+	}
+	for _, resource := range pluginResources() {
+		router.Serve(resource)
+	}
 
-	router.Mux.HandleFunc("/download/{username:"+minecraft.ValidUsernameRegex+"}{extension:(?:.png)?}", router.DownloadPage)
-	router.Mux.HandleFunc("/skin/{username:"+minecraft.ValidUsernameRegex+"}{extension:(?:.png)?}", router.SkinPage)
+	if routeEnabled("skins") {
+		router.Mux.HandleFunc("/download/{username:"+playerRegex+"}{extension:(?:.png)?}", requireSignature(router.DownloadPage))
+		router.Mux.HandleFunc("/skin/{username:"+playerRegex+"}{extension:(?:.png)?}", requireSignature(router.SkinPage))
+		router.Mux.HandleFunc("/skinurl/{username:"+playerRegex+"}", requireSignature(router.SkinURLPage))
+		router.Mux.HandleFunc("/texture/{hash:"+textureHashRegex+"}{extension:(?:.png)?}", requireSignature(router.TexturePage))
+		if len(config.Cape.Provider) > 0 {
+			router.Mux.HandleFunc("/cape/{username:"+playerRegex+"}{extension:(?:.png)?}", requireSignature(router.CapePage))
+		}
+	}
+
+	if routeEnabled("cards") {
+		router.Mux.HandleFunc("/card/{username:"+playerRegex+"}{extension:(?:\\.png|\\.webp)?}", requireSignature(router.CardPage))
+	}
+	if routeEnabled("montages") {
+		router.Mux.HandleFunc("/montage/{users:[^/]+?}{extension:(?:\\.png|\\.webp)?}", requireSignature(router.MontagePage))
+	}
 
 	router.Mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "%s\n", ImgdVersion)
-		log.Infof("%s %s 200", r.RemoteAddr, r.RequestURI)
 	})
 
-	router.Mux.Handle("/metrics", promhttp.Handler())
+	if routeEnabled("api") {
+		router.BindAPI()
+	}
+	if config.Server.InternalAddress == "" {
+		router.BindInternal()
+	}
+
+	router.Mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, config.Server.URL, http.StatusFound)
+	})
+}
+
+// Bind the routes for operators rather than the public: metrics, status
+// and admin. With an internal address configured, they're served only on
+// that, along with /debug/vars, which shows our command line and memory
+// stats so is never served alongside the images.
+func (router *Router) BindInternal() {
+	// Exemplars are only sent to scrapers asking for OpenMetrics.
+	router.Mux.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})))
+	if config.Server.InternalAddress != "" {
+		router.Mux.Handle("/debug/vars", expvarHandler())
+	}
+
+	router.BindAdmin()
 
 	router.Mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		setCacheHeaders(w, r, CacheClassStatus)
 		w.Header().Set("Content-Type", "application/json")
 		w.Write(stats.ToJSON())
-		log.Infof("%s %s 200", r.RemoteAddr, r.RequestURI)
 	})
 
-	router.Mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		http.Redirect(w, r, config.Server.URL, http.StatusFound)
-		log.Infof("%s %s 200", r.RemoteAddr, r.RequestURI)
+	router.Mux.HandleFunc("/status/timeseries", func(w http.ResponseWriter, r *http.Request) {
+		setCacheHeaders(w, r, CacheClassStatus)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(stats.TimeSeries.ToJSON())
 	})
 }
 
 func fetchSkin(username string) *mcSkin {
+	return fetchSkinVia(context.Background(), username, true)
+}
+
+// Fetches the skin from the cache or upstream. Skins are cached by UUID, so
+// a username changing hands can't serve the wrong skin. If upstream is
+// unavailable and usePeers is set, the configured peer instances are asked
+// for it before we fall back to Steve.
+func fetchSkinVia(ctx context.Context, username string, usePeers bool) *mcSkin {
 	if username == "char" || username == "MHF_Steve" {
 		skin, _ := minecraft.FetchSkinForSteve()
-		return &mcSkin{Skin: skin}
+		return &mcSkin{Render: mcskin.Render{Skin: skin}}
+	}
+	// Players given by UUID are remembered by it, however it was written.
+	if uuid, ok := normalizeUUID(username); ok {
+		username = uuid
+		if isOfflineUUID(uuid) {
+			return fetchOfflineSkin(ctx, uuid)
+		}
+	}
+	if isBedrockGamertag(username) {
+		uuid, reason := resolveGamertag(ctx, username)
+		if reason != NegativeNone {
+			return fallbackFor("")
+		}
+		username = uuid
+	}
+	if config.Bedrock.URL != "" && isFloodgateUUID(username) {
+		return fetchBedrockSkin(ctx, username)
 	}
 
-	hasTimer := prometheus.NewTimer(cacheDuration.WithLabelValues("has"))
-	if cache.has(strings.ToLower(username)) {
-		hasTimer.ObserveDuration()
-		pullTimer := prometheus.NewTimer(cacheDuration.WithLabelValues("pull"))
-		defer pullTimer.ObserveDuration()
+	trace := debugTraceFrom(ctx)
+	// Players we know the UUID of, and have cached, don't need Mojang at all.
+	start := time.Now()
+	knownUUID, known := lookupUUID(username)
+	if known {
+		trace.lookup("uuid", username, true, start)
+		start = time.Now()
+		skin := pullCachedSkin(knownUUID)
+		trace.lookup("skin", knownUUID, skin != nil, start)
+		if skin != nil {
+			return skin
+		}
+	} else {
+		trace.lookup("uuid", username, false, start)
+	}
+
+	// Mojang recently told us this player doesn't exist. Checked before the
+	// negative cache, as it doesn't cost us a round trip to the cache.
+	start = time.Now()
+	missing := missingFilter.has(username)
+	trace.lookup("missing", username, missing, start)
+	if missing {
+		log.Debugf("Missing filter hit: %s", username)
 		stats.HitCache()
-		return &mcSkin{Processed: nil, Skin: cache.pull(strings.ToLower(username))}
+		skin := fallbackFor(knownUUID)
+		skin.Cached = true
+		return skin
+	}
+
+	// We recently failed to get this player, don't bother Mojang again yet.
+	start = time.Now()
+	reason := cache.pullNegative(strings.ToLower(username))
+	trace.lookup("negative", strings.ToLower(username), reason != NegativeNone, start)
+	if reason != NegativeNone {
+		log.Debugf("Negative cache hit: %s (%s)", username, reason)
+		stats.HitCache()
+		skin := fallbackFor(knownUUID)
+		skin.Cached = true
+		return skin
+	}
+
+	var uuid string
+	stage(ctx, "uuid", func() {
+		uuid, reason = resolveUUID(ctx, username)
+	})
+	if reason == NegativeNone {
+		// Their username may have expired while their skin is still cached.
+		start = time.Now()
+		skin := pullCachedSkin(uuid)
+		trace.lookup("skin", uuid, skin != nil, start)
+		if skin != nil {
+			return skin
+		}
 	}
-	hasTimer.ObserveDuration()
 	stats.MissCache()
 
+	// Skins uploaded to us, or set in the store, take the place of the
+	// player's Mojang one.
+	if reason == NegativeNone && (config.Offline.Upload || config.Offline.Prefer) {
+		if skin, missing := fetchStoredSkin(uuid); missing == NegativeNone {
+			return skin
+		}
+	}
+
 	var skin minecraft.Skin
-	stats.APIRequested("GetUUID")
-	uuid, err := mcClient.NormalizePlayerForUUID(username)
+	var slim bool
+	if reason == NegativeNone {
+		skin, slim, reason = fetchSkinForUUID(ctx, username, uuid)
+	}
+
+	ttl := skinCacheTtl()
+	if reason == NegativeAPIError && usePeers && len(config.Peer.URL) > 0 {
+		var err error
+		skin, err = fetchSkinFromPeers(ctx, username)
+		if err != nil {
+			log.Infof("Failed peer lookup: %s (%s)", username, err.Error())
+			stats.Errored(ErrPeer)
+		} else {
+			reason = NegativeNone
+			slim = isSlimSkin(skin)
+			// Secondhand, so only kept until we'd ask Mojang again.
+			ttl = config.errorTtl()
+		}
+	}
+
+	if reason != NegativeNone {
+		// Remember the failure, for less time if the player may well exist.
+		ttl = config.failedTtl()
+		if reason == NegativeAPIError {
+			ttl = config.errorTtl()
+		} else {
+			missingFilter.add(username)
+		}
+		addTimer := prometheus.NewTimer(cacheDuration.WithLabelValues("add"))
+		cache.addNegative(strings.ToLower(username), reason, ttl)
+		addTimer.ObserveDuration()
+
+		stats.Errored(ErrFallbackSteve)
+		return fallbackFor(uuid)
+	}
+
+	// Without a UUID, which we won't have if a peer bailed us out, we've
+	// nothing to cache the skin under.
+	if uuid != "" {
+		addTimer := prometheus.NewTimer(cacheDuration.WithLabelValues("add"))
+		cache.add(uuid, skin, ttl)
+		addTimer.ObserveDuration()
+	}
+	return &mcSkin{Render: mcskin.Render{Skin: skin, Slim: slim}}
+}
+
+// Returns the skin cached for the UUID, or nil if there isn't one.
+func pullCachedSkin(uuid string) *mcSkin {
+	hasTimer := prometheus.NewTimer(cacheDuration.WithLabelValues("has"))
+	if !cache.has(uuid) {
+		hasTimer.ObserveDuration()
+		return nil
+	}
+	hasTimer.ObserveDuration()
+
+	pullTimer := prometheus.NewTimer(cacheDuration.WithLabelValues("pull"))
+	defer pullTimer.ObserveDuration()
+	stats.HitCache()
+	skin := cache.pull(uuid)
+	// Past its prime, but still good enough to serve while we fetch a fresh
+	// copy.
+	if isStale(uuid) {
+		refresher.refresh(uuid)
+	}
+	return &mcSkin{Render: mcskin.Render{Skin: skin, Slim: isSlimSkin(skin)}, Fallback: isFallbackSkin(skin), Cached: true}
+}
+
+// Returns the UUID for the player if we don't need to ask Mojang for it,
+// either because we were given one or we recently looked it up.
+func lookupUUID(player string) (string, bool) {
+	if uuid, ok := normalizeUUID(player); ok {
+		return uuid, true
+	}
+	return uuidCache.get(strings.ToLower(player))
+}
+
+// Returns the UUID for the player, asking Mojang if we don't know it. On
+// failure, returns why.
+func resolveUUID(ctx context.Context, player string) (string, NegativeReason) {
+	if uuid, known := lookupUUID(player); known {
+		return uuid, NegativeNone
+	}
+
+	start := time.Now()
+	result, err := coalesce("GetUUID", strings.ToLower(player), func() (interface{}, error) {
+		return upstream.do(func() (interface{}, error) {
+			stats.APIRequested("GetUUID")
+			return mcClient.NormalizePlayerForUUID(player)
+		})
+	})
+	// The UUID's looked up without the context, so isn't traced with the
+	// other requests upstream.
+	debugTraceFrom(ctx).call("GetUUID "+player, 0, err, start)
 	if err != nil {
 		switch errorMsg := err.Error(); errorMsg {
 
 		case "unable to GetAPIProfile: user not found":
-			log.Debugf("Failed UUID lookup: %s (%s)", username, errorMsg)
-			stats.Errored("UnknownUser")
+			log.Debugf("Failed UUID lookup: %s (%s)", player, errorMsg)
+			stats.Errored(ErrUserNotFound)
+			return "", NegativeNotFound
 
 		case "unable to GetAPIProfile: rate limited":
-			log.Noticef("Failed UUID lookup: %s (%s)", username, errorMsg)
-			stats.Errored("LookupUUIDRateLimit")
+			log.Noticef("Failed UUID lookup: %s (%s)", player, errorMsg)
+			stats.Errored(ErrUpstreamRateLimited)
+			return resolveUUIDFromMirrors(ctx, player)
+
+		case errUpstreamBlocked.Error(), errBudgetExhausted.Error(), errCircuitOpen.Error():
+			log.Debugf("Failed UUID lookup: %s (%s)", player, errorMsg)
+			return resolveUUIDFromMirrors(ctx, player)
 
 		default:
-			log.Infof("Failed UUID lookup: %s (%s)", username, errorMsg)
-			stats.Errored("LookupUUID")
+			log.Infof("Failed UUID lookup: %s (%s)", player, errorMsg)
+			stats.Errored(ErrLookupUUID)
+			return resolveUUIDFromMirrors(ctx, player)
 
 		}
+	}
 
-		skin, _ = minecraft.FetchSkinForSteve()
-		stats.Errored("FallbackSteve")
-	} else {
-		// We have a UUID, so let's get a skin!
-		sPTimer := prometheus.NewTimer(getDuration.WithLabelValues("SessionProfile"))
-		skin, err = mcClient.FetchSkinUUID(uuid)
-		sPTimer.ObserveDuration()
-		if err != nil {
-			log.Noticef("Failed Skin SessionProfile: %s (%s)", username, err.Error())
-			stats.Errored("SkinSessionProfile")
-			skin, _ = minecraft.FetchSkinForSteve()
-			stats.Errored("FallbackSteve")
+	uuid := result.(string)
+	uuidCache.add(strings.ToLower(player), uuid, config.uuidTtl())
+	return uuid, NegativeNone
+}
+
+// Looks the player up with the mirrors, for when Mojang can't.
+func resolveUUIDFromMirrors(ctx context.Context, player string) (string, NegativeReason) {
+	if len(mirrors) == 0 {
+		return "", NegativeAPIError
+	}
+
+	profile, err := lookupMirrors(ctx, player)
+	if err != nil {
+		log.Infof("Failed mirror UUID lookup: %s (%s)", player, err.Error())
+		stats.Errored(ErrLookupUUIDMirror)
+		return "", NegativeAPIError
+	}
+	uuidCache.add(strings.ToLower(player), profile.UUID, config.uuidTtl())
+	return profile.UUID, NegativeNone
+}
+
+// Returns the player's profile from the profile cache, or else Mojang or
+// the mirrors. On failure, returns why.
+func fetchProfileForUUID(ctx context.Context, player string, uuid string) (Profile, NegativeReason) {
+	start := time.Now()
+	profile, ok := profileCache.get(uuid)
+	debugTraceFrom(ctx).lookup("profile", uuid, ok, start)
+	if ok {
+		return profile, NegativeNone
+	}
+
+	result, err := coalesce("SessionProfile", uuid, func() (interface{}, error) {
+		return upstream.do(func() (interface{}, error) {
+			return fetchProfile(ctx, uuid)
+		})
+	})
+	if err != nil {
+		log.Noticef("Failed Skin SessionProfile: %s (%s)", player, err.Error())
+		stats.Errored(ErrSkinSessionProfile)
+		if strings.HasSuffix(err.Error(), "user not found") {
+			return Profile{}, NegativeNotFound
+		}
+		if len(mirrors) == 0 {
+			return Profile{}, NegativeAPIError
+		}
+
+		if profile, err = lookupMirrors(ctx, uuid); err != nil {
+			log.Infof("Failed mirror SessionProfile: %s (%s)", player, err.Error())
+			stats.Errored(ErrSkinMirror)
+			return Profile{}, NegativeAPIError
 		}
+	} else {
+		profile = result.(Profile)
 	}
+	profileCache.add(uuid, profile, config.profileTtl())
+	return profile, NegativeNone
+}
+
+// Fetches the player's profile, then the skin it points at, from Mojang,
+// returning the skin and whether it's for the slim model. On failure,
+// returns why.
+func fetchSkinForUUID(ctx context.Context, player string, uuid string) (minecraft.Skin, bool, NegativeReason) {
+	return fetchSkinForUUIDSince(ctx, player, uuid, minecraft.Skin{})
+}
 
-	addTimer := prometheus.NewTimer(cacheDuration.WithLabelValues("add"))
-	cache.add(strings.ToLower(username), skin)
-	addTimer.ObserveDuration()
-	return &mcSkin{Processed: nil, Skin: skin}
+// Fetches the player's skin as fetchSkinForUUID does, but if it's still the
+// previous skin, doesn't download it again.
+func fetchSkinForUUIDSince(ctx context.Context, player string, uuid string, previous minecraft.Skin) (minecraft.Skin, bool, NegativeReason) {
+	var profile Profile
+	var reason NegativeReason
+	stage(ctx, "profile", func() {
+		profile, reason = fetchProfileForUUID(ctx, player, uuid)
+	})
+	if reason != NegativeNone {
+		return minecraft.Skin{}, false, reason
+	}
+	if profile.SkinURL == "" {
+		// They've never set a skin, so have the default.
+		skin, slim := defaultSkin(uuid)
+		return skin, slim, NegativeNone
+	}
+
+	var result interface{}
+	var err error
+	parallel(ctx, func() {
+		warmCape(ctx, uuid, profile)
+	}, func() {
+		stage(ctx, "skin", func() {
+			result, err = coalesce("Texture", profile.SkinURL, func() (interface{}, error) {
+				return fetchSkinTextureSince(ctx, profile.SkinURL, previous)
+			})
+		})
+	})
+	if err != nil {
+		log.Noticef("Failed Skin Texture: %s (%s)", player, err.Error())
+		stats.Errored(ErrSkinTexture)
+		return minecraft.Skin{}, false, NegativeAPIError
+	}
+	return result.(minecraft.Skin), profile.Slim, NegativeNone
 }
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/minecraft"
+)
+
+func TestSkinURLPage(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	missingFilter = MakeMissingFilter(0, time.Minute)
+
+	skin, _ := minecraft.FetchSkinForSteve()
+	skin.Hash = "abc123"
+	skin.URL = "http://textures.minecraft.net/texture/abc123"
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+
+	w := httptest.NewRecorder()
+	router.Mux.ServeHTTP(w, httptest.NewRequest("GET", "/skinurl/d9135e082f2244c89cb10d21ed3ac8fd", nil))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != skin.URL {
+		t.Fatalf("Expected a redirect to the texture, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+
+	w = httptest.NewRecorder()
+	router.Mux.ServeHTTP(w, httptest.NewRequest("GET", "/skinurl/d9135e082f2244c89cb10d21ed3ac8fd?json=1", nil))
+	result := struct{ URL string }{}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || result.URL != skin.URL {
+		t.Fatalf("Expected the texture URL as JSON, got %q", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.Mux.ServeHTTP(w, httptest.NewRequest("GET", "/skinurl/char", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected Steve to have no URL, got %d", w.Code)
+	}
+}
+
+func TestHeadRequests(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	renderCache = MakeRenderCache(1<<20, false)
+	hotCache = MakeHotCache(nil, 0)
+	missingFilter = MakeMissingFilter(0, time.Minute)
+
+	skin, _ := minecraft.FetchSkinForSteve()
+	skin.Hash = "clone1018"
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+	serve := func(method string, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.Mux.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	// HEAD renders on a miss, so it has the render's length.
+	avatar := "/avatar/d9135e082f2244c89cb10d21ed3ac8fd/32.png"
+	w := serve("HEAD", avatar)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == "" || w.Header().Get("Content-Length") == "" || w.Body.Len() != 0 {
+		t.Fatalf("Expected the headers alone, got %d %v", w.Code, w.Header())
+	}
+	if renderCache.size() != 1 {
+		t.Fatal("Expected HEAD's render to be cached")
+	}
+
+	get := serve("GET", avatar)
+	if w.Header().Get("Content-Length") != get.Header().Get("Content-Length") || w.Header().Get("ETag") != get.Header().Get("ETag") {
+		t.Fatalf("Expected HEAD to match GET, got %v and %v", w.Header(), get.Header())
+	}
+
+	get = serve("GET", "/skin/d9135e082f2244c89cb10d21ed3ac8fd")
+	w = serve("HEAD", "/skin/d9135e082f2244c89cb10d21ed3ac8fd")
+	if w.Header().Get("Content-Length") == "" || w.Header().Get("Content-Length") != get.Header().Get("Content-Length") || w.Body.Len() != 0 {
+		t.Fatalf("Expected the skin's length without the skin, got %v", w.Header())
+	}
+}
+
+func TestInternalRoutes(t *testing.T) {
+	stats = MakeStatsCollector()
+	defer func() { config.Server.InternalAddress = "" }()
+
+	serves := func(router *Router, path string) bool {
+		w := httptest.NewRecorder()
+		router.Mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code == http.StatusOK
+	}
+
+	public := &Router{Mux: mux.NewRouter()}
+	public.Bind()
+	if !serves(public, "/metrics") || !serves(public, "/stats") {
+		t.Fatal("Expected metrics and status alongside the images by default")
+	}
+	if serves(public, "/debug/vars") {
+		t.Fatal("Expected /debug/vars never to be served alongside the images")
+	}
+
+	config.Server.InternalAddress = "127.0.0.1:0"
+	public = &Router{Mux: mux.NewRouter()}
+	public.Bind()
+	internal := &Router{Mux: mux.NewRouter()}
+	internal.BindInternal()
+	for _, path := range []string{"/metrics", "/debug/vars", "/stats", "/status/timeseries"} {
+		if serves(public, path) {
+			t.Fatalf("Expected %s not to be public with an internal address", path)
+		}
+		if !serves(internal, path) {
+			t.Fatalf("Expected %s on the internal address", path)
+		}
+	}
+}
+
+func TestExpvars(t *testing.T) {
+	stats = MakeStatsCollector()
+	stats.Requested("Avatar")
+	stats.HitCache()
+	defer func() { config.Server.InternalAddress = "" }()
+	config.Server.InternalAddress = "127.0.0.1:0"
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.BindInternal()
+	w := httptest.NewRecorder()
+	router.Mux.ServeHTTP(w, httptest.NewRequest("GET", "/debug/vars", nil))
+
+	vars := struct {
+		Imgd     statusInfo
+		Memstats map[string]interface{}
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars.Imgd.Requested["Avatar"] != 1 || vars.Imgd.CacheHits != 1 {
+		t.Fatalf("Expected the status counters under imgd, got %+v", vars.Imgd)
+	}
+	if vars.Memstats == nil {
+		t.Fatal("Expected Go's memstats too")
+	}
+}
+
+func TestMakeServer(t *testing.T) {
+	config.Server.ReadTimeout = 10
+	config.Server.WriteTimeout = 30
+	config.Server.MaxHeaderBytes = 16384
+	defer func() {
+		config.Server.ReadTimeout = 0
+		config.Server.WriteTimeout = 0
+		config.Server.MaxHeaderBytes = 0
+	}()
+
+	server := makeServer(http.NotFoundHandler())
+	if server.ReadTimeout != 10*time.Second || server.WriteTimeout != 30*time.Second || server.IdleTimeout != 0 {
+		t.Fatalf("Expected the configured timeouts, got %s, %s and %s", server.ReadTimeout, server.WriteTimeout, server.IdleTimeout)
+	}
+	if server.MaxHeaderBytes != 16384 {
+		t.Fatalf("Expected headers limited to 16384 bytes, got %d", server.MaxHeaderBytes)
+	}
+}
+
+func TestRangeRequests(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	renderCache = MakeRenderCache(1<<20, false)
+	hotCache = MakeHotCache(nil, 0)
+	missingFilter = MakeMissingFilter(0, time.Minute)
+	defer func(stale int) { config.Ttl.Stale = stale }(config.Ttl.Stale)
+	config.Ttl.Stale = 0
+
+	skin, _ := minecraft.FetchSkinForSteve()
+	skin.Hash = "clone1018"
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+	serve := func(method, path, body string, header http.Header) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		for name := range header {
+			r.Header.Set(name, header.Get(name))
+		}
+		router.Mux.ServeHTTP(w, r)
+		return w
+	}
+
+	avatar := "/avatar/d9135e082f2244c89cb10d21ed3ac8fd/32.png"
+	full := serve("GET", avatar, "", nil)
+	if full.Header().Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("Expected ranges to be advertised, got %v", full.Header())
+	}
+	w := serve("GET", avatar, "", http.Header{"Range": {"bytes=10-19"}})
+	if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), full.Body.Bytes()[10:20]) {
+		t.Fatalf("Expected bytes 10 to 19, got %d %v", w.Code, w.Header())
+	}
+	if expected := fmt.Sprintf("bytes 10-19/%d", full.Body.Len()); w.Header().Get("Content-Range") != expected {
+		t.Fatalf("Expected Content-Range %q, got %q", expected, w.Header().Get("Content-Range"))
+	}
+	w = serve("GET", avatar, "", http.Header{"Range": {"bytes=10-19"}, "If-Range": {`"stale"`}})
+	if w.Code != http.StatusOK || w.Body.Len() != full.Body.Len() {
+		t.Fatalf("Expected a stale If-Range to get the whole render, got %d", w.Code)
+	}
+	w = serve("GET", avatar, "", http.Header{"Range": {fmt.Sprintf("bytes=%d-", full.Body.Len())}})
+	if w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("Expected a range past the end to be refused, got %d", w.Code)
+	}
+
+	// A batch download can be resumed where it was cut off.
+	batch := `[{"user":"d9135e082f2244c89cb10d21ed3ac8fd","type":"helm","size":32}]`
+	zipped := serve("POST", "/api/render/batch", batch, nil)
+	w = serve("POST", "/api/render/batch", batch, http.Header{"Range": {"bytes=100-"}, "If-Range": {zipped.Header().Get("ETag")}})
+	if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), zipped.Body.Bytes()[100:]) {
+		t.Fatalf("Expected the rest of the same ZIP, got %d", w.Code)
+	}
+}
+
+func TestTrimQuery(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	renderCache = MakeRenderCache(1<<20, false)
+	hotCache = MakeHotCache(nil, 0)
+	missingFilter = MakeMissingFilter(0, time.Minute)
+
+	// A solid skin without legs, or their overlays.
+	skin, _ := minecraft.FetchSkinForSteve()
+	legless := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	draw.Draw(legless, legless.Bounds(), image.White, image.Point{}, draw.Src)
+	for _, legs := range []image.Rectangle{image.Rect(0, 16, 16, 64), image.Rect(16, 48, 32, 64)} {
+		draw.Draw(legless, legs, image.Transparent, image.Point{}, draw.Src)
+	}
+	skin.Image, skin.Hash = legless, "legless"
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+	for query, height := range map[string]int{"": 360, "?trim=1": 225} {
+		w := httptest.NewRecorder()
+		router.Mux.ServeHTTP(w, httptest.NewRequest("GET", "/body/d9135e082f2244c89cb10d21ed3ac8fd.png"+query, nil))
+		img, err := png.Decode(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds().Dx() != 180 || img.Bounds().Dy() != height {
+			t.Fatalf("Expected %q to be 180x%d, got %v", query, height, img.Bounds())
+		}
+	}
+}
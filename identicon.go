@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+
+	"github.com/minotar/imgd/pkg/mcskin"
+	"github.com/minotar/minecraft"
+)
+
+// Returns a skin whose face is a symmetric pixel pattern seeded from the
+// name, so players we can't resolve get a stable, recognisable avatar
+// instead of all looking like Steve.
+func identiconSkin(name string) minecraft.Skin {
+	sum := md5.Sum([]byte(strings.ToLower(name)))
+
+	fg := color.NRGBA{sum[0]/2 + 0x40, sum[1]/2 + 0x40, sum[2]/2 + 0x40, 0xFF}
+	bg := color.NRGBA{fg.R / 3, fg.G / 3, fg.B / 3, 0xFF}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	// Colour the head and body with the background so every render type
+	// has something sensible to show.
+	draw.Draw(img, image.Rect(0, 0, 32, 16), &image.Uniform{bg}, image.ZP, draw.Src)
+	draw.Draw(img, image.Rect(0, 16, 56, 32), &image.Uniform{fg}, image.ZP, draw.Src)
+	draw.Draw(img, image.Rect(16, 48, 48, 64), &image.Uniform{fg}, image.ZP, draw.Src)
+
+	// The left half of the face comes from the hash bits, and is mirrored
+	// onto the right half.
+	for y := 0; y < mcskin.HeadHeight; y++ {
+		bits := sum[4+y]
+		for x := 0; x < mcskin.HeadWidth/2; x++ {
+			if bits&(1<<uint(x)) != 0 {
+				img.SetNRGBA(mcskin.HeadX+x, mcskin.HeadY+y, fg)
+				img.SetNRGBA(mcskin.HeadX+mcskin.HeadWidth-1-x, mcskin.HeadY+y, fg)
+			}
+		}
+	}
+
+	skin := minecraft.Skin{}
+	skin.Image = img
+	skin.Hash = fmt.Sprintf("identicon-%x", sum)
+	skin.Source = "Identicon"
+	return skin
+}
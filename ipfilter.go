@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Routes served on the internal address when there is one, see
+// BindInternal.
+var internalPrefixes = []string{"/admin/", "/metrics", "/debug/vars", "/stats", "/status/"}
+
+func isInternalPath(path string) bool {
+	for _, prefix := range internalPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Lets in requests by the client's address, so an internal-only instance,
+// or one under attack, can turn traffic away without a proxy in front.
+// Deny entries take precedence over allow entries, and with no allow
+// entries every address not denied is let in.
+type IPFilter struct {
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+}
+
+// Returns nil if there's nothing to filter.
+func MakeIPFilter(allow, deny []string) (*IPFilter, error) {
+	filter := &IPFilter{}
+	var err error
+	if filter.Allow, err = parseCIDRs(allow); err != nil {
+		return nil, err
+	}
+	if filter.Deny, err = parseCIDRs(deny); err != nil {
+		return nil, err
+	}
+	if len(filter.Allow) == 0 && len(filter.Deny) == 0 {
+		return nil, nil
+	}
+	return filter, nil
+}
+
+// Whether the address is let in. Clients whose address we can't make out
+// are only let in if there's no allow list.
+func (f *IPFilter) allows(ip net.IP) bool {
+	if f == nil {
+		return true
+	}
+	if ip == nil {
+		return len(f.Allow) == 0
+	}
+	if containsIP(f.Deny, ip) {
+		return false
+	}
+	return len(f.Allow) == 0 || containsIP(f.Allow, ip)
+}
+
+// Middleware refusing clients the filter doesn't let in, and those
+// adminFilter doesn't let in to the internal routes too, before any other
+// handler sees them.
+func ipFilterHandler(filter, adminFilter *IPFilter, router http.Handler) http.Handler {
+	if filter == nil && adminFilter == nil {
+		return router
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := net.ParseIP(clientIP(r))
+		if !filter.allows(ip) || (isInternalPath(r.URL.Path) && !adminFilter.allows(ip)) {
+			log.Debugf("Filtered %s %s from %s", r.Method, r.RequestURI, clientIP(r))
+			writeError(w, r, http.StatusForbidden, ErrForbidden, "forbidden")
+			stats.Errored(ErrIPFiltered)
+			return
+		}
+		router.ServeHTTP(w, r)
+	})
+}
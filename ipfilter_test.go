@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPFilter(t *testing.T) {
+	filter, err := MakeIPFilter([]string{"10.0.0.0/8", "2001:db8::/32"}, []string{"10.6.6.6"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, allowed := range map[string]bool{"10.1.2.3": true, "10.6.6.6": false, "192.0.2.1": false, "2001:db8::1": true} {
+		if filter.allows(net.ParseIP(ip)) != allowed {
+			t.Errorf("Expected %s allowed to be %v", ip, allowed)
+		}
+	}
+	if filter.allows(nil) {
+		t.Error("Expected an address we can't make out to be refused with an allow list")
+	}
+
+	if filter, err := MakeIPFilter(nil, nil); filter != nil || err != nil {
+		t.Fatalf("Expected nothing to filter, got %+v (%v)", filter, err)
+	}
+	if _, err := MakeIPFilter([]string{"10.0.0.0/33"}, nil); err == nil {
+		t.Fatal("Expected an invalid CIDR to be refused")
+	}
+}
+
+func TestIPFilterHandler(t *testing.T) {
+	stats = MakeStatsCollector()
+	public, _ := MakeIPFilter(nil, []string{"192.0.2.66"})
+	admin, _ := MakeIPFilter([]string{"10.0.0.0/8"}, nil)
+	handler := healthHandler(ipFilterHandler(public, admin, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+
+	serve := func(path, addr string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = addr + ":1234"
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	for _, expected := range []struct {
+		path, addr string
+		code       int
+	}{
+		{"/avatar/clone1018", "192.0.2.1", http.StatusNoContent},
+		{"/avatar/clone1018", "192.0.2.66", http.StatusForbidden},
+		{"/admin/config", "192.0.2.1", http.StatusForbidden},
+		{"/admin/config", "10.0.0.1", http.StatusNoContent},
+		{"/metrics", "192.0.2.1", http.StatusForbidden},
+		{"/healthz", "192.0.2.66", http.StatusOK},
+	} {
+		if code := serve(expected.path, expected.addr); code != expected.code {
+			t.Errorf("Expected %s from %s to get %d, got %d", expected.path, expected.addr, expected.code, code)
+		}
+	}
+	if stats.snapshot().Errored[string(ErrIPFiltered)] != 3 {
+		t.Fatalf("Expected the refusals to be counted, got %v", stats.snapshot().Errored)
+	}
+}
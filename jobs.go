@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// What's become of a render job.
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+	// Done, but its result was dropped early to make room for others'.
+	JobExpired = "expired"
+)
+
+const (
+	// Longest a job may render for before it's failed.
+	renderJobTimeout = 10 * time.Minute
+	// How long a worker waits before retrying a job the render pool was
+	// too busy for.
+	renderJobRetryDelay = time.Second
+	// Seconds we ask clients to wait when the job queue is full.
+	renderJobRetryAfter = 30
+	// How often the memory store drops expired jobs.
+	jobSweepInterval = time.Minute
+)
+
+// Returned for a job there was no room in the queue for.
+var errJobQueueFull = errors.New("job queue full")
+
+// A batch of renders queued with POST /api/render/async, and what's become
+// of it, as its status route tells the client.
+type RenderJob struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// Set if it failed.
+	Error ErrorCode `json:"error,omitempty"`
+	// How many renders it asked for.
+	Renders    int        `json:"renders"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Where the ZIP of renders is served, once it's done.
+	Result string `json:"result,omitempty"`
+
+	renders []batchRender
+}
+
+// Where render jobs wait for a worker, and what's become of them is kept
+// until it expires.
+type JobStore interface {
+	setup() error
+	// Queues the job, or returns errJobQueueFull.
+	enqueue(job *RenderJob) error
+	// Waits for the next job to render. May return nil, having waited a
+	// while without one.
+	next() (*RenderJob, error)
+	// Records the job's status, and its result once it's done.
+	save(job *RenderJob, result []byte) error
+	// Returns the job, or nil if there's no such job or it's expired.
+	get(id string) (*RenderJob, error)
+	result(id string) ([]byte, error)
+}
+
+func MakeJobStore(storeType string, queue int, ttl time.Duration, maxBytes int64) JobStore {
+	if storeType == "redis" {
+		return &JobStoreRedis{Queue: queue, Ttl: ttl}
+	}
+	return &JobStoreMemory{Queue: queue, Ttl: ttl, MaxBytes: maxBytes}
+}
+
+// Returns a random ID for a job, which can't be guessed to see others'
+// renders.
+func newJobID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func jobURL(id string) string {
+	return "/api/render/jobs/" + id
+}
+
+// Takes jobs from the store and renders them, until we exit.
+func (router *Router) runJobs(store JobStore) {
+	for {
+		job, err := store.next()
+		if err != nil {
+			log.Errorf("Unable to take a render job (%v)", err)
+			stats.Errored(ErrRenderJobs)
+			time.Sleep(renderJobRetryDelay)
+			continue
+		}
+		if job != nil {
+			router.runJob(store, job)
+		}
+	}
+}
+
+func (router *Router) runJob(store JobStore, job *RenderJob) {
+	job.Status = JobRunning
+	if err := store.save(job, nil); err != nil {
+		log.Errorf("Unable to save render job %s (%v)", job.ID, err)
+		stats.Errored(ErrRenderJobs)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), renderJobTimeout)
+	defer cancel()
+	start := time.Now()
+	data, err := router.renderJob(ctx, job)
+	finished := time.Now().UTC()
+	job.FinishedAt = &finished
+	if err == context.DeadlineExceeded {
+		log.Warningf("Render job %s timed out after %s", job.ID, renderJobTimeout)
+		job.Status, job.Error = JobFailed, ErrUpstreamTimeout
+	} else if err != nil {
+		job.Status, job.Error = JobFailed, ErrRenderFailed
+	} else {
+		log.Infof("Rendered job %s of %d renders in %s", job.ID, job.Renders, time.Since(start))
+		job.Status, job.Result = JobDone, jobURL(job.ID)+"/result"
+	}
+	if err := store.save(job, data); err != nil {
+		log.Errorf("Unable to save render job %s (%v)", job.ID, err)
+		stats.Errored(ErrRenderJobs)
+	}
+}
+
+// Renders the job's batch as a ZIP, waiting for room on the render pool
+// rather than failing as a request would.
+func (router *Router) renderJob(ctx context.Context, job *RenderJob) ([]byte, error) {
+	users := make([]string, len(job.renders))
+	for i, render := range job.renders {
+		users[i] = render.User
+	}
+	skins := fetchSkinsConcurrently(users, func(user string) *mcSkin {
+		return fetchSkinVia(ctx, user, true)
+	})
+
+	for {
+		data, err := router.renderBatch(ctx, job.renders, skins)
+		if err != errRenderQueueFull {
+			return data, err
+		}
+		// Those rendered before the pool filled up are in the render
+		// cache, so starting over costs little.
+		select {
+		case <-time.After(renderJobRetryDelay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// RenderAsyncPage queues a list of renders, as /api/render/batch takes but
+// many more of them, and answers at once with the job to poll for the ZIP,
+// so large batches don't hold a connection open while they render.
+func (router *Router) RenderAsyncPage(w http.ResponseWriter, r *http.Request) {
+	if jobStore == nil {
+		writeError(w, r, http.StatusNotImplemented, ErrNotEnabled, "async renders are not enabled")
+		return
+	}
+	renders, err := router.parseBatch(r, config.Jobs.MaxRenders)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrBadRequest, "%v", err)
+		return
+	}
+	stats.Requested("RenderAsync")
+	if !chargeRateLimit(w, r, len(renders)) {
+		return
+	}
+
+	job := &RenderJob{ID: newJobID(), Status: JobQueued, Renders: len(renders), CreatedAt: time.Now().UTC(), renders: renders}
+	if err := jobStore.enqueue(job); err == errJobQueueFull {
+		w.Header().Set("Retry-After", strconv.Itoa(renderJobRetryAfter))
+		writeError(w, r, http.StatusServiceUnavailable, ErrBusy, "too many render jobs queued, try again later")
+		return
+	} else if err != nil {
+		log.Errorf("Unable to queue render job (%v)", err)
+		stats.Errored(ErrRenderJobs)
+		writeInternalError(w, r, ErrInternal)
+		return
+	}
+
+	w.Header().Set("Location", jobURL(job.ID))
+	writeJob(w, http.StatusAccepted, job)
+}
+
+// Looks up the job the request names, answering for it if there's no such
+// job.
+func lookupJob(w http.ResponseWriter, r *http.Request) *RenderJob {
+	if jobStore == nil {
+		writeError(w, r, http.StatusNotImplemented, ErrNotEnabled, "async renders are not enabled")
+		return nil
+	}
+	job, err := jobStore.get(mux.Vars(r)["id"])
+	if err != nil {
+		log.Errorf("Unable to look up render job (%v)", err)
+		stats.Errored(ErrRenderJobs)
+		writeInternalError(w, r, ErrInternal)
+		return nil
+	}
+	if job == nil {
+		writeError(w, r, http.StatusNotFound, ErrJobNotFound, "no such job, or it's expired")
+		return nil
+	}
+	return job
+}
+
+// RenderJobPage tells the client what's become of their render job.
+func (router *Router) RenderJobPage(w http.ResponseWriter, r *http.Request) {
+	if job := lookupJob(w, r); job != nil {
+		writeJob(w, http.StatusOK, job)
+	}
+}
+
+// RenderJobResultPage serves the ZIP of a finished job's renders.
+func (router *Router) RenderJobResultPage(w http.ResponseWriter, r *http.Request) {
+	job := lookupJob(w, r)
+	if job == nil {
+		return
+	}
+	if job.Status == JobExpired {
+		writeError(w, r, http.StatusNotFound, ErrJobNotFound, "job's result has expired")
+		return
+	}
+	if job.Status != JobDone {
+		writeError(w, r, http.StatusConflict, ErrJobPending, "job is %s", job.Status)
+		return
+	}
+	data, err := jobStore.result(job.ID)
+	if err != nil {
+		log.Errorf("Unable to fetch render job %s's result (%v)", job.ID, err)
+		stats.Errored(ErrRenderJobs)
+		writeInternalError(w, r, ErrInternal)
+		return
+	}
+	if data == nil {
+		writeError(w, r, http.StatusNotFound, ErrJobNotFound, "no such job, or it's expired")
+		return
+	}
+	writeBatch(w, r, data)
+}
+
+func writeJob(w http.ResponseWriter, status int, job *RenderJob) {
+	body, _ := json.Marshal(job)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+type memoryJob struct {
+	job    RenderJob
+	result []byte
+	// Unset until it's finished.
+	expires time.Time
+}
+
+// Queues jobs, and keeps what's become of them, in this process.
+type JobStoreMemory struct {
+	Queue int
+	Ttl   time.Duration
+	// Bytes of results kept, beyond which the soonest to expire are
+	// dropped early. 0 for no limit.
+	MaxBytes int64
+
+	mu    sync.Mutex
+	jobs  map[string]*memoryJob
+	queue chan *RenderJob
+	// Bytes of results held.
+	bytes int64
+}
+
+func (s *JobStoreMemory) setup() error {
+	s.jobs = map[string]*memoryJob{}
+	s.queue = make(chan *RenderJob, s.Queue)
+	go s.sweep()
+	log.Noticef("Queueing render jobs in memory (queue: %d, results: %d bytes)", s.Queue, s.MaxBytes)
+	return nil
+}
+
+// Drops expired jobs every so often, so their results don't wait on the
+// next save to be freed.
+func (s *JobStoreMemory) sweep() {
+	ticker := time.NewTicker(jobSweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.mu.Lock()
+		s.expire(time.Now())
+		s.mu.Unlock()
+	}
+}
+
+// Drops expired jobs. Must be called with the lock held.
+func (s *JobStoreMemory) expire(now time.Time) {
+	for id, entry := range s.jobs {
+		if !entry.expires.IsZero() && now.After(entry.expires) {
+			s.remove(id)
+		}
+	}
+}
+
+// Must be called with the lock held.
+func (s *JobStoreMemory) remove(id string) {
+	if entry, exists := s.jobs[id]; exists {
+		s.bytes -= int64(len(entry.result))
+		delete(s.jobs, id)
+	}
+}
+
+// Drops the results of the finished jobs soonest to expire until the rest
+// fit. The jobs are kept, marked expired, so their status can still be
+// asked for. Must be called with the lock held.
+func (s *JobStoreMemory) shrink() {
+	for s.MaxBytes > 0 && s.bytes > s.MaxBytes {
+		oldest := ""
+		for id, entry := range s.jobs {
+			if entry.result != nil && (oldest == "" || entry.expires.Before(s.jobs[oldest].expires)) {
+				oldest = id
+			}
+		}
+		if oldest == "" {
+			return
+		}
+		log.Warningf("Dropping render job %s's result early, as results are over %d bytes", oldest, s.MaxBytes)
+		entry := s.jobs[oldest]
+		s.bytes -= int64(len(entry.result))
+		entry.result = nil
+		entry.job.Status, entry.job.Result = JobExpired, ""
+	}
+}
+
+func (s *JobStoreMemory) enqueue(job *RenderJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case s.queue <- job:
+	default:
+		return errJobQueueFull
+	}
+	s.jobs[job.ID] = &memoryJob{job: *job}
+	return nil
+}
+
+func (s *JobStoreMemory) next() (*RenderJob, error) {
+	job := <-s.queue
+	return job, nil
+}
+
+func (s *JobStoreMemory) save(job *RenderJob, result []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.expire(now)
+	s.remove(job.ID)
+	entry := &memoryJob{job: *job, result: result}
+	if job.Status == JobDone || job.Status == JobFailed {
+		entry.expires = now.Add(s.Ttl)
+	}
+	s.jobs[job.ID] = entry
+	s.bytes += int64(len(result))
+	s.shrink()
+	return nil
+}
+
+func (s *JobStoreMemory) lookup(id string) *memoryJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, exists := s.jobs[id]
+	if !exists || (!entry.expires.IsZero() && time.Now().After(entry.expires)) {
+		return nil
+	}
+	return entry
+}
+
+func (s *JobStoreMemory) get(id string) (*RenderJob, error) {
+	entry := s.lookup(id)
+	if entry == nil {
+		return nil, nil
+	}
+	job := entry.job
+	return &job, nil
+}
+
+func (s *JobStoreMemory) result(id string) ([]byte, error) {
+	entry := s.lookup(id)
+	if entry == nil {
+		return nil, nil
+	}
+	return entry.result, nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/fzzy/radix/extra/pool"
+	"github.com/fzzy/radix/redis"
+)
+
+// Seconds a worker waits on the queue for a job before asking again.
+const jobPollTimeout = 5
+
+// Queues a job unless the queue's full, in one step so instances racing
+// for the last place can't both take it. Returns 0 if it's full.
+const enqueueJobScript = `
+if redis.call("LLEN", KEYS[1]) >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call("SETEX", KEYS[2], ARGV[2], ARGV[3])
+redis.call("LPUSH", KEYS[1], ARGV[4])
+return 1
+`
+
+// A job as it waits in the Redis queue, with the renders its status leaves
+// out.
+type redisQueuedJob struct {
+	Job     RenderJob
+	Renders []batchRender
+}
+
+// Queues jobs in a Redis list, which any instance's workers may take them
+// from, and keeps what's become of them alongside the cache, under the
+// [redis] prefix.
+type JobStoreRedis struct {
+	Queue int
+	Ttl   time.Duration
+
+	Pool *pool.Pool
+}
+
+func (s *JobStoreRedis) setup() error {
+	pool, err := pool.NewCustomPool("tcp", config.Redis.Address, config.Redis.PoolSize, dialFunc)
+	if err != nil {
+		return err
+	}
+	s.Pool = pool
+	log.Noticef("Queueing render jobs in Redis (address: %s, db: %v, queue: %d)", config.Redis.Address, config.Redis.DB, s.Queue)
+	return nil
+}
+
+func (s *JobStoreRedis) queueKey() string {
+	return config.Redis.Prefix + "jobs"
+}
+
+func (s *JobStoreRedis) jobKey(id string) string {
+	return config.Redis.Prefix + "job:" + id
+}
+
+func (s *JobStoreRedis) resultKey(id string) string {
+	return config.Redis.Prefix + "job:" + id + ":result"
+}
+
+// Seconds to keep the job's status for. Jobs yet to finish are kept until
+// they must have, so one lost with its instance doesn't linger.
+func (s *JobStoreRedis) ttl(job *RenderJob) string {
+	ttl := s.Ttl
+	if job.Status != JobDone && job.Status != JobFailed {
+		ttl += renderJobTimeout
+	}
+	return strconv.Itoa(int(ttl.Seconds()))
+}
+
+func (s *JobStoreRedis) enqueue(job *RenderJob) error {
+	client, err := s.Pool.Get()
+	if err != nil {
+		return err
+	}
+	defer s.Pool.CarefullyPut(client, &err)
+
+	status, _ := json.Marshal(job)
+	payload, _ := json.Marshal(redisQueuedJob{Job: *job, Renders: job.renders})
+	var queued int
+	if queued, err = client.Cmd("EVAL", enqueueJobScript, 2, s.queueKey(), s.jobKey(job.ID), s.Queue, s.ttl(job), status, payload).Int(); err != nil {
+		return err
+	}
+	if queued == 0 {
+		return errJobQueueFull
+	}
+	return nil
+}
+
+func (s *JobStoreRedis) next() (*RenderJob, error) {
+	client, err := s.Pool.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Pool.CarefullyPut(client, &err)
+
+	resp := client.Cmd("BRPOP", s.queueKey(), jobPollTimeout)
+	if err = resp.Err; err != nil {
+		return nil, err
+	}
+	if resp.Type == redis.NilReply {
+		return nil, nil
+	}
+	if len(resp.Elems) != 2 {
+		err = errors.New("unexpected BRPOP reply")
+		return nil, err
+	}
+	var payload []byte
+	if payload, err = resp.Elems[1].Bytes(); err != nil {
+		return nil, err
+	}
+
+	queued := redisQueuedJob{}
+	if err := json.Unmarshal(payload, &queued); err != nil {
+		return nil, fmt.Errorf("unable to decode queued job (%v)", err)
+	}
+	job := queued.Job
+	job.renders = queued.Renders
+	// Which render each is of doesn't survive JSON, but it was checked
+	// when it was queued.
+	for i := range job.renders {
+		resource, ok := batchResource(job.renders[i].Type)
+		if !ok {
+			return nil, fmt.Errorf("job %s asks for unknown render type %q", job.ID, job.renders[i].Type)
+		}
+		job.renders[i].resource = resource
+	}
+	return &job, nil
+}
+
+func (s *JobStoreRedis) save(job *RenderJob, result []byte) error {
+	client, err := s.Pool.Get()
+	if err != nil {
+		return err
+	}
+	defer s.Pool.CarefullyPut(client, &err)
+
+	// The result's saved first, so the status never says it's done before
+	// the result's there to fetch.
+	if result != nil {
+		if err = client.Cmd("SETEX", s.resultKey(job.ID), s.ttl(job), result).Err; err != nil {
+			return err
+		}
+	}
+	status, _ := json.Marshal(job)
+	err = client.Cmd("SETEX", s.jobKey(job.ID), s.ttl(job), status).Err
+	return err
+}
+
+// Returns the value under the key, or nil if there isn't one.
+func (s *JobStoreRedis) fetch(key string) ([]byte, error) {
+	client, err := s.Pool.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Pool.CarefullyPut(client, &err)
+
+	resp := client.Cmd("GET", key)
+	if err = resp.Err; err != nil {
+		return nil, err
+	}
+	if resp.Type == redis.NilReply {
+		return nil, nil
+	}
+	var value []byte
+	value, err = resp.Bytes()
+	return value, err
+}
+
+func (s *JobStoreRedis) get(id string) (*RenderJob, error) {
+	status, err := s.fetch(s.jobKey(id))
+	if err != nil || status == nil {
+		return nil, err
+	}
+	job := &RenderJob{}
+	if err := json.Unmarshal(status, job); err != nil {
+		return nil, fmt.Errorf("unable to decode job %s (%v)", id, err)
+	}
+	return job, nil
+}
+
+func (s *JobStoreRedis) result(id string) ([]byte, error) {
+	return s.fetch(s.resultKey(id))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/minecraft"
+)
+
+func TestRenderJobs(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	renderCache = MakeRenderCache(1<<20, false)
+	hotCache = MakeHotCache(nil, 0)
+	missingFilter = MakeMissingFilter(0, time.Minute)
+	saved := config.Jobs
+	defer func() { config.Jobs, jobStore = saved, nil }()
+	config.Jobs.MaxRenders = 10
+	jobStore = MakeJobStore("memory", 1, time.Minute, 0)
+	jobStore.setup()
+
+	skin, _ := minecraft.FetchSkinForSteve()
+	skin.Hash = "clone1018"
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.BindAPI()
+	serve := func(method string, path string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.Mux.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+	status := func(path string) RenderJob {
+		w := serve("GET", path, "")
+		job := RenderJob{}
+		if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("Expected the job's status, got %d %q", w.Code, w.Body.String())
+		}
+		return job
+	}
+
+	batch := `[{"user":"d9135e082f2244c89cb10d21ed3ac8fd","type":"helm","size":32}]`
+	w := serve("POST", "/api/render/async", batch)
+	location := w.Header().Get("Location")
+	if w.Code != http.StatusAccepted || !strings.HasPrefix(location, "/api/render/jobs/") {
+		t.Fatalf("Expected the job to be accepted, got %d %v", w.Code, w.Header())
+	}
+	if w := serve("POST", "/api/render/async", batch); w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("Expected a full queue to be refused, got %d", w.Code)
+	}
+	if w := serve("POST", "/api/render/async", `[`+strings.Repeat(`{"user":"clone1018","type":"helm"},`, 10)+`{"user":"clone1018","type":"helm"}]`); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected too big a job to be refused, got %d", w.Code)
+	}
+	if job := status(location); job.Status != JobQueued || job.Renders != 1 {
+		t.Fatalf("Expected the job to be queued, got %+v", job)
+	}
+	if w := serve("GET", location+"/result", ""); w.Code != http.StatusConflict || w.Header().Get(ErrorHeader) != string(ErrJobPending) {
+		t.Fatalf("Expected no result before it's done, got %d", w.Code)
+	}
+
+	job, _ := jobStore.next()
+	router.runJob(jobStore, job)
+	done := status(location)
+	if done.Status != JobDone || done.FinishedAt == nil || done.Result != location+"/result" {
+		t.Fatalf("Expected the job to be done, got %+v", done)
+	}
+	w = serve("GET", done.Result, "")
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("Expected a ZIP, got %d %v", w.Code, err)
+	}
+	if len(archive.File) != 1 || archive.File[0].Name != "d9135e082f2244c89cb10d21ed3ac8fd-helm-32.png" {
+		t.Fatalf("Expected the render, got %d files", len(archive.File))
+	}
+
+	// Once its result is dropped to make room, the job still answers.
+	store := jobStore.(*JobStoreMemory)
+	store.MaxBytes = 1
+	store.save(&RenderJob{ID: newJobID(), Status: JobDone}, []byte("zip"))
+	if job := status(location); job.Status != JobExpired || job.Result != "" {
+		t.Fatalf("Expected the job to have expired, got %+v", job)
+	}
+	if w := serve("GET", location+"/result", ""); w.Code != http.StatusNotFound || w.Header().Get(ErrorHeader) != string(ErrJobNotFound) {
+		t.Fatalf("Expected the dropped result to 404, got %d", w.Code)
+	}
+
+	if w := serve("GET", "/api/render/jobs/"+newJobID(), ""); w.Code != http.StatusNotFound || w.Header().Get(ErrorHeader) != string(ErrJobNotFound) {
+		t.Fatalf("Expected an unknown job to 404, got %d", w.Code)
+	}
+}
+
+func TestJobStoreMemoryExpires(t *testing.T) {
+	store := MakeJobStore("memory", 1, -time.Second, 0)
+	store.setup()
+	job := &RenderJob{ID: newJobID(), Status: JobQueued}
+	store.enqueue(job)
+	if found, _ := store.get(job.ID); found == nil {
+		t.Fatal("Expected a queued job never to expire")
+	}
+	job.Status = JobDone
+	store.save(job, []byte("zip"))
+	if found, _ := store.get(job.ID); found != nil {
+		t.Fatal("Expected a finished job to expire")
+	}
+}
+
+func TestJobStoreMemoryBoundsResults(t *testing.T) {
+	store := MakeJobStore("memory", 2, time.Minute, 4)
+	store.setup()
+	first := &RenderJob{ID: newJobID(), Status: JobDone}
+	store.save(first, []byte("zip"))
+	second := &RenderJob{ID: newJobID(), Status: JobDone}
+	store.save(second, []byte("zip"))
+
+	if data, _ := store.result(first.ID); data != nil {
+		t.Fatal("Expected the older result to be dropped to make room")
+	}
+	if found, _ := store.get(first.ID); found == nil || found.Status != JobExpired || found.Result != "" {
+		t.Fatalf("Expected the older job to be kept, marked expired, got %+v", found)
+	}
+	if data, _ := store.result(second.ID); string(data) != "zip" {
+		t.Fatalf("Expected the newer result to be kept, got %q", data)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/netutil"
+)
+
+// Another address to serve on as well as the server address, eg. "[::]:8000"
+// for IPv6 alongside IPv4, or a second port for HTTPS.
+type Listen struct {
+	Address string
+	// Certificate and key to serve HTTPS with, blank for plain HTTP. The
+	// [tls] section only applies to the server address.
+	Cert string
+	Key  string
+}
+
+// Returns the network to listen on the address with. With more than one
+// address, an IP only takes connections of its own family, so "0.0.0.0"
+// and "[::]" can both be listened on with the same port.
+func listenNetwork(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	ip := net.ParseIP(host)
+	if len(config.Listen) == 0 || err != nil || ip == nil {
+		return "tcp"
+	} else if ip.To4() != nil {
+		return "tcp4"
+	}
+	return "tcp6"
+}
+
+// Serves the handler on each of the [listen] addresses.
+func startListeners(handler http.Handler) {
+	names := make([]string, 0, len(config.Listen))
+	for name := range config.Listen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		listen := config.Listen[name]
+		listener, err := net.Listen(listenNetwork(listen.Address), listen.Address)
+		if err != nil {
+			log.Criticalf("Listen: \"%s\"", err.Error())
+			os.Exit(1)
+		}
+		if config.Server.MaxConns > 0 {
+			listener = netutil.LimitListener(listener, config.Server.MaxConns)
+		}
+		log.Noticef("Also serving on %s (%s)", listener.Addr(), name)
+
+		server := makeServer(handler)
+		server.Addr = listen.Address
+		listenServers = append(listenServers, server)
+		go func() {
+			if err := serveOn(server, listener, listen.Cert, listen.Key); err != nil && err != http.ErrServerClosed {
+				log.Criticalf("Serve: \"%s\"", err.Error())
+				os.Exit(1)
+			}
+		}()
+	}
+}
+
+// Serves on the listener, over HTTPS if there's a certificate.
+func serveOn(server *http.Server, listener net.Listener, cert string, key string) error {
+	if cert != "" {
+		return serveTLS(server, listener, cert, key)
+	}
+	if config.Server.H2C {
+		// Browsers only speak HTTP/2 over TLS, but a proxy in front of
+		// us may speak it to us in the clear.
+		if err := serveH2C(server); err != nil {
+			return err
+		}
+	}
+	return server.Serve(listener)
+}
+
+// Has the server take h2c as well as HTTP/1. Configuring HTTP/2 on the
+// server gives it the server's idle timeout, as well as the read and write
+// timeouts it takes from the server anyway, and has Shutdown tell h2c
+// clients to go away. The server hands h2c connections over rather than
+// tracking them, though, so h2cServing counts them for drainH2C.
+func serveH2C(server *http.Server) error {
+	h2 := &http2.Server{}
+	if err := http2.ConfigureServer(server, h2); err != nil {
+		return err
+	}
+	handler := h2c.NewHandler(server.Handler, h2)
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h2cServing.Add(1)
+		defer h2cServing.Add(-1)
+		handler.ServeHTTP(w, r)
+	})
+	return nil
+}
+
+// Waits for h2c connections to finish after Shutdown, or the context to end.
+func drainH2C(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for h2cServing.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Returns what's wrong with a [listen] section, if anything.
+func checkListen(listen *Listen) error {
+	if _, _, err := net.SplitHostPort(listen.Address); err != nil {
+		return fmt.Errorf("invalid address %q", listen.Address)
+	}
+	if (listen.Cert == "") != (listen.Key == "") {
+		return fmt.Errorf("cert and key must be given together")
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+func TestListenNetwork(t *testing.T) {
+	defer func(listen map[string]*Listen) { config.Listen = listen }(config.Listen)
+
+	config.Listen = nil
+	if network := listenNetwork("[::]:8000"); network != "tcp" {
+		t.Errorf("Expected tcp with one address, got %s", network)
+	}
+
+	config.Listen = map[string]*Listen{"ipv6": {Address: "[::]:8000"}}
+	for address, expected := range map[string]string{
+		"[::]:8000":      "tcp6",
+		"0.0.0.0:8000":   "tcp4",
+		"127.0.0.1:8000": "tcp4",
+		":8000":          "tcp",
+		"localhost:8000": "tcp",
+	} {
+		if network := listenNetwork(address); network != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, address, network)
+		}
+	}
+}
+
+func TestCheckListen(t *testing.T) {
+	if err := checkListen(&Listen{Address: "[::]:8000"}); err != nil {
+		t.Errorf("Expected a plain address to be fine, got %v", err)
+	}
+	if err := checkListen(&Listen{}); err == nil {
+		t.Error("Expected a missing address to be an error")
+	}
+	if err := checkListen(&Listen{Address: ":8443", Cert: "cert.pem"}); err == nil {
+		t.Error("Expected a cert without a key to be an error")
+	}
+}
+
+// Records when the client closes its connection.
+type closeNotifyingConn struct {
+	net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *closeNotifyingConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+// Starts an h2c server with the handler, returning its address and a client
+// which speaks HTTP/2 to it in the clear, telling of each connection closed.
+func startH2C(t *testing.T, handler http.Handler) (*http.Server, string, *http.Client, chan struct{}) {
+	saved := config.Server
+	t.Cleanup(func() { config.Server = saved })
+	config.Server.H2C, config.Server.IdleTimeout = true, 1
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := makeServer(handler)
+	go serveOn(server, listener, "", "")
+	t.Cleanup(func() { server.Close() })
+
+	closed := make(chan struct{})
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var dialer net.Dialer
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return &closeNotifyingConn{Conn: conn, closed: closed}, nil
+		},
+	}}
+	return server, "http://" + listener.Addr().String() + "/", client, closed
+}
+
+func TestH2C(t *testing.T) {
+	_, url, client, closed := startH2C(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "HTTP/2.0" {
+		t.Fatalf("Expected to be served over HTTP/2, got %s", body)
+	}
+
+	// The server's idle timeout applies to h2c connections too.
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the idle connection to be closed")
+	}
+}
+
+func TestH2CDrain(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	server, url, client, _ := startH2C(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	}))
+
+	result := make(chan error, 1)
+	go func() {
+		resp, err := client.Get(url)
+		if err == nil {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != "done" {
+				err = fmt.Errorf("got %q", body)
+			}
+		}
+		result <- err
+	}()
+	<-started
+
+	server.Shutdown(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := drainH2C(ctx); err == nil {
+		t.Fatal("Expected the h2c request to still be draining")
+	}
+
+	close(release)
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := drainH2C(ctx); err != nil {
+		t.Fatal("Expected the h2c connection to be drained")
+	}
+	if err := <-result; err != nil {
+		t.Fatalf("Expected the in-flight request to finish, got %v", err)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// The formats logs may be written in.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// slog only has four levels, so we add the two more we've always logged at.
+const (
+	LevelNotice   = slog.Level(2)
+	LevelCritical = slog.Level(12)
+)
+
+var logLevelNames = map[slog.Level]string{
+	slog.LevelDebug: "DEBUG",
+	slog.LevelInfo:  "INFO",
+	LevelNotice:     "NOTICE",
+	slog.LevelWarn:  "WARNING",
+	slog.LevelError: "ERROR",
+	LevelCritical:   "CRITICAL",
+}
+
+// Structured logger, with the level changeable while we're running.
+type Logger struct {
+	slog  *slog.Logger
+	level *slog.LevelVar
+}
+
+func MakeLogger(w io.Writer, format string) *Logger {
+	level := new(slog.LevelVar)
+	options := &slog.HandlerOptions{Level: level, ReplaceAttr: replaceLevelName}
+	var handler slog.Handler
+	if format == LogFormatJSON {
+		handler = slog.NewJSONHandler(w, options)
+	} else {
+		handler = slog.NewTextHandler(w, options)
+	}
+	return &Logger{slog: slog.New(handler), level: level}
+}
+
+// Names our extra levels, rather than slog's "INFO+2" and "ERROR+4".
+func replaceLevelName(groups []string, attr slog.Attr) slog.Attr {
+	if attr.Key == slog.LevelKey && len(groups) == 0 {
+		if level, ok := attr.Value.Any().(slog.Level); ok {
+			attr.Value = slog.StringValue(logLevelNames[level])
+		}
+	}
+	return attr
+}
+
+// Parses one of DEBUG, INFO, NOTICE, WARNING, ERROR or CRITICAL.
+func parseLogLevel(name string) (slog.Level, error) {
+	for level, levelName := range logLevelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+func (l *Logger) Level() string {
+	return logLevelNames[l.level.Level()]
+}
+
+func (l *Logger) SetLevel(level slog.Level) {
+	l.level.Set(level)
+}
+
+func (l *Logger) logf(level slog.Level, format string, args ...interface{}) {
+	if l.slog.Enabled(context.Background(), level) {
+		l.slog.Log(context.Background(), level, fmt.Sprintf(format, args...))
+	}
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.logf(slog.LevelDebug, format, args...)
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.logf(slog.LevelInfo, format, args...)
+}
+
+func (l *Logger) Noticef(format string, args ...interface{}) {
+	l.logf(LevelNotice, format, args...)
+}
+
+func (l *Logger) Warningf(format string, args ...interface{}) {
+	l.logf(slog.LevelWarn, format, args...)
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.logf(slog.LevelError, format, args...)
+}
+
+func (l *Logger) Criticalf(format string, args ...interface{}) {
+	l.logf(LevelCritical, format, args...)
+}
+
+func (l *Logger) Notice(msg string) {
+	l.slog.Log(context.Background(), LevelNotice, msg)
+}
+
+func (l *Logger) Warning(msg string) {
+	l.slog.Log(context.Background(), slog.LevelWarn, msg)
+}
+
+func (l *Logger) Error(msg string) {
+	l.slog.Log(context.Background(), slog.LevelError, msg)
+}
+
+// What handlers tell the access log about a request, beyond what it can see
+// for itself.
+type accessRecord struct {
+	Route    string
+	Player   string
+	Identity string
+	// Where the skin came from, whether we had it cached, and whether the
+	// render was cached.
+	Source    string
+	SkinCache string
+	Cache     string
+	// The hash of the skin's texture.
+	Texture string
+	// The trace the request is part of, if any.
+	TraceID string
+}
+
+// Notes where the skin the request is for came from.
+func (record *accessRecord) noteSkin(skin *mcSkin) {
+	record.Source = skin.Skin.Source
+	record.Texture = skin.Skin.Hash
+	record.SkinCache = "miss"
+	if skin.Cached {
+		record.SkinCache = "hit"
+	}
+}
+
+type accessRecordContextKey struct{}
+
+// Returns the access record for the request, or a throwaway one if it isn't
+// being logged, so handlers needn't check.
+func accessRecordFor(r *http.Request) *accessRecord {
+	if record, ok := r.Context().Value(accessRecordContextKey{}).(*accessRecord); ok {
+		return record
+	}
+	return &accessRecord{}
+}
+
+// Captures the status and size of the response for the access log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(data []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(data)
+	s.size += n
+	return n, err
+}
+
+// Keeps a response in memory, for handlers whose response we look at
+// rather than send.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: http.Header{}}
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(data)
+}
+
+// Logs a line for each request, with what the handlers recorded about it.
+func accessLogHandler(router http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		record := &accessRecord{TraceID: traceIDFrom(r.Context())}
+		recorder := &statusRecorder{ResponseWriter: w}
+		router.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), accessRecordContextKey{}, record)))
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("uri", r.RequestURI),
+			slog.Int("status", recorder.status),
+			slog.Int("size", recorder.size),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("remote", clientIP(r)),
+			slog.String("request_id", requestIDFrom(r.Context())),
+		}
+		for _, attr := range []slog.Attr{
+			slog.String("route", record.Route),
+			slog.String("player", record.Player),
+			slog.String("identity", record.Identity),
+			slog.String("source", record.Source),
+			slog.String("skin_cache", record.SkinCache),
+			slog.String("cache", record.Cache),
+			slog.String("trace_id", record.TraceID),
+		} {
+			if attr.Value.String() != "" {
+				attrs = append(attrs, attr)
+			}
+		}
+		log.slog.LogAttrs(context.Background(), slog.LevelInfo, "request", attrs...)
+		observeRoute(record, recorder.status, time.Since(start))
+		if accessLog != nil {
+			accessLog.write(r, record.Identity, recorder.status, recorder.size, start)
+		}
+	})
+}
+
+// Mux middleware noting which route matched, and for whom, once mux and
+// the Authenticators have had their say.
+func recordRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record := accessRecordFor(r)
+		if route := mux.CurrentRoute(r); route != nil {
+			record.Route, _ = route.GetPathTemplate()
+		}
+		vars := mux.Vars(r)
+		record.Player = vars["username"]
+		if record.Player != "" && topPlayers != nil {
+			topPlayers.record(strings.ToLower(record.Player))
+		}
+		if hash, byHash := vars["hash"]; byHash {
+			record.Player = hash
+		}
+		record.Identity = authIdentity(r)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	if level, err := parseLogLevel("notice"); err != nil || level != LevelNotice {
+		t.Fatalf("Expected NOTICE, got %v (%v)", level, err)
+	}
+	if _, err := parseLogLevel("LOUD"); err == nil {
+		t.Fatal("Expected an unknown level to be refused")
+	}
+}
+
+func TestLoggerLevels(t *testing.T) {
+	sw := new(StashingWriter)
+	logger := MakeLogger(sw, LogFormatText)
+	logger.SetLevel(LevelNotice)
+	logger.Infof("quiet %d", 1)
+	logger.Noticef("loud %d", 2)
+	output := sw.Unstash()
+	if strings.Contains(output, "quiet") || !strings.Contains(output, `level=NOTICE msg="loud 2"`) {
+		t.Fatalf("Expected only the notice, got %q", output)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	defer func(previous *Logger) { log = previous }(log)
+	sw := new(StashingWriter)
+	log = MakeLogger(sw, LogFormatJSON)
+
+	router := mux.NewRouter()
+	router.Use(recordRoute)
+	router.HandleFunc("/avatar/{username}", func(w http.ResponseWriter, r *http.Request) {
+		accessRecordFor(r).Source = "SessionProfile"
+		w.Write([]byte("image"))
+	})
+	handler := requestIDHandler(accessLogHandler(router))
+
+	r := httptest.NewRequest("GET", "/avatar/clone1018", nil)
+	r.Header.Set(RequestIDHeader, "abc123")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	line := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(sw.Unstash()), &line); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"level":      "INFO",
+		"route":      "/avatar/{username}",
+		"player":     "clone1018",
+		"status":     float64(200),
+		"size":       float64(5),
+		"source":     "SessionProfile",
+		"request_id": "abc123",
+	}
+	for key, value := range expected {
+		if line[key] != value {
+			t.Fatalf("Expected %s to be %v, got %v", key, value, line[key])
+		}
+	}
+	if _, ok := line["cache"]; ok {
+		t.Fatal("Expected fields the handler didn't set to be left out")
+	}
+}
+
+func TestLogLevelPage(t *testing.T) {
+	defer func(previous *Logger) { log = previous }(log)
+	log = MakeLogger(new(StashingWriter), LogFormatText)
+	log.SetLevel(slog.LevelInfo)
+	adminKeys = MakeAPIKeyAuthenticator([]string{"ops:adm1n"})
+	defer func() { adminKeys = nil }()
+	router := &Router{Mux: mux.NewRouter()}
+	router.BindAdmin()
+
+	r := httptest.NewRequest("PUT", "/admin/loglevel", strings.NewReader("DEBUG\n"))
+	r.Header.Set("X-Admin-Key", "adm1n")
+	w := httptest.NewRecorder()
+	router.Mux.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || log.Level() != "DEBUG" {
+		t.Fatalf("Expected the level to change, got %d and %s", w.Code, log.Level())
+	}
+
+	r = httptest.NewRequest("PUT", "/admin/loglevel", strings.NewReader("LOUD"))
+	r.Header.Set("X-Admin-Key", "adm1n")
+	w = httptest.NewRecorder()
+	router.Mux.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest || log.Level() != "DEBUG" {
+		t.Fatalf("Expected an unknown level to be refused, got %d", w.Code)
+	}
+}
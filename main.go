@@ -1,15 +1,24 @@
 package main
 
 import (
+	"crypto/rsa"
+	"crypto/tls"
+	"flag"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"os"
 	"runtime"
+	"sync/atomic"
+	"time"
 
 	"github.com/minotar/minecraft"
 
 	"github.com/gorilla/mux"
-	"github.com/op/go-logging"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/netutil"
+	"google.golang.org/grpc"
 )
 
 // Set the default, min and max width to resize processed images to.
@@ -24,13 +33,59 @@ const (
 var (
 	config        = &Configuration{}
 	cache         Cache
+	uuidCache     *UUIDCache
+	profileCache  *ProfileCache
+	historyCache  *HistoryCache
 	mcClient      *minecraft.Minecraft
+	upstream      *Upstream
+	mirrors       []*Mirror
+	signatureKey  *rsa.PublicKey
+	skinStore     SkinStore
 	stats         *StatusCollector
 	signalHandler *SignalHandler
+	authChain     []Authenticator
+	maintenance   *MaintenanceWorker
+	refresher     *Refresher
+	warmer        *Warmer
+	renderCache   *RenderCache
+	hotCache      *HotCache
+	snapshotter   *Snapshotter
+	handoff       *Handoff
+	httpServer    *http.Server
+	cors          *CORS
+	security      *SecurityHeaders
+	rateLimiter   *RateLimiter
+	ipFilter      *IPFilter
+	adminIPFilter *IPFilter
+	tenants       *Tenants
+	quota         *Quota
+	adminKeys     *APIKeyAuthenticator
+	uploadKeys    *APIKeyAuthenticator
+	missingFilter *MissingFilter
+	purger        *PurgeBroadcaster
+	accessLog     *AccessLog
+	readiness     *Readiness
+	routeAliases  []RouteAlias
+	customSkin    *minecraft.Skin
+	alexSkin      *minecraft.Skin
+	internalSrv   *http.Server
+	statsd        *StatsD
+	topPlayers    *TopPlayers
+	prefetcher    *Prefetcher
+	watcher       *Watcher
+	renderPool    *RenderPool
+	jobStore      JobStore
+	listenServers []*http.Server
+	grpcServer    *grpc.Server
+	chaos         *Chaos
+	// Requests being served through h2c, including the h2c connections
+	// it takes over, which last until they close.
+	h2cServing atomic.Int64
+	// Health of the textures CDN, which isn't behind the Upstream.
+	textureHealth = &UpstreamHealth{}
 )
 
-var log = logging.MustGetLogger("imgd")
-var format = "[%{time:15:04:05.000000}] %{level:.4s} %{message}"
+var log = MakeLogger(os.Stdout, LogFormatText)
 
 func setupConfig() {
 	err := config.load()
@@ -38,63 +93,602 @@ func setupConfig() {
 		fmt.Printf("Error loading config: %s\n", err)
 		return
 	}
+	configHashGauge.Set(float64(config.hash()))
 }
 
 func setupCache() {
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	historyCache = MakeHistoryCache()
+	refresher = MakeRefresher()
+	renderCache = MakeRenderCache(uint64(config.Server.RenderCacheMem)<<20, config.Server.RenderCacheCompress)
+	hotCache = MakeHotCache(config.Server.HotSize, config.Server.HotCacheEntries)
+	missingFilter = MakeMissingFilter(config.Server.MissingFilter, config.failedTtl())
 	cache = MakeCache(config.Server.Cache)
 	err := cache.setup()
 	if err != nil {
 		log.Criticalf("Unable to setup Cache. (%v)", err)
 		os.Exit(1)
 	}
+	if chaos != nil {
+		cache = MakeCacheChaos(cache, chaos)
+	}
+}
+
+// Readies the faults in [chaos] to inject, for "imgd serve -chaos".
+func setupChaos() {
+	c := config.Chaos
+	if err := checkChaosRates(c.LatencyRate, c.RateLimitRate, c.ErrorRate, c.CacheErrorRate); err != nil {
+		log.Criticalf("Invalid [chaos]. (%v)", err)
+		os.Exit(1)
+	}
+	chaos = &Chaos{
+		Latency:        msDuration(c.Latency),
+		LatencyRate:    c.LatencyRate,
+		RateLimitRate:  c.RateLimitRate,
+		ErrorRate:      c.ErrorRate,
+		CacheErrorRate: c.CacheErrorRate,
+	}
+	log.Warningf("Injecting faults for testing: %+v. Never serve like this in production!", *chaos)
+}
+
+func setupMaintenance() {
+	if config.Server.MaintenanceInterval <= 0 {
+		return
+	}
+	if config.Server.Cache == "redis" || config.Server.Cache == "memcached" {
+		log.Infof("The %s cache expires skins itself, so maintenance will only log a summary of its health", config.Server.Cache)
+	}
+	maintenance = MakeMaintenanceWorker(time.Duration(config.Server.MaintenanceInterval) * time.Second)
+	go maintenance.run()
+}
+
+func setupPurge() {
+	if config.Redis.PurgeChannel == "" {
+		return
+	}
+	purger = MakePurgeBroadcaster(config.Redis.PurgeChannel)
+	go purger.run()
+}
+
+func setupStatsD() {
+	if config.StatsD.Address == "" {
+		return
+	}
+
+	interval := time.Duration(config.StatsD.FlushInterval) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	var err error
+	statsd, err = MakeStatsD(config.StatsD.Address, config.StatsD.Prefix, config.StatsD.DogStatsD, interval)
+	if err != nil {
+		log.Criticalf("Unable to setup StatsD. (%v)", err)
+		os.Exit(1)
+	}
+	go statsd.run()
+}
+
+func setupSnapshot() {
+	if config.Server.SnapshotPath == "" {
+		return
+	}
+	if _, ok := cache.(snapshottingCache); !ok {
+		log.Warningf("The %s cache can't be snapshotted, ignoring snapshotpath", config.Server.Cache)
+		return
+	}
+
+	interval := time.Duration(config.Server.SnapshotInterval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	snapshotter = MakeSnapshotter(config.Server.SnapshotPath, interval)
+	// What we were handed is fresher than the last snapshot.
+	if handoff == nil || !handoff.Received {
+		if err := snapshotter.load(); err != nil {
+			// A bad snapshot shouldn't stop us starting, we'll just start cold.
+			log.Errorf("Unable to restore cache snapshot. (%v)", err)
+		}
+	}
+	go snapshotter.run()
+}
+
+func setupHandoff() {
+	if config.Server.HandoffSocket == "" {
+		return
+	}
+	c, ok := cache.(snapshottingCache)
+	if !ok {
+		log.Warningf("The %s cache can't be handed off, ignoring handoffsocket", config.Server.Cache)
+		return
+	}
+
+	handoff = MakeHandoff(config.Server.HandoffSocket, c)
+	start := time.Now()
+	if err := handoff.receive(); err != nil {
+		// As with a bad snapshot, we'll just start cold.
+		log.Errorf("Unable to take the cache over. (%v)", err)
+	} else if handoff.Received {
+		log.Noticef("Took the cache over from %s (skins: %d), took %s", handoff.Path, cache.size(), time.Since(start))
+	}
+	if err := handoff.listen(); err != nil {
+		log.Criticalf("Unable to listen for a cache handoff. (%v)", err)
+		os.Exit(1)
+	}
+}
+
+func setupQuota() {
+	limits, err := parseQuotaLimits(config.Quota.Limit)
+	if err != nil {
+		log.Criticalf("Unable to parse quota limits. (%v)", err)
+		os.Exit(1)
+	}
+	if config.Quota.Daily == 0 && len(limits) == 0 {
+		return
+	}
+	counter, err := MakeRedisQuotaCounter(config.Quota.Prefix)
+	if err != nil {
+		log.Criticalf("Unable to setup quotas. (%v)", err)
+		os.Exit(1)
+	}
+	quota = &Quota{Daily: int64(config.Quota.Daily), Limits: limits, Counter: counter}
+	log.Noticef("Quotas of %d requests a day (%d clients with their own), counted in Redis at %s", config.Quota.Daily, len(limits), config.Redis.Address)
+}
+
+func setupAuth() {
+	var err error
+	authChain, err = MakeAuthChain(config.Auth.Authenticator)
+	if err != nil {
+		log.Criticalf("Unable to setup Auth. (%v)", err)
+		os.Exit(1)
+	}
+
+	if keys := MakeAPIKeyAuthenticator(config.Admin.Key); len(keys.Keys) > 0 {
+		adminKeys = keys
+	}
+	if keys := MakeAPIKeyAuthenticator(config.Offline.UploadKey); len(keys.Keys) > 0 {
+		uploadKeys = keys
+	}
+	if config.RateLimit.Rate > 0 {
+		rateLimiter = MakeRateLimiter(config.RateLimit.Rate, config.RateLimit.Burst)
+	}
+	setupQuota()
+	cors = MakeCORS(config.CORS.Origin, config.CORS.Method, config.CORS.Header, config.CORS.MaxAge)
+	security = MakeSecurityHeaders(config.Security.NoSniff, config.Security.ReferrerPolicy, config.Security.StatusCSP, config.Security.HSTS)
+
+	deadlineTrusted, err = parseCIDRs(config.Server.DeadlineTrusted)
+	if err != nil {
+		log.Criticalf("Unable to parse deadlinetrusted. (%v)", err)
+		os.Exit(1)
+	}
+	trustedProxies, err = parseCIDRs(config.Server.TrustedProxy)
+	if err != nil {
+		log.Criticalf("Unable to parse trustedproxy. (%v)", err)
+		os.Exit(1)
+	}
+	ipFilter, err = MakeIPFilter(config.IPFilter.Allow, config.IPFilter.Deny)
+	if err != nil {
+		log.Criticalf("Unable to parse [ipfilter] allow or deny. (%v)", err)
+		os.Exit(1)
+	}
+	adminIPFilter, err = MakeIPFilter(config.IPFilter.AdminAllow, config.IPFilter.AdminDeny)
+	if err != nil {
+		log.Criticalf("Unable to parse [ipfilter] adminallow or admindeny. (%v)", err)
+		os.Exit(1)
+	}
+	tenants, err = MakeTenants(config.Tenant, config.Preset)
+	if err != nil {
+		log.Criticalf("Unable to setup tenants. (%v)", err)
+		os.Exit(1)
+	}
+}
+
+func setupFallback() {
+	if !loadAlexSkin() {
+		log.Warning("Alex isn't bundled, serving Steve in its place")
+	}
+	if config.Server.FallbackSkin == "" {
+		return
+	}
+	skin, err := loadFallbackSkin(config.Server.FallbackSkin)
+	if err != nil {
+		log.Criticalf("Unable to load fallbackskin. (%v)", err)
+		os.Exit(1)
+	}
+	customSkin = &skin
+}
+
+func setupAliases() {
+	var err error
+	routeAliases, err = parseRouteAliases(config.Alias.Route)
+	if err != nil {
+		log.Criticalf("Unable to parse aliases. (%v)", err)
+		os.Exit(1)
+	}
+}
+
+func setupRoutes() {
+	var err error
+	disabledRoutes, err = parseDisabledRoutes(config.Routes.Disable)
+	if err != nil {
+		log.Criticalf("Unable to parse disabled routes. (%v)", err)
+		os.Exit(1)
+	}
+	for name, size := range config.Size {
+		if size == nil {
+			continue
+		}
+		if err := checkRouteSize(name, size, config.Renderer); err != nil {
+			log.Criticalf("Invalid [size \"%s\"]. (%v)", name, err)
+			os.Exit(1)
+		}
+	}
+	for name, preset := range config.Preset {
+		if preset == nil {
+			continue
+		}
+		if err := checkPreset(preset); err != nil {
+			log.Criticalf("Invalid [preset \"%s\"]. (%v)", name, err)
+			os.Exit(1)
+		}
+		if _, exists := config.Theme[preset.Theme]; preset.Theme != "" && !exists {
+			log.Criticalf("Invalid [preset \"%s\"]. (unknown theme %q)", name, preset.Theme)
+			os.Exit(1)
+		}
+	}
+	themes, err = parseThemes(config.Theme)
+	if err != nil {
+		log.Criticalf("Unable to parse the themes. (%v)", err)
+		os.Exit(1)
+	}
+	extraHeaders, err = parseExtraHeaders(config.Headers)
+	if err != nil {
+		log.Criticalf("Unable to parse [headers]. (%v)", err)
+		os.Exit(1)
+	}
+	cardStyle, err = parseCardStyle(config.Card.Background, config.Card.Gradient, config.Card.Text, config.Card.Accent)
+	if err != nil {
+		log.Criticalf("Unable to parse [card] colours. (%v)", err)
+		os.Exit(1)
+	}
+}
+
+func setupAccessLog() {
+	if config.AccessLog.File == "" {
+		return
+	}
+	maxSize := int64(config.AccessLog.MaxSize) * 1024 * 1024
+	maxAge := time.Duration(config.AccessLog.MaxAge) * time.Hour
+	file, err := MakeRotatingFile(config.AccessLog.File, maxSize, maxAge)
+	if err != nil {
+		log.Criticalf("Unable to open the access log. (%v)", err)
+		os.Exit(1)
+	}
+	accessLog = MakeAccessLog(file)
+}
+
+func setupTopPlayers() {
+	if config.Server.TopPlayers <= 0 {
+		return
+	}
+
+	window := time.Duration(config.Server.TopPlayersWindow) * time.Second
+	if window <= 0 {
+		window = time.Hour
+	}
+	topPlayers = MakeTopPlayers(config.Server.TopPlayers, window)
+}
+
+func setupPrefetch() {
+	if config.Prefetch.Interval <= 0 {
+		return
+	}
+	if topPlayers == nil {
+		log.Warning("Not prefetching, as top players aren't being tracked")
+		return
+	}
+	if _, ok := cache.(agingCache); !ok {
+		log.Warning("Not prefetching, as the cache can't tell when skins expire")
+		return
+	}
+
+	prefetcher = MakePrefetcher(time.Duration(config.Prefetch.Interval)*time.Second, config.Prefetch.Players,
+		time.Duration(config.Prefetch.Ahead)*time.Second, config.Prefetch.Rate)
+	go prefetcher.run()
+}
+
+func setupWatcher() {
+	if config.Watch.Interval <= 0 {
+		return
+	}
+
+	watcher = MakeWatcher(time.Duration(config.Watch.Interval)*time.Second, config.Watch.File, config.Watch.Secret)
+	if err := watcher.load(); err != nil {
+		log.Criticalf("Unable to read watch file. (%v)", err)
+		os.Exit(1)
+	}
+	go watcher.run()
+}
+
+func setupRenderPool() {
+	if !config.Render.Pool {
+		return
+	}
+	workers := config.Render.Workers
+	if workers == 0 {
+		workers = runtime.NumCPU()
+	}
+	renderPool = MakeRenderPool(workers, config.Render.Queue, config.Render.LargeWidth)
+}
+
+func setupRenderers() {
+	var err error
+	pluginRenderers, err = makePluginRenderers(config.Renderer)
+	if err != nil {
+		log.Criticalf("Unable to setup renderer plugins. (%v)", err)
+		os.Exit(1)
+	}
+	for _, name := range pluginResources() {
+		log.Noticef("Rendering %s with %s", name, pluginRenderers[name].URL)
+	}
+}
+
+// Starts the workers for async render jobs, if they're enabled.
+func setupJobs() {
+	if config.Jobs.Workers == 0 {
+		return
+	}
+	jobStore = MakeJobStore(config.Jobs.Store, config.Jobs.Queue, time.Duration(config.Jobs.Ttl)*time.Second, int64(config.Jobs.ResultMem)<<20)
+	if err := jobStore.setup(); err != nil {
+		log.Criticalf("Unable to setup the render job store. (%v)", err)
+		os.Exit(1)
+	}
+	router := &Router{}
+	for i := 0; i < config.Jobs.Workers; i++ {
+		go router.runJobs(jobStore)
+	}
+}
+
+func setupWarmup() {
+	warmer = MakeWarmer(config.Warmup.Rate)
+	if config.Warmup.File == "" {
+		return
+	}
+
+	players, err := readWarmupFile(config.Warmup.File)
+	if err != nil {
+		log.Errorf("Unable to read warm-up file. (%v)", err)
+		return
+	}
+	warmer.start(players)
 }
 
 func setupMcClient() {
+	client, err := makeUpstreamClient(upstreamClientOptions{
+		Proxy:          config.Minecraft.Proxy,
+		CABundle:       config.Minecraft.CABundle,
+		ConnectTimeout: msDuration(config.Minecraft.ConnectTimeout),
+		ReadTimeout:    msDuration(config.Minecraft.ReadTimeout),
+		Retries:        config.Minecraft.Retries,
+		RetryBackoff:   msDuration(config.Minecraft.RetryBackoff),
+	})
+	if err != nil {
+		log.Criticalf("Unable to setup the Minecraft client. (%v)", err)
+		os.Exit(1)
+	}
+
+	if config.Minecraft.AuthServer != "" {
+		config.applyAuthServer()
+		log.Noticef("Using auth server %s", config.Minecraft.AuthServer)
+	}
+
 	mcClient = &minecraft.Minecraft{
-		Client:    minecraft.NewHTTPClient(),
+		Client:    client,
 		UserAgent: config.Minecraft.UserAgent,
 		UUIDAPI: minecraft.UUIDAPI{
 			SessionServerURL: config.Minecraft.SessionServerURL,
 			ProfileURL:       config.Minecraft.ProfileURL,
 		},
 	}
+	if config.Minecraft.BackoffBase <= 0 || config.Minecraft.BackoffMax <= 0 {
+		log.Criticalf("[minecraft] backoffbase and backoffmax must be more than 0, or we'd never back off")
+		os.Exit(1)
+	}
+	upstream = MakeUpstream(msDuration(config.Minecraft.BackoffBase), msDuration(config.Minecraft.BackoffMax))
+	upstream.Breaker = MakeCircuitBreaker(config.Minecraft.BreakerThreshold, msDuration(config.Minecraft.BreakerCooldown))
+	if config.Minecraft.Budget > 0 {
+		upstream.Budget = MakeTokenBucket(float64(config.Minecraft.Budget)/60, config.Minecraft.BudgetBurst)
+	}
+
+	if config.Minecraft.VerifySignature && config.Minecraft.SignatureKey == "" && config.Minecraft.AuthServer != "" {
+		signatureKey, err = fetchAuthServerKey(config.Minecraft.AuthServer)
+		if err != nil {
+			log.Criticalf("Unable to fetch the auth server's signature key. (%v)", err)
+			os.Exit(1)
+		}
+	} else if config.Minecraft.VerifySignature {
+		signatureKey, err = readSignatureKey(config.Minecraft.SignatureKey)
+		if err != nil {
+			log.Criticalf("Unable to read signaturekey. (%v)", err)
+			os.Exit(1)
+		}
+	}
+
+	mirrors, err = MakeMirrors(config.Mirror.URL)
+	if err != nil {
+		log.Criticalf("Unable to setup mirrors. (%v)", err)
+		os.Exit(1)
+	}
 }
 
-func setupLog(logBackend *logging.LogBackend) {
-	logging.SetBackend(logBackend)
-	logging.SetFormatter(logging.MustStringFormatter(format))
-	logLevel, err := logging.LogLevel(config.Server.Logging)
-	logging.SetLevel(logLevel, "")
+func setupSkinStore() {
+	var err error
+	skinStore, err = MakeSkinStore(config.Offline.Store)
+	if err != nil {
+		log.Criticalf("Unable to setup the skin store. (%v)", err)
+		os.Exit(1)
+	}
+	if _, ok := skinStore.(WritableSkinStore); config.Offline.Upload && !ok {
+		log.Criticalf("Skin uploads need a store they can be written to, eg. disk or s3.")
+		os.Exit(1)
+	}
+}
+
+func setupLog(w io.Writer) {
+	log = MakeLogger(w, config.Server.LogFormat)
+	logLevel, err := parseLogLevel(config.Server.Logging)
 	if err != nil {
 		log.Errorf("Invalid log type: %s", config.Server.Logging)
-		// If error it sets the logging to ERROR, let's change it to INFO
-		logging.SetLevel(4, "")
 	}
-	log.Noticef("Log level set to %s", logging.GetLevel(""))
+	log.SetLevel(logLevel)
+	log.Noticef("Log level set to %s", log.Level())
+}
+
+// Serves the metrics, status and admin routes on their own address, eg. one
+// only reachable from inside our network, so they're never exposed through
+// the public ingress. Admin routes still need an admin key or identity.
+func startInternalServer() {
+	r := Router{Mux: mux.NewRouter()}
+	r.Mux.NotFoundHandler = NotFoundHandler{}
+	r.Mux.Use(recordRoute)
+	r.BindInternal()
+	// Everything here is internal, so it's held to the admin lists alone.
+	handler := imgdHandler(healthHandler(ipFilterHandler(adminIPFilter, nil, authHandler(authChain, false, r.Mux))))
+
+	listener, err := net.Listen("tcp", config.Server.InternalAddress)
+	if err != nil {
+		log.Criticalf("Listen: \"%s\"", err.Error())
+		os.Exit(1)
+	}
+	log.Noticef("Serving metrics, status and admin on %s", listener.Addr())
+	internalSrv = makeServer(handler)
+	go func() {
+		if err := internalSrv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Criticalf("Serve: \"%s\"", err.Error())
+			os.Exit(1)
+		}
+	}()
 }
 
 func startServer() {
+	if config.Server.InternalAddress != "" {
+		startInternalServer()
+	}
+	if config.GRPC.Address != "" {
+		startGRPCServer()
+	}
+	handler := publicHandler()
+	listener, err := listen(config.Server.Address)
+	if err != nil {
+		log.Criticalf("Listen: \"%s\"", err.Error())
+		os.Exit(1)
+	}
+	if config.Server.MaxConns > 0 {
+		// Further connections wait in the kernel's backlog until one closes.
+		listener = netutil.LimitListener(listener, config.Server.MaxConns)
+	}
+	log.Noticef("imgd %s starting on %s", ImgdVersion, listener.Addr())
+	go readiness.warmAfter(warmer)
+	startListeners(handler)
+	httpServer = makeServer(handler)
+	httpServer.Addr = config.Server.Address
+	err = serveOn(httpServer, listener, config.TLS.Cert, config.TLS.Key)
+	if err == http.ErrServerClosed {
+		// We're draining, shutdown exits once that's done.
+		select {}
+	}
+	if err != nil {
+		log.Criticalf("Serve: \"%s\"", err.Error())
+		os.Exit(1)
+	}
+}
+
+// Binds the public routes, behind everything a request passes through on
+// its way to them.
+func publicHandler() http.Handler {
 	r := Router{Mux: mux.NewRouter()}
 	r.Bind()
-	http.Handle("/", imgdHandler(r.Mux))
-	log.Noticef("imgd %s starting on %s", ImgdVersion, config.Server.Address)
-	err := http.ListenAndServe(config.Server.Address, nil)
+	security.Disallowed = robotsDisallowed(r.Mux, routeAliases)
+	timeout := time.Duration(config.Server.RequestTimeout) * time.Second
+	return imgdHandler(timeoutHandler(timeout, healthHandler(ipFilterHandler(ipFilter, adminIPFilter, authHandler(authChain, config.Auth.Required, deadlineHandler(rateLimitHandler(rateLimiter, quotaHandler(quota, aliasHandler(routeAliases, tenantHandler(tenants, debugHandler(r.Mux)))))))))))
+}
+
+// Makes a server with the configured timeouts, so slow or idle clients can't
+// hold connections open indefinitely.
+func makeServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:        handler,
+		ReadTimeout:    time.Duration(config.Server.ReadTimeout) * time.Second,
+		WriteTimeout:   time.Duration(config.Server.WriteTimeout) * time.Second,
+		IdleTimeout:    time.Duration(config.Server.IdleTimeout) * time.Second,
+		MaxHeaderBytes: config.Server.MaxHeaderBytes,
+	}
+}
+
+// Serves HTTPS, with HTTP/2 for clients which support it.
+func serveTLS(server *http.Server, listener net.Listener, cert string, key string) error {
+	reloader, err := MakeCertReloader(cert, key)
 	if err != nil {
-		log.Criticalf("ListenAndServe: \"%s\"", err.Error())
-		os.Exit(1)
+		return err
+	}
+	if config.TLS.Reload > 0 {
+		go reloader.watch(time.Duration(config.TLS.Reload) * time.Second)
 	}
+
+	server.TLSConfig = &tls.Config{GetCertificate: reloader.getCertificate}
+	if err := http2.ConfigureServer(server, &http2.Server{}); err != nil {
+		return err
+	}
+	return server.ServeTLS(listener, "", "")
 }
 
 func main() {
-	runtime.GOMAXPROCS(runtime.NumCPU())
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+// Serves images over HTTP until we're stopped, for "imgd serve".
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	withChaos := fs.Bool("chaos", false, "inject the faults in [chaos], for testing in staging")
+	selfTest := fs.Bool("self-test", false, "render a bundled skin as everything we serve, report how it went and exit")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *selfTest {
+		return runSelfTest()
+	}
 
-	logBackend := logging.NewLogBackend(os.Stdout, "", 0)
+	runtime.GOMAXPROCS(runtime.NumCPU())
 
 	signalHandler = MakeSignalHandler()
 	stats = MakeStatsCollector()
+	readiness = MakeReadiness()
 	setupConfig()
-	setupLog(logBackend)
+	setupLog(os.Stdout)
+	setupAccessLog()
+	if *withChaos {
+		setupChaos()
+	}
 	setupCache()
+	setupStatsD()
+	setupHandoff()
+	setupSnapshot()
+	setupPurge()
+	setupMaintenance()
+	setupAuth()
+	setupAliases()
+	setupRoutes()
 	setupMcClient()
+	setupSkinStore()
+	setupFallback()
+	setupWarmup()
+	setupTopPlayers()
+	setupPrefetch()
+	setupWatcher()
+	setupRenderPool()
+	setupRenderers()
+	setupJobs()
+	go prerenderDefaults(&Router{})
 	startServer()
+	return 0
 }
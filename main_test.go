@@ -7,7 +7,6 @@ import (
 	_ "image/png"
 	"testing"
 
-	"github.com/op/go-logging"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -33,10 +32,9 @@ func hashRender(render image.Image) string {
 }
 
 func TestSetup(t *testing.T) {
-	logBackend := logging.NewLogBackend(SilentWriter{}, "", 0)
 	stats = MakeStatsCollector()
 	setupConfig()
-	setupLog(logBackend)
+	setupLog(SilentWriter{})
 	setupCache()
 	setupMcClient()
 }
@@ -121,10 +119,9 @@ func TestRenders(t *testing.T) {
 }
 
 func BenchmarkSetup(b *testing.B) {
-	logBackend := logging.NewLogBackend(SilentWriter{}, "", 0)
 	stats = MakeStatsCollector()
 	setupConfig()
-	setupLog(logBackend)
+	setupLog(SilentWriter{})
 	setupCache()
 }
 
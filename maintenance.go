@@ -0,0 +1,51 @@
+package main
+
+import (
+	"time"
+)
+
+// Periodically tidies up the cache and logs a one-line summary of its health.
+type MaintenanceWorker struct {
+	Interval time.Duration
+	stop     chan struct{}
+}
+
+func MakeMaintenanceWorker(interval time.Duration) *MaintenanceWorker {
+	return &MaintenanceWorker{Interval: interval, stop: make(chan struct{})}
+}
+
+func (m *MaintenanceWorker) run() {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.runOnce()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// Compacts the cache if it supports it, then logs its freshly computed size.
+func (m *MaintenanceWorker) runOnce() {
+	start := time.Now()
+
+	var removed uint
+	if compactor, ok := cache.(compactingCache); ok {
+		var err error
+		removed, err = compactor.compact()
+		if err != nil {
+			log.Errorf("Maintenance: compaction failed (%v)", err)
+			stats.Errored(ErrCacheCompact)
+		}
+	}
+
+	log.Noticef("Maintenance: cache holds %d skins in %d bytes, removed %d, took %s",
+		cache.size(), cache.memory(), removed, time.Since(start))
+}
+
+func (m *MaintenanceWorker) Stop() {
+	close(m.stop)
+}
@@ -1,10 +1,33 @@
 package main
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minotar/imgd/pkg/mcskin"
+	"github.com/minotar/minecraft"
+	"github.com/prometheus/client_golang/prometheus"
+)
 
 const namespace = "imgd"
 
 var (
+	buildInfoGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "build_info",
+		Help:      "Always 1, labelled with the version and commit imgd was built from.",
+	}, []string{"version", "commit", "goversion"})
+
+	configHashGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "config_hash",
+		Help:      "A hash of the configuration we're running with, which differs between instances whose configs do.",
+	})
+
 	inFlightGauge = prometheus.NewGauge(prometheus.GaugeOpts{
 		Namespace: namespace,
 		Subsystem: "http",
@@ -36,6 +59,14 @@ var (
 		Buckets:   []float64{.00025, .0005, 0.001, 0.0025, .005},
 	}, []string{"resource"})
 
+	renderStageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "image",
+		Name:      "stage_duration_seconds",
+		Help:      "Histogram of the time (in seconds) each stage of rendering took: extract, composite, scale or encode.",
+		Buckets:   []float64{.00001, .000025, .00005, .0001, .00025, .0005, .001, .0025, .005, .01},
+	}, []string{"resource", "format", "stage"})
+
 	getDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Namespace: namespace,
 		Subsystem: "texture",
@@ -44,6 +75,51 @@ var (
 		Buckets:   []float64{.1, .25, .5, 1},
 	}, []string{"source"})
 
+	textureSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "texture",
+		Name:      "size_bytes",
+		Help:      "Histogram of the size (in bytes) of each texture downloaded.",
+		Buckets:   prometheus.ExponentialBuckets(256, 2, 9),
+	})
+
+	skinFormatCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "texture",
+			Name:      "skin_formats_total",
+			Help:      "Counter of skins downloaded, by format: legacy (64x32), modern (64x64) or hd.",
+		},
+		[]string{"format"},
+	)
+
+	fetchStageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "fetch",
+		Name:      "stage_duration_seconds",
+		Help:      "Histogram of the time (in seconds) each stage of fetching a player took: uuid, profile, skin or cape.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"stage"})
+
+	unchangedTextureCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "texture",
+			Name:      "unchanged_total",
+			Help:      "Counter of skins refreshed without downloading their texture, as its URL hadn't changed.",
+		},
+	)
+
+	invalidTextureCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "texture",
+			Name:      "invalid_total",
+			Help:      "Counter of textures refused, by reason: too_large, corrupt or dimensions.",
+		},
+		[]string{"reason"},
+	)
+
 	cacheDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Namespace: namespace,
 		Subsystem: "cache",
@@ -52,12 +128,20 @@ var (
 		Buckets:   []float64{.0005, .001, 0.0025, .005, 0.0075, 0.01, 0.1},
 	}, []string{"operation"})
 
+	cacheEntrySize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "entry_size_bytes",
+		Help:      "Histogram of the memory or storage (in bytes) each entry stored takes, by backend.",
+		Buckets:   prometheus.ExponentialBuckets(256, 2, 10),
+	}, []string{"backend"})
+
 	errorCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: namespace,
 			Subsystem: "status",
 			Name:      "errors",
-			Help:      "Error events",
+			Help:      "Error events, by their stable error code.",
 		},
 		[]string{"event"},
 	)
@@ -92,6 +176,306 @@ var (
 		[]string{"call"},
 	)
 
+	coalescedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "status",
+			Name:      "coalesced",
+			Help:      "Requests to external APIs saved by waiting on an identical one in flight",
+		},
+		[]string{"call"},
+	)
+
+	upstreamInFlightGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "status",
+		Name:      "in_flight",
+		Help:      "Requests to external APIs in flight, which identical ones may wait on.",
+	}, []string{"call"})
+
+	refreshPendingGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "refreshes_pending",
+		Help:      "Stale skins being refreshed in the background.",
+	})
+
+	grpcCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "grpc",
+			Name:      "calls_total",
+			Help:      "Counter of gRPC calls, by method and status code.",
+		},
+		[]string{"method", "code"},
+	)
+
+	chaosCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "chaos",
+			Name:      "faults_total",
+			Help:      "Counter of faults injected for testing, by fault: latency, rate_limit, upstream_error or cache_error.",
+		},
+		[]string{"fault"},
+	)
+
+	cacheEntriesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "entries",
+		Help:      "Number of entries held by each cache backend.",
+	}, []string{"backend"})
+
+	cacheBytesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "bytes",
+		Help:      "Bytes used by each cache backend.",
+	}, []string{"backend"})
+
+	cacheFillGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "fill_ratio",
+		Help:      "Fraction of its size limit each bounded cache backend is using.",
+	}, []string{"backend"})
+
+	tierHitRatioGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "tier_hit_ratio",
+		Help:      "Fraction of cache lookups served by each tier of a tiered cache.",
+	}, []string{"tier"})
+
+	evictionCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "evictions_total",
+			Help:      "Entries dropped to make room, by cache backend.",
+		},
+		[]string{"backend"},
+	)
+
+	expirationCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "expirations_total",
+			Help:      "Entries dropped because their TTL ran out, by cache backend.",
+		},
+		[]string{"backend"},
+	)
+
+	removalCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "removals_total",
+			Help:      "Entries removed from each cache backend, by why: ttl, lru or purge.",
+		},
+		[]string{"backend", "reason"},
+	)
+
+	admittedBytesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "admitted_bytes_total",
+			Help:      "Bytes stored by each cache backend.",
+		},
+		[]string{"backend"},
+	)
+
+	evictedBytesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "evicted_bytes_total",
+			Help:      "Bytes freed by each cache backend, whatever the entries were removed for.",
+		},
+		[]string{"backend"},
+	)
+
+	hitRatioGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "hit_ratio",
+		Help:      "Fraction of skin cache lookups which hit over the last five minutes.",
+	})
+
+	entryAgeGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "entry_age_seconds",
+		Help:      "Average time entries in each cache backend have been held for.",
+	}, []string{"backend"})
+
+	upstreamCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "upstream",
+			Name:      "requests_total",
+			Help:      "Requests to Mojang and its mirrors, by upstream and result.",
+		},
+		[]string{"upstream", "result"},
+	)
+
+	upstreamHealthyGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "healthy",
+		Help:      "Whether we're currently willing to ask each upstream, 1 if so.",
+	}, []string{"upstream"})
+
+	timeoutCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "status",
+			Name:      "timeouts",
+			Help:      "Upstream requests which timed out, by host",
+		},
+		[]string{"host"},
+	)
+
+	collapsedLabelCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "metrics",
+			Name:      "collapsed_labels_total",
+			Help:      "Label values counted as \"other\", as the metric already had too many, by metric.",
+		},
+		[]string{"metric"},
+	)
+
+	rateLimitedCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "status",
+		Name:      "rate_limited",
+		Help:      "Requests refused as the client was over the rate limit",
+	})
+
+	pluginDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "render",
+		Name:      "plugin_duration_seconds",
+		Help:      "Histogram of the time (in seconds) renderer plugins took to answer, by render type.",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"resource"})
+
+	prefetchCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "prefetched_total",
+		Help:      "Counter of popular players' skins refreshed ahead of expiring.",
+	})
+
+	quotaExceededCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "status",
+		Name:      "quota_exceeded",
+		Help:      "Requests refused as the client had used up their daily quota",
+	})
+
+	tenantRequestsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "status",
+		Name:      "tenant_requests_total",
+		Help:      "Requests from each tenant, by whether they were served, rate limited or forbidden",
+	}, []string{"tenant", "result"})
+
+	routeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "route_duration_seconds",
+		Help:      "Histogram of the time (in seconds) requests took, by route and whether they were served from cache.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"route", "class"})
+
+	responseCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "responses_total",
+		Help:      "Counter of responses by route and status class, eg. 2xx.",
+	}, []string{"route", "code"})
+
+	upstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "request_duration_seconds",
+		Help:      "Histogram of the time (in seconds) each request to Mojang or a mirror took, by host. Retries are timed apart.",
+		Buckets:   []float64{.025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"host"})
+
+	upstreamResponseCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "upstream",
+			Name:      "responses_total",
+			Help:      "Responses from Mojang and mirrors by host and status code, eg. 429 when rate limited, or \"timeout\" or \"error\" when there was none.",
+		},
+		[]string{"host", "code"},
+	)
+
+	upstreamAvailabilityGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "availability",
+		Help:      "How available each upstream seems from our recent requests to it: 0 down, 1 degraded, 2 up, -1 unknown.",
+	}, []string{"upstream"})
+	circuitGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "circuit_state",
+		Help:      "State of each upstream's circuit breaker: 0 closed, 1 half-open, 2 open.",
+	}, []string{"upstream"})
+
+	skinChangeCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "watch",
+		Name:      "skin_changes_total",
+		Help:      "Counter of skin changes the watcher has seen.",
+	})
+
+	webhookCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "watch",
+		Name:      "webhooks_total",
+		Help:      "Counter of webhooks sent for skin changes, by result: \"delivered\" or \"failed\".",
+	}, []string{"result"})
+
+	renderQueueGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "render",
+		Name:      "queued",
+		Help:      "Heavy renders waiting for a worker.",
+	})
+
+	panicCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "status",
+			Name:      "panics_total",
+			Help:      "Counter of panics recovered from, by where: handler, render, fetch or refresh.",
+		},
+		[]string{"where"},
+	)
+
+	renderAbandonedCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "render",
+		Name:      "abandoned_total",
+		Help:      "Counter of queued renders skipped as their request had gone or run out of time.",
+	})
+
+	renderRejectedCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "render",
+		Name:      "rejected_total",
+		Help:      "Counter of renders refused with a 503 as the render queue was full.",
+	})
+
 	// Latency on Get (source of skin) :tick:
 	// Total latency for HTTP request (response code) :tick:
 	// Latency on cache  (has, puul or add) :tick:
@@ -101,14 +485,310 @@ var (
 )
 
 func init() {
+	prometheus.MustRegister(buildInfoGauge)
+	prometheus.MustRegister(configHashGauge)
 	prometheus.MustRegister(inFlightGauge)
 	prometheus.MustRegister(requestDuration)
+	prometheus.MustRegister(routeDuration)
+	prometheus.MustRegister(responseCounter)
 	prometheus.MustRegister(responseSize)
 	prometheus.MustRegister(processingDuration)
+	prometheus.MustRegister(renderStageDuration)
 	prometheus.MustRegister(getDuration)
+	prometheus.MustRegister(textureSize)
+	prometheus.MustRegister(skinFormatCounter)
+	prometheus.MustRegister(invalidTextureCounter)
+	prometheus.MustRegister(unchangedTextureCounter)
+	prometheus.MustRegister(fetchStageDuration)
 	prometheus.MustRegister(cacheDuration)
+	prometheus.MustRegister(cacheEntrySize)
 	prometheus.MustRegister(errorCounter)
 	prometheus.MustRegister(cacheCounter)
 	prometheus.MustRegister(requestCounter)
 	prometheus.MustRegister(apiCounter)
+	prometheus.MustRegister(coalescedCounter)
+	prometheus.MustRegister(upstreamInFlightGauge)
+	prometheus.MustRegister(refreshPendingGauge)
+	prometheus.MustRegister(grpcCounter)
+	prometheus.MustRegister(chaosCounter)
+	prometheus.MustRegister(cacheEntriesGauge)
+	prometheus.MustRegister(cacheBytesGauge)
+	prometheus.MustRegister(cacheFillGauge)
+	prometheus.MustRegister(tierHitRatioGauge)
+	prometheus.MustRegister(evictionCounter)
+	prometheus.MustRegister(expirationCounter)
+	prometheus.MustRegister(removalCounter)
+	prometheus.MustRegister(admittedBytesCounter)
+	prometheus.MustRegister(evictedBytesCounter)
+	prometheus.MustRegister(hitRatioGauge)
+	prometheus.MustRegister(entryAgeGauge)
+	prometheus.MustRegister(upstreamCounter)
+	prometheus.MustRegister(upstreamHealthyGauge)
+	prometheus.MustRegister(upstreamDuration)
+	prometheus.MustRegister(upstreamResponseCounter)
+	prometheus.MustRegister(circuitGauge)
+	prometheus.MustRegister(upstreamAvailabilityGauge)
+	prometheus.MustRegister(skinChangeCounter)
+	prometheus.MustRegister(webhookCounter)
+	prometheus.MustRegister(renderQueueGauge)
+	prometheus.MustRegister(renderRejectedCounter)
+	prometheus.MustRegister(renderAbandonedCounter)
+	prometheus.MustRegister(panicCounter)
+	prometheus.MustRegister(timeoutCounter)
+	prometheus.MustRegister(rateLimitedCounter)
+	prometheus.MustRegister(quotaExceededCounter)
+	prometheus.MustRegister(tenantRequestsCounter)
+	prometheus.MustRegister(prefetchCounter)
+	prometheus.MustRegister(pluginDuration)
+	prometheus.MustRegister(collapsedLabelCounter)
+
+	commit, _ := buildInfo()
+	buildInfoGauge.WithLabelValues(ImgdVersion, commit, runtime.Version()).Set(1)
+}
+
+// Most values a label may take, past which new ones are counted as
+// otherLabel, and the longest a value may be.
+const (
+	maxLabelValues = 100
+	maxLabelLength = 64
+	otherLabel     = "other"
+)
+
+// Keeps a label to a bounded set of values, so a flood of odd hostnames or
+// the like can't blow up the number of series Prometheus has to keep, or the
+// maps /stats reports. The first maxLabelValues values seen, along with any
+// allowed up front, are kept; later ones are counted as otherLabel.
+type labelLimit struct {
+	Metric string
+
+	mu     sync.RWMutex
+	values map[string]bool
+}
+
+func makeLabelLimit(metric string, allowed ...string) *labelLimit {
+	l := &labelLimit{Metric: metric, values: map[string]bool{}}
+	for _, value := range allowed {
+		l.values[normalizeLabel(value)] = true
+	}
+	return l
+}
+
+// Returns the value to label the metric with.
+func (l *labelLimit) value(value string) string {
+	value = normalizeLabel(value)
+	l.mu.RLock()
+	known := l.values[value]
+	l.mu.RUnlock()
+	if known {
+		return value
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.values[value] && len(l.values) >= maxLabelValues {
+		collapsedLabelCounter.WithLabelValues(l.Metric).Inc()
+		return otherLabel
+	}
+	l.values[value] = true
+	return value
+}
+
+// Replaces anything but letters, digits and a little punctuation, and
+// shortens overly long values.
+func normalizeLabel(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || strings.ContainsRune("_-.:/", r) {
+			return r
+		}
+		return '_'
+	}, value)
+	if len(value) > maxLabelLength {
+		value = value[:maxLabelLength]
+	}
+	return value
+}
+
+// The labels fed from values we don't choose ourselves.
+var (
+	errorLabels     = makeLabelLimit("errors")
+	requestLabels   = makeLabelLimit("requests", renderResources...)
+	apiLabels       = makeLabelLimit("api")
+	coalescedLabels = makeLabelLimit("coalesced")
+	hostLabels      = makeLabelLimit("hosts")
+)
+
+// Records how long the request took against its route, eg. "avatar" or
+// "texture/helm", and its class: "cache-hit" when we had the skin or render
+// cached, "cache-miss" when we had to ask upstream, "error" for a 5xx, or
+// "none" when no skin was involved. The response is also counted by its
+// status class.
+func observeRoute(record *accessRecord, status int, took time.Duration) {
+	route := routeLabel(record.Route)
+	observeWithTrace(routeDuration.WithLabelValues(route, responseClass(record, status)), took.Seconds(), record.TraceID)
+	stats.Responded(route, statusClass(status))
+}
+
+// Returns "2xx" for a 200, "4xx" for a 404 and so on.
+func statusClass(status int) string {
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// Records how long each stage of a render took, by what was rendered and the
+// format it was encoded as.
+func observeRender(resource string, extension string, timings mcskin.Timings) {
+	format := "png"
+	if _, known := renderFormats[extension]; known {
+		format = strings.TrimPrefix(extension, ".")
+	}
+	for stage, took := range map[string]time.Duration{
+		"extract":   timings.Extract,
+		"composite": timings.Composite,
+		"scale":     timings.Scale,
+		"encode":    timings.Encode,
+	} {
+		renderStageDuration.WithLabelValues(resource, format, stage).Observe(took.Seconds())
+	}
+}
+
+// Records a single request to an upstream host, and how it went.
+func observeUpstream(host string, took time.Duration, resp *http.Response, err error) {
+	host = hostLabels.value(host)
+	upstreamDuration.WithLabelValues(host).Observe(took.Seconds())
+	code := "error"
+	if isTimeout(err) {
+		code = "timeout"
+	} else if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	upstreamResponseCounter.WithLabelValues(host, code).Inc()
+}
+
+// Shortens a route's path template to its fixed parts, eg.
+// "/armor/bust/{username:...}/{width:[0-9]+}" to "armor/bust" and
+// "/texture/{hash:...}/helm{extension:...}" to "texture/helm".
+func routeLabel(template string) string {
+	if template == "" {
+		return "unmatched"
+	}
+	parts := []string{}
+	for _, part := range strings.Split(template, "/") {
+		if fixed, _, _ := strings.Cut(part, "{"); fixed != "" {
+			parts = append(parts, fixed)
+		}
+	}
+	if len(parts) == 0 {
+		return "root"
+	}
+	return strings.Join(parts, "/")
+}
+
+func responseClass(record *accessRecord, status int) string {
+	switch {
+	case status >= 500:
+		return "error"
+	case record.SkinCache == "miss" || (record.Cache == "miss" && record.SkinCache == ""):
+		return "cache-miss"
+	case record.SkinCache == "hit" || record.Cache == "hit":
+		return "cache-hit"
+	default:
+		return "none"
+	}
+}
+
+// Why entries leave a cache.
+const (
+	removedTTL   = "ttl"
+	removedLRU   = "lru"
+	removedPurge = "purge"
+)
+
+// Cache traffic by "backend reason" for removals, and by backend for bytes,
+// for /stats.
+var (
+	cacheRemovals counterMap
+	cacheAdmitted counterMap
+	cacheEvicted  counterMap
+)
+
+// Counts entries removed from a cache backend, and why.
+func countRemoved(backend string, reason string, n uint) {
+	if n == 0 {
+		return
+	}
+	switch reason {
+	case removedLRU:
+		evictionCounter.WithLabelValues(backend).Add(float64(n))
+	case removedTTL:
+		expirationCounter.WithLabelValues(backend).Add(float64(n))
+	}
+	removalCounter.WithLabelValues(backend, reason).Add(float64(n))
+	cacheRemovals.add(backend+" "+reason, uint64(n))
+}
+
+// Counts bytes stored in a cache backend.
+func countAdmitted(backend string, bytes uint64) {
+	admittedBytesCounter.WithLabelValues(backend).Add(float64(bytes))
+	cacheEntrySize.WithLabelValues(backend).Observe(float64(bytes))
+	cacheAdmitted.add(backend, bytes)
+}
+
+// Returns the skin's format, for counting: legacy 64x32 skins, modern
+// 64x64 ones, or HD ones larger than that.
+func skinFormat(skin minecraft.Skin) string {
+	if skin.Image == nil {
+		return "unknown"
+	}
+	bounds := skin.Image.Bounds()
+	switch {
+	case bounds.Dx() == 64 && bounds.Dy() == 32:
+		return "legacy"
+	case bounds.Dx() == 64 && bounds.Dy() == 64:
+		return "modern"
+	case bounds.Dx() > 64:
+		return "hd"
+	}
+	return "unknown"
+}
+
+// Counts a texture refused for the reason.
+func countInvalidTexture(reason string) {
+	invalidTextureCounter.WithLabelValues(reason).Inc()
+	stats.Errored(ErrInvalidTexture)
+}
+
+// Counts bytes freed from a cache backend.
+func countEvicted(backend string, bytes uint64) {
+	evictedBytesCounter.WithLabelValues(backend).Add(float64(bytes))
+	cacheEvicted.add(backend, bytes)
+}
+
+// Updates the gauges for the cache, and each tier of it if it's tiered.
+func recordCacheMetrics(c Cache) {
+	if tiered, ok := c.(*CacheTiered); ok {
+		recordCacheMetrics(tiered.L1)
+		recordCacheMetrics(tiered.L2)
+		return
+	}
+
+	backend := cacheBackend(c)
+	size, mem := c.size(), c.memory()
+	cacheEntriesGauge.WithLabelValues(backend).Set(float64(size))
+	cacheBytesGauge.WithLabelValues(backend).Set(float64(mem))
+	if bounded, ok := c.(boundedCache); ok && bounded.capacity() > 0 {
+		cacheFillGauge.WithLabelValues(backend).Set(float64(mem) / float64(bounded.capacity()))
+	}
+	if aged, ok := c.(agedCache); ok {
+		entryAgeGauge.WithLabelValues(backend).Set(aged.averageAge().Seconds())
+	}
+}
+
+// The render cache sits apart from the skin cache, so is recorded as its
+// own backend.
+func recordRenderCacheMetrics(c *RenderCache) {
+	cacheEntriesGauge.WithLabelValues("render").Set(float64(c.size()))
+	cacheBytesGauge.WithLabelValues("render").Set(float64(c.memory()))
+	if c.enabled() {
+		cacheFillGauge.WithLabelValues("render").Set(float64(c.memory()) / float64(c.MaxMem))
+	}
+	entryAgeGauge.WithLabelValues("render").Set(c.averageAge().Seconds())
 }
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minotar/imgd/pkg/mcskin"
+	"github.com/minotar/minecraft"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"gopkg.in/gcfg.v1"
+)
+
+func TestRouteLabel(t *testing.T) {
+	for template, expected := range map[string]string{
+		"":  "unmatched",
+		"/": "root",
+		"/avatar/{username:" + playerRegex + "}{extension:(?:\\..*)?}":                    "avatar",
+		"/armor/bust/{username:" + playerRegex + "}/{width:[0-9]+}{extension:(?:\\..*)?}": "armor/bust",
+		"/texture/{hash:" + textureHashRegex + "}/helm{extension:(?:\\..*)?}":             "texture/helm",
+		"/texture/{hash:" + textureHashRegex + "}{extension:(?:.png)?}":                   "texture",
+	} {
+		if label := routeLabel(template); label != expected {
+			t.Fatalf("Expected %q for %q, got %q", expected, template, label)
+		}
+	}
+}
+
+func TestResponseClass(t *testing.T) {
+	for _, test := range []struct {
+		record   accessRecord
+		status   int
+		expected string
+	}{
+		{accessRecord{SkinCache: "hit", Cache: "hit"}, 200, "cache-hit"},
+		{accessRecord{SkinCache: "hit", Cache: "miss"}, 200, "cache-hit"},
+		{accessRecord{SkinCache: "miss", Cache: "miss"}, 200, "cache-miss"},
+		{accessRecord{SkinCache: "miss"}, 500, "error"},
+		{accessRecord{}, 200, "none"},
+	} {
+		if class := responseClass(&test.record, test.status); class != test.expected {
+			t.Fatalf("Expected %q for %+v, got %q", test.expected, test.record, class)
+		}
+	}
+}
+
+func TestObserveRouteStatusClass(t *testing.T) {
+	stats = MakeStatsCollector()
+	record := &accessRecord{Route: "/avatar/{username:" + playerRegex + "}"}
+	observeRoute(record, 200, time.Millisecond)
+	observeRoute(record, 204, time.Millisecond)
+	observeRoute(record, 404, time.Millisecond)
+
+	responses := stats.snapshot().Responses["avatar"]
+	if responses["2xx"] != 2 || responses["4xx"] != 1 {
+		t.Fatalf("Expected 2 2xx and 1 4xx, got %v", responses)
+	}
+	if count := testutil.ToFloat64(responseCounter.WithLabelValues("avatar", "2xx")); count < 2 {
+		t.Fatalf("Expected at least 2 2xx in Prometheus, got %v", count)
+	}
+}
+
+func TestRenderTimings(t *testing.T) {
+	steve, _ := minecraft.FetchSkinForSteve()
+	skin := &mcSkin{Render: mcskin.Render{Skin: steve, Mode: "Normal"}}
+	if err := skin.GetArmorBody(256); err != nil {
+		t.Fatal(err)
+	}
+	if skin.Timings.Extract <= 0 || skin.Timings.Scale <= 0 {
+		t.Fatalf("Expected cutting out and resizing to be timed, got %+v", skin.Timings)
+	}
+
+	observeRender("Body", ".svg", skin.Timings)
+	for _, stage := range []string{"extract", "composite", "scale", "encode"} {
+		metric := &dto.Metric{}
+		renderStageDuration.WithLabelValues("Body", "svg", stage).(prometheus.Histogram).Write(metric)
+		if metric.GetHistogram().GetSampleCount() == 0 {
+			t.Fatalf("Expected the %s stage to be observed", stage)
+		}
+	}
+}
+
+func TestLabelLimit(t *testing.T) {
+	limit := makeLabelLimit("test", "Avatar")
+	collapsed := testutil.ToFloat64(collapsedLabelCounter.WithLabelValues("test"))
+
+	for i := 0; i < maxLabelValues-1; i++ {
+		if value := limit.value(fmt.Sprintf("host%d", i)); value != fmt.Sprintf("host%d", i) {
+			t.Fatalf("Expected host%d to be kept, got %q", i, value)
+		}
+	}
+	if value := limit.value("one-too-many"); value != otherLabel {
+		t.Fatalf("Expected %q once the limit's reached, got %q", otherLabel, value)
+	}
+	if value := limit.value("Avatar"); value != "Avatar" {
+		t.Fatalf("Expected allowed values to be kept, got %q", value)
+	}
+	if value := limit.value("host0"); value != "host0" {
+		t.Fatalf("Expected values already seen to be kept, got %q", value)
+	}
+	if got := testutil.ToFloat64(collapsedLabelCounter.WithLabelValues("test")) - collapsed; got != 1 {
+		t.Fatalf("Expected one collapsed label to be counted, got %v", got)
+	}
+}
+
+func TestNormalizeLabel(t *testing.T) {
+	for value, expected := range map[string]string{
+		"textures.minecraft.net:443": "textures.minecraft.net:443",
+		"Armor/Bust":                 "Armor/Bust",
+		"bad\nvalue{}":               "bad_value__",
+		strings.Repeat("a", 100):     strings.Repeat("a", maxLabelLength),
+	} {
+		if normalized := normalizeLabel(value); normalized != expected {
+			t.Fatalf("Expected %q for %q, got %q", expected, value, normalized)
+		}
+	}
+}
+
+func TestSkinFormat(t *testing.T) {
+	for _, c := range []struct {
+		width, height int
+		expected      string
+	}{
+		{64, 32, "legacy"},
+		{64, 64, "modern"},
+		{128, 128, "hd"},
+		{32, 32, "unknown"},
+	} {
+		skin := minecraft.Skin{Texture: minecraft.Texture{Image: image.NewNRGBA(image.Rect(0, 0, c.width, c.height))}}
+		if format := skinFormat(skin); format != c.expected {
+			t.Fatalf("Expected a %dx%d skin to be %s, got %s", c.width, c.height, c.expected, format)
+		}
+	}
+}
+
+func TestBuildInfo(t *testing.T) {
+	if got := testutil.ToFloat64(buildInfoGauge); got != 1 {
+		t.Fatalf("Expected build info to be set, got %v", got)
+	}
+}
+
+func TestConfigHash(t *testing.T) {
+	a, b := &Configuration{}, &Configuration{}
+	for _, c := range []*Configuration{a, b} {
+		if err := gcfg.ReadStringInto(c, configDefaults); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.hash() != b.hash() {
+		t.Fatal("Expected the same config to hash the same")
+	}
+	if a.hash() > 1<<53 {
+		t.Fatalf("Expected the hash to fit in a float64, got %d", a.hash())
+	}
+
+	b.Server.CacheMaxMem++
+	if a.hash() == b.hash() {
+		t.Fatal("Expected a changed option to change the hash")
+	}
+}
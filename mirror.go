@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minotar/imgd/pkg/mcclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Failures in a row before we stop asking a mirror for a while.
+	mirrorMaxFailures = 3
+	// How long an unhealthy mirror is left alone for.
+	mirrorCooldown = 30 * time.Second
+)
+
+// Third party mirrors of Mojang's API we can ask for profiles when Mojang
+// themselves are erroring or rate limiting us. Each kind of mirror parses
+// its own response format into a Profile.
+var mirrorParsers = map[string]func(body []byte) (Profile, error){
+	"ashcon":   parseAshconProfile,
+	"playerdb": parsePlayerDBProfile,
+}
+
+// A mirror we fall back to, and how healthy it's been of late.
+type Mirror struct {
+	// The host, to label its metrics with.
+	Name string
+	Kind string
+	// The player's username or UUID is appended to this.
+	URL string
+
+	mu        sync.Mutex
+	failures  uint
+	downUntil time.Time
+	health    UpstreamHealth
+}
+
+// Mirrors are given as "kind:url", eg.
+// "ashcon:https://api.ashcon.app/mojang/v2/user/".
+func MakeMirrors(specs []string) ([]*Mirror, error) {
+	mirrors := []*Mirror{}
+	for _, spec := range specs {
+		if spec == "" {
+			continue
+		}
+		parts := strings.SplitN(spec, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("mirror \"%s\" should be kind:url", spec)
+		}
+		kind := strings.ToLower(parts[0])
+		if _, exists := mirrorParsers[kind]; !exists {
+			return nil, fmt.Errorf("unknown mirror kind \"%s\"", kind)
+		}
+		parsed, err := url.Parse(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		mirrors = append(mirrors, &Mirror{Name: parsed.Host, Kind: kind, URL: parts[1]})
+	}
+	return mirrors, nil
+}
+
+// Whether we're still willing to ask the mirror.
+func (m *Mirror) healthy() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return time.Now().After(m.downUntil)
+}
+
+// Tracks the mirror's health from the outcome of a lookup which took
+// latency. A player not being found is the mirror working fine.
+func (m *Mirror) record(latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := "ok"
+	if err != nil && !strings.HasSuffix(err.Error(), "user not found") {
+		m.health.record(latency, err)
+		result = "error"
+		m.failures++
+		if m.failures >= mirrorMaxFailures {
+			m.downUntil = time.Now().Add(mirrorCooldown)
+			m.failures = 0
+			log.Warningf("Mirror %s unhealthy, skipping it for %s", m.Name, mirrorCooldown)
+		}
+	} else {
+		m.health.record(latency, nil)
+		m.failures = 0
+	}
+	upstreamCounter.WithLabelValues(m.Name, result).Inc()
+}
+
+// Looks up the player, by username or UUID.
+func (m *Mirror) lookup(ctx context.Context, player string) (Profile, error) {
+	stats.APIRequested("Mirror")
+	mirrorTimer := prometheus.NewTimer(getDuration.WithLabelValues("Mirror"))
+	defer mirrorTimer.ObserveDuration()
+
+	start := time.Now()
+	profile, err := m.fetch(ctx, player)
+	m.record(time.Since(start), err)
+	return profile, err
+}
+
+// Requests the player from the mirror, returning its response.
+func (m *Mirror) get(ctx context.Context, call string, player string) (json.RawMessage, error) {
+	resp, err := upstreamGet(ctx, call, m.URL+player)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body := json.RawMessage{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("unable to %s: %v", call, err)
+	}
+	return body, nil
+}
+
+func (m *Mirror) fetch(ctx context.Context, player string) (Profile, error) {
+	body, err := m.get(ctx, "GetMirrorProfile", player)
+	if err != nil {
+		return Profile{}, err
+	}
+	profile, err := mirrorParsers[m.Kind](body)
+	if err != nil {
+		return Profile{}, err
+	}
+	profile.UUID = mcclient.NormalizeUUID(profile.UUID)
+	return profile, nil
+}
+
+// Asks each healthy mirror for the player in turn, returning the first
+// profile we get.
+func lookupMirrors(ctx context.Context, player string) (Profile, error) {
+	err := fmt.Errorf("unable to GetMirrorProfile: no healthy mirrors")
+	for _, mirror := range mirrors {
+		if !mirror.healthy() {
+			continue
+		}
+		var profile Profile
+		if profile, err = mirror.lookup(ctx, player); err == nil {
+			return profile, nil
+		}
+		log.Debugf("Mirror %s failed for %s (%s)", mirror.Name, player, err.Error())
+	}
+	return Profile{}, err
+}
+
+// Whether each mirror is healthy, by name.
+func mirrorHealth() map[string]bool {
+	health := map[string]bool{}
+	for _, mirror := range mirrors {
+		health[mirror.Name] = mirror.healthy()
+	}
+	return health
+}
+
+// Parses a response from an Ashcon style API, which tells us whether the
+// skin is slim outright.
+func parseAshconProfile(body []byte) (Profile, error) {
+	response := struct {
+		UUID     string `json:"uuid"`
+		Username string `json:"username"`
+		Textures struct {
+			Slim bool `json:"slim"`
+			Skin struct {
+				URL string `json:"url"`
+			} `json:"skin"`
+			Cape struct {
+				URL string `json:"url"`
+			} `json:"cape"`
+		} `json:"textures"`
+	}{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return Profile{}, fmt.Errorf("unable to GetMirrorProfile: %v", err)
+	}
+	if response.UUID == "" {
+		return Profile{}, fmt.Errorf("unable to GetMirrorProfile: user not found")
+	}
+	// Ashcon doesn't pass on the signed textures property, so there's
+	// nothing to verify.
+	if signatureKey != nil {
+		return Profile{}, fmt.Errorf("unable to VerifyTextureProperty: unsigned")
+	}
+	return Profile{
+		UUID:    response.UUID,
+		Name:    response.Username,
+		SkinURL: response.Textures.Skin.URL,
+		CapeURL: response.Textures.Cape.URL,
+		Slim:    response.Textures.Slim,
+	}, nil
+}
+
+// Parses a response from a PlayerDB style API, which passes on Mojang's
+// textures property as is.
+func parsePlayerDBProfile(body []byte) (Profile, error) {
+	response := struct {
+		Success bool `json:"success"`
+		Data    struct {
+			Player struct {
+				ID         string               `json:"id"`
+				Username   string               `json:"username"`
+				Properties []sessionProfileProp `json:"properties"`
+			} `json:"player"`
+		} `json:"data"`
+	}{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return Profile{}, fmt.Errorf("unable to GetMirrorProfile: %v", err)
+	}
+	if !response.Success || response.Data.Player.ID == "" {
+		return Profile{}, fmt.Errorf("unable to GetMirrorProfile: user not found")
+	}
+
+	profile := Profile{UUID: response.Data.Player.ID, Name: response.Data.Player.Username}
+	if err := applyProperties(&profile, response.Data.Player.Properties); err != nil {
+		return Profile{}, err
+	}
+	return profile, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/minotar/minecraft"
+)
+
+func TestMakeMirrors(t *testing.T) {
+	mirrors, err := MakeMirrors([]string{"ashcon:https://api.ashcon.app/mojang/v2/user/", ""})
+	if err != nil || len(mirrors) != 1 || mirrors[0].Name != "api.ashcon.app" || mirrors[0].Kind != "ashcon" {
+		t.Fatalf("Expected an ashcon mirror, got %v (%v)", mirrors, err)
+	}
+	if _, err := MakeMirrors([]string{"crafatar:https://crafatar.com/"}); err == nil {
+		t.Fatal("Expected an unknown kind of mirror to be refused")
+	}
+}
+
+func TestMirrorFallback(t *testing.T) {
+	stats = MakeStatsCollector()
+	upstream = MakeUpstream(0, 0)
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	config.Ttl.UUID = 60
+
+	textures := base64.StdEncoding.EncodeToString([]byte(`{"textures":{"SKIN":{"url":"http://textures/abc","metadata":{"model":"slim"}}}}`))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ashcon/clone1018":
+			fmt.Fprint(w, `{"uuid":"d9135e08-2f22-44c8-9cb1-0d21ed3ac8fd","username":"clone1018","textures":{"slim":false}}`)
+		case "/playerdb/d9135e082f2244c89cb10d21ed3ac8fd":
+			fmt.Fprintf(w, `{"success":true,"data":{"player":{"id":"d9135e08-2f22-44c8-9cb1-0d21ed3ac8fd","username":"clone1018","properties":[{"name":"textures","value":"%s"}]}}}`, textures)
+		case "/ashcon/d9135e082f2244c89cb10d21ed3ac8fd", "/broken/clone1018":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	mcClient = &minecraft.Minecraft{Client: server.Client()}
+
+	var err error
+	mirrors, err = MakeMirrors([]string{
+		"ashcon:" + server.URL + "/broken/",
+		"ashcon:" + server.URL + "/ashcon/",
+		"playerdb:" + server.URL + "/playerdb/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { mirrors = nil }()
+
+	if uuid, reason := resolveUUIDFromMirrors(context.Background(), "clone1018"); reason != NegativeNone || uuid != "d9135e082f2244c89cb10d21ed3ac8fd" {
+		t.Fatalf("Expected the second mirror to resolve the UUID, got %s (%s)", uuid, reason)
+	}
+	if cached, _ := uuidCache.get("clone1018"); cached != "d9135e082f2244c89cb10d21ed3ac8fd" {
+		t.Fatal("Expected the mirror's UUID to be cached")
+	}
+
+	profile, err := lookupMirrors(context.Background(), "d9135e082f2244c89cb10d21ed3ac8fd")
+	if err != nil || profile.SkinURL != "http://textures/abc" || !profile.Slim {
+		t.Fatalf("Expected the playerdb mirror's profile, got %+v (%v)", profile, err)
+	}
+
+	// Fail the first mirror enough and we stop asking it.
+	for i := 0; i < mirrorMaxFailures; i++ {
+		mirrors[0].lookup(context.Background(), "clone1018")
+	}
+	if mirrors[0].healthy() || !mirrors[1].healthy() {
+		t.Fatal("Expected only the broken mirror to be unhealthy")
+	}
+	mirrors[0].downUntil = time.Time{}
+}
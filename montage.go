@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/imgd/pkg/mcskin"
+)
+
+const (
+	// Most players which may be put in one montage.
+	MaxMontagePlayers = 100
+	// Most pixels between tiles.
+	MaxMontagePadding = 32
+	// Most pixels a montage may be across or down.
+	MaxMontageSide = 4096
+
+	defaultMontageCols = 8
+	defaultMontageSize = "32"
+)
+
+// The layout of a montage, from its query string.
+type montageLayout struct {
+	resource string
+	width    uint
+	cols     int
+	padding  int
+}
+
+// Reads the montage's layout from the query string: ?type=avatar or helm,
+// the default, ?size= of each tile, ?cols= and ?padding=.
+func (router *Router) montageLayout(r *http.Request, count int) (montageLayout, error) {
+	query := r.URL.Query()
+	layout := montageLayout{resource: "Helm", cols: defaultMontageCols}
+	if name := query.Get("type"); name != "" {
+		if !strings.EqualFold(name, "avatar") && !strings.EqualFold(name, "helm") {
+			return layout, fmt.Errorf("montages are of avatar or helm renders")
+		}
+		layout.resource, _ = batchResource(name)
+	}
+
+	size := query.Get("size")
+	if size == "" {
+		size = defaultMontageSize
+	}
+	var allowed bool
+	if layout.width, allowed = router.routeWidth(layout.resource, size); !allowed {
+		return layout, fmt.Errorf("size not allowed")
+	}
+
+	var err error
+	if query.Has("cols") {
+		if layout.cols, err = strconv.Atoi(query.Get("cols")); err != nil || layout.cols < 1 {
+			return layout, fmt.Errorf("cols must be a positive number")
+		}
+	}
+	if query.Has("padding") {
+		if layout.padding, err = strconv.Atoi(query.Get("padding")); err != nil || layout.padding < 0 || layout.padding > MaxMontagePadding {
+			return layout, fmt.Errorf("padding must be from 0 to %d", MaxMontagePadding)
+		}
+	}
+
+	bounds := mcskin.MontageBounds(count, layout.cols, int(layout.width), layout.padding)
+	if bounds.Dx() > MaxMontageSide || bounds.Dy() > MaxMontageSide {
+		return layout, fmt.Errorf("montage would be larger than %dx%d", MaxMontageSide, MaxMontageSide)
+	}
+	return layout, nil
+}
+
+// MontagePage tiles the avatars of a comma separated list of players into
+// one grid, so "online players" widgets needn't make a request for each.
+// Players appear in the order given, and any we can't fetch as Steve.
+func (router *Router) MontagePage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	ext := vars["extension"]
+	if ext == "" {
+		ext = ".png"
+	}
+	users := strings.Split(vars["users"], ",")
+	if len(users) > MaxMontagePlayers {
+		writeError(w, r, http.StatusBadRequest, ErrBadRequest, "at most %d players may be in a montage", MaxMontagePlayers)
+		return
+	}
+	for _, user := range users {
+		if !profilesPlayerRegex.MatchString(user) {
+			writeError(w, r, http.StatusBadRequest, ErrBadRequest, "invalid user %q", user)
+			return
+		}
+	}
+	layout, err := router.montageLayout(r, len(users))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrBadRequest, "%v", err)
+		return
+	}
+	if !themeKnown(r) {
+		writeError(w, r, http.StatusBadRequest, ErrBadRequest, "unknown theme")
+		return
+	}
+	background := themes[r.URL.Query().Get("theme")]
+	stats.Requested("Montage")
+	if !chargeRateLimit(w, r, len(users)) {
+		return
+	}
+	record := accessRecordFor(r)
+
+	skins := fetchSkinsConcurrently(users, func(user string) *mcSkin {
+		return fetchSkinForRequest(r, user, true)
+	})
+	if r.Context().Err() == context.Canceled {
+		return
+	}
+
+	key := new(strings.Builder)
+	fmt.Fprintf(key, "v%d|Montage|%+v|%s|%v", mcskin.Version, layout, ext, background)
+	for _, user := range users {
+		key.WriteString("|" + textureKey(skins[strings.ToLower(user)].Skin))
+	}
+	sum := md5.Sum([]byte(key.String()))
+	etag := quoteETag("montage-" + hex.EncodeToString(sum[:8]))
+	if writeNotModified(w, r, etag, CacheClassRender) {
+		return
+	}
+	if data, ok := renderCache.get(key.String()); ok {
+		stats.HitRenderCache()
+		record.Cache = "hit"
+		router.writeType(ext, etag, data, w, r)
+		return
+	}
+	if renderCache.enabled() {
+		stats.MissRenderCache()
+		record.Cache = "miss"
+	}
+	data, err := router.renderMontage(r.Context(), layout, ext, background, users, skins)
+	if err == errRenderQueueFull || err == context.DeadlineExceeded {
+		writeRenderQueueFull(w, r)
+		return
+	} else if err == context.Canceled {
+		return
+	} else if err != nil {
+		log.Errorf("Failed montage of %d players (%s)", len(users), err.Error())
+		stats.Errored(ErrRenderFailed)
+		writeInternalError(w, r, ErrRenderFailed)
+		return
+	}
+	renderCache.add(key.String(), data)
+	router.writeType(ext, etag, data, w, r)
+}
+
+// Draws the players' renders into the montage and encodes it, taking one
+// turn on the render pool for the lot.
+func (router *Router) renderMontage(ctx context.Context, layout montageLayout, ext string, background mcskin.Background, users []string, skins map[string]*mcSkin) ([]byte, error) {
+	var data []byte
+	var err error
+	draw := func() {
+		montage := mcskin.NewMontage(len(users), layout.cols, int(layout.width), layout.padding)
+		for i, user := range users {
+			// Each render draws on its own copy, as it leaves its result
+			// there.
+			skin := *skins[strings.ToLower(user)]
+			skin.Mode = "Normal"
+			skin.Options = mcskin.Options{}
+			if err = router.ResolveMethod(&skin, layout.resource)(int(layout.width)); err != nil {
+				return
+			}
+			montage.Draw(i, skin.Processed)
+			skin.Release()
+		}
+
+		out := &mcSkin{}
+		out.Processed = montage.Processed
+		out.Options.Background = background
+		out.PostProcess()
+		data, err = router.encodeType(ext, out)
+	}
+	if renderPool == nil {
+		draw()
+		return data, err
+	}
+	if poolErr := renderPool.do(ctx, draw); poolErr != nil {
+		return nil, poolErr
+	}
+	return data, err
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/minecraft"
+)
+
+func TestMontage(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	renderCache = MakeRenderCache(0, false)
+	hotCache = MakeHotCache(nil, 0)
+	missingFilter = MakeMissingFilter(0, time.Minute)
+	defer func(stale int) { config.Ttl.Stale = stale }(config.Ttl.Stale)
+	config.Ttl.Stale = 0
+
+	// Opaque all over, so every head drawn shows.
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.NRGBA{0x80, 0x40, 0x20, 0xFF}), image.Point{}, draw.Src)
+	skin := minecraft.Skin{}
+	skin.Image = img
+	skin.Hash = "clone1018"
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+	cache.add("069a79f444e94726a5befca90e38aaf5", skin, time.Minute)
+	cache.add("853c80ef3c3749fdaa49938b674adae6", skin, time.Minute)
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.Mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := serve("/montage/d9135e082f2244c89cb10d21ed3ac8fd,069a79f444e94726a5befca90e38aaf5,853c80ef3c3749fdaa49938b674adae6.png?cols=2&size=16&padding=2")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the montage, got %d %q", w.Code, w.Body.String())
+	}
+	head := httptest.NewRecorder()
+	router.Mux.ServeHTTP(head, httptest.NewRequest("HEAD", "/montage/d9135e082f2244c89cb10d21ed3ac8fd,069a79f444e94726a5befca90e38aaf5,853c80ef3c3749fdaa49938b674adae6.png?cols=2&size=16&padding=2", nil))
+	if head.Header().Get("Content-Length") != strconv.Itoa(w.Body.Len()) || head.Body.Len() != 0 {
+		t.Fatalf("Expected HEAD to give the montage's length, got %v", head.Header())
+	}
+	montage, err := png.Decode(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two columns and two rows of 16px tiles, with 2px around each.
+	if bounds := montage.Bounds(); bounds.Dx() != 2*16+3*2 || bounds.Dy() != 2*16+3*2 {
+		t.Fatalf("Expected a 38x38 montage, got %v", bounds)
+	}
+	if c := color.NRGBAModel.Convert(montage.At(0, 0)).(color.NRGBA); c.A != 0 {
+		t.Fatalf("Expected the padding to be transparent, got %v", c)
+	}
+	for _, tile := range [][2]int{{2, 2}, {20, 2}, {2, 20}} {
+		if c := color.NRGBAModel.Convert(montage.At(tile[0]+8, tile[1]+8)).(color.NRGBA); c.A != 0xFF {
+			t.Fatalf("Expected a head at %v, got %v", tile, c)
+		}
+	}
+	// Three players leave the last place empty.
+	if c := color.NRGBAModel.Convert(montage.At(28, 28)).(color.NRGBA); c.A != 0 {
+		t.Fatalf("Expected the last place to be empty, got %v", c)
+	}
+
+	for _, path := range []string{
+		"/montage/clone1018,not-a-player!",
+		"/montage/clone1018?cols=0",
+		"/montage/clone1018?padding=100",
+		"/montage/clone1018?type=body",
+		"/montage/" + strings.Repeat("clone1018,", MaxMontagePlayers) + "clone1018",
+	} {
+		if w := serve(path); w.Code != http.StatusBadRequest && w.Code != http.StatusNotFound {
+			t.Errorf("Expected %s to be refused, got %d", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/minotar/imgd/pkg/mcskin"
+	"github.com/minotar/minecraft"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Somewhere other than Mojang that players' skins can come from, eg. an
+// offline mode server's own skin database.
+type SkinStore interface {
+	// Returns the skin for the UUID, and whether the store has one.
+	fetch(uuid string) (minecraft.Skin, bool, error)
+}
+
+// A SkinStore which skins can be uploaded to, so we can be the skin source
+// ourselves.
+type WritableSkinStore interface {
+	SkinStore
+	// Keeps the PNG as the UUID's skin, replacing any it already had.
+	store(uuid string, data []byte) error
+}
+
+// Factories for the SkinStores which can be named in the config. Others can
+// be compiled in by calling RegisterSkinStore from an init() in their own
+// file.
+var skinStoreFactories = map[string]func() (SkinStore, error){}
+
+// Makes a SkinStore available to the [offline] config section.
+func RegisterSkinStore(name string, factory func() (SkinStore, error)) {
+	skinStoreFactories[strings.ToLower(name)] = factory
+}
+
+func init() {
+	RegisterSkinStore("disk", func() (SkinStore, error) {
+		return &DiskSkinStore{Path: config.Offline.Path}, nil
+	})
+	RegisterSkinStore("http", func() (SkinStore, error) {
+		if config.Offline.URL == "" {
+			return nil, fmt.Errorf("http skin store requires url")
+		}
+		return &HTTPSkinStore{URL: config.Offline.URL}, nil
+	})
+	RegisterSkinStore("s3", func() (SkinStore, error) {
+		return MakeS3SkinStore(config.Offline.Path)
+	})
+	RegisterSkinStore("mysql", func() (SkinStore, error) {
+		return MakeMySQLSkinStore(config.Offline.DSN, config.Offline.Query)
+	})
+}
+
+// Builds the SkinStore named in the config, or nil if none is.
+func MakeSkinStore(name string) (SkinStore, error) {
+	if name == "" {
+		return nil, nil
+	}
+	factory, exists := skinStoreFactories[strings.ToLower(name)]
+	if !exists {
+		return nil, fmt.Errorf("unknown skin store \"%s\"", name)
+	}
+	return factory()
+}
+
+// Returns the UUID an offline mode server gives the username, as the
+// server does: a version 3 UUID of "OfflinePlayer:" and their name.
+func offlineUUID(username string) string {
+	sum := md5.Sum([]byte("OfflinePlayer:" + username))
+	sum[6] = sum[6]&0x0f | 0x30
+	sum[8] = sum[8]&0x3f | 0x80
+	return hex.EncodeToString(sum[:])
+}
+
+// Whether the UUID is one an offline mode server made up, rather than one
+// Mojang gave out.
+func isOfflineUUID(uuid string) bool {
+	return len(uuid) == 32 && uuid[12] == '3'
+}
+
+// Whether the request's username is for an offline mode player.
+func offlineRequested(r *http.Request) bool {
+	return config.Offline.Enabled || r.URL.Query().Get("offline") == "1"
+}
+
+// Fetches the offline mode player's skin from the cache or the SkinStore.
+// Mojang knows nothing of them, so without a store they get the default
+// skin for their UUID. Players the store hasn't got are negatively cached,
+// so we don't ask it again on every request.
+func fetchOfflineSkin(ctx context.Context, uuid string) *mcSkin {
+	if skin := pullCachedSkin(uuid); skin != nil {
+		return skin
+	}
+	if reason := cache.pullNegative(uuid); reason != NegativeNone {
+		log.Debugf("Negative cache hit: %s (%s)", uuid, reason)
+		stats.HitCache()
+		skin := fallbackFor(uuid)
+		skin.Cached = true
+		return skin
+	}
+	stats.MissCache()
+
+	skin, reason := fetchStoredSkin(uuid)
+	if reason != NegativeNone {
+		if skinStore != nil {
+			ttl := config.failedTtl()
+			if reason == NegativeAPIError {
+				ttl = config.errorTtl()
+			}
+			addTimer := prometheus.NewTimer(cacheDuration.WithLabelValues("add"))
+			cache.addNegative(uuid, reason, ttl)
+			addTimer.ObserveDuration()
+		}
+		return fallbackFor(uuid)
+	}
+	return skin
+}
+
+// Fetches the player's skin from the SkinStore, caching it if it has one.
+// Otherwise returns whether the store hasn't got it, or failed us.
+func fetchStoredSkin(uuid string) (*mcSkin, NegativeReason) {
+	if skinStore == nil {
+		return nil, NegativeNotFound
+	}
+
+	storeTimer := prometheus.NewTimer(getDuration.WithLabelValues("SkinStore"))
+	skin, found, err := skinStore.fetch(uuid)
+	storeTimer.ObserveDuration()
+	if err != nil {
+		log.Infof("Failed skin store lookup: %s (%s)", uuid, err.Error())
+		stats.Errored(ErrSkinStore)
+		return nil, NegativeAPIError
+	}
+	if !found {
+		return nil, NegativeNotFound
+	}
+
+	addTimer := prometheus.NewTimer(cacheDuration.WithLabelValues("add"))
+	cache.add(uuid, skin, skinCacheTtl())
+	addTimer.ObserveDuration()
+	return &mcSkin{Render: mcskin.Render{Skin: skin, Slim: isSlimSkin(skin)}}, NegativeNone
+}
+
+// Reads skins from a directory of <uuid>.png files.
+type DiskSkinStore struct {
+	Path string
+}
+
+func (s *DiskSkinStore) fetch(uuid string) (minecraft.Skin, bool, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.Path, uuid+".png"))
+	if os.IsNotExist(err) {
+		return minecraft.Skin{}, false, nil
+	} else if err != nil {
+		return minecraft.Skin{}, false, err
+	}
+	return decodeStoredSkin(data)
+}
+
+func (s *DiskSkinStore) store(uuid string, data []byte) error {
+	return writeFileAtomic(filepath.Join(s.Path, uuid+".png"), data)
+}
+
+// Decodes a skin from a store, hashing it so it can be served with an ETag.
+func decodeStoredSkin(data []byte) (minecraft.Skin, bool, error) {
+	skin := minecraft.Skin{}
+	if err := skin.Decode(bytes.NewReader(data)); err != nil {
+		return minecraft.Skin{}, false, err
+	}
+	sum := md5.Sum(data)
+	skin.Hash = hex.EncodeToString(sum[:])
+	skin.Source = "SkinStore"
+	return skin, true, nil
+}
+
+// Fetches skins from a web server, which returns the PNG for a URL ending
+// in the UUID or a 404.
+type HTTPSkinStore struct {
+	URL string
+}
+
+func (s *HTTPSkinStore) fetch(uuid string) (minecraft.Skin, bool, error) {
+	stats.APIRequested("SkinStore")
+	resp, err := mcClient.Client.Get(s.URL + uuid)
+	if err != nil {
+		return minecraft.Skin{}, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusNoContent:
+		return minecraft.Skin{}, false, nil
+	default:
+		return minecraft.Skin{}, false, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return minecraft.Skin{}, false, err
+	}
+	return decodeStoredSkin(data)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minotar/minecraft"
+)
+
+// Keeps skins as <prefix><uuid>.png objects in the [s3] bucket, so a fleet
+// of instances can share the skins uploaded to any of them.
+type S3SkinStore struct {
+	Client *minio.Client
+	Bucket string
+	Prefix string
+}
+
+// Makes a store for the objects under the path in the [s3] bucket.
+func MakeS3SkinStore(path string) (*S3SkinStore, error) {
+	client, err := minio.New(config.S3.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.S3.AccessKey, config.S3.SecretKey, ""),
+		Secure: !config.S3.Insecure,
+		Region: config.S3.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.Trim(path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &S3SkinStore{Client: client, Bucket: config.S3.Bucket, Prefix: prefix}, nil
+}
+
+func (s *S3SkinStore) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), msDuration(config.S3.Timeout))
+}
+
+func (s *S3SkinStore) fetch(uuid string) (minecraft.Skin, bool, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+
+	obj, err := s.Client.GetObject(ctx, s.Bucket, s.Prefix+uuid+".png", minio.GetObjectOptions{})
+	if err != nil {
+		return minecraft.Skin{}, false, err
+	}
+	defer obj.Close()
+	data, err := ioutil.ReadAll(obj)
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return minecraft.Skin{}, false, nil
+	} else if err != nil {
+		return minecraft.Skin{}, false, err
+	}
+	return decodeStoredSkin(data)
+}
+
+func (s *S3SkinStore) store(uuid string, data []byte) error {
+	ctx, cancel := s.context()
+	defer cancel()
+
+	_, err := s.Client.PutObject(ctx, s.Bucket, s.Prefix+uuid+".png", bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: "image/png"})
+	return err
+}
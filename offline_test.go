@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestOfflineUUID(t *testing.T) {
+	uuid := offlineUUID("Notch")
+	if uuid != "b50ad385829d3141a2167e7d7539ba7f" {
+		t.Fatalf("Expected Notch's offline UUID, got %s", uuid)
+	}
+	if !isOfflineUUID(uuid) || isOfflineUUID("069a79f444e94726a5befca90e38aaf5") {
+		t.Fatal("Expected only the offline UUID to be recognised")
+	}
+}
+
+func TestOfflineSkinStore(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	renderCache = MakeRenderCache(0, false)
+	hotCache = MakeHotCache(nil, 0)
+	missingFilter = MakeMissingFilter(0, time.Minute)
+	config.Ttl.Skin = 60
+
+	dir, err := ioutil.TempDir("", "imgd-skins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file, _ := os.Create(filepath.Join(dir, offlineUUID("clone1018")+".png"))
+	png.Encode(file, image.NewNRGBA(image.Rect(0, 0, 64, 64)))
+	file.Close()
+
+	skinStore = &DiskSkinStore{Path: dir}
+	defer func() { skinStore = nil }()
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+
+	w := httptest.NewRecorder()
+	router.Mux.ServeHTTP(w, httptest.NewRequest("GET", "/skin/clone1018?offline=1", nil))
+	if w.Code != http.StatusOK || !cache.has(offlineUUID("clone1018")) {
+		t.Fatalf("Expected the stored skin to be served and cached, got %d", w.Code)
+	}
+
+	if skin := fetchOfflineSkin(context.Background(), offlineUUID("lukegb")); !skin.Fallback {
+		t.Fatal("Expected a player missing from the store to get Steve")
+	}
+	if cache.pullNegative(offlineUUID("lukegb")) != NegativeNotFound {
+		t.Fatal("Expected a player missing from the store to be negatively cached")
+	}
+	if skin := fetchOfflineSkin(context.Background(), offlineUUID("lukegb")); !skin.Fallback || !skin.Cached {
+		t.Fatal("Expected the negative cache to answer for them")
+	}
+}
+
+func TestMySQLSkinStore(t *testing.T) {
+	if _, err := MakeMySQLSkinStore("", ""); err == nil {
+		t.Fatal("Expected the store to need a DSN")
+	}
+	store, err := MakeMySQLSkinStore("imgd:hunter2@tcp(127.0.0.1:3306)/skinsrestorer", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Query != SkinsRestorerQuery {
+		t.Fatal("Expected SkinsRestorer's tables to be read by default")
+	}
+	if uuid := dashUUID(offlineUUID("Notch")); uuid != "b50ad385-829d-3141-a216-7e7d7539ba7f" {
+		t.Fatalf("Expected the UUID as a server writes it, got %s", uuid)
+	}
+}
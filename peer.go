@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/minotar/minecraft"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Header marking requests made by another imgd instance. Instances only
+// answer these from their cache or upstream, never their own peers, so
+// peers can't bounce a request between each other forever.
+const PeerHeader = "X-Imgd-Peer"
+
+// Asks each of the configured peers for the raw skin in turn, returning the
+// first one we get.
+func fetchSkinFromPeers(ctx context.Context, username string) (minecraft.Skin, error) {
+	client := &http.Client{Timeout: msDuration(config.Peer.Timeout)}
+
+	for _, peer := range config.Peer.URL {
+		skin, err := fetchSkinFromPeer(ctx, client, strings.TrimRight(peer, "/"), username)
+		if err == nil {
+			return skin, nil
+		}
+		log.Debugf("Peer %s failed for %s (%s)", peer, username, err.Error())
+	}
+
+	return minecraft.Skin{}, errors.New("no peer had the skin")
+}
+
+func fetchSkinFromPeer(ctx context.Context, client *http.Client, peer string, username string) (minecraft.Skin, error) {
+	stats.APIRequested("Peer")
+	peerTimer := prometheus.NewTimer(getDuration.WithLabelValues("Peer"))
+	defer peerTimer.ObserveDuration()
+
+	path := "/skin/" + username
+	// Signed, with signed on, as peers share our secret.
+	if signer := hmacSigner(); signer != nil {
+		expires := time.Now().Add(time.Minute).Unix()
+		path += fmt.Sprintf("?expires=%d&sig=%s", expires, signer.Sign(path, expires))
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", peer+path, nil)
+	if err != nil {
+		return minecraft.Skin{}, err
+	}
+	req.Header.Set(PeerHeader, "1")
+	if config.Peer.Key != "" {
+		req.Header.Set("X-API-Key", config.Peer.Key)
+	}
+	req.Header.Set("User-Agent", config.Minecraft.UserAgent)
+	forwardRequestID(ctx, req)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return minecraft.Skin{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return minecraft.Skin{}, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	// Checked as a texture from Mojang would be, as a peer's no more to be
+	// trusted.
+	skin, err := sessionClient().DecodeTexture(resp.Body)
+	if err != nil {
+		return minecraft.Skin{}, err
+	}
+	if err := checkSkinDimensions(skin); err != nil {
+		return minecraft.Skin{}, err
+	}
+	skin.Source = "Peer"
+	return skin, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/minotar/minecraft"
+)
+
+func TestFetchSkinFromPeers(t *testing.T) {
+	stats = MakeStatsCollector()
+	mcClient = &minecraft.Minecraft{Client: http.DefaultClient}
+	savedPeer, savedMinecraft, savedAuth := config.Peer, config.Minecraft, config.Auth
+	defer func() { config.Peer, config.Minecraft, config.Auth = savedPeer, savedMinecraft, savedAuth }()
+	config.Peer.Timeout = 1000
+	config.Peer.Key = "p33r"
+
+	var served []byte
+	var asked *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asked = r
+		w.Write(served)
+	}))
+	defer server.Close()
+	config.Peer.URL = []string{server.URL + "/"}
+
+	served = encodeTestSkin(64, 64)
+	skin, err := fetchSkinFromPeers(context.Background(), "clone1018")
+	if err != nil || skin.Source != "Peer" {
+		t.Fatalf("Expected the peer's skin, got %v", err)
+	}
+	if asked.URL.Path != "/skin/clone1018" || asked.Header.Get(PeerHeader) == "" || asked.Header.Get("X-API-Key") != "p33r" {
+		t.Fatalf("Expected the peer to be asked with our key, got %s %v", asked.URL, asked.Header)
+	}
+
+	// A peer's skin is checked as a texture from Mojang is.
+	config.Minecraft.MaxTextureSize = 64
+	if _, err := fetchSkinFromPeers(context.Background(), "clone1018"); err == nil {
+		t.Fatal("Expected a skin over the size limit to be refused")
+	}
+	config.Minecraft.MaxTextureSize = 0
+	for _, body := range [][]byte{[]byte("not a png"), encodeTestSkin(32, 32)} {
+		served = body
+		if _, err := fetchSkinFromPeers(context.Background(), "clone1018"); err == nil {
+			t.Fatalf("Expected %q to be refused", body[:8])
+		}
+	}
+
+	// With signed on, peers are sent signed URLs.
+	served = encodeTestSkin(64, 64)
+	config.Auth.Signed, config.Auth.HMACSecret = true, "s3cret"
+	if _, err := fetchSkinFromPeers(context.Background(), "clone1018"); err != nil {
+		t.Fatal(err)
+	}
+	query, _ := url.ParseQuery(asked.URL.RawQuery)
+	expires, _ := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if signer := hmacSigner(); query.Get("sig") != signer.Sign("/skin/clone1018", expires) {
+		t.Fatalf("Expected the peer to be sent a signed URL, got %s", asked.URL)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Fetching a player we've nothing cached for is a chain of round trips:
+// their UUID, their profile, then their textures. Stages which don't
+// depend on each other are run side by side, and each is timed, so the
+// cold path costs the longest chain rather than every stage in turn.
+
+// Runs the stage of a fetch, timing it.
+func stage(ctx context.Context, name string, fn func()) {
+	timer := prometheus.NewTimer(fetchStageDuration.WithLabelValues(name))
+	defer func() {
+		debugTraceFrom(ctx).fetchStage(name, timer.ObserveDuration())
+	}()
+	fn()
+}
+
+// Runs the functions side by side, returning once they all have. The last
+// is run on the calling goroutine. A panic in any of the others is logged
+// rather than taking the server down.
+func parallel(ctx context.Context, fns ...func()) {
+	if len(fns) == 0 {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, fn := range fns[:len(fns)-1] {
+		wg.Add(1)
+		go func(fn func()) {
+			defer wg.Done()
+			defer func() {
+				if p := recover(); p != nil {
+					logPanic(ctx, "pipeline", p)
+				}
+			}()
+			fn()
+		}(fn)
+	}
+	fns[len(fns)-1]()
+	wg.Wait()
+}
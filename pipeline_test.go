@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/minotar/minecraft"
+)
+
+func TestParallel(t *testing.T) {
+	stats = MakeStatsCollector()
+
+	// Each waits on the other, so they only finish if run side by side.
+	a, b := make(chan bool), make(chan bool)
+	done := make(chan bool)
+	go func() {
+		parallel(context.Background(), func() {
+			a <- true
+			<-b
+		}, func() {
+			<-a
+			b <- true
+		})
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the functions to run side by side")
+	}
+
+	ran := false
+	parallel(context.Background(), func() {
+		panic("oops")
+	}, func() {
+		ran = true
+	})
+	if !ran {
+		t.Fatal("Expected the others to run despite one panicking")
+	}
+}
+
+func TestFetchSkinWarmsCape(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	profileCache = MakeProfileCache()
+	upstream = MakeUpstream(0, 0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/texture/skin":
+			w.Write(encodeTestSkin(64, 64))
+		case "/texture/cape":
+			w.Write(encodeTestSkin(64, 32))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	mcClient = &minecraft.Minecraft{Client: server.Client()}
+
+	defer func(providers []string, withSkin bool) {
+		config.Cape.Provider = providers
+		config.Cape.WithSkin = withSkin
+	}(config.Cape.Provider, config.Cape.WithSkin)
+	config.Cape.Provider = []string{"mojang"}
+	config.Cape.WithSkin = true
+
+	uuid := "d9135e082f2244c89cb10d21ed3ac8fd"
+	profileCache.add(uuid, Profile{UUID: uuid, Name: "clone1018", SkinURL: server.URL + "/texture/skin", CapeURL: server.URL + "/texture/cape"}, time.Minute)
+
+	skin, _, reason := fetchSkinForUUID(context.Background(), "clone1018", uuid)
+	if reason != NegativeNone || skin.Image.Bounds().Dy() != 64 {
+		t.Fatalf("Expected the skin to be fetched, got %s", reason)
+	}
+	if !cache.has(capeCacheKey(uuid)) {
+		t.Fatal("Expected the cape to be fetched alongside the skin")
+	}
+	if cape := cache.pull(capeCacheKey(uuid)); cape.Image.Bounds().Dy() != 32 {
+		t.Fatal("Expected the cape to be cached as it was")
+	}
+}
@@ -0,0 +1,16 @@
+/*
+Package imgdpb is the gRPC API for imgd's renders, generated from
+imgd.proto. Services rendering skins server to server, eg. game server
+plugins and bots, can use it in place of building URLs:
+
+	conn, err := grpc.NewClient("imgd.internal:9090", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	client := imgdpb.NewRendererClient(conn)
+	render, err := client.Render(ctx, &imgdpb.RenderRequest{
+		Player: &imgdpb.RenderRequest_User{User: "clone1018"},
+		Type:   "armor/bust",
+		Size:   64,
+	})
+*/
+package imgdpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative imgd.proto
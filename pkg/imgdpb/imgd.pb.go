@@ -0,0 +1,459 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: imgd.proto
+
+package imgdpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type RenderRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Whose skin to draw.
+	//
+	// Types that are valid to be assigned to Player:
+	//
+	//	*RenderRequest_User
+	//	*RenderRequest_Uuid
+	//	*RenderRequest_Texture
+	Player isRenderRequest_Player `protobuf_oneof:"player"`
+	// The render, named as in URLs, eg. "avatar" or "armor/bust". Avatar if
+	// blank.
+	Type string `protobuf:"bytes,4,opt,name=type,proto3" json:"type,omitempty"`
+	// Width in pixels, 0 for the default.
+	Size uint32 `protobuf:"varint,5,opt,name=size,proto3" json:"size,omitempty"`
+	// "png", "webp", "gif" or "svg". PNG if blank.
+	Format        string         `protobuf:"bytes,6,opt,name=format,proto3" json:"format,omitempty"`
+	Options       *RenderOptions `protobuf:"bytes,7,opt,name=options,proto3" json:"options,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RenderRequest) Reset() {
+	*x = RenderRequest{}
+	mi := &file_imgd_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RenderRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RenderRequest) ProtoMessage() {}
+
+func (x *RenderRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_imgd_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RenderRequest.ProtoReflect.Descriptor instead.
+func (*RenderRequest) Descriptor() ([]byte, []int) {
+	return file_imgd_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *RenderRequest) GetPlayer() isRenderRequest_Player {
+	if x != nil {
+		return x.Player
+	}
+	return nil
+}
+
+func (x *RenderRequest) GetUser() string {
+	if x != nil {
+		if x, ok := x.Player.(*RenderRequest_User); ok {
+			return x.User
+		}
+	}
+	return ""
+}
+
+func (x *RenderRequest) GetUuid() string {
+	if x != nil {
+		if x, ok := x.Player.(*RenderRequest_Uuid); ok {
+			return x.Uuid
+		}
+	}
+	return ""
+}
+
+func (x *RenderRequest) GetTexture() string {
+	if x != nil {
+		if x, ok := x.Player.(*RenderRequest_Texture); ok {
+			return x.Texture
+		}
+	}
+	return ""
+}
+
+func (x *RenderRequest) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *RenderRequest) GetSize() uint32 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *RenderRequest) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *RenderRequest) GetOptions() *RenderOptions {
+	if x != nil {
+		return x.Options
+	}
+	return nil
+}
+
+type isRenderRequest_Player interface {
+	isRenderRequest_Player()
+}
+
+type RenderRequest_User struct {
+	// A username, or a UUID.
+	User string `protobuf:"bytes,1,opt,name=user,proto3,oneof"`
+}
+
+type RenderRequest_Uuid struct {
+	Uuid string `protobuf:"bytes,2,opt,name=uuid,proto3,oneof"`
+}
+
+type RenderRequest_Texture struct {
+	// The hash of a texture on Mojang's texture server.
+	Texture string `protobuf:"bytes,3,opt,name=texture,proto3,oneof"`
+}
+
+func (*RenderRequest_User) isRenderRequest_Player() {}
+
+func (*RenderRequest_Uuid) isRenderRequest_Player() {}
+
+func (*RenderRequest_Texture) isRenderRequest_Player() {}
+
+// The options the HTTP routes take as query parameters.
+type RenderOptions struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Degrees to swing the arms out by, for body renders.
+	ArmAngle float64 `protobuf:"fixed64,1,opt,name=arm_angle,json=armAngle,proto3" json:"arm_angle,omitempty"`
+	// Draw body renders mid-stride.
+	Walking bool `protobuf:"varint,2,opt,name=walking,proto3" json:"walking,omitempty"`
+	// Draw the ears of skins which have them.
+	Ears bool `protobuf:"varint,3,opt,name=ears,proto3" json:"ears,omitempty"`
+	// Crop renders to what was drawn.
+	Trim bool `protobuf:"varint,4,opt,name=trim,proto3" json:"trim,omitempty"`
+	// Text to label the render with, none if blank.
+	Label         string `protobuf:"bytes,5,opt,name=label,proto3" json:"label,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RenderOptions) Reset() {
+	*x = RenderOptions{}
+	mi := &file_imgd_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RenderOptions) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RenderOptions) ProtoMessage() {}
+
+func (x *RenderOptions) ProtoReflect() protoreflect.Message {
+	mi := &file_imgd_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RenderOptions.ProtoReflect.Descriptor instead.
+func (*RenderOptions) Descriptor() ([]byte, []int) {
+	return file_imgd_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *RenderOptions) GetArmAngle() float64 {
+	if x != nil {
+		return x.ArmAngle
+	}
+	return 0
+}
+
+func (x *RenderOptions) GetWalking() bool {
+	if x != nil {
+		return x.Walking
+	}
+	return false
+}
+
+func (x *RenderOptions) GetEars() bool {
+	if x != nil {
+		return x.Ears
+	}
+	return false
+}
+
+func (x *RenderOptions) GetTrim() bool {
+	if x != nil {
+		return x.Trim
+	}
+	return false
+}
+
+func (x *RenderOptions) GetLabel() string {
+	if x != nil {
+		return x.Label
+	}
+	return ""
+}
+
+type RenderResponse struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Image       []byte                 `protobuf:"bytes,1,opt,name=image,proto3" json:"image,omitempty"`
+	ContentType string                 `protobuf:"bytes,2,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	Etag        string                 `protobuf:"bytes,3,opt,name=etag,proto3" json:"etag,omitempty"`
+	// Whether the player's skin couldn't be had, so the fallback was drawn.
+	Fallback bool `protobuf:"varint,4,opt,name=fallback,proto3" json:"fallback,omitempty"`
+	// The index of the render in the batch this answers.
+	Index         uint32 `protobuf:"varint,5,opt,name=index,proto3" json:"index,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RenderResponse) Reset() {
+	*x = RenderResponse{}
+	mi := &file_imgd_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RenderResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RenderResponse) ProtoMessage() {}
+
+func (x *RenderResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_imgd_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RenderResponse.ProtoReflect.Descriptor instead.
+func (*RenderResponse) Descriptor() ([]byte, []int) {
+	return file_imgd_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *RenderResponse) GetImage() []byte {
+	if x != nil {
+		return x.Image
+	}
+	return nil
+}
+
+func (x *RenderResponse) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+func (x *RenderResponse) GetEtag() string {
+	if x != nil {
+		return x.Etag
+	}
+	return ""
+}
+
+func (x *RenderResponse) GetFallback() bool {
+	if x != nil {
+		return x.Fallback
+	}
+	return false
+}
+
+func (x *RenderResponse) GetIndex() uint32 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+type RenderBatchRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Renders       []*RenderRequest       `protobuf:"bytes,1,rep,name=renders,proto3" json:"renders,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RenderBatchRequest) Reset() {
+	*x = RenderBatchRequest{}
+	mi := &file_imgd_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RenderBatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RenderBatchRequest) ProtoMessage() {}
+
+func (x *RenderBatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_imgd_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RenderBatchRequest.ProtoReflect.Descriptor instead.
+func (*RenderBatchRequest) Descriptor() ([]byte, []int) {
+	return file_imgd_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *RenderBatchRequest) GetRenders() []*RenderRequest {
+	if x != nil {
+		return x.Renders
+	}
+	return nil
+}
+
+var File_imgd_proto protoreflect.FileDescriptor
+
+const file_imgd_proto_rawDesc = "" +
+	"\n" +
+	"\n" +
+	"imgd.proto\x12\aimgd.v1\"\xd3\x01\n" +
+	"\rRenderRequest\x12\x14\n" +
+	"\x04user\x18\x01 \x01(\tH\x00R\x04user\x12\x14\n" +
+	"\x04uuid\x18\x02 \x01(\tH\x00R\x04uuid\x12\x1a\n" +
+	"\atexture\x18\x03 \x01(\tH\x00R\atexture\x12\x12\n" +
+	"\x04type\x18\x04 \x01(\tR\x04type\x12\x12\n" +
+	"\x04size\x18\x05 \x01(\rR\x04size\x12\x16\n" +
+	"\x06format\x18\x06 \x01(\tR\x06format\x120\n" +
+	"\aoptions\x18\a \x01(\v2\x16.imgd.v1.RenderOptionsR\aoptionsB\b\n" +
+	"\x06player\"\x84\x01\n" +
+	"\rRenderOptions\x12\x1b\n" +
+	"\tarm_angle\x18\x01 \x01(\x01R\barmAngle\x12\x18\n" +
+	"\awalking\x18\x02 \x01(\bR\awalking\x12\x12\n" +
+	"\x04ears\x18\x03 \x01(\bR\x04ears\x12\x12\n" +
+	"\x04trim\x18\x04 \x01(\bR\x04trim\x12\x14\n" +
+	"\x05label\x18\x05 \x01(\tR\x05label\"\x8f\x01\n" +
+	"\x0eRenderResponse\x12\x14\n" +
+	"\x05image\x18\x01 \x01(\fR\x05image\x12!\n" +
+	"\fcontent_type\x18\x02 \x01(\tR\vcontentType\x12\x12\n" +
+	"\x04etag\x18\x03 \x01(\tR\x04etag\x12\x1a\n" +
+	"\bfallback\x18\x04 \x01(\bR\bfallback\x12\x14\n" +
+	"\x05index\x18\x05 \x01(\rR\x05index\"F\n" +
+	"\x12RenderBatchRequest\x120\n" +
+	"\arenders\x18\x01 \x03(\v2\x16.imgd.v1.RenderRequestR\arenders2\x8c\x01\n" +
+	"\bRenderer\x129\n" +
+	"\x06Render\x12\x16.imgd.v1.RenderRequest\x1a\x17.imgd.v1.RenderResponse\x12E\n" +
+	"\vRenderBatch\x12\x1b.imgd.v1.RenderBatchRequest\x1a\x17.imgd.v1.RenderResponse0\x01B$Z\"github.com/minotar/imgd/pkg/imgdpbb\x06proto3"
+
+var (
+	file_imgd_proto_rawDescOnce sync.Once
+	file_imgd_proto_rawDescData []byte
+)
+
+func file_imgd_proto_rawDescGZIP() []byte {
+	file_imgd_proto_rawDescOnce.Do(func() {
+		file_imgd_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_imgd_proto_rawDesc), len(file_imgd_proto_rawDesc)))
+	})
+	return file_imgd_proto_rawDescData
+}
+
+var file_imgd_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_imgd_proto_goTypes = []any{
+	(*RenderRequest)(nil),      // 0: imgd.v1.RenderRequest
+	(*RenderOptions)(nil),      // 1: imgd.v1.RenderOptions
+	(*RenderResponse)(nil),     // 2: imgd.v1.RenderResponse
+	(*RenderBatchRequest)(nil), // 3: imgd.v1.RenderBatchRequest
+}
+var file_imgd_proto_depIdxs = []int32{
+	1, // 0: imgd.v1.RenderRequest.options:type_name -> imgd.v1.RenderOptions
+	0, // 1: imgd.v1.RenderBatchRequest.renders:type_name -> imgd.v1.RenderRequest
+	0, // 2: imgd.v1.Renderer.Render:input_type -> imgd.v1.RenderRequest
+	3, // 3: imgd.v1.Renderer.RenderBatch:input_type -> imgd.v1.RenderBatchRequest
+	2, // 4: imgd.v1.Renderer.Render:output_type -> imgd.v1.RenderResponse
+	2, // 5: imgd.v1.Renderer.RenderBatch:output_type -> imgd.v1.RenderResponse
+	4, // [4:6] is the sub-list for method output_type
+	2, // [2:4] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_imgd_proto_init() }
+func file_imgd_proto_init() {
+	if File_imgd_proto != nil {
+		return
+	}
+	file_imgd_proto_msgTypes[0].OneofWrappers = []any{
+		(*RenderRequest_User)(nil),
+		(*RenderRequest_Uuid)(nil),
+		(*RenderRequest_Texture)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_imgd_proto_rawDesc), len(file_imgd_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_imgd_proto_goTypes,
+		DependencyIndexes: file_imgd_proto_depIdxs,
+		MessageInfos:      file_imgd_proto_msgTypes,
+	}.Build()
+	File_imgd_proto = out.File
+	file_imgd_proto_goTypes = nil
+	file_imgd_proto_depIdxs = nil
+}
@@ -0,0 +1,171 @@
+package mcclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/minotar/minecraft"
+)
+
+// Client fetches profiles and textures. Its errors are in the same form as
+// the minecraft package's, eg. "unable to GetSessionProfile: user not
+// found", so they can be handled alike.
+type Client struct {
+	HTTP      *http.Client
+	UserAgent string
+	// Where profiles are fetched from, with the UUID appended.
+	SessionServerURL string
+	// Called with each request before it's sent, eg. to add headers.
+	Prepare func(*http.Request)
+	// Checks the textures property was signed by the auth server. Profiles
+	// are only asked for signed when it's set.
+	Verify func(Property) error
+	// Called with the size in bytes of each texture downloaded.
+	Downloaded func(bytes int64)
+	// Bytes a texture may be, 0 for DefaultMaxTextureSize.
+	MaxTextureSize int64
+}
+
+// Get requests the URL, turning any status other than 200 into an error.
+// The call names what was being done, for the error.
+func (c *Client) Get(ctx context.Context, call string, url string) (*http.Response, error) {
+	return c.getIfChanged(ctx, call, url, Validators{})
+}
+
+// Requests the URL as Get does, but only if it's changed since the copy the
+// validators are for, returning an error wrapping ErrNotModified if not.
+func (c *Client) getIfChanged(ctx context.Context, call string, url string, since Validators) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", c.UserAgent)
+	if since.ETag != "" {
+		req.Header.Set("If-None-Match", since.ETag)
+	}
+	if since.LastModified != "" {
+		req.Header.Set("If-Modified-Since", since.LastModified)
+	}
+	if c.Prepare != nil {
+		c.Prepare(req)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to %s: %v", call, err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusNotModified:
+		resp.Body.Close()
+		return nil, fmt.Errorf("unable to %s: %w", call, ErrNotModified)
+	case http.StatusNoContent, http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("unable to %s: user not found", call)
+	case http.StatusTooManyRequests:
+		resp.Body.Close()
+		return nil, fmt.Errorf("unable to %s: rate limited", call)
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("unable to %s: %s", call, resp.Status)
+	}
+}
+
+// FetchProfile fetches the player's profile from the session server.
+func (c *Client) FetchProfile(ctx context.Context, uuid string) (Profile, error) {
+	url := c.SessionServerURL + uuid
+	if c.Verify != nil {
+		url += "?unsigned=false"
+	}
+	resp, err := c.Get(ctx, "GetSessionProfile", url)
+	if err != nil {
+		return Profile{}, err
+	}
+	defer resp.Body.Close()
+
+	session := SessionProfile{}
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		return Profile{}, fmt.Errorf("unable to GetSessionProfile: %v", err)
+	}
+
+	profile := Profile{UUID: NormalizeUUID(session.ID), Name: session.Name}
+	if err := profile.ApplyProperties(session.Properties, c.Verify); err != nil {
+		return Profile{}, err
+	}
+	return profile, nil
+}
+
+// FetchTexture downloads the texture. These are served from Mojang's CDN
+// rather than their API, so aren't subject to its rate limit. Textures over
+// the size limit, or which aren't a PNG we can decode, are refused with
+// ErrTextureTooLarge or ErrTextureCorrupt, and the rest are decoded without
+// their ancillary chunks.
+func (c *Client) FetchTexture(ctx context.Context, url string) (minecraft.Skin, error) {
+	skin, _, err := c.FetchTextureIfChanged(ctx, url, Validators{})
+	return skin, err
+}
+
+// FetchTextureIfChanged downloads the texture as FetchTexture does, unless
+// it hasn't changed since the copy the validators are for, in which case it
+// returns an error wrapping ErrNotModified. Returns the validators for the
+// copy downloaded, to revalidate it with later.
+func (c *Client) FetchTextureIfChanged(ctx context.Context, url string, since Validators) (minecraft.Skin, Validators, error) {
+	resp, err := c.getIfChanged(ctx, "FetchTexture", url, since)
+	if err != nil {
+		return minecraft.Skin{}, Validators{}, err
+	}
+	defer resp.Body.Close()
+	validators := Validators{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+
+	skin, err := c.DecodeTexture(resp.Body)
+	if err != nil {
+		return minecraft.Skin{}, Validators{}, fmt.Errorf("unable to FetchTexture: %w", err)
+	}
+	skin.Source = "SessionProfile"
+	skin.URL = url
+	skin.Hash = TextureHash(url)
+	return skin, validators, nil
+}
+
+// DecodeTexture reads a texture as FetchTexture does, wherever it's from.
+// Textures over the size limit, or which aren't a PNG we can decode, are
+// refused with ErrTextureTooLarge or ErrTextureCorrupt.
+func (c *Client) DecodeTexture(r io.Reader) (minecraft.Skin, error) {
+	limit := c.MaxTextureSize
+	if limit <= 0 {
+		limit = DefaultMaxTextureSize
+	}
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return minecraft.Skin{}, err
+	}
+	if c.Downloaded != nil {
+		c.Downloaded(int64(len(data)))
+	}
+	if int64(len(data)) > limit {
+		return minecraft.Skin{}, ErrTextureTooLarge
+	}
+	if data, err = StripAncillaryChunks(data); err != nil {
+		return minecraft.Skin{}, err
+	}
+
+	skin := minecraft.Skin{}
+	if err := skin.Decode(bytes.NewReader(data)); err != nil {
+		return minecraft.Skin{}, fmt.Errorf("%w: %v", ErrTextureCorrupt, err)
+	}
+	return skin, nil
+}
+
+// TextureHash returns the hash of the texture at the URL. Texture URLs end
+// with it, though some auth servers add an extension.
+func TextureHash(url string) string {
+	return strings.TrimSuffix(path.Base(url), path.Ext(url))
+}
@@ -0,0 +1,157 @@
+package mcclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFetchProfile(t *testing.T) {
+	textures := base64.RawStdEncoding.EncodeToString([]byte(`{"textures":{"SKIN":{"url":"http://textures.minecraft.net/texture/abc123","metadata":{"model":"slim"}}}}`))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != "test" {
+			t.Errorf("Expected our user agent, got %q", r.Header.Get("User-Agent"))
+		}
+		if r.URL.Path != "/853c80ef-3c37-49fd-aa49-938b674adae6" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		fmt.Fprintf(w, `{"id":"853C80EF-3C37-49FD-AA49-938B674ADAE6","name":"jeb_","properties":[{"name":"textures","value":"%s"}]}`, textures)
+	}))
+	defer server.Close()
+
+	client := &Client{HTTP: server.Client(), UserAgent: "test", SessionServerURL: server.URL + "/"}
+	profile, err := client.FetchProfile(context.Background(), "853c80ef-3c37-49fd-aa49-938b674adae6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.UUID != "853c80ef3c3749fdaa49938b674adae6" || profile.Name != "jeb_" || !profile.Slim {
+		t.Fatalf("Unexpected profile %+v", profile)
+	}
+	if TextureHash(profile.SkinURL) != "abc123" {
+		t.Fatalf("Expected the hash from the skin URL, got %s", TextureHash(profile.SkinURL))
+	}
+
+	if _, err := client.FetchProfile(context.Background(), "nobody"); err == nil || !strings.HasSuffix(err.Error(), "user not found") {
+		t.Fatalf("Expected an unknown player not to be found, got %v", err)
+	}
+
+	client.Verify = func(Property) error { return fmt.Errorf("unsigned") }
+	if _, err := client.FetchProfile(context.Background(), "853c80ef-3c37-49fd-aa49-938b674adae6"); err == nil {
+		t.Fatal("Expected a refused textures property to fail the fetch")
+	}
+}
+
+func TestFetchTextureCountsBytes(t *testing.T) {
+	texture := new(bytes.Buffer)
+	png.Encode(texture, image.NewNRGBA(image.Rect(0, 0, 64, 64)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(texture.Bytes())
+	}))
+	defer server.Close()
+
+	var downloaded int64
+	client := &Client{HTTP: server.Client(), Downloaded: func(bytes int64) { downloaded = bytes }}
+	skin, err := client.FetchTexture(context.Background(), server.URL+"/texture/abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skin.Hash != "abc123" {
+		t.Fatalf("Expected the hash from the URL, got %s", skin.Hash)
+	}
+	if downloaded != int64(texture.Len()) {
+		t.Fatalf("Expected %d bytes downloaded, got %d", texture.Len(), downloaded)
+	}
+}
+
+func TestFetchTextureRefusesInvalid(t *testing.T) {
+	texture := new(bytes.Buffer)
+	png.Encode(texture, image.NewNRGBA(image.Rect(0, 0, 64, 64)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/texture/truncated":
+			w.Write(texture.Bytes()[:texture.Len()/2])
+		case "/texture/html":
+			w.Write([]byte("<html>Not found</html>"))
+		default:
+			w.Write(texture.Bytes())
+		}
+	}))
+	defer server.Close()
+
+	client := &Client{HTTP: server.Client(), MaxTextureSize: int64(texture.Len())}
+	if _, err := client.FetchTexture(context.Background(), server.URL+"/texture/ok"); err != nil {
+		t.Fatalf("Expected a texture at the limit to be fetched, got %v", err)
+	}
+	for _, path := range []string{"truncated", "html"} {
+		if _, err := client.FetchTexture(context.Background(), server.URL+"/texture/"+path); !errors.Is(err, ErrTextureCorrupt) {
+			t.Fatalf("Expected the %s texture to be corrupt, got %v", path, err)
+		}
+	}
+
+	client.MaxTextureSize = int64(texture.Len() - 1)
+	if _, err := client.FetchTexture(context.Background(), server.URL+"/texture/ok"); !errors.Is(err, ErrTextureTooLarge) {
+		t.Fatalf("Expected a texture over the limit to be refused, got %v", err)
+	}
+}
+
+func TestStripAncillaryChunks(t *testing.T) {
+	texture := new(bytes.Buffer)
+	png.Encode(texture, image.NewNRGBA(image.Rect(0, 0, 64, 32)))
+	data := texture.Bytes()
+
+	// Slip a tEXt chunk in after IHDR, which is the 8 byte signature then
+	// 25 bytes.
+	text := []byte("\x00\x00\x00\x05tEXthello\x00\x00\x00\x00")
+	padded := append(append(append([]byte{}, data[:33]...), text...), data[33:]...)
+
+	stripped, err := StripAncillaryChunks(padded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stripped, data) {
+		t.Fatal("Expected the tEXt chunk to be stripped")
+	}
+	if _, err := png.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Fatalf("Expected the stripped texture to decode, got %v", err)
+	}
+
+	if _, err := StripAncillaryChunks(data[:len(data)-4]); !errors.Is(err, ErrTextureCorrupt) {
+		t.Fatalf("Expected a texture without IEND to be corrupt, got %v", err)
+	}
+}
+
+func TestFetchTextureIfChanged(t *testing.T) {
+	texture := new(bytes.Buffer)
+	png.Encode(texture, image.NewNRGBA(image.Rect(0, 0, 64, 64)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		w.Write(texture.Bytes())
+	}))
+	defer server.Close()
+
+	client := &Client{HTTP: server.Client()}
+	_, validators, err := client.FetchTextureIfChanged(context.Background(), server.URL+"/texture/abc123", Validators{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if validators.ETag != `"v1"` || validators.LastModified == "" {
+		t.Fatalf("Expected the texture's validators, got %+v", validators)
+	}
+	if _, _, err := client.FetchTextureIfChanged(context.Background(), server.URL+"/texture/abc123", validators); !errors.Is(err, ErrNotModified) {
+		t.Fatalf("Expected the texture not to have changed, got %v", err)
+	}
+}
@@ -0,0 +1,13 @@
+/*
+Package mcclient fetches players' profiles from a Mojang style session
+server, and the skins their textures property points at.
+
+	client := &mcclient.Client{
+		HTTP:             http.DefaultClient,
+		UserAgent:        "example/1.0",
+		SessionServerURL: "https://sessionserver.mojang.com/session/minecraft/profile/",
+	}
+	profile, err := client.FetchProfile(ctx, "069a79f444e94726a5befca90e38aaf5")
+	skin, err := client.FetchTexture(ctx, profile.SkinURL)
+*/
+package mcclient
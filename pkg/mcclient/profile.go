@@ -0,0 +1,91 @@
+package mcclient
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Profile is a player's profile from the session server, with what we need
+// to fetch and render their textures.
+type Profile struct {
+	UUID    string
+	Name    string
+	SkinURL string
+	CapeURL string
+	// Whether the skin is for the slim, three pixel wide armed, model.
+	Slim bool
+}
+
+// Property is one of a profile's properties, eg. "textures".
+type Property struct {
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	Signature string `json:"signature"`
+}
+
+// SessionProfile is the session server's response for a profile.
+type SessionProfile struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Properties []Property `json:"properties"`
+}
+
+// The base64 encoded "textures" property of a session profile.
+type texturesProperty struct {
+	Textures struct {
+		Skin struct {
+			URL      string `json:"url"`
+			Metadata struct {
+				Model string `json:"model"`
+			} `json:"metadata"`
+		} `json:"SKIN"`
+		Cape struct {
+			URL string `json:"url"`
+		} `json:"CAPE"`
+	} `json:"textures"`
+}
+
+// ApplyProperties fills in the profile's textures from its "textures"
+// property. If verify is given it's called with the property first, and
+// the property is refused if it returns an error.
+func (p *Profile) ApplyProperties(properties []Property, verify func(Property) error) error {
+	for _, property := range properties {
+		if property.Name != "textures" {
+			continue
+		}
+		if verify != nil {
+			if err := verify(property); err != nil {
+				return err
+			}
+		}
+		value, err := DecodeValue(property.Value)
+		if err != nil {
+			return fmt.Errorf("unable to DecodeTextureProperty: %v", err)
+		}
+		textures := texturesProperty{}
+		if err := json.Unmarshal(value, &textures); err != nil {
+			return fmt.Errorf("unable to DecodeTextureProperty: %v", err)
+		}
+		p.SkinURL = textures.Textures.Skin.URL
+		p.CapeURL = textures.Textures.Cape.URL
+		p.Slim = strings.EqualFold(textures.Textures.Skin.Metadata.Model, "slim")
+	}
+	return nil
+}
+
+// DecodeValue decodes a property's base64 value. Not every auth server pads
+// it.
+func DecodeValue(value string) ([]byte, error) {
+	if decoded, err := base64.StdEncoding.DecodeString(value); err == nil {
+		return decoded, nil
+	}
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+// NormalizeUUID returns the UUID without dashes and in lower case, as some
+// auth servers give it with its dashes.
+func NormalizeUUID(uuid string) string {
+	return strings.ToLower(strings.Replace(uuid, "-", "", -1))
+}
@@ -0,0 +1,72 @@
+package mcclient
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// Textures larger than this are refused, unless the Client sets its own
+// limit. Even HD skins are a fraction of it.
+const DefaultMaxTextureSize = 1 << 20
+
+// Errors FetchTexture wraps for textures unfit to use, so callers can tell
+// them apart with errors.Is.
+var (
+	ErrTextureTooLarge = errors.New("texture too large")
+	ErrTextureCorrupt  = errors.New("corrupt texture")
+)
+
+// ErrNotModified is wrapped by FetchTextureIfChanged when the texture
+// hasn't changed since the copy the validators are for.
+var ErrNotModified = errors.New("not modified")
+
+// Validators are what a texture server said identifies the copy of a
+// texture it sent, so we can ask for it again only if it's changed.
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+// Whether there's anything to revalidate with.
+func (v Validators) Empty() bool {
+	return v.ETag == "" && v.LastModified == ""
+}
+
+// Every PNG starts with this.
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// StripAncillaryChunks returns the PNG without its ancillary chunks, eg.
+// text and EXIF, bar tRNS, which says which colours are transparent. None
+// of the rest change how the texture's drawn, so they're only somewhere for
+// junk to hide. Returns ErrTextureCorrupt if the chunks don't add up.
+func StripAncillaryChunks(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, ErrTextureCorrupt
+	}
+
+	stripped := make([]byte, 0, len(data))
+	stripped = append(stripped, pngSignature...)
+	rest := data[len(pngSignature):]
+	for {
+		// Each chunk is its length, type, data and CRC.
+		if len(rest) < 12 {
+			return nil, ErrTextureCorrupt
+		}
+		length := uint64(binary.BigEndian.Uint32(rest[:4]))
+		if length > uint64(len(rest)-12) {
+			return nil, ErrTextureCorrupt
+		}
+		chunk := rest[:12+length]
+		rest = rest[12+length:]
+
+		kind := string(chunk[4:8])
+		// Critical chunks have an upper case first letter.
+		if chunk[4]&0x20 == 0 || kind == "tRNS" {
+			stripped = append(stripped, chunk...)
+		}
+		if kind == "IEND" {
+			return stripped, nil
+		}
+	}
+}
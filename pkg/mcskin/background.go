@@ -0,0 +1,29 @@
+package mcskin
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Background is what's drawn behind a render, fading from Top at the top to
+// Bottom at the bottom. The zero Background leaves it transparent.
+type Background struct {
+	Top    color.NRGBA
+	Bottom color.NRGBA
+}
+
+// Whether the background draws anything.
+func (b Background) IsZero() bool {
+	return b == Background{}
+}
+
+// Fills in behind the render with the background.
+func (skin *Render) drawBackground(background Background) {
+	bounds := skin.Processed.Bounds()
+	filled := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	fillGradient(filled, background.Top, background.Bottom)
+	draw.Draw(filled, filled.Bounds(), skin.Processed, bounds.Min, draw.Over)
+
+	skin.Processed = filled
+}
@@ -0,0 +1,33 @@
+package mcskin
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestBackground(t *testing.T) {
+	background := Background{
+		Top:    color.NRGBA{0x10, 0x20, 0x30, 0xFF},
+		Bottom: color.NRGBA{0x00, 0x00, 0x00, 0xFF},
+	}
+	skin := noisySkin()
+	skin.Options.Background = background
+	if err := skin.GetBody(100); err != nil {
+		t.Fatal(err)
+	}
+	skin.PostProcess()
+
+	body := skin.Processed.(*image.NRGBA)
+	bounds := body.Bounds()
+	if c := body.NRGBAAt(0, 0); c != background.Top {
+		t.Fatalf("Expected the top left to be the background, got %v", c)
+	}
+	if c := body.NRGBAAt(0, bounds.Dy()-1); c != background.Bottom {
+		t.Fatalf("Expected the bottom left to be the bottom of the background, got %v", c)
+	}
+	// The face is drawn over it.
+	if c := body.NRGBAAt(bounds.Dx()/2, bounds.Dy()/8); c.A != 0xFF || c.B == background.Top.B {
+		t.Fatalf("Expected the body over the background, got %v", c)
+	}
+}
@@ -0,0 +1,97 @@
+package mcskin
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/disintegration/imaging"
+)
+
+// The size of a card, as Discord, Twitter and other OpenGraph readers
+// expect of a large link preview.
+const (
+	CardWidth  = 1200
+	CardHeight = 630
+)
+
+// CardStyle is the colours a card is drawn in.
+type CardStyle struct {
+	// The background fades from Background at the top to Gradient at the
+	// bottom.
+	Background color.NRGBA
+	Gradient   color.NRGBA
+	// The player's name.
+	Text color.NRGBA
+	// The bar beneath the name.
+	Accent color.NRGBA
+}
+
+// Sets skin.Processed to a card for link previews: the body with any armor
+// on the left of the background, and the name beside it.
+func (skin *Render) GetCard(name string, style CardStyle) error {
+	name = truncateLabel(name)
+
+	// Draw the body at the skin's scale, then blow it up by whole pixels so
+	// it stays crisp.
+	mode := skin.Mode
+	skin.Mode = "None"
+	err := skin.GetArmorBody(0)
+	skin.Mode = mode
+	if err != nil {
+		return err
+	}
+	body := skin.Processed
+	bodyBounds := body.Bounds()
+	scale := CardHeight * 4 / 5 / bodyBounds.Dy()
+	if scale < 1 {
+		scale = 1
+	}
+	scaled := imaging.Resize(body, bodyBounds.Dx()*scale, bodyBounds.Dy()*scale, imaging.NearestNeighbor)
+
+	card := image.NewNRGBA(image.Rect(0, 0, CardWidth, CardHeight))
+	fillGradient(card, style.Background, style.Gradient)
+
+	margin := (CardHeight - scaled.Bounds().Dy()) / 2
+	bodyRect := scaled.Bounds().Add(image.Pt(margin, margin))
+	draw.Draw(card, bodyRect, scaled, image.Point{}, draw.Over)
+
+	// The name takes what's left of the width, but no more than a quarter
+	// of the height.
+	left := bodyRect.Max.X + margin
+	textWidth := measureText(name)
+	textScale := (CardWidth - left - margin) / (textWidth + 1)
+	if most := CardHeight / 4 / GlyphHeight; textScale > most {
+		textScale = most
+	}
+	if textScale < 1 {
+		textScale = 1
+	}
+	top := (CardHeight - (GlyphHeight+3)*textScale) / 2
+	drawTextShadow(card, name, left, top, textScale, style.Text)
+
+	bar := image.Rect(left, top+(GlyphHeight+2)*textScale, left+textWidth*textScale, top+(GlyphHeight+3)*textScale)
+	draw.Draw(card, bar, image.NewUniform(style.Accent), image.Point{}, draw.Over)
+
+	skin.Processed = card
+	return nil
+}
+
+// Fills the image top to bottom with a fade from one colour to the other.
+func fillGradient(img *image.NRGBA, from, to color.NRGBA) {
+	bounds := img.Bounds()
+	height := bounds.Dy()
+	mix := func(a, b uint8, y int) uint8 {
+		if height <= 1 {
+			return a
+		}
+		return uint8((int(a)*(height-1-y) + int(b)*y) / (height - 1))
+	}
+	for y := 0; y < height; y++ {
+		c := color.NRGBA{mix(from.R, to.R, y), mix(from.G, to.G, y), mix(from.B, to.B, y), mix(from.A, to.A, y)}
+		row := img.Pix[y*img.Stride : y*img.Stride+bounds.Dx()*4]
+		for x := 0; x < len(row); x += 4 {
+			row[x], row[x+1], row[x+2], row[x+3] = c.R, c.G, c.B, c.A
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package mcskin
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/minotar/minecraft"
+)
+
+func TestGetCard(t *testing.T) {
+	steve, err := minecraft.FetchSkinForSteve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	style := CardStyle{
+		Background: color.NRGBA{0x10, 0x20, 0x30, 0xFF},
+		Gradient:   color.NRGBA{0x00, 0x00, 0x00, 0xFF},
+		Text:       color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF},
+		Accent:     color.NRGBA{0x55, 0xAA, 0x55, 0xFF},
+	}
+	skin := &Render{Skin: steve, Mode: "Normal"}
+	if err := skin.GetCard("Notch", style); err != nil {
+		t.Fatal(err)
+	}
+
+	card := skin.Processed.(*image.NRGBA)
+	if bounds := card.Bounds(); bounds.Dx() != CardWidth || bounds.Dy() != CardHeight {
+		t.Fatalf("Expected a %dx%d card, got %v", CardWidth, CardHeight, bounds)
+	}
+	if c := card.NRGBAAt(CardWidth-1, 0); c != style.Background {
+		t.Fatalf("Expected the top right to be the background, got %v", c)
+	}
+	if c := card.NRGBAAt(CardWidth-1, CardHeight-1); c != style.Gradient {
+		t.Fatalf("Expected the bottom right to be the gradient, got %v", c)
+	}
+	// The body's blown up 15 times and centred down the left, so Steve's
+	// face is around 195, 135.
+	if c := card.NRGBAAt(195, 135); c.A != 0xFF || c == style.Background {
+		t.Fatalf("Expected the body to be drawn, got %v", c)
+	}
+
+	accent, text := 0, 0
+	for y := 0; y < CardHeight; y++ {
+		for x := CardWidth / 2; x < CardWidth; x++ {
+			switch card.NRGBAAt(x, y) {
+			case style.Accent:
+				accent++
+			case style.Text:
+				text++
+			}
+		}
+	}
+	if accent == 0 || text == 0 {
+		t.Fatalf("Expected the name and its bar to be drawn, got %d text and %d accent pixels", text, accent)
+	}
+}
+
+func TestFillGradient(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 3))
+	fillGradient(img, color.NRGBA{0, 0, 0, 0xFF}, color.NRGBA{0xFF, 0x80, 0, 0xFF})
+	if c := img.NRGBAAt(1, 1); c != (color.NRGBA{0x7F, 0x40, 0, 0xFF}) {
+		t.Fatalf("Expected the middle row halfway between, got %v", c)
+	}
+}
@@ -0,0 +1,9 @@
+/*
+Package mcskin renders Minecraft skins: heads, helms, isometric cubes,
+busts and bodies, with or without the armor layer, as PNG or SVG.
+
+	render := &mcskin.Render{Skin: skin, Mode: "Normal"}
+	render.GetHelm(180)
+	render.WritePNG(w)
+*/
+package mcskin
@@ -0,0 +1,188 @@
+package mcskin
+
+import (
+	"image"
+	"image/color"
+)
+
+// The Ears mod keeps its settings in a row of pixels of the 64x64 skin which
+// are otherwise unused, starting with a magic pixel, and textures its ears
+// and tail from other unused regions. Only the ears and tail are drawn, as
+// straight as the mod draws them by default.
+const (
+	earsMagicX = 0
+	earsMagicY = 32
+
+	// Ears are 16x8, drawn above the head or split either side of it.
+	EarsX      = 24
+	EarsY      = 0
+	EarsWidth  = 16
+	EarsHeight = 8
+
+	// The tail is 8x12, hanging from the waist.
+	TailX      = 56
+	TailY      = 16
+	TailWidth  = 8
+	TailHeight = 12
+)
+
+// Colours the Ears settings pixels use.
+var (
+	earsBlue   = color.NRGBA{0x3F, 0x23, 0xD8, 0xFF}
+	earsGreen  = color.NRGBA{0x23, 0xD8, 0x48, 0xFF}
+	earsRed    = color.NRGBA{0xD8, 0x23, 0x3F, 0xFF}
+	earsOrange = color.NRGBA{0xD8, 0x78, 0x23, 0xFF}
+)
+
+// EarMode is where a skin's ears are.
+type EarMode int
+
+const (
+	EarsNone EarMode = iota
+	EarsAbove
+	EarsSides
+	// Behind the head, so hidden from the front.
+	EarsBehind
+	// Both above and either side of the head.
+	EarsAround
+)
+
+// TailMode is which way a skin's tail points.
+type TailMode int
+
+const (
+	TailNone TailMode = iota
+	TailDown
+	// Straight out behind, so edge on from the front.
+	TailBack
+	TailUp
+)
+
+// Ears are the extras a skin made for the Ears mod has.
+type Ears struct {
+	Ears EarMode
+	Tail TailMode
+}
+
+// DecodeEars reads the Ears mod's settings from the skin, returning false
+// if it wasn't made for the mod.
+func DecodeEars(img image.Image) (Ears, bool) {
+	bounds := img.Bounds()
+	if bounds.Dx() != 64 || bounds.Dy() != 64 {
+		return Ears{}, false
+	}
+	pixel := func(x int) color.NRGBA {
+		return color.NRGBAModel.Convert(img.At(bounds.Min.X+earsMagicX+x, bounds.Min.Y+earsMagicY)).(color.NRGBA)
+	}
+	if pixel(0) != earsBlue {
+		return Ears{}, false
+	}
+
+	ears := Ears{}
+	switch pixel(1) {
+	case earsBlue:
+		ears.Ears = EarsAbove
+	case earsGreen:
+		ears.Ears = EarsSides
+	case earsRed:
+		ears.Ears = EarsBehind
+	case earsOrange:
+		ears.Ears = EarsAround
+	}
+	// The pixels between anchor the ears and add claws or horns, which
+	// don't show from the front.
+	switch pixel(4) {
+	case earsRed:
+		ears.Tail = TailDown
+	case earsGreen:
+		ears.Tail = TailBack
+	case earsBlue:
+		ears.Tail = TailUp
+	}
+	return ears, true
+}
+
+// Draws the skin's ears around the head, which is at headX, headY in the
+// render, growing the render to fit them. Does nothing unless asked to, and
+// the skin was made for the Ears mod.
+func (skin *Render) addEars(img *image.NRGBA, headX int, headY int) *image.NRGBA {
+	if !skin.Options.Ears {
+		return img
+	}
+	ears, ok := DecodeEars(skin.Image)
+	if !ok {
+		return img
+	}
+
+	above := ears.Ears == EarsAbove || ears.Ears == EarsAround
+	sides := ears.Ears == EarsSides || ears.Ears == EarsAround
+	if !above && !sides {
+		return img
+	}
+
+	// How far the ears stick out past the head, and so the render.
+	width := img.Bounds().Dx()
+	overhang := (EarsWidth - HeadWidth) / 2
+	if sides {
+		overhang = EarsWidth / 2
+	}
+	left := overhang - headX
+	right := headX + HeadWidth + overhang - width
+	top := 0
+	if above {
+		top = EarsHeight - headY
+	}
+	if left < 0 {
+		left = 0
+	}
+	if right < 0 {
+		right = 0
+	}
+	if top < 0 {
+		top = 0
+	}
+
+	earsImg := skin.crop(skin.Image, image.Rect(EarsX, EarsY, EarsX+EarsWidth, EarsY+EarsHeight))
+	padded := pad(img, left, top, right)
+	headX, headY = headX+left, headY+top
+	if above {
+		fastDraw(padded, earsImg, headX-(EarsWidth-HeadWidth)/2, headY-EarsHeight)
+	}
+	if sides {
+		half := EarsWidth / 2
+		fastDraw(padded, skin.crop(earsImg, image.Rect(0, 0, half, EarsHeight)), headX-half, headY)
+		fastDraw(padded, skin.crop(earsImg, image.Rect(half, 0, EarsWidth, EarsHeight)), headX+HeadWidth, headY)
+	}
+	return padded
+}
+
+// Draws the skin's tail behind the body, whose waist is at waistX, waistY
+// in the render. A straight tail is mostly hidden by the body from the
+// front, but shows where the body is see-through.
+func (skin *Render) addTail(img *image.NRGBA, waistX int, waistY int) *image.NRGBA {
+	if !skin.Options.Ears {
+		return img
+	}
+	ears, ok := DecodeEars(skin.Image)
+	if !ok || (ears.Tail != TailDown && ears.Tail != TailUp) {
+		return img
+	}
+
+	tailImg := skin.crop(skin.Image, image.Rect(TailX, TailY, TailX+TailWidth, TailY+TailHeight))
+	y := waistY
+	if ears.Tail == TailUp {
+		y -= TailHeight
+	}
+	behind := image.NewNRGBA(img.Bounds())
+	fastDraw(behind, tailImg, waistX, y)
+	fastDraw(behind, img, 0, 0)
+	return behind
+}
+
+// Returns the image on a larger canvas, with the given margins.
+func pad(img *image.NRGBA, left int, top int, right int) *image.NRGBA {
+	bounds := img.Bounds()
+	padded := image.NewNRGBA(image.Rect(0, 0, bounds.Dx()+left+right, bounds.Dy()+top))
+	fastDraw(padded, img, left, top)
+	return padded
+}
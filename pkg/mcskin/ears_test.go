@@ -0,0 +1,73 @@
+package mcskin
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/minotar/minecraft"
+)
+
+// Returns a skin made for the Ears mod, with the given ear and tail
+// settings, and solid ears and tail.
+func earsSkin(ears color.NRGBA, tail color.NRGBA) *Render {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	opaque := color.NRGBA{0x80, 0x80, 0x80, 0xFF}
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			if (x >= HeadX && x < HeadX+HeadWidth && y >= HeadY && y < HeadY+HeadHeight) ||
+				(x >= EarsX && x < EarsX+EarsWidth && y >= EarsY && y < EarsY+EarsHeight) ||
+				(x >= TailX && x < TailX+TailWidth && y >= TailY && y < TailY+TailHeight) {
+				img.SetNRGBA(x, y, opaque)
+			}
+		}
+	}
+	img.SetNRGBA(0, 32, earsBlue)
+	img.SetNRGBA(1, 32, ears)
+	img.SetNRGBA(4, 32, tail)
+
+	skin := &Render{Skin: minecraft.Skin{Texture: minecraft.Texture{Image: img}}, Mode: "None"}
+	skin.Options.Ears = true
+	return skin
+}
+
+func TestDecodeEars(t *testing.T) {
+	ears, ok := DecodeEars(earsSkin(earsGreen, earsRed).Image)
+	if !ok || ears.Ears != EarsSides || ears.Tail != TailDown {
+		t.Fatalf("Expected ears at the sides and a tail hanging down, got %+v", ears)
+	}
+	if _, ok := DecodeEars(image.NewNRGBA(image.Rect(0, 0, 64, 64))); ok {
+		t.Fatal("Expected a skin without the magic pixel not to have ears")
+	}
+}
+
+func TestGetHelmWithEars(t *testing.T) {
+	skin := earsSkin(earsBlue, color.NRGBA{})
+	skin.GetHelm(0)
+	if bounds := skin.Processed.Bounds(); bounds.Dx() != EarsWidth || bounds.Dy() != HeadHeight+EarsHeight {
+		t.Fatalf("Expected the render to grow to fit the ears, got %v", bounds)
+	}
+	if skin.Processed.(*image.NRGBA).NRGBAAt(0, 0).A != 0xFF {
+		t.Fatal("Expected the ears to be drawn above the head")
+	}
+
+	skin.Options.Ears = false
+	skin.GetHelm(0)
+	if bounds := skin.Processed.Bounds(); bounds.Dx() != HeadWidth {
+		t.Fatalf("Expected no ears unless asked for, got %v", bounds)
+	}
+}
+
+func TestGetBodyWithSideEars(t *testing.T) {
+	skin := earsSkin(earsGreen, earsRed)
+	skin.GetBody(0)
+	// The ears stick out half their width either side of the head, past
+	// the arms.
+	if bounds := skin.Processed.Bounds(); bounds.Dx() != HeadWidth+EarsWidth || bounds.Dy() != 32 {
+		t.Fatalf("Expected the render to widen to fit the ears, got %v", bounds)
+	}
+	// The legs are see-through here, so the tail hanging behind them shows.
+	if skin.Processed.(*image.NRGBA).NRGBAAt(8, 31).A != 0xFF {
+		t.Fatal("Expected the tail to be drawn behind the legs")
+	}
+}
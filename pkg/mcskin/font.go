@@ -0,0 +1,190 @@
+package mcskin
+
+import (
+	"image"
+	"image/color"
+)
+
+const (
+	// Height of a glyph in the bitmap font, in pixels.
+	GlyphHeight = 7
+	// Gap between glyphs, and the width of a space.
+	GlyphSpacing = 1
+	SpaceWidth   = 3
+)
+
+// The classic 5x7 font of HD44780 character LCDs, as in glcdfont.c from the
+// Adafruit GFX Library (Copyright (c) 2012 Adafruit Industries, BSD licence).
+// It isn't Minecraft's font. Each glyph is five columns, with the lowest bit
+// of each column being its top pixel. Empty columns are trimmed when drawing
+// to give proportional spacing.
+var fontGlyphs = map[rune][5]uint8{
+	' ':  {0x00, 0x00, 0x00, 0x00, 0x00},
+	'!':  {0x00, 0x00, 0x5F, 0x00, 0x00},
+	'"':  {0x00, 0x07, 0x00, 0x07, 0x00},
+	'#':  {0x14, 0x7F, 0x14, 0x7F, 0x14},
+	'$':  {0x24, 0x2A, 0x7F, 0x2A, 0x12},
+	'%':  {0x23, 0x13, 0x08, 0x64, 0x62},
+	'&':  {0x36, 0x49, 0x55, 0x22, 0x50},
+	'\'': {0x00, 0x05, 0x03, 0x00, 0x00},
+	'(':  {0x00, 0x1C, 0x22, 0x41, 0x00},
+	')':  {0x00, 0x41, 0x22, 0x1C, 0x00},
+	'*':  {0x08, 0x2A, 0x1C, 0x2A, 0x08},
+	'+':  {0x08, 0x08, 0x3E, 0x08, 0x08},
+	',':  {0x00, 0x50, 0x30, 0x00, 0x00},
+	'-':  {0x08, 0x08, 0x08, 0x08, 0x08},
+	'.':  {0x00, 0x60, 0x60, 0x00, 0x00},
+	'/':  {0x20, 0x10, 0x08, 0x04, 0x02},
+	'0':  {0x3E, 0x51, 0x49, 0x45, 0x3E},
+	'1':  {0x00, 0x42, 0x7F, 0x40, 0x00},
+	'2':  {0x42, 0x61, 0x51, 0x49, 0x46},
+	'3':  {0x21, 0x41, 0x45, 0x4B, 0x31},
+	'4':  {0x18, 0x14, 0x12, 0x7F, 0x10},
+	'5':  {0x27, 0x45, 0x45, 0x45, 0x39},
+	'6':  {0x3C, 0x4A, 0x49, 0x49, 0x30},
+	'7':  {0x01, 0x71, 0x09, 0x05, 0x03},
+	'8':  {0x36, 0x49, 0x49, 0x49, 0x36},
+	'9':  {0x06, 0x49, 0x49, 0x29, 0x1E},
+	':':  {0x00, 0x36, 0x36, 0x00, 0x00},
+	';':  {0x00, 0x56, 0x36, 0x00, 0x00},
+	'<':  {0x00, 0x08, 0x14, 0x22, 0x41},
+	'=':  {0x14, 0x14, 0x14, 0x14, 0x14},
+	'>':  {0x41, 0x22, 0x14, 0x08, 0x00},
+	'?':  {0x02, 0x01, 0x51, 0x09, 0x06},
+	'@':  {0x32, 0x49, 0x79, 0x41, 0x3E},
+	'A':  {0x7E, 0x11, 0x11, 0x11, 0x7E},
+	'B':  {0x7F, 0x49, 0x49, 0x49, 0x36},
+	'C':  {0x3E, 0x41, 0x41, 0x41, 0x22},
+	'D':  {0x7F, 0x41, 0x41, 0x22, 0x1C},
+	'E':  {0x7F, 0x49, 0x49, 0x49, 0x41},
+	'F':  {0x7F, 0x09, 0x09, 0x01, 0x01},
+	'G':  {0x3E, 0x41, 0x41, 0x51, 0x32},
+	'H':  {0x7F, 0x08, 0x08, 0x08, 0x7F},
+	'I':  {0x00, 0x41, 0x7F, 0x41, 0x00},
+	'J':  {0x20, 0x40, 0x41, 0x3F, 0x01},
+	'K':  {0x7F, 0x08, 0x14, 0x22, 0x41},
+	'L':  {0x7F, 0x40, 0x40, 0x40, 0x40},
+	'M':  {0x7F, 0x02, 0x04, 0x02, 0x7F},
+	'N':  {0x7F, 0x04, 0x08, 0x10, 0x7F},
+	'O':  {0x3E, 0x41, 0x41, 0x41, 0x3E},
+	'P':  {0x7F, 0x09, 0x09, 0x09, 0x06},
+	'Q':  {0x3E, 0x41, 0x51, 0x21, 0x5E},
+	'R':  {0x7F, 0x09, 0x19, 0x29, 0x46},
+	'S':  {0x46, 0x49, 0x49, 0x49, 0x31},
+	'T':  {0x01, 0x01, 0x7F, 0x01, 0x01},
+	'U':  {0x3F, 0x40, 0x40, 0x40, 0x3F},
+	'V':  {0x1F, 0x20, 0x40, 0x20, 0x1F},
+	'W':  {0x7F, 0x20, 0x18, 0x20, 0x7F},
+	'X':  {0x63, 0x14, 0x08, 0x14, 0x63},
+	'Y':  {0x03, 0x04, 0x78, 0x04, 0x03},
+	'Z':  {0x61, 0x51, 0x49, 0x45, 0x43},
+	'[':  {0x00, 0x00, 0x7F, 0x41, 0x41},
+	'\\': {0x02, 0x04, 0x08, 0x10, 0x20},
+	']':  {0x41, 0x41, 0x7F, 0x00, 0x00},
+	'^':  {0x04, 0x02, 0x01, 0x02, 0x04},
+	'_':  {0x40, 0x40, 0x40, 0x40, 0x40},
+	'`':  {0x00, 0x01, 0x02, 0x04, 0x00},
+	'a':  {0x20, 0x54, 0x54, 0x54, 0x78},
+	'b':  {0x7F, 0x48, 0x44, 0x44, 0x38},
+	'c':  {0x38, 0x44, 0x44, 0x44, 0x20},
+	'd':  {0x38, 0x44, 0x44, 0x48, 0x7F},
+	'e':  {0x38, 0x54, 0x54, 0x54, 0x18},
+	'f':  {0x08, 0x7E, 0x09, 0x01, 0x02},
+	'g':  {0x08, 0x14, 0x54, 0x54, 0x3C},
+	'h':  {0x7F, 0x08, 0x04, 0x04, 0x78},
+	'i':  {0x00, 0x44, 0x7D, 0x40, 0x00},
+	'j':  {0x20, 0x40, 0x44, 0x3D, 0x00},
+	'k':  {0x00, 0x7F, 0x10, 0x28, 0x44},
+	'l':  {0x00, 0x41, 0x7F, 0x40, 0x00},
+	'm':  {0x7C, 0x04, 0x18, 0x04, 0x78},
+	'n':  {0x7C, 0x08, 0x04, 0x04, 0x78},
+	'o':  {0x38, 0x44, 0x44, 0x44, 0x38},
+	'p':  {0x7C, 0x14, 0x14, 0x14, 0x08},
+	'q':  {0x08, 0x14, 0x14, 0x18, 0x7C},
+	'r':  {0x7C, 0x08, 0x04, 0x04, 0x08},
+	's':  {0x48, 0x54, 0x54, 0x54, 0x20},
+	't':  {0x04, 0x3F, 0x44, 0x40, 0x20},
+	'u':  {0x3C, 0x40, 0x40, 0x20, 0x7C},
+	'v':  {0x1C, 0x20, 0x40, 0x20, 0x1C},
+	'w':  {0x3C, 0x40, 0x30, 0x40, 0x3C},
+	'x':  {0x44, 0x28, 0x10, 0x28, 0x44},
+	'y':  {0x0C, 0x50, 0x50, 0x50, 0x3C},
+	'z':  {0x44, 0x64, 0x54, 0x4C, 0x44},
+	'{':  {0x00, 0x08, 0x36, 0x41, 0x00},
+	'|':  {0x00, 0x00, 0x7F, 0x00, 0x00},
+	'}':  {0x00, 0x41, 0x36, 0x08, 0x00},
+	'~':  {0x10, 0x08, 0x08, 0x10, 0x08},
+}
+
+// Returns the columns to draw for the rune, with empty columns trimmed from
+// either side. Runes we don't have a glyph for are drawn as a "?".
+func glyphColumns(r rune) []uint8 {
+	if r == ' ' {
+		return make([]uint8, SpaceWidth)
+	}
+
+	glyph, exists := fontGlyphs[r]
+	if !exists {
+		glyph = fontGlyphs['?']
+	}
+
+	start, end := 0, len(glyph)
+	for start < end && glyph[start] == 0 {
+		start++
+	}
+	for end > start && glyph[end-1] == 0 {
+		end--
+	}
+	return glyph[start:end]
+}
+
+// Returns the width of the text in font pixels (before scaling).
+func measureText(text string) int {
+	width := 0
+	for _, r := range text {
+		width += len(glyphColumns(r)) + GlyphSpacing
+	}
+	if width > 0 {
+		width -= GlyphSpacing
+	}
+	return width
+}
+
+// Draws the text onto dst with its top left at x, y, with each font pixel
+// being scale pixels square. Anything falling outside dst is clipped.
+func drawText(dst *image.NRGBA, text string, x, y, scale int, c color.NRGBA) {
+	for _, r := range text {
+		columns := glyphColumns(r)
+		drawGlyph(dst, columns, x, y, scale, c)
+		x += (len(columns) + GlyphSpacing) * scale
+	}
+}
+
+// Draws a glyph's columns onto dst with its top left at x, y.
+func drawGlyph(dst *image.NRGBA, columns []uint8, x, y, scale int, c color.NRGBA) {
+	for col, bits := range columns {
+		for row := 0; row < GlyphHeight; row++ {
+			if bits&(1<<uint(row)) != 0 {
+				fillRect(dst, image.Rect(x+col*scale, y+row*scale, x+(col+1)*scale, y+(row+1)*scale), c)
+			}
+		}
+	}
+}
+
+// Fills the part of the rectangle inside dst with the colour.
+func fillRect(dst *image.NRGBA, rect image.Rectangle, c color.NRGBA) {
+	rect = rect.Intersect(dst.Bounds())
+	for py := rect.Min.Y; py < rect.Max.Y; py++ {
+		for px := rect.Min.X; px < rect.Max.X; px++ {
+			dst.SetNRGBA(px, py, c)
+		}
+	}
+}
+
+// Draws the text with a drop shadow a quarter of its brightness, the way
+// Minecraft draws text in game.
+func drawTextShadow(dst *image.NRGBA, text string, x, y, scale int, c color.NRGBA) {
+	shadow := color.NRGBA{c.R / 4, c.G / 4, c.B / 4, c.A}
+	drawText(dst, text, x+scale, y+scale, scale, shadow)
+	drawText(dst, text, x, y, scale, c)
+}
@@ -0,0 +1,38 @@
+package mcskin
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestMeasureText(t *testing.T) {
+	// "i" is trimmed to three columns, "H" is the full five.
+	if width := measureText("iH"); width != 3+GlyphSpacing+5 {
+		t.Fatalf("measureText(\"iH\") was %d", width)
+	}
+	if width := measureText(""); width != 0 {
+		t.Fatalf("measureText(\"\") was %d", width)
+	}
+}
+
+func TestDrawTextClips(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	drawText(img, "W", 0, 0, 2, color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF})
+
+	// The top left of "W" is set, and nothing outside the image panicked.
+	if img.NRGBAAt(0, 0).A != 0xFF {
+		t.Fatal("Expected the top left pixel of W to be drawn")
+	}
+}
+
+func TestTruncateLabel(t *testing.T) {
+	name := strings.Repeat("é", MaxLabelLength+1)
+	if label := truncateLabel(name); label != strings.Repeat("é", MaxLabelLength) {
+		t.Fatalf("Expected %d whole characters, got %q", MaxLabelLength, label)
+	}
+	if label := truncateLabel("Notch"); label != "Notch" {
+		t.Fatalf("Expected a short label to be left alone, got %q", label)
+	}
+}
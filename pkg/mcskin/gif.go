@@ -0,0 +1,47 @@
+package mcskin
+
+import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+)
+
+// Returns the image with a palette, as a GIF needs. Renders rarely have more
+// colours than a palette can hold, so they're kept exactly when they fit,
+// and dithered to the web-safe colours when they don't. GIFs have no partial
+// transparency, so pixels under half opaque are dropped and the rest made
+// opaque.
+func paletted(img image.Image) *image.Paletted {
+	bounds := img.Bounds()
+	out := image.NewPaletted(bounds, color.Palette{color.Transparent})
+	index := map[color.NRGBA]uint8{}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A < 0x80 {
+				continue
+			}
+			c.A = 0xFF
+			i, seen := index[c]
+			if !seen {
+				if len(out.Palette) == 256 {
+					return dithered(img)
+				}
+				i = uint8(len(out.Palette))
+				index[c] = i
+				out.Palette = append(out.Palette, c)
+			}
+			out.SetColorIndex(x, y, i)
+		}
+	}
+	return out
+}
+
+func dithered(img image.Image) *image.Paletted {
+	bounds := img.Bounds()
+	out := image.NewPaletted(bounds, append(color.Palette{color.Transparent}, palette.WebSafe...))
+	draw.FloydSteinberg.Draw(out, bounds, img, bounds.Min)
+	return out
+}
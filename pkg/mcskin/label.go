@@ -0,0 +1,45 @@
+package mcskin
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"unicode/utf8"
+)
+
+// Longest label we'll draw, in characters.
+const MaxLabelLength = 32
+
+// Cuts the label down to MaxLabelLength characters, rather than bytes, so
+// a name isn't cut through the middle of a character.
+func truncateLabel(text string) string {
+	if utf8.RuneCountInString(text) <= MaxLabelLength {
+		return text
+	}
+	return string([]rune(text)[:MaxLabelLength])
+}
+
+// Extends the processed image downwards and draws the label beneath the
+// render, scaled up as far as it will fit the render's width.
+func (skin *Render) drawLabel(text string) {
+	text = truncateLabel(text)
+
+	bounds := skin.Processed.Bounds()
+	textWidth := measureText(text)
+	scale := bounds.Dx() / (textWidth + 2)
+	if scale < 1 {
+		scale = 1
+	}
+	labelHeight := (GlyphHeight + 3) * scale
+
+	labelled := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()+labelHeight))
+	draw.Draw(labelled, bounds.Sub(bounds.Min), skin.Processed, bounds.Min, draw.Src)
+
+	x := (bounds.Dx() - textWidth*scale) / 2
+	if x < 0 {
+		x = 0
+	}
+	drawTextShadow(labelled, text, x, bounds.Dy()+scale, scale, color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF})
+
+	skin.Processed = labelled
+}
@@ -0,0 +1,47 @@
+package mcskin
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Montage tiles square renders of the same size into a grid, eg. the heads
+// of a server's online players, so they can be fetched as one image.
+type Montage struct {
+	Processed *image.NRGBA
+	cols      int
+	size      int
+	padding   int
+}
+
+// MontageBounds is the size of a montage of count tiles, each size pixels
+// square, cols to a row, with padding between them and around the edge.
+func MontageBounds(count, cols, size, padding int) image.Rectangle {
+	if cols > count {
+		cols = count
+	}
+	rows := (count + cols - 1) / cols
+	return image.Rect(0, 0, cols*size+(cols+1)*padding, rows*size+(rows+1)*padding)
+}
+
+// NewMontage makes a transparent montage with room for count tiles, laid
+// out as for MontageBounds.
+func NewMontage(count, cols, size, padding int) *Montage {
+	if cols > count {
+		cols = count
+	}
+	return &Montage{
+		Processed: image.NewNRGBA(MontageBounds(count, cols, size, padding)),
+		cols:      cols,
+		size:      size,
+		padding:   padding,
+	}
+}
+
+// Draw draws the tile in the i'th place, counting left to right, then top
+// to bottom.
+func (m *Montage) Draw(i int, tile image.Image) {
+	x := m.padding + (i%m.cols)*(m.size+m.padding)
+	y := m.padding + (i/m.cols)*(m.size+m.padding)
+	draw.Draw(m.Processed, image.Rect(x, y, x+m.size, y+m.size), tile, tile.Bounds().Min, draw.Src)
+}
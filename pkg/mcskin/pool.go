@@ -0,0 +1,85 @@
+package mcskin
+
+import (
+	"image"
+	"image/png"
+	"math"
+	"math/bits"
+	"sync"
+)
+
+// Renders are drawn, encoded and thrown away many times a second. The
+// largest buffers they need, the resized image and the PNG encoder's, are
+// reused rather than left for the garbage collector.
+
+// Pixel buffers, by the power of two their capacity is.
+var pixPools [64]sync.Pool
+
+// Returns a pixel buffer of n bytes, with whatever it held before.
+func getPix(n int) []uint8 {
+	class := bits.Len(uint(n - 1))
+	if pix, ok := pixPools[class].Get().(*[]uint8); ok {
+		return (*pix)[:n]
+	}
+	return make([]uint8, n, 1<<class)
+}
+
+// Returns the pixel buffer to be reused.
+func putPix(pix []uint8) {
+	class := bits.Len(uint(cap(pix) - 1))
+	if cap(pix) != 1<<class {
+		// Not one of ours.
+		return
+	}
+	pixPools[class].Put(&pix)
+}
+
+// Resizes the image as imaging.Resize does with imaging.NearestNeighbor and
+// a height of 0, into a pooled image. Every pixel is written, so what the
+// buffer held before doesn't matter.
+func resizeNearest(src *image.NRGBA, width int) *image.NRGBA {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	height := int(math.Max(1, math.Floor(float64(width)*float64(srcH)/float64(srcW)+0.5)))
+
+	dst := &image.NRGBA{Pix: getPix(width * height * 4), Stride: width * 4, Rect: image.Rect(0, 0, width, height)}
+	dx := float64(srcW) / float64(width)
+	dy := float64(srcH) / float64(height)
+	for y := 0; y < height; y++ {
+		srcY := int((float64(y) + 0.5) * dy)
+		dstOff := y * dst.Stride
+		for x := 0; x < width; x++ {
+			srcX := int((float64(x) + 0.5) * dx)
+			srcOff := src.PixOffset(bounds.Min.X+srcX, bounds.Min.Y+srcY)
+			copy(dst.Pix[dstOff:dstOff+4], src.Pix[srcOff:srcOff+4])
+			dstOff += 4
+		}
+	}
+	return dst
+}
+
+// Reuses the PNG encoder's buffers between encodes.
+type pngBufferPool struct {
+	pool sync.Pool
+}
+
+func (p *pngBufferPool) Get() *png.EncoderBuffer {
+	buf, _ := p.pool.Get().(*png.EncoderBuffer)
+	return buf
+}
+
+func (p *pngBufferPool) Put(buf *png.EncoderBuffer) {
+	p.pool.Put(buf)
+}
+
+var pngEncoder = &png.Encoder{BufferPool: &pngBufferPool{}}
+
+// Release returns the render's buffers to be reused, once it's been
+// encoded. Processed mustn't be used after.
+func (skin *Render) Release() {
+	if skin.pooled != nil {
+		putPix(skin.pooled.Pix)
+		skin.pooled = nil
+		skin.Processed = nil
+	}
+}
@@ -0,0 +1,64 @@
+package mcskin
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/minotar/minecraft"
+)
+
+// Returns a skin with every pixel different, so any resize which picks the
+// wrong source pixel shows.
+func noisySkin() *Render {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 4), uint8(y * 4), uint8(x ^ y), 0xFF})
+		}
+	}
+	return &Render{Skin: minecraft.Skin{Texture: minecraft.Texture{Image: img}}, Mode: "Normal"}
+}
+
+func TestResizeNearestMatchesImaging(t *testing.T) {
+	src := noisySkin().Image.(*image.NRGBA)
+	// A sub-image, as bust renders are, to check its offset is respected.
+	sub := src.SubImage(image.Rect(8, 8, 24, 40)).(*image.NRGBA)
+	for _, img := range []*image.NRGBA{src, sub} {
+		for _, width := range []int{1, 7, 16, 33, 64, 100, 300} {
+			expected := imaging.Resize(img, width, 0, imaging.NearestNeighbor)
+			resized := resizeNearest(img, width)
+			if resized.Bounds() != expected.Bounds() || !bytes.Equal(resized.Pix, expected.Pix) {
+				t.Fatalf("Expected resizing %v to %d to match imaging", img.Bounds(), width)
+			}
+		}
+	}
+}
+
+func TestReleaseReusesBuffers(t *testing.T) {
+	skin := noisySkin()
+	skin.GetBody(256)
+	pix := skin.pooled.Pix
+	skin.Release()
+	if skin.Processed != nil || skin.pooled != nil {
+		t.Fatal("Expected the released render to be cleared")
+	}
+	if reused := getPix(len(pix)); &reused[:1][0] != &pix[:1][0] {
+		// The pool may drop buffers whenever it likes, eg. under the race
+		// detector, so this is only worth noting.
+		t.Log("The released buffer wasn't reused")
+	}
+}
+
+func BenchmarkRenderAndEncode(b *testing.B) {
+	skin := noisySkin()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		skin.GetBody(512)
+		skin.WritePNG(io.Discard)
+		skin.Release()
+	}
+}
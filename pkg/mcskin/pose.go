@@ -0,0 +1,140 @@
+package mcskin
+
+import (
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// The furthest the arms may be swung out from the body, in degrees.
+	MaxArmAngle = 90
+	// How far the limbs swing, in degrees, for the walking preset.
+	WalkingAngle = 15
+)
+
+// Whether any of the options require the limbs to be posed.
+func (opts Options) isPosed() bool {
+	return opts.ArmAngle != 0 || opts.Walking
+}
+
+// Returns the angles (clockwise, in degrees) for the right arm, left arm,
+// right leg and left leg. The "right" limbs are those on the left of the
+// image, as we're looking at the front of the player. Walking swings each
+// arm against the other, and each leg against its arm, as in a stride.
+func (opts Options) limbAngles() (ra, la, rl, ll float64) {
+	ra, la = opts.ArmAngle, -opts.ArmAngle
+	if opts.Walking {
+		ra += WalkingAngle
+		la -= WalkingAngle
+		rl -= WalkingAngle
+		ll += WalkingAngle
+	}
+	return
+}
+
+// Returns the torso, arms and legs of the skin as separate images, with the
+// armor layer drawn over each of them if requested.
+func (skin *Render) bodyParts(armor bool) (torso, ra, la, rl, ll *image.NRGBA) {
+	torso = skin.crop(skin.Image, image.Rect(TorsoX, TorsoY, TorsoX+TorsoWidth, TorsoY+TorsoHeight))
+	ra = skin.crop(skin.Image, image.Rect(RaX, RaY, RaX+RaWidth, RaY+RaHeight))
+	rl = skin.crop(skin.Image, image.Rect(RlX, RlY, RlX+RlWidth, RlY+RlHeight))
+
+	// Old skins don't have a left arm or leg, so we'll flip their right ones.
+	if !skin.is18Skin() {
+		return torso, ra, imaging.FlipH(ra), rl, imaging.FlipH(rl)
+	}
+
+	la = skin.crop(skin.Image, image.Rect(LaX, LaY, LaX+LaWidth, LaY+LaHeight))
+	ll = skin.crop(skin.Image, image.Rect(LlX, LlY, LlX+LlWidth, LlY+LlHeight))
+
+	if armor {
+		layers := []struct {
+			part *image.NRGBA
+			rect image.Rectangle
+		}{
+			{torso, image.Rect(Torso2X, Torso2Y, Torso2X+TorsoWidth, Torso2Y+TorsoHeight)},
+			{ra, image.Rect(Ra2X, Ra2Y, Ra2X+RaWidth, Ra2Y+RaHeight)},
+			{la, image.Rect(La2X, La2Y, La2X+LaWidth, La2Y+LaHeight)},
+			{rl, image.Rect(Rl2X, Rl2Y, Rl2X+RlWidth, Rl2Y+RlHeight)},
+			{ll, image.Rect(Ll2X, Ll2Y, Ll2X+LlWidth, Ll2Y+LlHeight)},
+		}
+		for _, layer := range layers {
+			overlay := skin.crop(skin.Image, layer.rect)
+			skin.removeAlpha(overlay)
+			fastDraw(layer.part, overlay, 0, 0)
+		}
+	}
+
+	return torso, ra, la, rl, ll
+}
+
+// Returns a front render of the body with the limbs rotated about their
+// shoulders and hips. The canvas is widened to fit the swung out limbs.
+func (skin *Render) renderPosedBody(armor bool) *image.NRGBA {
+	raAngle, laAngle, rlAngle, llAngle := skin.Options.limbAngles()
+
+	// Work out how far the furthest limb can reach past the body.
+	reach := 0.0
+	for _, angle := range []float64{raAngle, laAngle, rlAngle, llAngle} {
+		reach = math.Max(reach, math.Abs(math.Sin(angle*math.Pi/180))*RaHeight)
+	}
+	margin := int(math.Ceil(reach)) + 1
+
+	base := image.NewNRGBA(image.Rect(0, 0, LaWidth+TorsoWidth+RaWidth+margin*2, HeadHeight+TorsoHeight+LlHeight))
+	torso, ra, la, rl, ll := skin.bodyParts(armor)
+
+	var head *image.NRGBA
+	if armor {
+		head = skin.cropHelm(skin.Image).(*image.NRGBA)
+	} else {
+		head = skin.cropHead(skin.Image).(*image.NRGBA)
+	}
+
+	// Limbs go down first so the torso covers their pivots.
+	shoulderY := float64(HeadHeight + 2)
+	hipY := float64(HeadHeight + TorsoHeight)
+	drawRotated(base, ra, raAngle, RaWidth/2, 2, float64(margin+RaWidth/2), shoulderY)
+	drawRotated(base, la, laAngle, LaWidth/2, 2, float64(margin+RaWidth+TorsoWidth+LaWidth/2), shoulderY)
+	drawRotated(base, rl, rlAngle, RlWidth/2, 0, float64(margin+RaWidth+RlWidth/2), hipY)
+	drawRotated(base, ll, llAngle, LlWidth/2, 0, float64(margin+RaWidth+RlWidth+LlWidth/2), hipY)
+
+	fastDraw(base, torso, margin+RaWidth, HeadHeight)
+	fastDraw(base, head, margin+RaWidth, 0)
+
+	base = skin.addTail(base, margin+RaWidth, HeadHeight+TorsoHeight)
+	return skin.addEars(base, margin+RaWidth, 0)
+}
+
+// Draws "src" onto "dst", rotated clockwise by "degrees" about the pivot
+// (srcX, srcY), such that the pivot lands on (dstX, dstY). Like fastDraw,
+// transparent pixels are skipped and all others are drawn fully opaque.
+func drawRotated(dst, src *image.NRGBA, degrees, srcX, srcY, dstX, dstY float64) {
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	srcBounds := src.Bounds()
+	dstBounds := dst.Bounds()
+
+	for y := 0; y < dstBounds.Max.Y; y++ {
+		for x := 0; x < dstBounds.Max.X; x++ {
+			// Map the centre of the destination pixel back onto the source.
+			vx := float64(x) + 0.5 - dstX
+			vy := float64(y) + 0.5 - dstY
+			sx := int(math.Floor(vx*cos + vy*sin + srcX))
+			sy := int(math.Floor(-vx*sin + vy*cos + srcY))
+			if sx < 0 || sy < 0 || sx >= srcBounds.Max.X || sy >= srcBounds.Max.Y {
+				continue
+			}
+
+			srcPx := sy*src.Stride + sx*4
+			if src.Pix[srcPx+3] == 0 {
+				continue
+			}
+			dstPx := y*dst.Stride + x*4
+			dst.Pix[dstPx+0] = src.Pix[srcPx+0]
+			dst.Pix[dstPx+1] = src.Pix[srcPx+1]
+			dst.Pix[dstPx+2] = src.Pix[srcPx+2]
+			dst.Pix[dstPx+3] = 0xFF
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package mcskin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"testing"
+
+	"github.com/minotar/minecraft"
+)
+
+// Returns a 64x64 skin with every pixel a different colour, so any part
+// drawn from the wrong place, or turned the wrong way, changes the render.
+func noiseSkin() *Render {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			c := color.NRGBAModel.Convert(palette.Plan9[(x*7+y*13)%len(palette.Plan9)]).(color.NRGBA)
+			c.R ^= uint8(x * 4)
+			c.G ^= uint8(y * 4)
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return &Render{Skin: minecraft.Skin{Texture: minecraft.Texture{Image: img}}, Mode: "None"}
+}
+
+func renderHash(img image.Image) string {
+	sum := sha256.Sum256(img.(*image.NRGBA).Pix)
+	return hex.EncodeToString(sum[:8])
+}
+
+func TestPosedBody(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		armAngle float64
+		walking  bool
+		hash     string
+	}{
+		{"armangle", 45, false, "1ede3b129f574eca"},
+		{"walking", 0, true, "40db90f8473a7cd8"},
+		{"both", 30, true, "db7fa9e41aae731a"},
+	} {
+		skin := noiseSkin()
+		skin.Options.ArmAngle, skin.Options.Walking = test.armAngle, test.walking
+		skin.GetBody(0)
+		if hash := renderHash(skin.Processed); hash != test.hash {
+			t.Errorf("Expected the %s render to hash to %s, got %s", test.name, test.hash, hash)
+		}
+	}
+}
+
+func TestWalkingStrides(t *testing.T) {
+	ra, la, rl, ll := Options{Walking: true}.limbAngles()
+	if ra != -la || rl != -ll || ra != -rl {
+		t.Fatalf("Expected each arm to swing against the other, and its leg, got %v %v %v %v", ra, la, rl, ll)
+	}
+}
+
+func TestPosedBodyWithEars(t *testing.T) {
+	skin := earsSkin(earsBlue, earsRed)
+	skin.Options.Walking = true
+	skin.GetBody(0)
+	plain := earsSkin(earsBlue, earsRed)
+	plain.Options.Walking, plain.Options.Ears = true, false
+	plain.GetBody(0)
+	if skin.Processed.Bounds().Dy() != plain.Processed.Bounds().Dy()+EarsHeight {
+		t.Fatalf("Expected the posed render to grow to fit the ears, got %v and %v", skin.Processed.Bounds(), plain.Processed.Bounds())
+	}
+}
@@ -1,20 +1,27 @@
-package main
+package mcskin
 
 import (
 	"image"
 	"image/color"
 	"image/draw"
-	"image/png"
+	"image/gif"
 	"io"
 	"math"
 	"strconv"
+	"time"
 
+	"github.com/HugoSmits86/nativewebp"
 	"github.com/ajstarks/svgo"
 	"github.com/disintegration/gift"
 	"github.com/disintegration/imaging"
 	"github.com/minotar/minecraft"
 )
 
+// Version of the renders. Bump it with any change which draws a skin any
+// differently, so renders cached by an older version aren't served in place
+// of the new ones.
+const Version = 2
+
 const (
 	HeadX      = 8
 	HeadY      = 8
@@ -68,30 +75,70 @@ const (
 	BustHeight = 16
 )
 
-type mcSkin struct {
+// Options are the per-request tweaks to how a render is drawn.
+type Options struct {
+	// Degrees each arm is swung out from the body (body renders only).
+	ArmAngle float64
+	// Swings the limbs as if mid-stride (body renders only).
+	Walking bool
+	// Crops fully transparent rows and columns from the edges of the render.
+	Trim bool
+	// Text drawn beneath the render, if any.
+	Label string
+	// Draws the ears and tail of skins made for the Ears mod (flat head
+	// and body renders only).
+	Ears bool
+	// Drawn behind the render, under any label too.
+	Background Background
+}
+
+// Render draws one skin. Set Skin, and Options if any, then call one of the
+// Get methods, which leave the render in Processed ready to be written out.
+type Render struct {
 	Processed image.Image
-	Mode      string
+	// How the render is resized; "None" leaves it at the skin's scale.
+	Mode    string
+	Options Options
+	// Whether the skin is for the slim model.
+	Slim bool
+	// How long the render took, stage by stage.
+	Timings Timings
 	minecraft.Skin
+
+	// The resized image, if it's from the pool.
+	pooled *image.NRGBA
+}
+
+// Timings are how long each stage of a render took.
+type Timings struct {
+	// Cutting the parts we need out of the skin.
+	Extract time.Duration
+	// Drawing them together, and whatever's done to the finished render.
+	Composite time.Duration
+	// Resizing to the width asked for.
+	Scale time.Duration
+	// Encoding as PNG or SVG.
+	Encode time.Duration
 }
 
 // Sets skin.Processed to the face of the user.
-func (skin *mcSkin) GetHead(width int) error {
-	skin.Processed = skin.cropHead(skin.Image)
+func (skin *Render) GetHead(width int) error {
+	skin.Processed = skin.addEars(skin.cropHead(skin.Image).(*image.NRGBA), 0, 0)
 	skin.resize(width, imaging.NearestNeighbor)
 	return nil
 }
 
 // Sets skin.Processed to the face of the user overlaid with their helmet.
-func (skin *mcSkin) GetHelm(width int) error {
-	skin.Processed = skin.cropHelm(skin.Image)
+func (skin *Render) GetHelm(width int) error {
+	skin.Processed = skin.addEars(skin.cropHelm(skin.Image).(*image.NRGBA), 0, 0)
 	skin.resize(width, imaging.NearestNeighbor)
 	return nil
 }
 
 // Sets skin.Processed to an isometric render of the head from a top-left angle (showing 3 sides).
-func (skin *mcSkin) GetCube(width int) error {
+func (skin *Render) GetCube(width int) error {
 	// Crop out the top of the head
-	topFlat := imaging.Crop(skin.Image, image.Rect(8, 0, 16, 8))
+	topFlat := skin.crop(skin.Image, image.Rect(8, 0, 16, 8))
 	// Resize appropriately, so that it fills the `width` when rotated 45 def.
 	topFlat = imaging.Resize(topFlat, int(float64(width)*math.Sqrt(2)/3+1), 0, imaging.NearestNeighbor)
 	// Create the Gift filter
@@ -106,7 +153,7 @@ func (skin *mcSkin) GetCube(width int) error {
 	// Skew the front and sides at 15 degree angles to match up with the
 	// head that has been smushed
 	front := skin.cropHead(skin.Image).(*image.NRGBA)
-	side := imaging.Crop(skin.Image, image.Rect(0, 8, 8, 16))
+	side := skin.crop(skin.Image, image.Rect(0, 8, 8, 16))
 	front = imaging.Resize(front, width/2, int(float64(width)/1.75), imaging.NearestNeighbor)
 	side = imaging.Resize(side, width/2, int(float64(width)/1.75), imaging.NearestNeighbor)
 	front = skewVertical(front, math.Pi/12)
@@ -124,14 +171,14 @@ func (skin *mcSkin) GetCube(width int) error {
 }
 
 // Sets skin.Processed to the upper portion of the body (slightly higher cutoff than waist).
-func (skin *mcSkin) GetBust(width int) error {
+func (skin *Render) GetBust(width int) error {
 	headImg := skin.cropHead(skin.Image).(*image.NRGBA)
 	upperBodyImg := skin.renderUpperBody()
 
 	bustImg := skin.addHead(upperBodyImg, headImg)
 
 	bustImg.Rect.Max.Y = BustHeight
-	skin.Processed = bustImg
+	skin.Processed = skin.addEars(bustImg, LaWidth, 0)
 
 	skin.resize(width, imaging.NearestNeighbor)
 
@@ -139,14 +186,14 @@ func (skin *mcSkin) GetBust(width int) error {
 }
 
 // Sets skin.Processed to the upper portion of the body (slightly higher cutoff than waist) but with any armor which the user has.
-func (skin *mcSkin) GetArmorBust(width int) error {
+func (skin *Render) GetArmorBust(width int) error {
 	helmImg := skin.cropHelm(skin.Image).(*image.NRGBA)
 	upperArmorImg := skin.renderUpperArmor()
 
 	bustImg := skin.addHead(upperArmorImg, helmImg)
 
 	bustImg.Rect.Max.Y = BustHeight
-	skin.Processed = bustImg
+	skin.Processed = skin.addEars(bustImg, LaWidth, 0)
 
 	skin.resize(width, imaging.NearestNeighbor)
 
@@ -154,13 +201,20 @@ func (skin *mcSkin) GetArmorBust(width int) error {
 }
 
 // Sets skin.Processed to a front render of the body.
-func (skin *mcSkin) GetBody(width int) error {
+func (skin *Render) GetBody(width int) error {
+	if skin.Options.isPosed() {
+		skin.Processed = skin.renderPosedBody(false)
+		skin.resize(width, imaging.NearestNeighbor)
+		return nil
+	}
+
 	headImg := skin.cropHead(skin.Image).(*image.NRGBA)
 	upperBodyImg := skin.renderUpperBody()
 	lowerBodyImg := skin.renderLowerBody()
 
 	bodyImg := skin.addHead(upperBodyImg, headImg)
-	skin.Processed = skin.addLegs(bodyImg, lowerBodyImg)
+	bodyImg = skin.addTail(skin.addLegs(bodyImg, lowerBodyImg), LaWidth, HeadHeight+TorsoHeight)
+	skin.Processed = skin.addEars(bodyImg, LaWidth, 0)
 
 	skin.resize(width, imaging.NearestNeighbor)
 
@@ -168,13 +222,20 @@ func (skin *mcSkin) GetBody(width int) error {
 }
 
 // Sets skin.Processed to a front render of the body but with any armor which the user has.
-func (skin *mcSkin) GetArmorBody(width int) error {
+func (skin *Render) GetArmorBody(width int) error {
+	if skin.Options.isPosed() {
+		skin.Processed = skin.renderPosedBody(true)
+		skin.resize(width, imaging.NearestNeighbor)
+		return nil
+	}
+
 	helmImg := skin.cropHelm(skin.Image).(*image.NRGBA)
 	upperArmorImg := skin.renderUpperArmor()
 	lowerArmorImg := skin.renderLowerArmor()
 
 	bodyImg := skin.addHead(upperArmorImg, helmImg)
-	skin.Processed = skin.addLegs(bodyImg, lowerArmorImg)
+	bodyImg = skin.addTail(skin.addLegs(bodyImg, lowerArmorImg), LaWidth, HeadHeight+TorsoHeight)
+	skin.Processed = skin.addEars(bodyImg, LaWidth, 0)
 
 	skin.resize(width, imaging.NearestNeighbor)
 
@@ -182,17 +243,17 @@ func (skin *mcSkin) GetArmorBody(width int) error {
 }
 
 // Returns the torso and arms.
-func (skin *mcSkin) renderUpperBody() *image.NRGBA {
+func (skin *Render) renderUpperBody() *image.NRGBA {
 	// This will be the base.
 	upperBodyImg := image.NewNRGBA(image.Rect(0, 0, LaWidth+TorsoWidth+RaWidth, TorsoHeight))
 
-	torsoImg := imaging.Crop(skin.Image, image.Rect(TorsoX, TorsoY, TorsoX+TorsoWidth, TorsoY+TorsoHeight))
-	raImg := imaging.Crop(skin.Image, image.Rect(RaX, RaY, RaX+RaWidth, RaY+TorsoHeight))
+	torsoImg := skin.crop(skin.Image, image.Rect(TorsoX, TorsoY, TorsoX+TorsoWidth, TorsoY+TorsoHeight))
+	raImg := skin.crop(skin.Image, image.Rect(RaX, RaY, RaX+RaWidth, RaY+TorsoHeight))
 
 	// If it's an old skin, they don't have a Left Arm, so we'll just flip their right.
 	var laImg image.Image
 	if skin.is18Skin() {
-		laImg = imaging.Crop(skin.Image, image.Rect(LaX, LaY, LaX+LaWidth, LaY+TorsoHeight))
+		laImg = skin.crop(skin.Image, image.Rect(LaX, LaY, LaX+LaWidth, LaY+TorsoHeight))
 	} else {
 		laImg = imaging.FlipH(raImg)
 	}
@@ -201,20 +262,20 @@ func (skin *mcSkin) renderUpperBody() *image.NRGBA {
 }
 
 // Returns the torso and arms but with any armor which the user has.
-func (skin *mcSkin) renderUpperArmor() *image.NRGBA {
+func (skin *Render) renderUpperArmor() *image.NRGBA {
 	// This will be the base.
 	upperArmorBodyImg := skin.renderUpperBody()
 
 	// If it's an old skin, they don't have armor here.
 	if skin.is18Skin() {
 		// Get the armor layers from the skin and remove the Alpha.
-		torso2Img := imaging.Crop(skin.Image, image.Rect(Torso2X, Torso2Y, Torso2X+TorsoWidth, Torso2Y+TorsoHeight))
+		torso2Img := skin.crop(skin.Image, image.Rect(Torso2X, Torso2Y, Torso2X+TorsoWidth, Torso2Y+TorsoHeight))
 		skin.removeAlpha(torso2Img)
 
-		la2Img := imaging.Crop(skin.Image, image.Rect(La2X, La2Y, La2X+LaWidth, La2Y+TorsoHeight))
+		la2Img := skin.crop(skin.Image, image.Rect(La2X, La2Y, La2X+LaWidth, La2Y+TorsoHeight))
 		skin.removeAlpha(la2Img)
 
-		ra2Img := imaging.Crop(skin.Image, image.Rect(Ra2X, Ra2Y, Ra2X+RaWidth, Ra2Y+TorsoHeight))
+		ra2Img := skin.crop(skin.Image, image.Rect(Ra2X, Ra2Y, Ra2X+RaWidth, Ra2Y+TorsoHeight))
 		skin.removeAlpha(ra2Img)
 
 		return skin.drawUpper(upperArmorBodyImg, torso2Img, ra2Img, la2Img)
@@ -223,7 +284,7 @@ func (skin *mcSkin) renderUpperArmor() *image.NRGBA {
 }
 
 // Given a base, torso and arms, it will return them all arranged correctly.
-func (skin *mcSkin) drawUpper(base, torso, la, ra *image.NRGBA) *image.NRGBA {
+func (skin *Render) drawUpper(base, torso, la, ra *image.NRGBA) *image.NRGBA {
 	// Torso
 	fastDraw(base, torso, LaWidth, 0)
 	// Left Arm
@@ -235,16 +296,16 @@ func (skin *mcSkin) drawUpper(base, torso, la, ra *image.NRGBA) *image.NRGBA {
 }
 
 // Returns the legs.
-func (skin *mcSkin) renderLowerBody() *image.NRGBA {
+func (skin *Render) renderLowerBody() *image.NRGBA {
 	// This will be the base.
 	lowerBodyImg := image.NewNRGBA(image.Rect(0, 0, LlWidth+RlWidth, LlHeight))
 
-	rlImg := imaging.Crop(skin.Image, image.Rect(RlX, RlY, RlX+RlWidth, RlY+RlHeight))
+	rlImg := skin.crop(skin.Image, image.Rect(RlX, RlY, RlX+RlWidth, RlY+RlHeight))
 
 	// If it's an old skin, they don't have a Left Leg, so we'll just flip their right.
 	var llImg image.Image
 	if skin.is18Skin() {
-		llImg = imaging.Crop(skin.Image, image.Rect(LlX, LlY, LlX+LlWidth, LlY+LlHeight))
+		llImg = skin.crop(skin.Image, image.Rect(LlX, LlY, LlX+LlWidth, LlY+LlHeight))
 	} else {
 		llImg = imaging.FlipH(rlImg)
 	}
@@ -253,17 +314,17 @@ func (skin *mcSkin) renderLowerBody() *image.NRGBA {
 }
 
 // Returns the legs but with any armor which the user has.
-func (skin *mcSkin) renderLowerArmor() *image.NRGBA {
+func (skin *Render) renderLowerArmor() *image.NRGBA {
 	// This will be the base.
 	lowerArmorBodyImg := skin.renderLowerBody()
 
 	// If it's an old skin, they don't have armor here.
 	if skin.is18Skin() {
 		// Get the armor layers from the skin and remove the Alpha.
-		ll2Img := imaging.Crop(skin.Image, image.Rect(Ll2X, Ll2Y, Ll2X+LlWidth, Ll2Y+LlHeight))
+		ll2Img := skin.crop(skin.Image, image.Rect(Ll2X, Ll2Y, Ll2X+LlWidth, Ll2Y+LlHeight))
 		skin.removeAlpha(ll2Img)
 
-		rl2Img := imaging.Crop(skin.Image, image.Rect(Rl2X, Rl2Y, Rl2X+RlWidth, Rl2Y+RlHeight))
+		rl2Img := skin.crop(skin.Image, image.Rect(Rl2X, Rl2Y, Rl2X+RlWidth, Rl2Y+RlHeight))
 		skin.removeAlpha(rl2Img)
 
 		return skin.drawLower(lowerArmorBodyImg, ll2Img, rl2Img)
@@ -272,7 +333,7 @@ func (skin *mcSkin) renderLowerArmor() *image.NRGBA {
 }
 
 // Given a base and legs, it will return them all arranged correctly.
-func (skin *mcSkin) drawLower(base, ll, rl *image.NRGBA) *image.NRGBA {
+func (skin *Render) drawLower(base, ll, rl *image.NRGBA) *image.NRGBA {
 	// Left Leg
 	fastDraw(base, ll, 0, 0)
 	// Right Leg
@@ -282,7 +343,7 @@ func (skin *mcSkin) drawLower(base, ll, rl *image.NRGBA) *image.NRGBA {
 }
 
 // Rams the head onto the base (hopefully body...) to return a Frankenstein.
-func (skin *mcSkin) addHead(base, head *image.NRGBA) *image.NRGBA {
+func (skin *Render) addHead(base, head *image.NRGBA) *image.NRGBA {
 	base.Pix = append(make([]uint8, HeadHeight*base.Stride), base.Pix...)
 	base.Rect.Max.Y += HeadHeight
 	fastDraw(base, head, LaWidth, 0)
@@ -291,7 +352,7 @@ func (skin *mcSkin) addHead(base, head *image.NRGBA) *image.NRGBA {
 }
 
 // Attached the legs onto the base (likely body).
-func (skin *mcSkin) addLegs(base, legs *image.NRGBA) *image.NRGBA {
+func (skin *Render) addLegs(base, legs *image.NRGBA) *image.NRGBA {
 	base.Pix = append(base.Pix, make([]uint8, LlHeight*base.Stride)...)
 	base.Rect.Max.Y += LlHeight
 	fastDraw(base, legs, LaWidth, HeadHeight+TorsoHeight)
@@ -299,13 +360,37 @@ func (skin *mcSkin) addLegs(base, legs *image.NRGBA) *image.NRGBA {
 	return base
 }
 
+// Applies the options which act on the finished render.
+func (skin *Render) PostProcess() {
+	if skin.Options.Trim {
+		skin.Processed = trimTransparent(skin.Processed)
+	}
+	if skin.Options.Label != "" {
+		skin.drawLabel(skin.Options.Label)
+	}
+	if !skin.Options.Background.IsZero() {
+		skin.drawBackground(skin.Options.Background)
+	}
+}
+
 // Writes the *processed* image as a PNG to the given writer.
-func (skin *mcSkin) WritePNG(w io.Writer) error {
-	return png.Encode(w, skin.Processed)
+func (skin *Render) WritePNG(w io.Writer) error {
+	return pngEncoder.Encode(w, skin.Processed)
+}
+
+// Writes the processed image as a lossless WebP, which is usually smaller
+// than the PNG.
+func (skin *Render) WriteWebP(w io.Writer) error {
+	return nativewebp.Encode(w, skin.Processed, nil)
+}
+
+// Writes the processed image as a GIF.
+func (skin *Render) WriteGIF(w io.Writer) error {
+	return gif.Encode(w, paletted(skin.Processed), nil)
 }
 
 // Writes the processed image as an svg.
-func (skin *mcSkin) WriteSVG(w io.Writer) error {
+func (skin *Render) WriteSVG(w io.Writer) error {
 	canvas := svg.New(w)
 	bounds := skin.Processed.Bounds()
 	img := skin.Processed.(*image.NRGBA)
@@ -332,19 +417,36 @@ func (skin *mcSkin) WriteSVG(w io.Writer) error {
 }
 
 // Writes the *original* skin image as a png to the given writer.
-func (skin *mcSkin) WriteSkin(w io.Writer) error {
-	return png.Encode(w, skin.Image)
+func (skin *Render) WriteSkin(w io.Writer) error {
+	return pngEncoder.Encode(w, skin.Image)
 }
 
 // Resizes the skin to the given dimensions, keeping aspect ratio.
-func (skin *mcSkin) resize(width int, filter imaging.ResampleFilter) {
+func (skin *Render) resize(width int, filter imaging.ResampleFilter) {
 	if skin.Mode != "None" {
-		skin.Processed = imaging.Resize(skin.Processed, width, 0, filter)
+		start := time.Now()
+		src, ok := skin.Processed.(*image.NRGBA)
+		if ok && filter.Support <= 0 && width > 0 && !src.Bounds().Empty() {
+			resized := resizeNearest(src, width)
+			skin.Release()
+			skin.pooled, skin.Processed = resized, resized
+		} else {
+			skin.Processed = imaging.Resize(skin.Processed, width, 0, filter)
+		}
+		skin.Timings.Scale += time.Since(start)
 	}
 }
 
+// Cuts part of a skin out, as the first stage of a render.
+func (skin *Render) crop(img image.Image, rect image.Rectangle) *image.NRGBA {
+	start := time.Now()
+	cropped := imaging.Crop(img, rect)
+	skin.Timings.Extract += time.Since(start)
+	return cropped
+}
+
 // Removes the skin's alpha matte from the given image.
-func (skin *mcSkin) removeAlpha(img *image.NRGBA) {
+func (skin *Render) removeAlpha(img *image.NRGBA) {
 	// If it's already a transparent image, do nothing
 	if skin.AlphaSig[3] == 0 {
 		return
@@ -363,26 +465,81 @@ func (skin *mcSkin) removeAlpha(img *image.NRGBA) {
 }
 
 // Checks if the skin is a 1.8 skin using its height.
-func (skin *mcSkin) is18Skin() bool {
+func (skin *Render) is18Skin() bool {
 	bounds := skin.Image.Bounds()
 	return bounds.Max.Y == 64
 }
 
+// IsSlim returns whether the skin is drawn for the slim model. Only the
+// session profile says so outright, so this looks for the two columns of
+// each arm which a slim skin leaves empty.
+func IsSlim(skin minecraft.Skin) bool {
+	if skin.Image == nil {
+		return false
+	}
+	bounds := skin.Image.Bounds()
+	if bounds.Dx() != 64 || bounds.Dy() != 64 {
+		return false
+	}
+	for y := RaY; y < RaY+RaHeight; y++ {
+		for x := 54; x < 56; x++ {
+			if _, _, _, a := skin.Image.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA(); a != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 // Returns the head of the skin image.
-func (skin *mcSkin) cropHead(img image.Image) image.Image {
-	return imaging.Crop(img, image.Rect(HeadX, HeadY, HeadX+HeadWidth, HeadY+HeadHeight))
+func (skin *Render) cropHead(img image.Image) image.Image {
+	return skin.crop(img, image.Rect(HeadX, HeadY, HeadX+HeadWidth, HeadY+HeadHeight))
 }
 
 // Returns the head of the skin image overlayed with the helm.
-func (skin *mcSkin) cropHelm(img image.Image) image.Image {
+func (skin *Render) cropHelm(img image.Image) image.Image {
 	headImg := skin.cropHead(img)
-	helmImg := imaging.Crop(img, image.Rect(HelmX, HelmY, HelmX+HeadWidth, HelmY+HeadHeight))
+	helmImg := skin.crop(img, image.Rect(HelmX, HelmY, HelmX+HeadWidth, HelmY+HeadHeight))
 	skin.removeAlpha(helmImg)
 	fastDraw(headImg.(*image.NRGBA), helmImg, 0, 0)
 
 	return headImg
 }
 
+// Returns the image cropped down to the smallest rectangle holding all of
+// its non-transparent pixels. Fully transparent images are left alone.
+func trimTransparent(img image.Image) image.Image {
+	src, ok := img.(*image.NRGBA)
+	if !ok {
+		src = imaging.Clone(img)
+	}
+	bounds := src.Bounds()
+	trimmed := image.Rectangle{Min: bounds.Max, Max: bounds.Min}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := src.Pix[src.PixOffset(bounds.Min.X, y):]
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if row[(x-bounds.Min.X)*4+3] == 0 {
+				continue
+			}
+			if x < trimmed.Min.X {
+				trimmed.Min.X = x
+			}
+			if x >= trimmed.Max.X {
+				trimmed.Max.X = x + 1
+			}
+			if y < trimmed.Min.Y {
+				trimmed.Min.Y = y
+			}
+			trimmed.Max.Y = y + 1
+		}
+	}
+
+	if trimmed.Empty() || trimmed == bounds {
+		return img
+	}
+	return imaging.Crop(src, trimmed)
+}
+
 // Draws the "src" onto the "dst" image at the given x/y bounds, maintaining
 // the original size. Pixels with have an alpha of 0x00 are not draw, and
 // all others are drawn with an alpha of 0xFF
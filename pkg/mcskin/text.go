@@ -0,0 +1,135 @@
+package mcskin
+
+import (
+	"image"
+	"image/color"
+	"unicode"
+)
+
+// Longest text DrawText will draw, in characters, not counting formatting
+// codes.
+const MaxTextLength = 64
+
+// Starts a formatting code, as in Minecraft's chat, signs and MOTDs.
+const FormatPrefix = '§'
+
+// The colours of Minecraft's colour codes.
+var formatColors = map[rune]color.NRGBA{
+	'0': {0x00, 0x00, 0x00, 0xFF},
+	'1': {0x00, 0x00, 0xAA, 0xFF},
+	'2': {0x00, 0xAA, 0x00, 0xFF},
+	'3': {0x00, 0xAA, 0xAA, 0xFF},
+	'4': {0xAA, 0x00, 0x00, 0xFF},
+	'5': {0xAA, 0x00, 0xAA, 0xFF},
+	'6': {0xFF, 0xAA, 0x00, 0xFF},
+	'7': {0xAA, 0xAA, 0xAA, 0xFF},
+	'8': {0x55, 0x55, 0x55, 0xFF},
+	'9': {0x55, 0x55, 0xFF, 0xFF},
+	'a': {0x55, 0xFF, 0x55, 0xFF},
+	'b': {0x55, 0xFF, 0xFF, 0xFF},
+	'c': {0xFF, 0x55, 0x55, 0xFF},
+	'd': {0xFF, 0x55, 0xFF, 0xFF},
+	'e': {0xFF, 0xFF, 0x55, 0xFF},
+	'f': {0xFF, 0xFF, 0xFF, 0xFF},
+}
+
+// How a character is to be drawn, once the formatting codes before it are
+// applied.
+type formattedGlyph struct {
+	columns []uint8
+	// Where it starts, in font pixels.
+	x         int
+	color     color.NRGBA
+	bold      bool
+	strike    bool
+	underline bool
+}
+
+// Applies the formatting codes in the text, returning each character to be
+// drawn and the width of them all in font pixels. A colour resets the
+// formatting, as in game; obfuscated and italic text is drawn plainly.
+func layoutText(text string) ([]formattedGlyph, int) {
+	glyphs := []formattedGlyph{}
+	style := formattedGlyph{color: formatColors['f']}
+	x := 0
+	code := false
+	for _, r := range text {
+		if code {
+			code = false
+			r = unicode.ToLower(r)
+			if c, ok := formatColors[r]; ok {
+				style = formattedGlyph{color: c}
+			}
+			switch r {
+			case 'l':
+				style.bold = true
+			case 'm':
+				style.strike = true
+			case 'n':
+				style.underline = true
+			case 'r':
+				style = formattedGlyph{color: formatColors['f']}
+			}
+			continue
+		}
+		if r == FormatPrefix {
+			code = true
+			continue
+		}
+		if len(glyphs) == MaxTextLength {
+			break
+		}
+
+		glyph := style
+		glyph.columns = glyphColumns(r)
+		glyph.x = x
+		glyphs = append(glyphs, glyph)
+		x += len(glyph.columns) + GlyphSpacing
+		if glyph.bold {
+			x++
+		}
+	}
+	if x > 0 {
+		x -= GlyphSpacing
+	}
+	return glyphs, x
+}
+
+// DrawText draws the text in the font, each font pixel scale pixels square,
+// with Minecraft's formatting codes applied and its drop shadow, on a
+// transparent image just big enough for it.
+func DrawText(text string, scale int) *image.NRGBA {
+	if scale < 1 {
+		scale = 1
+	}
+	glyphs, width := layoutText(text)
+	// Room for the shadow, and an underline below the descenders.
+	img := image.NewNRGBA(image.Rect(0, 0, (width+1)*scale, (GlyphHeight+2)*scale))
+
+	for _, shadow := range []bool{true, false} {
+		offset := 0
+		if shadow {
+			offset = scale
+		}
+		for _, glyph := range glyphs {
+			c := glyph.color
+			if shadow {
+				c = color.NRGBA{c.R / 4, c.G / 4, c.B / 4, c.A}
+			}
+			x := glyph.x*scale + offset
+			drawGlyph(img, glyph.columns, x, offset, scale, c)
+			advance := len(glyph.columns) + GlyphSpacing
+			if glyph.bold {
+				drawGlyph(img, glyph.columns, x+scale, offset, scale, c)
+				advance++
+			}
+			if glyph.strike {
+				fillRect(img, image.Rect(x, offset+GlyphHeight/2*scale, x+advance*scale, offset+(GlyphHeight/2+1)*scale), c)
+			}
+			if glyph.underline {
+				fillRect(img, image.Rect(x, offset+GlyphHeight*scale, x+advance*scale, offset+(GlyphHeight+1)*scale), c)
+			}
+		}
+	}
+	return img
+}
@@ -0,0 +1,50 @@
+package mcskin
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestLayoutText(t *testing.T) {
+	glyphs, width := layoutText("§cA§lB§rC§zD")
+	if len(glyphs) != 4 {
+		t.Fatalf("Expected the codes not to be drawn, got %d glyphs", len(glyphs))
+	}
+	if glyphs[0].color != formatColors['c'] || glyphs[0].bold {
+		t.Fatalf("Expected A to be plain red, got %+v", glyphs[0])
+	}
+	if glyphs[1].color != formatColors['c'] || !glyphs[1].bold {
+		t.Fatalf("Expected B to be bold red, got %+v", glyphs[1])
+	}
+	if glyphs[2].color != formatColors['f'] || glyphs[2].bold || glyphs[3].color != formatColors['f'] {
+		t.Fatalf("Expected §r to reset, and an unknown code to do nothing, got %+v", glyphs[2:])
+	}
+	// Bold is a pixel wider.
+	if want := measureText("ABCD") + 1; width != want {
+		t.Fatalf("Expected a width of %d, got %d", want, width)
+	}
+
+	if glyphs, _ := layoutText(string(make([]rune, MaxTextLength+10))); len(glyphs) != MaxTextLength {
+		t.Fatalf("Expected the text to be cut at %d characters, got %d", MaxTextLength, len(glyphs))
+	}
+}
+
+func TestDrawText(t *testing.T) {
+	img := DrawText("§9§nHi", 2)
+	width := measureText("Hi")
+	if bounds := img.Bounds(); bounds.Dx() != (width+1)*2 || bounds.Dy() != (GlyphHeight+2)*2 {
+		t.Fatalf("Unexpected bounds %v", bounds)
+	}
+	// H's first column is solid, as is the underline beneath it.
+	blue := formatColors['9']
+	if c := img.NRGBAAt(0, 0); c != blue {
+		t.Fatalf("Expected the H in blue, got %v", c)
+	}
+	if c := img.NRGBAAt(0, GlyphHeight*2); c != blue {
+		t.Fatalf("Expected the underline in blue, got %v", c)
+	}
+	shadow := color.NRGBA{blue.R / 4, blue.G / 4, blue.B / 4, 0xFF}
+	if c := img.NRGBAAt(width*2+1, GlyphHeight*2+2); c != shadow {
+		t.Fatalf("Expected the underline's shadow at the bottom right, got %v", c)
+	}
+}
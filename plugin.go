@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Largest render we'll take back from a renderer plugin.
+	maxPluginRenderSize = 8 << 20
+	// How long plugins have to answer, unless they're given a timeout.
+	defaultPluginTimeout = 10 * time.Second
+)
+
+// A render type drawn by a renderer plugin rather than by us, configured as
+// a [renderer "<type>"] section. It's served under /<type>/ like our own
+// renders, and its renders are cached like them too.
+type ExternalRenderer struct {
+	// URL the skin is POSTed to, as a PNG, with the render's type, size,
+	// format and whether the skin is slim in the query string. The
+	// plugin answers with the render.
+	URL string
+	// Formats the plugin can render, eg. ".png". The first is served when
+	// the client didn't ask for one we have.
+	Format []string
+	// Seconds the plugin has to answer, 0 for defaultPluginTimeout.
+	Timeout int
+}
+
+// A renderer plugin, as set up from its config.
+type pluginRenderer struct {
+	Name    string
+	URL     string
+	Formats []string
+	client  *http.Client
+}
+
+// Render types plugins may draw, eg. "scene" or "scene/full".
+var pluginNameRegex = regexp.MustCompile("^[A-Za-z0-9_-]+(/[A-Za-z0-9_-]+)*$")
+
+// Renderer plugins by the render type they draw.
+var pluginRenderers = map[string]*pluginRenderer{}
+
+// Returns an error if the renderer's section doesn't describe a usable
+// plugin.
+func checkExternalRenderer(name string, renderer *ExternalRenderer) error {
+	for _, resource := range renderResources {
+		if strings.EqualFold(name, resource) {
+			return fmt.Errorf("%q is already a render type", name)
+		}
+	}
+	if !pluginNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid render type %q", name)
+	}
+	if parsed, err := url.Parse(renderer.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return fmt.Errorf("invalid url %q", renderer.URL)
+	}
+	for _, format := range renderer.Format {
+		if _, known := renderFormats[format]; !known {
+			return fmt.Errorf("unknown format %q", format)
+		}
+	}
+	return nil
+}
+
+// Sets up the renderer plugins from the config.
+func makePluginRenderers(renderers map[string]*ExternalRenderer) (map[string]*pluginRenderer, error) {
+	plugins := map[string]*pluginRenderer{}
+	for name, renderer := range renderers {
+		if renderer == nil {
+			continue
+		}
+		if err := checkExternalRenderer(name, renderer); err != nil {
+			return nil, err
+		}
+		formats := renderer.Format
+		if len(formats) == 0 {
+			formats = []string{".png"}
+		}
+		timeout := time.Duration(renderer.Timeout) * time.Second
+		if timeout == 0 {
+			timeout = defaultPluginTimeout
+		}
+		plugins[name] = &pluginRenderer{
+			Name:    name,
+			URL:     renderer.URL,
+			Formats: formats,
+			client:  &http.Client{Timeout: timeout},
+		}
+	}
+	return plugins, nil
+}
+
+// Returns the render types the plugins draw, in order.
+func pluginResources() []string {
+	names := make([]string, 0, len(pluginRenderers))
+	for name := range pluginRenderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the format to ask the plugin for, given the one the client asked
+// for, and whether it can render it. A negotiated format the plugin can't
+// render falls back to the plugin's first.
+func (p *pluginRenderer) format(ext string, negotiated bool) (string, bool) {
+	for _, format := range p.Formats {
+		if format == ext {
+			return ext, true
+		}
+	}
+	return p.Formats[0], negotiated
+}
+
+// Has the plugin render the skin.
+func (p *pluginRenderer) render(ctx context.Context, width uint, ext string, skin *mcSkin) ([]byte, error) {
+	body := new(bytes.Buffer)
+	if err := skin.WriteSkin(body); err != nil {
+		return nil, err
+	}
+	query := url.Values{
+		"type":   {p.Name},
+		"size":   {strconv.FormatUint(uint64(width), 10)},
+		"format": {strings.TrimPrefix(ext, ".")},
+		"slim":   {strconv.FormatBool(skin.Slim)},
+	}
+	target := p.URL
+	if strings.Contains(target, "?") {
+		target += "&" + query.Encode()
+	} else {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", target, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "image/png")
+	req.Header.Set("Accept", renderFormats[ext])
+	req.Header.Set("User-Agent", "imgd/"+ImgdVersion)
+
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	if ctx.Err() != nil {
+		// The request went away or ran out of time, which is no fault of
+		// the plugin's.
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, ctx.Err()
+	} else if err != nil {
+		return nil, p.failed(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, p.failed(fmt.Errorf("%s", resp.Status))
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxPluginRenderSize+1))
+	if err != nil {
+		return nil, p.failed(err)
+	} else if len(data) > maxPluginRenderSize {
+		return nil, p.failed(fmt.Errorf("render over %d bytes", maxPluginRenderSize))
+	}
+	pluginDuration.WithLabelValues(p.Name).Observe(time.Since(start).Seconds())
+	return data, nil
+}
+
+func (p *pluginRenderer) failed(err error) error {
+	log.Warningf("Renderer plugin %s failed (%v)", p.Name, err)
+	stats.Errored(ErrRendererPlugin)
+	return fmt.Errorf("renderer plugin %s: %v", p.Name, err)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/minecraft"
+)
+
+func TestCheckExternalRenderer(t *testing.T) {
+	for name, renderer := range map[string]*ExternalRenderer{
+		"scene":      {URL: "http://127.0.0.1:9000/render", Format: []string{".png", ".webp"}},
+		"scene/full": {URL: "https://renderer.example.com/"},
+	} {
+		if err := checkExternalRenderer(name, renderer); err != nil {
+			t.Errorf("Expected %s to be fine, got %v", name, err)
+		}
+	}
+	for name, renderer := range map[string]*ExternalRenderer{
+		"helm":   {URL: "http://127.0.0.1:9000/render"},
+		"a/../b": {URL: "http://127.0.0.1:9000/render"},
+		"scene":  {URL: "ftp://127.0.0.1/render"},
+		"scenes": {URL: "http://127.0.0.1:9000/render", Format: []string{".bmp"}},
+	} {
+		if err := checkExternalRenderer(name, renderer); err == nil {
+			t.Errorf("Expected %s to be refused", name)
+		}
+	}
+}
+
+func TestPluginRenderer(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	renderCache = MakeRenderCache(1<<20, false)
+	hotCache = MakeHotCache(nil, 0)
+	missingFilter = MakeMissingFilter(0, time.Minute)
+
+	skin, _ := minecraft.FetchSkinForSteve()
+	skin.Hash = "clone1018"
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+
+	var calls int32
+	plugin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		query := r.URL.Query()
+		if r.Method != "POST" || query.Get("type") != "scene" || query.Get("size") != "64" || query.Get("format") != "png" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if _, err := png.Decode(r.Body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("scene"))
+	}))
+	defer plugin.Close()
+
+	var err error
+	pluginRenderers, err = makePluginRenderers(map[string]*ExternalRenderer{"scene": {URL: plugin.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { pluginRenderers = map[string]*pluginRenderer{} }()
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+	serve := func(path string, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		router.Mux.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		w := serve("/scene/d9135e082f2244c89cb10d21ed3ac8fd/64", "image/webp,*/*")
+		if w.Code != http.StatusOK || w.Body.String() != "scene" || w.Header().Get("Content-Type") != "image/png" {
+			t.Fatalf("Expected the plugin's PNG, got %d %q %v", w.Code, w.Body.String(), w.Header())
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("Expected the render to be cached, the plugin was called %d times", calls)
+	}
+	if w := serve("/scene/d9135e082f2244c89cb10d21ed3ac8fd/64.webp", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("Expected a 404 for a format the plugin can't render, got %d", w.Code)
+	}
+	if w := serve("/scene/d9135e082f2244c89cb10d21ed3ac8fd/32.png", ""); w.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected a 500 when the plugin fails, got %d", w.Code)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Keeps the most requested players' skins warm, by refreshing each shortly
+// before it expires rather than waiting for a request to find it gone. The
+// refreshes are spread out, so Mojang sees a steady trickle rather than a
+// burst each time a batch of popular skins expires together.
+type Prefetcher struct {
+	Interval time.Duration
+	// How many of the top players to keep warm.
+	Players int
+	// How long before a skin expires to refresh it.
+	Ahead time.Duration
+	// Refreshes to start a second at most.
+	Rate int
+
+	stop chan struct{}
+}
+
+func MakePrefetcher(interval time.Duration, players int, ahead time.Duration, rate int) *Prefetcher {
+	return &Prefetcher{
+		Interval: interval,
+		Players:  players,
+		Ahead:    ahead,
+		Rate:     rate,
+		stop:     make(chan struct{}),
+	}
+}
+
+func (p *Prefetcher) run() {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.sweep()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// Refreshes the top players whose skins expire within Ahead, or have
+// already, and returns how many it refreshed.
+func (p *Prefetcher) sweep() int {
+	refreshed := 0
+	for _, player := range topPlayers.list(p.Players) {
+		uuid, ok := lookupUUID(player.Name)
+		if !ok {
+			// Their UUID's expired too, so look it up again now.
+			var reason NegativeReason
+			if uuid, reason = resolveUUID(context.Background(), player.Name); reason != NegativeNone {
+				continue
+			}
+		}
+		if !p.due(uuid) {
+			continue
+		}
+
+		if refreshed > 0 && p.Rate > 0 {
+			select {
+			case <-time.After(time.Second / time.Duration(p.Rate)):
+			case <-p.stop:
+				return refreshed
+			}
+		}
+		log.Debugf("Prefetching: %s", player.Name)
+		refresher.refresh(uuid)
+		prefetchCounter.Inc()
+		refreshed++
+	}
+	return refreshed
+}
+
+// Whether the UUID's skin expires within Ahead.
+func (p *Prefetcher) due(uuid string) bool {
+	remaining, ok := cache.(agingCache).expiresIn(uuid)
+	return !ok || remaining-config.staleTtl() < p.Ahead
+}
+
+func (p *Prefetcher) Stop() {
+	close(p.stop)
+}
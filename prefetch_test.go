@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/minotar/minecraft"
+)
+
+func TestPrefetcherDue(t *testing.T) {
+	cache = &CacheMemory{}
+	cache.setup()
+	defer func(stale int) { config.Ttl.Stale = stale }(config.Ttl.Stale)
+	config.Ttl.Stale = 60
+
+	skin := minecraft.Skin{}
+	skin.Hash = "clone1018"
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Hour)
+	cache.add("2f3665cc5e29439bbd14cb6d3a6313a7", skin, 2*time.Minute)
+
+	p := MakePrefetcher(time.Minute, 10, 5*time.Minute, 0)
+	if p.due("d9135e082f2244c89cb10d21ed3ac8fd") {
+		t.Fatal("Expected a skin with most of its hour left not to be due")
+	}
+	// A minute of its two is the stale window, so it expires within Ahead.
+	if !p.due("2f3665cc5e29439bbd14cb6d3a6313a7") {
+		t.Fatal("Expected a skin about to expire to be due")
+	}
+	if !p.due("af74a02d19cb445bb07f6866a861f783") {
+		t.Fatal("Expected a skin which isn't cached to be due")
+	}
+}
+
+func TestPrefetcherSkipsFreshSkins(t *testing.T) {
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	defer func(top *TopPlayers) { topPlayers = top }(topPlayers)
+	topPlayers = MakeTopPlayers(10, time.Hour)
+	topPlayers.record("d9135e082f2244c89cb10d21ed3ac8fd")
+	defer func(stale int) { config.Ttl.Stale = stale }(config.Ttl.Stale)
+	config.Ttl.Stale = 60
+
+	skin := minecraft.Skin{}
+	skin.Hash = "clone1018"
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Hour)
+
+	if refreshed := MakePrefetcher(time.Minute, 10, 5*time.Minute, 0).sweep(); refreshed != 0 {
+		t.Fatalf("Expected nothing to refresh, refreshed %d", refreshed)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/minotar/imgd/pkg/mcskin"
+	"github.com/minotar/minecraft"
+)
+
+// Renders of the default skins, keyed as the render cache is, so players
+// without a skin of their own, and everyone we fall back on, never wait on
+// a render. Set once they've all been drawn, and never evicted.
+var defaultRenders atomic.Pointer[map[string][]byte]
+
+// Returns the default render under the key, if it's one we drew.
+func defaultRender(key string) ([]byte, bool) {
+	renders := defaultRenders.Load()
+	if renders == nil {
+		return nil, false
+	}
+	data, ok := (*renders)[key]
+	return data, ok
+}
+
+// Returns the widths to draw the default renders at: the hot sizes, and the
+// widths renders are without one.
+func defaultRenderWidths() []uint {
+	widths := []uint{DefaultWidth}
+	for _, size := range config.Size {
+		if size != nil {
+			widths = append(widths, size.defaultWidth())
+		}
+	}
+	for _, width := range config.Server.HotSize {
+		if width < MinWidth {
+			width = MinWidth
+		} else if width > MaxWidth {
+			width = MaxWidth
+		}
+		widths = append(widths, width)
+	}
+	return widths
+}
+
+// Draws Steve, Alex and the operator's fallback skin as each of the built
+// in renders, at each of the default widths, in each format clients can be
+// given without asking for one.
+func prerenderDefaults(router *Router) {
+	start := time.Now()
+	steve, _ := minecraft.FetchSkinForSteve()
+	skins := []*mcSkin{
+		{Render: mcskin.Render{Skin: fallbackSkin()}},
+		{Render: mcskin.Render{Skin: steve}},
+	}
+	if alexSkin != nil {
+		skins = append(skins, &mcSkin{Render: mcskin.Render{Skin: *alexSkin, Slim: true}})
+	}
+
+	renders := map[string][]byte{}
+	for _, base := range skins {
+		for _, resource := range renderResources {
+			for _, width := range defaultRenderWidths() {
+				for _, ext := range negotiableFormats {
+					// Each render draws on its own copy, as it leaves its
+					// result there.
+					skin := *base
+					skin.Mode = router.getResizeMode(ext)
+					key := renderKey(resource, width, ext, &skin)
+					if _, drawn := renders[key]; drawn {
+						continue
+					}
+					data, err := router.draw(resource, width, ext, &skin)
+					if err != nil {
+						log.Warningf("Unable to prerender %s at %d as %s (%v)", resource, width, ext, err)
+						continue
+					}
+					renders[key] = data
+				}
+			}
+		}
+	}
+	defaultRenders.Store(&renders)
+	log.Infof("Prerendered %d default renders in %s", len(renders), time.Since(start))
+}
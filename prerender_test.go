@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/imgd/pkg/mcskin"
+	"github.com/minotar/minecraft"
+)
+
+func TestPrerenderDefaults(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	renderCache = MakeRenderCache(0, false)
+	hotCache = MakeHotCache(nil, 0)
+	missingFilter = MakeMissingFilter(0, time.Minute)
+	config.Ttl.Failed = 60
+
+	// Mojang's nowhere to be found, so there's no Alex.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	mcClient = &minecraft.Minecraft{Client: server.Client()}
+	defer func(url string) { config.Minecraft.TextureURL = url }(config.Minecraft.TextureURL)
+	config.Minecraft.TextureURL = server.URL + "/texture/"
+	config.Minecraft.SessionServerURL = server.URL + "/profile/"
+	upstream = MakeUpstream(0, 0)
+	defer defaultRenders.Store(nil)
+
+	router := &Router{Mux: mux.NewRouter()}
+	prerenderDefaults(router)
+
+	steve, _ := minecraft.FetchSkinForSteve()
+	skin := &mcSkin{Render: mcskin.Render{Skin: steve, Mode: "Normal"}}
+	expected, ok := defaultRender(renderKey("Body", DefaultWidth, ".png", skin))
+	if !ok {
+		t.Fatal("Expected Steve's body to be prerendered at the default width")
+	}
+
+	// Steve's who a player we can't find gets.
+	router.Bind()
+	w := httptest.NewRecorder()
+	router.Mux.ServeHTTP(w, httptest.NewRequest("GET", "/body/d9135e082f2244c89cb10d21ed3ac8fd.png", nil))
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), expected) {
+		t.Fatalf("Expected the prerendered body to be served, got %d", w.Code)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/imgd/pkg/mcskin"
+)
+
+// A named set of render options, as a [preset "<name>"] section, used with
+// ?preset=<name>. Sites can keep their URLs short, and restyle every avatar
+// they show with a config edit. Anything also in the URL wins over the
+// preset.
+type Preset struct {
+	// Width when the path has none, 0 to leave it to the render.
+	Size     uint
+	ArmAngle float64
+	Walking  bool
+	Ears     bool
+	Trim     bool
+	// Draws the player's name beneath the render, or LabelText if set.
+	Label     bool
+	LabelText string
+	// The [theme] drawn behind the render, if any.
+	Theme string
+}
+
+// Returns the preset the request asks for, if any, and false if it asks for
+// one we don't have.
+func presetFor(r *http.Request) (*Preset, bool) {
+	name := r.URL.Query().Get("preset")
+	if name == "" {
+		return nil, true
+	}
+	preset := config.Preset[name]
+	return preset, preset != nil
+}
+
+// Returns the width the request asks for in its path, or else its preset,
+// blank for the render's default.
+func requestedWidth(r *http.Request) string {
+	if width := mux.Vars(r)["width"]; width != "" {
+		return width
+	}
+	if preset, _ := presetFor(r); preset != nil && preset.Size > 0 {
+		return strconv.FormatUint(uint64(preset.Size), 10)
+	}
+	return ""
+}
+
+// The render options the preset gives the player.
+func (p *Preset) options(player string) mcskin.Options {
+	opts := mcskin.Options{
+		ArmAngle: p.ArmAngle,
+		Walking:  p.Walking,
+		Ears:     p.Ears,
+		Trim:     p.Trim,
+		// Checked to exist on startup.
+		Background: themes[p.Theme],
+	}
+	if p.Label {
+		opts.Label = player
+		if p.LabelText != "" {
+			opts.Label = p.LabelText
+		}
+	}
+	return opts
+}
+
+func checkPreset(p *Preset) error {
+	if p.Size != 0 && (p.Size < MinWidth || p.Size > MaxWidth) {
+		return fmt.Errorf("size %d isn't between %d and %d", p.Size, MinWidth, MaxWidth)
+	}
+	if p.ArmAngle < 0 || p.ArmAngle > mcskin.MaxArmAngle {
+		return fmt.Errorf("armangle %g isn't between 0 and %d", p.ArmAngle, mcskin.MaxArmAngle)
+	}
+	if utf8.RuneCountInString(p.LabelText) > mcskin.MaxLabelLength {
+		return fmt.Errorf("labeltext is longer than %d characters", mcskin.MaxLabelLength)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/minecraft"
+	"gopkg.in/gcfg.v1"
+)
+
+func TestCheckPreset(t *testing.T) {
+	if err := checkPreset(&Preset{Size: 128, ArmAngle: 10, Label: true}); err != nil {
+		t.Fatal(err)
+	}
+	for _, preset := range []*Preset{{Size: 4}, {Size: 1000}, {ArmAngle: -1}, {ArmAngle: 91}, {LabelText: "a label far, far longer than we draw"}} {
+		if err := checkPreset(preset); err == nil {
+			t.Errorf("Expected %+v to be refused", preset)
+		}
+	}
+}
+
+func TestPresets(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	renderCache = MakeRenderCache(0, false)
+	hotCache = MakeHotCache(nil, 0)
+	missingFilter = MakeMissingFilter(0, time.Minute)
+	// Serving a stale skin would refresh it in the background, long after
+	// the test.
+	defer func(stale int) { config.Ttl.Stale = stale }(config.Ttl.Stale)
+	config.Ttl.Stale = 0
+
+	saved := config.Preset
+	defer func() { config.Preset = saved }()
+	c := Configuration{}
+	if err := gcfg.ReadStringInto(&c, "[preset \"forum\"]\nsize = 64\ntrim = true\nlabel = true\nlabeltext = hi\n"); err != nil {
+		t.Fatal(err)
+	}
+	config.Preset = c.Preset
+
+	skin, _ := minecraft.FetchSkinForSteve()
+	skin.Hash = "clone1018"
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.Mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	opts := router.GetRenderOptions(mux.SetURLVars(httptest.NewRequest("GET", "/helm/x?preset=forum&trim=0", nil), map[string]string{"username": "x"}))
+	if opts.Trim || opts.Label != "hi" {
+		t.Fatalf("Expected the preset's label, and the URL's trim, got %+v", opts)
+	}
+
+	w := serve("/helm/d9135e082f2244c89cb10d21ed3ac8fd.png?preset=forum&label=0")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the preset to be drawn, got %d", w.Code)
+	}
+	img, err := png.Decode(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 64 {
+		t.Fatalf("Expected the preset's width, got %v", img.Bounds())
+	}
+
+	w = serve("/helm/d9135e082f2244c89cb10d21ed3ac8fd/32.png?preset=forum&label=0")
+	if img, err = png.Decode(w.Body); err != nil || img.Bounds().Dx() != 32 {
+		t.Fatalf("Expected the path's width to win, got %v (%v)", img.Bounds(), err)
+	}
+
+	if w := serve("/helm/d9135e082f2244c89cb10d21ed3ac8fd.png?preset=launcher"); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected an unknown preset to be refused, got %d", w.Code)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/minotar/imgd/pkg/mcclient"
+	"github.com/minotar/minecraft"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A player's profile from Mojang's session server, with what we need to
+// fetch and render their textures.
+type Profile = mcclient.Profile
+
+type sessionProfileProp = mcclient.Property
+
+// Returns a client for Mojang, or whichever auth server we're configured
+// to use, which passes on the request ID and checks signatures if we've
+// been configured to.
+func sessionClient() *mcclient.Client {
+	return &mcclient.Client{
+		HTTP:             mcClient.Client,
+		UserAgent:        config.Minecraft.UserAgent,
+		SessionServerURL: config.Minecraft.SessionServerURL,
+		Prepare: func(req *http.Request) {
+			forwardRequestID(req.Context(), req)
+		},
+		Verify: propertyVerifier(),
+		Downloaded: func(bytes int64) {
+			textureSize.Observe(float64(bytes))
+		},
+		MaxTextureSize: config.Minecraft.MaxTextureSize,
+	}
+}
+
+// Returns what checks textures properties are signed, nil if we haven't
+// been configured to check them.
+func propertyVerifier() func(sessionProfileProp) error {
+	if signatureKey == nil {
+		return nil
+	}
+	key := signatureKey
+	return func(property sessionProfileProp) error {
+		if err := verifyProperty(key, property); err != nil {
+			stats.Errored(ErrTextureSignature)
+			return err
+		}
+		return nil
+	}
+}
+
+// Requests the URL from Mojang, returning errors in the same form as the
+// minecraft package so they're handled alike.
+func upstreamGet(ctx context.Context, call string, url string) (*http.Response, error) {
+	return sessionClient().Get(ctx, call, url)
+}
+
+// Fetches the player's profile from the session server.
+func fetchProfile(ctx context.Context, uuid string) (Profile, error) {
+	stats.APIRequested("SessionProfile")
+	sPTimer := prometheus.NewTimer(getDuration.WithLabelValues("SessionProfile"))
+	defer sPTimer.ObserveDuration()
+
+	return sessionClient().FetchProfile(ctx, uuid)
+}
+
+// Fills in the profile's textures from its "textures" property, checking
+// its signature first if we've been configured to.
+func applyProperties(p *Profile, properties []sessionProfileProp) error {
+	return p.ApplyProperties(properties, propertyVerifier())
+}
+
+// Downloads the texture. These are served from Mojang's CDN rather than
+// their API, so aren't subject to its rate limit.
+func fetchTexture(ctx context.Context, url string) (minecraft.Skin, error) {
+	stats.APIRequested("Texture")
+	textureTimer := prometheus.NewTimer(getDuration.WithLabelValues("Texture"))
+	defer textureTimer.ObserveDuration()
+
+	start := time.Now()
+	skin, err := sessionClient().FetchTexture(ctx, url)
+	// Textures we refuse were still served fine.
+	if err == nil || errors.Is(err, mcclient.ErrTextureTooLarge) || errors.Is(err, mcclient.ErrTextureCorrupt) {
+		textureHealth.record(time.Since(start), nil)
+	} else {
+		textureHealth.record(time.Since(start), err)
+	}
+	if errors.Is(err, mcclient.ErrTextureTooLarge) {
+		countInvalidTexture("too_large")
+	} else if errors.Is(err, mcclient.ErrTextureCorrupt) {
+		countInvalidTexture("corrupt")
+	}
+	return skin, err
+}
+
+// Returned for skins which aren't a size Minecraft would draw.
+var errSkinDimensions = errors.New("skin has invalid dimensions")
+
+// Checks the skin is 64 pixels wide, or a multiple of that for HD skins,
+// and either square or half as tall as it is wide.
+func checkSkinDimensions(skin minecraft.Skin) error {
+	if skin.Image == nil {
+		return errSkinDimensions
+	}
+	bounds := skin.Image.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 64 || width > 1024 || width%64 != 0 || (height != width && height != width/2) {
+		return fmt.Errorf("%w: %dx%d", errSkinDimensions, width, height)
+	}
+	return nil
+}
+
+// Downloads the skin texture, refusing it if it's not a valid skin, and
+// counting it by format.
+func fetchSkinTexture(ctx context.Context, url string) (minecraft.Skin, error) {
+	return fetchSkinTextureSince(ctx, url, minecraft.Skin{})
+}
+
+// Fetches the skin texture as fetchSkinTexture does, but returns the
+// previous skin if it's the same texture. Texture URLs are named for their
+// contents, so there's no need to download one we already have.
+func fetchSkinTextureSince(ctx context.Context, url string, previous minecraft.Skin) (minecraft.Skin, error) {
+	if previous.Image != nil && (previous.URL == url || strings.EqualFold(previous.Hash, mcclient.TextureHash(url))) {
+		unchangedTextureCounter.Inc()
+		skinFormatCounter.WithLabelValues(skinFormat(previous)).Inc()
+		return previous, nil
+	}
+	skin, err := fetchTexture(ctx, url)
+	if err != nil {
+		return skin, err
+	}
+	if err := checkSkinDimensions(skin); err != nil {
+		countInvalidTexture("dimensions")
+		return minecraft.Skin{}, err
+	}
+	skinFormatCounter.WithLabelValues(skinFormat(skin)).Inc()
+	return skin, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Most profiles we'll remember at once.
+const profileCacheCount = 100000
+
+type ttlCacheEntry[V any] struct {
+	Value   V
+	Expires time.Time
+}
+
+// Remembers values by key, each for its own TTL, holding at most Limit at
+// once. It's what the small caches we keep apart from skins are made of:
+// profiles, username to UUID mappings and histories.
+type TTLCache[V any] struct {
+	Limit int
+
+	mu      sync.Mutex
+	entries map[string]ttlCacheEntry[V]
+}
+
+func MakeTTLCache[V any](limit int) *TTLCache[V] {
+	return &TTLCache[V]{Limit: limit, entries: map[string]ttlCacheEntry[V]{}}
+}
+
+// Returns the key's value, if we have it and it hasn't expired.
+func (c *TTLCache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var none V
+	entry, exists := c.entries[key]
+	if !exists {
+		return none, false
+	}
+	if time.Now().After(entry.Expires) {
+		delete(c.entries, key)
+		return none, false
+	}
+	return entry.Value, true
+}
+
+func (c *TTLCache[V]) add(key string, value V, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= c.Limit {
+		c.expire()
+	}
+	if len(c.entries) >= c.Limit {
+		// Everything is still fresh, so there's no fair way to choose.
+		c.entries = map[string]ttlCacheEntry[V]{}
+	}
+	c.entries[key] = ttlCacheEntry[V]{Value: value, Expires: time.Now().Add(ttl)}
+}
+
+func (c *TTLCache[V]) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
+
+func (c *TTLCache[V]) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = map[string]ttlCacheEntry[V]{}
+}
+
+// Drops expired entries. Must be called with the lock held.
+func (c *TTLCache[V]) expire() {
+	now := time.Now()
+	for key, entry := range c.entries {
+		if now.After(entry.Expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Remembers the profiles we've fetched, by UUID, apart from their skins.
+// Once the skin itself has expired, or been purged, we can fetch it again
+// straight from the texture server without asking Mojang for the profile.
+type ProfileCache = TTLCache[Profile]
+
+func MakeProfileCache() *ProfileCache {
+	return MakeTTLCache[Profile](profileCacheCount)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minotar/minecraft"
+)
+
+func TestProfileCache(t *testing.T) {
+	c := MakeProfileCache()
+	c.add("a", Profile{UUID: "a"}, time.Minute)
+	c.add("b", Profile{UUID: "b"}, -time.Minute)
+	if profile, ok := c.get("a"); !ok || profile.UUID != "a" {
+		t.Fatal("Expected the fresh profile")
+	}
+	if _, ok := c.get("b"); ok {
+		t.Fatal("Expected the expired profile to be gone")
+	}
+}
+
+func TestProfileCacheSkipsSessionServer(t *testing.T) {
+	stats = MakeStatsCollector()
+	profileCache = MakeProfileCache()
+	upstream = MakeUpstream(0, 0)
+	config.Ttl.Profile = 60
+
+	texture := new(bytes.Buffer)
+	png.Encode(texture, image.NewNRGBA(image.Rect(0, 0, 64, 64)))
+
+	uuid := "069a79f444e94726a5befca90e38aaf5"
+	profiles := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/profile/") {
+			profiles++
+			value := base64.StdEncoding.EncodeToString([]byte(`{"textures":{"SKIN":{"url":"http://` + r.Host + `/texture/abc"}}}`))
+			w.Write([]byte(`{"id":"` + uuid + `","name":"Notch","properties":[{"name":"textures","value":"` + value + `"}]}`))
+			return
+		}
+		w.Write(texture.Bytes())
+	}))
+	defer server.Close()
+	config.Minecraft.SessionServerURL = server.URL + "/profile/"
+	mcClient = &minecraft.Minecraft{Client: server.Client()}
+
+	for i := 0; i < 2; i++ {
+		if _, _, reason := fetchSkinForUUID(context.Background(), "Notch", uuid); reason != NegativeNone {
+			t.Fatalf("Expected the skin, got %v", reason)
+		}
+	}
+	if profiles != 1 {
+		t.Fatalf("Expected the profile to be fetched once, got %d fetches", profiles)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/minotar/imgd/pkg/mcclient"
+	"github.com/minotar/imgd/pkg/mcskin"
+	"github.com/minotar/minecraft"
+)
+
+func TestFetchSkinForUUID(t *testing.T) {
+	stats = MakeStatsCollector()
+	upstream = MakeUpstream(0, 0)
+
+	// A classic skin, with the arms filled in.
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := mcskin.RaY; y < mcskin.RaY+mcskin.RaHeight; y++ {
+		for x := mcskin.RaX; x < 56; x++ {
+			img.Set(x, y, color.Black)
+		}
+	}
+	texture := new(bytes.Buffer)
+	png.Encode(texture, img)
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/profile/853c80ef3c3749fdaa49938b674adae6":
+			textures := fmt.Sprintf(`{"textures":{"SKIN":{"url":"%s/texture/abc123","metadata":{"model":"slim"}}}}`, server.URL)
+			fmt.Fprintf(w, `{"id":"853c80ef3c3749fdaa49938b674adae6","name":"jeb_","properties":[{"name":"textures","value":"%s"}]}`,
+				base64.StdEncoding.EncodeToString([]byte(textures)))
+		case "/texture/abc123":
+			w.Write(texture.Bytes())
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	config.Minecraft.SessionServerURL = server.URL + "/profile/"
+	mcClient = &minecraft.Minecraft{Client: server.Client()}
+
+	skin, slim, reason := fetchSkinForUUID(context.Background(), "jeb_", "853c80ef3c3749fdaa49938b674adae6")
+	if reason != NegativeNone {
+		t.Fatalf("Expected the skin to be fetched, got %s", reason)
+	}
+	if !slim || skin.Hash != "abc123" || skin.Image.Bounds().Dx() != 64 {
+		t.Fatal("Expected the slim skin from the texture URL")
+	}
+	if isSlimSkin(skin) {
+		t.Fatal("Expected a skin with full width arms not to look slim")
+	}
+
+	if _, _, reason := fetchSkinForUUID(context.Background(), "nobody", "00000000000000000000000000000000"); reason != NegativeNotFound {
+		t.Fatalf("Expected an unknown UUID not to be found, got %s", reason)
+	}
+}
+
+func TestCheckSkinDimensions(t *testing.T) {
+	for size, valid := range map[image.Point]bool{
+		{64, 64}:     true,
+		{64, 32}:     true,
+		{128, 128}:   true,
+		{1024, 512}:  true,
+		{32, 32}:     false,
+		{64, 48}:     false,
+		{100, 100}:   false,
+		{2048, 2048}: false,
+	} {
+		skin := minecraft.Skin{}
+		skin.Image = image.NewNRGBA(image.Rect(0, 0, size.X, size.Y))
+		if err := checkSkinDimensions(skin); (err == nil) != valid {
+			t.Errorf("Expected %v to be valid: %v, got %v", size, valid, err)
+		}
+	}
+}
+
+func TestFetchSkinTextureRefusesInvalid(t *testing.T) {
+	stats = MakeStatsCollector()
+
+	texture := new(bytes.Buffer)
+	png.Encode(texture, image.NewNRGBA(image.Rect(0, 0, 48, 48)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/texture/odd":
+			w.Write(texture.Bytes())
+		default:
+			w.Write([]byte("not a png"))
+		}
+	}))
+	defer server.Close()
+	mcClient = &minecraft.Minecraft{Client: server.Client()}
+
+	if _, err := fetchSkinTexture(context.Background(), server.URL+"/texture/odd"); !errors.Is(err, errSkinDimensions) {
+		t.Fatalf("Expected a 48x48 skin to be refused, got %v", err)
+	}
+	if _, err := fetchSkinTexture(context.Background(), server.URL+"/texture/junk"); !errors.Is(err, mcclient.ErrTextureCorrupt) {
+		t.Fatalf("Expected junk to be refused as corrupt, got %v", err)
+	}
+}
+
+func TestFetchSkinSkipsUnchangedTexture(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	profileCache = MakeProfileCache()
+	upstream = MakeUpstream(0, 0)
+
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		w.Write(encodeTestSkin(64, 64))
+	}))
+	defer server.Close()
+	mcClient = &minecraft.Minecraft{Client: server.Client()}
+
+	uuid := "d9135e082f2244c89cb10d21ed3ac8fd"
+	profileCache.add(uuid, Profile{UUID: uuid, SkinURL: server.URL + "/texture/abc123"}, time.Minute)
+
+	first, _, reason := fetchSkinForUUID(context.Background(), uuid, uuid)
+	if reason != NegativeNone {
+		t.Fatalf("Expected the skin to be fetched, got %s", reason)
+	}
+	second, _, reason := fetchSkinForUUIDSince(context.Background(), uuid, uuid, first)
+	if reason != NegativeNone || second.Image != first.Image {
+		t.Fatalf("Expected the previous skin back, got %s", reason)
+	}
+
+	// The URL names the texture's hash, so that's all a cached skin needs.
+	known := minecraft.Skin{}
+	known.Image = first.Image
+	known.Hash = "abc123"
+	if third, _, reason := fetchSkinForUUIDSince(context.Background(), uuid, uuid, known); reason != NegativeNone || third.Image != first.Image {
+		t.Fatalf("Expected the known skin back, got %s", reason)
+	}
+	if downloads != 1 {
+		t.Fatalf("Expected the unchanged skin to be downloaded once, got %d", downloads)
+	}
+
+	// Without the previous skin to hand, it has to be downloaded.
+	if _, _, reason := fetchSkinForUUIDSince(context.Background(), uuid, uuid, minecraft.Skin{}); reason != NegativeNone || downloads != 2 {
+		t.Fatalf("Expected the skin to be downloaded again, got %s after %d", reason, downloads)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fzzy/radix/extra/pubsub"
+)
+
+// How long to wait before resubscribing after losing the connection.
+const purgeReconnectDelay = 5 * time.Second
+
+// Removes the player from this instance's caches.
+func purgePlayer(username string, uuid string) {
+	username = strings.ToLower(username)
+	if uuid != "" {
+		cache.remove(uuid)
+		profileCache.remove(uuid)
+	}
+	// Also drops any negative entry for the username.
+	cache.remove(username)
+	uuidCache.remove(username)
+	hotCache.purge(username, uuid)
+}
+
+// Empties this instance's caches.
+func flushCaches() error {
+	uuidCache.flush()
+	profileCache.flush()
+	renderCache.flush()
+	hotCache.flush()
+	missingFilter.flush()
+	return cache.flush()
+}
+
+// Relays purges between instances over a Redis pub/sub channel, so purging a
+// player on one instance purges them from the memory caches of every
+// instance.
+type PurgeBroadcaster struct {
+	Channel string
+	// Random ID we tag our messages with, so we can ignore our own.
+	node string
+}
+
+func MakePurgeBroadcaster(channel string) *PurgeBroadcaster {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return &PurgeBroadcaster{Channel: channel, node: hex.EncodeToString(id)}
+}
+
+// Tells the other instances to purge the player.
+func (b *PurgeBroadcaster) purge(username string, uuid string) {
+	// An empty UUID would collapse into the separator, so send a dash.
+	if uuid == "" {
+		uuid = "-"
+	}
+	b.publish(fmt.Sprintf("%s purge %s %s", b.node, username, uuid))
+}
+
+// Tells the other instances to flush their caches.
+func (b *PurgeBroadcaster) flush() {
+	b.publish(fmt.Sprintf("%s flush", b.node))
+}
+
+func (b *PurgeBroadcaster) publish(message string) {
+	client, err := dialFunc("tcp", config.Redis.Address)
+	if err != nil {
+		log.Errorf("Unable to publish purge (%v)", err)
+		stats.Errored(ErrPurgePublish)
+		return
+	}
+	defer client.Close()
+
+	if err := client.Cmd("PUBLISH", b.Channel, message).Err; err != nil {
+		log.Errorf("Unable to publish purge (%v)", err)
+		stats.Errored(ErrPurgePublish)
+	}
+}
+
+// Listens for purges from the other instances, reconnecting whenever we
+// lose the connection.
+func (b *PurgeBroadcaster) run() {
+	for {
+		if err := b.listen(); err != nil {
+			log.Errorf("Lost purge subscription, reconnecting (%v)", err)
+			stats.Errored(ErrPurgeSubscribe)
+		}
+		time.Sleep(purgeReconnectDelay)
+	}
+}
+
+func (b *PurgeBroadcaster) listen() error {
+	client, err := dialFunc("tcp", config.Redis.Address)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	sub := pubsub.NewSubClient(client)
+	if reply := sub.Subscribe(b.Channel); reply.Err != nil {
+		return reply.Err
+	}
+	log.Noticef("Listening for purges on %s", b.Channel)
+
+	for {
+		reply := sub.Receive()
+		if reply.Err != nil {
+			if reply.Timeout() {
+				continue
+			}
+			return reply.Err
+		}
+		if reply.Type == pubsub.MessageReply {
+			b.handle(reply.Message)
+		}
+	}
+}
+
+// Applies a purge from another instance.
+func (b *PurgeBroadcaster) handle(message string) {
+	fields := strings.Fields(message)
+	if len(fields) < 2 || fields[0] == b.node {
+		return
+	}
+
+	switch fields[1] {
+	case "purge":
+		if len(fields) != 4 {
+			break
+		}
+		uuid := fields[3]
+		if uuid == "-" {
+			uuid = ""
+		}
+		purgePlayer(fields[2], uuid)
+		log.Infof("Purged %s from the cache (by node %s)", fields[2], fields[0])
+		return
+	case "flush":
+		if err := flushCaches(); err != nil {
+			log.Errorf("Failed to flush the cache (%v)", err)
+			stats.Errored(ErrCacheFlush)
+		}
+		log.Infof("Flushed the cache (by node %s)", fields[0])
+		return
+	}
+	log.Warningf("Ignoring unknown purge message: %s", message)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/minotar/minecraft"
+)
+
+func TestPurgeBroadcasterHandle(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+
+	uuid := "d9135e082f2244c89cb10d21ed3ac8fd"
+	skin := minecraft.Skin{}
+	skin.Hash = "clone1018"
+	cache.add(uuid, skin, time.Minute)
+
+	b := MakePurgeBroadcaster("imgd:purge")
+	b.handle(b.node + " purge clone1018 " + uuid)
+	if !cache.has(uuid) {
+		t.Fatal("Our own purges shouldn't be applied twice")
+	}
+
+	b.handle("othernode purge clone1018 " + uuid)
+	if cache.has(uuid) {
+		t.Fatal("Expected a purge from another node to be applied")
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fzzy/radix/extra/pool"
+	"github.com/fzzy/radix/redis"
+)
+
+// Counts are kept for a day after the one they're for, so yesterday's usage
+// can still be looked up.
+const quotaKeyTtl = 2 * 24 * time.Hour
+
+// Counts requests a client has made today.
+type QuotaCounter interface {
+	// Counts a request by the client on the day, and returns how many
+	// they've now made on it.
+	incr(day string, client string) (int64, error)
+	// Returns how many requests each client made on the day.
+	usage(day string) (map[string]int64, error)
+}
+
+// Holds each client to a number of requests a day, counted in Redis so the
+// quota is shared by every instance. Clients are who an authenticator
+// identified them as, eg. the name of their API key, else their IP.
+type Quota struct {
+	// Requests a day each client may make, 0 for no limit.
+	Daily int64
+	// Quotas for particular clients, 0 for no limit.
+	Limits  map[string]int64
+	Counter QuotaCounter
+}
+
+// Parses a list of "client:requests" quotas.
+func parseQuotaLimits(list []string) (map[string]int64, error) {
+	limits := map[string]int64{}
+	for _, entry := range list {
+		i := strings.LastIndex(entry, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid quota %q, expected client:requests", entry)
+		}
+		limit, err := strconv.ParseInt(entry[i+1:], 10, 64)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid quota %q, expected client:requests", entry)
+		}
+		limits[entry[:i]] = limit
+	}
+	return limits, nil
+}
+
+// Returns the client's quota, 0 for no limit.
+func (q *Quota) limit(client string) int64 {
+	if limit, exists := q.Limits[client]; exists {
+		return limit
+	}
+	return q.Daily
+}
+
+// Returns the day it is for the quota, which rolls over at midnight UTC.
+func quotaDay(now time.Time) string {
+	return now.UTC().Format("2006-01-02")
+}
+
+// Returns who the request counts against.
+func quotaClient(r *http.Request) string {
+	if identity := authIdentity(r); identity != "" {
+		return identity
+	}
+	return clientIP(r)
+}
+
+// Refuses requests from clients who've used up today's quota with a 429,
+// and tells the rest how much of it they have left. If Redis can't be
+// reached, requests are let through rather than refused. Run it after
+// authentication, so API keys are counted as themselves.
+func quotaHandler(quota *Quota, router http.Handler) http.Handler {
+	if quota == nil {
+		return router
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := quotaClient(r)
+		limit := quota.limit(client)
+		if limit == 0 || strings.HasPrefix(r.URL.Path, "/admin/") {
+			router.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		used, err := quota.Counter.incr(quotaDay(now), client)
+		if err != nil {
+			log.Warningf("Unable to count request against quota (%v)", err)
+			stats.Errored(ErrQuota)
+			router.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("X-Quota-Limit", strconv.FormatInt(limit, 10))
+		if used > limit {
+			midnight := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+			w.Header().Set("X-Quota-Remaining", "0")
+			w.Header().Set("Retry-After", strconv.Itoa(int(midnight.Sub(now).Seconds())+1))
+			writeError(w, r, http.StatusTooManyRequests, ErrRateLimited, "daily quota of %d requests used up", limit)
+			stats.Errored(ErrQuotaExceeded)
+			quotaExceededCounter.Inc()
+			return
+		}
+		w.Header().Set("X-Quota-Remaining", strconv.FormatInt(limit-used, 10))
+		router.ServeHTTP(w, r)
+	})
+}
+
+// A client's usage on the day, for the admin API.
+type quotaUsage struct {
+	Client string
+	Used   int64
+	// 0 if they've no limit.
+	Limit int64
+}
+
+// QuotasPage lists how many requests each client has made today, most
+// first, or on the day given as ?day=YYYY-MM-DD. ?client= narrows it down
+// to one client.
+func (router *Router) QuotasPage(w http.ResponseWriter, r *http.Request) {
+	if quota == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprintf(w, "501 quotas aren't enabled")
+		return
+	}
+
+	day := r.URL.Query().Get("day")
+	if day == "" {
+		day = quotaDay(time.Now())
+	} else if _, err := time.Parse("2006-01-02", day); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "400 expected a day as YYYY-MM-DD")
+		return
+	}
+
+	counts, err := quota.Counter.usage(day)
+	if err != nil {
+		log.Errorf("Unable to read quota usage (%v)", err)
+		stats.Errored(ErrQuota)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "503 unable to read quota usage")
+		return
+	}
+	clients := []quotaUsage{}
+	for client, used := range counts {
+		if only := r.URL.Query().Get("client"); only == "" || only == client {
+			clients = append(clients, quotaUsage{Client: client, Used: used, Limit: quota.limit(client)})
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].Used != clients[j].Used {
+			return clients[i].Used > clients[j].Used
+		}
+		return clients[i].Client < clients[j].Client
+	})
+
+	page, _ := json.Marshal(struct {
+		Day     string
+		Daily   int64
+		Clients []quotaUsage
+	}{day, quota.Daily, clients})
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(page)
+}
+
+// Counts requests in Redis, as "<prefix><day>:<client>".
+type RedisQuotaCounter struct {
+	Prefix string
+	Pool   *pool.Pool
+}
+
+func MakeRedisQuotaCounter(prefix string) (*RedisQuotaCounter, error) {
+	p, err := pool.NewCustomPool("tcp", config.Redis.Address, config.Redis.PoolSize, dialFunc)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisQuotaCounter{Prefix: prefix, Pool: p}, nil
+}
+
+func (c *RedisQuotaCounter) incr(day string, client string) (int64, error) {
+	conn, err := c.Pool.Get()
+	if err != nil {
+		return 0, err
+	}
+	defer c.Pool.CarefullyPut(conn, &err)
+
+	key := c.Prefix + day + ":" + client
+	conn.Append("INCR", key)
+	conn.Append("EXPIRE", key, int(quotaKeyTtl.Seconds()))
+	var used int64
+	if used, err = conn.GetReply().Int64(); err != nil {
+		return 0, err
+	}
+	err = conn.GetReply().Err
+	return used, err
+}
+
+func (c *RedisQuotaCounter) usage(day string) (map[string]int64, error) {
+	conn, err := c.Pool.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Pool.CarefullyPut(conn, &err)
+
+	prefix := c.Prefix + day + ":"
+	counts := map[string]int64{}
+	cursor := "0"
+	for {
+		reply := conn.Cmd("SCAN", cursor, "MATCH", prefix+"*", "COUNT", 1000)
+		if err = reply.Err; err != nil {
+			return nil, err
+		}
+		if len(reply.Elems) != 2 {
+			err = fmt.Errorf("unexpected reply to SCAN")
+			return nil, err
+		}
+		var keys []string
+		if cursor, err = reply.Elems[0].Str(); err != nil {
+			return nil, err
+		}
+		if keys, err = reply.Elems[1].List(); err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			var used int64
+			reply := conn.Cmd("GET", key)
+			if reply.Type == redis.NilReply {
+				// It expired since the scan.
+				continue
+			}
+			if used, err = reply.Int64(); err != nil {
+				return nil, err
+			}
+			counts[strings.TrimPrefix(key, prefix)] = used
+		}
+		if cursor == "0" {
+			return counts, nil
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Counts in memory, as Redis would.
+type memoryQuotaCounter struct {
+	counts map[string]map[string]int64
+	err    error
+}
+
+func (c *memoryQuotaCounter) incr(day string, client string) (int64, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.counts[day] == nil {
+		c.counts[day] = map[string]int64{}
+	}
+	c.counts[day][client]++
+	return c.counts[day][client], nil
+}
+
+func (c *memoryQuotaCounter) usage(day string) (map[string]int64, error) {
+	return c.counts[day], c.err
+}
+
+func TestParseQuotaLimits(t *testing.T) {
+	limits, err := parseQuotaLimits([]string{"acme:1000", "192.0.2.1:0", "2001:db8::1:50"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits["acme"] != 1000 || limits["192.0.2.1"] != 0 || limits["2001:db8::1"] != 50 {
+		t.Fatalf("Unexpected limits %v", limits)
+	}
+	for _, bad := range []string{"acme", ":10", "acme:lots", "acme:-1"} {
+		if _, err := parseQuotaLimits([]string{bad}); err == nil {
+			t.Errorf("Expected %q to be invalid", bad)
+		}
+	}
+}
+
+func TestQuotaHandler(t *testing.T) {
+	stats = MakeStatsCollector()
+	counter := &memoryQuotaCounter{counts: map[string]map[string]int64{}}
+	q := &Quota{Daily: 2, Limits: map[string]int64{"acme": 3, "ops": 0}, Counter: counter}
+	handler := quotaHandler(q, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(identity string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/avatar/clone1018", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		if identity != "" {
+			r = r.WithContext(context.WithValue(r.Context(), authContextKey{}, identity))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	for i := 0; i < 2; i++ {
+		if w := request(""); w.Code != http.StatusOK {
+			t.Fatalf("Expected the quota to be allowed, got %d", w.Code)
+		}
+	}
+	w := request("")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" || w.Header().Get("X-Quota-Remaining") != "0" {
+		t.Fatalf("Expected a 429 with Retry-After, got %d %v", w.Code, w.Header())
+	}
+
+	for i := 0; i < 3; i++ {
+		if w := request("acme"); w.Code != http.StatusOK {
+			t.Fatalf("Expected API keys to have their own quota, got %d", w.Code)
+		}
+	}
+	if w := request("acme"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected acme's quota to be used up, got %d", w.Code)
+	}
+	for i := 0; i < 5; i++ {
+		if w := request("ops"); w.Code != http.StatusOK || w.Header().Get("X-Quota-Limit") != "" {
+			t.Fatal("Expected a 0 quota to be no limit")
+		}
+	}
+
+	counter.err = errors.New("connection refused")
+	if w := request(""); w.Code != http.StatusOK {
+		t.Fatal("Expected requests through when the counts can't be reached")
+	}
+}
+
+func TestQuotasPage(t *testing.T) {
+	defer func() { quota = nil }()
+	day := "2024-01-02"
+	quota = &Quota{Daily: 10, Limits: map[string]int64{"acme": 100}, Counter: &memoryQuotaCounter{counts: map[string]map[string]int64{
+		day: {"acme": 42, "192.0.2.1": 7},
+	}}}
+
+	w := httptest.NewRecorder()
+	(&Router{}).QuotasPage(w, httptest.NewRequest("GET", "/admin/quotas?day="+day, nil))
+	page := struct {
+		Day     string
+		Clients []quotaUsage
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	expected := []quotaUsage{{"acme", 42, 100}, {"192.0.2.1", 7, 10}}
+	if page.Day != day || len(page.Clients) != 2 || page.Clients[0] != expected[0] || page.Clients[1] != expected[1] {
+		t.Fatalf("Unexpected usage %+v", page)
+	}
+
+	w = httptest.NewRecorder()
+	(&Router{}).QuotasPage(w, httptest.NewRequest("GET", "/admin/quotas?day=yesterday", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected a bad day to be refused, got %d", w.Code)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// Most clients we'll track buckets for at once.
+const rateLimitClients = 100000
+
+// Gives each client IP a TokenBucket, so no one client can take more than
+// its share of the instance.
+type RateLimiter struct {
+	// Requests a second each client may make on average, and at once.
+	Rate  float64
+	Burst int
+
+	mu      sync.Mutex
+	buckets map[string]*TokenBucket
+}
+
+func MakeRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{Rate: rate, Burst: burst, buckets: map[string]*TokenBucket{}}
+}
+
+// Whether the client may make a request, and if not how many seconds until
+// they may.
+func (l *RateLimiter) allow(ip string) (bool, int) {
+	bucket := l.bucket(ip)
+	if bucket.take() {
+		return true, 0
+	}
+	return false, int(math.Ceil(bucket.wait().Seconds()))
+}
+
+func (l *RateLimiter) bucket(ip string) *TokenBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if bucket, exists := l.buckets[ip]; exists {
+		return bucket
+	}
+	if len(l.buckets) >= rateLimitClients {
+		l.expire()
+	}
+	if len(l.buckets) >= rateLimitClients {
+		// Everyone is mid burst, so there's no fair way to choose.
+		l.buckets = map[string]*TokenBucket{}
+	}
+	bucket := MakeTokenBucket(l.Rate, l.Burst)
+	l.buckets[ip] = bucket
+	return bucket
+}
+
+// Drops the buckets of clients who've been quiet long enough for them to
+// refill, as a fresh bucket would be no different. Must be called with the
+// lock held.
+func (l *RateLimiter) expire() {
+	for ip, bucket := range l.buckets {
+		if bucket.full() {
+			delete(l.buckets, ip)
+		}
+	}
+}
+
+// Refuses requests from clients over the limit with a 429. Authenticated
+// callers aren't limited, so have the limiter run after authentication.
+func rateLimitHandler(limiter *RateLimiter, router http.Handler) http.Handler {
+	if limiter == nil {
+		return router
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authIdentity(r) == "" {
+			if ok, retryAfter := limiter.allow(clientIP(r)); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				writeError(w, r, http.StatusTooManyRequests, ErrRateLimited, "too many requests")
+				stats.Errored(ErrRateLimited)
+				rateLimitedCounter.Inc()
+				return
+			}
+		}
+		router.ServeHTTP(w, r)
+	})
+}
+
+// Charges the client, and their tenant, for each of the n renders or
+// players the request asks for, of which rateLimitHandler and tenantHandler
+// have already charged one, so a batch costs what asking for each would.
+// The client's next requests wait until it's paid for. Asking for more than
+// their burst is refused with a 429, returning false.
+func chargeRateLimit(w http.ResponseWriter, r *http.Request, n int) bool {
+	if n <= 1 {
+		return true
+	}
+	buckets := []*TokenBucket{}
+	if rateLimiter != nil && authIdentity(r) == "" {
+		buckets = append(buckets, rateLimiter.bucket(clientIP(r)))
+	}
+	if tenant := tenants.identify(r); tenant != nil && tenant.bucket != nil {
+		buckets = append(buckets, tenant.bucket)
+	}
+	for _, bucket := range buckets {
+		if float64(n) > bucket.Burst {
+			writeError(w, r, http.StatusTooManyRequests, ErrRateLimited, "at most %d may be asked for at once", int(bucket.Burst))
+			stats.Errored(ErrRateLimited)
+			rateLimitedCounter.Inc()
+			return false
+		}
+	}
+	for _, bucket := range buckets {
+		bucket.charge(n - 1)
+	}
+	return true
+}
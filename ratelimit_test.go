@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimitHandler(t *testing.T) {
+	stats = MakeStatsCollector()
+	handler := rateLimitHandler(MakeRateLimiter(0.5, 2), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/avatar/clone1018", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	for i := 0; i < 2; i++ {
+		if w := request("192.0.2.1:1234"); w.Code != http.StatusOK {
+			t.Fatalf("Expected the burst to be allowed, got %d", w.Code)
+		}
+	}
+	w := request("192.0.2.1:5678")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "2" {
+		t.Fatalf("Expected a 429 with Retry-After, got %d %s", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := request("192.0.2.2:1234"); w.Code != http.StatusOK {
+		t.Fatal("Expected other clients to have their own limit")
+	}
+
+	r := httptest.NewRequest("GET", "/avatar/clone1018", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r = r.WithContext(context.WithValue(r.Context(), authContextKey{}, "key:ops"))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatal("Expected authenticated callers not to be limited")
+	}
+}
+
+func TestRateLimiterExpiresIdleClients(t *testing.T) {
+	l := MakeRateLimiter(1, 1)
+	l.allow("192.0.2.1")
+	l.allow("192.0.2.2")
+	l.buckets["192.0.2.1"].last = l.buckets["192.0.2.1"].last.Add(-time.Minute)
+	l.expire()
+	if _, exists := l.buckets["192.0.2.1"]; exists || len(l.buckets) != 1 {
+		t.Fatal("Expected only the idle client to be forgotten")
+	}
+}
+
+func TestChargeRateLimit(t *testing.T) {
+	stats = MakeStatsCollector()
+	defer func() { rateLimiter, tenants = nil, nil }()
+	rateLimiter = MakeRateLimiter(1, 5)
+	var err error
+	if tenants, err = MakeTenants(map[string]*Tenant{"mineplex": {APIKey: []string{"secret"}, Rate: 1, Burst: 5}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	handler := rateLimitHandler(rateLimiter, tenantHandler(tenants, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chargeRateLimit(w, r, n)
+	})))
+	serve := func(size int, tenant bool) int {
+		n = size
+		r := httptest.NewRequest("POST", "/api/render/batch", nil)
+		if tenant {
+			r.Header.Set("X-API-Key", "secret")
+			r = r.WithContext(context.WithValue(r.Context(), authContextKey{}, "mineplex"))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// More than the burst is never let through.
+	if code := serve(6, false); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected a batch bigger than the burst to be refused, got %d", code)
+	}
+	// A batch of four is, but costs four tokens, so with the one the refused
+	// request cost, the client waits until it's paid for.
+	if code := serve(4, false); code != http.StatusOK {
+		t.Fatalf("Expected the batch to be let through, got %d", code)
+	}
+	if code := serve(1, false); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected the client to wait for the batch to be paid for, got %d", code)
+	}
+
+	// Tenants aren't held to the client limit, but are to their own.
+	if code := serve(6, true); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected a tenant's batch bigger than its burst to be refused, got %d", code)
+	}
+	if code := serve(4, true); code != http.StatusOK {
+		t.Fatalf("Expected the tenant's batch to be let through, got %d", code)
+	}
+	if code := serve(1, true); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected the tenant to wait for its batch to be paid for, got %d", code)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+
+	"github.com/minotar/imgd/pkg/mcskin"
+)
+
+// Returned for a render which panicked.
+var errRenderPanicked = errors.New("render panicked")
+
+// Logs a panic we recovered from with its stack and the request it was
+// for, and counts it by where it happened.
+func logPanic(ctx context.Context, where string, p interface{}) {
+	log.Errorf("Panic in %s (request %s): %v\n%s", where, requestIDFrom(ctx), p, debug.Stack())
+	panicCounter.WithLabelValues(where).Inc()
+	stats.Errored(ErrPanic)
+}
+
+// Notes whether the response has been started, so a panic after that
+// doesn't write a second one over it.
+type panicWriter struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (w *panicWriter) WriteHeader(status int) {
+	w.wrote = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *panicWriter) Write(data []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(data)
+}
+
+// Recovers from panics in the handlers, answering with a 500 rather than
+// dropping the connection. http.ErrAbortHandler is let through, as it's
+// how a handler means to drop the connection.
+func recoverHandler(router http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pw := &panicWriter{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			} else if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			logPanic(r.Context(), "handler", p)
+			if !pw.wrote {
+				writePanicResponse(w, r)
+			}
+		}()
+		router.ServeHTTP(pw, r)
+	})
+}
+
+// Whether the request is for JSON rather than an image.
+func wantsJSON(r *http.Request) bool {
+	for _, prefix := range []string{"/api/", "/admin/", "/stats", "/status", "/version"} {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// Answers a request whose handler panicked with a 500: JSON for the API, or
+// the fallback skin's head for images, so a page full of avatars shows
+// heads rather than broken images.
+func writePanicResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Del("Content-Length")
+	w.Header().Del("ETag")
+	w.Header().Set("Cache-Control", "no-store")
+	id := requestIDFrom(r.Context())
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "internal server error", "request_id": id})
+		return
+	}
+
+	if image := panicImage(); image != nil {
+		w.Header().Set("Content-Type", "image/png")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(image)
+		return
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, "500 internal server error (request %s)", id)
+}
+
+var (
+	panicImageOnce sync.Once
+	panicImageData []byte
+)
+
+// Returns the fallback skin's head as a PNG, or nil if even that can't be
+// drawn.
+func panicImage() []byte {
+	panicImageOnce.Do(func() {
+		defer func() {
+			if p := recover(); p != nil {
+				logPanic(context.Background(), "panic_image", p)
+			}
+		}()
+		skin := &mcskin.Render{Skin: fallbackSkin(), Mode: "Normal"}
+		if err := skin.GetHelm(int(DefaultWidth)); err != nil {
+			return
+		}
+		buf := new(bytes.Buffer)
+		if err := skin.WritePNG(buf); err == nil {
+			panicImageData = buf.Bytes()
+		}
+	})
+	return panicImageData
+}
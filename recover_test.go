@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRecoverHandler(t *testing.T) {
+	stats = MakeStatsCollector()
+	handler := requestIDHandler(recoverHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("wrote") == "1" {
+			w.WriteHeader(http.StatusOK)
+		}
+		panic("oops")
+	})))
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := serve("/api/profiles")
+	body := map[string]string{}
+	if w.Code != http.StatusInternalServerError || json.Unmarshal(w.Body.Bytes(), &body) != nil || body["request_id"] != w.Header().Get(RequestIDHeader) {
+		t.Fatalf("Expected a JSON 500 with the request ID, got %d %s", w.Code, w.Body)
+	}
+
+	w = serve("/avatar/clone1018")
+	if w.Code != http.StatusInternalServerError || w.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("Expected an image 500, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if _, err := png.Decode(w.Body); err != nil {
+		t.Fatalf("Expected the fallback head (%v)", err)
+	}
+
+	if w := serve("/avatar/clone1018?wrote=1"); w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Fatalf("Expected a response already started to be left alone, got %d", w.Code)
+	}
+}
+
+func TestRecoverHandlerLetsAbortsThrough(t *testing.T) {
+	handler := recoverHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Fatalf("Expected http.ErrAbortHandler to be panicked on, got %v", p)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/avatar/clone1018", nil))
+}
+
+func TestRenderPoolRecovers(t *testing.T) {
+	stats = MakeStatsCollector()
+	pool := MakeRenderPool(1, 1, 200)
+	if err := pool.do(context.Background(), func() { panic("oops") }); err != errRenderPanicked {
+		t.Fatalf("Expected the panic to be returned as an error, got %v", err)
+	}
+	ran := false
+	if err := pool.do(context.Background(), func() { ran = true }); err != nil || !ran {
+		t.Fatal("Expected the worker to carry on after the panic")
+	}
+}
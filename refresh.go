@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/minotar/minecraft"
+)
+
+// Refreshes stale skins in the background, so the request which noticed
+// they were stale doesn't wait on Mojang. Only one refresh runs per player
+// at a time.
+type Refresher struct {
+	mu      sync.Mutex
+	pending map[string]bool
+}
+
+func MakeRefresher() *Refresher {
+	return &Refresher{pending: map[string]bool{}}
+}
+
+// Starts refreshing the skin for the UUID, unless we're already doing so.
+func (r *Refresher) refresh(uuid string) {
+	// Textures are cached alongside players, but never change, so there's
+	// nothing to ask the session server about.
+	if !isUUID(uuid) {
+		return
+	}
+	r.mu.Lock()
+	if r.pending[uuid] {
+		r.mu.Unlock()
+		return
+	}
+	r.pending[uuid] = true
+	r.mu.Unlock()
+	refreshPendingGauge.Inc()
+
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				logPanic(context.Background(), "refresh", p)
+			}
+			r.mu.Lock()
+			delete(r.pending, uuid)
+			r.mu.Unlock()
+			refreshPendingGauge.Dec()
+		}()
+
+		// Mojang knows nothing of offline mode players, only the store
+		// does, which has the skin ready.
+		if isOfflineUUID(uuid) {
+			if _, reason := fetchStoredSkin(uuid); reason != NegativeNone {
+				log.Infof("Failed to refresh stale skin: %s (%s)", uuid, reason)
+				stats.Errored(ErrRefresh)
+			}
+			return
+		}
+		// Nor does it know which skin Bedrock players wear, Geyser does.
+		if config.Bedrock.URL != "" && isFloodgateUUID(uuid) {
+			if skin := fetchGeyserSkin(context.Background(), uuid); skin.Fallback {
+				log.Infof("Failed to refresh stale skin: %s", uuid)
+				stats.Errored(ErrRefresh)
+			}
+			return
+		}
+
+		// The stale skin isn't downloaded again, if its texture's unchanged.
+		var previous minecraft.Skin
+		if cache.has(uuid) {
+			previous = cache.pull(uuid)
+		}
+		skin, _, reason := fetchSkinForUUIDSince(context.Background(), uuid, uuid, previous)
+		if reason != NegativeNone {
+			// Keep serving the stale skin until it hits its hard TTL.
+			log.Infof("Failed to refresh stale skin: %s (%s)", uuid, reason)
+			stats.Errored(ErrRefresh)
+			return
+		}
+		cache.add(uuid, skin, skinCacheTtl())
+	}()
+}
+
+// Number of refreshes running.
+func (r *Refresher) pendingCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.pending)
+}
+
+// Whether the UUID's cached skin is past its soft TTL. Only caches which
+// can tell us how long an entry has left support this.
+func isStale(uuid string) bool {
+	aging, ok := cache.(agingCache)
+	if !ok || config.staleTtl() <= 0 {
+		return false
+	}
+	remaining, ok := aging.expiresIn(uuid)
+	return ok && remaining < config.staleTtl()
+}
+
+// The hard TTL to store skins with. Where we can serve stale skins, they're
+// kept around past the skin TTL for the stale window.
+func skinCacheTtl() time.Duration {
+	if _, ok := cache.(agingCache); ok {
+		return config.skinTtl() + config.staleTtl()
+	}
+	return config.skinTtl()
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRefreshSkipsTextures(t *testing.T) {
+	refresher := MakeRefresher()
+	refresher.refresh(textureCacheKey("e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"))
+	if refresher.pendingCount() != 0 {
+		t.Fatal("Expected a texture not to be refreshed")
+	}
+}
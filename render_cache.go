@@ -0,0 +1,172 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/minotar/imgd/pkg/mcskin"
+)
+
+// Renders smaller than this aren't worth compressing.
+const renderCompressMin = 1024
+
+var (
+	renderEncoder, _ = zstd.NewWriter(nil)
+	renderDecoder, _ = zstd.NewReader(nil)
+)
+
+// An encoded render, ready to be written out unless it's compressed.
+type cachedRender struct {
+	Key        string
+	Data       []byte
+	Compressed bool
+	Added      time.Time
+}
+
+// Keeps the encoded output of recent renders, so popular avatars don't get
+// rendered and encoded again on every request. Renders are keyed on the
+// texture they came from, so a player changing their skin naturally misses.
+// The least recently used renders are evicted to stay within MaxMem.
+type RenderCache struct {
+	// Bytes the renders may take up, 0 to disable the cache.
+	MaxMem uint64
+	// Whether to zstd compress larger renders. PNGs are already compressed,
+	// so this mostly helps SVGs.
+	Compress bool
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// Renders, most recently used at the front.
+	recency *list.List
+	bytes   uint64
+	// Sum of the Unix times the renders were added, for their average age.
+	addedSum int64
+}
+
+func MakeRenderCache(maxMem uint64, compress bool) *RenderCache {
+	return &RenderCache{
+		MaxMem:   maxMem,
+		Compress: compress,
+		entries:  map[string]*list.Element{},
+		recency:  list.New(),
+	}
+}
+
+// Returns the key a render is cached under. It starts with the render
+// version, as the ETag's made from it, so clients and CDNs holding renders
+// from an older renderer fetch them again.
+func renderKey(resource string, width uint, extension string, skin *mcSkin) string {
+	return fmt.Sprintf("v%d|%s|%d|%s|%+v|%s", mcskin.Version, resource, width, extension, skin.Options, textureKey(skin.Skin))
+}
+
+func (c *RenderCache) enabled() bool {
+	return c.MaxMem > 0
+}
+
+// Returns the encoded render, marking it as the most recently used.
+func (c *RenderCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	elem, exists := c.entries[key]
+	if !exists {
+		c.mu.Unlock()
+		return nil, false
+	}
+	c.recency.MoveToFront(elem)
+	render := elem.Value.(*cachedRender)
+	c.mu.Unlock()
+
+	if !render.Compressed {
+		return render.Data, true
+	}
+	data, err := renderDecoder.DecodeAll(render.Data, nil)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, false
+	}
+	return data, true
+}
+
+// Stores the encoded render, evicting the least recently used renders until
+// we're back within MaxMem.
+func (c *RenderCache) add(key string, data []byte) {
+	if !c.enabled() {
+		return
+	}
+
+	render := &cachedRender{Key: key, Data: data, Added: time.Now()}
+	if c.Compress && len(data) >= renderCompressMin {
+		// Only keep the compressed copy if it's actually smaller.
+		if compressed := renderEncoder.EncodeAll(data, nil); len(compressed) < len(data) {
+			render.Data = compressed
+			render.Compressed = true
+		}
+	}
+	if uint64(len(render.Data)) > c.MaxMem {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, exists := c.entries[key]; exists {
+		c.unlink(elem)
+	}
+	c.entries[key] = c.recency.PushFront(render)
+	c.bytes += uint64(len(render.Data))
+	c.addedSum += render.Added.Unix()
+	countAdmitted("render", uint64(len(render.Data)))
+
+	for c.bytes > c.MaxMem {
+		c.unlink(c.recency.Back())
+		countRemoved("render", removedLRU, 1)
+	}
+}
+
+// Must be called with the lock held.
+func (c *RenderCache) unlink(elem *list.Element) {
+	render := c.recency.Remove(elem).(*cachedRender)
+	delete(c.entries, render.Key)
+	c.bytes -= uint64(len(render.Data))
+	c.addedSum -= render.Added.Unix()
+	countEvicted("render", uint64(len(render.Data)))
+}
+
+// Drops every render.
+func (c *RenderCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	countRemoved("render", removedPurge, uint(len(c.entries)))
+	countEvicted("render", c.bytes)
+	c.entries = map[string]*list.Element{}
+	c.recency = list.New()
+	c.bytes = 0
+	c.addedSum = 0
+}
+
+func (c *RenderCache) size() uint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return uint(len(c.entries))
+}
+
+func (c *RenderCache) memory() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.bytes
+}
+
+func (c *RenderCache) averageAge() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(c.addedSum/int64(len(c.entries)), 0))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRenderCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := MakeRenderCache(10, false)
+
+	c.add("a", []byte("1234"))
+	c.add("b", []byte("1234"))
+	c.get("a")
+	c.add("c", []byte("1234"))
+
+	if _, ok := c.get("b"); ok {
+		t.Fatal("Expected b to be evicted")
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("Expected a to be kept")
+	}
+	if c.memory() != 8 {
+		t.Fatalf("Expected 8 bytes used, got %d", c.memory())
+	}
+
+	c.add("huge", make([]byte, 11))
+	if _, ok := c.get("huge"); ok || c.size() != 2 {
+		t.Fatal("Renders bigger than the whole cache shouldn't be stored")
+	}
+}
+
+func TestRenderCacheCompresses(t *testing.T) {
+	c := MakeRenderCache(1<<20, true)
+
+	svg := []byte(strings.Repeat(`<rect x="0" y="0" width="1" height="1" fill="#000000" />`, 100))
+	c.add("svg", svg)
+	if c.memory() >= uint64(len(svg)) {
+		t.Fatalf("Expected the render to be compressed, took %d bytes", c.memory())
+	}
+	if data, ok := c.get("svg"); !ok || !bytes.Equal(data, svg) {
+		t.Fatal("Expected the render to decompress back to the original")
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// Seconds we ask clients to wait when the render queue is full.
+const renderRetryAfter = 1
+
+// Returned for a render there was no room in the queue for.
+var errRenderQueueFull = errors.New("render queue full")
+
+// Runs the heavy renders, 3D, bodies and large sizes, on a fixed number of
+// workers, so a spike in traffic queues up rather than starving the instance
+// of CPU and memory. Once the queue is full too, renders are refused.
+type RenderPool struct {
+	Workers int
+	Queue   int
+	// Renders at least this wide are heavy, whatever they're of.
+	LargeWidth uint
+
+	jobs chan func()
+}
+
+func MakeRenderPool(workers int, queue int, largeWidth uint) *RenderPool {
+	p := &RenderPool{
+		Workers:    workers,
+		Queue:      queue,
+		LargeWidth: largeWidth,
+		jobs:       make(chan func(), queue),
+	}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *RenderPool) work() {
+	for job := range p.jobs {
+		renderQueueGauge.Dec()
+		job()
+	}
+}
+
+// Whether the render is worth running on the pool.
+func (p *RenderPool) heavy(resource string, width uint) bool {
+	return resourceGroup(resource) != "" || width >= p.LargeWidth
+}
+
+// Runs fn on a worker and waits for it to finish. Returns
+// errRenderQueueFull, without running it, if the queue is full, and the
+// context's error if it's done before fn has finished. Once it's done, fn
+// isn't started, so renders nobody's waiting for anymore don't hold up the
+// queue.
+func (p *RenderPool) do(ctx context.Context, fn func()) error {
+	done := make(chan struct{})
+	abandoned := false
+	var panicked error
+	job := func() {
+		defer close(done)
+		// A panic here would take the whole instance down with it.
+		defer func() {
+			if p := recover(); p != nil {
+				logPanic(ctx, "render", p)
+				panicked = errRenderPanicked
+			}
+		}()
+		if ctx.Err() != nil {
+			abandoned = true
+			renderAbandonedCounter.Inc()
+			return
+		}
+		fn()
+	}
+
+	renderQueueGauge.Inc()
+	select {
+	case p.jobs <- job:
+	default:
+		renderQueueGauge.Dec()
+		return errRenderQueueFull
+	}
+	select {
+	case <-done:
+		if abandoned {
+			return ctx.Err()
+		}
+		return panicked
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Tells the client we're too busy to render for them right now.
+func writeRenderQueueFull(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", strconv.Itoa(renderRetryAfter))
+	writeError(w, r, http.StatusServiceUnavailable, ErrBusy, "too busy rendering, try again shortly")
+	stats.Errored(ErrRenderQueueFull)
+	renderRejectedCounter.Inc()
+}
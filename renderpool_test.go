@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRenderPool(t *testing.T) {
+	stats = MakeStatsCollector()
+	pool := MakeRenderPool(1, 1, 200)
+	if !pool.heavy("Cube", 64) || !pool.heavy("Avatar", 200) || pool.heavy("Helm", 180) {
+		t.Fatal("Expected only 3D and large renders to be heavy")
+	}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	go pool.do(context.Background(), func() {
+		close(started)
+		<-release
+	})
+	<-started
+	go pool.do(context.Background(), func() {})
+	for i := 0; len(pool.jobs) == 0; i++ {
+		if i == 100 {
+			t.Fatal("Expected the second render to be queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if pool.do(context.Background(), func() { t.Fatal("Expected the render not to run") }) != errRenderQueueFull {
+		t.Fatal("Expected the render to be refused with the worker busy and the queue full")
+	}
+	w := httptest.NewRecorder()
+	writeRenderQueueFull(w, httptest.NewRequest("GET", "/avatar/clone1018", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("Expected a 503 with Retry-After, got %d %s", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	close(release)
+	for len(pool.jobs) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	ran := false
+	if pool.do(context.Background(), func() { ran = true }) != nil || !ran {
+		t.Fatal("Expected renders to run once the queue drained")
+	}
+}
+
+func TestRenderPoolSkipsAbandonedRenders(t *testing.T) {
+	stats = MakeStatsCollector()
+	pool := MakeRenderPool(1, 1, 200)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	go pool.do(context.Background(), func() {
+		close(started)
+		<-release
+	})
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() {
+		result <- pool.do(ctx, func() { t.Error("Expected the abandoned render not to run") })
+	}()
+	for len(pool.jobs) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-result; err != context.Canceled {
+		t.Fatalf("Expected to stop waiting once the request went, got %v", err)
+	}
+
+	// The worker gets to it once free, and skips it.
+	close(release)
+	for len(pool.jobs) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := pool.do(context.Background(), func() {}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+)
+
+// Header request IDs are accepted from, and passed on in.
+const RequestIDHeader = "X-Request-ID"
+
+// Request IDs we'll accept from a proxy in front of us. Anything else, eg.
+// something long enough to bloat our logs, is replaced with our own.
+var requestIDRegex = regexp.MustCompile("^[A-Za-z0-9._-]{1,64}$")
+
+type requestIDContextKey struct{}
+
+// Gives each request an ID, or keeps the one a proxy in front of us gave
+// it, so a request can be traced through the proxy's logs, ours, and our
+// requests to Mojang. It's sent back in the response too.
+func requestIDHandler(router http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !requestIDRegex.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		router.ServeHTTP(w, r.WithContext(withRequestID(r.Context(), id)))
+	})
+}
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, id)
+}
+
+// Returns the ID of the request the context is for, if any.
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// A context for work done on behalf of the request, carrying only its ID,
+// and its trace if it's being debugged. Fetches are shared between
+// requests, so they mustn't be cancelled just because the request that
+// started them went away.
+func requestContext(r *http.Request) context.Context {
+	ctx := withRequestID(context.Background(), requestIDFrom(r.Context()))
+	return withDebugTrace(ctx, debugTraceFrom(r.Context()))
+}
+
+// Passes the request ID on to an upstream we're asking on its behalf.
+func forwardRequestID(ctx context.Context, req *http.Request) {
+	if id := requestIDFrom(ctx); id != "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/minotar/minecraft"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := requestIDHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestIDFrom(r.Context())
+	}))
+
+	r := httptest.NewRequest("GET", "/avatar/clone1018", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if len(seen) != 16 || w.Header().Get(RequestIDHeader) != seen {
+		t.Fatalf("Expected a generated ID, got %q and %q", seen, w.Header().Get(RequestIDHeader))
+	}
+
+	r.Header.Set(RequestIDHeader, "proxy-1234.abc")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if seen != "proxy-1234.abc" || w.Header().Get(RequestIDHeader) != seen {
+		t.Fatalf("Expected the proxy's ID to be kept, got %q", seen)
+	}
+
+	r.Header.Set(RequestIDHeader, "not an id\n")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if seen == "not an id\n" || len(seen) != 16 {
+		t.Fatalf("Expected an invalid ID to be replaced, got %q", seen)
+	}
+}
+
+func TestRequestIDSentUpstream(t *testing.T) {
+	var sent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = r.Header.Get(RequestIDHeader)
+	}))
+	defer server.Close()
+	mcClient = &minecraft.Minecraft{Client: server.Client()}
+
+	r := httptest.NewRequest("GET", "/avatar/clone1018", nil)
+	r = r.WithContext(withRequestID(r.Context(), "abc123"))
+	resp, err := upstreamGet(requestContext(r), "Test", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if sent != "abc123" {
+		t.Fatalf("Expected the request ID upstream, got %q", sent)
+	}
+}
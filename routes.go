@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Groups of routes which can be turned off, eg. on a public instance short
+// of CPU or bandwidth, with what they cover.
+var routeGroups = map[string]string{
+	"skins":    "raw skins and capes: /skin/, /download/, /skinurl/, /cape/ and /texture/<hash>.png",
+	"3d":       "isometric renders: /cube/",
+	"bodies":   "bust and body renders, with or without armor",
+	"svg":      "renders as .svg",
+	"textures": "renders by texture hash: /texture/<hash>/<render>",
+	"api":      "the bulk API: /api/",
+	"cards":    "link preview cards: /card/",
+	"montages": "grids of many players' avatars: /montage/",
+}
+
+// Route groups turned off in the config, see routeGroups.
+var disabledRoutes map[string]bool
+
+func parseDisabledRoutes(names []string) (map[string]bool, error) {
+	disabled := map[string]bool{}
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, exists := routeGroups[name]; !exists {
+			return nil, fmt.Errorf("unknown route group %q", name)
+		}
+		disabled[name] = true
+	}
+	return disabled, nil
+}
+
+func routeEnabled(group string) bool {
+	return !disabledRoutes[group]
+}
+
+// The group a render belongs to, or "" for the cheap flat ones which are
+// always served.
+func resourceGroup(resource string) string {
+	if pluginRenderers[resource] != nil {
+		// Plugins' renders cost us no more than a flat one.
+		return ""
+	}
+	switch resource {
+	case "Avatar", "Helm":
+		return ""
+	case "Cube":
+		return "3d"
+	default:
+		return "bodies"
+	}
+}
+
+// Returns the groups the request's path falls in, eg. "bodies" and "svg" for
+// /body/clone1018.svg, going by the path alone.
+func pathRouteGroups(path string) []string {
+	groups := []string{}
+	path = strings.ToLower(path)
+	if strings.HasSuffix(path, ".svg") {
+		groups = append(groups, "svg")
+	}
+
+	prefixGroups := map[string]string{"/api/": "api", "/card/": "cards", "/montage/": "montages",
+		"/skin/": "skins", "/download/": "skins", "/skinurl/": "skins", "/cape/": "skins"}
+	for prefix, group := range prefixGroups {
+		if strings.HasPrefix(path, prefix) {
+			return append(groups, group)
+		}
+	}
+	if strings.HasPrefix(path, "/texture/") {
+		// Either the texture itself, or a render of it.
+		parts := strings.SplitN(strings.TrimPrefix(path, "/texture/"), "/", 2)
+		if len(parts) == 1 {
+			return append(groups, "skins")
+		}
+		groups = append(groups, "textures")
+		path = "/" + parts[1]
+	}
+
+	for _, resource := range renderResources {
+		name := "/" + strings.ToLower(resource)
+		if path == name || strings.HasPrefix(path, name+"/") || strings.HasPrefix(path, name+".") {
+			if group := resourceGroup(resource); group != "" {
+				groups = append(groups, group)
+			}
+			break
+		}
+	}
+	return groups
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestDisabledRoutes(t *testing.T) {
+	var err error
+	disabledRoutes, err = parseDisabledRoutes([]string{"skins", "3D", "svg", "api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { disabledRoutes = nil }()
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+	routed := func(method string, path string) bool {
+		match := &mux.RouteMatch{}
+		return router.Mux.Match(httptest.NewRequest(method, path, nil), match) && match.MatchErr == nil
+	}
+
+	for _, path := range []string{"/skin/clone1018", "/download/clone1018", "/cube/clone1018", "/texture/" + alexTextureHash + "/cube"} {
+		if routed("GET", path) {
+			t.Fatalf("Expected %s to be disabled", path)
+		}
+	}
+	if routed("POST", "/api/profiles") {
+		t.Fatal("Expected the API to be disabled")
+	}
+	for _, path := range []string{"/avatar/clone1018", "/body/clone1018/100", "/texture/" + alexTextureHash + "/helm"} {
+		if !routed("GET", path) {
+			t.Fatalf("Expected %s to be served", path)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.Mux.ServeHTTP(w, httptest.NewRequest("GET", "/avatar/clone1018.svg", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected SVG renders to be disabled, got %d", w.Code)
+	}
+
+	if _, err := parseDisabledRoutes([]string{"animated"}); err == nil {
+		t.Fatal("Expected an unknown group to be an error")
+	}
+}
+
+func TestPathRouteGroups(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/avatar/clone1018/64.png":              "",
+		"/body/clone1018.svg":                   "svg bodies",
+		"/armor/bust/clone1018":                 "bodies",
+		"/cube/clone1018":                       "3d",
+		"/skin/clone1018":                       "skins",
+		"/texture/" + alexTextureHash + ".png":  "skins",
+		"/texture/" + alexTextureHash + "/cube": "textures 3d",
+		"/texture/" + alexTextureHash + "/helm": "textures",
+		"/api/profiles":                         "api",
+		"/montage/clone1018,citricsquid.png":    "montages",
+		"/stats":                                "",
+	} {
+		if groups := strings.Join(pathRouteGroups(path), " "); groups != expected {
+			t.Errorf("Expected %s to be in %q, got %q", path, expected, groups)
+		}
+	}
+}
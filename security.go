@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Routes reporting on imgd itself, rather than serving images.
+var statusPrefixes = []string{"/stats", "/status/", "/version", "/metrics", "/debug/vars", "/admin/"}
+
+// Headers we add to responses to keep browsers from misusing them.
+type SecurityHeaders struct {
+	// Whether to send X-Content-Type-Options: nosniff.
+	NoSniff bool
+	// Referrer-Policy to send, blank for none.
+	ReferrerPolicy string
+	// Content-Security-Policy for the status routes, blank for none.
+	StatusCSP string
+	// Seconds browsers should only use HTTPS for, 0 to not send HSTS.
+	HSTS int
+	// Paths robots.txt tells crawlers not to walk.
+	Disallowed []string
+}
+
+func MakeSecurityHeaders(noSniff bool, referrerPolicy string, statusCSP string, hsts int) *SecurityHeaders {
+	return &SecurityHeaders{NoSniff: noSniff, ReferrerPolicy: referrerPolicy, StatusCSP: statusCSP, HSTS: hsts}
+}
+
+func isStatusPath(path string) bool {
+	for _, prefix := range statusPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Adds the security headers to responses, and answers /robots.txt ahead of
+// authentication so crawlers always see it.
+func (s *SecurityHeaders) handler(router http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.NoSniff {
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+		}
+		if s.ReferrerPolicy != "" {
+			w.Header().Set("Referrer-Policy", s.ReferrerPolicy)
+		}
+		if s.StatusCSP != "" && isStatusPath(r.URL.Path) {
+			w.Header().Set("Content-Security-Policy", s.StatusCSP)
+		}
+		// Browsers ignore it over plain HTTP, so it's only worth sending
+		// once we, or a proxy in front of us, serve HTTPS.
+		if s.HSTS > 0 {
+			w.Header().Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d", s.HSTS))
+		}
+
+		if r.URL.Path == "/robots.txt" {
+			s.writeRobots(w, r)
+			return
+		}
+		router.ServeHTTP(w, r)
+	})
+}
+
+func (s *SecurityHeaders) writeRobots(w http.ResponseWriter, r *http.Request) {
+	setCacheHeaders(w, r, CacheClassStatus)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "User-agent: *\n")
+	for _, path := range s.Disallowed {
+		fmt.Fprintf(w, "Disallow: %s\n", path)
+	}
+}
+
+// Returns the paths crawlers shouldn't walk: those under each route with
+// more to its path than its first part, which are addressed by player or
+// texture, and the aliases for them. The status routes are left to the IP
+// filter and auth.
+func robotsDisallowed(router *mux.Router, aliases []RouteAlias) []string {
+	disallowed := map[string]bool{}
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		first, rest, nested := strings.Cut(strings.TrimPrefix(template, "/"), "/")
+		if nested && rest != "" && !strings.Contains(first, "{") && !isStatusPath("/"+first+"/") {
+			disallowed["/"+first+"/"] = true
+		}
+		return nil
+	})
+	for _, alias := range aliases {
+		for path := range disallowed {
+			if strings.HasPrefix(alias.To, path) {
+				disallowed[alias.From] = true
+				break
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(disallowed))
+	for path := range disallowed {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// Answers with nothing, unlike http.NotFound which sets nosniff itself.
+var emptyHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+func TestSecurityHeaders(t *testing.T) {
+	handler := MakeSecurityHeaders(true, "no-referrer", "default-src 'none'", 0).handler(emptyHandler)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/avatar/clone1018", nil))
+	if w.Header().Get("X-Content-Type-Options") != "nosniff" || w.Header().Get("Referrer-Policy") != "no-referrer" {
+		t.Fatalf("Expected nosniff and the referrer policy, got %v", w.Header())
+	}
+	if w.Header().Get("Content-Security-Policy") != "" || w.Header().Get("Strict-Transport-Security") != "" {
+		t.Fatalf("Expected no CSP on images and no HSTS, got %v", w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/stats", nil))
+	if w.Header().Get("Content-Security-Policy") != "default-src 'none'" {
+		t.Fatalf("Expected the CSP on status routes, got %v", w.Header())
+	}
+
+	handler = MakeSecurityHeaders(false, "", "", 31536000).handler(emptyHandler)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/avatar/clone1018", nil))
+	if w.Header().Get("Strict-Transport-Security") != "max-age=31536000" || w.Header().Get("X-Content-Type-Options") != "" {
+		t.Fatalf("Expected only HSTS, got %v", w.Header())
+	}
+}
+
+func TestRobots(t *testing.T) {
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+	security := MakeSecurityHeaders(false, "", "", 0)
+	security.Disallowed = robotsDisallowed(router.Mux, []RouteAlias{{From: "/a/", To: "/avatar/"}, {From: "/s/", To: "/stats"}})
+	handler := security.handler(emptyHandler)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/robots.txt", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected robots.txt, got %d", w.Code)
+	}
+	for _, path := range []string{"/avatar/", "/card/", "/montage/", "/texture/", "/api/", "/a/"} {
+		if !strings.Contains(w.Body.String(), "Disallow: "+path+"\n") {
+			t.Fatalf("Expected robots.txt to disallow %s, got %q", path, w.Body.String())
+		}
+	}
+	for _, path := range []string{"/stats", "/admin/", "/s/", "/healthz"} {
+		if strings.Contains(w.Body.String(), "Disallow: "+path) {
+			t.Fatalf("Expected robots.txt not to mention %s, got %q", path, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	_ "embed"
+	"fmt"
+	"image/gif"
+	"image/png"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/minotar/minecraft"
+	"gopkg.in/gcfg.v1"
+)
+
+// The skin the self-test renders, bundled so it needs nothing from Mojang.
+//
+//go:embed selftest/skin.png
+var selfTestSkinPNG []byte
+
+// The self-test's skin is cached under this UUID, which no player has.
+const selfTestUUID = "00000000000040008000000000000000"
+
+// Width the self-test renders at, that of the golden renders, so it can
+// check what it gets against those of the classic skin.
+const selfTestWidth = goldenWidth
+
+// How one render in the self-test went.
+type selfTestResult struct {
+	Name     string
+	Checksum string
+	// Whether there was a checksum to check it against.
+	Verified bool
+	Err      error
+}
+
+// Boots the server with the built in defaults, renders the bundled skin as
+// each render in each format through it, and reports how it went, for
+// "imgd serve -self-test". Nothing is fetched from Mojang or written to a
+// shared cache, so it's safe to run anywhere.
+func runSelfTest() int {
+	stats = MakeStatsCollector()
+	readiness = MakeReadiness()
+	*config = Configuration{}
+	if err := gcfg.ReadStringInto(config, configDefaults); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read the default config (%v)\n", err)
+		return 1
+	}
+	config.dropBlankEntries()
+	setupLog(os.Stderr)
+	setupCache()
+	setupAuth()
+	setupRoutes()
+	setupRenderPool()
+	setupRenderers()
+
+	skin := minecraft.Skin{}
+	if err := skin.Decode(bytes.NewReader(selfTestSkinPNG)); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to decode the test skin (%v)\n", err)
+		return 1
+	}
+	skin.Hash = fmt.Sprintf("selftest-%x", md5.Sum(selfTestSkinPNG))
+	skin.Source = "SelfTest"
+	cache.add(selfTestUUID, skin, config.skinTtl())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to listen (%v)\n", err)
+		return 1
+	}
+	server := makeServer(publicHandler())
+	go server.Serve(listener)
+	defer server.Close()
+
+	results := selfTest("http://" + listener.Addr().String())
+	failed := 0
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			failed++
+			fmt.Printf("FAIL  %-18s %v\n", result.Name, result.Err)
+		case result.Verified:
+			fmt.Printf("ok    %-18s %s\n", result.Name, result.Checksum)
+		default:
+			fmt.Printf("ok    %-18s %s (not checked)\n", result.Name, result.Checksum)
+		}
+	}
+	fmt.Printf("%d of %d renders passed\n", len(results)-failed, len(results))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// Requests each render of the test skin in each format from the server.
+// Those there are golden renders of are checked against them. WebPs and
+// GIFs are only checked to be what they claim.
+func selfTest(baseURL string) []selfTestResult {
+	_, golden, err := parseGoldenChecksums(goldenChecksums)
+	if err != nil {
+		return []selfTestResult{{Name: "golden checksums", Err: err}}
+	}
+	exts := []string{}
+	for ext := range renderFormats {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	results := []selfTestResult{}
+	for _, resource := range renderResources {
+		for _, ext := range exts {
+			name := strings.ToLower(resource) + ext
+			result := selfTestResult{Name: name}
+			body, err := selfTestGet(client, fmt.Sprintf("%s/%s/%s/%d%s", baseURL, strings.ToLower(resource), selfTestUUID, selfTestWidth, ext), renderFormats[ext])
+			if err == nil {
+				result.Checksum, err = checkSelfTestRender(ext, body)
+			}
+			// The golden renders skip Armour, it being Armor spelt
+			// differently.
+			goldenName := "classic/" + strings.Replace(name, "armour/", "armor/", 1)
+			if expected, known := golden[goldenName]; err == nil && known {
+				result.Verified = true
+				if result.Checksum != expected {
+					err = fmt.Errorf("checksum %s, expected %s", result.Checksum, expected)
+				}
+			}
+			result.Err = err
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+func selfTestGet(client *http.Client, url string, contentType string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d (%s)", resp.StatusCode, resp.Header.Get(ErrorHeader))
+	}
+	if got := resp.Header.Get("Content-Type"); got != contentType {
+		return nil, fmt.Errorf("Content-Type %q, expected %q", got, contentType)
+	}
+	return body, nil
+}
+
+// Checks the render decodes to the width asked for, and returns its
+// checksum, as the golden renders have them.
+func checkSelfTestRender(ext string, body []byte) (string, error) {
+	var width int
+	switch ext {
+	case ".png":
+		pngConfig, err := png.DecodeConfig(bytes.NewReader(body))
+		if err != nil {
+			return "", err
+		}
+		width = pngConfig.Width
+	case ".gif":
+		gifConfig, err := gif.DecodeConfig(bytes.NewReader(body))
+		if err != nil {
+			return "", err
+		}
+		width = gifConfig.Width
+	case ".webp":
+		if len(body) < 12 || string(body[:4]) != "RIFF" || string(body[8:12]) != "WEBP" {
+			return "", fmt.Errorf("not a WebP")
+		}
+		width = selfTestWidth
+	case ".svg":
+		if !bytes.HasPrefix(body, []byte("<?xml")) {
+			return "", fmt.Errorf("not an SVG")
+		}
+		width = selfTestWidth
+	}
+	if width != selfTestWidth {
+		return "", fmt.Errorf("%dpx wide, expected %dpx", width, selfTestWidth)
+	}
+	return renderChecksum(ext, body)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func TestSelfTest(t *testing.T) {
+	saved := *config
+	defer func() { *config = saved }()
+
+	if code := runCommand([]string{"--self-test"}); code != 0 {
+		t.Fatalf("Expected the self-test to pass, got exit code %d", code)
+	}
+}
+
+func TestCheckSelfTestRender(t *testing.T) {
+	buf := &bytes.Buffer{}
+	png.Encode(buf, image.NewNRGBA(image.Rect(0, 0, 32, 32)))
+	if _, err := checkSelfTestRender(".png", buf.Bytes()); err == nil {
+		t.Fatal("Expected a render of the wrong width to fail")
+	}
+	if _, err := checkSelfTestRender(".webp", buf.Bytes()); err == nil {
+		t.Fatal("Expected a PNG claiming to be a WebP to fail")
+	}
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"os/signal"
 	"runtime/pprof"
 	"syscall"
+	"time"
 )
 
 type SignalHandler struct {
@@ -47,6 +49,10 @@ func (s *SignalHandler) handleSignal(signal os.Signal) {
 			break
 		}
 		log.Noticef("Dumped block pprof to %s", tf.Name())
+	case syscall.SIGTERM, syscall.SIGINT:
+		log.Noticef("Received %s, shutting down", signal)
+		shutdown(time.Duration(config.Server.ShutdownGrace) * time.Second)
+		os.Exit(0)
 	case syscall.SIGUSR2:
 		tf, err := ioutil.TempFile("", "goroutine")
 		if err != nil {
@@ -69,6 +75,50 @@ func (s *SignalHandler) handleSignal(signal os.Signal) {
 	}
 }
 
+// Stops accepting connections and waits up to the grace period for
+// in-flight requests to finish, then saves what we've cached so we come
+// back warm.
+func shutdown(grace time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if httpServer != nil {
+		if err := httpServer.Shutdown(ctx); err != nil {
+			log.Warningf("Gave up draining requests after %s (%v)", grace, err)
+		}
+	}
+	for _, server := range listenServers {
+		server.Shutdown(ctx)
+	}
+	if err := drainH2C(ctx); err != nil {
+		log.Warningf("Gave up draining h2c connections after %s (%v)", grace, err)
+	}
+	if internalSrv != nil {
+		internalSrv.Shutdown(ctx)
+	}
+	if grpcServer != nil {
+		stopGRPCServer(ctx)
+	}
+	if snapshotter != nil {
+		if err := snapshotter.save(); err != nil {
+			log.Errorf("Snapshot failed (%v)", err)
+		}
+	}
+	if handoff != nil {
+		handoff.Stop()
+	}
+	if watcher != nil {
+		watcher.Stop()
+	}
+	if prefetcher != nil {
+		prefetcher.Stop()
+	}
+	if statsd != nil {
+		// So what happened since the last flush isn't lost.
+		close(statsd.stop)
+		statsd.flush()
+	}
+}
+
 func (s *SignalHandler) Stop() {
 	s.stopChannel <- 1
 }
@@ -77,7 +127,7 @@ func MakeSignalHandler() *SignalHandler {
 	s := new(SignalHandler)
 	s.stopChannel = make(chan int)
 	s.signalChannel = make(chan os.Signal, 2)
-	signal.Notify(s.signalChannel, syscall.SIGUSR1, syscall.SIGUSR2)
+	signal.Notify(s.signalChannel, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGTERM, syscall.SIGINT)
 	go s.run()
 	return s
 }
@@ -2,12 +2,14 @@ package main
 
 import (
 	"bytes"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
 	"strings"
 	"syscall"
 	"testing"
-
-	"github.com/op/go-logging"
+	"time"
 )
 
 type StashingWriter struct {
@@ -24,12 +26,11 @@ func (w *StashingWriter) Unstash() string {
 
 func testSetupSignals() *StashingWriter {
 	sw := new(StashingWriter)
-	logBackend := logging.NewLogBackend(sw, "", 0)
 	stats = MakeStatsCollector()
 	setupConfig()
-	setupLog(logBackend)
+	setupLog(sw)
 	// Ensure we have debug log level
-	logging.SetLevel(5, "")
+	log.SetLevel(slog.LevelDebug)
 	setupCache()
 	return sw
 }
@@ -47,7 +48,7 @@ func testDumpsProfile(t *testing.T, prefixText string, signal os.Signal) {
 		t.Fatalf("'%s' occurred multiple times in log", prefixText)
 	}
 	pprofLocation := logOutput[strings.Index(logOutput, prefixText)+len(prefixText):]
-	pprofLocation = pprofLocation[:strings.Index(pprofLocation, "\n")]
+	pprofLocation = pprofLocation[:strings.IndexAny(pprofLocation, "\"\n")]
 	if _, err := os.Stat(pprofLocation); os.IsNotExist(err) {
 		t.Fatal("Pprof output path " + pprofLocation + " does not exist!")
 	} else {
@@ -61,3 +62,36 @@ func TestDumpsBlockProfile(t *testing.T) {
 func TestDumpsGoroutineProfile(t *testing.T) {
 	testDumpsProfile(t, "Dumped goroutine pprof to ", syscall.SIGUSR2)
 }
+
+func TestShutdownDrains(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	httpServer = &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	})}
+	defer func() { httpServer = nil }()
+	go httpServer.Serve(listener)
+
+	result := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err == nil {
+			resp.Body.Close()
+		}
+		result <- err
+	}()
+	<-started
+
+	shutdown(5 * time.Second)
+	if err := <-result; err != nil {
+		t.Fatalf("Expected the in-flight request to finish, got %v", err)
+	}
+	if _, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		t.Fatal("Expected new connections to be refused")
+	}
+}
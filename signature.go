@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+)
+
+// Reads the public key textures properties are signed with, eg. Mojang's
+// yggdrasil_session_pubkey.der. Either PEM or DER encoding is accepted.
+func readSignatureKey(path string) (*rsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseSignatureKey(data)
+}
+
+func parseSignatureKey(data []byte) (*rsa.PublicKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+
+	key, err := x509.ParsePKIXPublicKey(data)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("not an RSA public key")
+	}
+	return rsaKey, nil
+}
+
+// Checks the property was signed by the auth server, so a skin URL from a
+// proxied or mirrored auth server can be trusted.
+func verifyProperty(key *rsa.PublicKey, property sessionProfileProp) error {
+	if property.Signature == "" {
+		return fmt.Errorf("unable to VerifyTextureProperty: unsigned")
+	}
+	signature, err := base64.StdEncoding.DecodeString(property.Signature)
+	if err != nil {
+		return fmt.Errorf("unable to VerifyTextureProperty: %v", err)
+	}
+	// The signature is over the base64 value as given, not what it decodes to.
+	hash := sha1.Sum([]byte(property.Value))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA1, hash[:], signature); err != nil {
+		return fmt.Errorf("unable to VerifyTextureProperty: invalid signature")
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"testing"
+)
+
+func TestVerifyProperty(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value := base64.StdEncoding.EncodeToString([]byte(`{"textures":{"SKIN":{"url":"http://textures.minecraft.net/texture/abc"}}}`))
+	hash := sha1.Sum([]byte(value))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA1, hash[:])
+	property := sessionProfileProp{Name: "textures", Value: value, Signature: base64.StdEncoding.EncodeToString(signature)}
+
+	if err := verifyProperty(&key.PublicKey, property); err != nil {
+		t.Fatalf("Expected the signature to verify, got %v", err)
+	}
+
+	stats = MakeStatsCollector()
+	signatureKey = &key.PublicKey
+	defer func() { signatureKey = nil }()
+
+	profile := Profile{}
+	if err := applyProperties(&profile, []sessionProfileProp{property}); err != nil || profile.SkinURL == "" {
+		t.Fatalf("Expected the signed textures to be used, got %v", err)
+	}
+
+	tampered := property
+	tampered.Value = base64.StdEncoding.EncodeToString([]byte(`{"textures":{"SKIN":{"url":"http://evil.example/abc"}}}`))
+	profile = Profile{}
+	if err := applyProperties(&profile, []sessionProfileProp{tampered}); err == nil || profile.SkinURL != "" {
+		t.Fatal("Expected tampered textures to be refused")
+	}
+
+	unsigned := property
+	unsigned.Signature = ""
+	if err := applyProperties(&profile, []sessionProfileProp{unsigned}); err == nil {
+		t.Fatal("Expected unsigned textures to be refused")
+	}
+}
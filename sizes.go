@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Widths a render may be asked for, as a [size "<render>"] section, eg.
+// [size "avatar"] or [size "armor/bust"], or [size "default"] for renders
+// without their own. Public instances can hold renders to a handful of
+// widths, so nobody can defeat their CDN and render caches by asking for
+// every width in turn.
+type RouteSize struct {
+	// Width when none's asked for, 0 for DefaultWidth.
+	Default uint
+	// Widths allowed. Without any, those from Min to Max are, in steps of
+	// Step from Min.
+	Allow []uint
+	Min   uint
+	Max   uint
+	Step  uint
+}
+
+// Returns the widths allowed for the render, nil if it may be any.
+func routeSize(resource string) *RouteSize {
+	if size := config.Size[strings.ToLower(resource)]; size != nil {
+		return size
+	}
+	return config.Size["default"]
+}
+
+func (s *RouteSize) defaultWidth() uint {
+	if s.Default == 0 {
+		return DefaultWidth
+	}
+	return s.Default
+}
+
+func (s *RouteSize) allows(width uint) bool {
+	if len(s.Allow) > 0 {
+		for _, allowed := range s.Allow {
+			if width == allowed {
+				return true
+			}
+		}
+		return false
+	}
+	min, max := s.Min, s.Max
+	if min == 0 {
+		min = MinWidth
+	}
+	if max == 0 {
+		max = MaxWidth
+	}
+	if width < min || width > max {
+		return false
+	}
+	return s.Step <= 1 || (width-min)%s.Step == 0
+}
+
+// Returns an error if the section isn't for a render we serve, or allows no
+// width we'd draw, or doesn't allow its own default.
+func checkRouteSize(name string, s *RouteSize, renderers map[string]*ExternalRenderer) error {
+	known := name == "default" || renderers[name] != nil
+	for _, resource := range renderResources {
+		known = known || strings.ToLower(resource) == name
+	}
+	if !known {
+		return fmt.Errorf("unknown render %q", name)
+	}
+	for _, width := range append(append([]uint{}, s.Allow...), s.Min, s.Max) {
+		if width != 0 && (width < MinWidth || width > MaxWidth) {
+			return fmt.Errorf("%d is outside %d to %d", width, MinWidth, MaxWidth)
+		}
+	}
+	if s.Min != 0 && s.Max != 0 && s.Min > s.Max {
+		return fmt.Errorf("min is more than max")
+	}
+	if !s.allows(s.defaultWidth()) {
+		return fmt.Errorf("the default, %d, isn't allowed", s.defaultWidth())
+	}
+	return nil
+}
+
+// Returns the width asked for, or the render's default if none was, and
+// whether it's allowed. Renders without a [size] section may be any width,
+// clamped to those we draw.
+func (router *Router) routeWidth(resource string, inp string) (uint, bool) {
+	size := routeSize(resource)
+	if size == nil {
+		return router.GetWidth(inp), true
+	}
+	if inp == "" {
+		return size.defaultWidth(), true
+	}
+	width, err := strconv.ParseUint(inp, 10, 0)
+	if err != nil {
+		return 0, false
+	}
+	return uint(width), size.allows(uint(width))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/minecraft"
+)
+
+func TestRouteSizeAllows(t *testing.T) {
+	listed := &RouteSize{Allow: []uint{32, 64}}
+	stepped := &RouteSize{Min: 50, Max: 300, Step: 50}
+	for _, test := range []struct {
+		size    *RouteSize
+		width   uint
+		allowed bool
+	}{
+		{listed, 32, true},
+		{listed, 33, false},
+		{stepped, 150, true},
+		{stepped, 175, false},
+		{stepped, 350, false},
+		{&RouteSize{}, 8, true},
+		{&RouteSize{}, 301, false},
+	} {
+		if test.size.allows(test.width) != test.allowed {
+			t.Errorf("Expected %d allowed by %+v: %v", test.width, test.size, test.allowed)
+		}
+	}
+}
+
+func TestCheckRouteSize(t *testing.T) {
+	if err := checkRouteSize("armor/bust", &RouteSize{Allow: []uint{180}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	for name, size := range map[string]*RouteSize{
+		"avatar":  {Allow: []uint{32}},
+		"default": {Min: 200, Max: 100},
+		"hat":     {},
+		"body":    {Allow: []uint{1000}, Default: 1000},
+	} {
+		if err := checkRouteSize(name, size, nil); err == nil {
+			t.Errorf("Expected [size %q] %+v to be refused", name, size)
+		}
+	}
+}
+
+func TestServeRouteSizes(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	renderCache = MakeRenderCache(0, false)
+	hotCache = MakeHotCache(nil, 0)
+	missingFilter = MakeMissingFilter(0, time.Minute)
+
+	skin, _ := minecraft.FetchSkinForSteve()
+	skin.Hash = "x"
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+
+	defer func() { config.Size = nil }()
+	config.Size = map[string]*RouteSize{
+		"default": {Allow: []uint{32, 64}, Default: 64},
+		"helm":    {Min: 16, Max: 48, Step: 16},
+	}
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+	serve := func(path string) int {
+		w := httptest.NewRecorder()
+		router.Mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code
+	}
+
+	for path, code := range map[string]int{
+		"/avatar/d9135e082f2244c89cb10d21ed3ac8fd/32.png": http.StatusOK,
+		"/avatar/d9135e082f2244c89cb10d21ed3ac8fd/33.png": http.StatusBadRequest,
+		"/avatar/d9135e082f2244c89cb10d21ed3ac8fd.png":    http.StatusOK,
+		"/helm/d9135e082f2244c89cb10d21ed3ac8fd/48.png":   http.StatusOK,
+		"/helm/d9135e082f2244c89cb10d21ed3ac8fd/64.png":   http.StatusBadRequest,
+	} {
+		if got := serve(path); got != code {
+			t.Errorf("Expected %d for %s, got %d", code, path, got)
+		}
+	}
+
+	if width, _ := router.routeWidth("Avatar", ""); width != 64 {
+		t.Fatalf("Expected the route's default width, got %d", width)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"github.com/minotar/imgd/pkg/mcskin"
+	"github.com/minotar/minecraft"
+)
+
+// A skin as we serve it, with where it came from alongside the render.
+type mcSkin struct {
+	mcskin.Render
+	// Whether the skin is a stand-in for one we couldn't fetch.
+	Fallback bool
+	// Whether we had the skin cached, rather than asking upstream.
+	Cached bool
+}
+
+// Whether the skin is drawn for the slim model. Only the session profile
+// says so outright, and we don't keep that in the cache, so cached skins
+// are checked for the slim model's empty arm columns.
+func isSlimSkin(skin minecraft.Skin) bool {
+	return mcskin.IsSlim(skin)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image/png"
+
+	"github.com/minotar/minecraft"
+)
+
+// Caches other than the memory one store a skin as a PNG. Where it came
+// from, its URL and its texture hash are kept next to the pixels, in tEXt
+// chunks of the same PNG, so they're stored and expire along with it, and
+// whatever reads the PNG elsewhere ignores them. Skins cached before they
+// were kept just come back without them.
+const (
+	skinSourceKeyword = "imgd-source"
+	skinURLKeyword    = "imgd-url"
+	skinHashKeyword   = "imgd-hash"
+)
+
+// Every PNG starts with this, then its IHDR chunk.
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// Size of the IHDR chunk, with its length, type and CRC.
+const pngHeaderChunkSize = 4 + 4 + 13 + 4
+
+// Encodes the skin as a PNG for a cache to store, with its source, URL and
+// hash.
+func encodeSkinPNG(skin minecraft.Skin) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, skin.Image); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+
+	// The text goes straight after the header, where it's read before the
+	// pixels.
+	split := len(pngSignature) + pngHeaderChunkSize
+	text := new(bytes.Buffer)
+	for _, field := range [][2]string{
+		{skinSourceKeyword, skin.Source},
+		{skinURLKeyword, skin.URL},
+		{skinHashKeyword, skin.Hash},
+	} {
+		if field[1] != "" {
+			writePNGChunk(text, "tEXt", []byte(field[0]+"\x00"+field[1]))
+		}
+	}
+	return append(append(append([]byte{}, data[:split]...), text.Bytes()...), data[split:]...), nil
+}
+
+// Decodes a skin stored with encodeSkinPNG, or a plain PNG.
+func decodeSkinPNG(data []byte) (minecraft.Skin, error) {
+	skin := minecraft.Skin{}
+	if err := skin.Decode(bytes.NewReader(data)); err != nil {
+		return minecraft.Skin{}, err
+	}
+	text, err := readPNGText(data)
+	if err != nil {
+		return minecraft.Skin{}, err
+	}
+	skin.Source = text[skinSourceKeyword]
+	skin.URL = text[skinURLKeyword]
+	skin.Hash = text[skinHashKeyword]
+	return skin, nil
+}
+
+func writePNGChunk(buf *bytes.Buffer, kind string, data []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.WriteString(kind)
+	buf.Write(data)
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(kind))
+	crc.Write(data)
+	binary.Write(buf, binary.BigEndian, crc.Sum32())
+}
+
+// Returns the tEXt chunks before the pixels, by keyword.
+func readPNGText(data []byte) (map[string]string, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errors.New("not a PNG")
+	}
+	text := map[string]string{}
+	for offset := len(pngSignature); offset+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[offset:]))
+		kind := string(data[offset+4 : offset+8])
+		if kind == "IDAT" || kind == "IEND" {
+			break
+		}
+		end := offset + 8 + length + 4
+		if length < 0 || end > len(data) {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		if kind == "tEXt" {
+			chunk := data[offset+8 : offset+8+length]
+			if sep := bytes.IndexByte(chunk, 0); sep != -1 {
+				text[string(chunk[:sep])] = string(chunk[sep+1:])
+			}
+		}
+		offset = end
+	}
+	return text, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/minotar/minecraft"
+)
+
+func TestSkinPNGKeepsURL(t *testing.T) {
+	skin := minecraft.Skin{}
+	skin.Image = image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	skin.Source = "SessionProfile"
+	skin.URL = "http://textures.minecraft.net/texture/3b60a1f6d562f52aaebbf1434f1de147933a3affe0e764fa49ea057536623cd3"
+	skin.Hash = "3b60a1f6d562f52aaebbf1434f1de147933a3affe0e764fa49ea057536623cd3"
+
+	data, err := encodeSkinPNG(skin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatalf("Expected a PNG anything can read (%v)", err)
+	}
+	decoded, err := decodeSkinPNG(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Source != skin.Source || decoded.URL != skin.URL || decoded.Hash != skin.Hash {
+		t.Fatalf("Expected the source, URL and hash back, got %q, %q and %q", decoded.Source, decoded.URL, decoded.Hash)
+	}
+	if decoded.Image.Bounds().Dx() != 64 {
+		t.Fatal("Expected the pixels back")
+	}
+}
+
+func TestSkinPNGPlain(t *testing.T) {
+	buf := new(bytes.Buffer)
+	png.Encode(buf, image.NewNRGBA(image.Rect(0, 0, 64, 32)))
+
+	skin, err := decodeSkinPNG(buf.Bytes())
+	if err != nil || skin.URL != "" || skin.Image.Bounds().Dy() != 32 {
+		t.Fatalf("Expected a PNG cached before URLs were kept to decode without one (%v)", err)
+	}
+	if _, err := decodeSkinPNG([]byte("GIF89a")); err == nil {
+		t.Fatal("Expected anything but a PNG to be refused")
+	}
+}
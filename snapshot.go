@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Bumped whenever the snapshot format changes, so we don't try to load one
+// written by an incompatible version.
+const snapshotVersion = 1
+
+type cacheSnapshot struct {
+	Version int
+	// Oldest first, so restoring them in order rebuilds the recency list.
+	Users []snapshotUser
+	// Map of texture keys to the texture, PNG encoded.
+	Textures map[string]snapshotTexture
+}
+
+type snapshotUser struct {
+	Username string
+	Hash     string
+	Reason   NegativeReason
+	Expires  time.Time
+}
+
+type snapshotTexture struct {
+	Source string
+	URL    string
+	Hash   string
+	PNG    []byte
+}
+
+// Caches which can save their contents to, and restore them from, a file.
+type snapshottingCache interface {
+	snapshot(w io.Writer) error
+	restore(r io.Reader) error
+}
+
+// Periodically saves the in-memory cache to disk and loads it back on boot,
+// so a restart doesn't send a stampede of cold-cache traffic to Mojang.
+type Snapshotter struct {
+	Path     string
+	Interval time.Duration
+	stop     chan struct{}
+}
+
+func MakeSnapshotter(path string, interval time.Duration) *Snapshotter {
+	return &Snapshotter{Path: path, Interval: interval, stop: make(chan struct{})}
+}
+
+func (s *Snapshotter) run() {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.save(); err != nil {
+				log.Errorf("Snapshot failed (%v)", err)
+				stats.Errored(ErrSnapshot)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Writes the cache out to the snapshot file. The file is replaced
+// atomically, so a crash part way through leaves the last good snapshot.
+func (s *Snapshotter) save() error {
+	snapshotter, ok := cache.(snapshottingCache)
+	if !ok {
+		return fmt.Errorf("%T can't be snapshotted", cache)
+	}
+
+	start := time.Now()
+	buf := new(bytes.Buffer)
+	if err := snapshotter.snapshot(buf); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.Path, buf.Bytes()); err != nil {
+		return err
+	}
+
+	log.Infof("Saved cache snapshot of %d bytes to %s, took %s", buf.Len(), s.Path, time.Since(start))
+	return nil
+}
+
+// Restores the cache from the snapshot file, if there is one.
+func (s *Snapshotter) load() error {
+	snapshotter, ok := cache.(snapshottingCache)
+	if !ok {
+		return fmt.Errorf("%T can't be snapshotted", cache)
+	}
+
+	file, err := os.Open(s.Path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := snapshotter.restore(file); err != nil {
+		return err
+	}
+	log.Noticef("Restored cache snapshot from %s (skins: %d)", s.Path, cache.size())
+	return nil
+}
+
+func (s *Snapshotter) Stop() {
+	close(s.stop)
+}
+
+func encodeSnapshot(w io.Writer, snapshot *cacheSnapshot) error {
+	snapshot.Version = snapshotVersion
+	return gob.NewEncoder(w).Encode(snapshot)
+}
+
+func decodeSnapshot(r io.Reader) (*cacheSnapshot, error) {
+	snapshot := &cacheSnapshot{}
+	if err := gob.NewDecoder(r).Decode(snapshot); err != nil {
+		return nil, err
+	}
+	if snapshot.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", snapshot.Version)
+	}
+	return snapshot, nil
+}
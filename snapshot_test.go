@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"testing"
+	"time"
+
+	"github.com/minotar/minecraft"
+)
+
+func TestCacheMemorySnapshotRoundTrip(t *testing.T) {
+	c := &CacheMemory{}
+	c.setup()
+
+	skin := minecraft.Skin{}
+	skin.Hash = "clone1018"
+	skin.Source = "SessionProfile"
+	skin.Image = image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	c.add("clone1018", skin, time.Minute)
+	c.add("expired", skin, -time.Minute)
+	c.addNegative("nobody", NegativeNotFound, time.Minute)
+
+	buf := new(bytes.Buffer)
+	if err := c.snapshot(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := &CacheMemory{}
+	restored.setup()
+	if err := restored.restore(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if !restored.has("clone1018") || restored.pull("clone1018").Source != "SessionProfile" {
+		t.Fatal("Expected clone1018 to be restored")
+	}
+	if restored.has("expired") {
+		t.Fatal("Expired entries shouldn't be restored")
+	}
+	if restored.pullNegative("nobody") != NegativeNotFound {
+		t.Fatal("Expected the negative entry to be restored")
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// Most sizes a srcset may be asked for with.
+	MaxSrcsetSizes = 10
+	// Signed URLs in a srcset are good for at least this long, and change
+	// this often, so pages embedding them stay cacheable.
+	srcsetSignedTtl = 24 * time.Hour
+)
+
+var textureHashPattern = regexp.MustCompile("^" + textureHashRegex + "$")
+
+// A render of the player at one of the sizes asked for.
+type srcsetURL struct {
+	Size uint
+	URL  string
+}
+
+type srcsetResult struct {
+	// For an <img>'s srcset, eg. "https://.../helm/clone1018/32 32w, ...".
+	Srcset string
+	URLs   []srcsetURL
+}
+
+// Parses a comma separated list of sizes, clamped to those we render, in
+// the order given and without repeats. Sizes the render's [size] section
+// doesn't allow are refused.
+func (router *Router) parseSrcsetSizes(resource string, list string) ([]uint, error) {
+	sizes := []uint{}
+	seen := map[uint]bool{}
+	for _, size := range strings.Split(list, ",") {
+		size = strings.TrimSpace(size)
+		if _, err := strconv.ParseUint(size, 10, 0); err != nil {
+			return nil, fmt.Errorf("invalid size %q", size)
+		}
+		width, allowed := router.routeWidth(resource, size)
+		if !allowed {
+			return nil, fmt.Errorf("size %s not allowed", size)
+		}
+		if !seen[width] {
+			seen[width] = true
+			sizes = append(sizes, width)
+		}
+	}
+	if len(sizes) > MaxSrcsetSizes {
+		return nil, fmt.Errorf("at most %d sizes may be asked for", MaxSrcsetSizes)
+	}
+	return sizes, nil
+}
+
+// Returns the HMAC authenticator we'd sign URLs for, if renders need to be
+// signed to be served. With only the image routes signed, URLs are only
+// signed for requests an authenticator identified, lest anyone could get
+// them.
+func srcsetSigner(r *http.Request) *HMACAuthenticator {
+	if config.Auth.Required || (config.Auth.Signed && authIdentity(r) != "") {
+		return hmacSigner()
+	}
+	return nil
+}
+
+// Returns the path to the render of the skin at the size. Skins from Mojang
+// are rendered by their texture's hash, which never changes what it shows,
+// so the URL can be cached for good.
+func srcsetPath(resource string, username string, size uint, skin *mcSkin) string {
+	if skin != nil && !skin.Fallback && skin.URL != "" && textureHashPattern.MatchString(skin.Hash) && routeEnabled("textures") {
+		return fmt.Sprintf("/texture/%s/%s/%d", strings.ToLower(skin.Hash), strings.ToLower(resource), size)
+	}
+	return fmt.Sprintf("/%s/%s/%d", strings.ToLower(resource), username, size)
+}
+
+// SrcsetPage returns the URLs of a render of the player at each of the sizes
+// asked for, eg. /api/srcset/clone1018?type=helm&sizes=32,64,128, ready to
+// put in a responsive <img>. It's JSON, or with format=html the srcset
+// attribute's value alone, escaped for HTML. With [auth] requiring it, the
+// URLs are signed.
+func (router *Router) SrcsetPage(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+	query := r.URL.Query()
+	name := query.Get("type")
+	if name == "" {
+		name = "avatar"
+	}
+	resource, ok := batchResource(name)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, ErrBadRequest, "unknown render type %q", name)
+		return
+	}
+	width, _ := router.routeWidth(resource, "")
+	sizes := []uint{width}
+	if list := query.Get("sizes"); list != "" {
+		var err error
+		if sizes, err = router.parseSrcsetSizes(resource, list); err != nil {
+			writeError(w, r, http.StatusBadRequest, ErrBadRequest, "%s", err.Error())
+			return
+		}
+	}
+	stats.Requested("Srcset")
+
+	skin := fetchSkinForRequest(r, username, true)
+	signer := srcsetSigner(r)
+	// Rounded, so the URLs only change once a period.
+	period := int64(srcsetSignedTtl / time.Second)
+	expires := (time.Now().Unix()/period + 2) * period
+
+	result := srcsetResult{URLs: []srcsetURL{}}
+	candidates := []string{}
+	for _, size := range sizes {
+		path := srcsetPath(resource, username, size, skin)
+		link := strings.TrimRight(config.Server.URL, "/") + path
+		if signer != nil {
+			link += "?" + url.Values{
+				"expires": {strconv.FormatInt(expires, 10)},
+				"sig":     {signer.Sign(path, expires)},
+			}.Encode()
+		}
+		result.URLs = append(result.URLs, srcsetURL{Size: size, URL: link})
+		candidates = append(candidates, fmt.Sprintf("%s %dw", link, size))
+	}
+	result.Srcset = strings.Join(candidates, ", ")
+
+	if query.Get("format") == "html" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, html.EscapeString(result.Srcset))
+		return
+	}
+	data, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
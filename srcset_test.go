@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/minecraft"
+)
+
+func TestSrcsetPage(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	renderCache = MakeRenderCache(0, false)
+	hotCache = MakeHotCache(nil, 0)
+	missingFilter = MakeMissingFilter(0, time.Minute)
+	config.Server.URL = "https://minotar.example/"
+	defer func() { config.Server.URL = "" }()
+
+	skin, _ := minecraft.FetchSkinForSteve()
+	skin.Hash = "a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	skin.URL = "https://textures.minecraft.net/texture/" + skin.Hash
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.Mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := serve("/api/srcset/d9135e082f2244c89cb10d21ed3ac8fd?type=helm&sizes=32,64,32,1000")
+	result := srcsetResult{}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.URLs) != 3 || result.URLs[2].Size != MaxWidth {
+		t.Fatalf("Expected each size once, clamped, got %v", result.URLs)
+	}
+	expected := "https://minotar.example/texture/" + skin.Hash + "/helm/32 32w"
+	if !strings.HasPrefix(result.Srcset, expected+", ") {
+		t.Fatalf("Expected stable texture URLs, got %s", result.Srcset)
+	}
+
+	config.Auth.Required = true
+	authChain = []Authenticator{&HMACAuthenticator{Secret: []byte("s3cret")}}
+	defer func() {
+		config.Auth.Required = false
+		authChain = nil
+	}()
+	w = serve("/api/srcset/d9135e082f2244c89cb10d21ed3ac8fd?sizes=64&format=html")
+	if !strings.Contains(w.Body.String(), "/avatar/64?expires=") || !strings.Contains(w.Body.String(), "&amp;sig=") {
+		t.Fatalf("Expected a signed URL, escaped for HTML, got %s", w.Body.String())
+	}
+
+	for _, path := range []string{"/api/srcset/clone1018?type=nope", "/api/srcset/clone1018?sizes=big"} {
+		if w := serve(path); w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected %s to be refused, got %d", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Most we put in one packet, to stay under the MTU of most networks.
+const statsdPacketSize = 1432
+
+// Periodically sends our Prometheus metrics to StatsD, for pipelines which
+// are built around it, eg. Datadog's agent. Counters are sent as what
+// they've grown by since the last flush, gauges as they are, and histograms
+// as the counters .count and .sum.
+type StatsD struct {
+	// Prefix for every metric's name, eg. "imgd.".
+	Prefix string
+	// Whether to send labels as DogStatsD tags, rather than in the name.
+	Tags     bool
+	Interval time.Duration
+
+	conn     net.Conn
+	gatherer prometheus.Gatherer
+	// Held while flushing, which shutdown does too.
+	mu sync.Mutex
+	// Counter values as of the last flush, by line.
+	last map[string]float64
+	stop chan struct{}
+}
+
+func MakeStatsD(address string, prefix string, tags bool, interval time.Duration) (*StatsD, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsD{
+		Prefix:   prefix,
+		Tags:     tags,
+		Interval: interval,
+		conn:     conn,
+		gatherer: prometheus.DefaultGatherer,
+		last:     map[string]float64{},
+		stop:     make(chan struct{}),
+	}, nil
+}
+
+func (s *StatsD) run() {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.flush(); err != nil {
+				log.Warningf("Unable to send metrics to StatsD (%v)", err)
+				stats.Errored(ErrStatsD)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Sends the metrics as they are now.
+func (s *StatsD) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	families, err := s.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	packet := []byte{}
+	for _, line := range s.lines(families) {
+		if len(packet) > 0 && len(packet)+len(line)+1 > statsdPacketSize {
+			if _, err := s.conn.Write(packet); err != nil {
+				return err
+			}
+			packet = packet[:0]
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	if len(packet) > 0 {
+		_, err = s.conn.Write(packet)
+	}
+	return err
+}
+
+// Returns a StatsD line for each of our metrics, leaving out the Go runtime's
+// and any counters which haven't moved.
+func (s *StatsD) lines(families []*dto.MetricFamily) []string {
+	lines := []string{}
+	for _, family := range families {
+		if !strings.HasPrefix(family.GetName(), namespace+"_") {
+			continue
+		}
+		name := s.Prefix + strings.TrimPrefix(family.GetName(), namespace+"_")
+		for _, metric := range family.GetMetric() {
+			name, tags := s.labelled(name, metric.GetLabel())
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				lines = s.appendCount(lines, name, tags, metric.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				lines = append(lines, name+":"+formatStat(metric.GetGauge().GetValue())+"|g"+tags)
+			case dto.MetricType_HISTOGRAM:
+				histogram := metric.GetHistogram()
+				lines = s.appendCount(lines, name+".count", tags, float64(histogram.GetSampleCount()))
+				lines = s.appendCount(lines, name+".sum", tags, histogram.GetSampleSum())
+			}
+		}
+	}
+	return lines
+}
+
+// Adds a counter's growth since the last flush, if it's grown.
+func (s *StatsD) appendCount(lines []string, name string, tags string, value float64) []string {
+	key := name + tags
+	delta := value - s.last[key]
+	if delta < 0 {
+		// It's been reset, so all of it is new.
+		delta = value
+	}
+	s.last[key] = value
+	if delta == 0 {
+		return lines
+	}
+	return append(lines, name+":"+formatStat(delta)+"|c"+tags)
+}
+
+// Returns the name and tags for a metric with the given labels. Without
+// tags, plain StatsD has nowhere else to put them but the name.
+func (s *StatsD) labelled(name string, labels []*dto.LabelPair) (string, string) {
+	if len(labels) == 0 {
+		return name, ""
+	}
+	tags := []string{}
+	for _, label := range labels {
+		if s.Tags {
+			tags = append(tags, label.GetName()+":"+statsdSafe(label.GetValue()))
+		} else if label.GetValue() != "" {
+			name += "." + statsdSafe(label.GetValue())
+		}
+	}
+	if len(tags) == 0 {
+		return name, ""
+	}
+	return name, "|#" + strings.Join(tags, ",")
+}
+
+// Replaces what would break up a StatsD line.
+var statsdReplacer = strings.NewReplacer(":", "_", "|", "_", ",", "_", "#", "_", "\n", "_", " ", "_")
+
+func statsdSafe(value string) string {
+	return statsdReplacer.Replace(value)
+}
+
+func formatStat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
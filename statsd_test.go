@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func testStatsD(t *testing.T, tags bool) (*StatsD, net.PacketConn, *prometheus.CounterVec) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := MakeStatsD(listener.LocalAddr().String(), "imgd.", tags, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: namespace, Name: "served_total"}, []string{"resource"})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Namespace: namespace, Name: "in_flight"})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Namespace: namespace, Name: "took_seconds"})
+	other := prometheus.NewGauge(prometheus.GaugeOpts{Name: "go_other"})
+	registry.MustRegister(counter, gauge, histogram, other)
+	counter.WithLabelValues("avatar").Add(3)
+	gauge.Set(2)
+	histogram.Observe(0.5)
+	s.gatherer = registry
+	return s, listener, counter
+}
+
+func readStatsD(t *testing.T, s *StatsD, listener net.PacketConn) []string {
+	if err := s.flush(); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, statsdPacketSize)
+	listener.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := listener.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(buf[:n]), "\n")
+	sort.Strings(lines)
+	return lines
+}
+
+func TestStatsDFlush(t *testing.T) {
+	s, listener, counter := testStatsD(t, false)
+	defer listener.Close()
+
+	expected := "imgd.in_flight:2|g imgd.served_total.avatar:3|c imgd.took_seconds.count:1|c imgd.took_seconds.sum:0.5|c"
+	if lines := strings.Join(readStatsD(t, s, listener), " "); lines != expected {
+		t.Fatalf("Expected %q, got %q", expected, lines)
+	}
+
+	// Counters are sent as what they've grown by, and not at all if they
+	// haven't.
+	counter.WithLabelValues("avatar").Add(2)
+	expected = "imgd.in_flight:2|g imgd.served_total.avatar:2|c"
+	if lines := strings.Join(readStatsD(t, s, listener), " "); lines != expected {
+		t.Fatalf("Expected %q, got %q", expected, lines)
+	}
+}
+
+func TestStatsDTags(t *testing.T) {
+	s, listener, _ := testStatsD(t, true)
+	defer listener.Close()
+
+	lines := readStatsD(t, s, listener)
+	if lines[1] != "imgd.served_total:3|c|#resource:avatar" {
+		t.Fatalf("Expected the label as a tag, got %q", lines[1])
+	}
+}
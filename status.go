@@ -2,11 +2,17 @@ package main
 
 import (
 	"encoding/json"
+	"math"
 	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/minotar/imgd/pkg/mcskin"
 )
 
-// The different MessageTypes for statusCollectorMessage
+// The kinds of event counted, as the time series records them.
 const (
 	StatusTypeCacheHit = iota
 	StatusTypeCacheMiss
@@ -14,109 +20,310 @@ const (
 	StatusTypeRequested
 	StatusTypeAPIRequested
 	StatusTypeErrored
+	StatusTypeTierHit
+	StatusTypeRenderCacheHit
+	StatusTypeRenderCacheMiss
+	StatusTypeCoalesced
+	StatusTypeTimedOut
 )
 
-type statusCollectorMessage struct {
-	// The type of message this is.
-	MessageType uint
+// Bumped whenever the fields of /stats change, other than being added to.
+const statusVersion = 2
 
-	// If MessageType == StatusTypeRequested, StatusTypeAPIRequested or StatusTypeErrored then this is the state we are reporting.
-	StatusType string
+// What /stats reports.
+type statusInfo struct {
+	// Version of these fields, see statusVersion.
+	StatusVersion int
+	// The version of imgd, and the commit, time and Go it was built from.
+	Version   string
+	GitCommit string
+	BuildDate string
+	GoVersion string
+	// Version of the renders, see mcskin.Version.
+	RenderVersion int
+	// Number of goroutines running.
+	Goroutines int
+	// Number of garbage collections, and the seconds they've paused us for.
+	GCRuns  uint32
+	GCPause float64
+	// The cache backend we're configured with.
+	CacheBackend string
+	// Number of bytes allocated to the process.
+	ImgdMem uint64
+	// Time in seconds the process has been running for
+	Uptime int64
+	// Number of times an error has been recorded.
+	Errored map[string]uint
+	// Number of times a request type has been requested.
+	Requested map[string]uint
+	// Number of times an API request type has been made.
+	APIRequested map[string]uint
+	// Number of responses by route, then status class, eg. "2xx".
+	Responses map[string]map[string]uint
+	// Number of API requests saved by waiting on an identical one.
+	Coalesced map[string]uint
+	// Fraction of API requests, by call, saved by waiting on an identical
+	// one.
+	CoalescedRatio map[string]float64
+	// Number of API requests in flight by call, and of requests waiting on
+	// them, including those which made them.
+	InFlight map[string]int
+	Waiting  map[string]int
+	// Number of heavy renders waiting for a worker, and how many may.
+	RenderQueued   int
+	RenderQueueCap int
+	// Number of stale skins being refreshed.
+	RefreshesPending int
+	// Number of upstream requests which timed out, by host.
+	TimedOut map[string]uint
+	// Number of times skins have been served from the cache.
+	CacheHits uint
+	// Number of times skins have failed to be served from the cache.
+	CacheMisses uint
+	// Fraction of lookups which hit the cache over the last five minutes.
+	CacheHitRatio float64
+	// Average seconds the cache has held its entries for.
+	CacheEntryAge float64
+	// Number of entries removed by cache backend, then why: "ttl", "lru"
+	// or "purge".
+	CacheRemovals map[string]map[string]uint
+	// Bytes per second stored in, and freed from, each cache backend since
+	// the last collection.
+	CacheAdmittedRate map[string]float64
+	CacheEvictedRate  map[string]float64
+	// Number of cache hits served by each tier of a tiered cache.
+	TierHits map[string]uint
+	// Fraction of cache lookups served by each tier.
+	TierHitRatio map[string]float64
+	// Number of skins in cache.
+	CacheSize uint
+	// Size of cache memory.
+	CacheMem uint64
+	// Bytes saved by storing shared textures once.
+	CacheDedupSaved uint64
+	// Number of times renders have been served from the render cache.
+	RenderCacheHits uint
+	// Number of times we've had to render afresh.
+	RenderCacheMisses uint
+	// Number of renders in the render cache.
+	RenderCacheSize uint
+	// Size of the render cache memory.
+	RenderCacheMem uint64
+	// Seconds left backing off from Mojang, 0 if we aren't.
+	UpstreamBlocked float64
+	// Number of times Mojang rate limiting us has made us back off.
+	UpstreamBlocks uint
+	// State of the circuit breaker in front of Mojang, and the number of
+	// times it's opened.
+	UpstreamCircuit      string
+	UpstreamCircuitTrips uint
+	// How available Mojang seem from our recent requests to them, see
+	// availabilityNames.
+	UpstreamAvailability string
+	// Requests to Mojang left in our budget, -1 if it isn't capped.
+	UpstreamBudget int
+	// Whether each mirror is healthy enough to ask.
+	MirrorHealthy map[string]bool
+	// The last few errors recorded, newest first.
+	RecentErrors []recentError
 }
 
-type StatusCollector struct {
-	info struct {
-		// Number of bytes allocated to the process.
-		ImgdMem uint64
-		// Time in seconds the process has been running for
-		Uptime int64
-		// Number of times an error has been recorded.
-		Errored map[string]uint
-		// Number of times a request type has been requested.
-		Requested map[string]uint
-		// Number of times an API request type has been made.
-		APIRequested map[string]uint
-		// Number of times skins have been served from the cache.
-		CacheHits uint
-		// Number of times skins have failed to be served from the cache.
-		CacheMisses uint
-		// Number of skins in cache.
-		CacheSize uint
-		// Size of cache memory.
-		CacheMem uint64
+// Most errors kept for RecentErrors.
+const recentErrorCount = 20
+
+// An error as it was recorded.
+type recentError struct {
+	Type string
+	Time time.Time
+}
+
+// Keeps the last recentErrorCount errors.
+type recentErrors struct {
+	mu     sync.Mutex
+	errors [recentErrorCount]recentError
+	next   int
+}
+
+func (r *recentErrors) add(errorType string, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors[r.next%recentErrorCount] = recentError{Type: errorType, Time: now}
+	r.next++
+}
+
+// Returns the errors, newest first.
+func (r *recentErrors) list() []recentError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	errors := []recentError{}
+	for i := r.next - 1; i >= 0 && i >= r.next-recentErrorCount; i-- {
+		errors = append(errors, r.errors[i%recentErrorCount])
+	}
+	return errors
+}
+
+// Counts by name, eg. of each error, safe to increment from any number of
+// requests at once.
+type counterMap struct {
+	counts sync.Map
+}
+
+func (m *counterMap) inc(name string) {
+	m.add(name, 1)
+}
+
+func (m *counterMap) add(name string, n uint64) {
+	count, ok := m.counts.Load(name)
+	if !ok {
+		count, _ = m.counts.LoadOrStore(name, new(atomic.Uint64))
+	}
+	count.(*atomic.Uint64).Add(n)
+}
+
+func (m *counterMap) snapshot() map[string]uint {
+	counts := map[string]uint{}
+	m.counts.Range(func(name, count interface{}) bool {
+		counts[name.(string)] = uint(count.(*atomic.Uint64).Load())
+		return true
+	})
+	return counts
+}
+
+// Like counterMap, but for gauges, which go down as well as up.
+type gaugeMap struct {
+	values sync.Map
+}
+
+func (m *gaugeMap) add(name string, n int64) {
+	value, ok := m.values.Load(name)
+	if !ok {
+		value, _ = m.values.LoadOrStore(name, new(atomic.Int64))
 	}
+	value.(*atomic.Int64).Add(n)
+}
+
+func (m *gaugeMap) snapshot() map[string]int {
+	values := map[string]int{}
+	m.values.Range(func(name, value interface{}) bool {
+		values[name.(string)] = int(value.(*atomic.Int64).Load())
+		return true
+	})
+	return values
+}
+
+// Returns the counts of names made of two words, eg. "avatar 2xx", by the
+// first word and then the second.
+func (m *counterMap) nested() map[string]map[string]uint {
+	nested := map[string]map[string]uint{}
+	for name, count := range m.snapshot() {
+		outer, inner, _ := strings.Cut(name, " ")
+		if nested[outer] == nil {
+			nested[outer] = map[string]uint{}
+		}
+		nested[outer][inner] = count
+	}
+	return nested
+}
+
+// Counts what we've done for /stats. Counting is lock-free, so requests
+// never wait on it; the gauges are gathered every five seconds by Collect.
+type StatusCollector struct {
+	errored      counterMap
+	requested    counterMap
+	apiRequested counterMap
+	responses    counterMap
+	coalesced    counterMap
+	uncoalesced  counterMap
+	timedOut     counterMap
+	tierHits     counterMap
+	recentErrors recentErrors
+
+	cacheHits         atomic.Uint64
+	cacheMisses       atomic.Uint64
+	renderCacheHits   atomic.Uint64
+	renderCacheMisses atomic.Uint64
+
+	// The gauges, as last collected.
+	mu     sync.Mutex
+	gauges statusInfo
+	// Cache bytes by backend as of the last collection, to work out rates.
+	lastCollect  time.Time
+	lastAdmitted map[string]uint
+	lastEvicted  map[string]uint
 
 	// Unix timestamp the process was booted at.
 	StartedAt int64
 
-	// Channel for feeding in input data.
-	inputData chan statusCollectorMessage
+	// Per-minute counts for the last 24 hours.
+	TimeSeries *TimeSeries
 }
 
 func MakeStatsCollector() *StatusCollector {
 	collector := &StatusCollector{}
 	collector.StartedAt = time.Now().Unix()
-	collector.info.Errored = map[string]uint{}
-	collector.info.Requested = map[string]uint{}
-	collector.info.APIRequested = map[string]uint{}
-	collector.inputData = make(chan statusCollectorMessage, 5)
+	collector.gauges.TierHitRatio = map[string]float64{}
+	collector.gauges.StatusVersion = statusVersion
+	collector.gauges.Version = ImgdVersion
+	collector.gauges.GitCommit, collector.gauges.BuildDate = buildInfo()
+	collector.gauges.GoVersion = runtime.Version()
+	collector.gauges.RenderVersion = mcskin.Version
+	collector.TimeSeries = &TimeSeries{}
 
 	// Run a function every five seconds to collect time-based info.
 	go func() {
 		ticker := time.NewTicker(time.Second * 5)
-
-		for {
-			select {
-			case <-ticker.C:
-				collector.Collect()
-			case msg := <-collector.inputData:
-				collector.handleMessage(msg)
-			}
+		for range ticker.C {
+			collector.Collect()
 		}
 	}()
 
 	return collector
 }
 
-// Message handler function, called inside goroutine.
-func (s *StatusCollector) handleMessage(msg statusCollectorMessage) {
-	switch msg.MessageType {
-	case StatusTypeCacheHit:
-		cacheCounter.WithLabelValues("hit").Inc()
-		s.info.CacheHits++
-	case StatusTypeCacheMiss:
-		cacheCounter.WithLabelValues("miss").Inc()
-		s.info.CacheMisses++
-	case StatusTypeErrored:
-		err := msg.StatusType
-		errorCounter.WithLabelValues(err).Inc()
-		if _, exists := s.info.Errored[err]; exists {
-			s.info.Errored[err]++
-		} else {
-			s.info.Errored[err] = 1
-		}
-	case StatusTypeRequested:
-		req := msg.StatusType
-		requestCounter.WithLabelValues(req).Inc()
-		if _, exists := s.info.Requested[req]; exists {
-			s.info.Requested[req]++
-		} else {
-			s.info.Requested[req] = 1
-		}
-	case StatusTypeAPIRequested:
-		req := msg.StatusType
-		apiCounter.WithLabelValues(req).Inc()
-		if _, exists := s.info.APIRequested[req]; exists {
-			s.info.APIRequested[req]++
-		} else {
-			s.info.APIRequested[req] = 1
-		}
+// Returns the counts so far along with the gauges as last collected.
+func (s *StatusCollector) snapshot() statusInfo {
+	s.mu.Lock()
+	info := s.gauges
+	// Collect fills the ratios in place, so they're copied while it can't.
+	info.TierHitRatio = make(map[string]float64, len(s.gauges.TierHitRatio))
+	for tier, ratio := range s.gauges.TierHitRatio {
+		info.TierHitRatio[tier] = ratio
 	}
+	s.mu.Unlock()
+
+	info.Errored = s.errored.snapshot()
+	info.Requested = s.requested.snapshot()
+	info.APIRequested = s.apiRequested.snapshot()
+	info.Responses = s.responses.nested()
+	info.CacheRemovals = cacheRemovals.nested()
+	info.Coalesced = s.coalesced.snapshot()
+	info.CoalescedRatio = map[string]float64{}
+	uncoalesced := s.uncoalesced.snapshot()
+	for call, coalesced := range info.Coalesced {
+		info.CoalescedRatio[call] = float64(coalesced) / float64(coalesced+uncoalesced[call])
+	}
+	info.InFlight = coalesceInFlight.snapshot()
+	info.Waiting = coalesceWaiting.snapshot()
+	if renderPool != nil {
+		info.RenderQueued = len(renderPool.jobs)
+		info.RenderQueueCap = renderPool.Queue
+	}
+	if refresher != nil {
+		info.RefreshesPending = refresher.pendingCount()
+	}
+	info.TimedOut = s.timedOut.snapshot()
+	info.TierHits = s.tierHits.snapshot()
+	info.RecentErrors = s.recentErrors.list()
+	info.CacheHits = uint(s.cacheHits.Load())
+	info.CacheMisses = uint(s.cacheMisses.Load())
+	info.RenderCacheHits = uint(s.renderCacheHits.Load())
+	info.RenderCacheMisses = uint(s.renderCacheMisses.Load())
+	return info
 }
 
 // Encodes the info struct to a JSON string byte slice
 func (s *StatusCollector) ToJSON() []byte {
-	results, _ := json.Marshal(s.info)
+	results, _ := json.Marshal(s.snapshot())
 	return results
 }
 
@@ -125,46 +332,182 @@ func (s *StatusCollector) Collect() {
 	memstats := &runtime.MemStats{}
 	runtime.ReadMemStats(memstats)
 
-	s.info.ImgdMem = memstats.Alloc
-	s.info.Uptime = time.Now().Unix() - s.StartedAt
-	s.info.CacheSize = cache.size()
-	s.info.CacheMem = cache.memory()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info := &s.gauges
+	info.ImgdMem = memstats.Alloc
+	info.Uptime = time.Now().Unix() - s.StartedAt
+	info.Goroutines = runtime.NumGoroutine()
+	info.GCRuns = memstats.NumGC
+	info.GCPause = time.Duration(memstats.PauseTotalNs).Seconds()
+	info.CacheBackend = cacheBackend(cache)
+	info.CacheSize = cache.size()
+	info.CacheMem = cache.memory()
+	recordCacheMetrics(cache)
+	info.CacheHitRatio = s.TimeSeries.hitRatio(time.Now(), cacheRatioWindow)
+	hitRatioGauge.Set(info.CacheHitRatio)
+	if aged, ok := cache.(agedCache); ok {
+		info.CacheEntryAge = aged.averageAge().Seconds()
+	}
+	s.collectRates(time.Now())
+	if lookups := s.cacheHits.Load() + s.cacheMisses.Load(); lookups > 0 {
+		for tier, hits := range s.tierHits.snapshot() {
+			info.TierHitRatio[tier] = float64(hits) / float64(lookups)
+			tierHitRatioGauge.WithLabelValues(tier).Set(info.TierHitRatio[tier])
+		}
+	}
+	if dedup, ok := cache.(dedupCache); ok {
+		info.CacheDedupSaved = dedup.dedupSaved()
+	}
+	if upstream != nil {
+		remaining, _ := upstream.blocked()
+		info.UpstreamBlocked = math.Max(remaining.Seconds(), 0)
+		info.UpstreamBlocks = upstream.blockCount()
+		state := upstream.Breaker.currentState()
+		info.UpstreamCircuit = circuitStateNames[state]
+		info.UpstreamCircuitTrips = upstream.Breaker.tripCount()
+		info.UpstreamBudget = -1
+		if upstream.Budget != nil {
+			info.UpstreamBudget = upstream.Budget.available()
+		}
+		circuitGauge.WithLabelValues("mojang").Set(float64(state))
+		if remaining > 0 || state == CircuitOpen {
+			upstreamHealthyGauge.WithLabelValues("mojang").Set(0)
+		} else {
+			upstreamHealthyGauge.WithLabelValues("mojang").Set(1)
+		}
+	}
+	for _, report := range upstreamReports() {
+		availability := report.availability()
+		upstreamAvailabilityGauge.WithLabelValues(report.Name).Set(float64(availability))
+		if report.Kind == "mojang" {
+			info.UpstreamAvailability = availabilityNames[availability]
+		}
+	}
+	info.MirrorHealthy = mirrorHealth()
+	for name, healthy := range info.MirrorHealthy {
+		if healthy {
+			upstreamHealthyGauge.WithLabelValues(name).Set(1)
+		} else {
+			upstreamHealthyGauge.WithLabelValues(name).Set(0)
+		}
+	}
+	if renderCache != nil {
+		info.RenderCacheSize = renderCache.size()
+		info.RenderCacheMem = renderCache.memory()
+		recordRenderCacheMetrics(renderCache)
+	}
 }
 
-// Increments the error counter for the specific type.
-func (s *StatusCollector) Errored(errorType string) {
-	s.inputData <- statusCollectorMessage{
-		MessageType: StatusTypeErrored,
-		StatusType:  errorType,
+// Minutes the rolling cache hit ratio covers.
+const cacheRatioWindow = 5
+
+// Works out the cache byte rates since the last collection. Must be called
+// with the lock held.
+func (s *StatusCollector) collectRates(now time.Time) {
+	admitted, evicted := cacheAdmitted.snapshot(), cacheEvicted.snapshot()
+	if !s.lastCollect.IsZero() {
+		rate := func(current map[string]uint, last map[string]uint) map[string]float64 {
+			rates := map[string]float64{}
+			for backend, bytes := range current {
+				rates[backend] = float64(bytes-last[backend]) / now.Sub(s.lastCollect).Seconds()
+			}
+			return rates
+		}
+		s.gauges.CacheAdmittedRate = rate(admitted, s.lastAdmitted)
+		s.gauges.CacheEvictedRate = rate(evicted, s.lastEvicted)
 	}
+	s.lastCollect, s.lastAdmitted, s.lastEvicted = now, admitted, evicted
+}
+
+// Increments the error counter for the code.
+func (s *StatusCollector) Errored(code ErrorCode) {
+	errorType := errorLabels.value(string(code))
+	now := time.Now()
+	s.TimeSeries.record(now, StatusTypeErrored)
+	s.recentErrors.add(errorType, now)
+	errorCounter.WithLabelValues(errorType).Inc()
+	s.errored.inc(errorType)
 }
 
 // Increments the request counter for the specific type.
 func (s *StatusCollector) Requested(reqType string) {
-	s.inputData <- statusCollectorMessage{
-		MessageType: StatusTypeRequested,
-		StatusType:  reqType,
-	}
+	reqType = requestLabels.value(reqType)
+	s.TimeSeries.record(time.Now(), StatusTypeRequested)
+	requestCounter.WithLabelValues(reqType).Inc()
+	s.requested.inc(reqType)
 }
 
 // Increments the request counter for the specific type.
 func (s *StatusCollector) APIRequested(reqType string) {
-	s.inputData <- statusCollectorMessage{
-		MessageType: StatusTypeAPIRequested,
-		StatusType:  reqType,
-	}
+	reqType = apiLabels.value(reqType)
+	s.TimeSeries.record(time.Now(), StatusTypeAPIRequested)
+	apiCounter.WithLabelValues(reqType).Inc()
+	s.apiRequested.inc(reqType)
+}
+
+// Should be called for every response, with its route and status class.
+func (s *StatusCollector) Responded(route string, class string) {
+	responseCounter.WithLabelValues(route, class).Inc()
+	s.responses.inc(route + " " + class)
+}
+
+// Should be called every time an API request is saved by waiting on an
+// identical one already in flight.
+func (s *StatusCollector) Coalesced(call string) {
+	call = coalescedLabels.value(call)
+	s.TimeSeries.record(time.Now(), StatusTypeCoalesced)
+	coalescedCounter.WithLabelValues(call).Inc()
+	s.coalesced.inc(call)
+}
+
+// Should be called every time an API request is made for want of an
+// identical one in flight to wait on.
+func (s *StatusCollector) Uncoalesced(call string) {
+	s.uncoalesced.inc(coalescedLabels.value(call))
+}
+
+// Should be called every time a request upstream times out, with the host
+// it was to.
+func (s *StatusCollector) TimedOut(host string) {
+	host = hostLabels.value(host)
+	s.TimeSeries.record(time.Now(), StatusTypeTimedOut)
+	timeoutCounter.WithLabelValues(host).Inc()
+	s.timedOut.inc(host)
 }
 
 // Should be called every time we serve a cached skin.
 func (s *StatusCollector) HitCache() {
-	s.inputData <- statusCollectorMessage{
-		MessageType: StatusTypeCacheHit,
-	}
+	s.TimeSeries.record(time.Now(), StatusTypeCacheHit)
+	cacheCounter.WithLabelValues("hit").Inc()
+	s.cacheHits.Add(1)
+}
+
+// Should be called every time a tiered cache serves a skin, with the tier
+// that served it.
+func (s *StatusCollector) HitCacheTier(tier string) {
+	s.TimeSeries.record(time.Now(), StatusTypeTierHit)
+	cacheCounter.WithLabelValues("hit_" + strings.ToLower(tier)).Inc()
+	s.tierHits.inc(tier)
 }
 
 // Should be called every time we try and fail to serve a cached skin.
 func (s *StatusCollector) MissCache() {
-	s.inputData <- statusCollectorMessage{
-		MessageType: StatusTypeCacheMiss,
-	}
+	s.TimeSeries.record(time.Now(), StatusTypeCacheMiss)
+	cacheCounter.WithLabelValues("miss").Inc()
+	s.cacheMisses.Add(1)
+}
+
+// Should be called every time we serve a cached render.
+func (s *StatusCollector) HitRenderCache() {
+	s.TimeSeries.record(time.Now(), StatusTypeRenderCacheHit)
+	cacheCounter.WithLabelValues("render_hit").Inc()
+	s.renderCacheHits.Add(1)
+}
+
+// Should be called every time we have to render afresh.
+func (s *StatusCollector) MissRenderCache() {
+	s.TimeSeries.record(time.Now(), StatusTypeRenderCacheMiss)
+	cacheCounter.WithLabelValues("render_miss").Inc()
+	s.renderCacheMisses.Add(1)
 }
@@ -1,80 +1,191 @@
 package main
 
 import (
+	"encoding/json"
+	"runtime"
+	"sync"
 	"testing"
 	"time"
-
-	"github.com/op/go-logging"
 )
 
 func testSetupStatus() *StashingWriter {
 	sw := new(StashingWriter)
-	logBackend := logging.NewLogBackend(sw, "", 0)
 	stats = MakeStatsCollector()
 	setupConfig()
-	setupLog(logBackend)
+	setupLog(sw)
 	setupCache()
 	return sw
 }
 
 func TestStatusHandleMessageCacheHit(t *testing.T) {
+	stats = MakeStatsCollector()
 	stats.HitCache()
-	time.Sleep(time.Duration(1) * time.Millisecond)
-	if stats.info.CacheHits != 1 {
-		t.Fatalf("CacheHits not 1, was %d", stats.info.CacheHits)
+	if stats.snapshot().CacheHits != 1 {
+		t.Fatalf("CacheHits not 1, was %d", stats.snapshot().CacheHits)
 	}
 }
 
 func TestStatusHandleMessageCacheMiss(t *testing.T) {
 	stats.MissCache()
-	time.Sleep(time.Duration(1) * time.Millisecond)
-	if stats.info.CacheMisses != 1 {
-		t.Fatalf("CacheMisses not 1, was %d", stats.info.CacheMisses)
+	if stats.snapshot().CacheMisses != 1 {
+		t.Fatalf("CacheMisses not 1, was %d", stats.snapshot().CacheMisses)
 	}
 }
 
 func TestStatusHandleMessageRequested(t *testing.T) {
 	stats.Requested("test")
-	time.Sleep(time.Duration(1) * time.Millisecond)
-	if stats.info.Requested["test"] != 1 {
-		t.Fatalf("Requested[\"test\"] not 1, was %d", stats.info.Requested["test"])
+	if stats.snapshot().Requested["test"] != 1 {
+		t.Fatalf("Requested[\"test\"] not 1, was %d", stats.snapshot().Requested["test"])
 	}
 
 	stats.Requested("test")
 	stats.Requested("test")
 	stats.Requested("bacon")
 	stats.Requested("fromage")
-	time.Sleep(time.Duration(1) * time.Millisecond)
-	if stats.info.Requested["test"] != 3 {
-		t.Fatalf("Requested[\"test\"] not 3, was %d", stats.info.Requested["test"])
+	if stats.snapshot().Requested["test"] != 3 {
+		t.Fatalf("Requested[\"test\"] not 3, was %d", stats.snapshot().Requested["test"])
 	}
-	if stats.info.Requested["bacon"] != 1 {
-		t.Fatalf("Requested[\"bacon\"] not 1, was %d", stats.info.Requested["bacon"])
+	if stats.snapshot().Requested["bacon"] != 1 {
+		t.Fatalf("Requested[\"bacon\"] not 1, was %d", stats.snapshot().Requested["bacon"])
 	}
-	if stats.info.Requested["fromage"] != 1 {
-		t.Fatalf("Requested[\"fromage\"] not 1, was %d", stats.info.Requested["fromage"])
+	if stats.snapshot().Requested["fromage"] != 1 {
+		t.Fatalf("Requested[\"fromage\"] not 1, was %d", stats.snapshot().Requested["fromage"])
 	}
 }
 
 func TestStatusHandleMessageErrored(t *testing.T) {
 	stats.Errored("test")
-	time.Sleep(time.Duration(1) * time.Millisecond)
-	if stats.info.Errored["test"] != 1 {
-		t.Fatalf("Errored[\"test\"] not 1, was %d", stats.info.Errored["test"])
+	if stats.snapshot().Errored["test"] != 1 {
+		t.Fatalf("Errored[\"test\"] not 1, was %d", stats.snapshot().Errored["test"])
 	}
 
 	stats.Errored("test")
 	stats.Errored("test")
 	stats.Errored("bacon")
 	stats.Errored("fromage")
-	time.Sleep(time.Duration(1) * time.Millisecond)
-	if stats.info.Errored["test"] != 3 {
-		t.Fatalf("Errored[\"test\"] not 3, was %d", stats.info.Errored["test"])
+	if stats.snapshot().Errored["test"] != 3 {
+		t.Fatalf("Errored[\"test\"] not 3, was %d", stats.snapshot().Errored["test"])
+	}
+	if stats.snapshot().Errored["bacon"] != 1 {
+		t.Fatalf("Errored[\"bacon\"] not 1, was %d", stats.snapshot().Errored["bacon"])
+	}
+	if stats.snapshot().Errored["fromage"] != 1 {
+		t.Fatalf("Errored[\"fromage\"] not 1, was %d", stats.snapshot().Errored["fromage"])
+	}
+}
+
+func TestStatusConcurrentCounts(t *testing.T) {
+	collector := MakeStatsCollector()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				collector.Requested("Avatar")
+				collector.HitCache()
+			}
+		}()
+	}
+	wg.Wait()
+
+	info := map[string]interface{}{}
+	if err := json.Unmarshal(collector.ToJSON(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info["CacheHits"] != float64(5000) {
+		t.Fatalf("CacheHits not 5000, was %v", info["CacheHits"])
+	}
+	if requested := info["Requested"].(map[string]interface{}); requested["Avatar"] != float64(5000) {
+		t.Fatalf("Requested[\"Avatar\"] not 5000, was %v", requested["Avatar"])
+	}
+	if _, exists := info["Errored"]; !exists {
+		t.Fatalf("Errored missing from %v", info)
+	}
+}
+
+func TestStatusSnapshotIsACopy(t *testing.T) {
+	cache = &CacheMemory{}
+	cache.setup()
+	collector := MakeStatsCollector()
+	collector.HitCache()
+	collector.HitCacheTier("memory")
+	collector.Collect()
+
+	info := collector.snapshot()
+	collector.HitCacheTier("redis")
+	collector.Collect()
+	if _, changed := info.TierHitRatio["redis"]; changed || info.TierHitRatio["memory"] != 1 {
+		t.Fatalf("Expected the snapshot not to change after it was taken, got %v", info.TierHitRatio)
+	}
+}
+
+func TestStatusBuildInfo(t *testing.T) {
+	collector := MakeStatsCollector()
+	collector.Collect()
+
+	info := map[string]interface{}{}
+	if err := json.Unmarshal(collector.ToJSON(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info["StatusVersion"] != float64(statusVersion) || info["Version"] != ImgdVersion {
+		t.Fatalf("Expected the status and imgd versions, got %v and %v", info["StatusVersion"], info["Version"])
+	}
+	if info["GoVersion"] != runtime.Version() || info["Goroutines"].(float64) < 1 {
+		t.Fatalf("Expected the Go runtime's details, got %v and %v", info["GoVersion"], info["Goroutines"])
+	}
+	if info["CacheBackend"] != cacheBackend(cache) {
+		t.Fatalf("Expected the cache backend, got %v", info["CacheBackend"])
+	}
+	// Those from before are still there.
+	if _, exists := info["CacheHits"]; !exists {
+		t.Fatalf("CacheHits missing from %v", info)
+	}
+}
+
+func TestTimeSeriesHitRatio(t *testing.T) {
+	ts := &TimeSeries{}
+	now := time.Now()
+	if ratio := ts.hitRatio(now, 5); ratio != 0 {
+		t.Fatalf("Expected 0 without lookups, got %v", ratio)
 	}
-	if stats.info.Errored["bacon"] != 1 {
-		t.Fatalf("Errored[\"bacon\"] not 1, was %d", stats.info.Errored["bacon"])
+
+	ts.record(now, StatusTypeCacheHit)
+	ts.record(now.Add(-2*time.Minute), StatusTypeCacheHit)
+	ts.record(now.Add(-2*time.Minute), StatusTypeCacheHit)
+	ts.record(now.Add(-3*time.Minute), StatusTypeCacheMiss)
+	// Too long ago to count.
+	ts.record(now.Add(-10*time.Minute), StatusTypeCacheMiss)
+	if ratio := ts.hitRatio(now, 5); ratio != 0.75 {
+		t.Fatalf("Expected 0.75, got %v", ratio)
 	}
-	if stats.info.Errored["fromage"] != 1 {
-		t.Fatalf("Errored[\"fromage\"] not 1, was %d", stats.info.Errored["fromage"])
+}
+
+func TestTimeSeriesRecord(t *testing.T) {
+	ts := &TimeSeries{}
+	now := time.Unix(1500000000, 0)
+
+	ts.record(now, StatusTypeRequested)
+	ts.record(now, StatusTypeRequested)
+	ts.record(now, StatusTypeCacheHit)
+	ts.record(now.Add(-time.Minute), StatusTypeErrored)
+
+	slots := ts.Slots(now)
+	if len(slots) != timeSeriesSlots {
+		t.Fatalf("Expected %d slots, got %d", timeSeriesSlots, len(slots))
+	}
+	last := slots[len(slots)-1]
+	if last.Requests != 2 || last.CacheHits != 1 {
+		t.Fatalf("Current minute was %+v", last)
+	}
+	if slots[len(slots)-2].Errors != 1 {
+		t.Fatalf("Previous minute was %+v", slots[len(slots)-2])
+	}
+
+	// A day later the old counts should have been dropped.
+	ts.record(now.Add(24*time.Hour), StatusTypeRequested)
+	last = ts.Slots(now.Add(24 * time.Hour))[timeSeriesSlots-1]
+	if last.Requests != 1 || last.CacheHits != 0 {
+		t.Fatalf("Wrapped minute was %+v", last)
 	}
 }
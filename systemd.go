@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// The first file descriptor systemd passes sockets from.
+const listenFdsStart = 3
+
+// Returns the socket systemd passed us with socket activation, or nil if
+// it didn't. systemd holds the socket open across restarts, queueing
+// connections while we're down, so we can be redeployed without dropping
+// any.
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+	// They're meant for us alone, not anything we might start.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if fds > 1 {
+		log.Warningf("systemd passed %d sockets, only listening on the first", fds)
+	}
+
+	syscall.CloseOnExec(listenFdsStart)
+	file := os.NewFile(uintptr(listenFdsStart), "LISTEN_FD_3")
+	defer file.Close()
+	return net.FileListener(file)
+}
+
+// Returns the socket systemd passed us, or else listens on the address.
+func listen(address string) (net.Listener, error) {
+	listener, err := systemdListener()
+	if err != nil || listener != nil {
+		return listener, err
+	}
+	return net.Listen(listenNetwork(address), address)
+}
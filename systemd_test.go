@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestListenWithoutSystemd(t *testing.T) {
+	// Sockets passed to another process aren't ours.
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	os.Setenv("LISTEN_FDS", "1")
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+
+	if listener, err := systemdListener(); listener != nil || err != nil {
+		t.Fatalf("Expected no systemd socket, got %v (%v)", listener, err)
+	}
+
+	listener, err := listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// One of the operator's customers, eg. a Minecraft network, as a
+// [tenant "<name>"] section, recognised by its API keys or the hosts it
+// serves avatars on, and held to its own limits.
+type Tenant struct {
+	// API keys the tenant's requests carry, as for [auth] apikey.
+	APIKey []string
+	// Hosts the tenant serves avatars from, eg. avatars.example.net.
+	Host []string
+	// Requests a second the tenant may make on average, across all its
+	// clients, and at once. 0 for no limit.
+	Rate  float64
+	Burst int
+	// Route groups the tenant may use, blank for any we serve.
+	Route []string
+	// The [preset] for requests which don't ask for one.
+	Preset string
+}
+
+// A tenant as we're serving it, with what it's used since we started.
+type tenantState struct {
+	Name   string
+	Tenant *Tenant
+	// Nil if it has no rate limit.
+	bucket *TokenBucket
+	// Nil if it may use any route.
+	routes map[string]bool
+
+	requests    atomic.Int64
+	rateLimited atomic.Int64
+	forbidden   atomic.Int64
+}
+
+// The configured tenants, and how to tell which a request is from.
+type Tenants struct {
+	byName map[string]*tenantState
+	byHost map[string]*tenantState
+	// Each key's name is its tenant's.
+	keys *APIKeyAuthenticator
+}
+
+// Builds the tenants from their sections. Returns nil if there are none.
+func MakeTenants(sections map[string]*Tenant, presets map[string]*Preset) (*Tenants, error) {
+	tenants := &Tenants{byName: map[string]*tenantState{}, byHost: map[string]*tenantState{}}
+	for name, tenant := range sections {
+		if tenant == nil {
+			continue
+		}
+		if err := checkTenant(tenant, presets); err != nil {
+			return nil, fmt.Errorf("[tenant \"%s\"] %v", name, err)
+		}
+		state := &tenantState{Name: name, Tenant: tenant}
+		if tenant.Rate > 0 {
+			state.bucket = MakeTokenBucket(tenant.Rate, tenant.Burst)
+		}
+		if len(tenant.Route) > 0 {
+			state.routes = map[string]bool{}
+			for _, group := range tenant.Route {
+				state.routes[strings.ToLower(strings.TrimSpace(group))] = true
+			}
+		}
+		for _, host := range tenant.Host {
+			host = strings.ToLower(host)
+			if other, exists := tenants.byHost[host]; exists {
+				return nil, fmt.Errorf("host %s is both %s's and %s's", host, other.Name, name)
+			}
+			tenants.byHost[host] = state
+		}
+		tenants.byName[name] = state
+	}
+	if len(tenants.byName) == 0 {
+		return nil, nil
+	}
+	tenants.keys = MakeAPIKeyAuthenticator(tenantAPIKeys(sections))
+	return tenants, nil
+}
+
+// Lists the tenants' keys as "tenant:key", as [auth] apikey has them, so
+// the apikey authenticator identifies tenants by name.
+func tenantAPIKeys(sections map[string]*Tenant) []string {
+	keys := []string{}
+	for name, tenant := range sections {
+		if tenant == nil {
+			continue
+		}
+		for _, key := range tenant.APIKey {
+			if key != "" {
+				keys = append(keys, name+":"+key)
+			}
+		}
+	}
+	return keys
+}
+
+func checkTenant(t *Tenant, presets map[string]*Preset) error {
+	if len(t.APIKey) == 0 && len(t.Host) == 0 {
+		return fmt.Errorf("needs an apikey or host to be recognised by")
+	}
+	for _, group := range t.Route {
+		if _, exists := routeGroups[strings.ToLower(strings.TrimSpace(group))]; !exists {
+			return fmt.Errorf("unknown route group %q", group)
+		}
+	}
+	if _, exists := presets[t.Preset]; t.Preset != "" && !exists {
+		return fmt.Errorf("unknown preset %q", t.Preset)
+	}
+	return nil
+}
+
+// Returns the tenant the request is from, by its API key, else the host it
+// was made to, or nil if it's from none.
+func (t *Tenants) identify(r *http.Request) *tenantState {
+	if t == nil {
+		return nil
+	}
+	if tenant := t.byKey(r); tenant != nil {
+		return tenant
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return t.byHost[strings.ToLower(host)]
+}
+
+// Returns the tenant whose API key the request carries, or nil if it
+// carries none of theirs.
+func (t *Tenants) byKey(r *http.Request) *tenantState {
+	if t == nil {
+		return nil
+	}
+	given := r.Header.Get("X-API-Key")
+	if given == "" {
+		given = r.URL.Query().Get("apikey")
+	}
+	if name := t.keys.identify(given); name != "" {
+		return t.byName[name]
+	}
+	return nil
+}
+
+// Holds requests from tenants to their rate limits and routes, and gives
+// them the tenant's preset if they don't ask for one. Requests from no
+// tenant are left alone. Run it after any aliases are resolved, so the
+// routes are checked as they'll be served.
+func tenantHandler(tenants *Tenants, router http.Handler) http.Handler {
+	if tenants == nil {
+		return router
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := tenants.identify(r)
+		if tenant == nil {
+			router.ServeHTTP(w, r)
+			return
+		}
+		tenant.requests.Add(1)
+
+		if tenant.bucket != nil && !tenant.bucket.take() {
+			tenant.rateLimited.Add(1)
+			tenantRequestsCounter.WithLabelValues(tenant.Name, "rate_limited").Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(tenant.bucket.wait().Seconds())+1))
+			writeError(w, r, http.StatusTooManyRequests, ErrRateLimited, "too many requests")
+			stats.Errored(ErrRateLimited)
+			return
+		}
+		if tenant.routes != nil {
+			for _, group := range pathRouteGroups(r.URL.Path) {
+				if !tenant.routes[group] {
+					tenant.forbidden.Add(1)
+					tenantRequestsCounter.WithLabelValues(tenant.Name, "forbidden").Inc()
+					writeError(w, r, http.StatusForbidden, ErrForbidden, "the %s routes aren't enabled for you", group)
+					stats.Errored(ErrTenantRoute)
+					return
+				}
+			}
+		}
+		tenantRequestsCounter.WithLabelValues(tenant.Name, "served").Inc()
+
+		if tenant.Tenant.Preset != "" && r.URL.Query().Get("preset") == "" {
+			query := r.URL.Query()
+			query.Set("preset", tenant.Tenant.Preset)
+			r = r.Clone(r.Context())
+			r.URL.RawQuery = query.Encode()
+		}
+		router.ServeHTTP(w, r)
+	})
+}
+
+// A tenant's limits and usage, for the admin API.
+type tenantReport struct {
+	Name   string
+	Hosts  []string
+	Rate   float64
+	Burst  int
+	Routes []string
+	Preset string
+	// Since we started.
+	Requests    int64
+	RateLimited int64
+	Forbidden   int64
+}
+
+// TenantsPage lists each tenant's limits, and how many requests it's made
+// of this instance since it started, and how many were refused. Their API
+// keys are left out.
+func (router *Router) TenantsPage(w http.ResponseWriter, r *http.Request) {
+	if tenants == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprintf(w, "501 no tenants are configured")
+		return
+	}
+
+	reports := []tenantReport{}
+	for _, tenant := range tenants.byName {
+		reports = append(reports, tenantReport{
+			Name:        tenant.Name,
+			Hosts:       tenant.Tenant.Host,
+			Rate:        tenant.Tenant.Rate,
+			Burst:       tenant.Tenant.Burst,
+			Routes:      tenant.Tenant.Route,
+			Preset:      tenant.Tenant.Preset,
+			Requests:    tenant.requests.Load(),
+			RateLimited: tenant.rateLimited.Load(),
+			Forbidden:   tenant.forbidden.Load(),
+		})
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Name < reports[j].Name })
+
+	page, _ := json.Marshal(reports)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(page)
+}
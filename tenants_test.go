@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTenants(t *testing.T) {
+	stats = MakeStatsCollector()
+	if none, err := MakeTenants(map[string]*Tenant{}, nil); none != nil || err != nil {
+		t.Fatalf("Expected no tenants, got %v", err)
+	}
+	if _, err := MakeTenants(map[string]*Tenant{"a": {Host: []string{"x"}}, "b": {Host: []string{"X"}}}, nil); err == nil {
+		t.Fatal("Expected a host shared by two tenants to be refused")
+	}
+	if _, err := MakeTenants(map[string]*Tenant{"a": {Host: []string{"x"}, Preset: "forum"}}, nil); err == nil {
+		t.Fatal("Expected an unknown preset to be refused")
+	}
+
+	var err error
+	tenants, err = MakeTenants(map[string]*Tenant{
+		"mineplex": {APIKey: []string{"secret"}, Rate: 1, Burst: 1},
+		"hypixel":  {Host: []string{"avatars.hypixel.net"}, Route: []string{"bodies"}, Preset: "forum"},
+	}, map[string]*Preset{"forum": {Size: 64}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { tenants = nil }()
+
+	preset := ""
+	handler := tenantHandler(tenants, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		preset = r.URL.Query().Get("preset")
+	}))
+	serve := func(host string, path string, key string) int {
+		r := httptest.NewRequest("GET", "http://"+host+path, nil)
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := serve("avatars.mineplex.com", "/body/clone1018", "secret"); code != http.StatusOK {
+		t.Fatalf("Expected the tenant's first request through, got %d", code)
+	}
+	if code := serve("avatars.mineplex.com", "/body/clone1018", "secret"); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected the tenant's rate limit, got %d", code)
+	}
+	if code := serve("avatars.mineplex.com", "/body/clone1018", ""); code != http.StatusOK {
+		t.Fatalf("Expected requests from no tenant through, got %d", code)
+	}
+
+	for path, expected := range map[string]int{
+		"/avatar/clone1018":      http.StatusOK,
+		"/body/clone1018":        http.StatusOK,
+		"/cube/clone1018":        http.StatusForbidden,
+		"/body/clone1018.svg":    http.StatusForbidden,
+		"/api/history/clone1018": http.StatusForbidden,
+	} {
+		if code := serve("avatars.hypixel.net:8000", path, ""); code != expected {
+			t.Errorf("Expected %s to be %d for the tenant, got %d", path, expected, code)
+		}
+	}
+	serve("avatars.hypixel.net", "/avatar/clone1018", "")
+	if preset != "forum" {
+		t.Fatalf("Expected the tenant's preset, got %q", preset)
+	}
+	serve("avatars.hypixel.net", "/avatar/clone1018?preset=profile", "")
+	if preset != "profile" {
+		t.Fatalf("Expected the request's own preset to win, got %q", preset)
+	}
+
+	w := httptest.NewRecorder()
+	(&Router{}).TenantsPage(w, httptest.NewRequest("GET", "/admin/tenants", nil))
+	reports := []tenantReport{}
+	if err := json.Unmarshal(w.Body.Bytes(), &reports); err != nil || len(reports) != 2 {
+		t.Fatalf("Expected both tenants, got %q", w.Body.String())
+	}
+	if hypixel := reports[0]; hypixel.Name != "hypixel" || hypixel.Requests != 7 || hypixel.Forbidden != 3 {
+		t.Fatalf("Expected hypixel's usage, got %+v", hypixel)
+	}
+	if mineplex := reports[1]; mineplex.Requests != 2 || mineplex.RateLimited != 1 {
+		t.Fatalf("Expected mineplex's usage, got %+v", mineplex)
+	}
+}
+
+func TestTenantKeysArentAdmin(t *testing.T) {
+	stats = MakeStatsCollector()
+	var err error
+	tenants, err = MakeTenants(map[string]*Tenant{"mineplex": {APIKey: []string{"secret"}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { tenants = nil }()
+
+	chain := []Authenticator{MakeAPIKeyAuthenticator(append([]string{"ops:s3cret"}, tenantAPIKeys(map[string]*Tenant{"mineplex": {APIKey: []string{"secret"}}})...))}
+	handler := authHandler(chain, false, requireIdentity(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(key string) int {
+		r := httptest.NewRequest("POST", "/admin/cache/flush", nil)
+		r.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := serve("secret"); code != http.StatusForbidden {
+		t.Fatalf("Expected a tenant's key to be refused, got %d", code)
+	}
+	if code := serve("s3cret"); code != http.StatusNoContent {
+		t.Fatalf("Expected an [auth] key to be let through, got %d", code)
+	}
+}
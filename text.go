@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/imgd/pkg/mcskin"
+)
+
+// The size of each pixel of the font /api/text/ draws by default, and the
+// largest it may be asked for.
+const (
+	DefaultTextSize = 2
+	MaxTextSize     = 16
+)
+
+// TextPage draws the text in a small bitmap font, with any § formatting
+// codes applied, for signs, MOTDs and the like.
+func (router *Router) TextPage(w http.ResponseWriter, r *http.Request) {
+	stats.Requested("Text")
+	text := mux.Vars(r)["text"]
+	// Room for every character to have a code before it.
+	if len(text) > mcskin.MaxTextLength*8 {
+		writeError(w, r, http.StatusBadRequest, ErrBadRequest, "text too long")
+		return
+	}
+	size := DefaultTextSize
+	if query := r.URL.Query().Get("size"); query != "" {
+		parsed, err := strconv.Atoi(query)
+		if err != nil || parsed < 1 || parsed > MaxTextSize {
+			writeError(w, r, http.StatusBadRequest, ErrBadRequest, "size not allowed")
+			return
+		}
+		size = parsed
+	}
+
+	sum := md5.Sum([]byte(fmt.Sprintf("v%d|%d|%s", mcskin.Version, size, text)))
+	etag := quoteETag(hex.EncodeToString(sum[:8]))
+	if writeNotModified(w, r, etag, CacheClassRender) {
+		return
+	}
+
+	skin := &mcSkin{Render: mcskin.Render{Processed: mcskin.DrawText(text, size)}}
+	data, err := router.encodeType(".png", skin)
+	if err != nil {
+		writeInternalError(w, r, ErrRenderFailed)
+		stats.Errored(ErrRenderFailed)
+		return
+	}
+	router.writeType(".png", etag, data, w, r)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/imgd/pkg/mcskin"
+)
+
+func TestTextPage(t *testing.T) {
+	stats = MakeStatsCollector()
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.BindAPI()
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.Mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := serve("/api/text/%C2%A7aHello%20world.png?size=3")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" || w.Header().Get("ETag") == "" {
+		t.Fatalf("Expected a PNG, got %d %v", w.Code, w.Header())
+	}
+	img, err := png.Decode(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := mcskin.DrawText("§aHello world", 3).Bounds(); img.Bounds() != want {
+		t.Fatalf("Expected the text to be %v, got %v", want, img.Bounds())
+	}
+
+	for _, path := range []string{"/api/text/hi.png?size=0", "/api/text/hi.png?size=17", "/api/text/hi.png?size=big"} {
+		if w := serve(path); w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected %s to be refused, got %d", path, w.Code)
+		}
+	}
+}
+
+func TestTextETagHasRenderVersion(t *testing.T) {
+	stats = MakeStatsCollector()
+	router := &Router{Mux: mux.NewRouter()}
+	router.BindAPI()
+	w := httptest.NewRecorder()
+	router.Mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/text/imgd.png", nil))
+	sum := md5.Sum([]byte(fmt.Sprintf("v%d|%d|imgd", mcskin.Version, DefaultTextSize)))
+	if etag := w.Header().Get("ETag"); etag != quoteETag(hex.EncodeToString(sum[:8])) {
+		t.Fatalf("Expected the ETag to change with the render version, got %s", etag)
+	}
+}
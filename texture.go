@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/imgd/pkg/mcclient"
+	"github.com/minotar/imgd/pkg/mcskin"
+	"github.com/minotar/minecraft"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Matches the hash at the end of a textures.minecraft.net URL.
+const textureHashRegex = "[0-9a-fA-F]{32,64}"
+
+// Returns the hash Mojang's URL for the skin's texture ends in. Skins
+// which aren't Mojang's fall back to our own key for them.
+func mojangTextureHash(skin minecraft.Skin) string {
+	if skin.Hash != "" {
+		return skin.Hash
+	}
+	if skin.URL != "" {
+		return mcclient.TextureHash(skin.URL)
+	}
+	return textureKey(skin)
+}
+
+// Textures are cached alongside players, under a prefix no UUID can have.
+func textureCacheKey(hash string) string {
+	return "texture-" + strings.ToLower(hash)
+}
+
+// Fetches the texture with the hash from the cache or Mojang's CDN. This
+// skips looking the player up entirely, for callers which already know
+// which texture they want, eg. from a player head.
+func fetchSkinByHash(ctx context.Context, hash string) *mcSkin {
+	trace := debugTraceFrom(ctx)
+	key := textureCacheKey(hash)
+	start := time.Now()
+	cached := pullCachedSkin(key)
+	trace.lookup("skin", key, cached != nil, start)
+	if cached != nil {
+		return cached
+	}
+
+	start = time.Now()
+	reason := cache.pullNegative(key)
+	trace.lookup("negative", key, reason != NegativeNone, start)
+	if reason != NegativeNone {
+		log.Debugf("Negative cache hit: texture %s (%s)", hash, reason)
+		stats.HitCache()
+		skin := fallbackFor("")
+		skin.Cached = true
+		return skin
+	}
+	stats.MissCache()
+
+	url := config.Minecraft.TextureURL + strings.ToLower(hash)
+	result, err := coalesce("Texture", url, func() (interface{}, error) {
+		return fetchSkinTexture(ctx, url)
+	})
+	if err != nil {
+		log.Infof("Failed texture fetch: %s (%s)", hash, err.Error())
+		stats.Errored(ErrTextureHash)
+
+		reason, ttl := NegativeAPIError, config.errorTtl()
+		if strings.HasSuffix(err.Error(), "user not found") {
+			reason, ttl = NegativeNotFound, config.failedTtl()
+		}
+		addTimer := prometheus.NewTimer(cacheDuration.WithLabelValues("add"))
+		cache.addNegative(key, reason, ttl)
+		addTimer.ObserveDuration()
+
+		return fallbackFor("")
+	}
+
+	skin := result.(minecraft.Skin)
+	// A texture never changes, so there's no need to ever refresh it.
+	addTimer := prometheus.NewTimer(cacheDuration.WithLabelValues("add"))
+	cache.add(key, skin, config.skinTtl())
+	addTimer.ObserveDuration()
+	return &mcSkin{Render: mcskin.Render{Skin: skin, Slim: isSlimSkin(skin)}}
+}
+
+// TexturePage shows the texture with the hash as is.
+func (router *Router) TexturePage(w http.ResponseWriter, r *http.Request) {
+	stats.Requested("Texture")
+	hash := mux.Vars(r)["hash"]
+	skin := fetchSkinByHash(requestContext(r), hash)
+	accessRecordFor(r).noteSkin(skin)
+
+	etag := quoteETag(textureKey(skin.Skin))
+	if writeNotModified(w, r, etag, CacheClassSkin) {
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s.png\"", hash))
+	setCacheHeaders(w, r, CacheClassSkin)
+	w.Header().Add("ETag", etag)
+	w.Header().Add("Content-Type", "image/png")
+	writeSkin(w, r, skin)
+}
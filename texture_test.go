@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/minecraft"
+)
+
+func TestTextureRoutes(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	renderCache = MakeRenderCache(0, false)
+	hotCache = MakeHotCache(nil, 0)
+	config.Ttl.Skin = 60
+
+	texture := new(bytes.Buffer)
+	png.Encode(texture, image.NewNRGBA(image.Rect(0, 0, 64, 64)))
+
+	hash := "3b60a1f6d562f52aaebbf1434f1de147933a3affe0e764fa49ea057536623cd3"
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/texture/"+hash {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(texture.Bytes())
+	}))
+	defer server.Close()
+	config.Minecraft.TextureURL = server.URL + "/texture/"
+	mcClient = &minecraft.Minecraft{Client: server.Client()}
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+
+	for _, path := range []string{"/texture/" + hash, "/texture/" + hash + "/avatar/32.png"} {
+		w := httptest.NewRecorder()
+		router.Mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+			t.Fatalf("Expected %s to be served, got %d", path, w.Code)
+		}
+	}
+	if requests != 1 {
+		t.Fatalf("Expected the texture to be fetched once then cached, got %d fetches", requests)
+	}
+	w := httptest.NewRecorder()
+	router.Mux.ServeHTTP(w, httptest.NewRequest("GET", "/texture/"+hash, nil))
+	if w.Header().Get("ETag") != "\""+hash+"\"" {
+		t.Fatalf("Expected the hash as the ETag, got %s", w.Header().Get("ETag"))
+	}
+
+	missing := "0000000000000000000000000000000000000000000000000000000000000000"
+	config.Ttl.Failed = 60
+	fetchSkinByHash(context.Background(), missing)
+	if !fetchSkinByHash(context.Background(), missing).Fallback || requests != 2 {
+		t.Fatalf("Expected a missing texture to be remembered, got %d fetches", requests)
+	}
+}
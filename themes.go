@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/minotar/imgd/pkg/mcskin"
+)
+
+// A named background, as a [theme "<name>"] section, drawn behind renders
+// asked for with ?theme=<name>, so embeds match the site they're on without
+// it hard-coding colours. The background fades from Background at the top to
+// Gradient at the bottom, or is solid without one.
+type Theme struct {
+	Background string
+	Gradient   string
+}
+
+// The parsed themes, by name, from the [theme] config.
+var themes map[string]mcskin.Background
+
+// Parses each theme's colours.
+func parseThemes(sections map[string]*Theme) (map[string]mcskin.Background, error) {
+	parsed := map[string]mcskin.Background{}
+	for name, theme := range sections {
+		if theme == nil {
+			continue
+		}
+		background, err := parseTheme(theme)
+		if err != nil {
+			return nil, fmt.Errorf("[theme \"%s\"] %v", name, err)
+		}
+		parsed[name] = background
+	}
+	return parsed, nil
+}
+
+func parseTheme(theme *Theme) (mcskin.Background, error) {
+	top, err := parseHexColor(theme.Background)
+	if err != nil {
+		return mcskin.Background{}, fmt.Errorf("background: %v", err)
+	}
+	bottom := top
+	if theme.Gradient != "" {
+		if bottom, err = parseHexColor(theme.Gradient); err != nil {
+			return mcskin.Background{}, fmt.Errorf("gradient: %v", err)
+		}
+	}
+	return mcskin.Background{Top: top, Bottom: bottom}, nil
+}
+
+// Returns whether the request asks for a theme we don't have.
+func themeKnown(r *http.Request) bool {
+	name := r.URL.Query().Get("theme")
+	if name == "" {
+		return true
+	}
+	_, exists := themes[name]
+	return exists
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/minecraft"
+)
+
+func TestParseThemes(t *testing.T) {
+	parsed, err := parseThemes(map[string]*Theme{
+		"dark":  {Background: "2b2d42", Gradient: "14151f"},
+		"brand": {Background: "#5fb65f"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed["dark"].Bottom != (color.NRGBA{0x14, 0x15, 0x1f, 0xFF}) {
+		t.Fatalf("Expected the gradient at the bottom, got %+v", parsed["dark"])
+	}
+	if parsed["brand"].Top != parsed["brand"].Bottom {
+		t.Fatalf("Expected a theme without a gradient to be solid, got %+v", parsed["brand"])
+	}
+
+	if _, err := parseThemes(map[string]*Theme{"bad": {Background: "navy"}}); err == nil {
+		t.Fatal("Expected a colour which isn't hex to be refused")
+	}
+}
+
+func TestThemes(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	renderCache = MakeRenderCache(0, false)
+	hotCache = MakeHotCache(nil, 0)
+	missingFilter = MakeMissingFilter(0, time.Minute)
+	defer func(stale int) { config.Ttl.Stale = stale }(config.Ttl.Stale)
+	config.Ttl.Stale = 0
+
+	saved := themes
+	defer func() { themes = saved }()
+	var err error
+	if themes, err = parseThemes(map[string]*Theme{"light": {Background: "f8f9fa"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	skin, _ := minecraft.FetchSkinForSteve()
+	skin.Hash = "clone1018"
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.Mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := serve("/body/d9135e082f2244c89cb10d21ed3ac8fd/100.png?theme=light")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the themed render, got %d", w.Code)
+	}
+	img, err := png.Decode(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The corner beside the head is transparent without a theme.
+	if c := color.NRGBAModel.Convert(img.At(0, 0)); c != themes["light"].Top {
+		t.Fatalf("Expected the theme's background, got %v", c)
+	}
+
+	if w := serve("/body/d9135e082f2244c89cb10d21ed3ac8fd/100.png?theme=sepia"); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected an unknown theme to be refused, got %d", w.Code)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Keep one slot per minute for the last 24 hours.
+const (
+	timeSeriesInterval = 60
+	timeSeriesSlots    = 24 * 60
+)
+
+type timeSeriesSlot struct {
+	// Unix timestamp of the start of the minute this slot counts.
+	Time        int64
+	Requests    uint
+	CacheHits   uint
+	CacheMisses uint
+	Errors      uint
+}
+
+// Ring buffer of per-minute counters, so the status page can show trends
+// without needing any external monitoring.
+type TimeSeries struct {
+	mu    sync.Mutex
+	slots [timeSeriesSlots]timeSeriesSlot
+}
+
+// Returns the slot for the given time, clearing it out if it was last used
+// for an older minute.
+func (t *TimeSeries) slot(now time.Time) *timeSeriesSlot {
+	minute := now.Unix() / timeSeriesInterval
+	slot := &t.slots[minute%timeSeriesSlots]
+	if slot.Time != minute*timeSeriesInterval {
+		*slot = timeSeriesSlot{Time: minute * timeSeriesInterval}
+	}
+	return slot
+}
+
+// Counts a status message against the current minute.
+func (t *TimeSeries) record(now time.Time, messageType uint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	slot := t.slot(now)
+	switch messageType {
+	case StatusTypeRequested:
+		slot.Requests++
+	case StatusTypeCacheHit:
+		slot.CacheHits++
+	case StatusTypeCacheMiss:
+		slot.CacheMisses++
+	case StatusTypeErrored:
+		slot.Errors++
+	}
+}
+
+// Returns the slots from oldest to newest, ending with the current minute.
+// Minutes nothing happened in are included with zero counts.
+func (t *TimeSeries) Slots(now time.Time) []timeSeriesSlot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current := now.Unix() / timeSeriesInterval
+	slots := make([]timeSeriesSlot, 0, timeSeriesSlots)
+	for minute := current - timeSeriesSlots + 1; minute <= current; minute++ {
+		slot := t.slots[minute%timeSeriesSlots]
+		if slot.Time != minute*timeSeriesInterval {
+			slot = timeSeriesSlot{Time: minute * timeSeriesInterval}
+		}
+		slots = append(slots, slot)
+	}
+	return slots
+}
+
+// Encodes the time series to a JSON string byte slice
+func (t *TimeSeries) ToJSON() []byte {
+	results, _ := json.Marshal(struct {
+		Interval int
+		Slots    []timeSeriesSlot
+	}{timeSeriesInterval, t.Slots(time.Now())})
+	return results
+}
+
+// Returns the fraction of cache lookups which hit over the last few minutes,
+// including the current one, or 0 if there were none.
+func (t *TimeSeries) hitRatio(now time.Time, minutes int) float64 {
+	slots := t.Slots(now)
+	var hits, lookups uint
+	for _, slot := range slots[len(slots)-minutes:] {
+		hits += slot.CacheHits
+		lookups += slot.CacheHits + slot.CacheMisses
+	}
+	if lookups == 0 {
+		return 0
+	}
+	return float64(hits) / float64(lookups)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+)
+
+// Serves the certificate from CertFile and KeyFile, reloading it when
+// either changes, so a renewed certificate is picked up without a restart.
+type CertReloader struct {
+	CertFile string
+	KeyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	// When the files were last modified, as of the last load.
+	certMod time.Time
+	keyMod  time.Time
+}
+
+func MakeCertReloader(certFile string, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{CertFile: certFile, KeyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CertReloader) load() error {
+	certMod, keyMod, err := r.modTimes()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cert = &cert
+	r.certMod = certMod
+	r.keyMod = keyMod
+	return nil
+}
+
+func (r *CertReloader) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(r.CertFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	keyInfo, err := os.Stat(r.KeyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
+
+// Reloads the certificate if either file has changed since we last loaded
+// it. A certificate which fails to load, eg. as it's only half written, is
+// ignored in favour of the one we have.
+func (r *CertReloader) reload() {
+	certMod, keyMod, err := r.modTimes()
+	if err != nil {
+		log.Errorf("Unable to check TLS certificate: %s", err.Error())
+		return
+	}
+	r.mu.RLock()
+	changed := !certMod.Equal(r.certMod) || !keyMod.Equal(r.keyMod)
+	r.mu.RUnlock()
+	if !changed {
+		return
+	}
+
+	if err := r.load(); err != nil {
+		log.Errorf("Unable to reload TLS certificate: %s", err.Error())
+		return
+	}
+	log.Noticef("Reloaded TLS certificate %s", r.CertFile)
+}
+
+// Checks for a changed certificate every interval.
+func (r *CertReloader) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		r.reload()
+	}
+}
+
+// For tls.Config.
+func (r *CertReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, nil
+}
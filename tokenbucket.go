@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Allows Rate events a second on average, and bursts of up to Burst at
+// once.
+type TokenBucket struct {
+	Rate  float64
+	Burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// Starts full, so a freshly started instance can serve a burst straight
+// away.
+func MakeTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{Rate: rate, Burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Takes a token, returning false if there are none left.
+func (b *TokenBucket) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Takes n tokens however many are left, which may leave the bucket in debt
+// until it's refilled enough for another to be taken.
+func (b *TokenBucket) charge(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	b.tokens -= float64(n)
+}
+
+// How long until there's a token to take.
+func (b *TokenBucket) wait() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	if b.tokens >= 1 || b.Rate <= 0 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.Rate * float64(time.Second))
+}
+
+// Whether the bucket has refilled completely.
+func (b *TokenBucket) full() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	return b.tokens >= b.Burst
+}
+
+// Number of whole tokens left.
+func (b *TokenBucket) available() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	return int(b.tokens)
+}
+
+// Adds the tokens earned since we last did. Must be called with the lock
+// held.
+func (b *TokenBucket) refill() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.Rate
+	if b.tokens > b.Burst {
+		b.tokens = b.Burst
+	}
+	b.last = now
+}
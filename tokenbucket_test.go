@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := MakeTokenBucket(60, 2)
+	if !b.take() || !b.take() {
+		t.Fatal("Expected the burst to be allowed")
+	}
+	if b.take() {
+		t.Fatal("Expected the bucket to be empty")
+	}
+
+	b.last = b.last.Add(-time.Second)
+	if !b.take() {
+		t.Fatal("Expected a token after a second")
+	}
+
+	b.last = b.last.Add(-time.Hour)
+	if b.available() != 2 {
+		t.Fatalf("Expected the bucket to refill only to the burst, got %d", b.available())
+	}
+}
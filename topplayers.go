@@ -0,0 +1,201 @@
+package main
+
+import (
+	"container/heap"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Shape of each count-min sketch, which overcounts a name by at most
+// e/topSketchWidth of the requests in the window, 19 times in 20.
+const (
+	topSketchWidth = 2048
+	topSketchDepth = 3
+	// Number of pieces the window is split into, so it slides along a
+	// piece at a time rather than all being forgotten at once.
+	topSlots = 6
+)
+
+// Counts requests per name in a fixed amount of memory, never undercounting
+// but sometimes overcounting a name which shares its cells with others.
+type countMinSketch [topSketchDepth][topSketchWidth]uint32
+
+// Calls fn with the cell the name maps to in each row.
+func (s *countMinSketch) cells(name string, fn func(row int, col uint64)) {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32
+
+	for row := 0; row < topSketchDepth; row++ {
+		fn(row, (h1+uint64(row)*h2)%topSketchWidth)
+	}
+}
+
+func (s *countMinSketch) add(name string) {
+	s.cells(name, func(row int, col uint64) {
+		s[row][col]++
+	})
+}
+
+func (s *countMinSketch) count(name string) uint64 {
+	min := uint64(0)
+	s.cells(name, func(row int, col uint64) {
+		if row == 0 || uint64(s[row][col]) < min {
+			min = uint64(s[row][col])
+		}
+	})
+	return min
+}
+
+// A player in the top list, and about how many times they were requested.
+type topPlayer struct {
+	Name  string
+	Count uint64
+	// Where the player is in the heap.
+	index int
+}
+
+// Min-heap of the top players, so the least requested is the one to go.
+type topHeap []*topPlayer
+
+func (h topHeap) Len() int           { return len(h) }
+func (h topHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h topHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *topHeap) Push(x interface{}) {
+	player := x.(*topPlayer)
+	player.index = len(*h)
+	*h = append(*h, player)
+}
+
+func (h *topHeap) Pop() interface{} {
+	old := *h
+	player := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return player
+}
+
+// Keeps the players requested most over the last Window, so operators can
+// see who's worth warming up, or which name a bot is hammering. Requests are
+// counted in a count-min sketch per slice of the window, and only the top
+// Size players are remembered by name, so memory stays fixed however many
+// players are requested.
+type TopPlayers struct {
+	Size   int
+	Window time.Duration
+
+	mu      sync.Mutex
+	slots   [topSlots]countMinSketch
+	current int
+	rotated time.Time
+	top     topHeap
+	players map[string]*topPlayer
+}
+
+func MakeTopPlayers(size int, window time.Duration) *TopPlayers {
+	return &TopPlayers{
+		Size:    size,
+		Window:  window,
+		rotated: time.Now(),
+		players: map[string]*topPlayer{},
+	}
+}
+
+// Returns about how many times the name was requested over the window. Must
+// be called with the lock held.
+func (t *TopPlayers) estimate(name string) uint64 {
+	var count uint64
+	for i := range t.slots {
+		count += t.slots[i].count(name)
+	}
+	return count
+}
+
+// Forgets the oldest slices of the window once they've slid out of it, and
+// brings the top players' counts up to date. Must be called with the lock
+// held.
+func (t *TopPlayers) rotate() {
+	slot := t.Window / topSlots
+	elapsed := time.Since(t.rotated)
+	if elapsed < slot {
+		return
+	}
+
+	for i := 0; i < topSlots && elapsed >= slot; i++ {
+		t.current = (t.current + 1) % topSlots
+		t.slots[t.current] = countMinSketch{}
+		elapsed -= slot
+	}
+	t.rotated = time.Now().Add(-elapsed % slot)
+
+	top := topHeap{}
+	for _, player := range t.top {
+		player.Count = t.estimate(player.Name)
+		if player.Count == 0 {
+			delete(t.players, player.Name)
+			continue
+		}
+		player.index = len(top)
+		top = append(top, player)
+	}
+	heap.Init(&top)
+	t.top = top
+}
+
+// Counts a request for the player.
+func (t *TopPlayers) record(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rotate()
+	t.slots[t.current].add(name)
+	count := t.estimate(name)
+
+	if player, exists := t.players[name]; exists {
+		player.Count = count
+		heap.Fix(&t.top, player.index)
+		return
+	}
+	if len(t.top) < t.Size {
+		player := &topPlayer{Name: name, Count: count}
+		heap.Push(&t.top, player)
+		t.players[name] = player
+		return
+	}
+	if len(t.top) > 0 && count > t.top[0].Count {
+		// Reuse the least requested player's place.
+		player := t.top[0]
+		delete(t.players, player.Name)
+		player.Name, player.Count = name, count
+		t.players[name] = player
+		heap.Fix(&t.top, 0)
+	}
+}
+
+// Returns up to limit of the top players, most requested first.
+func (t *TopPlayers) list(limit int) []topPlayer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rotate()
+	players := make([]topPlayer, 0, len(t.top))
+	for _, player := range t.top {
+		players = append(players, *player)
+	}
+	sort.Slice(players, func(i, j int) bool {
+		if players[i].Count != players[j].Count {
+			return players[i].Count > players[j].Count
+		}
+		return players[i].Name < players[j].Name
+	})
+	if limit < len(players) {
+		players = players[:limit]
+	}
+	return players
+}
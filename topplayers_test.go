@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestTopPlayers(t *testing.T) {
+	top := MakeTopPlayers(3, time.Hour)
+	for name, count := range map[string]int{"clone1018": 50, "lukegb": 30, "citricsquid": 20, "notch": 10} {
+		for i := 0; i < count; i++ {
+			top.record(name)
+		}
+	}
+	// A long tail of names requested once shouldn't push anyone out.
+	for i := 0; i < 1000; i++ {
+		top.record(fmt.Sprintf("player%d", i))
+	}
+
+	players := top.list(10)
+	if len(players) != 3 {
+		t.Fatalf("Expected 3 players, got %+v", players)
+	}
+	for i, name := range []string{"clone1018", "lukegb", "citricsquid"} {
+		if players[i].Name != name {
+			t.Fatalf("Expected %s at %d, got %+v", name, i, players)
+		}
+	}
+	if players[0].Count < 50 {
+		t.Fatalf("Expected clone1018 to be counted at least 50 times, got %d", players[0].Count)
+	}
+	if players := top.list(1); len(players) != 1 || players[0].Name != "clone1018" {
+		t.Fatalf("Expected just clone1018, got %+v", players)
+	}
+}
+
+func TestTopPlayersWindowSlides(t *testing.T) {
+	top := MakeTopPlayers(3, time.Hour)
+	top.record("clone1018")
+
+	// Half the window later, they're still counted.
+	top.rotated = top.rotated.Add(-30 * time.Minute)
+	top.record("lukegb")
+	if players := top.list(3); len(players) != 2 {
+		t.Fatalf("Expected both players, got %+v", players)
+	}
+
+	// Once the window's passed, clone1018 has slid out of it.
+	top.rotated = top.rotated.Add(-40 * time.Minute)
+	if players := top.list(3); len(players) != 1 || players[0].Name != "lukegb" {
+		t.Fatalf("Expected only lukegb, got %+v", players)
+	}
+}
+
+func TestTopPlayersPage(t *testing.T) {
+	saved := topPlayers
+	defer func() { topPlayers = saved }()
+	topPlayers = MakeTopPlayers(10, time.Hour)
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Mux.Use(recordRoute)
+	router.Serve("Avatar")
+	router.BindAdmin()
+	handler := authHandler([]Authenticator{MakeAPIKeyAuthenticator([]string{"ops:s3cret"})}, false, router.Mux)
+	for _, path := range []string{"/avatar/Clone1018", "/avatar/clone1018/64", "/avatar/lukegb"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("HEAD", path, nil))
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/admin/players/top", nil)
+	r.Header.Set("X-API-Key", "s3cret")
+	handler.ServeHTTP(w, r)
+
+	page := struct {
+		Window  int64
+		Players []topPlayer
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Expected the players as JSON, got %d %q", w.Code, w.Body.String())
+	}
+	if page.Window != 3600 || len(page.Players) != 2 || page.Players[0].Name != "clone1018" || page.Players[0].Count != 2 {
+		t.Fatalf("Expected clone1018 twice then lukegb, got %+v", page)
+	}
+}
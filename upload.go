@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Most a skin upload may be. A 64x64 PNG is well under this, even with
+// metadata.
+const MaxSkinUploadSize = 256 * 1024
+
+// Returns why the data isn't a skin we'd accept, or nil if it is one.
+func checkSkinUpload(data []byte) error {
+	image, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("not a PNG")
+	}
+	if image.Width != 64 || image.Height != 64 {
+		return fmt.Errorf("skin must be 64x64, not %dx%d", image.Width, image.Height)
+	}
+	if _, _, err := decodeStoredSkin(data); err != nil {
+		return fmt.Errorf("unreadable skin")
+	}
+	return nil
+}
+
+// Middleware which only lets those with an upload or admin key through, as
+// skin uploads change what everyone's served. Identities from [auth] won't
+// do, as they're handed out to anyone using the public routes.
+func requireUploader(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, identity := adminKey(r), ""
+		if uploadKeys != nil {
+			identity = uploadKeys.identify(key)
+		}
+		if identity == "" && adminKeys != nil {
+			identity = adminKeys.identify(key)
+		}
+		if identity == "" {
+			log.Warningf("Refused skin upload %s from %s", r.RequestURI, clientIP(r))
+			stats.Errored(ErrAuthDenied)
+			writeError(w, r, http.StatusForbidden, ErrForbidden, "skin uploads need an upload or admin key")
+			return
+		}
+		handler(w, r.WithContext(context.WithValue(r.Context(), authContextKey{}, identity)))
+	}
+}
+
+// SkinUploadPage stores the PNG body as the player's skin, which is served
+// in place of their Mojang skin from then on.
+func (router *Router) SkinUploadPage(w http.ResponseWriter, r *http.Request) {
+	store, ok := skinStore.(WritableSkinStore)
+	if !config.Offline.Upload || !ok {
+		writeError(w, r, http.StatusNotImplemented, ErrNotEnabled, "skin uploads aren't enabled")
+		return
+	}
+	uuid, ok := normalizeUUID(mux.Vars(r)["uuid"])
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, ErrBadRequest, "invalid UUID")
+		return
+	}
+
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxSkinUploadSize))
+	if err != nil {
+		writeError(w, r, http.StatusRequestEntityTooLarge, ErrBadRequest, "skin must be at most %d bytes", MaxSkinUploadSize)
+		return
+	}
+	if err := checkSkinUpload(data); err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrBadRequest, "%s", err.Error())
+		return
+	}
+
+	if err := store.store(uuid, data); err != nil {
+		log.Errorf("Failed to store skin: %s (%v)", uuid, err)
+		stats.Errored(ErrSkinStore)
+		writeInternalError(w, r, ErrInternal)
+		return
+	}
+	// Whatever we had for them is out of date now.
+	purgePlayer(uuid, uuid)
+	if purger != nil {
+		purger.purge(uuid, uuid)
+	}
+
+	log.Noticef("Stored skin for %s (by %s)", uuid, authIdentity(r))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestSkinUploadPage(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	renderCache = MakeRenderCache(0, false)
+	hotCache = MakeHotCache(nil, 0)
+	missingFilter = MakeMissingFilter(0, time.Minute)
+	config.Ttl.Skin = 60
+
+	dir := t.TempDir()
+	skinStore = &DiskSkinStore{Path: dir}
+	config.Offline.Upload = true
+	adminKeys = MakeAPIKeyAuthenticator([]string{"ops:adm1n"})
+	defer func() {
+		skinStore = nil
+		config.Offline.Upload = false
+		adminKeys = nil
+	}()
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+	upload := func(uuid string, key string, body []byte) int {
+		r := httptest.NewRequest("PUT", "/api/skin/"+uuid, bytes.NewReader(body))
+		r.Header.Set("X-Admin-Key", key)
+		w := httptest.NewRecorder()
+		router.Mux.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	notch := "069a79f444e94726a5befca90e38aaf5"
+	if code := upload(notch, "wrong", encodeTestSkin(64, 64)); code != http.StatusForbidden {
+		t.Fatalf("Expected an upload without the key to be refused, got %d", code)
+	}
+	if code := upload(notch, "adm1n", encodeTestSkin(64, 32)); code != http.StatusBadRequest {
+		t.Fatalf("Expected a legacy sized skin to be refused, got %d", code)
+	}
+	if code := upload(notch, "adm1n", []byte("GIF89a")); code != http.StatusBadRequest {
+		t.Fatalf("Expected a non-PNG to be refused, got %d", code)
+	}
+	if code := upload("069a79f4-44e9-4726-a5be-fca90e38aaf5", "adm1n", encodeTestSkin(64, 64)); code != http.StatusNoContent {
+		t.Fatalf("Expected the skin to be stored, got %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, notch+".png")); err != nil {
+		t.Fatalf("Expected the skin in the store (%v)", err)
+	}
+
+	// An identity from [auth] isn't enough, but an upload key is.
+	chain := []Authenticator{MakeAPIKeyAuthenticator([]string{"customer:s3cret"})}
+	r := httptest.NewRequest("PUT", "/api/skin/"+notch, bytes.NewReader(encodeTestSkin(64, 64)))
+	r.Header.Set("X-API-Key", "s3cret")
+	w := httptest.NewRecorder()
+	authHandler(chain, false, router.Mux).ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Expected an [auth] identity to be refused, got %d", w.Code)
+	}
+	uploadKeys = MakeAPIKeyAuthenticator([]string{"plugin:upl0ad"})
+	defer func() { uploadKeys = nil }()
+	if code := upload(notch, "upl0ad", encodeTestSkin(64, 64)); code != http.StatusNoContent {
+		t.Fatalf("Expected the upload key to be accepted, got %d", code)
+	}
+
+	skin := fetchSkinVia(context.Background(), notch, false)
+	if skin.Fallback || skin.Source != "SkinStore" {
+		t.Fatalf("Expected the uploaded skin in place of Mojang's, got %s", skin.Source)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Returned in place of asking Mojang while we're backing off.
+var errUpstreamBlocked = errors.New("upstream blocked: backing off after rate limit")
+
+// Returned in place of asking Mojang once we've spent our request budget.
+var errBudgetExhausted = errors.New("upstream blocked: request budget exhausted")
+
+// Guards our requests to Mojang. Once they rate limit us, we stop asking
+// them for a while, backing off exponentially with jitter for as long as
+// they keep doing so, rather than hammering them until we're blocked
+// outright. Requests made while we're backing off are shed, as are those
+// made while the Breaker is open or once the Budget is spent.
+type Upstream struct {
+	// The first backoff, and the most it may grow to.
+	Base    time.Duration
+	Max     time.Duration
+	Breaker *CircuitBreaker
+	// Caps our requests below Mojang's own limit, nil for no cap.
+	Budget *TokenBucket
+
+	mu sync.Mutex
+	// Rate limits seen in a row, without a successful request between.
+	failures uint
+	until    time.Time
+	// Number of times we've backed off.
+	blocks uint
+
+	health UpstreamHealth
+}
+
+func MakeUpstream(base, max time.Duration) *Upstream {
+	return &Upstream{Base: base, Max: max, Breaker: MakeCircuitBreaker(0, 0)}
+}
+
+// Runs fn against Mojang, unless we're backing off or they're down.
+func (u *Upstream) do(fn func() (interface{}, error)) (interface{}, error) {
+	if _, blocked := u.blocked(); blocked {
+		stats.Errored(ErrUpstreamBlocked)
+		upstreamCounter.WithLabelValues("mojang", "blocked").Inc()
+		return nil, errUpstreamBlocked
+	}
+	// The breaker's checked first, so requests it sheds don't spend the
+	// budget.
+	if !u.Breaker.allow() {
+		stats.Errored(ErrCircuitOpen)
+		upstreamCounter.WithLabelValues("mojang", "circuit_open").Inc()
+		return nil, errCircuitOpen
+	}
+	if u.Budget != nil && !u.Budget.take() {
+		stats.Errored(ErrUpstreamBudget)
+		upstreamCounter.WithLabelValues("mojang", "budget_exhausted").Inc()
+		return nil, errBudgetExhausted
+	}
+
+	var result interface{}
+	var err error
+	start := time.Now()
+	if chaos != nil {
+		result, err = chaos.upstream(fn)
+	} else {
+		result, err = fn()
+	}
+	switch {
+	case isRateLimited(err):
+		// They're up, just busy. The backoff deals with that.
+		u.limited()
+		u.Breaker.succeeded()
+		u.health.record(time.Since(start), err)
+		upstreamCounter.WithLabelValues("mojang", "rate_limited").Inc()
+	case err != nil && !strings.HasSuffix(err.Error(), "user not found"):
+		u.Breaker.failed()
+		u.health.record(time.Since(start), err)
+		upstreamCounter.WithLabelValues("mojang", "error").Inc()
+	default:
+		u.succeeded()
+		u.Breaker.succeeded()
+		u.health.record(time.Since(start), nil)
+		upstreamCounter.WithLabelValues("mojang", "ok").Inc()
+	}
+	return result, err
+}
+
+// Returns how much longer we're backing off for, if we are.
+func (u *Upstream) blocked() (time.Duration, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	remaining := time.Until(u.until)
+	return remaining, remaining > 0
+}
+
+// Starts, or extends, a backoff.
+func (u *Upstream) limited() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	delay := u.Max
+	if u.failures < 32 && u.Base<<u.failures < u.Max {
+		delay = u.Base << u.failures
+	}
+	u.failures++
+	// Spread out when a fleet of instances comes back.
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+
+	// A request already in flight may be rate limited after we've started
+	// backing off, don't let it cut the backoff short.
+	if until := time.Now().Add(delay); until.After(u.until) {
+		u.until = until
+	}
+	u.blocks++
+	log.Warningf("Rate limited by Mojang, backing off for %s", delay)
+}
+
+func (u *Upstream) succeeded() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.failures = 0
+}
+
+// Number of times we've backed off.
+func (u *Upstream) blockCount() uint {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.blocks
+}
+
+// Whether the error is Mojang telling us to slow down.
+func isRateLimited(err error) bool {
+	return err != nil && strings.HasSuffix(err.Error(), "rate limited")
+}
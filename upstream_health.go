@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Most recent requests to an upstream its health is judged on.
+	healthSamples = 256
+	// How far back those requests may go.
+	healthWindow = 5 * time.Minute
+)
+
+// How available an upstream seems, from how our requests to it have gone.
+// Mojang no longer publish their own status, so this is the best we have.
+const (
+	// We haven't asked it anything lately.
+	AvailabilityUnknown = iota - 1
+	AvailabilityDown
+	// Rate limiting us, or failing some requests.
+	AvailabilityDegraded
+	AvailabilityUp
+)
+
+var availabilityNames = map[int]string{
+	AvailabilityUnknown:  "unknown",
+	AvailabilityDown:     "down",
+	AvailabilityDegraded: "degraded",
+	AvailabilityUp:       "up",
+}
+
+// Fraction of recent requests which must succeed for an upstream to be up,
+// and below which it's down rather than degraded.
+const (
+	availableSuccessRate = 0.95
+	downSuccessRate      = 0.5
+)
+
+// The outcome of a request to an upstream.
+type healthSample struct {
+	At      time.Time
+	Latency time.Duration
+	OK      bool
+}
+
+// Keeps the outcomes of the last healthSamples requests to an upstream, and
+// the last error it gave, for /admin/upstreams. The zero value is ready to
+// use.
+type UpstreamHealth struct {
+	mu          sync.Mutex
+	samples     [healthSamples]healthSample
+	next        int
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// Records the outcome of a request which took latency. Requests the client
+// gave up on say nothing of the upstream's health.
+func (h *UpstreamHealth) record(latency time.Duration, err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	h.samples[h.next%healthSamples] = healthSample{At: now, Latency: latency, OK: err == nil}
+	h.next++
+	if err != nil {
+		h.lastError = err.Error()
+		h.lastErrorAt = now
+	}
+}
+
+// An upstream's health, as /admin/upstreams reports it.
+type upstreamReport struct {
+	Name string
+	// "mojang", "mirror" or "textures".
+	Kind string
+	// State of its circuit breaker: "closed", "half-open" or "open". Mirrors
+	// open for a while after failing several times in a row.
+	Circuit string
+	// Seconds left backing off from it after it rate limited us, if any.
+	Backoff float64 `json:",omitempty"`
+	// "up", "degraded", "down", or "unknown" if we've not asked it anything
+	// lately.
+	Availability string
+	// Requests it was asked over the last healthWindow, up to
+	// healthSamples, the fraction which succeeded, and their median
+	// latency in milliseconds.
+	Requests      int
+	SuccessRate   float64
+	MedianLatency float64
+	LastError     string     `json:",omitempty"`
+	LastErrorAt   *time.Time `json:",omitempty"`
+}
+
+// Fills in the report from the recent requests.
+func (h *UpstreamHealth) report(report *upstreamReport) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	since := time.Now().Add(-healthWindow)
+	latencies := []time.Duration{}
+	succeeded := 0
+	for i := h.next - 1; i >= 0 && i >= h.next-healthSamples; i-- {
+		sample := h.samples[i%healthSamples]
+		if sample.At.Before(since) {
+			break
+		}
+		latencies = append(latencies, sample.Latency)
+		if sample.OK {
+			succeeded++
+		}
+	}
+
+	report.Requests = len(latencies)
+	if len(latencies) > 0 {
+		report.SuccessRate = float64(succeeded) / float64(len(latencies))
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		report.MedianLatency = float64(latencies[len(latencies)/2]) / float64(time.Millisecond)
+	}
+	if h.lastError != "" {
+		at := h.lastErrorAt
+		report.LastError = h.lastError
+		report.LastErrorAt = &at
+	}
+}
+
+// Judges how available the upstream is from the rest of its report.
+func (report *upstreamReport) availability() int {
+	switch {
+	case report.Circuit == circuitStateNames[CircuitOpen]:
+		return AvailabilityDown
+	case report.Backoff > 0:
+		// Up, but not for us.
+		return AvailabilityDegraded
+	case report.Requests == 0:
+		return AvailabilityUnknown
+	case report.SuccessRate < downSuccessRate:
+		return AvailabilityDown
+	case report.SuccessRate < availableSuccessRate:
+		return AvailabilityDegraded
+	default:
+		return AvailabilityUp
+	}
+}
+
+// Reports on Mojang, each mirror, then the textures CDN.
+func upstreamReports() []upstreamReport {
+	reports := []upstreamReport{}
+	if upstream != nil {
+		report := upstreamReport{Name: "mojang", Kind: "mojang", Circuit: circuitStateNames[upstream.Breaker.currentState()]}
+		if remaining, blocked := upstream.blocked(); blocked {
+			report.Backoff = remaining.Seconds()
+		}
+		upstream.health.report(&report)
+		reports = append(reports, report)
+	}
+	for _, mirror := range mirrors {
+		report := upstreamReport{Name: mirror.Name, Kind: "mirror", Circuit: circuitStateNames[CircuitClosed]}
+		if !mirror.healthy() {
+			report.Circuit = circuitStateNames[CircuitOpen]
+		}
+		mirror.health.report(&report)
+		reports = append(reports, report)
+	}
+	report := upstreamReport{Name: "textures", Kind: "textures", Circuit: circuitStateNames[CircuitClosed]}
+	textureHealth.report(&report)
+	reports = append(reports, report)
+
+	for i := range reports {
+		reports[i].Availability = availabilityNames[reports[i].availability()]
+	}
+	return reports
+}
+
+// UpstreamsPage reports the health of each upstream we depend on, so when
+// something's wrong it's quick to tell whether it's us or them.
+func (router *Router) UpstreamsPage(w http.ResponseWriter, r *http.Request) {
+	page, _ := json.Marshal(upstreamReports())
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(page)
+}
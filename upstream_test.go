@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestUpstreamBacksOff(t *testing.T) {
+	stats = MakeStatsCollector()
+	u := MakeUpstream(time.Minute, 4*time.Minute)
+
+	limited := func() (interface{}, error) {
+		return nil, errors.New("unable to GetAPIProfile: rate limited")
+	}
+	if _, err := u.do(limited); err == errUpstreamBlocked {
+		t.Fatal("Shouldn't be blocked before being rate limited")
+	}
+
+	remaining, blocked := u.blocked()
+	if !blocked || remaining > time.Minute || remaining < 30*time.Second {
+		t.Fatalf("Expected to back off for 30s to 1m, got %s", remaining)
+	}
+
+	calls := 0
+	if _, err := u.do(func() (interface{}, error) { calls++; return nil, nil }); err != errUpstreamBlocked || calls != 0 {
+		t.Fatal("Expected requests to be shed while backing off")
+	}
+
+	// Keep being limited, and the backoff grows to the max.
+	for i := 0; i < 5; i++ {
+		u.until = time.Time{}
+		u.limited()
+	}
+	if remaining, _ := u.blocked(); remaining > 4*time.Minute || remaining < 2*time.Minute {
+		t.Fatalf("Expected the backoff to be capped, got %s", remaining)
+	}
+	if u.blockCount() != 6 {
+		t.Fatalf("Expected 6 backoffs, got %d", u.blockCount())
+	}
+
+	u.until = time.Time{}
+	u.do(func() (interface{}, error) { return "853c80ef3c3749fdaa49938b674adae6", nil })
+	if u.failures != 0 {
+		t.Fatal("Expected a success to reset the backoff")
+	}
+}
+
+func TestUpstreamBudget(t *testing.T) {
+	stats = MakeStatsCollector()
+	u := MakeUpstream(time.Minute, time.Minute)
+	u.Budget = MakeTokenBucket(0, 1)
+
+	calls := 0
+	fn := func() (interface{}, error) { calls++; return nil, nil }
+	if _, err := u.do(fn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.do(fn); err != errBudgetExhausted || calls != 1 {
+		t.Fatal("Expected requests to be shed once the budget is spent")
+	}
+}
+
+func TestUpstreamBreakerSparesBudget(t *testing.T) {
+	stats = MakeStatsCollector()
+	u := MakeUpstream(time.Minute, time.Minute)
+	u.Breaker = MakeCircuitBreaker(1, time.Minute)
+	u.Budget = MakeTokenBucket(0, 2)
+
+	u.do(func() (interface{}, error) {
+		return nil, errors.New("unable to GetSessionProfile: 503 Service Unavailable")
+	})
+	if _, err := u.do(func() (interface{}, error) { return nil, nil }); err != errCircuitOpen {
+		t.Fatalf("Expected the open circuit to shed the request, got %v", err)
+	}
+	if u.Budget.available() != 1 {
+		t.Fatalf("Expected the shed request not to spend the budget, %d left", u.Budget.available())
+	}
+}
+
+func TestUpstreamsPage(t *testing.T) {
+	stats = MakeStatsCollector()
+	savedUpstream, savedMirrors, savedTextures := upstream, mirrors, textureHealth
+	defer func() { upstream, mirrors, textureHealth = savedUpstream, savedMirrors, savedTextures }()
+	upstream = MakeUpstream(time.Minute, time.Minute)
+	mirrors, _ = MakeMirrors([]string{"ashcon:https://api.ashcon.app/mojang/v2/user/"})
+	textureHealth = &UpstreamHealth{}
+
+	for _, latency := range []time.Duration{10, 30, 20} {
+		upstream.health.record(latency*time.Millisecond, nil)
+	}
+	upstream.do(func() (interface{}, error) { return nil, errors.New("unable to GetAPIProfile: 503") })
+	// Players not being found is the mirror working.
+	mirrors[0].record(time.Millisecond, errors.New("unable to GetMirrorProfile: user not found"))
+	textureHealth.record(time.Millisecond, context.Canceled)
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Mux.HandleFunc("/admin/upstreams", router.UpstreamsPage)
+	w := httptest.NewRecorder()
+	router.Mux.ServeHTTP(w, httptest.NewRequest("GET", "/admin/upstreams", nil))
+	reports := []upstreamReport{}
+	if err := json.Unmarshal(w.Body.Bytes(), &reports); err != nil || len(reports) != 3 {
+		t.Fatalf("Expected Mojang, the mirror and the textures CDN, got %q", w.Body.String())
+	}
+
+	mojang, mirror, textures := reports[0], reports[1], reports[2]
+	if mojang.Name != "mojang" || mojang.Circuit != "closed" || mojang.Requests != 4 || mojang.SuccessRate != 0.75 {
+		t.Fatalf("Expected 3 of Mojang's 4 requests to have succeeded, got %+v", mojang)
+	}
+	if mojang.MedianLatency < 20 || mojang.LastError != "unable to GetAPIProfile: 503" || mojang.LastErrorAt == nil {
+		t.Fatalf("Expected the median latency and last error, got %+v", mojang)
+	}
+	if mirror.Name != "api.ashcon.app" || mirror.SuccessRate != 1 || mirror.LastError != "" {
+		t.Fatalf("Expected the mirror to be healthy, got %+v", mirror)
+	}
+	if textures.Requests != 0 {
+		t.Fatalf("Expected a cancelled request not to count, got %+v", textures)
+	}
+}
+
+func TestUpstreamAvailability(t *testing.T) {
+	for _, expected := range []struct {
+		report       upstreamReport
+		availability string
+	}{
+		{upstreamReport{Circuit: "closed"}, "unknown"},
+		{upstreamReport{Circuit: "closed", Requests: 100, SuccessRate: 0.99}, "up"},
+		{upstreamReport{Circuit: "closed", Requests: 100, SuccessRate: 0.8}, "degraded"},
+		{upstreamReport{Circuit: "closed", Requests: 100, SuccessRate: 0.2}, "down"},
+		{upstreamReport{Circuit: "closed", Requests: 100, SuccessRate: 0.2, Backoff: 30}, "degraded"},
+		{upstreamReport{Circuit: "open", Requests: 100, SuccessRate: 0.99}, "down"},
+	} {
+		if availability := availabilityNames[expected.report.availability()]; availability != expected.availability {
+			t.Errorf("Expected %+v to be %s, got %s", expected.report, expected.availability, availability)
+		}
+	}
+
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	saved := upstream
+	defer func() { upstream = saved }()
+	upstream = MakeUpstream(time.Minute, time.Minute)
+	upstream.do(func() (interface{}, error) { return nil, nil })
+	stats.Collect()
+	if availability := stats.snapshot().UpstreamAvailability; availability != "up" {
+		t.Fatalf("Expected Mojang to be up, got %q", availability)
+	}
+}
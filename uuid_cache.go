@@ -0,0 +1,38 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/minotar/minecraft"
+)
+
+// Most usernames we'll remember UUIDs for at once.
+const uuidCacheCount = 100000
+
+// Matches a UUID with its dashes stripped.
+var uuidRegex = regexp.MustCompile("^[0-9a-f]{32}$")
+
+// Matches a username, a Floodgate gamertag, or a UUID with or without its
+// dashes, in a route.
+var playerRegex = "(?:[0-9a-fA-F]{8}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{12}|\\.?" + minecraft.ValidUsernameRegex + ")"
+
+func isUUID(player string) bool {
+	return uuidRegex.MatchString(player)
+}
+
+// Returns the player as a UUID in the form we cache skins under, if it is
+// one.
+func normalizeUUID(player string) (string, bool) {
+	uuid := strings.ToLower(strings.Replace(player, "-", "", -1))
+	return uuid, isUUID(uuid)
+}
+
+// Remembers which UUID each username belongs to. Skins are cached by UUID,
+// so this is what lets us find a player's skin from their username. It has
+// its own TTL as usernames can change hands.
+type UUIDCache = TTLCache[string]
+
+func MakeUUIDCache() *UUIDCache {
+	return MakeTTLCache[string](uuidCacheCount)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minotar/minecraft"
+)
+
+func TestNormalizeUUID(t *testing.T) {
+	for _, player := range []string{"d9135e082f2244c89cb10d21ed3ac8fd", "D9135E08-2F22-44C8-9CB1-0D21ED3AC8FD"} {
+		if uuid, ok := normalizeUUID(player); !ok || uuid != "d9135e082f2244c89cb10d21ed3ac8fd" {
+			t.Fatalf("Expected %s to be a UUID, got %s", player, uuid)
+		}
+	}
+	if _, ok := normalizeUUID("clone1018"); ok {
+		t.Fatal("Expected a username not to be a UUID")
+	}
+}
+
+func TestUUIDRoutes(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+	renderCache = MakeRenderCache(0, false)
+	hotCache = MakeHotCache(nil, 0)
+	missingFilter = MakeMissingFilter(0, time.Minute)
+
+	skin, _ := minecraft.FetchSkinForSteve()
+	skin.Hash = "clone1018"
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+
+	router := &Router{Mux: mux.NewRouter()}
+	router.Bind()
+
+	for _, path := range []string{
+		"/avatar/d9135e082f2244c89cb10d21ed3ac8fd",
+		"/helm/d9135e08-2f22-44c8-9cb1-0d21ed3ac8fd/32.png",
+		"/skin/D9135E08-2F22-44C8-9CB1-0D21ED3AC8FD",
+	} {
+		w := httptest.NewRecorder()
+		router.Mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("ETag"), "\"clone1018") {
+			t.Fatalf("Expected %s to serve the cached skin, got %d", path, w.Code)
+		}
+	}
+}
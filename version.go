@@ -0,0 +1,30 @@
+package main
+
+import (
+	"runtime/debug"
+)
+
+// The commit and time imgd was built from. Go records both when building
+// from a git checkout, but they can be set with, eg.
+// go build -ldflags "-X main.gitCommit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+// when building from elsewhere.
+var (
+	gitCommit string
+	buildDate string
+)
+
+// Returns the commit and time imgd was built from, blank if we don't know.
+func buildInfo() (string, string) {
+	commit, date := gitCommit, buildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && commit == "":
+				commit = setting.Value
+			case setting.Key == "vcs.time" && date == "":
+				date = setting.Value
+			}
+		}
+	}
+	return commit, date
+}
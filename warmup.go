@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minotar/minecraft"
+)
+
+var warmupUsernameRegex = regexp.MustCompile("^" + minecraft.ValidUsernameRegex + "$")
+
+// Preloads the skins for a list of players, so a large server can have its
+// player list cached before traffic arrives. Players are fetched at a fixed
+// rate, so we don't get ourselves rate limited by Mojang.
+type Warmer struct {
+	// Players fetched per second.
+	Rate int
+
+	mu      sync.Mutex
+	running bool
+}
+
+func MakeWarmer(rate int) *Warmer {
+	if rate <= 0 {
+		rate = 10
+	}
+	return &Warmer{Rate: rate}
+}
+
+// Reads a list of usernames and UUIDs, one per line. Blank lines and lines
+// starting with # are skipped, as is anything which isn't a valid player.
+func readWarmupList(r io.Reader) ([]string, error) {
+	players := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		uuid := strings.ToLower(strings.Replace(line, "-", "", -1))
+		if isUUID(uuid) {
+			players = append(players, uuid)
+		} else if warmupUsernameRegex.MatchString(line) {
+			players = append(players, line)
+		} else {
+			log.Warningf("Skipping invalid warm-up entry: %s", line)
+		}
+	}
+	return players, scanner.Err()
+}
+
+// Reads the list of players from a file.
+func readWarmupFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return readWarmupList(file)
+}
+
+// Whether a warm-up is running.
+func (w *Warmer) busy() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.running
+}
+
+// Starts preloading the players in the background. Returns false if a
+// warm-up is already running.
+func (w *Warmer) start(players []string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.running {
+		return false
+	}
+	w.running = true
+	go w.run(players)
+	return true
+}
+
+func (w *Warmer) run(players []string) {
+	defer func() {
+		w.mu.Lock()
+		w.running = false
+		w.mu.Unlock()
+	}()
+
+	log.Noticef("Warming up the cache with %d players", len(players))
+	ticker := time.NewTicker(time.Second / time.Duration(w.Rate))
+	defer ticker.Stop()
+
+	var fetched, fallback uint
+	for _, player := range players {
+		// Players already cached don't cost us anything.
+		if uuid, known := lookupUUID(player); known && cache.has(uuid) {
+			continue
+		}
+		<-ticker.C
+		if fetchSkin(player).Fallback {
+			fallback++
+		}
+		fetched++
+	}
+
+	log.Noticef("Warmed up the cache (fetched: %d, failed: %d, already cached: %d)",
+		fetched, fallback, uint(len(players))-fetched)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadWarmupList(t *testing.T) {
+	list := `# Online players
+clone1018
+
+853C80EF-3C37-49FD-AA49-938B674ADAE6
+not a player!
+lukegb
+`
+	players, err := readWarmupList(strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"clone1018", "853c80ef3c3749fdaa49938b674adae6", "lukegb"}
+	if len(players) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, players)
+	}
+	for i := range expected {
+		if players[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, players)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minotar/imgd/pkg/mcclient"
+)
+
+const (
+	// Header webhooks carry the hex HMAC-SHA256 of their body in, keyed
+	// with the watch secret, as "sha256=<hmac>".
+	WebhookSignatureHeader = "X-Imgd-Signature"
+	// How long a webhook's receiver has to answer.
+	webhookTimeout = 10 * time.Second
+)
+
+// A callback URL to tell when a player's skin changes.
+type watch struct {
+	Player string
+	UUID   string
+	URL    string
+}
+
+// What's POSTed to a watch's URL when the player's skin changes. Hashes are
+// blank for the default skin.
+type skinChange struct {
+	Player   string
+	UUID     string
+	Previous string
+	Hash     string
+	SkinURL  string
+	Time     time.Time
+}
+
+// What the watch file holds, so the watches and what we last saw survive a
+// restart.
+type watchFile struct {
+	Watches []watch
+	Hashes  map[string]string
+}
+
+// Polls the profiles of watched players, and when one's texture changes,
+// purges them from the cache and tells each URL watching them. Sites can
+// then bust their own CDN's copies of the player's avatars.
+type Watcher struct {
+	Interval time.Duration
+	// File the watches are kept in, blank to keep them in memory.
+	Path string
+	// Key the webhooks are signed with, blank to not sign them.
+	Secret string
+
+	client *http.Client
+	mu     sync.Mutex
+	// Guarded by mu.
+	watches []watch
+	// Texture hash each watched UUID had when we last looked. Guarded by mu.
+	hashes map[string]string
+	stop   chan struct{}
+}
+
+func MakeWatcher(interval time.Duration, path string, secret string) *Watcher {
+	return &Watcher{
+		Interval: interval,
+		Path:     path,
+		Secret:   secret,
+		client:   &http.Client{Timeout: webhookTimeout},
+		hashes:   map[string]string{},
+		stop:     make(chan struct{}),
+	}
+}
+
+func (w *Watcher) run() {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.poll()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// Watches the player for the URL.
+func (w *Watcher) add(ctx context.Context, player string, url string) (watch, error) {
+	if err := checkURL(url); err != nil || url == "" {
+		return watch{}, fmt.Errorf("invalid callback URL %q", url)
+	}
+	uuid, reason := resolveUUID(ctx, player)
+	if reason != NegativeNone {
+		return watch{}, fmt.Errorf("unable to look up %s (%s)", player, reason)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	added := watch{Player: strings.ToLower(player), UUID: uuid, URL: url}
+	for _, existing := range w.watches {
+		if existing.UUID == uuid && existing.URL == url {
+			return existing, nil
+		}
+	}
+	w.watches = append(w.watches, added)
+	return added, w.save()
+}
+
+// Stops watching the player, by username or UUID, for the URL. Returns
+// whether they were being watched.
+func (w *Watcher) remove(player string, url string) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	kept := w.watches[:0]
+	removed := false
+	for _, existing := range w.watches {
+		if existing.URL == url && (strings.EqualFold(existing.Player, player) || existing.UUID == player) {
+			removed = true
+			continue
+		}
+		kept = append(kept, existing)
+	}
+	w.watches = kept
+	if !removed {
+		return false, nil
+	}
+	for uuid := range w.hashes {
+		if len(w.watching(uuid)) == 0 {
+			delete(w.hashes, uuid)
+		}
+	}
+	return true, w.save()
+}
+
+// Returns the watches.
+func (w *Watcher) list() []watch {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]watch{}, w.watches...)
+}
+
+// Returns the watches on the UUID. Must be called with the lock held.
+func (w *Watcher) watching(uuid string) []watch {
+	watches := []watch{}
+	for _, existing := range w.watches {
+		if existing.UUID == uuid {
+			watches = append(watches, existing)
+		}
+	}
+	return watches
+}
+
+// Looks at each watched player's profile, skipping the profile cache so
+// changes are seen as soon as Mojang has them.
+func (w *Watcher) poll() {
+	w.mu.Lock()
+	players := map[string]string{}
+	for _, existing := range w.watches {
+		players[existing.UUID] = existing.Player
+	}
+	w.mu.Unlock()
+
+	for uuid, player := range players {
+		result, err := upstream.do(func() (interface{}, error) {
+			return fetchProfile(context.Background(), uuid)
+		})
+		if err != nil {
+			log.Infof("Failed to poll watched player: %s (%s)", player, err.Error())
+			stats.Errored(ErrWatch)
+			continue
+		}
+		w.check(player, uuid, result.(Profile))
+	}
+}
+
+// Compares the profile's texture with what the player had last time, and
+// acts on any change.
+func (w *Watcher) check(player string, uuid string, profile Profile) {
+	hash := ""
+	if profile.SkinURL != "" {
+		hash = strings.ToLower(mcclient.TextureHash(profile.SkinURL))
+	}
+
+	w.mu.Lock()
+	previous, seen := w.hashes[uuid]
+	w.hashes[uuid] = hash
+	watches := w.watching(uuid)
+	if !seen || previous != hash {
+		if err := w.save(); err != nil {
+			log.Errorf("Unable to save watches (%v)", err)
+			stats.Errored(ErrWatch)
+		}
+	}
+	w.mu.Unlock()
+
+	// The first look only tells us what they have now.
+	if !seen || previous == hash {
+		return
+	}
+
+	log.Infof("Skin changed: %s (%s -> %s)", player, previous, hash)
+	skinChangeCounter.Inc()
+	purgePlayer(player, uuid)
+	if purger != nil {
+		purger.purge(player, uuid)
+	}
+
+	change := skinChange{Player: player, UUID: uuid, Previous: previous, Hash: hash, SkinURL: profile.SkinURL, Time: time.Now()}
+	for _, existing := range watches {
+		go w.notify(existing.URL, change)
+	}
+}
+
+// POSTs the change to the URL.
+func (w *Watcher) notify(url string, change skinChange) {
+	body, _ := json.Marshal(change)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "imgd/"+ImgdVersion)
+	if w.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, "sha256="+signWebhook(w.Secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			err = fmt.Errorf("%s", resp.Status)
+		}
+	}
+	if err != nil {
+		log.Warningf("Unable to send webhook to %s (%v)", url, err)
+		stats.Errored(ErrWebhook)
+		webhookCounter.WithLabelValues("failed").Inc()
+		return
+	}
+	webhookCounter.WithLabelValues("delivered").Inc()
+}
+
+// Returns the hex HMAC-SHA256 of the body, keyed with the secret.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Writes the watches out to the watch file, if there is one. Must be called
+// with the lock held.
+func (w *Watcher) save() error {
+	if w.Path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(watchFile{Watches: w.watches, Hashes: w.hashes}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(w.Path, data)
+}
+
+// Reads the watches back from the watch file, if there is one.
+func (w *Watcher) load() error {
+	if w.Path == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(w.Path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	file := watchFile{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.watches = file.Watches
+	if file.Hashes != nil {
+		w.hashes = file.Hashes
+	}
+	return nil
+}
+
+func (w *Watcher) Stop() {
+	close(w.stop)
+}
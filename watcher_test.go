@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/minotar/minecraft"
+)
+
+func TestWatcherNotifiesOnChange(t *testing.T) {
+	stats = MakeStatsCollector()
+	cache = &CacheMemory{}
+	cache.setup()
+	uuidCache = MakeUUIDCache()
+	profileCache = MakeProfileCache()
+
+	changes := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- body
+		changes <- r
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "watches.json")
+	w := MakeWatcher(time.Minute, path, "s3cret")
+	w.watches = []watch{{Player: "clone1018", UUID: "d9135e082f2244c89cb10d21ed3ac8fd", URL: server.URL}}
+
+	skin, _ := minecraft.FetchSkinForSteve()
+	cache.add("d9135e082f2244c89cb10d21ed3ac8fd", skin, time.Minute)
+
+	w.check("clone1018", "d9135e082f2244c89cb10d21ed3ac8fd", Profile{SkinURL: "http://textures.minecraft.net/texture/AAA"})
+	if !cache.has("d9135e082f2244c89cb10d21ed3ac8fd") {
+		t.Fatal("Expected the first look not to purge the player")
+	}
+
+	w.check("clone1018", "d9135e082f2244c89cb10d21ed3ac8fd", Profile{SkinURL: "http://textures.minecraft.net/texture/bbb"})
+	if cache.has("d9135e082f2244c89cb10d21ed3ac8fd") {
+		t.Fatal("Expected a changed skin to purge the player")
+	}
+
+	select {
+	case r := <-changes:
+		body := <-bodies
+		if r.Header.Get(WebhookSignatureHeader) != "sha256="+signWebhook("s3cret", body) {
+			t.Fatalf("Expected the webhook to be signed, got %q", r.Header.Get(WebhookSignatureHeader))
+		}
+		change := skinChange{}
+		json.Unmarshal(body, &change)
+		if change.Previous != "aaa" || change.Hash != "bbb" || change.Player != "clone1018" {
+			t.Fatalf("Unexpected change %+v", change)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a webhook for the change")
+	}
+
+	restored := MakeWatcher(time.Minute, path, "")
+	if err := restored.load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(restored.list()) != 1 || restored.hashes["d9135e082f2244c89cb10d21ed3ac8fd"] != "bbb" {
+		t.Fatal("Expected the watches and last hash to be restored")
+	}
+
+	if removed, _ := restored.remove("CLONE1018", server.URL); !removed || len(restored.list()) != 0 {
+		t.Fatal("Expected the watch to be removed")
+	}
+}